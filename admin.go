@@ -0,0 +1,674 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/client"
+	"github.com/bradmwilliams/mongodb-client/pkg/kube"
+	"github.com/bradmwilliams/mongodb-client/pkg/provision"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func newAdminCommand(o *options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "admin",
+		Short: "Administer the deployment with the admin client",
+	}
+	cmd.AddCommand(newAdminUserCommand(o))
+	cmd.AddCommand(newAdminRoleCommand(o))
+	cmd.AddCommand(newAdminReplSetCommand(o))
+	cmd.AddCommand(newAdminShardCommand(o))
+	cmd.AddCommand(newAdminOpsCommand(o))
+	return cmd
+}
+
+// parseGrant parses a role granted as role or role@db, in database by default.
+func parseGrant(value, database string) (provision.RoleGrant, error) {
+	parts := strings.SplitN(value, "@", 2)
+	if len(parts[0]) == 0 {
+		return provision.RoleGrant{}, fmt.Errorf("invalid role %q, expected role or role@db", value)
+	}
+	grant := provision.RoleGrant{Role: parts[0], Database: database}
+	if len(parts) == 2 {
+		if len(parts[1]) == 0 {
+			return provision.RoleGrant{}, fmt.Errorf("invalid role %q, expected role or role@db", value)
+		}
+		grant.Database = parts[1]
+	}
+	return grant, nil
+}
+
+func parseGrants(values []string, database string) ([]provision.RoleGrant, error) {
+	grants := make([]provision.RoleGrant, 0, len(values))
+	for _, value := range values {
+		grant, err := parseGrant(value, database)
+		if err != nil {
+			return nil, err
+		}
+		grants = append(grants, grant)
+	}
+	return grants, nil
+}
+
+// parsePrivilege parses a privilege written <resource>:<action>[,<action>...] where the resource
+// is db.collection, db for every collection of a database, * for every database or cluster.
+func parsePrivilege(value string) (provision.Privilege, error) {
+	i := strings.LastIndex(value, ":")
+	if i <= 0 || i == len(value)-1 {
+		return provision.Privilege{}, fmt.Errorf("invalid privilege %q, expected <resource>:<action>[,<action>...]", value)
+	}
+	privilege := provision.Privilege{Actions: strings.Split(value[i+1:], ",")}
+	switch resource := value[:i]; resource {
+	case "cluster":
+		privilege.Resource.Cluster = true
+	case "*":
+	default:
+		parts := strings.SplitN(resource, ".", 2)
+		privilege.Resource.Database = parts[0]
+		if len(parts) == 2 {
+			privilege.Resource.Collection = parts[1]
+		}
+	}
+	return privilege, nil
+}
+
+// passwordOptions selects the password of a user and where a generated one is written.
+type passwordOptions struct {
+	Env       string
+	Generate  bool
+	Length    int
+	Secret    string
+	SecretKey string
+	URIKey    string
+
+	kube *kube.Client
+}
+
+func (p *passwordOptions) addFlags(flagset *pflag.FlagSet, generate, generateUsage string) {
+	p.Length, p.SecretKey, p.URIKey = 32, "password", "uri"
+	flagset.StringVar(&p.Env, "password-env", p.Env, "Environment variable holding the password")
+	flagset.BoolVar(&p.Generate, generate, p.Generate, generateUsage)
+	flagset.IntVar(&p.Length, "password-length", p.Length, "Number of characters of generated passwords")
+	flagset.StringVar(&p.Secret, "secret", p.Secret, "Write the user, the generated password and the connection string of the user to this Kubernetes Secret, as [namespace/]name, instead of printing the password")
+	flagset.StringVar(&p.SecretKey, "secret-key", p.SecretKey, "Key of the password in --secret, the user is written under username")
+	flagset.StringVar(&p.URIKey, "secret-uri-key", p.URIKey, "Key of the connection string of the user in --secret, empty to leave it out")
+}
+
+func (p *passwordOptions) validate() error {
+	if len(p.Env) > 0 && p.Generate {
+		return fmt.Errorf("--password-env cannot be combined with generated passwords")
+	}
+	if p.Generate && p.Length < 16 {
+		return fmt.Errorf("--password-length must be at least 16")
+	}
+	if len(p.Secret) > 0 {
+		if !p.Generate {
+			return fmt.Errorf("--secret only applies to generated passwords")
+		}
+		if p.SecretKey == "username" || p.URIKey == "username" || p.URIKey == p.SecretKey {
+			return fmt.Errorf("--secret-key and --secret-uri-key must be different keys other than username")
+		}
+		// The client is created before the password is changed, which cannot be undone.
+		var err error
+		if p.kube, err = kube.NewInClusterClient(); err != nil {
+			return fmt.Errorf("--secret: %w", err)
+		}
+	}
+	return nil
+}
+
+// password returns the password to set, empty when none is.
+func (p *passwordOptions) password() (string, error) {
+	if len(p.Env) > 0 {
+		password := os.Getenv(p.Env)
+		if len(password) == 0 {
+			return "", fmt.Errorf("--password-env %s is empty", p.Env)
+		}
+		return password, nil
+	}
+	if p.Generate {
+		return generatePassword(p.Length)
+	}
+	return "", nil
+}
+
+// publish hands a generated password over, to the Secret, with the connection string of user on
+// the deployment of manager, or on standard output.
+func (p *passwordOptions) publish(ctx context.Context, manager *client.ConnectionManager, user, database, password string) error {
+	if !p.Generate {
+		return nil
+	}
+	if len(p.Secret) == 0 {
+		fmt.Println(password)
+		return nil
+	}
+	namespace, name := p.kube.Namespace, p.Secret
+	if parts := strings.SplitN(p.Secret, "/", 2); len(parts) == 2 {
+		namespace, name = parts[0], parts[1]
+	}
+	data := map[string][]byte{"username": []byte(user), p.SecretKey: []byte(password)}
+	if len(p.URIKey) > 0 {
+		uri, err := manager.ConnectionString(user, password, database)
+		if err != nil {
+			return fmt.Errorf("the password of %s was set but its connection string could not be built, generate another one: %w", user, err)
+		}
+		data[p.URIKey] = []byte(uri)
+	}
+	if err := p.kube.ApplySecret(ctx, namespace, name, data, map[string]string{"app.kubernetes.io/managed-by": "mongodb-client"}); err != nil {
+		return fmt.Errorf("the password of %s was set but could not be written, generate another one: %w", user, err)
+	}
+	fmt.Fprintf(os.Stderr, "Wrote the password of %s to secret %s/%s\n", user, namespace, name)
+	return nil
+}
+
+const passwordAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+
+// generatePassword returns a random password of length characters that needs no escaping in
+// connection strings or shells.
+func generatePassword(length int) (string, error) {
+	password := make([]byte, length)
+	max := big.NewInt(int64(len(passwordAlphabet)))
+	for i := range password {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		password[i] = passwordAlphabet[n.Int64()]
+	}
+	return string(password), nil
+}
+
+func newAdminUserCommand(o *options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "user",
+		Short: "Create, update, delete and list database users",
+	}
+	cmd.AddCommand(newAdminUserListCommand(o))
+	cmd.AddCommand(newAdminUserCreateCommand(o))
+	cmd.AddCommand(newAdminUserUpdateCommand(o))
+	cmd.AddCommand(newAdminUserDeleteCommand(o))
+	return cmd
+}
+
+// adminCommand connects with the admin client and runs a command on database.
+func adminCommand(o *options, database string, command bson.D, result interface{}) error {
+	manager, err := o.connect()
+	if err != nil {
+		return err
+	}
+	defer disconnect(manager)
+	if len(database) == 0 {
+		database = manager.Database()
+	}
+	ctx, cancel := manager.ContextFor(client.AdminOperation)
+	defer cancel()
+	singleResult := manager.Admin().Database(database).RunCommand(ctx, command)
+	if result == nil {
+		return singleResult.Err()
+	}
+	return singleResult.Decode(result)
+}
+
+type adminUserListOptions struct {
+	Database     string
+	AllDatabases bool
+}
+
+func newAdminUserListCommand(o *options) *cobra.Command {
+	l := &adminUserListOptions{}
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the users of a database and their roles",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			return l.run(o)
+		},
+	}
+	flagset := cmd.Flags()
+	flagset.StringVar(&l.Database, "db", l.Database, "Database the users authenticate against, defaults to MONGODB_DATABASE")
+	flagset.BoolVar(&l.AllDatabases, "all-databases", l.AllDatabases, "List the users of every database")
+	return cmd
+}
+
+type grantInfo struct {
+	Role     string `bson:"role" json:"role"`
+	Database string `bson:"db" json:"db"`
+}
+
+func formatGrants(grants []grantInfo) string {
+	roles := make([]string, 0, len(grants))
+	for _, grant := range grants {
+		roles = append(roles, grant.Role+"@"+grant.Database)
+	}
+	return strings.Join(roles, ",")
+}
+
+func (l *adminUserListOptions) run(o *options) error {
+	database := l.Database
+	var command bson.D
+	if l.AllDatabases {
+		database = "admin"
+		command = bson.D{{Key: "usersInfo", Value: bson.D{{Key: "forAllDBs", Value: true}}}}
+	} else {
+		command = bson.D{{Key: "usersInfo", Value: 1}}
+	}
+	var result struct {
+		Users []struct {
+			User     string      `bson:"user" json:"user"`
+			Database string      `bson:"db" json:"db"`
+			Roles    []grantInfo `bson:"roles" json:"roles"`
+		} `bson:"users"`
+	}
+	out, err := o.printer()
+	if err != nil {
+		return err
+	}
+	if err := adminCommand(o, database, command, &result); err != nil {
+		return fmt.Errorf("unable to list users: %w", err)
+	}
+	return out.print(os.Stdout, result.Users, func(stdout io.Writer) error {
+		w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "USER\tDB\tROLES")
+		for _, user := range result.Users {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", user.User, user.Database, formatGrants(user.Roles))
+		}
+		return w.Flush()
+	})
+}
+
+type adminUserCreateOptions struct {
+	Name     string
+	Database string
+	Roles    []string
+	Password passwordOptions
+}
+
+func newAdminUserCreateCommand(o *options) *cobra.Command {
+	c := &adminUserCreateOptions{}
+	cmd := &cobra.Command{
+		Use:   "create",
+		Short: "Create a database user",
+		Long: `Create a user authenticating against a database with the password of --password-env, or with a
+generated password that is printed or written to a Kubernetes Secret with --secret. The Secret,
+created in the namespace of the pod unless it is given as namespace/name, holds the username, the
+password and a connection string of the user, from the in-cluster credentials of the pod.`,
+		Example: `  mongodb-client admin user create --name app --role readWrite --generate-password --secret app-mongodb
+  mongodb-client admin user create --name reporting --role read@sampledb --role read@analytics --password-env REPORTING_PASSWORD`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			return c.run(o)
+		},
+	}
+	flagset := cmd.Flags()
+	flagset.StringVar(&c.Name, "name", c.Name, "Name of the user")
+	flagset.StringVar(&c.Database, "db", c.Database, "Database the user authenticates against, defaults to MONGODB_DATABASE")
+	flagset.StringSliceVar(&c.Roles, "role", c.Roles, "Roles granted to the user as role or role@db, in --db by default")
+	c.Password.addFlags(flagset, "generate-password", "Generate a random password")
+	cmd.MarkFlagRequired("name")
+	return cmd
+}
+
+func (c *adminUserCreateOptions) run(o *options) error {
+	if err := c.Password.validate(); err != nil {
+		return err
+	}
+	if len(c.Password.Env) == 0 && !c.Password.Generate {
+		return fmt.Errorf("--password-env or --generate-password is required")
+	}
+	password, err := c.Password.password()
+	if err != nil {
+		return err
+	}
+	manager, err := o.connect()
+	if err != nil {
+		return err
+	}
+	defer disconnect(manager)
+	database := c.Database
+	if len(database) == 0 {
+		database = manager.Database()
+	}
+	grants, err := parseGrants(c.Roles, database)
+	if err != nil {
+		return fmt.Errorf("--role: %w", err)
+	}
+
+	ctx, cancel := manager.ContextFor(client.AdminOperation)
+	defer cancel()
+	err = manager.Admin().Database(database).RunCommand(ctx, bson.D{
+		{Key: "createUser", Value: c.Name},
+		{Key: "pwd", Value: password},
+		{Key: "roles", Value: provision.GrantDocuments(database, grants)},
+	}).Err()
+	if err != nil {
+		return fmt.Errorf("unable to create user %s@%s: %w", c.Name, database, err)
+	}
+	fmt.Fprintf(os.Stderr, "Created user %s@%s\n", c.Name, database)
+	return c.Password.publish(ctx, manager, c.Name, database, password)
+}
+
+type adminUserUpdateOptions struct {
+	Name     string
+	Database string
+	Roles    []string
+	Grant    []string
+	Revoke   []string
+	Password passwordOptions
+}
+
+func newAdminUserUpdateCommand(o *options) *cobra.Command {
+	u := &adminUserUpdateOptions{}
+	cmd := &cobra.Command{
+		Use:   "update",
+		Short: "Change the roles or rotate the password of a database user",
+		Long: `Replace the roles of a user with --role, or grant and revoke roles, and change its password to
+the one of --password-env or to a generated one with --rotate-password.`,
+		Example: `  mongodb-client admin user update --name app --rotate-password --secret app-mongodb
+  mongodb-client admin user update --name reporting --grant read@archive --revoke read@analytics`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			return u.run(o, cmd.Flags().Changed("role"))
+		},
+	}
+	flagset := cmd.Flags()
+	flagset.StringVar(&u.Name, "name", u.Name, "Name of the user")
+	flagset.StringVar(&u.Database, "db", u.Database, "Database the user authenticates against, defaults to MONGODB_DATABASE")
+	flagset.StringSliceVar(&u.Roles, "role", u.Roles, "Replace the roles of the user, as role or role@db, in --db by default")
+	flagset.StringSliceVar(&u.Grant, "grant", u.Grant, "Grant roles to the user, as role or role@db")
+	flagset.StringSliceVar(&u.Revoke, "revoke", u.Revoke, "Revoke roles from the user, as role or role@db")
+	u.Password.addFlags(flagset, "rotate-password", "Change the password to a generated one")
+	cmd.MarkFlagRequired("name")
+	return cmd
+}
+
+func (u *adminUserUpdateOptions) run(o *options, replaceRoles bool) error {
+	if err := u.Password.validate(); err != nil {
+		return err
+	}
+	if replaceRoles && (len(u.Grant) > 0 || len(u.Revoke) > 0) {
+		return fmt.Errorf("--role cannot be combined with --grant or --revoke")
+	}
+	password, err := u.Password.password()
+	if err != nil {
+		return err
+	}
+	if !replaceRoles && len(u.Grant) == 0 && len(u.Revoke) == 0 && len(password) == 0 {
+		return fmt.Errorf("nothing to update, set --role, --grant, --revoke, --password-env or --rotate-password")
+	}
+	manager, err := o.connect()
+	if err != nil {
+		return err
+	}
+	defer disconnect(manager)
+	database := u.Database
+	if len(database) == 0 {
+		database = manager.Database()
+	}
+	db := manager.Admin().Database(database)
+	ctx, cancel := manager.ContextFor(client.AdminOperation)
+	defer cancel()
+
+	update := bson.D{{Key: "updateUser", Value: u.Name}}
+	if len(password) > 0 {
+		update = append(update, bson.E{Key: "pwd", Value: password})
+	}
+	if replaceRoles {
+		grants, err := parseGrants(u.Roles, database)
+		if err != nil {
+			return fmt.Errorf("--role: %w", err)
+		}
+		update = append(update, bson.E{Key: "roles", Value: provision.GrantDocuments(database, grants)})
+	}
+	if len(update) > 1 {
+		if err := db.RunCommand(ctx, update).Err(); err != nil {
+			return fmt.Errorf("unable to update user %s@%s: %w", u.Name, database, err)
+		}
+	}
+	for _, change := range []struct {
+		flag    string
+		command string
+		values  []string
+	}{
+		{"--grant", "grantRolesToUser", u.Grant},
+		{"--revoke", "revokeRolesFromUser", u.Revoke},
+	} {
+		if len(change.values) == 0 {
+			continue
+		}
+		grants, err := parseGrants(change.values, database)
+		if err != nil {
+			return fmt.Errorf("%s: %w", change.flag, err)
+		}
+		err = db.RunCommand(ctx, bson.D{{Key: change.command, Value: u.Name}, {Key: "roles", Value: provision.GrantDocuments(database, grants)}}).Err()
+		if err != nil {
+			return fmt.Errorf("unable to update the roles of user %s@%s: %w", u.Name, database, err)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Updated user %s@%s\n", u.Name, database)
+	return u.Password.publish(ctx, manager, u.Name, database, password)
+}
+
+type adminDropOptions struct {
+	Name     string
+	Database string
+}
+
+func newAdminUserDeleteCommand(o *options) *cobra.Command {
+	d := &adminDropOptions{}
+	cmd := &cobra.Command{
+		Use:   "delete",
+		Short: "Delete a database user",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			if err := adminCommand(o, d.Database, bson.D{{Key: "dropUser", Value: d.Name}}, nil); err != nil {
+				return fmt.Errorf("unable to delete user %s: %w", d.Name, err)
+			}
+			fmt.Fprintf(os.Stderr, "Deleted user %s\n", d.Name)
+			return nil
+		},
+	}
+	flagset := cmd.Flags()
+	flagset.StringVar(&d.Name, "name", d.Name, "Name of the user")
+	flagset.StringVar(&d.Database, "db", d.Database, "Database the user authenticates against, defaults to MONGODB_DATABASE")
+	cmd.MarkFlagRequired("name")
+	return cmd
+}
+
+func newAdminRoleCommand(o *options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "role",
+		Short: "Create, update, delete and list user-defined roles",
+		Long: `Manage user-defined roles. Privileges are written <resource>:<action>[,<action>...] where the
+resource is db.collection, db for every collection of a database, * for every database, or cluster
+for cluster wide actions, such as sampledb.episodes:find,insert.`,
+	}
+	cmd.AddCommand(newAdminRoleListCommand(o))
+	cmd.AddCommand(newAdminRoleCreateCommand(o, false))
+	cmd.AddCommand(newAdminRoleCreateCommand(o, true))
+	cmd.AddCommand(newAdminRoleDeleteCommand(o))
+	return cmd
+}
+
+type adminRoleListOptions struct {
+	Database string
+	BuiltIn  bool
+}
+
+func newAdminRoleListCommand(o *options) *cobra.Command {
+	l := &adminRoleListOptions{}
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the roles of a database with their privileges",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			return l.run(o)
+		},
+	}
+	flagset := cmd.Flags()
+	flagset.StringVar(&l.Database, "db", l.Database, "Database of the roles, defaults to MONGODB_DATABASE")
+	flagset.BoolVar(&l.BuiltIn, "builtin", l.BuiltIn, "List the built-in roles too")
+	return cmd
+}
+
+func (l *adminRoleListOptions) run(o *options) error {
+	var result struct {
+		Roles []struct {
+			Role       string `bson:"role" json:"role"`
+			Database   string `bson:"db" json:"db"`
+			IsBuiltin  bool   `bson:"isBuiltin" json:"isBuiltin"`
+			Privileges []struct {
+				Resource bson.M   `bson:"resource" json:"resource"`
+				Actions  []string `bson:"actions" json:"actions"`
+			} `bson:"privileges" json:"privileges"`
+			Roles []grantInfo `bson:"roles" json:"roles"`
+		} `bson:"roles"`
+	}
+	command := bson.D{
+		{Key: "rolesInfo", Value: 1},
+		{Key: "showPrivileges", Value: true},
+		{Key: "showBuiltinRoles", Value: l.BuiltIn},
+	}
+	out, err := o.printer()
+	if err != nil {
+		return err
+	}
+	if err := adminCommand(o, l.Database, command, &result); err != nil {
+		return fmt.Errorf("unable to list roles: %w", err)
+	}
+	return out.print(os.Stdout, result.Roles, func(stdout io.Writer) error {
+		w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ROLE\tDB\tBUILTIN\tPRIVILEGES\tINHERITED")
+		for _, role := range result.Roles {
+			privileges := make([]string, 0, len(role.Privileges))
+			for _, privilege := range role.Privileges {
+				privileges = append(privileges, formatResource(privilege.Resource)+":"+strings.Join(privilege.Actions, ","))
+			}
+			fmt.Fprintf(w, "%s\t%s\t%t\t%s\t%s\n", role.Role, role.Database, role.IsBuiltin, strings.Join(privileges, " "), formatGrants(role.Roles))
+		}
+		return w.Flush()
+	})
+}
+
+// formatResource writes a resource in the notation of privilege flags.
+func formatResource(resource bson.M) string {
+	if cluster, _ := resource["cluster"].(bool); cluster {
+		return "cluster"
+	}
+	db, _ := resource["db"].(string)
+	collection, _ := resource["collection"].(string)
+	switch {
+	case len(db) == 0 && len(collection) == 0:
+		return "*"
+	case len(collection) == 0:
+		return db
+	}
+	return db + "." + collection
+}
+
+type adminRoleOptions struct {
+	Name       string
+	Database   string
+	Privileges []string
+	Roles      []string
+}
+
+// newAdminRoleCreateCommand returns the create command, or the update command replacing the
+// privileges and inherited roles that are set.
+func newAdminRoleCreateCommand(o *options, update bool) *cobra.Command {
+	r := &adminRoleOptions{}
+	cmd := &cobra.Command{
+		Use:     "create",
+		Short:   "Create a user-defined role",
+		Example: `  mongodb-client admin role create --name episodesWriter --privilege sampledb.episodes:find,insert,update --inherit read@sampledb`,
+		Args:    cobra.NoArgs,
+	}
+	if update {
+		cmd.Use, cmd.Short = "update", "Replace the privileges or the inherited roles of a user-defined role"
+		cmd.Example = `  mongodb-client admin role update --name episodesWriter --privilege sampledb.episodes:find,insert,update,remove`
+	}
+	cmd.RunE = func(cmd *cobra.Command, arguments []string) error {
+		return r.run(o, update, cmd.Flags().Changed("privilege"), cmd.Flags().Changed("inherit"))
+	}
+	flagset := cmd.Flags()
+	flagset.StringVar(&r.Name, "name", r.Name, "Name of the role")
+	flagset.StringVar(&r.Database, "db", r.Database, "Database of the role, defaults to MONGODB_DATABASE")
+	flagset.StringArrayVar(&r.Privileges, "privilege", r.Privileges, "Privilege of the role as <resource>:<action>[,<action>...]")
+	flagset.StringSliceVar(&r.Roles, "inherit", r.Roles, "Roles inherited by the role, as role or role@db, in --db by default")
+	cmd.MarkFlagRequired("name")
+	return cmd
+}
+
+func (r *adminRoleOptions) run(o *options, update, setPrivileges, setRoles bool) error {
+	if update && !setPrivileges && !setRoles {
+		return fmt.Errorf("nothing to update, set --privilege or --inherit")
+	}
+	privileges := make([]provision.Privilege, 0, len(r.Privileges))
+	for _, value := range r.Privileges {
+		privilege, err := parsePrivilege(value)
+		if err != nil {
+			return fmt.Errorf("--privilege: %w", err)
+		}
+		privileges = append(privileges, privilege)
+	}
+	manager, err := o.connect()
+	if err != nil {
+		return err
+	}
+	defer disconnect(manager)
+	database := r.Database
+	if len(database) == 0 {
+		database = manager.Database()
+	}
+	grants, err := parseGrants(r.Roles, database)
+	if err != nil {
+		return fmt.Errorf("--inherit: %w", err)
+	}
+
+	verb, command := "create", bson.D{{Key: "createRole", Value: r.Name}}
+	if update {
+		verb, command = "update", bson.D{{Key: "updateRole", Value: r.Name}}
+	}
+	if !update || setPrivileges {
+		command = append(command, bson.E{Key: "privileges", Value: provision.PrivilegeDocuments(privileges)})
+	}
+	if !update || setRoles {
+		command = append(command, bson.E{Key: "roles", Value: provision.GrantDocuments(database, grants)})
+	}
+	ctx, cancel := manager.ContextFor(client.AdminOperation)
+	defer cancel()
+	if err := manager.Admin().Database(database).RunCommand(ctx, command).Err(); err != nil {
+		return fmt.Errorf("unable to %s role %s@%s: %w", verb, r.Name, database, err)
+	}
+	fmt.Fprintf(os.Stderr, "Role %s@%s %sd\n", r.Name, database, verb)
+	return nil
+}
+
+func newAdminRoleDeleteCommand(o *options) *cobra.Command {
+	d := &adminDropOptions{}
+	cmd := &cobra.Command{
+		Use:   "delete",
+		Short: "Delete a user-defined role",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			if err := adminCommand(o, d.Database, bson.D{{Key: "dropRole", Value: d.Name}}, nil); err != nil {
+				return fmt.Errorf("unable to delete role %s: %w", d.Name, err)
+			}
+			fmt.Fprintf(os.Stderr, "Deleted role %s\n", d.Name)
+			return nil
+		},
+	}
+	flagset := cmd.Flags()
+	flagset.StringVar(&d.Name, "name", d.Name, "Name of the role")
+	flagset.StringVar(&d.Database, "db", d.Database, "Database of the role, defaults to MONGODB_DATABASE")
+	cmd.MarkFlagRequired("name")
+	return cmd
+}
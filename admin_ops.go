@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/client"
+	"github.com/spf13/cobra"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func newAdminOpsCommand(o *options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "ops",
+		Short: "List and kill in-progress operations",
+	}
+	cmd.AddCommand(newOpsListCommand(o))
+	cmd.AddCommand(newOpsKillCommand(o))
+	return cmd
+}
+
+type opsListOptions struct {
+	MinDuration time.Duration
+	Namespace   string
+	Op          string
+	Idle        bool
+}
+
+// operation is an in-progress operation reported by $currentOp.
+type operation struct {
+	// OpID is an integer on a mongod and a string prefixed with the shard on a mongos.
+	OpID        interface{} `bson:"opid" json:"opid"`
+	Op          string      `bson:"op" json:"op"`
+	Namespace   string      `bson:"ns" json:"ns"`
+	Active      bool        `bson:"active" json:"active"`
+	Microsecs   int64       `bson:"microsecs_running" json:"microsecsRunning"`
+	Client      string      `bson:"client" json:"client,omitempty"`
+	Description string      `bson:"desc" json:"desc,omitempty"`
+	Shard       string      `bson:"shard" json:"shard,omitempty"`
+	PlanSummary string      `bson:"planSummary" json:"planSummary,omitempty"`
+	Command     bson.Raw    `bson:"command" json:"-"`
+}
+
+func newOpsListCommand(o *options) *cobra.Command {
+	l := &opsListOptions{}
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List in-progress operations, longest running first",
+		Example: `  mongodb-client admin ops list --min-duration 30s
+  mongodb-client admin ops list --ns sampledb.episodes --op query`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			return l.run(o)
+		},
+	}
+	flagset := cmd.Flags()
+	flagset.DurationVar(&l.MinDuration, "min-duration", l.MinDuration, "Only list operations running for at least this long")
+	flagset.StringVar(&l.Namespace, "ns", l.Namespace, "Only list operations on this database or database.collection")
+	flagset.StringVar(&l.Op, "op", l.Op, "Only list operations of this type: command, query, getmore, insert, update, remove")
+	flagset.BoolVar(&l.Idle, "idle", l.Idle, "Also list idle connections and cursors")
+	addJSONFlag(flagset, o)
+	return cmd
+}
+
+func (l *opsListOptions) run(o *options) error {
+	switch l.Op {
+	case "", "command", "query", "getmore", "insert", "update", "remove", "killcursors", "none":
+	default:
+		return fmt.Errorf("--op must be one of command, query, getmore, insert, update, remove, killcursors or none")
+	}
+	out, err := o.printer()
+	if err != nil {
+		return err
+	}
+	manager, err := o.connect()
+	if err != nil {
+		return err
+	}
+	defer disconnect(manager)
+	ctx, cancel := manager.ContextFor(client.AdminOperation)
+	defer cancel()
+
+	match := bson.D{}
+	if !l.Idle {
+		match = append(match, bson.E{Key: "active", Value: true})
+	}
+	if l.MinDuration > 0 {
+		match = append(match, bson.E{Key: "microsecs_running", Value: bson.D{{Key: "$gte", Value: l.MinDuration.Microseconds()}}})
+	}
+	if len(l.Namespace) > 0 {
+		if strings.Contains(l.Namespace, ".") {
+			match = append(match, bson.E{Key: "ns", Value: l.Namespace})
+		} else {
+			match = append(match, bson.E{Key: "ns", Value: bson.D{{Key: "$regex", Value: "^" + regexp.QuoteMeta(l.Namespace) + `(\.|$)`}}})
+		}
+	}
+	if len(l.Op) > 0 {
+		match = append(match, bson.E{Key: "op", Value: l.Op})
+	}
+	cursor, err := manager.Admin().Database("admin").Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$currentOp", Value: bson.D{{Key: "allUsers", Value: true}, {Key: "idleConnections", Value: l.Idle}}}},
+		{{Key: "$match", Value: match}},
+		{{Key: "$sort", Value: bson.D{{Key: "microsecs_running", Value: -1}}}},
+	})
+	if err != nil {
+		return fmt.Errorf("unable to list the operations: %w", err)
+	}
+	var operations []operation
+	if err := cursor.All(ctx, &operations); err != nil {
+		return fmt.Errorf("unable to list the operations: %w", err)
+	}
+
+	return out.print(os.Stdout, operations, func(stdout io.Writer) error {
+		w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "OPID\tOP\tNAMESPACE\tRUNNING\tCLIENT\tPLAN\tCOMMAND")
+		for _, operation := range operations {
+			running := (time.Duration(operation.Microsecs) * time.Microsecond).Round(time.Millisecond)
+			fmt.Fprintf(w, "%v\t%s\t%s\t%s\t%s\t%s\t%s\n", operation.OpID, operation.Op, operation.Namespace, running,
+				operation.Client, operation.PlanSummary, summarizeCommand(operation.Command, 60))
+		}
+		return w.Flush()
+	})
+}
+
+// summarizeCommand returns the command as relaxed extended JSON truncated to width characters.
+func summarizeCommand(command bson.Raw, width int) string {
+	if len(command) == 0 {
+		return ""
+	}
+	data, err := bson.MarshalExtJSON(command, false, false)
+	if err != nil {
+		return ""
+	}
+	if summary := string(data); len(summary) > width {
+		return summary[:width-3] + "..."
+	}
+	return string(data)
+}
+
+func newOpsKillCommand(o *options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "kill OPID...",
+		Short: "Terminate in-progress operations",
+		Long: `Terminate operations by the OPID reported by admin ops list. Operations are interrupted at their
+next yield point, a jammed operation may take a moment to stop.`,
+		Example: `  mongodb-client admin ops kill 12345
+  mongodb-client admin ops kill shard-0:67890`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			manager, err := o.connect()
+			if err != nil {
+				return err
+			}
+			defer disconnect(manager)
+			ctx, cancel := manager.ContextFor(client.AdminOperation)
+			defer cancel()
+			admin := manager.Admin().Database("admin")
+			for _, argument := range arguments {
+				// A mongod identifies operations with integers, a mongos with shard:opid strings.
+				var opid interface{} = argument
+				if id, err := strconv.ParseInt(argument, 10, 32); err == nil {
+					opid = int32(id)
+				}
+				if err := admin.RunCommand(ctx, bson.D{{Key: "killOp", Value: 1}, {Key: "op", Value: opid}}).Err(); err != nil {
+					return fmt.Errorf("unable to kill operation %s: %w", argument, err)
+				}
+				fmt.Fprintf(os.Stderr, "Killed operation %s\n", argument)
+			}
+			return nil
+		},
+	}
+	return cmd
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/client"
+	"github.com/spf13/cobra"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+func newAdminReplSetCommand(o *options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "replset",
+		Short: "Initiate, inspect and reconfigure a self-hosted replica set",
+	}
+	cmd.AddCommand(newReplSetInitCommand(o))
+	cmd.AddCommand(newReplSetStatusCommand(o))
+	cmd.AddCommand(newReplSetMemberCommand(o, true))
+	cmd.AddCommand(newReplSetMemberCommand(o, false))
+	cmd.AddCommand(newReplSetStepDownCommand(o))
+	return cmd
+}
+
+type replSetMember struct {
+	ID          int32   `bson:"_id"`
+	Host        string  `bson:"host"`
+	ArbiterOnly bool    `bson:"arbiterOnly,omitempty"`
+	Hidden      bool    `bson:"hidden,omitempty"`
+	Priority    float64 `bson:"priority"`
+	Votes       int32   `bson:"votes"`
+}
+
+type replSetInitOptions struct {
+	Host      string
+	Anonymous bool
+	Name      string
+	Members   []string
+	Arbiters  []string
+	Timeout   time.Duration
+}
+
+func newReplSetInitCommand(o *options) *cobra.Command {
+	i := &replSetInitOptions{Timeout: 2 * time.Minute}
+	cmd := &cobra.Command{
+		Use:   "init",
+		Short: "Initiate a replica set and wait for its primary",
+		Long: `Send replSetInitiate to a server started with --replSet, connecting to it directly, then wait
+until the replica set has elected a primary.
+
+The members default to the server connected to. Before the first user exists the server only
+accepts unauthenticated connections from localhost, use --anonymous then.`,
+		Example: `  mongodb-client admin replset init --anonymous --member mongodb-0.mongodb:27017 \
+    --member mongodb-1.mongodb:27017 --member mongodb-2.mongodb:27017`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			return i.run(o)
+		},
+	}
+	flagset := cmd.Flags()
+	flagset.StringVar(&i.Host, "host", i.Host, "Server to initiate the replica set from as host:port, defaults to MONGODB_HOST:MONGODB_PORT")
+	flagset.BoolVar(&i.Anonymous, "anonymous", i.Anonymous, "Connect without credentials, through the localhost exception")
+	flagset.StringVar(&i.Name, "name", i.Name, "Name of the replica set, defaults to the --replSet of the server")
+	flagset.StringSliceVar(&i.Members, "member", i.Members, "Members of the replica set as host:port, defaults to --host")
+	flagset.StringSliceVar(&i.Arbiters, "arbiter", i.Arbiters, "Arbiters of the replica set as host:port")
+	flagset.DurationVar(&i.Timeout, "timeout", i.Timeout, "How long to wait for a primary to be elected, 0 to not wait")
+	return cmd
+}
+
+func (i *replSetInitOptions) run(o *options) error {
+	if err := o.validate(); err != nil {
+		return err
+	}
+	manager, err := o.connectionManager()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := cmdContext()
+	defer cancel()
+	c, err := manager.ConnectDirect(ctx, i.Host, i.Anonymous)
+	if err != nil {
+		return err
+	}
+	defer c.Disconnect(context.Background())
+	admin := c.Database("admin")
+
+	name := i.Name
+	if len(name) == 0 {
+		var options struct {
+			Parsed struct {
+				Replication struct {
+					ReplSetName string `bson:"replSetName"`
+					ReplSet     string `bson:"replSet"`
+				} `bson:"replication"`
+			} `bson:"parsed"`
+		}
+		if err := admin.RunCommand(ctx, bson.D{{Key: "getCmdLineOpts", Value: 1}}).Decode(&options); err != nil {
+			return fmt.Errorf("unable to read the replica set name of the server, set --name: %w", err)
+		}
+		name = options.Parsed.Replication.ReplSetName
+		if len(name) == 0 {
+			name = options.Parsed.Replication.ReplSet
+		}
+		if len(name) == 0 {
+			return fmt.Errorf("the server was not started with --replSet")
+		}
+	}
+	hosts := i.Members
+	if len(hosts) == 0 {
+		hosts = []string{i.Host}
+		if len(i.Host) == 0 {
+			hosts = []string{manager.Address()}
+		}
+	}
+	var members []replSetMember
+	for _, host := range hosts {
+		members = append(members, replSetMember{ID: int32(len(members)), Host: host, Priority: 1, Votes: 1})
+	}
+	for _, host := range i.Arbiters {
+		members = append(members, replSetMember{ID: int32(len(members)), Host: host, ArbiterOnly: true, Votes: 1})
+	}
+	config := bson.D{{Key: "_id", Value: name}, {Key: "members", Value: members}}
+	if err := admin.RunCommand(ctx, bson.D{{Key: "replSetInitiate", Value: config}}).Err(); err != nil {
+		return fmt.Errorf("unable to initiate replica set %s: %w", name, err)
+	}
+	fmt.Fprintf(os.Stderr, "Initiated replica set %s with %d members\n", name, len(members))
+	if i.Timeout <= 0 {
+		return nil
+	}
+
+	waitCtx, cancelWait := context.WithTimeout(ctx, i.Timeout)
+	defer cancelWait()
+	err = wait.PollImmediateUntil(time.Second, func() (bool, error) {
+		status, err := replSetStatus(waitCtx, admin)
+		if err != nil {
+			return false, nil
+		}
+		for _, member := range status.Members {
+			if member.StateStr == "PRIMARY" {
+				fmt.Fprintf(os.Stderr, "%s is primary\n", member.Name)
+				return true, nil
+			}
+		}
+		return false, nil
+	}, waitCtx.Done())
+	if err != nil {
+		return fmt.Errorf("no primary was elected within %s", i.Timeout)
+	}
+	return nil
+}
+
+type replSetStatusResult struct {
+	Set     string `bson:"set" json:"set"`
+	Members []struct {
+		ID             int32     `bson:"_id" json:"id"`
+		Name           string    `bson:"name" json:"name"`
+		Health         float64   `bson:"health" json:"health"`
+		StateStr       string    `bson:"stateStr" json:"stateStr"`
+		Uptime         int64     `bson:"uptime" json:"uptime"`
+		OptimeDate     time.Time `bson:"optimeDate" json:"optimeDate"`
+		PingMs         int64     `bson:"pingMs" json:"pingMs"`
+		SyncSourceHost string    `bson:"syncSourceHost" json:"syncSourceHost,omitempty"`
+		Self           bool      `bson:"self" json:"self"`
+	} `bson:"members" json:"members"`
+}
+
+func replSetStatus(ctx context.Context, admin *mongo.Database) (*replSetStatusResult, error) {
+	status := &replSetStatusResult{}
+	if err := admin.RunCommand(ctx, bson.D{{Key: "replSetGetStatus", Value: 1}}).Decode(status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+func newReplSetStatusCommand(o *options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "status",
+		Short: "Show the state, health and replication lag of the members",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			out, err := o.printer()
+			if err != nil {
+				return err
+			}
+			manager, err := o.connect()
+			if err != nil {
+				return err
+			}
+			defer disconnect(manager)
+			ctx, cancel := manager.ContextFor(client.AdminOperation)
+			defer cancel()
+			status, err := replSetStatus(ctx, manager.Admin().Database("admin"))
+			if err != nil {
+				return fmt.Errorf("unable to read the replica set status: %w", err)
+			}
+
+			return out.print(os.Stdout, []*replSetStatusResult{status}, func(stdout io.Writer) error {
+				var primaryOptime time.Time
+				for _, member := range status.Members {
+					if member.StateStr == "PRIMARY" {
+						primaryOptime = member.OptimeDate
+					}
+				}
+				fmt.Fprintf(stdout, "Replica set %s\n", status.Set)
+				w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+				fmt.Fprintln(w, "ID\tMEMBER\tSTATE\tHEALTHY\tUPTIME\tLAG\tPING\tSYNC SOURCE")
+				for _, member := range status.Members {
+					lag := "-"
+					if !primaryOptime.IsZero() && member.StateStr == "SECONDARY" {
+						lag = primaryOptime.Sub(member.OptimeDate).String()
+					}
+					name := member.Name
+					if member.Self {
+						name += " (self)"
+					}
+					fmt.Fprintf(w, "%d\t%s\t%s\t%t\t%s\t%s\t%dms\t%s\n", member.ID, name, member.StateStr, member.Health == 1,
+						time.Duration(member.Uptime)*time.Second, lag, member.PingMs, member.SyncSourceHost)
+				}
+				return w.Flush()
+			})
+		},
+	}
+}
+
+type replSetMemberOptions struct {
+	Priority float64
+	Votes    int32
+	Arbiter  bool
+	Hidden   bool
+	Force    bool
+}
+
+// newReplSetMemberCommand returns the add-member command, or the remove-member command.
+func newReplSetMemberCommand(o *options, add bool) *cobra.Command {
+	m := &replSetMemberOptions{Priority: 1, Votes: 1}
+	cmd := &cobra.Command{
+		Use:   "remove-member HOST:PORT",
+		Short: "Remove a member from the replica set",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			return m.run(o, arguments[0], add)
+		},
+	}
+	if add {
+		cmd.Use, cmd.Short = "add-member HOST:PORT", "Add a member to the replica set"
+		cmd.Example = `  mongodb-client admin replset add-member mongodb-3.mongodb:27017
+  mongodb-client admin replset add-member backup.mongodb:27017 --priority 0 --hidden`
+	}
+	flagset := cmd.Flags()
+	if add {
+		flagset.Float64Var(&m.Priority, "priority", m.Priority, "Priority of the member in elections, 0 for a member that never becomes primary")
+		flagset.Int32Var(&m.Votes, "votes", m.Votes, "Votes of the member in elections, 0 or 1")
+		flagset.BoolVar(&m.Arbiter, "arbiter", m.Arbiter, "Add the member as an arbiter")
+		flagset.BoolVar(&m.Hidden, "hidden", m.Hidden, "Hide the member from clients, requires --priority 0")
+	}
+	flagset.BoolVar(&m.Force, "force", m.Force, "Reconfigure from a secondary when the replica set has no primary")
+	return cmd
+}
+
+func (m *replSetMemberOptions) run(o *options, host string, add bool) error {
+	if add && m.Hidden && m.Priority != 0 {
+		return fmt.Errorf("--hidden requires --priority 0")
+	}
+	if add && m.Arbiter {
+		m.Priority = 0
+	}
+	manager, err := o.connect()
+	if err != nil {
+		return err
+	}
+	defer disconnect(manager)
+	ctx, cancel := manager.ContextFor(client.AdminOperation)
+	defer cancel()
+	admin := manager.Admin().Database("admin")
+
+	var result struct {
+		Config bson.M `bson:"config"`
+	}
+	if err := admin.RunCommand(ctx, bson.D{{Key: "replSetGetConfig", Value: 1}}).Decode(&result); err != nil {
+		return fmt.Errorf("unable to read the replica set configuration: %w", err)
+	}
+	config := result.Config
+	members, _ := config["members"].(bson.A)
+	var updated bson.A
+	var nextID int32
+	found := false
+	for _, item := range members {
+		member, _ := item.(bson.M)
+		id, _ := member["_id"].(int32)
+		if id >= nextID {
+			nextID = id + 1
+		}
+		if strings.EqualFold(fmt.Sprint(member["host"]), host) {
+			found = true
+			if !add {
+				continue
+			}
+		}
+		updated = append(updated, member)
+	}
+	switch {
+	case add && found:
+		return fmt.Errorf("%s is already a member", host)
+	case !add && !found:
+		return fmt.Errorf("%s is not a member", host)
+	case add:
+		updated = append(updated, replSetMember{ID: nextID, Host: host, ArbiterOnly: m.Arbiter, Hidden: m.Hidden, Priority: m.Priority, Votes: m.Votes})
+	}
+	config["members"] = updated
+	version, _ := config["version"].(int32)
+	config["version"] = version + 1
+
+	err = admin.RunCommand(ctx, bson.D{{Key: "replSetReconfig", Value: config}, {Key: "force", Value: m.Force}}).Err()
+	if err != nil {
+		return fmt.Errorf("unable to reconfigure the replica set: %w", err)
+	}
+	if add {
+		fmt.Fprintf(os.Stderr, "Added %s to the replica set\n", host)
+	} else {
+		fmt.Fprintf(os.Stderr, "Removed %s from the replica set\n", host)
+	}
+	return nil
+}
+
+type replSetStepDownOptions struct {
+	Duration time.Duration
+	CatchUp  time.Duration
+	Force    bool
+}
+
+func newReplSetStepDownCommand(o *options) *cobra.Command {
+	s := &replSetStepDownOptions{Duration: time.Minute, CatchUp: 10 * time.Second}
+	cmd := &cobra.Command{
+		Use:   "stepdown",
+		Short: "Make the primary step down so that another member is elected",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			return s.run(o)
+		},
+	}
+	flagset := cmd.Flags()
+	flagset.DurationVar(&s.Duration, "duration", s.Duration, "How long the primary is not eligible to become primary again")
+	flagset.DurationVar(&s.CatchUp, "secondary-catch-up", s.CatchUp, "How long the primary waits for a secondary to catch up before stepping down")
+	flagset.BoolVar(&s.Force, "force", s.Force, "Step down even when no secondary caught up")
+	return cmd
+}
+
+func (s *replSetStepDownOptions) run(o *options) error {
+	if s.CatchUp >= s.Duration {
+		return fmt.Errorf("--secondary-catch-up must be shorter than --duration")
+	}
+	manager, err := o.connect()
+	if err != nil {
+		return err
+	}
+	defer disconnect(manager)
+	ctx, cancel := context.WithTimeout(context.Background(), s.CatchUp+manager.Timeout(client.AdminOperation))
+	defer cancel()
+	err = manager.Admin().Database("admin").RunCommand(ctx, bson.D{
+		{Key: "replSetStepDown", Value: int64(s.Duration / time.Second)},
+		{Key: "secondaryCatchUpPeriodSecs", Value: int64(s.CatchUp / time.Second)},
+		{Key: "force", Value: s.Force},
+	}).Err()
+	// Servers before 4.2 close every connection when the primary steps down.
+	if err != nil && !mongo.IsNetworkError(err) {
+		return fmt.Errorf("unable to step down the primary: %w", err)
+	}
+	fmt.Fprintln(os.Stderr, "The primary stepped down")
+	return nil
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/client"
+	"github.com/bradmwilliams/mongodb-client/pkg/provision"
+	"github.com/spf13/cobra"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func newAdminShardCommand(o *options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "shard",
+		Short: "Shard databases and collections, inspect chunks and control the balancer",
+		Long: `Administer a sharded cluster through a mongos: enable sharding, shard collections, list the
+distribution of their chunks across shards and start or stop the balancer.`,
+	}
+	cmd.AddCommand(newShardEnableCommand(o))
+	cmd.AddCommand(newShardCollectionCommand(o))
+	cmd.AddCommand(newShardChunksCommand(o))
+	cmd.AddCommand(newShardBalancerCommand(o))
+	return cmd
+}
+
+func newShardEnableCommand(o *options) *cobra.Command {
+	var database string
+	cmd := &cobra.Command{
+		Use:   "enable",
+		Short: "Enable sharding for a database",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			manager, err := o.connect()
+			if err != nil {
+				return err
+			}
+			defer disconnect(manager)
+			if len(database) == 0 {
+				database = manager.Database()
+			}
+			ctx, cancel := manager.ContextFor(client.AdminOperation)
+			defer cancel()
+			if err := manager.Admin().Database("admin").RunCommand(ctx, bson.D{{Key: "enableSharding", Value: database}}).Err(); err != nil {
+				return fmt.Errorf("unable to enable sharding for %s: %w", database, err)
+			}
+			fmt.Fprintf(os.Stderr, "Enabled sharding for %s\n", database)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&database, "db", database, "Database to shard, defaults to MONGODB_DATABASE")
+	return cmd
+}
+
+type shardCollectionOptions struct {
+	Database   string
+	Collection string
+	Keys       []string
+	Unique     bool
+	Chunks     int32
+}
+
+func newShardCollectionCommand(o *options) *cobra.Command {
+	s := &shardCollectionOptions{}
+	cmd := &cobra.Command{
+		Use:   "collection",
+		Short: "Shard a collection on a shard key",
+		Long: `Shard a collection on the keys of --key, in order. Keys prefixed with - are descending, a key
+suffixed with :hashed is hashed. An index supporting the shard key is created when the collection
+is empty.`,
+		Example: `  mongodb-client admin shard collection --collection episodes --key podcast:hashed
+  mongodb-client admin shard collection --collection events --key tenant --key published`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			return s.run(o)
+		},
+	}
+	flagset := cmd.Flags()
+	flagset.StringVar(&s.Database, "db", s.Database, "Database of the collection, defaults to MONGODB_DATABASE")
+	flagset.StringVar(&s.Collection, "collection", s.Collection, "Collection to shard")
+	flagset.StringSliceVar(&s.Keys, "key", s.Keys, "Fields of the shard key, such as tenant,published or podcast:hashed")
+	flagset.BoolVar(&s.Unique, "unique", s.Unique, "Enforce a unique constraint on the shard key")
+	flagset.Int32Var(&s.Chunks, "initial-chunks", s.Chunks, "Number of chunks created for an empty collection with a hashed shard key")
+	cmd.MarkFlagRequired("collection")
+	cmd.MarkFlagRequired("key")
+	return cmd
+}
+
+func (s *shardCollectionOptions) run(o *options) error {
+	index := provision.Index{Keys: s.Keys}
+	keys, err := index.KeySpec()
+	if err != nil {
+		return fmt.Errorf("--key: %w", err)
+	}
+	hashed := false
+	for _, key := range keys {
+		switch key.Value {
+		case "hashed":
+			hashed = true
+		case "text", "2dsphere":
+			return fmt.Errorf("--key %s cannot be a shard key", key.Key)
+		}
+	}
+	if s.Chunks > 0 && !hashed {
+		return fmt.Errorf("--initial-chunks requires a hashed shard key")
+	}
+	if s.Unique && hashed {
+		return fmt.Errorf("--unique cannot be combined with a hashed shard key")
+	}
+
+	manager, err := o.connect()
+	if err != nil {
+		return err
+	}
+	defer disconnect(manager)
+	database := s.Database
+	if len(database) == 0 {
+		database = manager.Database()
+	}
+	namespace := database + "." + s.Collection
+	command := bson.D{
+		{Key: "shardCollection", Value: namespace},
+		{Key: "key", Value: keys},
+		{Key: "unique", Value: s.Unique},
+	}
+	if s.Chunks > 0 {
+		command = append(command, bson.E{Key: "numInitialChunks", Value: s.Chunks})
+	}
+	ctx, cancel := cmdContext()
+	defer cancel()
+	if err := manager.Admin().Database("admin").RunCommand(ctx, command).Err(); err != nil {
+		return fmt.Errorf("unable to shard %s: %w", namespace, err)
+	}
+	fmt.Fprintf(os.Stderr, "Sharded %s on %s\n", namespace, strings.Join(s.Keys, ","))
+	return nil
+}
+
+type shardChunksOptions struct {
+	Database   string
+	Collection string
+}
+
+func newShardChunksCommand(o *options) *cobra.Command {
+	c := &shardChunksOptions{}
+	cmd := &cobra.Command{
+		Use:   "chunks",
+		Short: "Show how the chunks of sharded collections are distributed across shards",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			return c.run(o)
+		},
+	}
+	flagset := cmd.Flags()
+	flagset.StringVar(&c.Database, "db", c.Database, "Only show the collections of this database")
+	flagset.StringVar(&c.Collection, "collection", c.Collection, "Only show this collection, requires --db")
+	return cmd
+}
+
+func (c *shardChunksOptions) run(o *options) error {
+	if len(c.Collection) > 0 && len(c.Database) == 0 {
+		return fmt.Errorf("--collection requires --db")
+	}
+	out, err := o.printer()
+	if err != nil {
+		return err
+	}
+	manager, err := o.connect()
+	if err != nil {
+		return err
+	}
+	defer disconnect(manager)
+	ctx, cancel := manager.ContextFor(client.AdminOperation)
+	defer cancel()
+	configDB := manager.Admin().Database("config")
+
+	filter := bson.D{{Key: "dropped", Value: bson.D{{Key: "$ne", Value: true}}}}
+	switch {
+	case len(c.Collection) > 0:
+		filter = append(filter, bson.E{Key: "_id", Value: c.Database + "." + c.Collection})
+	case len(c.Database) > 0:
+		filter = append(filter, bson.E{Key: "_id", Value: bson.D{{Key: "$regex", Value: "^" + regexp.QuoteMeta(c.Database) + `\.`}}})
+	}
+	cursor, err := configDB.Collection("collections").Find(ctx, filter)
+	if err != nil {
+		return fmt.Errorf("unable to list the sharded collections: %w", err)
+	}
+	var collections []struct {
+		Namespace string        `bson:"_id"`
+		UUID      bson.RawValue `bson:"uuid"`
+		Key       bson.D        `bson:"key"`
+	}
+	if err := cursor.All(ctx, &collections); err != nil {
+		return fmt.Errorf("unable to list the sharded collections: %w", err)
+	}
+
+	var distributions []chunkDistribution
+	for _, collection := range collections {
+		// Chunks reference their collection by namespace before 5.0 and by UUID since.
+		match := bson.D{{Key: "ns", Value: collection.Namespace}}
+		if collection.UUID.Type == bson.TypeBinary {
+			match = bson.D{{Key: "$or", Value: bson.A{match, bson.D{{Key: "uuid", Value: collection.UUID}}}}}
+		}
+		counts, err := chunkCounts(ctx, configDB, match)
+		if err != nil {
+			return fmt.Errorf("unable to count the chunks of %s: %w", collection.Namespace, err)
+		}
+		var total int64
+		shards := make([]string, 0, len(counts))
+		for shard, count := range counts {
+			total += count
+			shards = append(shards, shard)
+		}
+		sort.Strings(shards)
+		info := provision.IndexInfo{Key: collection.Key}
+		for _, shard := range shards {
+			distributions = append(distributions, chunkDistribution{
+				Collection: collection.Namespace,
+				ShardKey:   strings.Join(info.Keys(), ","),
+				Shard:      shard,
+				Chunks:     counts[shard],
+				Share:      100 * float64(counts[shard]) / float64(total),
+			})
+		}
+	}
+	return out.print(os.Stdout, distributions, func(stdout io.Writer) error {
+		w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "COLLECTION\tSHARD KEY\tSHARD\tCHUNKS\tSHARE")
+		for _, distribution := range distributions {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%.1f%%\n", distribution.Collection, distribution.ShardKey, distribution.Shard, distribution.Chunks, distribution.Share)
+		}
+		return w.Flush()
+	})
+}
+
+// chunkDistribution is the number of chunks of a sharded collection on a shard.
+type chunkDistribution struct {
+	Collection string `json:"collection"`
+	ShardKey   string `json:"shardKey"`
+	Shard      string `json:"shard"`
+	Chunks     int64  `json:"chunks"`
+	// Share is the percentage of the chunks of the collection on the shard.
+	Share float64 `json:"share"`
+}
+
+// chunkCounts returns the number of chunks matching match, by shard.
+func chunkCounts(ctx context.Context, configDB *mongo.Database, match bson.D) (map[string]int64, error) {
+	cursor, err := configDB.Collection("chunks").Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$group", Value: bson.D{{Key: "_id", Value: "$shard"}, {Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var groups []struct {
+		Shard string `bson:"_id"`
+		Count int64  `bson:"count"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int64, len(groups))
+	for _, group := range groups {
+		counts[group.Shard] = group.Count
+	}
+	return counts, nil
+}
+
+func newShardBalancerCommand(o *options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "balancer",
+		Short: "Show, start or stop the balancer",
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "status",
+		Short: "Show whether the balancer is enabled and running",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			out, err := o.printer()
+			if err != nil {
+				return err
+			}
+			manager, err := o.connect()
+			if err != nil {
+				return err
+			}
+			defer disconnect(manager)
+			ctx, cancel := manager.ContextFor(client.AdminOperation)
+			defer cancel()
+			var status struct {
+				Mode       string `bson:"mode" json:"mode"`
+				InBalancer bool   `bson:"inBalancerRound" json:"inBalancerRound"`
+				Rounds     int64  `bson:"numBalancerRounds" json:"numBalancerRounds"`
+			}
+			if err := manager.Admin().Database("admin").RunCommand(ctx, bson.D{{Key: "balancerStatus", Value: 1}}).Decode(&status); err != nil {
+				return fmt.Errorf("unable to read the balancer status: %w", err)
+			}
+			return out.print(os.Stdout, []interface{}{status}, func(stdout io.Writer) error {
+				w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+				fmt.Fprintln(w, "MODE\tIN ROUND\tROUNDS")
+				fmt.Fprintf(w, "%s\t%t\t%d\n", status.Mode, status.InBalancer, status.Rounds)
+				return w.Flush()
+			})
+		},
+	})
+	cmd.AddCommand(newShardBalancerToggleCommand(o, "start", "balancerStart", "started", "Enable the balancer"))
+	cmd.AddCommand(newShardBalancerToggleCommand(o, "stop", "balancerStop", "stopped", "Disable the balancer, waiting for the round in progress to finish"))
+	return cmd
+}
+
+func newShardBalancerToggleCommand(o *options, use, command, done, short string) *cobra.Command {
+	return &cobra.Command{
+		Use:   use,
+		Short: short,
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			manager, err := o.connect()
+			if err != nil {
+				return err
+			}
+			defer disconnect(manager)
+			// balancerStop waits for the current balancing round, which can outlast the operation timeout.
+			ctx, cancel := cmdContext()
+			defer cancel()
+			if err := manager.Admin().Database("admin").RunCommand(ctx, bson.D{{Key: command, Value: 1}}).Err(); err != nil {
+				return fmt.Errorf("unable to %s the balancer: %w", use, err)
+			}
+			fmt.Fprintf(os.Stderr, "Balancer %s\n", done)
+			return nil
+		},
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/client"
+	"github.com/spf13/cobra"
+	"go.mongodb.org/mongo-driver/bson"
+	mongoOptions "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type aggregateOptions struct {
+	Database     string
+	Collection   string
+	Pipeline     string
+	PipelineFile string
+	AllowDiskUse bool
+	MaxTime      time.Duration
+	BatchSize    int32
+	Explain      string
+	Lint         lintOptions
+	Params       []string
+}
+
+func newAggregateCommand(o *options) *cobra.Command {
+	a := &aggregateOptions{}
+	cmd := &cobra.Command{
+		Use:   "aggregate",
+		Short: "Run an aggregation pipeline and stream the results as NDJSON",
+		Example: `  mongodb-client aggregate --collection episodes --pipeline '[{"$group":{"_id":"$podcast","total":{"$sum":"$duration"}}}]'
+  mongodb-client aggregate --collection episodes --pipeline-file report.json --allow-disk-use --max-time 5m
+  mongodb-client aggregate --collection episodes --pipeline-file report.json --explain executionStats
+  mongodb-client aggregate --collection episodes --pipeline-file report.json --lint-only
+  mongodb-client aggregate --collection episodes --pipeline-file report.json --param since=2024-01-01`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			return a.run(o)
+		},
+	}
+
+	flagset := cmd.Flags()
+	flagset.StringVar(&a.Database, "db", a.Database, "Database to run the pipeline against, defaults to MONGODB_DATABASE")
+	flagset.StringVar(&a.Collection, "collection", a.Collection, "Collection to aggregate")
+	flagset.StringVar(&a.Pipeline, "pipeline", a.Pipeline, "Pipeline as a JSON array of stages")
+	flagset.StringVar(&a.PipelineFile, "pipeline-file", a.PipelineFile, "File containing the pipeline as a JSON array of stages, - reads standard input")
+	flagset.BoolVar(&a.AllowDiskUse, "allow-disk-use", a.AllowDiskUse, "Allow stages to write temporary data to disk")
+	flagset.DurationVar(&a.MaxTime, "max-time", a.MaxTime, "Time limit for the pipeline and the streaming of its results, also sent as maxTimeMS (0 uses the timeout of the aggregations)")
+	flagset.Int32Var(&a.BatchSize, "batch-size", a.BatchSize, "Number of documents per cursor batch (0 uses the server default)")
+	flagset.StringVar(&a.Explain, "explain", a.Explain, "Print the plan of the pipeline instead of its results: queryPlanner, executionStats or allPlansExecution")
+	addLintFlags(flagset, &a.Lint)
+	addParamFlag(flagset, &a.Params)
+	cmd.MarkFlagRequired("collection")
+	return cmd
+}
+
+func (a *aggregateOptions) run(o *options) error {
+	if (len(a.Pipeline) > 0) == (len(a.PipelineFile) > 0) {
+		return fmt.Errorf("exactly one of --pipeline or --pipeline-file must be specified")
+	}
+	if err := validateExplain(a.Explain); err != nil {
+		return err
+	}
+	value := a.Pipeline
+	if len(a.PipelineFile) > 0 {
+		var data []byte
+		var err error
+		if a.PipelineFile == "-" {
+			data, err = ioutil.ReadAll(os.Stdin)
+		} else {
+			data, err = ioutil.ReadFile(a.PipelineFile)
+		}
+		if err != nil {
+			return fmt.Errorf("unable to read pipeline: %w", err)
+		}
+		value = string(data)
+	}
+	if err := renderTemplates(a.Params, map[string]*string{"the pipeline": &value}); err != nil {
+		return err
+	}
+	pipeline, err := parsePipeline(value)
+	if err != nil {
+		return err
+	}
+
+	aggregateOptions := mongoOptions.Aggregate().SetAllowDiskUse(a.AllowDiskUse)
+	if a.BatchSize > 0 {
+		aggregateOptions.SetBatchSize(a.BatchSize)
+	}
+
+	manager, err := o.connect()
+	if err != nil {
+		return err
+	}
+	defer disconnect(manager)
+
+	database := a.Database
+	if len(database) == 0 {
+		database = manager.Database()
+	}
+
+	collection := manager.Primary().Database(database).Collection(a.Collection)
+
+	// The pipeline and the streaming of its results are bounded by --max-time, the timeout of the
+	// aggregations without it, and the server stops working on the pipeline once the client gives up.
+	ctx, cancel := operationContext(manager, client.AggregateOperation, a.MaxTime)
+	defer cancel()
+	aggregateOptions.MaxTime = client.MaxTime(ctx)
+	if err := a.Lint.check(ctx, collection, nil, pipeline); err != nil || a.Lint.Only {
+		return err
+	}
+	if len(a.Explain) > 0 {
+		command := bson.D{
+			{Key: "aggregate", Value: a.Collection},
+			{Key: "pipeline", Value: pipeline},
+			{Key: "cursor", Value: bson.D{}},
+			{Key: "allowDiskUse", Value: a.AllowDiskUse},
+		}
+		if aggregateOptions.MaxTime != nil {
+			command = append(command, bson.E{Key: "maxTimeMS", Value: aggregateOptions.MaxTime.Milliseconds()})
+		}
+		return explain(ctx, os.Stdout, collection.Database(), command, a.Explain)
+	}
+	cursor, err := collection.Aggregate(ctx, pipeline, aggregateOptions)
+	if err != nil {
+		return err
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	return client.Each(ctx, cursor, func(doc bson.Raw) error {
+		return writeLine(out, doc)
+	})
+}
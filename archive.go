@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/archival"
+	"github.com/bradmwilliams/mongodb-client/pkg/client"
+	"github.com/spf13/cobra"
+)
+
+func newArchiveCommand(o *options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "archive",
+		Short: "Run the archives of the config file and restore the documents they moved",
+		Long: `Archives move the old documents of a collection to cold storage, an archive collection or gzip
+NDJSON files of a directory or object store. They are defined in the archives section of --config
+and run as the background jobs archive-<name>:
+
+  archives:
+    episodes:
+      collection: episodes
+      field: published
+      maxAge: 8760h
+      destination: s3://cold-storage/mongodb
+      schedule: "@daily"
+
+Every chunk of moved documents is recorded in the manifest of the archive, the object
+<name>/manifest.json of the destination or the documents of the ` + archival.ManifestCollection + ` collection next
+to the archive collection. The documents of the manifest can be written back on demand.`,
+		Example: `  mongodb-client archive run episodes --config config.yaml
+  mongodb-client archive list episodes --config config.yaml
+  mongodb-client archive restore episodes --config config.yaml --location mongodb/episodes/sampledb.episodes-20240101T000000Z-0001.ndjson.gz`,
+	}
+	cmd.AddCommand(newArchiveRunCommand(o))
+	cmd.AddCommand(newArchiveListCommand(o))
+	cmd.AddCommand(newArchiveRestoreCommand(o))
+	return cmd
+}
+
+// archivalPolicy connects to the database and returns the archive called name of the config file.
+func (o *options) archivalPolicy(name string) (*client.ConnectionManager, *archival.Policy, error) {
+	if len(o.ConfigFile) == 0 {
+		return nil, nil, fmt.Errorf("--config is required to find the archive %s", name)
+	}
+	cfg, err := o.loadConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	manager, err := o.connect()
+	if err != nil {
+		return nil, nil, err
+	}
+	policy, err := archivalPolicy(cfg, name, manager.Database())
+	if err != nil {
+		disconnect(manager)
+		return nil, nil, err
+	}
+	return manager, policy, nil
+}
+
+func newArchiveRunCommand(o *options) *cobra.Command {
+	return &cobra.Command{
+		Use:     "run NAME",
+		Short:   "Move the documents of an archive now instead of waiting for its job",
+		Example: `  mongodb-client archive run episodes --config config.yaml`,
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			manager, policy, err := o.archivalPolicy(arguments[0])
+			if err != nil {
+				return err
+			}
+			defer disconnect(manager)
+			ctx, cancel := cmdContext()
+			defer cancel()
+			return policy.Run(ctx, manager.Primary())
+		},
+	}
+}
+
+type archiveListOptions struct{}
+
+func newArchiveListCommand(o *options) *cobra.Command {
+	l := &archiveListOptions{}
+	cmd := &cobra.Command{
+		Use:     "list NAME",
+		Short:   "List the entries of the manifest of an archive",
+		Example: `  mongodb-client archive list episodes --config config.yaml -o json`,
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			return l.run(o, arguments[0])
+		},
+	}
+	addJSONFlag(cmd.Flags(), o)
+	return cmd
+}
+
+func (l *archiveListOptions) run(o *options, name string) error {
+	out, err := o.printer()
+	if err != nil {
+		return err
+	}
+	manager, policy, err := o.archivalPolicy(name)
+	if err != nil {
+		return err
+	}
+	defer disconnect(manager)
+	ctx, cancel := manager.Context()
+	defer cancel()
+	entries, err := policy.Entries(ctx, manager.Primary())
+	if err != nil {
+		return err
+	}
+	return out.print(os.Stdout, entries, func(stdout io.Writer) error {
+		w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "LOCATION\tNAMESPACE\tDOCUMENTS\tFIRST ID\tLAST ID\tCREATED")
+		for _, entry := range entries {
+			fmt.Fprintf(w, "%s\t%s.%s\t%d\t%s\t%s\t%s\n", entry.Location, entry.Database, entry.Collection, entry.Documents, entry.FirstID, entry.LastID, entry.Created.Format(time.RFC3339))
+		}
+		return w.Flush()
+	})
+}
+
+type archiveRestoreOptions struct {
+	archival.RestoreOptions
+	FilterExpr string
+}
+
+func newArchiveRestoreCommand(o *options) *cobra.Command {
+	r := &archiveRestoreOptions{
+		RestoreOptions: archival.RestoreOptions{BatchSize: 1000},
+	}
+	cmd := &cobra.Command{
+		Use:   "restore NAME",
+		Short: "Write the archived documents back to their collection",
+		Long: `Write the documents of an archive back to the collection they were moved from, or to --to-db and
+--to-collection, replacing the documents with the same _id. The files of a destination are restored
+oldest first, all of them or those of --location, and the documents of an archive collection are
+restored as a whole or by --filter. The archive itself is left as it is.`,
+		Example: `  mongodb-client archive restore episodes --config config.yaml
+  mongodb-client archive restore episodes --config config.yaml --location mongodb/episodes/sampledb.episodes-20240101T000000Z-0001.ndjson.gz
+  mongodb-client archive restore sessions --config config.yaml --filter '{"user":"alice"}' --to-collection sessions_restored`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			return r.run(o, arguments[0])
+		},
+	}
+
+	flagset := cmd.Flags()
+	flagset.StringSliceVar(&r.Locations, "location", r.Locations, "Files of the manifest to restore, all of them by default")
+	flagset.StringVar(&r.FilterExpr, "filter", r.FilterExpr, "Extended JSON query filter of the documents restored from an archive collection")
+	flagset.StringVar(&r.Database, "to-db", r.Database, "Database to restore the documents to, the one they were archived from by default")
+	flagset.StringVar(&r.Collection, "to-collection", r.Collection, "Collection to restore the documents to, the one they were archived from by default")
+	flagset.IntVar(&r.BatchSize, "batch-size", r.BatchSize, "Number of documents written per request")
+	return cmd
+}
+
+func (r *archiveRestoreOptions) run(o *options, name string) error {
+	if r.BatchSize < 1 {
+		return fmt.Errorf("--batch-size must be at least 1")
+	}
+	filter, err := parseDocument(r.FilterExpr)
+	if err != nil {
+		return fmt.Errorf("--filter: %w", err)
+	}
+	if len(filter) > 0 {
+		r.Filter = filter
+	}
+	manager, policy, err := o.archivalPolicy(name)
+	if err != nil {
+		return err
+	}
+	defer disconnect(manager)
+	ctx, cancel := cmdContext()
+	defer cancel()
+	result, err := policy.Restore(ctx, manager.Primary(), r.RestoreOptions)
+	fmt.Fprintln(os.Stdout, result)
+	return err
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/audit"
+	"github.com/bradmwilliams/mongodb-client/pkg/auth"
+	"github.com/bradmwilliams/mongodb-client/pkg/client"
+	"github.com/bradmwilliams/mongodb-client/pkg/config"
+	"github.com/spf13/cobra"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mongoOptions "go.mongodb.org/mongo-driver/mongo/options"
+	"k8s.io/klog"
+)
+
+// startAudit audits the commands of the clients created by the command at source when the config
+// file has an audit section. The logger must be set before the clients are created.
+func (o *options) startAudit(source string) error {
+	cfg, err := o.loadConfig()
+	if err != nil {
+		return err
+	}
+	if cfg.Audit == nil {
+		return nil
+	}
+	if len(cfg.Audit.File) == 0 && len(cfg.Audit.Collection) == 0 {
+		return fmt.Errorf("the audit section of the config file must set a file or a collection")
+	}
+	var sinks []audit.Sink
+	if len(cfg.Audit.File) > 0 {
+		sink, err := audit.NewFileSink(cfg.Audit.File)
+		if err != nil {
+			return err
+		}
+		sinks = append(sinks, sink)
+	}
+	if len(cfg.Audit.Collection) > 0 {
+		audited := *cfg.Audit
+		sinks = append(sinks, audit.NewCollectionSink(func(ctx context.Context) (*mongo.Collection, func(), error) {
+			return o.connectAuditCollection(ctx, audited)
+		}, o.OperationTimeout))
+	}
+	o.audit = audit.NewLogger(source, sinks...)
+	o.audit.Actor = func(ctx context.Context) string {
+		if identity := auth.FromContext(ctx); identity != nil {
+			return identity.Name
+		}
+		return ""
+	}
+	return nil
+}
+
+// stopAudit closes the audit sinks, once every client is disconnected.
+func (o *options) stopAudit() {
+	if o.audit == nil {
+		return
+	}
+	if err := o.audit.Close(); err != nil {
+		klog.Errorf("%v", err)
+	}
+}
+
+// connectAuditCollection returns the audit collection of cfg and the function disconnecting its
+// client. The client is neither audited, which would audit the records, nor read-only.
+func (o *options) connectAuditCollection(ctx context.Context, cfg config.AuditConfig) (*mongo.Collection, func(), error) {
+	connection, err := o.connectionConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	connection.ClientOptions.Monitor = nil
+	connection.ReadOnly = false
+	connection.AutoEncryption = nil
+	manager := client.NewConnectionManager(connection)
+	if err := manager.Connect(ctx); err != nil {
+		manager.Disconnect(context.Background())
+		return nil, nil, err
+	}
+	c, database := manager.Primary(), manager.Database()
+	if len(cfg.Database) > 0 && cfg.Database != database {
+		c, database = manager.Admin(), cfg.Database
+	}
+	return c.Database(database).Collection(cfg.Collection), func() { disconnect(manager) }, nil
+}
+
+type auditReportOptions struct {
+	File       string
+	Collection bool
+	Since      string
+	Until      string
+	Actors     []string
+	Commands   []string
+	Namespace  string
+	Failed     bool
+	Summary    bool
+}
+
+// match returns whether the records match the filters of the options.
+func (r *auditReportOptions) match(now time.Time) (func(audit.Record) bool, error) {
+	var since, until time.Time
+	var err error
+	if len(r.Since) > 0 {
+		if since, err = parseOIDTime(r.Since, now); err != nil {
+			return nil, fmt.Errorf("--since: %w", err)
+		}
+	}
+	if len(r.Until) > 0 {
+		if until, err = parseOIDTime(r.Until, now); err != nil {
+			return nil, fmt.Errorf("--until: %w", err)
+		}
+	}
+	if _, err := path.Match(r.Namespace, ""); err != nil {
+		return nil, fmt.Errorf("--namespace: %w", err)
+	}
+	return func(record audit.Record) bool {
+		if !since.IsZero() && record.Time.Before(since) || !until.IsZero() && !record.Time.Before(until) {
+			return false
+		}
+		if len(r.Actors) > 0 && !contains(r.Actors, record.Actor) {
+			return false
+		}
+		if len(r.Commands) > 0 && !contains(r.Commands, record.Command) {
+			return false
+		}
+		if len(r.Namespace) > 0 {
+			if ok, _ := path.Match(r.Namespace, record.Namespace()); !ok {
+				return false
+			}
+		}
+		return !r.Failed || len(record.Error) > 0 || record.WriteErrors > 0
+	}, nil
+}
+
+func newAuditCommand(o *options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "audit",
+		Short: "Reports the operations recorded by the audit section of the config file",
+		Long: `Reports the operations recorded by the audit section of the config file.
+
+When the config file has an audit section, every command that changes data, users, indexes or the
+deployment, whether it is run by a subcommand, a background job or a request to the API, is
+recorded with who ran it, when, its namespace, the shape of its filters without their values and
+its result counts:
+
+  audit:
+    file: /var/log/mongodb-client/audit.log
+    collection: audit
+    database: ops
+
+The file is appended a JSON record per line, the collection, of the application database unless
+database is set, is written by a client of its own.`,
+	}
+	cmd.AddCommand(newAuditReportCommand(o))
+	return cmd
+}
+
+func newAuditReportCommand(o *options) *cobra.Command {
+	r := &auditReportOptions{}
+	cmd := &cobra.Command{
+		Use:   "report",
+		Short: "Lists the audited operations, or sums them up by actor, command and namespace",
+		Long: `Lists the audited operations, or sums them up by actor, command and namespace.
+
+The records are read from the audit file of the config file, or --file, or from the audit
+collection with --collection.`,
+		Example: `  mongodb-client --config config.yaml audit report --since 24h --namespace 'app.*'
+  mongodb-client --config config.yaml audit report --collection --summary --actor alice`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			out, err := o.printer()
+			if err != nil {
+				return err
+			}
+			match, err := r.match(time.Now().UTC())
+			if err != nil {
+				return err
+			}
+			records, err := r.records(o, match)
+			if err != nil {
+				return err
+			}
+			if r.Summary {
+				return printAuditSummary(out, records)
+			}
+			return out.print(os.Stdout, records, func(stdout io.Writer) error {
+				w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+				fmt.Fprintln(w, "TIME\tACTOR\tSOURCE\tCOMMAND\tNAMESPACE\tFILTERS\tN\tMODIFIED\tDURATION\tERROR")
+				for _, record := range records {
+					recordErr := record.Error
+					if len(recordErr) == 0 && record.WriteErrors > 0 {
+						recordErr = fmt.Sprintf("%d write errors", record.WriteErrors)
+					}
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%d\t%d\t%s\t%s\n", record.Time.Format(time.RFC3339), record.Actor, record.Source, record.Command, record.Namespace(), strings.Join(record.Filters, " "), record.N, record.Modified, time.Duration(record.DurationMS)*time.Millisecond, recordErr)
+				}
+				return w.Flush()
+			})
+		},
+	}
+	flagset := cmd.Flags()
+	flagset.StringVar(&r.File, "file", r.File, "Audit file to read, defaults to the file of the audit section of the config file")
+	flagset.BoolVar(&r.Collection, "collection", r.Collection, "Read the audit collection of the config file instead of the audit file")
+	flagset.StringVar(&r.Since, "since", r.Since, "Only report the operations run at or after this time: 2006-01-02T15:04:05Z, 2006-01-02, seconds since the epoch or a duration before now such as 36h or 7d")
+	flagset.StringVar(&r.Until, "until", r.Until, "Only report the operations run before this time, in the formats of --since")
+	flagset.StringSliceVar(&r.Actors, "actor", r.Actors, "Only report the operations of these actors")
+	flagset.StringSliceVar(&r.Commands, "command", r.Commands, "Only report these commands, such as delete or dropDatabase")
+	flagset.StringVar(&r.Namespace, "namespace", r.Namespace, "Only report the operations on the namespaces matching this pattern, such as app.users, app.* or app")
+	flagset.BoolVar(&r.Failed, "failed", r.Failed, "Only report the operations that failed, or had write errors")
+	flagset.BoolVar(&r.Summary, "summary", r.Summary, "Sum the operations, their documents and their failures up by actor, command and namespace")
+	return cmd
+}
+
+// records returns the matching records of the audit file or collection, oldest first.
+func (r *auditReportOptions) records(o *options, match func(audit.Record) bool) ([]audit.Record, error) {
+	cfg, err := o.loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	if !r.Collection {
+		file := r.File
+		if len(file) == 0 && cfg.Audit != nil {
+			file = cfg.Audit.File
+		}
+		if len(file) == 0 {
+			return nil, fmt.Errorf("--file is required when the config file has no audit file")
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, fmt.Errorf("unable to open the audit file: %w", err)
+		}
+		defer f.Close()
+		return audit.ReadFile(f, match)
+	}
+
+	if cfg.Audit == nil || len(cfg.Audit.Collection) == 0 {
+		return nil, fmt.Errorf("--collection requires the config file to have an audit collection")
+	}
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+	ctx, cancel := cmdContext()
+	defer cancel()
+	collection, disconnectAudit, err := o.connectAuditCollection(ctx, *cfg.Audit)
+	if err != nil {
+		return nil, err
+	}
+	defer disconnectAudit()
+	// The actors and commands are filtered by the server, the other filters by match.
+	filter := bson.D{}
+	if len(r.Actors) > 0 {
+		filter = append(filter, bson.E{Key: "actor", Value: bson.D{{Key: "$in", Value: r.Actors}}})
+	}
+	if len(r.Commands) > 0 {
+		filter = append(filter, bson.E{Key: "command", Value: bson.D{{Key: "$in", Value: r.Commands}}})
+	}
+	cursor, err := collection.Find(ctx, filter, mongoOptions.Find().SetSort(bson.D{{Key: "time", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the audit collection: %w", err)
+	}
+	var records []audit.Record
+	err = client.Each(ctx, cursor, func(doc bson.Raw) error {
+		var record audit.Record
+		if err := bson.Unmarshal(doc, &record); err != nil {
+			return fmt.Errorf("invalid audit record: %w", err)
+		}
+		if match(record) {
+			records = append(records, record)
+		}
+		return nil
+	})
+	return records, err
+}
+
+// auditSummary sums up the operations of an actor, a command and a namespace.
+type auditSummary struct {
+	Actor      string    `json:"actor"`
+	Command    string    `json:"command"`
+	Namespace  string    `json:"namespace"`
+	Operations int       `json:"operations"`
+	Documents  int64     `json:"documents"`
+	Failures   int       `json:"failures"`
+	Last       time.Time `json:"last"`
+}
+
+func printAuditSummary(out *printer, records []audit.Record) error {
+	byKey := map[[3]string]*auditSummary{}
+	var summaries []*auditSummary
+	for _, record := range records {
+		key := [3]string{record.Actor, record.Command, record.Namespace()}
+		summary, ok := byKey[key]
+		if !ok {
+			summary = &auditSummary{Actor: record.Actor, Command: record.Command, Namespace: record.Namespace()}
+			byKey[key] = summary
+			summaries = append(summaries, summary)
+		}
+		summary.Operations++
+		summary.Documents += record.N
+		if len(record.Error) > 0 || record.WriteErrors > 0 {
+			summary.Failures++
+		}
+		if record.Time.After(summary.Last) {
+			summary.Last = record.Time
+		}
+	}
+	sort.SliceStable(summaries, func(i, j int) bool {
+		a, b := summaries[i], summaries[j]
+		if a.Actor != b.Actor {
+			return a.Actor < b.Actor
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Command < b.Command
+	})
+	return out.print(os.Stdout, summaries, func(stdout io.Writer) error {
+		w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ACTOR\tNAMESPACE\tCOMMAND\tOPERATIONS\tDOCUMENTS\tFAILURES\tLAST")
+		for _, s := range summaries {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%d\t%s\n", s.Actor, s.Namespace, s.Command, s.Operations, s.Documents, s.Failures, s.Last.Format(time.RFC3339))
+		}
+		return w.Flush()
+	})
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/bench"
+	"github.com/bradmwilliams/mongodb-client/pkg/generate"
+	"github.com/spf13/cobra"
+)
+
+type benchOptions struct {
+	bench.Options
+	Database      string
+	Collection    string
+	Workload      string
+	ReadRatio     float64
+	MetricsListen string
+}
+
+func newBenchCommand(o *options) *cobra.Command {
+	b := &benchOptions{
+		Options:   bench.Options{Concurrency: 8, Duration: time.Minute, ReportInterval: 10 * time.Second},
+		ReadRatio: 0.9,
+	}
+	cmd := &cobra.Command{
+		Use:   "bench",
+		Short: "Run a workload of reads and writes against a collection and report its performance",
+		Long: `Run a workload against a collection with --concurrency workers for --duration, or until interrupted
+when it is 0, and report the throughput, latency percentiles and error rate of every operation.
+
+The workload of --workload is a YAML or JSON file of weighted operations, each of them one of find,
+insert, update, delete or aggregate, whose documents are templates as in the generate command:
+
+  operations:
+  - name: episodes-of-podcast
+    weight: 8
+    find:
+      filter: {podcast: {$ref: podcasts}}
+      sort: {duration: -1}
+      limit: 20
+  - name: rename-episode
+    weight: 1
+    update:
+      filter: {_id: {$ref: episodes}}
+      update: {$set: {title: {$fake: sentence}}}
+  - name: new-episode
+    weight: 1
+    insert: {podcast: {$ref: podcasts}, title: {$fake: sentence}, duration: {$fake: int, min: 1, max: 90}}
+
+Without --workload, --read-ratio of the operations find a document of the collection by an _id
+sampled from it and the others insert small fake documents into it.
+
+The statistics are printed every --report-interval on standard error, and exported as metrics on
+--metrics-listen during the run.`,
+		Example: `  mongodb-client bench --collection episodes --read-ratio 0.95 --concurrency 32 --duration 5m
+  mongodb-client bench --collection episodes --workload workload.yaml --rate 2000 --metrics-listen :8081
+  mongodb-client bench --collection episodes --workload workload.yaml --duration 30s -o json`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			if !cmd.Flags().Changed("seed") {
+				b.Seed = time.Now().UnixNano()
+			}
+			return b.run(o)
+		},
+	}
+
+	flagset := cmd.Flags()
+	flagset.StringVar(&b.Database, "db", b.Database, "Database of the collection, defaults to MONGODB_DATABASE")
+	flagset.StringVar(&b.Collection, "collection", b.Collection, "Collection to run the workload against")
+	flagset.StringVar(&b.Workload, "workload", b.Workload, "YAML or JSON file of the operations of the workload")
+	flagset.Float64Var(&b.ReadRatio, "read-ratio", b.ReadRatio, "Fraction of reads of the default workload, between 0 and 1")
+	flagset.IntVar(&b.Concurrency, "concurrency", b.Concurrency, "Number of operations run in parallel")
+	flagset.DurationVar(&b.Duration, "duration", b.Duration, "Duration of the run, 0 to run until interrupted")
+	flagset.Float64Var(&b.Rate, "rate", b.Rate, "Maximum number of operations started per second, unlimited when 0")
+	flagset.Int64Var(&b.Seed, "seed", b.Seed, "Seed of the choice of operations and of their values, a random one by default")
+	flagset.DurationVar(&b.ReportInterval, "report-interval", b.ReportInterval, "Interval between reports on standard error")
+	flagset.StringVar(&b.MetricsListen, "metrics-listen", b.MetricsListen, "Serve the metrics of the operations on this address during the run, such as :8081")
+	addJSONFlag(flagset, o)
+	cmd.MarkFlagRequired("collection")
+	return cmd
+}
+
+// benchStatistics are the statistics of an operation as printed by --output, latencies are in
+// milliseconds.
+type benchStatistics struct {
+	Operation string  `json:"operation"`
+	Count     int64   `json:"count"`
+	Errors    int64   `json:"errors"`
+	Documents int64   `json:"documents"`
+	Rate      float64 `json:"rate"`
+	ErrorRate float64 `json:"errorRate"`
+	P50       float64 `json:"p50Millis"`
+	P90       float64 `json:"p90Millis"`
+	P99       float64 `json:"p99Millis"`
+	Max       float64 `json:"maxMillis"`
+}
+
+func (b *benchOptions) run(o *options) error {
+	if b.Concurrency < 1 {
+		return fmt.Errorf("--concurrency must be at least 1")
+	}
+	if b.Duration < 0 {
+		return fmt.Errorf("--duration must not be negative")
+	}
+	if b.Rate < 0 {
+		return fmt.Errorf("--rate must not be negative")
+	}
+	if b.ReadRatio < 0 || b.ReadRatio > 1 {
+		return fmt.Errorf("--read-ratio must be between 0 and 1")
+	}
+	workload, err := b.workload()
+	if err != nil {
+		return err
+	}
+	out, err := o.printer()
+	if err != nil {
+		return err
+	}
+	manager, err := o.connect()
+	if err != nil {
+		return err
+	}
+	defer disconnect(manager)
+
+	database := b.Database
+	if len(database) == 0 {
+		database = manager.Database()
+	}
+	if len(b.MetricsListen) > 0 {
+		serveMetrics(b.MetricsListen)
+	}
+	b.Report = func(r bench.Report) {
+		total := r.Total()
+		fmt.Fprintf(os.Stderr, "%s: %d operations, %.0f/s, %.2f%% errors, p50 %s, p99 %s\n", r.Elapsed.Round(time.Second), total.Count, total.Rate(), total.ErrorRate()*100, total.P50, total.P99)
+	}
+
+	db := manager.Primary().Database(database)
+	ctx, cancel := cmdContext()
+	defer cancel()
+	report, err := bench.Run(ctx, db.Collection(b.Collection), workload, generate.Sample(db), b.Options)
+	if report.Elapsed > 0 {
+		if printErr := b.print(out, report); printErr != nil && err == nil {
+			err = printErr
+		}
+	}
+	return err
+}
+
+// workload returns the workload of --workload, or the default one of --read-ratio.
+func (b *benchOptions) workload() (*bench.Workload, error) {
+	if len(b.Workload) > 0 {
+		return bench.LoadWorkload(b.Workload)
+	}
+	collection, err := json.Marshal(b.Collection)
+	if err != nil {
+		return nil, err
+	}
+	workload := &bench.Workload{}
+	reads := int(math.Round(b.ReadRatio * 100))
+	if reads > 0 {
+		workload.Operations = append(workload.Operations, bench.Operation{
+			Name:   "find-by-id",
+			Weight: reads,
+			Find:   &bench.Find{Filter: json.RawMessage(`{"_id": {"$ref": ` + string(collection) + `}}`), Limit: 1},
+		})
+	}
+	if reads < 100 {
+		workload.Operations = append(workload.Operations, bench.Operation{
+			Name:   "insert",
+			Weight: 100 - reads,
+			Insert: json.RawMessage(`{"bench": true, "name": {"$fake": "name"}, "createdAt": {"$fake": "date"}, "text": {"$fake": "sentence"}}`),
+		})
+	}
+	return workload, nil
+}
+
+func (b *benchOptions) print(out *printer, report bench.Report) error {
+	operations := append(report.Operations, report.Total())
+	millis := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	var statistics []benchStatistics
+	for _, s := range operations {
+		statistics = append(statistics, benchStatistics{
+			Operation: s.Name, Count: s.Count, Errors: s.Errors, Documents: s.Documents, Rate: s.Rate(), ErrorRate: s.ErrorRate(),
+			P50: millis(s.P50), P90: millis(s.P90), P99: millis(s.P99), Max: millis(s.Max),
+		})
+	}
+	return out.print(os.Stdout, statistics, func(stdout io.Writer) error {
+		w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "OPERATION\tCOUNT\tRATE\tERRORS\tDOCUMENTS\tP50\tP90\tP99\tMAX")
+		for _, s := range operations {
+			fmt.Fprintf(w, "%s\t%d\t%.1f/s\t%d (%.2f%%)\t%d\t%s\t%s\t%s\t%s\n", s.Name, s.Count, s.Rate(), s.Errors, s.ErrorRate()*100, s.Documents, s.P50, s.P90, s.P99, s.Max)
+		}
+		return w.Flush()
+	})
+}
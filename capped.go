@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mongoOptions "go.mongodb.org/mongo-driver/mongo/options"
+	"k8s.io/klog"
+)
+
+// cappedPositionLost is the error of a tailable cursor whose next document was overwritten.
+const cappedPositionLost = 136
+
+type cappedOptions struct {
+	Database string
+	Size     int64
+	Max      int64
+}
+
+func newCappedCommand(o *options) *cobra.Command {
+	c := &cappedOptions{}
+	cmd := &cobra.Command{
+		Use:   "capped",
+		Short: "Create capped collections, or convert collections to capped ones",
+		Long: `Capped collections hold at most --size bytes, and at most --max documents when it is set, and
+overwrite their oldest documents once they are full. Their documents are kept in insertion order,
+which the tail command follows. They can also be declared in the manifest of the provision command.`,
+		Example: `  mongodb-client capped create logs --size 104857600 --max 100000
+  mongodb-client capped convert events --size 1073741824
+  mongodb-client tail --collection logs --await`,
+	}
+	flagset := cmd.PersistentFlags()
+	flagset.StringVar(&c.Database, "db", c.Database, "Database of the collection, defaults to MONGODB_DATABASE")
+	flagset.Int64Var(&c.Size, "size", c.Size, "Maximum size of the collection in bytes")
+	cmd.AddCommand(newCappedCreateCommand(o, c))
+	cmd.AddCommand(newCappedConvertCommand(o, c))
+	return cmd
+}
+
+func newCappedCreateCommand(o *options, c *cappedOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "create NAME",
+		Short:   "Create a capped collection",
+		Example: `  mongodb-client capped create logs --size 104857600 --max 100000`,
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			if c.Size <= 0 {
+				return fmt.Errorf("--size must be greater than zero")
+			}
+			if c.Max < 0 {
+				return fmt.Errorf("--max must not be negative")
+			}
+			createOptions := mongoOptions.CreateCollection().SetCapped(true).SetSizeInBytes(c.Size)
+			if c.Max > 0 {
+				createOptions.SetMaxDocuments(c.Max)
+			}
+			return c.run(o, arguments[0], "Created the capped collection %s.%s of %d bytes\n", func(ctx context.Context, db *mongo.Database) error {
+				return db.CreateCollection(ctx, arguments[0], createOptions)
+			})
+		},
+	}
+	cmd.Flags().Int64Var(&c.Max, "max", c.Max, "Maximum number of documents of the collection, unlimited when 0")
+	return cmd
+}
+
+func newCappedConvertCommand(o *options, c *cappedOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "convert NAME",
+		Short: "Convert a collection to a capped collection",
+		Long: `Convert the collection NAME to a capped collection of --size bytes, keeping its newest documents
+that fit. The conversion locks the database while the documents are copied, it can not be undone
+and the indexes of the collection other than _id are dropped.`,
+		Example: `  mongodb-client capped convert events --size 1073741824`,
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			if c.Size <= 0 {
+				return fmt.Errorf("--size must be greater than zero")
+			}
+			return c.run(o, arguments[0], "Converted %s.%s to a capped collection of %d bytes\n", func(ctx context.Context, db *mongo.Database) error {
+				return db.RunCommand(ctx, bson.D{
+					{Key: "convertToCapped", Value: arguments[0]},
+					{Key: "size", Value: c.Size},
+				}).Err()
+			})
+		},
+	}
+}
+
+// run applies fn to the database of the options and reports the capped collection with done.
+func (c *cappedOptions) run(o *options, name, done string, fn func(ctx context.Context, db *mongo.Database) error) error {
+	manager, err := o.connect()
+	if err != nil {
+		return err
+	}
+	defer disconnect(manager)
+	database := c.Database
+	if len(database) == 0 {
+		database = manager.Database()
+	}
+	ctx, cancel := cmdContext()
+	defer cancel()
+	if err := fn(ctx, manager.Primary().Database(database)); err != nil {
+		return fmt.Errorf("unable to make %s.%s a capped collection: %w", database, name, err)
+	}
+	fmt.Fprintf(os.Stderr, done, database, name, c.Size)
+	return nil
+}
+
+type tailOptions struct {
+	Database     string
+	Collection   string
+	Filter       string
+	Projection   string
+	Lines        int64
+	Await        bool
+	Output       string
+	MaxAwaitTime time.Duration
+}
+
+func newTailCommand(o *options) *cobra.Command {
+	t := &tailOptions{
+		Lines:        10,
+		Output:       "pretty",
+		MaxAwaitTime: time.Second,
+	}
+	cmd := &cobra.Command{
+		Use:   "tail",
+		Short: "Print the last documents of a capped collection and follow the ones inserted after them",
+		Long: `Print the last --lines documents of a capped collection, in insertion order, like tail prints the
+last lines of a file. With --await the command then waits for new documents with a tailable cursor
+and prints them as they are inserted, until interrupted, which works on deployments without change
+streams, such as standalone servers.
+
+When the cursor is lost, because the collection was empty or because the next document was
+overwritten before it was read, the command reopens it after the _id of the last printed
+document: the _ids should increase with the insertions, as ObjectIDs created by the clients do.`,
+		Example: `  mongodb-client tail --collection logs
+  mongodb-client tail --collection logs --await --filter '{"level":"error"}' --output ndjson`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			return t.run(o)
+		},
+	}
+
+	flagset := cmd.Flags()
+	flagset.StringVar(&t.Database, "db", t.Database, "Database of the collection, defaults to MONGODB_DATABASE")
+	flagset.StringVar(&t.Collection, "collection", t.Collection, "Capped collection to print")
+	flagset.StringVar(&t.Filter, "filter", t.Filter, "Extended JSON query filter of the documents to print")
+	flagset.StringVar(&t.Projection, "project", t.Projection, "Extended JSON projection")
+	flagset.Int64VarP(&t.Lines, "lines", "n", t.Lines, "Number of existing documents to print first, all of them when negative")
+	flagset.BoolVar(&t.Await, "await", t.Await, "Wait for new documents and print them until interrupted")
+	flagset.StringVar(&t.Output, "output", t.Output, fmt.Sprintf("Output format of the documents: %v", watchOutputs))
+	flagset.DurationVar(&t.MaxAwaitTime, "max-await-time", t.MaxAwaitTime, "Maximum time the server waits for new documents before answering a cursor request")
+	cmd.MarkFlagRequired("collection")
+	return cmd
+}
+
+func (t *tailOptions) run(o *options) error {
+	var format func(io.Writer, interface{}) error
+	switch t.Output {
+	case "pretty":
+		format = printDocument
+	case "ndjson":
+		format = writeLine
+	default:
+		return fmt.Errorf("--output must be one of %v", watchOutputs)
+	}
+	if t.MaxAwaitTime <= 0 {
+		return fmt.Errorf("--max-await-time must be greater than zero")
+	}
+	filter, err := parseDocument(t.Filter)
+	if err != nil {
+		return fmt.Errorf("--filter: %w", err)
+	}
+	projection, err := parseDocument(t.Projection)
+	if err != nil {
+		return fmt.Errorf("--project: %w", err)
+	}
+
+	manager, err := o.connect()
+	if err != nil {
+		return err
+	}
+	defer disconnect(manager)
+	database := t.Database
+	if len(database) == 0 {
+		database = manager.Database()
+	}
+	collection := manager.Primary().Database(database).Collection(t.Collection)
+
+	ctx, cancel := cmdContext()
+	defer cancel()
+	if err := checkCapped(ctx, collection); err != nil {
+		return err
+	}
+	var skip int64
+	if t.Lines >= 0 {
+		count, err := collection.CountDocuments(ctx, filter)
+		if err != nil {
+			return fmt.Errorf("unable to count the documents of %s.%s: %w", database, t.Collection, err)
+		}
+		if count > t.Lines {
+			skip = count - t.Lines
+		}
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	var last interface{}
+	for {
+		findOptions := mongoOptions.Find().SetSort(bson.D{{Key: "$natural", Value: 1}})
+		if len(projection) > 0 {
+			findOptions.SetProjection(projection)
+		}
+		query := filter
+		switch {
+		case last != nil:
+			query = bson.D{{Key: "$and", Value: bson.A{filter, bson.D{{Key: "_id", Value: bson.D{{Key: "$gt", Value: last}}}}}}}
+		case skip > 0:
+			findOptions.SetSkip(skip)
+		}
+		if t.Await {
+			findOptions.SetCursorType(mongoOptions.TailableAwait).SetMaxAwaitTime(t.MaxAwaitTime)
+		}
+		cursor, err := collection.Find(ctx, query, findOptions)
+		if err != nil {
+			return fmt.Errorf("unable to read %s.%s: %w", database, t.Collection, err)
+		}
+		for cursor.Next(ctx) {
+			// The current document is only valid until the next one is read.
+			if id, err := cursor.Current.LookupErr("_id"); err == nil {
+				last = bson.RawValue{Type: id.Type, Value: append([]byte(nil), id.Value...)}
+			}
+			if err := format(out, cursor.Current); err != nil {
+				cursor.Close(context.Background())
+				return err
+			}
+			// Documents are flushed one at a time so that they show up as soon as they are inserted.
+			if err := out.Flush(); err != nil {
+				cursor.Close(context.Background())
+				return err
+			}
+		}
+		err = cursor.Err()
+		cursor.Close(context.Background())
+		if !t.Await || ctx.Err() != nil {
+			return ignoreCanceled(ctx, err)
+		}
+		var commandErr mongo.CommandError
+		switch {
+		case errors.As(err, &commandErr) && commandErr.Code == cappedPositionLost:
+			klog.Warningf("Documents of %s.%s were overwritten before they were printed, resuming after the last printed one", database, t.Collection)
+		case err != nil:
+			return fmt.Errorf("unable to read %s.%s: %w", database, t.Collection, err)
+		}
+		// The cursor is dead, the collection was empty or the position was lost, wait a little
+		// before opening another one.
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(t.MaxAwaitTime):
+		}
+	}
+}
+
+// ignoreCanceled returns err, nil when it was caused by the interruption of ctx.
+func ignoreCanceled(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// checkCapped returns an error when collection is not a capped collection, tailable cursors are only
+// supported by capped collections.
+func checkCapped(ctx context.Context, collection *mongo.Collection) error {
+	cursor, err := collection.Database().ListCollections(ctx, bson.D{{Key: "name", Value: collection.Name()}})
+	if err != nil {
+		return fmt.Errorf("unable to read the options of %s: %w", collection.Name(), err)
+	}
+	var infos []struct {
+		Options struct {
+			Capped bool `bson:"capped"`
+		} `bson:"options"`
+	}
+	if err := cursor.All(ctx, &infos); err != nil {
+		return fmt.Errorf("unable to read the options of %s: %w", collection.Name(), err)
+	}
+	if len(infos) == 0 {
+		return fmt.Errorf("%s.%s does not exist", collection.Database().Name(), collection.Name())
+	}
+	if !infos[0].Options.Capped {
+		return fmt.Errorf("%s.%s is not a capped collection, only capped collections can be tailed", collection.Database().Name(), collection.Name())
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/bulk"
+	"github.com/bradmwilliams/mongodb-client/pkg/integrity"
+	"github.com/spf13/cobra"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type checkRefsOptions struct {
+	integrity.Options
+	Database string
+	Map      string
+}
+
+func newCheckRefsCommand(o *options) *cobra.Command {
+	c := &checkRefsOptions{
+		Options: integrity.Options{BatchSize: 1000},
+	}
+	cmd := &cobra.Command{
+		Use:   "check-refs",
+		Short: "Find broken references and duplicate keys between the collections of a database",
+		Long: `Check the references between the collections of a database and the keys of its collections, as
+declared in the reference map of --map, a YAML or JSON file:
+
+  references:
+  - name: episode-podcast
+    from: {collection: episodes, field: podcast}
+    to: {collection: podcasts, field: _id}
+    required: true
+    onOrphan: delete
+  keys:
+  - name: podcast-title
+    collection: podcasts
+    fields: [title]
+    onDuplicate: keepFirst
+
+A reference field holds a value, or an array of values, of the field of the "to" collection, _id by
+default. Documents whose references do not all exist are orphans, and documents without a required
+reference are dangling. Documents sharing the values of the fields of a key are duplicates.
+
+Every finding is printed. With --fix the actions of the map clean them up: onOrphan deletes the
+orphans or unsets their missing references, onDangling deletes the dangling documents and
+onDuplicate keeps the duplicate with the lowest _id. The command fails when it finds problems
+without --fix, or when the actions fail.`,
+		Example: `  mongodb-client check-refs --map references.yaml
+  mongodb-client check-refs --map references.yaml --db sampledb -o json
+  mongodb-client check-refs --map references.yaml --fix`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			return c.run(o)
+		},
+	}
+
+	flagset := cmd.Flags()
+	flagset.StringVar(&c.Database, "db", c.Database, "Database to check, defaults to MONGODB_DATABASE")
+	flagset.StringVar(&c.Map, "map", c.Map, "YAML or JSON file of the references and keys to check")
+	flagset.BoolVar(&c.Fix, "fix", c.Fix, "Apply the onOrphan, onDangling and onDuplicate actions of the map")
+	flagset.IntVar(&c.BatchSize, "batch-size", c.BatchSize, "Number of writes of the actions sent per request")
+	addJSONFlag(flagset, o)
+	cmd.MarkFlagRequired("map")
+	return cmd
+}
+
+// finding is a finding as printed by --output.
+type finding struct {
+	Kind       string            `json:"kind"`
+	Check      string            `json:"check"`
+	Collection string            `json:"collection"`
+	ID         json.RawMessage   `json:"_id,omitempty"`
+	Values     []json.RawMessage `json:"values,omitempty"`
+	IDs        []json.RawMessage `json:"ids,omitempty"`
+}
+
+func (c *checkRefsOptions) run(o *options) error {
+	if c.BatchSize < 1 {
+		return fmt.Errorf("--batch-size must be at least 1")
+	}
+	references, err := integrity.LoadMap(c.Map)
+	if err != nil {
+		return err
+	}
+	out, err := o.printer()
+	if err != nil {
+		return err
+	}
+	manager, err := o.connect()
+	if err != nil {
+		return err
+	}
+	defer disconnect(manager)
+
+	database := c.Database
+	if len(database) == 0 {
+		database = manager.Database()
+	}
+	ctx, cancel := cmdContext()
+	defer cancel()
+	// The findings are printed as they are found.
+	results, err := integrity.Check(ctx, manager.Primary().Database(database), references, c.Options, func(f integrity.Finding) error {
+		return out.print(os.Stdout, []finding{jsonFinding(f)}, func(stdout io.Writer) error {
+			var err error
+			switch f.Kind {
+			case integrity.Duplicate:
+				_, err = fmt.Fprintf(stdout, "%-10s %s %s %s: %s\n", f.Kind, f.Check, f.Collection, extJSONValues(f.Values), extJSONValues(f.IDs))
+			case integrity.Orphan:
+				_, err = fmt.Fprintf(stdout, "%-10s %s %s %s: missing %s\n", f.Kind, f.Check, f.Collection, extJSON(f.ID), extJSONValues(f.Values))
+			default:
+				_, err = fmt.Fprintf(stdout, "%-10s %s %s %s\n", f.Kind, f.Check, f.Collection, extJSON(f.ID))
+			}
+			return err
+		})
+	})
+
+	w := tabwriter.NewWriter(os.Stderr, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "CHECK\tORPHANS\tDANGLING\tDUPLICATES\tFIXED")
+	var left int64
+	var failures []bulk.Failure
+	for _, result := range results {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\n", result.Check, result.Orphans, result.Dangling, result.Duplicates, result.Fixed)
+		if !c.Fix {
+			left += result.Findings()
+		}
+		failures = append(failures, result.FixFailures...)
+	}
+	w.Flush()
+	if err != nil {
+		return err
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("unable to clean up %s: %w", database, &bulk.Error{Failures: failures})
+	}
+	if left > 0 {
+		return fmt.Errorf("%d problems found in %s", left, database)
+	}
+	return nil
+}
+
+// extJSONValues returns values as a relaxed Extended JSON array.
+func extJSONValues(values []bson.RawValue) string {
+	parts := make([]string, 0, len(values))
+	for _, value := range values {
+		parts = append(parts, extJSON(value))
+	}
+	return "[" + strings.Join(parts, ",") + "]"
+}
+
+func jsonFinding(f integrity.Finding) finding {
+	out := finding{Kind: f.Kind, Check: f.Check, Collection: f.Collection}
+	if f.ID.Type != 0 {
+		out.ID = json.RawMessage(extJSON(f.ID))
+	}
+	for _, value := range f.Values {
+		out.Values = append(out.Values, json.RawMessage(extJSON(value)))
+	}
+	for _, id := range f.IDs {
+		out.IDs = append(out.IDs, json.RawMessage(extJSON(id)))
+	}
+	return out
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// protectedDatabases are never dropped or emptied, whatever the config file.
+var protectedDatabases = []string{"admin", "config", "local"}
+
+// checkProtected refuses to drop or empty the collection database.collection, or the database when
+// collection is empty, when it matches a protected namespace. A database holding a protected
+// collection is protected as well.
+func (o *options) checkProtected(database, collection string) error {
+	cfg, err := o.loadConfig()
+	if err != nil {
+		return err
+	}
+	namespace := database
+	if len(collection) > 0 {
+		namespace += "." + collection
+	}
+	for _, pattern := range append(append([]string{}, protectedDatabases...), cfg.Protected...) {
+		parts := strings.SplitN(pattern, ".", 2)
+		matched, err := path.Match(parts[0], database)
+		if err == nil && matched && len(parts) == 2 && len(collection) > 0 {
+			matched, err = path.Match(parts[1], collection)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid protected namespace %q: %w", pattern, err)
+		}
+		if matched {
+			return fmt.Errorf("%s is protected by %s, it can not be dropped or emptied", namespace, pattern)
+		}
+	}
+	return nil
+}
+
+// confirm asks on the terminal to confirm the destructive action described by format, unless --yes
+// is set. Without a terminal to ask on, the action requires --yes.
+func (o *options) confirm(format string, args ...interface{}) error {
+	if o.Yes {
+		return nil
+	}
+	action := fmt.Sprintf(format, args...)
+	if !terminal.IsTerminal(int(os.Stdin.Fd())) {
+		return fmt.Errorf("%s requires a confirmation, use --yes to confirm it when there is no terminal", action)
+	}
+	fmt.Fprintf(os.Stderr, "%s? Type yes to continue: ", action)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("unable to read the confirmation: %w", err)
+	}
+	if !confirmed(answer) {
+		return fmt.Errorf("%s was not confirmed", action)
+	}
+	return nil
+}
+
+// confirmed reports whether answer confirms an action.
+func confirmed(answer string) bool {
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "yes" || answer == "y"
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/client"
+	"github.com/bradmwilliams/mongodb-client/pkg/config"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/connstring"
+)
+
+// profile returns the profile called name of the config file.
+func (o *options) profile(name string) (config.ProfileConfig, error) {
+	if len(o.ConfigFile) == 0 {
+		return config.ProfileConfig{}, fmt.Errorf("--config is required to use the profile %s", name)
+	}
+	cfg, err := o.loadConfig()
+	if err != nil {
+		return config.ProfileConfig{}, err
+	}
+	profile, ok := cfg.Profiles[name]
+	if !ok {
+		names := make([]string, 0, len(cfg.Profiles))
+		for n := range cfg.Profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return config.ProfileConfig{}, fmt.Errorf("no profile %s in the config file, the profiles are: %s", name, strings.Join(names, ", "))
+	}
+	return profile, nil
+}
+
+// profileConnection returns the connection details of the profile called name, with the defaults
+// of the connections described by the environment.
+func (o *options) profileConnection(name string) (client.Config, error) {
+	connection := client.Config{
+		OperationTimeout: client.DefaultOperationTimeout,
+		Backoff:          client.DefaultBackoff,
+		Retry:            client.DefaultRetryPolicy,
+	}
+	profile, err := o.profile(name)
+	if err != nil {
+		return connection, err
+	}
+	if connection.URI, err = profileURI(profile, profile.URI, profile.Username, profile.PasswordEnv); err != nil {
+		return connection, fmt.Errorf("profile %s: %w", name, err)
+	}
+	adminURI := profile.AdminURI
+	if len(adminURI) == 0 {
+		adminURI = profile.URI
+	}
+	if len(profile.AdminURI) > 0 || len(profile.AdminUsername) > 0 {
+		if connection.AdminURI, err = profileURI(profile, adminURI, profile.AdminUsername, profile.AdminPasswordEnv); err != nil {
+			return connection, fmt.Errorf("profile %s: adminURI: %w", name, err)
+		}
+	}
+	if len(profile.AdminPasswordEnv) > 0 {
+		connection.AdminPassword = os.Getenv(profile.AdminPasswordEnv)
+	}
+	cs, err := connstring.ParseAndValidate(connection.URI)
+	if err != nil {
+		return connection, fmt.Errorf("profile %s: invalid uri: %w", name, err)
+	}
+	connection.Database = profile.Database
+	connection.ReadOnly = profile.ReadOnly
+	if len(connection.Database) == 0 {
+		connection.Database = cs.Database
+	}
+	if len(connection.Database) == 0 {
+		return connection, fmt.Errorf("profile %s has no database, set database or the path of uri", name)
+	}
+	if len(cs.Hosts) > 0 {
+		connection.Host, connection.Port = cs.Hosts[0], "27017"
+		if host, port, err := net.SplitHostPort(cs.Hosts[0]); err == nil {
+			connection.Host, connection.Port = host, port
+		}
+	}
+	return connection, nil
+}
+
+// profileURI returns uri with the credentials of username, and the password of the environment
+// variable passwordEnv, and the TLS options of the profile.
+func profileURI(profile config.ProfileConfig, uri, username, passwordEnv string) (string, error) {
+	if len(uri) == 0 {
+		return "", fmt.Errorf("uri is required")
+	}
+	scheme := "mongodb://"
+	if strings.HasPrefix(uri, "mongodb+srv://") {
+		scheme = "mongodb+srv://"
+	} else if !strings.HasPrefix(uri, scheme) {
+		return "", fmt.Errorf("uri must start with mongodb:// or mongodb+srv://")
+	}
+	rest := strings.TrimPrefix(uri, scheme)
+	if len(username) > 0 {
+		hosts := rest
+		if i := strings.IndexAny(rest, "/?"); i >= 0 {
+			hosts = rest[:i]
+		}
+		if strings.Contains(hosts, "@") {
+			return "", fmt.Errorf("the credentials are set both in uri and in the username")
+		}
+		user := url.User(username)
+		if len(passwordEnv) > 0 {
+			password, ok := os.LookupEnv(passwordEnv)
+			if !ok {
+				return "", fmt.Errorf("%s is not defined", passwordEnv)
+			}
+			user = url.UserPassword(username, password)
+		}
+		rest = user.String() + "@" + rest
+	}
+	if profile.TLS != nil {
+		params := url.Values{"tls": {"true"}}
+		if len(profile.TLS.CAFile) > 0 {
+			params.Set("tlsCAFile", profile.TLS.CAFile)
+		}
+		if len(profile.TLS.CertificateKeyFile) > 0 {
+			params.Set("tlsCertificateKeyFile", profile.TLS.CertificateKeyFile)
+		}
+		if profile.TLS.Insecure {
+			params.Set("tlsInsecure", "true")
+		}
+		switch {
+		case strings.Contains(rest, "?"):
+			rest += "&" + params.Encode()
+		case strings.Contains(rest, "/"):
+			rest += "?" + params.Encode()
+		default:
+			rest += "/?" + params.Encode()
+		}
+	}
+	return scheme + rest, nil
+}
+
+// connectProfile returns the application client of the profile called name, with the connection
+// options of the command line, once it reaches the primary, and the database of the profile.
+func (o *options) connectProfile(ctx context.Context, name string) (*mongo.Client, string, error) {
+	connection, err := o.profileConnection(name)
+	if err != nil {
+		return nil, "", err
+	}
+	c, err := o.connectURI(ctx, connection.URI, connection.ReadOnly)
+	if err != nil {
+		return nil, "", fmt.Errorf("profile %s: %w", name, err)
+	}
+	return c, connection.Database, nil
+}
+
+// profileClients connects to the profiles referenced by jobs once, when a job first runs.
+type profileClients struct {
+	o *options
+
+	lock    sync.Mutex
+	clients map[string]*mongo.Client
+}
+
+// get returns the client of the profile called name, connecting to it unless it already is.
+func (p *profileClients) get(ctx context.Context, name string) (*mongo.Client, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if c, ok := p.clients[name]; ok {
+		return c, nil
+	}
+	c, _, err := p.o.connectProfile(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if p.clients == nil {
+		p.clients = map[string]*mongo.Client{}
+	}
+	p.clients[name] = c
+	return c, nil
+}
+
+// withProfileDatabases returns cfg with the retention policies, archives and downsampling policies
+// run against a profile that have no database set to the database of the profile, instead of the
+// application database of the main connection.
+func (o *options) withProfileDatabases(cfg *config.Config) (*config.Config, error) {
+	databases := map[string]string{}
+	database := func(profile string) (string, error) {
+		if db, ok := databases[profile]; ok {
+			return db, nil
+		}
+		connection, err := o.profileConnection(profile)
+		if err != nil {
+			return "", err
+		}
+		databases[profile] = connection.Database
+		return connection.Database, nil
+	}
+	resolved := *cfg
+	resolved.Retention = make(map[string]config.RetentionPolicyConfig, len(cfg.Retention))
+	for name, policy := range cfg.Retention {
+		if len(policy.Profile) > 0 && len(policy.Database) == 0 {
+			db, err := database(policy.Profile)
+			if err != nil {
+				return nil, fmt.Errorf("retention policy %s: %w", name, err)
+			}
+			policy.Database = db
+		}
+		resolved.Retention[name] = policy
+	}
+	resolved.Archives = make(map[string]config.ArchiveConfig, len(cfg.Archives))
+	for name, archive := range cfg.Archives {
+		if len(archive.Profile) > 0 && len(archive.Database) == 0 {
+			db, err := database(archive.Profile)
+			if err != nil {
+				return nil, fmt.Errorf("archive %s: %w", name, err)
+			}
+			archive.Database = db
+		}
+		resolved.Archives[name] = archive
+	}
+	resolved.Downsample = make(map[string]config.DownsampleConfig, len(cfg.Downsample))
+	for name, policy := range cfg.Downsample {
+		if len(policy.Profile) > 0 && len(policy.Database) == 0 {
+			db, err := database(policy.Profile)
+			if err != nil {
+				return nil, fmt.Errorf("downsampling policy %s: %w", name, err)
+			}
+			policy.Database = db
+		}
+		resolved.Downsample[name] = policy
+	}
+	return &resolved, nil
+}
+
+// connectDeployment returns a client of the deployment at uri or of the profile called profile, set
+// by the flags --<flag> and --<flag>-profile of which exactly one is required.
+func (o *options) connectDeployment(ctx context.Context, flag, uri, profile string) (*mongo.Client, error) {
+	switch {
+	case len(uri) > 0 && len(profile) > 0:
+		return nil, fmt.Errorf("--%s and --%s-profile can not be used together", flag, flag)
+	case len(profile) > 0:
+		c, _, err := o.connectProfile(ctx, profile)
+		if err != nil {
+			return nil, fmt.Errorf("--%s-profile: %w", flag, err)
+		}
+		return c, nil
+	case len(uri) > 0:
+		c, err := o.connectURI(ctx, uri, false)
+		if err != nil {
+			return nil, fmt.Errorf("--%s: %w", flag, err)
+		}
+		return c, nil
+	}
+	return nil, fmt.Errorf("one of --%s or --%s-profile is required", flag, flag)
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/client"
+	"github.com/spf13/cobra"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mongoOptions "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type countOptions struct {
+	Database   string
+	Collection string
+	Filter     string
+	MaxTime    time.Duration
+}
+
+// addFlags adds the flags selecting the collection and the output shared by the count commands.
+func (c *countOptions) addFlags(cmd *cobra.Command, o *options) {
+	flagset := cmd.Flags()
+	flagset.StringVar(&c.Database, "db", c.Database, "Database of the collection, defaults to MONGODB_DATABASE")
+	flagset.StringVar(&c.Collection, "collection", c.Collection, "Collection to count")
+	flagset.DurationVar(&c.MaxTime, "max-time", c.MaxTime, "Time limit for the command, also sent as maxTimeMS (0 uses the timeout of the reads)")
+	addJSONFlag(flagset, o)
+	cmd.MarkFlagRequired("collection")
+}
+
+// collection connects to the database and returns the collection of the options.
+func (c *countOptions) collection(o *options) (*client.ConnectionManager, *mongo.Collection, error) {
+	manager, err := o.connect()
+	if err != nil {
+		return nil, nil, err
+	}
+	database := c.Database
+	if len(database) == 0 {
+		database = manager.Database()
+	}
+	return manager, manager.Primary().Database(database).Collection(c.Collection), nil
+}
+
+// countResult is a count with its namespace and how it was obtained, as printed by --output.
+type countResult struct {
+	Database   string `json:"database"`
+	Collection string `json:"collection"`
+	Count      int64  `json:"count"`
+	Estimated  bool   `json:"estimated"`
+}
+
+// printCount prints count, bare by default and with its namespace in the format of --output.
+func (c *countOptions) printCount(out *printer, collection *mongo.Collection, count int64, estimated bool) error {
+	result := countResult{collection.Database().Name(), collection.Name(), count, estimated}
+	return out.print(os.Stdout, []countResult{result}, func(stdout io.Writer) error {
+		_, err := fmt.Fprintln(stdout, count)
+		return err
+	})
+}
+
+func newCountCommand(o *options) *cobra.Command {
+	c := &countOptions{}
+	var skip, limit int64
+	var hint string
+	var params []string
+	cmd := &cobra.Command{
+		Use:   "count",
+		Short: "Count the documents of a collection matching a filter",
+		Long: `Count the documents matching --filter, every document of the collection without it. The
+documents are counted by an aggregation, which reads the index or the documents matching the filter;
+estimated-count is much faster on large collections when the exact count is not needed.`,
+		Example: `  mongodb-client count --collection episodes --filter '{"duration":{"$gt":25}}'
+  mongodb-client count --collection episodes --filter '{"podcast":"weekly"}' --hint podcast_1 -o json`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			if skip < 0 || limit < 0 {
+				return fmt.Errorf("--limit and --skip must not be negative")
+			}
+			if err := renderTemplates(params, map[string]*string{"--filter": &c.Filter}); err != nil {
+				return err
+			}
+			filter, err := parseDocument(c.Filter)
+			if err != nil {
+				return fmt.Errorf("--filter: %w", err)
+			}
+			countDocuments := mongoOptions.Count()
+			if skip > 0 {
+				countDocuments.SetSkip(skip)
+			}
+			if limit > 0 {
+				countDocuments.SetLimit(limit)
+			}
+			if len(hint) > 0 {
+				countDocuments.SetHint(hint)
+			}
+
+			out, err := o.printer()
+			if err != nil {
+				return err
+			}
+			manager, collection, err := c.collection(o)
+			if err != nil {
+				return err
+			}
+			defer disconnect(manager)
+			// Counting reads every matching document, the server stops once the client gives up.
+			ctx, cancel := operationContext(manager, client.ReadOperation, c.MaxTime)
+			defer cancel()
+			countDocuments.MaxTime = client.MaxTime(ctx)
+			count, err := collection.CountDocuments(ctx, filter, countDocuments)
+			if err != nil {
+				return fmt.Errorf("unable to count the documents of %s.%s: %w", collection.Database().Name(), collection.Name(), err)
+			}
+			return c.printCount(out, collection, count, false)
+		},
+	}
+	c.addFlags(cmd, o)
+	flagset := cmd.Flags()
+	flagset.StringVar(&c.Filter, "filter", c.Filter, "Extended JSON query filter of the counted documents")
+	flagset.Int64Var(&skip, "skip", skip, "Number of matching documents to skip before counting")
+	flagset.Int64Var(&limit, "limit", limit, "Maximum count, counting stops once it is reached (0 means no limit)")
+	flagset.StringVar(&hint, "hint", hint, "Name of the index used to count the documents")
+	addParamFlag(flagset, &params)
+	return cmd
+}
+
+func newEstimatedCountCommand(o *options) *cobra.Command {
+	c := &countOptions{}
+	cmd := &cobra.Command{
+		Use:   "estimated-count",
+		Short: "Print the number of documents of a collection from its metadata",
+		Long: `Print the number of documents of the collection recorded in its metadata, without reading them.
+The count does not support filters, and may be inaccurate after an unclean shutdown or on sharded
+clusters with orphaned documents or migrations in progress.`,
+		Example: `  mongodb-client estimated-count --collection episodes`,
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			out, err := o.printer()
+			if err != nil {
+				return err
+			}
+			manager, collection, err := c.collection(o)
+			if err != nil {
+				return err
+			}
+			defer disconnect(manager)
+			ctx, cancel := operationContext(manager, client.ReadOperation, c.MaxTime)
+			defer cancel()
+			count, err := collection.EstimatedDocumentCount(ctx, &mongoOptions.EstimatedDocumentCountOptions{MaxTime: client.MaxTime(ctx)})
+			if err != nil {
+				return fmt.Errorf("unable to count the documents of %s.%s: %w", collection.Database().Name(), collection.Name(), err)
+			}
+			return c.printCount(out, collection, count, true)
+		},
+	}
+	c.addFlags(cmd, o)
+	return cmd
+}
+
+func newDistinctCommand(o *options) *cobra.Command {
+	c := &countOptions{}
+	cmd := &cobra.Command{
+		Use:   "distinct FIELD",
+		Short: "Print the distinct values of a field among the documents matching a filter",
+		Long: `Print the distinct values of FIELD among the documents matching --filter, one relaxed Extended
+JSON value per line. The elements of array values are distinct values of their own. With --output
+json a single JSON object holds the values and their number. The values must fit in a 16MB document.`,
+		Example: `  mongodb-client distinct podcast --collection episodes
+  mongodb-client distinct tags --collection episodes --filter '{"duration":{"$gt":25}}' -o json`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			field := arguments[0]
+			filter, err := parseDocument(c.Filter)
+			if err != nil {
+				return fmt.Errorf("--filter: %w", err)
+			}
+
+			out, err := o.printer()
+			if err != nil {
+				return err
+			}
+			manager, collection, err := c.collection(o)
+			if err != nil {
+				return err
+			}
+			defer disconnect(manager)
+			ctx, cancel := operationContext(manager, client.ReadOperation, c.MaxTime)
+			defer cancel()
+			values, err := collection.Distinct(ctx, field, filter, &mongoOptions.DistinctOptions{MaxTime: client.MaxTime(ctx)})
+			if err != nil {
+				return fmt.Errorf("unable to read the distinct values of %s of %s.%s: %w", field, collection.Database().Name(), collection.Name(), err)
+			}
+			result := bson.D{
+				{Key: "database", Value: collection.Database().Name()},
+				{Key: "collection", Value: collection.Name()},
+				{Key: "field", Value: field},
+				{Key: "count", Value: len(values)},
+				{Key: "values", Value: values},
+			}
+			return out.print(os.Stdout, []bson.D{result}, func(stdout io.Writer) error {
+				return printValues(stdout, values)
+			})
+		},
+	}
+	c.addFlags(cmd, o)
+	cmd.Flags().StringVar(&c.Filter, "filter", c.Filter, "Extended JSON query filter of the documents whose values are read")
+	return cmd
+}
+
+// printValues writes values to w, one relaxed Extended JSON value per line.
+func printValues(w io.Writer, values []interface{}) error {
+	for _, value := range values {
+		// Values are wrapped in a document, the Extended JSON encoder only encodes documents.
+		data, err := bson.MarshalExtJSON(bson.D{{Key: "v", Value: value}}, false, false)
+		if err != nil {
+			return err
+		}
+		var wrapped struct {
+			V json.RawMessage `json:"v"`
+		}
+		if err := json.Unmarshal(data, &wrapped); err != nil {
+			return err
+		}
+		fmt.Fprintln(w, string(wrapped.V))
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/client"
+)
+
+// debugHandlers registers the profiling endpoints at /debug/pprof/ and the runtime and connection
+// pool variables at /debug/vars, wrapped by wrap.
+func debugHandlers(mux *http.ServeMux, manager *client.ConnectionManager, stats *client.PoolStats, wrap func(http.Handler) http.Handler) {
+	expvar.Publish("mongodb_pool", expvar.Func(func() interface{} { return stats.Snapshot() }))
+	expvar.Publish("mongodb_connected", expvar.Func(func() interface{} { return manager.Connected() }))
+
+	mux.Handle("/debug/vars", wrap(expvar.Handler()))
+	mux.Handle("/debug/pprof/", wrap(http.HandlerFunc(pprof.Index)))
+	mux.Handle("/debug/pprof/cmdline", wrap(http.HandlerFunc(pprof.Cmdline)))
+	mux.Handle("/debug/pprof/profile", wrap(http.HandlerFunc(pprof.Profile)))
+	mux.Handle("/debug/pprof/symbol", wrap(http.HandlerFunc(pprof.Symbol)))
+	mux.Handle("/debug/pprof/trace", wrap(http.HandlerFunc(pprof.Trace)))
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/dedupe"
+	"github.com/spf13/cobra"
+)
+
+type dedupeOptions struct {
+	dedupe.Options
+	Database   string
+	Collection string
+	KeyExpr    string
+	FilterExpr string
+}
+
+func newDedupeCommand(o *options) *cobra.Command {
+	d := &dedupeOptions{
+		Options: dedupe.Options{Winner: dedupe.Newest, By: "_id", BatchSize: 1000},
+	}
+	cmd := &cobra.Command{
+		Use:   "dedupe",
+		Short: "Find the clusters of duplicate documents of a collection and optionally remove all but one of each",
+		Long: `Group the documents of a collection by a key, the values of --fields or the Extended JSON
+expression of --key, and print every cluster of documents sharing it with the document that is kept
+and the ones that would be removed.
+
+The kept document of a cluster is selected by --winner: newest or oldest by the --by field (the _id
+by default, whose ObjectIDs hold their creation time), or most-complete, the document with the most
+fields that are not null. Ties are broken by the lowest _id.
+
+With --remove the other documents of the clusters are deleted, in transactions of about
+--batch-size documents that never split a cluster and that are aborted when a kept document was
+deleted in the meantime. Transactions require a replica set or a sharded cluster.`,
+		Example: `  mongodb-client dedupe --collection podcasts --fields title,author
+  mongodb-client dedupe --collection users --key '{"email":{"$toLower":"$email"}}' --winner oldest --by createdAt
+  mongodb-client dedupe --collection episodes --fields podcast,title --winner most-complete --remove`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			return d.run(o)
+		},
+	}
+
+	flagset := cmd.Flags()
+	flagset.StringVar(&d.Database, "db", d.Database, "Database of the collection, defaults to MONGODB_DATABASE")
+	flagset.StringVar(&d.Collection, "collection", d.Collection, "Collection to deduplicate")
+	flagset.StringSliceVar(&d.Fields, "fields", d.Fields, "Comma separated fields of the key, the documents missing any of them are ignored")
+	flagset.StringVar(&d.KeyExpr, "key", d.KeyExpr, "Extended JSON expression of the key instead of --fields, such as '{\"email\":{\"$toLower\":\"$email\"}}'")
+	flagset.StringVar(&d.FilterExpr, "filter", d.FilterExpr, "Extended JSON query filter of the documents to group")
+	flagset.StringVar(&d.Winner, "winner", d.Winner, "Document of a cluster that is kept: newest, oldest or most-complete")
+	flagset.StringVar(&d.By, "by", d.By, "Field ordering the documents for the newest and oldest winners")
+	flagset.BoolVar(&d.Remove, "remove", d.Remove, "Delete the documents of the clusters but their winners")
+	flagset.IntVar(&d.BatchSize, "batch-size", d.BatchSize, "Number of documents deleted per transaction")
+	addJSONFlag(flagset, o)
+	cmd.MarkFlagRequired("collection")
+	return cmd
+}
+
+// dedupeCluster is a cluster as printed by --output.
+type dedupeCluster struct {
+	Key    json.RawMessage   `json:"key"`
+	Winner json.RawMessage   `json:"winner"`
+	Losers []json.RawMessage `json:"losers"`
+}
+
+func (d *dedupeOptions) run(o *options) error {
+	if (len(d.Fields) == 0) == (len(d.KeyExpr) == 0) {
+		return fmt.Errorf("exactly one of --fields or --key is required")
+	}
+	if d.BatchSize < 1 {
+		return fmt.Errorf("--batch-size must be at least 1")
+	}
+	switch d.Winner {
+	case dedupe.Newest, dedupe.Oldest, dedupe.MostComplete:
+	default:
+		return fmt.Errorf("--winner must be one of %s, %s or %s", dedupe.Newest, dedupe.Oldest, dedupe.MostComplete)
+	}
+	if len(d.KeyExpr) > 0 {
+		key, err := parseValue(d.KeyExpr)
+		if err != nil {
+			return fmt.Errorf("--key: %w", err)
+		}
+		d.Key = key
+	}
+	filter, err := parseDocument(d.FilterExpr)
+	if err != nil {
+		return fmt.Errorf("--filter: %w", err)
+	}
+	d.Filter = filter
+	d.Retry = o.Retry
+
+	out, err := o.printer()
+	if err != nil {
+		return err
+	}
+	manager, err := o.connect()
+	if err != nil {
+		return err
+	}
+	defer disconnect(manager)
+
+	database := d.Database
+	if len(database) == 0 {
+		database = manager.Database()
+	}
+	ctx, cancel := cmdContext()
+	defer cancel()
+	result, err := dedupe.Run(ctx, manager.Primary().Database(database).Collection(d.Collection), d.Options, func(c dedupe.Cluster) error {
+		cluster := dedupeCluster{Key: json.RawMessage(extJSON(c.Key)), Winner: json.RawMessage(extJSON(c.Winner))}
+		for _, id := range c.Losers {
+			cluster.Losers = append(cluster.Losers, json.RawMessage(extJSON(id)))
+		}
+		return out.print(os.Stdout, []dedupeCluster{cluster}, func(stdout io.Writer) error {
+			_, err := fmt.Fprintf(stdout, "%s: %d documents, keep %s, remove %s\n", extJSON(c.Key), len(c.Losers)+1, extJSON(c.Winner), extJSONValues(c.Losers))
+			return err
+		})
+	})
+	fmt.Fprintf(os.Stderr, "%d clusters of duplicates in %s.%s, %d documents to remove, %d removed\n", result.Clusters, database, d.Collection, result.Duplicates, result.Removed)
+	return err
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/bulk"
+	"github.com/bradmwilliams/mongodb-client/pkg/client"
+	"github.com/bradmwilliams/mongodb-client/pkg/compare"
+	"github.com/spf13/cobra"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type diffOptions struct {
+	compare.Options
+	From         string
+	To           string
+	FromProfile  string
+	ToProfile    string
+	Database     string
+	Collection   string
+	ToDatabase   string
+	ToCollection string
+	Filter       string
+}
+
+func newDiffCommand(o *options) *cobra.Command {
+	d := &diffOptions{
+		Options: compare.Options{BatchSize: 1000},
+	}
+	cmd := &cobra.Command{
+		Use:   "diff",
+		Short: "Compare two collections by _id and content, optionally repairing the target",
+		Long: `Compare the documents of a source and a target collection, which may belong to the same or to
+different deployments, and print those that are missing from the target, extra in the target or
+mismatched between them.
+
+Documents are matched by _id and compared by a hash of their BSON encoding, so the same fields in a
+different order are a mismatch. --fields lists the fields that differ. --repair makes the target
+match the source by inserting, replacing and deleting documents.
+
+The deployments are reached at --from and --to, or with the profiles of the config file of
+--from-profile and --to-profile, the configured connection is used for each of them when neither is
+set. The database of a profile is the default of --db, and of --to-db for --to-profile. The command fails when differences are found and not repaired.`,
+		Example: `  mongodb-client diff --collection episodes --to-db sampledb_copy --fields
+  mongodb-client diff --from "$SOURCE_URI" --to "$TARGET_URI" --db sampledb --collection episodes --repair
+  mongodb-client diff --config config.yaml --from-profile production --to-profile staging --collection episodes`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			return d.run(o)
+		},
+	}
+
+	flagset := cmd.Flags()
+	flagset.StringVar(&d.From, "from", d.From, "URI of the deployment of the source collection, defaults to the configured connection")
+	flagset.StringVar(&d.To, "to", d.To, "URI of the deployment of the target collection, defaults to the configured connection")
+	flagset.StringVar(&d.FromProfile, "from-profile", d.FromProfile, "Profile of the config file of the deployment of the source collection, instead of --from")
+	flagset.StringVar(&d.ToProfile, "to-profile", d.ToProfile, "Profile of the config file of the deployment of the target collection, instead of --to")
+	flagset.StringVar(&d.Database, "db", d.Database, "Database of the source collection, defaults to MONGODB_DATABASE")
+	flagset.StringVar(&d.Collection, "collection", d.Collection, "Source collection")
+	flagset.StringVar(&d.ToDatabase, "to-db", d.ToDatabase, "Database of the target collection, defaults to --db")
+	flagset.StringVar(&d.ToCollection, "to-collection", d.ToCollection, "Target collection, defaults to --collection")
+	flagset.StringVar(&d.Filter, "filter", d.Filter, "Extended JSON query filter restricting the compared documents of both collections")
+	flagset.IntVar(&d.BatchSize, "batch-size", d.BatchSize, "Number of documents looked up per request")
+	flagset.BoolVar(&d.Fields, "fields", d.Fields, "List the fields that differ between mismatched documents")
+	flagset.BoolVar(&d.Repair, "repair", d.Repair, "Make the target match the source")
+	addJSONFlag(flagset, o)
+	cmd.MarkFlagRequired("collection")
+	return cmd
+}
+
+// difference is a difference as printed by --output.
+type difference struct {
+	Kind   string            `json:"kind"`
+	ID     json.RawMessage   `json:"_id"`
+	Fields []differenceField `json:"fields,omitempty"`
+}
+
+type differenceField struct {
+	Path   string          `json:"path"`
+	Source json.RawMessage `json:"source,omitempty"`
+	Target json.RawMessage `json:"target,omitempty"`
+}
+
+func (d *diffOptions) run(o *options) error {
+	if d.BatchSize < 1 {
+		return fmt.Errorf("--batch-size must be at least 1")
+	}
+	filter, err := parseDocument(d.Filter)
+	if err != nil {
+		return fmt.Errorf("--filter: %w", err)
+	}
+	d.Options.Filter = filter
+	if err := o.validate(); err != nil {
+		return err
+	}
+
+	if len(d.From) > 0 && len(d.FromProfile) > 0 {
+		return fmt.Errorf("--from and --from-profile can not be used together")
+	}
+	if len(d.To) > 0 && len(d.ToProfile) > 0 {
+		return fmt.Errorf("--to and --to-profile can not be used together")
+	}
+
+	out, err := o.printer()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := cmdContext()
+	defer cancel()
+	var manager *client.ConnectionManager
+	if (len(d.From) == 0 && len(d.FromProfile) == 0) || (len(d.To) == 0 && len(d.ToProfile) == 0) || (len(d.Database) == 0 && len(d.FromProfile) == 0) {
+		m, err := o.connect()
+		if err != nil {
+			return err
+		}
+		defer disconnect(m)
+		manager = m
+	}
+	// clientOf returns the client of a deployment and the database of its profile, it is
+	// disconnected by the caller unless it is the client of the configured connection.
+	clientOf := func(uri, profile string) (*mongo.Client, string, error) {
+		switch {
+		case len(profile) > 0:
+			return o.connectProfile(ctx, profile)
+		case len(uri) > 0:
+			c, err := o.connectURI(ctx, uri, false)
+			return c, "", err
+		}
+		return manager.Primary(), manager.Database(), nil
+	}
+	source, sourceDatabase, err := clientOf(d.From, d.FromProfile)
+	if err != nil {
+		return fmt.Errorf("--from: %w", err)
+	}
+	if len(d.From) > 0 || len(d.FromProfile) > 0 {
+		defer disconnectClient(source)
+	}
+	target, targetDatabase, err := clientOf(d.To, d.ToProfile)
+	if err != nil {
+		return fmt.Errorf("--to: %w", err)
+	}
+	if len(d.To) > 0 || len(d.ToProfile) > 0 {
+		defer disconnectClient(target)
+	}
+
+	database := d.Database
+	if len(database) == 0 {
+		database = sourceDatabase
+	}
+	toDatabase, toCollection := d.ToDatabase, d.ToCollection
+	if len(toDatabase) == 0 {
+		toDatabase = database
+		if len(d.ToProfile) > 0 {
+			toDatabase = targetDatabase
+		}
+	}
+	if len(toCollection) == 0 {
+		toCollection = d.Collection
+	}
+	if d.From == d.To && d.FromProfile == d.ToProfile && database == toDatabase && d.Collection == toCollection {
+		return fmt.Errorf("the source and target collections are the same, set --to, --to-db or --to-collection")
+	}
+
+	result, err := compare.Compare(ctx, source.Database(database).Collection(d.Collection), target.Database(toDatabase).Collection(toCollection), d.Options, func(diff compare.Difference) error {
+		return out.print(os.Stdout, []difference{jsonDifference(diff)}, func(stdout io.Writer) error {
+			fmt.Fprintf(stdout, "%-10s %s\n", diff.Kind, extJSON(diff.ID))
+			for _, field := range diff.Fields {
+				fmt.Fprintf(stdout, "  %s: %s -> %s\n", field.Path, extJSON(field.Source), extJSON(field.Target))
+			}
+			return nil
+		})
+	})
+	fmt.Fprintf(os.Stderr, "%d documents compared: %d missing, %d extra, %d mismatched\n", result.Compared, result.Missing, result.Extra, result.Mismatched)
+	if err != nil {
+		return err
+	}
+	if d.Repair {
+		fmt.Fprintf(os.Stderr, "%d differences repaired, %d repairs failed\n", result.Repaired, len(result.RepairFailures))
+		if len(result.RepairFailures) > 0 {
+			return fmt.Errorf("unable to repair %s.%s: %w", toDatabase, toCollection, &bulk.Error{Failures: result.RepairFailures})
+		}
+		return nil
+	}
+	if result.Differences() > 0 {
+		return fmt.Errorf("the collections differ")
+	}
+	return nil
+}
+
+// extJSON returns value as relaxed Extended JSON, or "(absent)" when it has no type.
+func extJSON(value bson.RawValue) string {
+	if value.Type == 0 {
+		return "(absent)"
+	}
+	data, err := bson.MarshalExtJSON(bson.D{{Key: "v", Value: value}}, false, false)
+	if err != nil {
+		return value.String()
+	}
+	// Only the value of the wrapping document is kept: {"v":<value>}.
+	return string(data[5 : len(data)-1])
+}
+
+func jsonDifference(diff compare.Difference) difference {
+	out := difference{Kind: diff.Kind, ID: json.RawMessage(extJSON(diff.ID))}
+	for _, field := range diff.Fields {
+		f := differenceField{Path: field.Path}
+		if field.Source.Type != 0 {
+			f.Source = json.RawMessage(extJSON(field.Source))
+		}
+		if field.Target.Type != 0 {
+			f.Target = json.RawMessage(extJSON(field.Target))
+		}
+		out.Fields = append(out.Fields, f)
+	}
+	return out
+}
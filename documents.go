@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// parseDocument parses an Extended JSON document, an empty string is an empty document. Plain
+// numbers are parsed without losing precision, see preciseNumbers.
+func parseDocument(value string) (bson.D, error) {
+	doc := bson.D{}
+	if len(strings.TrimSpace(value)) == 0 {
+		return doc, nil
+	}
+	text, err := ejson.preciseNumbers(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON document %q: %w", value, err)
+	}
+	if err := bson.UnmarshalExtJSON([]byte(text), false, &doc); err != nil {
+		return nil, fmt.Errorf("invalid JSON document %q: %w", value, err)
+	}
+	return doc, nil
+}
+
+// parseValue parses an Extended JSON value, such as an _id.
+func parseValue(value string) (interface{}, error) {
+	wrapped := bson.D{}
+	text, err := ejson.preciseNumbers(`{"v":` + value + `}`)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Extended JSON value %q: %w", value, err)
+	}
+	if err := bson.UnmarshalExtJSON([]byte(text), false, &wrapped); err != nil {
+		return nil, fmt.Errorf("invalid Extended JSON value %q: %w", value, err)
+	}
+	return wrapped[0].Value, nil
+}
+
+// splitDocuments splits a string holding several consecutive JSON values, such as the
+// filter and update of an update command, into their raw text.
+func splitDocuments(value string) ([]string, error) {
+	var documents []string
+	decoder := json.NewDecoder(strings.NewReader(value))
+	for {
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err == io.EOF {
+			return documents, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("invalid JSON in %q: %w", value, err)
+		}
+		documents = append(documents, string(raw))
+	}
+}
+
+// ejsonModes are the values of --ejson.
+var ejsonModes = []string{"relaxed", "canonical"}
+
+// ejsonOptions is the Extended JSON the commands print documents as.
+type ejsonOptions struct {
+	// Mode is canonical, which keeps the type of every value, such as the int32, int64 and double
+	// numbers, or relaxed, which writes numbers and recent dates as plain JSON values.
+	Mode string
+	// Indent is the number of spaces printed documents are indented with, zero prints them on a
+	// single line. The NDJSON output is never indented.
+	Indent int
+	// StrictNumbers rejects the plain numbers of the documents parsed that a double would round,
+	// and prints the longs beyond 2^53 as {"$numberLong": "..."} in relaxed mode, so that the
+	// parsers reading numbers as doubles do not round them either.
+	StrictNumbers bool
+}
+
+// ejson is set by the --ejson and --indent flags.
+var ejson = ejsonOptions{Mode: "relaxed", Indent: 2}
+
+func (e ejsonOptions) validate() error {
+	if e.Mode != "relaxed" && e.Mode != "canonical" {
+		return fmt.Errorf("--ejson must be one of %s", strings.Join(ejsonModes, ", "))
+	}
+	if e.Indent < 0 {
+		return fmt.Errorf("--indent must not be negative")
+	}
+	return nil
+}
+
+// marshal returns doc as Extended JSON, indented when indent is true.
+func (e ejsonOptions) marshal(doc interface{}, indent bool) ([]byte, error) {
+	data, err := bson.MarshalExtJSON(doc, e.Mode == "canonical", false)
+	if err == nil && e.StrictNumbers && e.Mode == "relaxed" {
+		data = safeLongs(data)
+	}
+	if err != nil || !indent || e.Indent == 0 {
+		return data, err
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, data, "", strings.Repeat(" ", e.Indent)); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// marshalValue returns value, which may not be a document, as Extended JSON.
+func (e ejsonOptions) marshalValue(value interface{}) ([]byte, error) {
+	data, err := bson.MarshalExtJSON(bson.D{{Key: "v", Value: value}}, e.Mode == "canonical", false)
+	if err != nil {
+		return nil, err
+	}
+	if e.StrictNumbers && e.Mode == "relaxed" {
+		data = safeLongs(data)
+	}
+	// The value is unwrapped from {"v":...}.
+	return data[5 : len(data)-1], nil
+}
+
+// printDocument writes doc to w as indented Extended JSON.
+func printDocument(w io.Writer, doc interface{}) error {
+	data, err := ejson.marshal(doc, true)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// parsePipeline parses a JSON array of Extended JSON aggregation stages.
+func parsePipeline(value string) ([]bson.D, error) {
+	var stages []json.RawMessage
+	if err := json.Unmarshal([]byte(value), &stages); err != nil {
+		return nil, fmt.Errorf("pipeline must be a JSON array of stages: %w", err)
+	}
+	pipeline := make([]bson.D, 0, len(stages))
+	for i, stage := range stages {
+		doc, err := parseDocument(string(stage))
+		if err != nil {
+			return nil, fmt.Errorf("stage %d: %w", i, err)
+		}
+		pipeline = append(pipeline, doc)
+	}
+	return pipeline, nil
+}
+
+// writeLine writes doc to w as a single line of Extended JSON, the NDJSON format.
+func writeLine(w io.Writer, doc interface{}) error {
+	data, err := ejson.marshal(doc, false)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	_, err = w.Write(data)
+	return err
+}
+
+// documentReader streams Extended JSON documents from either a JSON array or NDJSON input.
+type documentReader struct {
+	reader  *bufio.Reader
+	decoder *json.Decoder
+	array   bool
+}
+
+func newDocumentReader(r io.Reader) *documentReader {
+	return &documentReader{reader: bufio.NewReader(r)}
+}
+
+// Next returns the next document, or io.EOF once the input is exhausted.
+func (d *documentReader) Next() (bson.D, error) {
+	if d.decoder == nil {
+		array, err := startsWithArray(d.reader)
+		if err != nil {
+			return nil, err
+		}
+		d.decoder = json.NewDecoder(d.reader)
+		if array {
+			// Consume the opening bracket so that the elements can be decoded one at a time.
+			if _, err := d.decoder.Token(); err != nil {
+				return nil, err
+			}
+		}
+		d.array = array
+	}
+
+	if d.array && !d.decoder.More() {
+		if _, err := d.decoder.Token(); err != nil {
+			return nil, fmt.Errorf("invalid JSON array: %w", err)
+		}
+		return nil, io.EOF
+	}
+	var raw json.RawMessage
+	if err := d.decoder.Decode(&raw); err != nil {
+		if err == io.EOF && !d.array {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("invalid JSON input: %w", err)
+	}
+	return parseDocument(string(raw))
+}
+
+// startsWithArray skips leading whitespace and reports whether the input is a JSON array.
+func startsWithArray(r *bufio.Reader) (bool, error) {
+	for {
+		b, err := r.Peek(1)
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		switch b[0] {
+		case ' ', '\t', '\r', '\n':
+			r.ReadByte()
+			continue
+		}
+		return b[0] == '[', nil
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/archive"
+	"github.com/bradmwilliams/mongodb-client/pkg/objectstore"
+	"github.com/spf13/cobra"
+)
+
+type dumpOptions struct {
+	Databases  []string
+	Collection string
+	Archive    string
+	Gzip       bool
+	Oplog      bool
+	Mask       string
+	Storage    objectstore.Options
+}
+
+func newDumpCommand(o *options) *cobra.Command {
+	d := &dumpOptions{
+		Gzip: true,
+	}
+	cmd := &cobra.Command{
+		Use:   "dump",
+		Short: "Write databases to a mongodump compatible BSON archive",
+		Long: `Write databases with their collection options and indexes to a BSON archive.
+
+The archive uses the format of mongodump --archive and can be restored with either the restore
+command or mongorestore --archive (add --gzip for compressed archives).`,
+		Example: `  mongodb-client dump --db sampledb --archive sampledb.archive.gz
+  mongodb-client dump --db sampledb --collection episodes --gzip=false > episodes.archive
+  mongodb-client dump --archive s3://backups/sampledb.archive.gz --sse aws:kms`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			return d.run(o)
+		},
+	}
+
+	flagset := cmd.Flags()
+	flagset.StringSliceVar(&d.Databases, "db", d.Databases, "Databases to dump, defaults to all databases except admin, config and local")
+	flagset.StringVar(&d.Collection, "collection", d.Collection, "Dump only this collection of each database")
+	flagset.StringVar(&d.Archive, "archive", d.Archive, "File or s3://, gs:// or azblob:// URL to write the archive to, defaults to standard output")
+	flagset.BoolVar(&d.Gzip, "gzip", d.Gzip, "Compress the archive with gzip")
+	flagset.BoolVar(&d.Oplog, "oplog", d.Oplog, "Include the oplog entries written during the dump, restore them with restore --oplog-replay for a consistent snapshot (requires a replica set)")
+	addMaskFlag(flagset, &d.Mask)
+	addStorageFlags(flagset, &d.Storage)
+	return cmd
+}
+
+func (d *dumpOptions) run(o *options) error {
+	if len(d.Collection) > 0 && len(d.Databases) == 0 {
+		return fmt.Errorf("--collection requires --db")
+	}
+	if d.Oplog && len(d.Mask) > 0 {
+		return fmt.Errorf("--mask cannot be combined with --oplog, the oplog entries are not masked")
+	}
+	transform, err := maskTransform(d.Mask)
+	if err != nil {
+		return err
+	}
+
+	manager, err := o.connect()
+	if err != nil {
+		return err
+	}
+	defer disconnect(manager)
+
+	ctx, cancel := cmdContext()
+	defer cancel()
+
+	out, err := openOutput(ctx, d.Archive, d.Storage)
+	if err != nil {
+		return err
+	}
+	// A failed dump must not leave a partial archive behind.
+	defer out.Abort()
+	buffered := bufio.NewWriter(out)
+	w := io.Writer(buffered)
+	var compressed *gzip.Writer
+	if d.Gzip {
+		compressed = gzip.NewWriter(buffered)
+		w = compressed
+	}
+	results, err := archive.Dump(ctx, manager.Primary(), w, archive.DumpOptions{Databases: d.Databases, Collection: d.Collection, Oplog: d.Oplog, Transform: transform})
+	if err != nil {
+		return err
+	}
+	if compressed != nil {
+		if err := compressed.Close(); err != nil {
+			return err
+		}
+	}
+	if err := buffered.Flush(); err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	for _, result := range results {
+		fmt.Fprintf(os.Stderr, "%s: %d documents\n", result.Namespace, result.Documents)
+	}
+	return nil
+}
@@ -0,0 +1,578 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/client"
+	"github.com/bradmwilliams/mongodb-client/pkg/config"
+	"github.com/bradmwilliams/mongodb-client/pkg/encryption"
+	"github.com/spf13/cobra"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// localMasterKeySize is the size of the master keys of the local KMS provider.
+const localMasterKeySize = 96
+
+// encryptionConfig returns the client-side encryption of the config file, reading the local master
+// key and the credentials of the providers that are not in the file.
+func encryptionConfig(cfg *config.EncryptionConfig) (*encryption.Config, error) {
+	c := &encryption.Config{
+		KeyVault:             cfg.KeyVault,
+		Providers:            make(map[string]map[string]interface{}),
+		MasterKeys:           make(map[string]interface{}),
+		Fields:               make(map[string][]encryption.Field),
+		Queryable:            make(map[string][]encryption.QueryableField),
+		BypassAutoEncryption: cfg.BypassAutoEncryption,
+	}
+
+	if local := cfg.KMS.Local; local != nil {
+		key, err := localMasterKey(local.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("kms.local: %w", err)
+		}
+		c.Providers[encryption.Local] = map[string]interface{}{"key": key}
+	}
+	if aws := cfg.KMS.AWS; aws != nil {
+		provider := map[string]interface{}{
+			"accessKeyId":     valueOrEnv(aws.AccessKeyID, "AWS_ACCESS_KEY_ID"),
+			"secretAccessKey": valueOrEnv(aws.SecretAccessKey, "AWS_SECRET_ACCESS_KEY"),
+		}
+		if token := valueOrEnv(aws.SessionToken, "AWS_SESSION_TOKEN"); len(token) > 0 {
+			provider["sessionToken"] = token
+		}
+		if len(provider["accessKeyId"].(string)) == 0 || len(provider["secretAccessKey"].(string)) == 0 {
+			return nil, fmt.Errorf("kms.aws: accessKeyId and secretAccessKey, or AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, are required")
+		}
+		c.Providers[encryption.AWS] = provider
+		if len(aws.Key) > 0 {
+			c.MasterKeys[encryption.AWS] = bson.D{{Key: "region", Value: aws.Region}, {Key: "key", Value: aws.Key}}
+		}
+	}
+	if gcp := cfg.KMS.GCP; gcp != nil {
+		provider, err := gcpProvider(valueOrEnv(gcp.CredentialsFile, "GOOGLE_APPLICATION_CREDENTIALS"))
+		if err != nil {
+			return nil, fmt.Errorf("kms.gcp: %w", err)
+		}
+		if len(gcp.Endpoint) > 0 {
+			provider["endpoint"] = gcp.Endpoint
+		}
+		c.Providers[encryption.GCP] = provider
+		if len(gcp.KeyName) > 0 {
+			c.MasterKeys[encryption.GCP] = bson.D{
+				{Key: "projectId", Value: gcp.ProjectID},
+				{Key: "location", Value: gcp.Location},
+				{Key: "keyRing", Value: gcp.KeyRing},
+				{Key: "keyName", Value: gcp.KeyName},
+			}
+		}
+	}
+	if azure := cfg.KMS.Azure; azure != nil {
+		provider := map[string]interface{}{
+			"tenantId":     valueOrEnv(azure.TenantID, "AZURE_TENANT_ID"),
+			"clientId":     valueOrEnv(azure.ClientID, "AZURE_CLIENT_ID"),
+			"clientSecret": valueOrEnv(azure.ClientSecret, "AZURE_CLIENT_SECRET"),
+		}
+		for name, value := range provider {
+			if len(value.(string)) == 0 {
+				return nil, fmt.Errorf("kms.azure: %s is required", name)
+			}
+		}
+		c.Providers[encryption.Azure] = provider
+		if len(azure.KeyName) > 0 {
+			c.MasterKeys[encryption.Azure] = bson.D{{Key: "keyVaultEndpoint", Value: azure.KeyVaultEndpoint}, {Key: "keyName", Value: azure.KeyName}}
+		}
+	}
+
+	for namespace, fields := range cfg.Fields {
+		paths := make([]string, 0, len(fields))
+		for path := range fields {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		for _, path := range paths {
+			field := fields[path]
+			algorithm := encryption.Random
+			switch field.Algorithm {
+			case "", "random":
+			case "deterministic":
+				algorithm = encryption.Deterministic
+			default:
+				return nil, fmt.Errorf("%s: field %s has an unsupported algorithm %q, must be deterministic or random", namespace, path, field.Algorithm)
+			}
+			c.Fields[namespace] = append(c.Fields[namespace], encryption.Field{
+				Path:       path,
+				KeyAltName: field.KeyAltName,
+				Algorithm:  algorithm,
+				BSONType:   field.BSONType,
+			})
+		}
+	}
+
+	for namespace, fields := range cfg.Queryable {
+		paths := make([]string, 0, len(fields))
+		for path := range fields {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		for _, path := range paths {
+			field := fields[path]
+			c.Queryable[namespace] = append(c.Queryable[namespace], encryption.QueryableField{
+				Path:       path,
+				KeyAltName: field.KeyAltName,
+				BSONType:   field.BSONType,
+				Query:      field.Query,
+				Contention: field.Contention,
+			})
+		}
+	}
+
+	if mongocryptd := cfg.Mongocryptd; mongocryptd != nil {
+		c.ExtraOptions = make(map[string]interface{})
+		if len(mongocryptd.URI) > 0 {
+			c.ExtraOptions["mongocryptdURI"] = mongocryptd.URI
+		}
+		if mongocryptd.BypassSpawn {
+			c.ExtraOptions["mongocryptdBypassSpawn"] = true
+		}
+		if len(mongocryptd.SpawnPath) > 0 {
+			c.ExtraOptions["mongocryptdSpawnPath"] = mongocryptd.SpawnPath
+		}
+		if len(mongocryptd.SpawnArgs) > 0 {
+			c.ExtraOptions["mongocryptdSpawnArgs"] = mongocryptd.SpawnArgs
+		}
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// valueOrEnv returns value, or the environment variable env when value is empty.
+func valueOrEnv(value, env string) string {
+	if len(value) > 0 {
+		return value
+	}
+	return os.Getenv(env)
+}
+
+// localMasterKey reads a master key of the local provider, raw or base64 encoded.
+func localMasterKey(path string) ([]byte, error) {
+	if len(path) == 0 {
+		return nil, fmt.Errorf("keyFile is required")
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the master key: %w", err)
+	}
+	if len(data) == localMasterKeySize {
+		return data, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != localMasterKeySize {
+		return nil, fmt.Errorf("%s must hold %d bytes, raw or base64 encoded", path, localMasterKeySize)
+	}
+	return key, nil
+}
+
+// gcpProvider returns the credentials of the GCP provider from the JSON key of a service account.
+func gcpProvider(path string) (map[string]interface{}, error) {
+	if len(path) == 0 {
+		return nil, fmt.Errorf("credentialsFile or GOOGLE_APPLICATION_CREDENTIALS is required")
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the credentials: %w", err)
+	}
+	var credentials struct {
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+	}
+	if err := json.Unmarshal(data, &credentials); err != nil {
+		return nil, fmt.Errorf("%s is not the JSON key of a service account: %w", path, err)
+	}
+	block, _ := pem.Decode([]byte(credentials.PrivateKey))
+	if len(credentials.ClientEmail) == 0 || block == nil {
+		return nil, fmt.Errorf("%s has no client_email or no PEM encoded private_key", path)
+	}
+	// The driver expects the DER encoded PKCS#8 key.
+	return map[string]interface{}{"email": credentials.ClientEmail, "privateKey": block.Bytes}, nil
+}
+
+func newEncryptionCommand(o *options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "encryption",
+		Short: "Manage the data keys of client-side field level encryption",
+		Long: `The encryption section of --config encrypts fields of the documents in the client, before they
+are sent to the server, for every connection of the client: the background jobs, the listen address
+and the subcommands. The fields are encrypted with data keys, stored in the key vault collection and
+themselves encrypted by the master key of a KMS provider:
+
+  encryption:
+    keyVault: encryption.__keyVault
+    kms:
+      aws:
+        region: us-east-1
+        key: arn:aws:kms:us-east-1:123456789012:key/0a1b2c3d-4e5f-6071-8293-a4b5c6d7e8f9
+    fields:
+      sampledb.users:
+        ssn: {keyAltName: users, algorithm: deterministic, bsonType: string}
+        contact.phone: {keyAltName: users}
+
+The credentials of the providers default to the environment variables of their SDKs. Deterministic
+fields can be queried by equality, random ones can only be read. The data keys must exist before
+the client connects with the fields of their keyAltName.
+
+The collections of the queryable section use Queryable Encryption instead, on MongoDB 7.0 and later:
+their encrypted fields are options of the collections, created by create-collection and checked by
+verify.
+
+  encryption:
+    queryable:
+      sampledb.patients:
+        ssn: {keyAltName: patients-ssn, bsonType: string, query: equality}
+        billing: {keyAltName: patients-billing, bsonType: object}
+
+Encryption requires a client built with the cse tag (make build-cse) and libmongocrypt, and
+mongocryptd, which is spawned from the PATH unless encryption.mongocryptd says otherwise.`,
+		Example: `  mongodb-client encryption create-key users --kms aws --config config.yaml
+  mongodb-client encryption list-keys --config config.yaml
+  mongodb-client encryption schema --config config.yaml
+  mongodb-client encryption create-collection sampledb.patients --kms aws --config config.yaml`,
+	}
+	cmd.AddCommand(newEncryptionCreateKeyCommand(o))
+	cmd.AddCommand(newEncryptionListKeysCommand(o))
+	cmd.AddCommand(newEncryptionDeleteKeyCommand(o))
+	cmd.AddCommand(newEncryptionSchemaCommand(o))
+	cmd.AddCommand(newEncryptionCreateCollectionCommand(o))
+	cmd.AddCommand(newEncryptionVerifyCommand(o))
+	return cmd
+}
+
+// connectKeyVault returns the encryption of the config file and a manager whose clients are not
+// encrypted, so that the key vault can be managed before the data keys of the fields exist.
+func (o *options) connectKeyVault() (*client.ConnectionManager, *encryption.Config, error) {
+	if err := o.validate(); err != nil {
+		return nil, nil, err
+	}
+	cfg, err := o.loadConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	if cfg.Encryption == nil {
+		return nil, nil, fmt.Errorf("--config has no encryption section")
+	}
+	connection, err := o.connectionConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	cse, err := encryptionConfig(cfg.Encryption)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid encryption configuration: %w", err)
+	}
+	connection.AutoEncryption = nil
+	manager := client.NewConnectionManager(connection)
+	ctx, cancel := manager.Context()
+	defer cancel()
+	if err := manager.Connect(ctx); err != nil {
+		manager.Disconnect(ctx)
+		return nil, nil, err
+	}
+	return manager, cse, nil
+}
+
+type encryptionCreateKeyOptions struct {
+	KMS string
+}
+
+func newEncryptionCreateKeyCommand(o *options) *cobra.Command {
+	c := &encryptionCreateKeyOptions{}
+	cmd := &cobra.Command{
+		Use:   "create-key NAME",
+		Short: "Create a data key in the key vault, called NAME by the keyAltName of the fields",
+		Long: `Create a data key encrypted by the master key of a KMS provider of the config file and store it in
+the key vault, creating its unique index on the keyAltNames first.`,
+		Example: `  mongodb-client encryption create-key users --kms aws --config config.yaml`,
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			return c.run(o, arguments[0])
+		},
+	}
+	cmd.Flags().StringVar(&c.KMS, "kms", c.KMS, "KMS provider of the master key: local, aws, gcp or azure, defaults to the only provider of the config file")
+	return cmd
+}
+
+func (c *encryptionCreateKeyOptions) run(o *options, name string) error {
+	manager, cse, err := o.connectKeyVault()
+	if err != nil {
+		return err
+	}
+	defer disconnect(manager)
+	provider, err := kmsProvider(cse, c.KMS)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := manager.Context()
+	defer cancel()
+	id, err := cse.CreateKey(ctx, manager.Admin(), provider, name)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stdout, "%s %s\n", name, keyID(id))
+	return nil
+}
+
+// kmsProvider returns the provider of --kms, or the only provider of the config file when it is
+// empty.
+func kmsProvider(cse *encryption.Config, provider string) (string, error) {
+	if len(provider) > 0 {
+		return provider, nil
+	}
+	if len(cse.Providers) != 1 {
+		return "", fmt.Errorf("--kms is required when the config file has several KMS providers")
+	}
+	for configured := range cse.Providers {
+		provider = configured
+	}
+	return provider, nil
+}
+
+type encryptionListKeysOptions struct{}
+
+// encryptionKey is a data key as printed by --output.
+type encryptionKey struct {
+	ID       string    `json:"id"`
+	AltNames []string  `json:"keyAltNames"`
+	Provider string    `json:"provider"`
+	Created  time.Time `json:"created"`
+	Fields   []string  `json:"fields"`
+}
+
+func newEncryptionListKeysCommand(o *options) *cobra.Command {
+	l := &encryptionListKeysOptions{}
+	cmd := &cobra.Command{
+		Use:     "list-keys",
+		Short:   "List the data keys of the key vault and the fields of the config file they encrypt",
+		Example: `  mongodb-client encryption list-keys --config config.yaml -o json`,
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			return l.run(o)
+		},
+	}
+	addJSONFlag(cmd.Flags(), o)
+	return cmd
+}
+
+func (l *encryptionListKeysOptions) run(o *options) error {
+	out, err := o.printer()
+	if err != nil {
+		return err
+	}
+	manager, cse, err := o.connectKeyVault()
+	if err != nil {
+		return err
+	}
+	defer disconnect(manager)
+	ctx, cancel := manager.Context()
+	defer cancel()
+	keys, err := cse.Keys(ctx, manager.Admin())
+	if err != nil {
+		return err
+	}
+	users := cse.KeyUsers()
+
+	listings := make([]encryptionKey, 0, len(keys))
+	for _, key := range keys {
+		var fields []string
+		for _, name := range key.AltNames {
+			fields = append(fields, users[name]...)
+		}
+		listings = append(listings, encryptionKey{ID: keyID(key.ID), AltNames: key.AltNames, Provider: key.Provider(), Created: key.Created, Fields: fields})
+	}
+	return out.print(os.Stdout, listings, func(stdout io.Writer) error {
+		w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAMES\tPROVIDER\tCREATED\tFIELDS")
+		for _, key := range listings {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", key.ID, strings.Join(key.AltNames, ","), key.Provider, key.Created.Format(time.RFC3339), strings.Join(key.Fields, ","))
+		}
+		return w.Flush()
+	})
+}
+
+type encryptionDeleteKeyOptions struct {
+	Force bool
+}
+
+func newEncryptionDeleteKeyCommand(o *options) *cobra.Command {
+	d := &encryptionDeleteKeyOptions{}
+	cmd := &cobra.Command{
+		Use:   "delete-key NAME",
+		Short: "Delete a data key from the key vault",
+		Long: `Delete the data key called NAME. The values it encrypted can never be decrypted again, the keys
+still used by the fields of the config file are only deleted with --force.`,
+		Example: `  mongodb-client encryption delete-key legacy --config config.yaml`,
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			return d.run(o, arguments[0])
+		},
+	}
+	cmd.Flags().BoolVar(&d.Force, "force", d.Force, "Delete the key even if fields of the config file are encrypted with it")
+	return cmd
+}
+
+func (d *encryptionDeleteKeyOptions) run(o *options, name string) error {
+	manager, cse, err := o.connectKeyVault()
+	if err != nil {
+		return err
+	}
+	defer disconnect(manager)
+	if fields := cse.KeyUsers()[name]; len(fields) > 0 && !d.Force {
+		return fmt.Errorf("the data key %s encrypts %s, use --force to delete it anyway", name, strings.Join(fields, ", "))
+	}
+	ctx, cancel := manager.Context()
+	defer cancel()
+	return cse.DeleteKey(ctx, manager.Admin(), name)
+}
+
+func newEncryptionSchemaCommand(o *options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "schema",
+		Short: "Print the JSON schemas of the encrypted fields as Extended JSON",
+		Long: `Print the JSON schema of every namespace with encrypted fields, as given to the driver. It may also
+be set as the validator of the collections, so that the server rejects the documents whose fields
+are not encrypted.`,
+		Example: `  mongodb-client encryption schema --config config.yaml`,
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			manager, cse, err := o.connectKeyVault()
+			if err != nil {
+				return err
+			}
+			defer disconnect(manager)
+			ctx, cancel := manager.Context()
+			defer cancel()
+			schemaMap, err := cse.SchemaMap(ctx, manager.Admin())
+			if err != nil {
+				return err
+			}
+			return printDocument(os.Stdout, schemaMap)
+		},
+	}
+}
+
+type encryptionCreateCollectionOptions struct {
+	KMS string
+}
+
+func newEncryptionCreateCollectionCommand(o *options) *cobra.Command {
+	c := &encryptionCreateCollectionOptions{}
+	cmd := &cobra.Command{
+		Use:   "create-collection NAMESPACE",
+		Short: "Create a collection with the queryable encrypted fields of the config file",
+		Long: `Create the collection database.collection of the queryable section of the config file, with its
+encrypted fields, its metadata collections enxcol_.<collection>.esc and .ecoc and its index on
+__safeContent__. The data keys of the fields that are not in the key vault yet are created first,
+encrypted by the master key of --kms. The server must run MongoDB 7.0 or later.`,
+		Example: `  mongodb-client encryption create-collection sampledb.patients --kms aws --config config.yaml`,
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			return c.run(o, arguments[0])
+		},
+	}
+	cmd.Flags().StringVar(&c.KMS, "kms", c.KMS, "KMS provider of the master key of the new data keys, defaults to the only provider of the config file")
+	return cmd
+}
+
+func (c *encryptionCreateCollectionOptions) run(o *options, namespace string) error {
+	manager, cse, err := o.connectKeyVault()
+	if err != nil {
+		return err
+	}
+	defer disconnect(manager)
+	provider, err := kmsProvider(cse, c.KMS)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := manager.Context()
+	defer cancel()
+	created, err := cse.CreateEncryptedCollection(ctx, manager.Admin(), manager.Admin(), namespace, provider)
+	for _, name := range created {
+		fmt.Fprintf(os.Stderr, "Created the data key %s\n", name)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Created %s with %d queryable encrypted fields\n", namespace, len(cse.Queryable[namespace]))
+	return nil
+}
+
+func newEncryptionVerifyCommand(o *options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "verify NAMESPACE",
+		Short: "Check a collection with Queryable Encryption against the config file",
+		Long: `Check that the server runs MongoDB 7.0 or later, that the data keys of the queryable encrypted
+fields of database.collection exist, that the encrypted fields of the collection match the config
+file and that its metadata collections and __safeContent__ index exist. The command fails when a
+check does.
+
+The driver of this client predates the Queryable Encryption protocol and the crypt_shared library,
+it can not encrypt the queries of these fields: the automatic encryption check is always skipped
+and the applications must query them through a driver supporting it.`,
+		Example: `  mongodb-client encryption verify sampledb.patients --config config.yaml`,
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			manager, cse, err := o.connectKeyVault()
+			if err != nil {
+				return err
+			}
+			defer disconnect(manager)
+			ctx, cancel := manager.Context()
+			defer cancel()
+			checks, err := cse.VerifyEncryptedCollection(ctx, manager.Admin(), manager.Admin(), arguments[0])
+			if err != nil {
+				return err
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "CHECK\tRESULT\tDETAIL")
+			failed := 0
+			for _, check := range checks {
+				result := "ok"
+				switch {
+				case check.Skipped:
+					result = "skipped"
+				case !check.OK:
+					result = "failed"
+					failed++
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\n", check.Name, result, check.Detail)
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+			if failed > 0 {
+				return fmt.Errorf("%d of %d checks of %s failed", failed, len(checks), arguments[0])
+			}
+			return nil
+		},
+	}
+}
+
+// keyID returns the UUID of a data key.
+func keyID(id primitive.Binary) string {
+	if len(id.Data) != 16 {
+		return base64.StdEncoding.EncodeToString(id.Data)
+	}
+	d := id.Data
+	return fmt.Sprintf("%x-%x-%x-%x-%x", d[0:4], d[4:6], d[6:8], d[8:10], d[10:16])
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"os"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+	"k8s.io/klog"
+)
+
+// The exit codes of the process, so that an init container can tell why the initialization of the
+// database failed. The other failures exit with 1.
+const (
+	// exitConnectivity is returned when the database can not be reached.
+	exitConnectivity = 2
+	// exitValidation is returned for invalid flags, config files, manifests or migrations, which
+	// retrying does not fix.
+	exitValidation = 3
+)
+
+// exitError is an error exiting the process with code.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+// validationError returns err exiting with exitValidation, nil when err is nil.
+func validationError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &exitError{code: exitValidation, err: err}
+}
+
+// connectivityError returns err exiting with exitConnectivity, nil when err is nil.
+func connectivityError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &exitError{code: exitConnectivity, err: err}
+}
+
+// exitCode returns the exit code of err: the code of an exitError, exitConnectivity for the errors
+// of the driver failing to reach a server, 1 otherwise.
+func exitCode(err error) int {
+	var exit *exitError
+	if errors.As(err, &exit) {
+		return exit.code
+	}
+	var selection topology.ServerSelectionError
+	if errors.As(err, &selection) || mongo.IsNetworkError(err) {
+		return exitConnectivity
+	}
+	return 1
+}
+
+// exit logs err and exits the process with its exit code.
+func exit(format string, err error) {
+	klog.Errorf(format, err)
+	klog.Flush()
+	os.Exit(exitCode(err))
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// validateExplain returns an error unless verbosity is empty or an explain verbosity.
+func validateExplain(verbosity string) error {
+	switch verbosity {
+	case "", "queryPlanner", "executionStats", "allPlansExecution":
+		return nil
+	}
+	return fmt.Errorf("--explain must be one of queryPlanner, executionStats or allPlansExecution")
+}
+
+// explain runs command through the explain command with verbosity and prints the query plans
+// and, unless verbosity is queryPlanner, the statistics of their execution.
+func explain(ctx context.Context, out io.Writer, db *mongo.Database, command bson.D, verbosity string) error {
+	// Documents are decoded in order for the key patterns of indexes to keep the order of their fields.
+	var result bson.D
+	err := db.RunCommand(ctx, bson.D{{Key: "explain", Value: command}, {Key: "verbosity", Value: verbosity}}).Decode(&result)
+	if err != nil {
+		return fmt.Errorf("unable to explain the %s command: %w", command[0].Key, err)
+	}
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	printExplainResult(w, "", result.Map())
+	return w.Flush()
+}
+
+// printExplainResult prints the explain output of a find or of an aggregation, which holds the
+// plans of the query at the top level, in a leading $cursor stage, or by shard.
+func printExplainResult(w io.Writer, shard string, result bson.M) {
+	if shards := explainDoc(result["shards"]); len(shards) > 0 {
+		names := make([]string, 0, len(shards))
+		for name := range shards {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			printExplainResult(w, name, explainDoc(shards[name]))
+		}
+		return
+	}
+	if len(shard) > 0 {
+		fmt.Fprintf(w, "Shard:\t%s\n", shard)
+	}
+	stages, _ := result["stages"].(bson.A)
+	query := result
+	if len(stages) > 0 {
+		if cursor := explainDoc(explainDoc(stages[0])["$cursor"]); len(cursor) > 0 {
+			query = cursor
+		}
+	}
+	printQueryPlan(w, query)
+	if len(stages) > 1 {
+		fmt.Fprintln(w, "Pipeline:")
+		fmt.Fprintln(w, "  STAGE\tRETURNED\tTIME")
+		for _, item := range stages {
+			// A stage is keyed by its name, next to its statistics.
+			stage := explainDoc(item)
+			for name := range stage {
+				if name != "nReturned" && name != "executionTimeMillisEstimate" {
+					fmt.Fprintf(w, "  %s\t%s\t%s\n", name, explainStat(stage, "nReturned", ""), explainStat(stage, "executionTimeMillisEstimate", "ms"))
+				}
+			}
+		}
+	}
+	fmt.Fprintln(w)
+}
+
+func printQueryPlan(w io.Writer, query bson.M) {
+	planner := explainDoc(query["queryPlanner"])
+	if namespace, ok := planner["namespace"].(string); ok {
+		fmt.Fprintf(w, "Namespace:\t%s\n", namespace)
+	}
+	winning := explainDoc(planner["winningPlan"])
+	// The slot based engine nests the plan of the classic engine under queryPlan.
+	if plan := explainDoc(winning["queryPlan"]); len(plan) > 0 {
+		winning = plan
+	}
+	if len(winning) > 0 {
+		fmt.Fprintf(w, "Winning plan:\t%s\n", strings.Join(planStages(winning), " <- "))
+		if indexes := planIndexes(winning); len(indexes) > 0 {
+			fmt.Fprintf(w, "Indexes:\t%s\n", strings.Join(indexes, ", "))
+		} else if containsStage(winning, "COLLSCAN") {
+			fmt.Fprintf(w, "Indexes:\tnone, the collection is scanned\n")
+		}
+	}
+	if rejected, _ := planner["rejectedPlans"].(bson.A); len(rejected) > 0 {
+		fmt.Fprintf(w, "Rejected plans:\t%d\n", len(rejected))
+	}
+
+	stats := explainDoc(query["executionStats"])
+	if len(stats) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "Returned:\t%s\n", explainStat(stats, "nReturned", ""))
+	fmt.Fprintf(w, "Documents examined:\t%s\n", explainStat(stats, "totalDocsExamined", ""))
+	fmt.Fprintf(w, "Keys examined:\t%s\n", explainStat(stats, "totalKeysExamined", ""))
+	fmt.Fprintf(w, "Execution time:\t%s\n", explainStat(stats, "executionTimeMillis", "ms"))
+	if root := explainDoc(stats["executionStages"]); len(root) > 0 {
+		fmt.Fprintln(w, "Stages:")
+		fmt.Fprintln(w, "  STAGE\tRETURNED\tDOCS EXAMINED\tKEYS EXAMINED\tTIME")
+		printExecutionStage(w, root, 1)
+	}
+}
+
+// printExecutionStage prints the statistics of stage and, indented below it, of its inputs.
+func printExecutionStage(w io.Writer, stage bson.M, depth int) {
+	name, _ := stage["stage"].(string)
+	if index, ok := stage["indexName"].(string); ok {
+		name += " " + index
+	}
+	fmt.Fprintf(w, "%s%s\t%s\t%s\t%s\t%s\n", strings.Repeat("  ", depth), name, explainStat(stage, "nReturned", ""),
+		explainStat(stage, "docsExamined", ""), explainStat(stage, "keysExamined", ""), explainStat(stage, "executionTimeMillisEstimate", "ms"))
+	for _, input := range planInputs(stage) {
+		printExecutionStage(w, input, depth+1)
+	}
+}
+
+// planInputs returns the input stages of a plan stage.
+func planInputs(stage bson.M) []bson.M {
+	var inputs []bson.M
+	if input := explainDoc(stage["inputStage"]); len(input) > 0 {
+		inputs = append(inputs, input)
+	}
+	if items, ok := stage["inputStages"].(bson.A); ok {
+		for _, item := range items {
+			inputs = append(inputs, explainDoc(item))
+		}
+	}
+	return inputs
+}
+
+// planStages returns the names of the stages of a plan from the root, following the first input.
+func planStages(stage bson.M) []string {
+	var names []string
+	for len(stage) > 0 {
+		name, _ := stage["stage"].(string)
+		names = append(names, name)
+		inputs := planInputs(stage)
+		if len(inputs) == 0 {
+			break
+		}
+		stage = inputs[0]
+	}
+	return names
+}
+
+// planIndexes returns the indexes used by a plan with their key pattern.
+func planIndexes(stage bson.M) []string {
+	var indexes []string
+	if name, ok := stage["indexName"].(string); ok {
+		if keys, err := bson.MarshalExtJSON(stage["keyPattern"], false, false); err == nil {
+			name += " " + string(keys)
+		}
+		indexes = append(indexes, name)
+	}
+	for _, input := range planInputs(stage) {
+		indexes = append(indexes, planIndexes(input)...)
+	}
+	return indexes
+}
+
+func containsStage(stage bson.M, name string) bool {
+	if stage["stage"] == name {
+		return true
+	}
+	for _, input := range planInputs(stage) {
+		if containsStage(input, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// explainDoc returns value as a bson.M, or nil when it is not a document.
+func explainDoc(value interface{}) bson.M {
+	switch doc := value.(type) {
+	case bson.M:
+		return doc
+	case bson.D:
+		return doc.Map()
+	}
+	return nil
+}
+
+// explainStat formats a numeric field of doc with unit, or returns - when it is missing.
+func explainStat(doc bson.M, field, unit string) string {
+	switch value := doc[field].(type) {
+	case int32, int64:
+		return fmt.Sprintf("%d%s", value, unit)
+	case float64:
+		return fmt.Sprintf("%.0f%s", value, unit)
+	}
+	return "-"
+}
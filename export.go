@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/archive"
+	"github.com/bradmwilliams/mongodb-client/pkg/client"
+	"github.com/bradmwilliams/mongodb-client/pkg/objectstore"
+	"github.com/spf13/cobra"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	mongoOptions "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type exportOptions struct {
+	Database   string
+	Collection string
+	Filter     string
+	Sort       string
+	Fields     []string
+	Format     string
+	Out        string
+	Skip       int64
+	Limit      int64
+	NoHeader   bool
+	BatchSize  int32
+	Mask       string
+	JQ         string
+	Params     []string
+	Storage    objectstore.Options
+}
+
+func newExportCommand(o *options) *cobra.Command {
+	e := &exportOptions{
+		Format: "ndjson",
+	}
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Stream the documents of a collection as NDJSON, a JSON array, or CSV",
+		Example: `  mongodb-client export --collection episodes --filter '{"duration":{"$gt":25}}' > episodes.ndjson
+  mongodb-client export --collection episodes --fields title,duration --format csv --out episodes.csv`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			return e.run(o)
+		},
+	}
+
+	flagset := cmd.Flags()
+	flagset.StringVar(&e.Database, "db", e.Database, "Database to export from, defaults to MONGODB_DATABASE")
+	flagset.StringVar(&e.Collection, "collection", e.Collection, "Collection to export")
+	flagset.StringVar(&e.Filter, "filter", e.Filter, "Extended JSON query filter")
+	flagset.StringVar(&e.Sort, "sort", e.Sort, "Extended JSON sort specification")
+	flagset.StringSliceVar(&e.Fields, "fields", e.Fields, "Comma-separated list of fields to export, dotted paths select nested fields (required for csv)")
+	flagset.StringVar(&e.Format, "format", e.Format, "Output format: ndjson, json or csv")
+	flagset.StringVar(&e.Out, "out", e.Out, "File or s3://, gs:// or azblob:// URL to write to, defaults to standard output")
+	flagset.Int64Var(&e.Skip, "skip", e.Skip, "Number of matching documents to skip")
+	flagset.Int64Var(&e.Limit, "limit", e.Limit, "Maximum number of documents to export (0 means no limit)")
+	flagset.Int32Var(&e.BatchSize, "batch-size", e.BatchSize, "Number of documents per cursor batch, bounding the memory used while exporting (0 uses the server default)")
+	flagset.BoolVar(&e.NoHeader, "no-header", e.NoHeader, "Omit the field names from the first line of csv output")
+	addMaskFlag(flagset, &e.Mask)
+	addJQFlag(flagset, &e.JQ)
+	addParamFlag(flagset, &e.Params)
+	addStorageFlags(flagset, &e.Storage)
+	cmd.MarkFlagRequired("collection")
+	return cmd
+}
+
+func (e *exportOptions) run(o *options) error {
+	switch e.Format {
+	case "ndjson", "json":
+	case "csv":
+		if len(e.Fields) == 0 {
+			return fmt.Errorf("--fields is required for csv output")
+		}
+	default:
+		return fmt.Errorf("--format must be one of ndjson, json or csv")
+	}
+	if err := renderTemplates(e.Params, map[string]*string{"--filter": &e.Filter, "--sort": &e.Sort}); err != nil {
+		return err
+	}
+	filter, err := parseDocument(e.Filter)
+	if err != nil {
+		return fmt.Errorf("--filter: %w", err)
+	}
+	if e.BatchSize < 0 {
+		return fmt.Errorf("--batch-size must not be negative")
+	}
+	findOptions := mongoOptions.Find().SetSkip(e.Skip).SetLimit(e.Limit)
+	if e.BatchSize > 0 {
+		findOptions.SetBatchSize(e.BatchSize)
+	}
+	if len(e.Sort) > 0 {
+		sort, err := parseDocument(e.Sort)
+		if err != nil {
+			return fmt.Errorf("--sort: %w", err)
+		}
+		findOptions.SetSort(sort)
+	}
+	if len(e.Fields) > 0 {
+		projection := bson.D{}
+		for _, field := range e.Fields {
+			projection = append(projection, bson.E{Key: field, Value: 1})
+		}
+		findOptions.SetProjection(projection)
+	}
+
+	transform, err := maskTransform(e.Mask)
+	if err != nil {
+		return err
+	}
+	expr, err := parseJQ(e.JQ)
+	if err != nil {
+		return err
+	}
+
+	manager, err := o.connect()
+	if err != nil {
+		return err
+	}
+	defer disconnect(manager)
+
+	database := e.Database
+	if len(database) == 0 {
+		database = manager.Database()
+	}
+
+	ctx, cancel := cmdContext()
+	defer cancel()
+
+	out, err := openOutput(ctx, e.Out, e.Storage)
+	if err != nil {
+		return err
+	}
+	// A failed export must not leave a partial object behind.
+	defer out.Abort()
+	writer := newDocumentWriter(e.Format, out, e.Fields, !e.NoHeader)
+	cursor, err := manager.Primary().Database(database).Collection(e.Collection).Find(ctx, filter, findOptions)
+	if err != nil {
+		return err
+	}
+	write := writer.Write
+	if expr != nil {
+		write = jqWriter(expr, write)
+	}
+	if transform != nil {
+		namespace := archive.Namespace{Database: database, Collection: e.Collection}
+		transformed := write
+		write = func(doc bson.Raw) error {
+			masked, err := transform(namespace, doc)
+			if err != nil {
+				return err
+			}
+			return transformed(masked)
+		}
+	}
+	if err := client.Each(ctx, cursor, write); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// documentWriter writes a stream of documents in one of the export formats.
+type documentWriter interface {
+	Write(doc bson.Raw) error
+	// Close flushes buffered output, it does not close the underlying writer.
+	Close() error
+}
+
+func newDocumentWriter(format string, w io.Writer, fields []string, header bool) documentWriter {
+	buffered := bufio.NewWriter(w)
+	switch format {
+	case "json":
+		return &jsonArrayWriter{w: buffered}
+	case "csv":
+		return &csvWriter{w: csv.NewWriter(buffered), buffered: buffered, fields: fields, header: header}
+	}
+	return &ndjsonWriter{w: buffered}
+}
+
+type ndjsonWriter struct {
+	w *bufio.Writer
+}
+
+func (n *ndjsonWriter) Write(doc bson.Raw) error {
+	return writeLine(n.w, doc)
+}
+
+func (n *ndjsonWriter) Close() error {
+	return n.w.Flush()
+}
+
+type jsonArrayWriter struct {
+	w     *bufio.Writer
+	count int
+}
+
+func (j *jsonArrayWriter) Write(doc bson.Raw) error {
+	separator := ",\n"
+	if j.count == 0 {
+		separator = "[\n"
+	}
+	j.count++
+	data, err := ejson.marshal(doc, false)
+	if err != nil {
+		return err
+	}
+	if _, err := j.w.WriteString(separator); err != nil {
+		return err
+	}
+	_, err = j.w.Write(data)
+	return err
+}
+
+func (j *jsonArrayWriter) Close() error {
+	end := "\n]\n"
+	if j.count == 0 {
+		end = "[]\n"
+	}
+	if _, err := j.w.WriteString(end); err != nil {
+		return err
+	}
+	return j.w.Flush()
+}
+
+type csvWriter struct {
+	w        *csv.Writer
+	buffered *bufio.Writer
+	fields   []string
+	header   bool
+	started  bool
+}
+
+func (c *csvWriter) Write(doc bson.Raw) error {
+	if !c.started {
+		c.started = true
+		if c.header {
+			if err := c.w.Write(c.fields); err != nil {
+				return err
+			}
+		}
+	}
+	record := make([]string, len(c.fields))
+	for i, field := range c.fields {
+		value, err := doc.LookupErr(strings.Split(field, ".")...)
+		if err != nil {
+			// Missing fields are exported as empty cells.
+			continue
+		}
+		if record[i], err = csvValue(value); err != nil {
+			return fmt.Errorf("field %s: %w", field, err)
+		}
+	}
+	return c.w.Write(record)
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	if err := c.w.Error(); err != nil {
+		return err
+	}
+	return c.buffered.Flush()
+}
+
+// csvValue renders a single BSON value the way mongoexport does for csv output.
+func csvValue(value bson.RawValue) (string, error) {
+	switch value.Type {
+	case bsontype.String:
+		return value.StringValue(), nil
+	case bsontype.ObjectID:
+		return fmt.Sprintf("ObjectId(%s)", value.ObjectID().Hex()), nil
+	case bsontype.Int32:
+		return strconv.FormatInt(int64(value.Int32()), 10), nil
+	case bsontype.Int64:
+		return strconv.FormatInt(value.Int64(), 10), nil
+	case bsontype.Double:
+		return strconv.FormatFloat(value.Double(), 'g', -1, 64), nil
+	case bsontype.Decimal128:
+		return value.Decimal128().String(), nil
+	case bsontype.Boolean:
+		return strconv.FormatBool(value.Boolean()), nil
+	case bsontype.DateTime:
+		return primitive.DateTime(value.DateTime()).Time().UTC().Format(time.RFC3339Nano), nil
+	case bsontype.Null, bsontype.Undefined:
+		return "", nil
+	}
+	// Documents, arrays and the remaining types are written as Extended JSON.
+	data, err := bson.MarshalExtJSON(bson.D{{Key: "v", Value: value}}, false, false)
+	if err != nil {
+		return "", err
+	}
+	// Strip the {"v": ... } wrapper.
+	return strings.TrimSuffix(strings.TrimPrefix(string(data), `{"v":`), "}"), nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/client"
+	"github.com/bradmwilliams/mongodb-client/pkg/featureflag"
+	"github.com/bradmwilliams/mongodb-client/pkg/jobs"
+	"github.com/spf13/cobra"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type featureFlagOptions struct {
+	Database   string
+	Collection string
+}
+
+func newFeatureFlagCommand(o *options) *cobra.Command {
+	f := &featureFlagOptions{Collection: "featureFlags"}
+	cmd := &cobra.Command{
+		Use:   "feature-flag",
+		Short: "List, set and unset the feature flags of a collection",
+		Long: `Manage the feature flags stored in a collection, one document per flag. Services embedding the
+featureflag package, and this client run with --feature-flag-collection, reload the flags as soon as
+they change. The background jobs of the client are skipped while the flag jobs.NAME.enabled is false.`,
+	}
+	persistent := cmd.PersistentFlags()
+	persistent.StringVar(&f.Database, "db", f.Database, "Database of the flags, defaults to MONGODB_DATABASE")
+	persistent.StringVar(&f.Collection, "collection", f.Collection, "Collection holding the flags")
+	cmd.AddCommand(newFeatureFlagListCommand(o, f))
+	cmd.AddCommand(newFeatureFlagSetCommand(o, f))
+	cmd.AddCommand(newFeatureFlagUnsetCommand(o, f))
+	return cmd
+}
+
+// collection connects to the database and returns the collection of the flags.
+func (f *featureFlagOptions) collection(o *options) (*client.ConnectionManager, *mongo.Collection, error) {
+	manager, err := o.connect()
+	if err != nil {
+		return nil, nil, err
+	}
+	database := f.Database
+	if len(database) == 0 {
+		database = manager.Database()
+	}
+	return manager, manager.Primary().Database(database).Collection(f.Collection), nil
+}
+
+func newFeatureFlagListCommand(o *options, f *featureFlagOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:     "list",
+		Short:   "Print the feature flags with their value as relaxed Extended JSON",
+		Example: `  mongodb-client feature-flag list`,
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			manager, collection, err := f.collection(o)
+			if err != nil {
+				return err
+			}
+			defer disconnect(manager)
+			ctx, cancel := manager.Context()
+			defer cancel()
+			flags, err := featureflag.List(ctx, collection)
+			if err != nil {
+				return err
+			}
+			out, err := o.printer()
+			if err != nil {
+				return err
+			}
+			docs := make([]bson.D, 0, len(flags))
+			for _, flag := range flags {
+				docs = append(docs, bson.D{
+					{Key: "name", Value: flag.Name},
+					{Key: "value", Value: flag.Value},
+					{Key: "description", Value: flag.Description},
+					{Key: "updatedAt", Value: flag.UpdatedAt},
+				})
+			}
+			return out.print(os.Stdout, docs, func(stdout io.Writer) error {
+				w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+				fmt.Fprintln(w, "NAME\tVALUE\tUPDATED\tDESCRIPTION")
+				for _, flag := range flags {
+					value, err := bson.MarshalExtJSON(bson.D{{Key: "v", Value: flag.Value}}, false, false)
+					if err != nil {
+						return err
+					}
+					// The value is unwrapped from {"v":...}.
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", flag.Name, value[5:len(value)-1], flag.UpdatedAt.Format("2006-01-02T15:04:05Z"), flag.Description)
+				}
+				return w.Flush()
+			})
+		},
+	}
+}
+
+func newFeatureFlagSetCommand(o *options, f *featureFlagOptions) *cobra.Command {
+	var description string
+	cmd := &cobra.Command{
+		Use:   "set NAME VALUE",
+		Short: "Set a feature flag to an Extended JSON value",
+		Long: `Set the flag NAME to VALUE, an Extended JSON value such as true, 10 or {"ratio":0.5}. A VALUE that
+is not valid JSON is stored as a string.`,
+		Example: `  mongodb-client feature-flag set jobs.backup-nightly.enabled false
+  mongodb-client feature-flag set search.engine atlas --description "Search engine of the catalog"`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			name := arguments[0]
+			var value interface{} = arguments[1]
+			if parsed, err := parseValue(arguments[1]); err == nil {
+				value = parsed
+			}
+			manager, collection, err := f.collection(o)
+			if err != nil {
+				return err
+			}
+			defer disconnect(manager)
+			ctx, cancel := manager.ContextFor(client.WriteOperation)
+			defer cancel()
+			return featureflag.Set(ctx, collection, name, value, description)
+		},
+	}
+	cmd.Flags().StringVar(&description, "description", description, "Description of the flag, the current one is kept when empty")
+	return cmd
+}
+
+func newFeatureFlagUnsetCommand(o *options, f *featureFlagOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:     "unset NAME",
+		Short:   "Remove a feature flag, readers fall back to their default",
+		Example: `  mongodb-client feature-flag unset jobs.backup-nightly.enabled`,
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			manager, collection, err := f.collection(o)
+			if err != nil {
+				return err
+			}
+			defer disconnect(manager)
+			ctx, cancel := manager.ContextFor(client.WriteOperation)
+			defer cancel()
+			removed, err := featureflag.Unset(ctx, collection, arguments[0])
+			if err != nil {
+				return err
+			}
+			if !removed {
+				return fmt.Errorf("feature flag %s is not set", arguments[0])
+			}
+			return nil
+		},
+	}
+}
+
+// toggled wraps handler so that it is skipped while the feature flag jobs.NAME.enabled of the job
+// called name is false.
+func toggled(flags *featureflag.Store, name string, handler jobs.Handler) jobs.Handler {
+	flag := "jobs." + name + ".enabled"
+	return func(ctx context.Context, c *mongo.Client) error {
+		if !flags.Bool(flag, true) {
+			return fmt.Errorf("%w: feature flag %s is false", jobs.ErrSkipped, flag)
+		}
+		return handler(ctx, c)
+	}
+}
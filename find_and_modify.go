@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/client"
+	"github.com/spf13/cobra"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type findAndModifyOptions struct {
+	Database     string
+	Collection   string
+	Filter       string
+	Sort         string
+	Projection   string
+	Update       string
+	Replacement  string
+	Remove       bool
+	Upsert       bool
+	Return       string
+	ArrayFilters string
+}
+
+func newFindAndModifyCommand(o *options) *cobra.Command {
+	f := &findAndModifyOptions{Return: "before"}
+	cmd := &cobra.Command{
+		Use:   "find-and-modify",
+		Short: "Atomically update, replace or delete a document and print it",
+		Long: `Atomically update, replace or delete the first document matching --filter in --sort order, and
+print it as Extended JSON, as it was before its modification or, with --return after, as it is
+after. No other operation can modify the document between its read and its modification, which
+makes the command suited to counters and to claiming the items of a work queue.
+
+--update is an update document or an aggregation pipeline. With --upsert a document is inserted when
+none matches, it is only printed with --return after. The command fails when no document matches.`,
+		Example: `  mongodb-client find-and-modify --collection counters --filter '{"_id":"episodes"}' \
+    --update '{"$inc":{"value":1}}' --upsert --return after
+  mongodb-client find-and-modify --collection jobs --filter '{"state":"queued"}' --sort '{"priority":-1,"created":1}' \
+    --update '{"$set":{"state":"running","started":{"$date":"2021-06-01T00:00:00Z"}}}' --return after
+  mongodb-client find-and-modify --collection episodes --filter '{"_id":1}' \
+    --update '{"$set":{"guests.$[g].confirmed":true}}' --array-filters '[{"g.name":"Ada"}]'
+  mongodb-client find-and-modify --collection jobs --filter '{"state":"done"}' --sort '{"finished":1}' --remove`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			return f.run(o)
+		},
+	}
+
+	flagset := cmd.Flags()
+	flagset.StringVar(&f.Database, "db", f.Database, "Database of the collection, defaults to MONGODB_DATABASE")
+	flagset.StringVar(&f.Collection, "collection", f.Collection, "Collection of the document")
+	flagset.StringVar(&f.Filter, "filter", f.Filter, "Extended JSON query filter of the document")
+	flagset.StringVar(&f.Sort, "sort", f.Sort, "Extended JSON sort specification choosing the document among those matching")
+	flagset.StringVar(&f.Projection, "project", f.Projection, "Extended JSON projection of the printed document")
+	flagset.StringVar(&f.Update, "update", f.Update, "Extended JSON update document or aggregation pipeline")
+	flagset.StringVar(&f.Replacement, "replace", f.Replacement, "Extended JSON document replacing the document")
+	flagset.BoolVar(&f.Remove, "remove", f.Remove, "Delete the document")
+	flagset.BoolVar(&f.Upsert, "upsert", f.Upsert, "Insert a document when none matches --filter")
+	flagset.StringVar(&f.Return, "return", f.Return, "Print the document as it was before its modification or as it is after: before or after")
+	flagset.StringVar(&f.ArrayFilters, "array-filters", f.ArrayFilters, "JSON array of the Extended JSON filters of the $[identifier] operators of --update")
+	cmd.MarkFlagRequired("collection")
+	return cmd
+}
+
+// modification returns the read-modify-write requested by the flags.
+func (f *findAndModifyOptions) modification() (*client.FindAndModify, error) {
+	if f.Return != "before" && f.Return != "after" {
+		return nil, fmt.Errorf("--return must be before or after")
+	}
+	modify := &client.FindAndModify{Remove: f.Remove, Upsert: f.Upsert, ReturnAfter: f.Return == "after"}
+	var err error
+	if modify.Filter, err = parseDocument(f.Filter); err != nil {
+		return nil, fmt.Errorf("--filter: %w", err)
+	}
+	if len(f.Sort) > 0 {
+		if modify.Sort, err = parseDocument(f.Sort); err != nil {
+			return nil, fmt.Errorf("--sort: %w", err)
+		}
+	}
+	if len(f.Projection) > 0 {
+		if modify.Projection, err = parseDocument(f.Projection); err != nil {
+			return nil, fmt.Errorf("--project: %w", err)
+		}
+	}
+	if trimmed := strings.TrimSpace(f.Update); strings.HasPrefix(trimmed, "[") {
+		if modify.Update, err = parsePipeline(trimmed); err != nil {
+			return nil, fmt.Errorf("--update: %w", err)
+		}
+	} else if len(trimmed) > 0 {
+		update, err := parseDocument(trimmed)
+		if err != nil {
+			return nil, fmt.Errorf("--update: %w", err)
+		}
+		for _, e := range update {
+			if !strings.HasPrefix(e.Key, "$") {
+				return nil, fmt.Errorf("--update must only hold update operators such as $set, use --replace to replace the document")
+			}
+		}
+		modify.Update = update
+	}
+	if len(f.Replacement) > 0 {
+		replacement, err := parseDocument(f.Replacement)
+		if err != nil {
+			return nil, fmt.Errorf("--replace: %w", err)
+		}
+		for _, e := range replacement {
+			if strings.HasPrefix(e.Key, "$") {
+				return nil, fmt.Errorf("--replace must not hold update operators, use --update to update the document")
+			}
+		}
+		modify.Replacement = replacement
+	}
+	if len(f.ArrayFilters) > 0 {
+		filters, err := parsePipeline(f.ArrayFilters)
+		if err != nil {
+			return nil, fmt.Errorf("--array-filters: %w", err)
+		}
+		for _, filter := range filters {
+			modify.ArrayFilters = append(modify.ArrayFilters, filter)
+		}
+	}
+	if err := modify.Validate(); err != nil {
+		return nil, fmt.Errorf("--update, --replace and --remove: %w", err)
+	}
+	return modify, nil
+}
+
+func (f *findAndModifyOptions) run(o *options) error {
+	modify, err := f.modification()
+	if err != nil {
+		return err
+	}
+
+	manager, err := o.connect()
+	if err != nil {
+		return err
+	}
+	defer disconnect(manager)
+
+	database := f.Database
+	if len(database) == 0 {
+		database = manager.Database()
+	}
+	ctx, cancel := manager.ContextFor(client.WriteOperation)
+	defer cancel()
+	var doc bson.Raw
+	err = modify.Run(ctx, manager.Primary().Database(database).Collection(f.Collection), &doc)
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments) && f.Upsert:
+		fmt.Fprintf(os.Stderr, "No document matched, inserted one in %s.%s\n", database, f.Collection)
+		return nil
+	case errors.Is(err, mongo.ErrNoDocuments):
+		return fmt.Errorf("no document of %s.%s matches --filter", database, f.Collection)
+	case err != nil:
+		return err
+	}
+	out, err := o.printer()
+	if err != nil {
+		return err
+	}
+	if err := out.printDocument(os.Stdout, doc); err != nil {
+		return err
+	}
+	return out.flush(os.Stdout)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/client"
+	"github.com/bradmwilliams/mongodb-client/pkg/fixtures"
+	"github.com/spf13/cobra"
+)
+
+type fixturesOptions struct {
+	Dir string
+}
+
+func newFixturesCommand(o *options) *cobra.Command {
+	f := &fixturesOptions{
+		Dir: "fixtures",
+	}
+	cmd := &cobra.Command{
+		Use:   "fixtures",
+		Short: "Load named sets of sample documents into the database",
+		Long: `Load fixture sets, named sets of sample documents, into the database.
+
+A set is a YAML or JSON file <name>.yaml of --dir listing Extended JSON documents by collection. It
+may include other sets, loaded first, and set the database of its documents, the configured one is
+used otherwise. A document named with a _name field, which is not written, is referred to by
+{"$fixture": "<name>"} in the other documents of the loaded sets, which is replaced by its _id:
+
+  include: [base]
+  collections:
+    podcasts:
+    - _name: polyglot
+      title: The Polyglot Developer Podcast
+    episodes:
+    - podcast: {$fixture: polyglot}
+      title: GraphQL for API Development
+
+Documents are inserted, so that loading a set twice fails on duplicate keys for the documents with
+an _id and duplicates the others. With --upsert the documents without an _id get one derived from
+their name, or from their contents, and replace those with the same _id, so that a set can be
+loaded again and again.`,
+		Example: `  mongodb-client fixtures list
+  mongodb-client fixtures load --set dev --upsert
+  mongodb-client fixtures load --dir testdata/fixtures --set base,load --db scratch`,
+	}
+	cmd.PersistentFlags().StringVar(&f.Dir, "dir", f.Dir, "Directory of the fixture sets")
+	cmd.AddCommand(newFixturesLoadCommand(o, f))
+	cmd.AddCommand(newFixturesListCommand(o, f))
+	return cmd
+}
+
+type fixturesLoadOptions struct {
+	Sets      []string
+	Database  string
+	Upsert    bool
+	BatchSize int
+	DryRun    bool
+}
+
+func newFixturesLoadCommand(o *options, f *fixturesOptions) *cobra.Command {
+	l := &fixturesLoadOptions{
+		BatchSize: 1000,
+	}
+	cmd := &cobra.Command{
+		Use:   "load",
+		Short: "Write the documents of fixture sets",
+		Example: `  mongodb-client fixtures load --set dev
+  mongodb-client fixtures load --set dev --upsert --dry-run`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			return l.run(o, f)
+		},
+	}
+
+	flagset := cmd.Flags()
+	flagset.StringSliceVar(&l.Sets, "set", l.Sets, "Fixture sets to load, with the sets they include")
+	flagset.StringVar(&l.Database, "db", l.Database, "Database of the documents of the sets without one, defaults to MONGODB_DATABASE")
+	flagset.BoolVar(&l.Upsert, "upsert", l.Upsert, "Give the documents stable _ids and replace the existing ones instead of inserting duplicates")
+	flagset.IntVar(&l.BatchSize, "batch-size", l.BatchSize, "Number of documents written per request")
+	flagset.BoolVar(&l.DryRun, "dry-run", l.DryRun, "Print the documents that would be written without writing them")
+	cmd.MarkFlagRequired("set")
+	return cmd
+}
+
+func (l *fixturesLoadOptions) run(o *options, f *fixturesOptions) error {
+	if l.BatchSize < 1 {
+		return fmt.Errorf("--batch-size must be at least 1")
+	}
+	manager, err := o.connect()
+	if err != nil {
+		return err
+	}
+	defer disconnect(manager)
+
+	database := l.Database
+	if len(database) == 0 {
+		database = manager.Database()
+	}
+	ctx, cancel := cmdContext()
+	defer cancel()
+	return loadFixtures(ctx, manager, f.Dir, l.Sets, database, fixtures.Options{Upsert: l.Upsert, BatchSize: l.BatchSize}, l.DryRun)
+}
+
+// loadFixtures writes the documents of sets of dir into database, or prints them with dryRun, and
+// reports the writes by collection on standard error.
+func loadFixtures(ctx context.Context, manager *client.ConnectionManager, dir string, sets []string, database string, opts fixtures.Options, dryRun bool) error {
+	loaded, err := fixtures.Load(dir, sets...)
+	if err != nil {
+		return validationError(err)
+	}
+	documents, err := loaded.Resolve(opts.Upsert)
+	if err != nil {
+		return validationError(err)
+	}
+	if dryRun {
+		for _, document := range documents {
+			db := document.Database
+			if len(db) == 0 {
+				db = database
+			}
+			fmt.Fprintf(os.Stdout, "%s.%s ", db, document.Collection)
+			if err := writeLine(os.Stdout, document.Document); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if opts.Upsert {
+		opts.Retry = manager.Retry
+	}
+	results, err := fixtures.Apply(ctx, manager.Primary(), database, documents, opts)
+	for _, result := range results {
+		fmt.Fprintf(os.Stderr, "%s.%s: %s\n", result.Database, result.Collection, result.Result)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to load fixture sets %s: %w", strings.Join(loaded.Sets, ", "), err)
+	}
+	return nil
+}
+
+// fixtureSet is a fixture set as printed by fixtures list.
+type fixtureSet struct {
+	Name      string   `json:"name"`
+	Documents int      `json:"documents"`
+	Includes  []string `json:"includes,omitempty"`
+}
+
+func newFixturesListCommand(o *options, f *fixturesOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List the fixture sets and the number of their documents",
+		Example: `  mongodb-client fixtures list --dir fixtures
+  mongodb-client fixtures list -o json`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			out, err := o.printer()
+			if err != nil {
+				return err
+			}
+			names, err := fixtures.Sets(f.Dir)
+			if err != nil {
+				return err
+			}
+			sets := make([]fixtureSet, 0, len(names))
+			for _, name := range names {
+				loaded, err := fixtures.Load(f.Dir, name)
+				if err != nil {
+					return err
+				}
+				set := fixtureSet{Name: name, Includes: loaded.Sets[:len(loaded.Sets)-1]}
+				for _, document := range loaded.Documents {
+					if document.Set == name {
+						set.Documents++
+					}
+				}
+				sets = append(sets, set)
+			}
+			return out.print(os.Stdout, sets, func(stdout io.Writer) error {
+				w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+				fmt.Fprintln(w, "SET\tDOCUMENTS\tINCLUDES")
+				for _, set := range sets {
+					fmt.Fprintf(w, "%s\t%d\t%s\n", set.Name, set.Documents, strings.Join(set.Includes, ","))
+				}
+				return w.Flush()
+			})
+		},
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/generate"
+	"github.com/spf13/cobra"
+	"go.mongodb.org/mongo-driver/bson"
+	"sigs.k8s.io/yaml"
+)
+
+type generateOptions struct {
+	generate.Options
+	Database   string
+	Collection string
+	Template   string
+	Drop       bool
+	DryRun     bool
+}
+
+func newGenerateCommand(o *options) *cobra.Command {
+	g := &generateOptions{
+		Options: generate.Options{Count: 1000, Workers: 4, BatchSize: 1000, ProgressInterval: 5 * time.Second},
+	}
+	cmd := &cobra.Command{
+		Use:   "generate",
+		Short: "Insert fake documents built from a template into a collection",
+		Long: `Insert --count fake documents into a collection, written by --workers in parallel, to populate
+performance environments without production data.
+
+The documents are built from --template, a YAML or JSON file with a single Extended JSON document
+in which the following values are replaced in every document:
+
+  {"$fake": "<function>", <arguments>}
+      a fake value, the functions are ` + strings.Join(generate.Fakers(), ", ") + `.
+      int and double take a min and a max, date a from and a to, sequence a start and pick the
+      values to pick from, such as {"$fake": "int", "min": 1, "max": 60}
+  {"$array": <template>, "min": 1, "max": 5}
+      an array of min to max values of the template
+  {"$ref": "<collection>", "field": "_id", "sample": 1000}
+      the field of a document of another collection of the database, picked from a sample of
+      them, to refer to documents generated before
+
+The same --seed generates the same documents. The fields of YAML templates are sorted by name, use
+a JSON template to keep their order.`,
+		Example: `  mongodb-client generate --collection podcasts --template podcast.json --count 1000
+  mongodb-client generate --collection episodes --template episode.yaml --count 10000000 --workers 16 --seed 42
+  mongodb-client generate --collection episodes --template episode.yaml --count 3 --dry-run`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			if !cmd.Flags().Changed("seed") {
+				g.Seed = time.Now().UnixNano()
+			}
+			return g.run(o)
+		},
+	}
+
+	flagset := cmd.Flags()
+	flagset.StringVar(&g.Database, "db", g.Database, "Database of the collection, defaults to MONGODB_DATABASE")
+	flagset.StringVar(&g.Collection, "collection", g.Collection, "Collection to insert the documents into")
+	flagset.StringVar(&g.Template, "template", g.Template, "YAML or JSON file of the template of the documents")
+	flagset.Int64Var(&g.Count, "count", g.Count, "Number of documents to insert")
+	flagset.IntVar(&g.Workers, "workers", g.Workers, "Number of batches inserted in parallel")
+	flagset.IntVar(&g.BatchSize, "batch-size", g.BatchSize, "Number of documents inserted per request")
+	flagset.Int64Var(&g.Seed, "seed", g.Seed, "Seed of the fake values, a random one by default")
+	flagset.BoolVar(&g.Drop, "drop", g.Drop, "Drop the collection before inserting the documents")
+	flagset.DurationVar(&g.ProgressInterval, "progress-interval", g.ProgressInterval, "Interval between progress reports on standard error")
+	flagset.BoolVar(&g.DryRun, "dry-run", g.DryRun, "Print the documents instead of inserting them")
+	cmd.MarkFlagRequired("collection")
+	cmd.MarkFlagRequired("template")
+	return cmd
+}
+
+func (g *generateOptions) run(o *options) error {
+	if g.Count < 1 {
+		return fmt.Errorf("--count must be at least 1")
+	}
+	if g.Workers < 1 {
+		return fmt.Errorf("--workers must be at least 1")
+	}
+	if g.BatchSize < 1 {
+		return fmt.Errorf("--batch-size must be at least 1")
+	}
+	doc, err := loadTemplate(g.Template)
+	if err != nil {
+		return err
+	}
+	manager, err := o.connect()
+	if err != nil {
+		return err
+	}
+	defer disconnect(manager)
+
+	database := g.Database
+	if len(database) == 0 {
+		database = manager.Database()
+	}
+	db := manager.Primary().Database(database)
+	ctx, cancel := cmdContext()
+	defer cancel()
+	template, err := generate.Compile(ctx, doc, generate.Sample(db))
+	if err != nil {
+		return fmt.Errorf("invalid template %s: %w", g.Template, err)
+	}
+
+	if g.DryRun {
+		r := rand.New(rand.NewSource(g.Seed))
+		for index := int64(0); index < g.Count; index++ {
+			if err := writeLine(os.Stdout, template.Document(r, index)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	collection := db.Collection(g.Collection)
+	if g.Drop {
+		if err := o.checkProtected(database, g.Collection); err != nil {
+			return err
+		}
+		if err := o.confirm("Drop %s.%s before generating", database, g.Collection); err != nil {
+			return err
+		}
+		if err := collection.Drop(ctx); err != nil {
+			return fmt.Errorf("unable to drop %s.%s: %w", database, g.Collection, err)
+		}
+	}
+	g.Progress = func(p generate.Progress) {
+		line := fmt.Sprintf("%s.%s: %d of %d documents inserted (%d%%), %.0f/s", database, g.Collection, p.Written, p.Total, min64(100, p.Written*100/p.Total), p.Rate())
+		if remaining := p.Remaining(); remaining > 0 {
+			line += fmt.Sprintf(", ETA %s", remaining.Round(time.Second))
+		}
+		fmt.Fprintln(os.Stderr, line)
+	}
+	fmt.Fprintf(os.Stderr, "Inserting %d documents into %s.%s with seed %d\n", g.Count, database, g.Collection, g.Seed)
+	result, err := generate.Run(ctx, collection, template, g.Options)
+	fmt.Fprintf(os.Stderr, "%d documents inserted, %d rejected\n", result.Inserted, len(result.Failures))
+	return err
+}
+
+// loadTemplate reads the Extended JSON document of a YAML or JSON file.
+func loadTemplate(path string) (bson.D, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read template: %w", err)
+	}
+	if ext := filepath.Ext(path); ext != ".json" {
+		if data, err = yaml.YAMLToJSON(data); err != nil {
+			return nil, fmt.Errorf("unable to parse template %s: %w", path, err)
+		}
+	}
+	doc := bson.D{}
+	if err := bson.UnmarshalExtJSON(data, false, &doc); err != nil {
+		return nil, fmt.Errorf("template %s is not an Extended JSON document: %w", path, err)
+	}
+	return doc, nil
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/client"
+	"github.com/bradmwilliams/mongodb-client/pkg/geo"
+	"github.com/spf13/cobra"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mongoOptions "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type geoOptions struct {
+	Database   string
+	Collection string
+	Field      string
+	Filter     string
+	Limit      int64
+}
+
+func newGeoCommand(o *options) *cobra.Command {
+	g := &geoOptions{Field: "location"}
+	cmd := &cobra.Command{
+		Use:   "geo",
+		Short: "Index, query and validate the GeoJSON locations of a collection",
+		Long: `Query the documents of a collection by the GeoJSON geometry of a field indexed by a 2dsphere
+index. Geometries are read from GeoJSON files holding a geometry, a Feature or a FeatureCollection,
+whose polygons are combined into a MultiPolygon. Positions are [longitude, latitude].`,
+	}
+	persistent := cmd.PersistentFlags()
+	persistent.StringVar(&g.Database, "db", g.Database, "Database of the collection, defaults to MONGODB_DATABASE")
+	persistent.StringVar(&g.Collection, "collection", g.Collection, "Collection holding the locations")
+	persistent.StringVar(&g.Field, "field", g.Field, "Field holding the GeoJSON locations")
+	cmd.MarkPersistentFlagRequired("collection")
+	cmd.AddCommand(newGeoIndexCommand(o, g))
+	cmd.AddCommand(newGeoNearCommand(o, g))
+	cmd.AddCommand(newGeoQueryCommand(o, g, "within", "Print the documents located entirely within the polygons of a GeoJSON file", geo.Within))
+	cmd.AddCommand(newGeoQueryCommand(o, g, "intersects", "Print the documents whose location intersects the geometry of a GeoJSON file", geo.Intersects))
+	cmd.AddCommand(newGeoValidateCommand(o, g))
+	return cmd
+}
+
+// collection connects to the database and returns the collection of the options.
+func (g *geoOptions) collection(o *options) (*client.ConnectionManager, *mongo.Collection, error) {
+	manager, err := o.connect()
+	if err != nil {
+		return nil, nil, err
+	}
+	database := g.Database
+	if len(database) == 0 {
+		database = manager.Database()
+	}
+	return manager, manager.Primary().Database(database).Collection(g.Collection), nil
+}
+
+func newGeoIndexCommand(o *options, g *geoOptions) *cobra.Command {
+	var name string
+	var sparse bool
+	cmd := &cobra.Command{
+		Use:   "index",
+		Short: "Create the 2dsphere index of the location field",
+		Long: `Create the 2dsphere index required by near queries on the location field. The server rejects
+the documents whose location is not valid GeoJSON once it exists, the validate command finds those
+that would prevent the index from being built.`,
+		Example: `  mongodb-client geo index --collection venues --field location`,
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			manager, collection, err := g.collection(o)
+			if err != nil {
+				return err
+			}
+			defer disconnect(manager)
+			indexOptions := mongoOptions.Index()
+			if len(name) > 0 {
+				indexOptions.SetName(name)
+			}
+			if sparse {
+				indexOptions.SetSparse(true)
+			}
+			// Index builds take as long as the collection is large.
+			ctx, cancel := cmdContext()
+			defer cancel()
+			created, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{Key: g.Field, Value: "2dsphere"}}, Options: indexOptions})
+			if err != nil {
+				return fmt.Errorf("unable to create the 2dsphere index of %s.%s: %w", collection.Database().Name(), collection.Name(), err)
+			}
+			fmt.Fprintf(os.Stderr, "Created index %s of %s.%s\n", created, collection.Database().Name(), collection.Name())
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&name, "name", name, "Name of the index, defaults to the name the server gives to the key")
+	cmd.Flags().BoolVar(&sparse, "sparse", sparse, "Leave the documents without a location out of the index")
+	return cmd
+}
+
+func newGeoNearCommand(o *options, g *geoOptions) *cobra.Command {
+	var point, file, distanceField string
+	var minDistance, maxDistance float64
+	cmd := &cobra.Command{
+		Use:   "near",
+		Short: "Print the documents nearest to a point, with their distance in meters",
+		Long: `Print the documents nearest to the point of --point, or of the GeoJSON file --geometry, nearest
+first, with their distance in meters in --distance-field. The location field must have a 2dsphere
+index.`,
+		Example: `  mongodb-client geo near --collection venues --point -73.9857,40.7484 --max-distance 500
+  mongodb-client geo near --collection venues --geometry office.geojson --filter '{"open":true}' --limit 5`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			if (len(point) == 0) == (len(file) == 0) {
+				return fmt.Errorf("one of --point and --geometry is required")
+			}
+			if minDistance < 0 || maxDistance < 0 || (maxDistance > 0 && minDistance > maxDistance) {
+				return fmt.Errorf("--min-distance and --max-distance must not be negative, and --min-distance not greater than --max-distance")
+			}
+			var near geo.Geometry
+			if len(point) > 0 {
+				var err error
+				if near, err = parsePoint(point); err != nil {
+					return fmt.Errorf("--point: %w", err)
+				}
+			} else {
+				var err error
+				if near, err = geo.Load(file); err != nil {
+					return err
+				}
+				if near.Type != "Point" {
+					return fmt.Errorf("--geometry must hold a Point, not a %s", near.Type)
+				}
+			}
+			filter, err := parseDocument(g.Filter)
+			if err != nil {
+				return fmt.Errorf("--filter: %w", err)
+			}
+			pipeline := geo.Near(g.Field, near, minDistance, maxDistance, filter, distanceField)
+			if g.Limit > 0 {
+				pipeline = append(pipeline, bson.D{{Key: "$limit", Value: g.Limit}})
+			}
+
+			manager, collection, err := g.collection(o)
+			if err != nil {
+				return err
+			}
+			defer disconnect(manager)
+			ctx, cancel := manager.Context()
+			defer cancel()
+			cursor, err := collection.Aggregate(ctx, pipeline)
+			if err != nil {
+				return err
+			}
+			return o.printCursor(ctx, cursor, nil)
+		},
+	}
+	flagset := cmd.Flags()
+	flagset.StringVar(&point, "point", point, "Point as longitude,latitude")
+	flagset.StringVar(&file, "geometry", file, "GeoJSON file holding the point instead of --point")
+	flagset.Float64Var(&minDistance, "min-distance", minDistance, "Minimum distance from the point in meters")
+	flagset.Float64Var(&maxDistance, "max-distance", maxDistance, "Maximum distance from the point in meters (0 means no limit)")
+	flagset.StringVar(&distanceField, "distance-field", "distance", "Field of the results holding their distance in meters")
+	flagset.StringVar(&g.Filter, "filter", g.Filter, "Extended JSON query filter selecting the documents among those near the point")
+	flagset.Int64Var(&g.Limit, "limit", 0, "Maximum number of documents to return (0 means no limit)")
+	return cmd
+}
+
+// parsePoint parses a point written longitude,latitude.
+func parsePoint(value string) (geo.Geometry, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) != 2 {
+		return geo.Geometry{}, fmt.Errorf("%q must be longitude,latitude", value)
+	}
+	lng, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	if err != nil {
+		return geo.Geometry{}, fmt.Errorf("invalid longitude: %w", err)
+	}
+	lat, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err != nil {
+		return geo.Geometry{}, fmt.Errorf("invalid latitude: %w", err)
+	}
+	point := geo.Point(lng, lat)
+	return point, point.Validate()
+}
+
+func newGeoQueryCommand(o *options, g *geoOptions, use, short string, operator func(field string, geometry geo.Geometry) bson.D) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   use + " GEOJSON",
+		Short: short,
+		Example: fmt.Sprintf(`  mongodb-client geo %s --collection venues manhattan.geojson
+  mongodb-client geo %s --collection venues --filter '{"open":true}' neighborhoods.geojson`, use, use),
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			file := arguments[0]
+			geometry, err := geo.Load(file)
+			if err != nil {
+				return err
+			}
+			if use == "within" && geometry.Type != "Polygon" && geometry.Type != "MultiPolygon" {
+				return fmt.Errorf("%s must hold polygons, not a %s", file, geometry.Type)
+			}
+			filter, err := parseDocument(g.Filter)
+			if err != nil {
+				return fmt.Errorf("--filter: %w", err)
+			}
+			filter = append(operator(g.Field, geometry), filter...)
+
+			manager, collection, err := g.collection(o)
+			if err != nil {
+				return err
+			}
+			defer disconnect(manager)
+			ctx, cancel := manager.Context()
+			defer cancel()
+			cursor, err := collection.Find(ctx, filter, mongoOptions.Find().SetLimit(g.Limit))
+			if err != nil {
+				return err
+			}
+			return o.printCursor(ctx, cursor, nil)
+		},
+	}
+	cmd.Flags().StringVar(&g.Filter, "filter", g.Filter, "Extended JSON query filter selecting the documents among those matching the geometry")
+	cmd.Flags().Int64Var(&g.Limit, "limit", 0, "Maximum number of documents to return (0 means no limit)")
+	return cmd
+}
+
+func newGeoValidateCommand(o *options, g *geoOptions) *cobra.Command {
+	var missing bool
+	cmd := &cobra.Command{
+		Use:   "validate",
+		Short: "Report the documents whose location is not valid GeoJSON",
+		Long: `Read the location of every document and print the _id of those whose location is not valid
+GeoJSON, or is outside of the longitudes and latitudes of the Earth, with the reason. These documents
+are rejected by 2dsphere indexes. Documents without a location are only reported with --missing. The
+command fails when it reports documents.`,
+		Example: `  mongodb-client geo validate --collection venues
+  mongodb-client geo validate --collection venues --missing --filter '{"type":"store"}'`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			filter, err := parseDocument(g.Filter)
+			if err != nil {
+				return fmt.Errorf("--filter: %w", err)
+			}
+			manager, collection, err := g.collection(o)
+			if err != nil {
+				return err
+			}
+			defer disconnect(manager)
+			// The whole collection is read, so the scan is bounded by interruption rather than the operation timeout.
+			ctx, cancel := cmdContext()
+			defer cancel()
+			cursor, err := collection.Find(ctx, filter, mongoOptions.Find().SetProjection(bson.D{{Key: g.Field, Value: 1}}))
+			if err != nil {
+				return err
+			}
+			var checked, invalid int64
+			err = client.Each(ctx, cursor, func(doc bson.Raw) error {
+				checked++
+				value, err := doc.LookupErr(strings.Split(g.Field, ".")...)
+				if err != nil {
+					if !missing {
+						return nil
+					}
+					err = fmt.Errorf("no %s", g.Field)
+				} else {
+					err = geo.ValidateValue(value)
+				}
+				if err != nil {
+					invalid++
+					fmt.Fprintf(os.Stdout, "%s\t%v\n", doc.Lookup("_id"), err)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "%d of %d documents of %s.%s have an invalid %s\n", invalid, checked, collection.Database().Name(), collection.Name(), g.Field)
+			if invalid > 0 {
+				return fmt.Errorf("found %d invalid locations", invalid)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&missing, "missing", missing, "Also report the documents without a location")
+	cmd.Flags().StringVar(&g.Filter, "filter", g.Filter, "Extended JSON query filter selecting the documents to validate")
+	return cmd
+}
@@ -3,9 +3,19 @@ module github.com/bradmwilliams/mongodb-client
 go 1.16
 
 require (
+	github.com/gorilla/websocket v1.5.0
+	github.com/graphql-go/graphql v0.8.1
+	github.com/nats-io/nats.go v1.20.0
 	github.com/prometheus/client_golang v1.11.0
+	github.com/segmentio/kafka-go v0.4.39
+	github.com/shopspring/decimal v1.3.1
 	github.com/spf13/cobra v1.2.1
+	github.com/spf13/pflag v1.0.5
 	go.mongodb.org/mongo-driver v1.7.0
+	golang.org/x/crypto v0.0.0-20220622213112-05595931fe9d
+	google.golang.org/grpc v1.47.0
+	google.golang.org/protobuf v1.28.1
 	k8s.io/apimachinery v0.21.3
 	k8s.io/klog v1.0.0
+	sigs.k8s.io/yaml v1.2.0
 )
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/auth"
+	"github.com/bradmwilliams/mongodb-client/pkg/client"
+	"github.com/bradmwilliams/mongodb-client/pkg/config"
+	"github.com/bradmwilliams/mongodb-client/pkg/graphql"
+)
+
+// graphqlHandler returns the GraphQL handler of the collections of cfg, and the handler to serve
+// it with: requiring authentication and checking the roles of the caller per database unless
+// authenticator is nil.
+func graphqlHandler(manager *client.ConnectionManager, cfg map[string]config.GraphQLCollectionConfig, authenticator *auth.Authenticator) (*graphql.Handler, http.Handler) {
+	collections := make([]graphql.Collection, 0, len(cfg))
+	for name, c := range cfg {
+		collections = append(collections, graphql.Collection{
+			Name:       name,
+			Database:   c.Database,
+			Collection: c.Collection,
+			Fields:     c.Fields,
+			SampleSize: c.SampleSize,
+		})
+	}
+	handler := graphql.NewHandler(manager, collections)
+	if authenticator == nil {
+		return handler, handler
+	}
+	handler.Authorize = func(ctx context.Context, database string, write bool) bool {
+		identity := auth.FromContext(ctx)
+		return identity != nil && authenticator.Authorize(identity, auth.Permission{Database: database, Write: write})
+	}
+	return handler, authenticator.Require(handler, nil)
+}
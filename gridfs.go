@@ -0,0 +1,380 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/gridfs"
+	"github.com/spf13/cobra"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type gridfsOptions struct {
+	Database string
+	Bucket   string
+}
+
+func newGridFSCommand(o *options) *cobra.Command {
+	g := &gridfsOptions{
+		Bucket: gridfs.DefaultBucket,
+	}
+	cmd := &cobra.Command{
+		Use:   "gridfs",
+		Short: "Upload, download, list and delete the files of a GridFS bucket",
+		Long: `Manage the files of a GridFS bucket, stored in the collections <bucket>.files and <bucket>.chunks of
+the database, with the credentials of the client.
+
+A file name may have several revisions, uploaded one after the other: get downloads the latest one
+unless --revision selects another, 0 being the first and -2 the one before the latest.`,
+		Example: `  mongodb-client gridfs put cover.png --metadata '{"podcast":"polyglot"}'
+  mongodb-client gridfs ls --prefix covers/
+  mongodb-client gridfs get covers/polyglot.png --output polyglot.png --resume
+  mongodb-client gridfs rm covers/polyglot.png`,
+	}
+	flagset := cmd.PersistentFlags()
+	flagset.StringVar(&g.Database, "db", g.Database, "Database of the bucket, defaults to MONGODB_DATABASE")
+	flagset.StringVar(&g.Bucket, "bucket", g.Bucket, "Name of the bucket")
+	cmd.AddCommand(newGridFSPutCommand(o, g))
+	cmd.AddCommand(newGridFSGetCommand(o, g))
+	cmd.AddCommand(newGridFSListCommand(o, g))
+	cmd.AddCommand(newGridFSRemoveCommand(o, g))
+	return cmd
+}
+
+// bucket returns the bucket of the options in the database of manager.
+func (g *gridfsOptions) bucket(o *options) (func(), *gridfs.Bucket, error) {
+	manager, err := o.connect()
+	if err != nil {
+		return nil, nil, err
+	}
+	database := g.Database
+	if len(database) == 0 {
+		database = manager.Database()
+	}
+	return func() { disconnect(manager) }, gridfs.NewBucket(manager.Primary().Database(database), g.Bucket), nil
+}
+
+type gridfsPutOptions struct {
+	Name      string
+	ID        string
+	ChunkSize int32
+	Metadata  string
+}
+
+func newGridFSPutCommand(o *options, g *gridfsOptions) *cobra.Command {
+	p := &gridfsPutOptions{}
+	cmd := &cobra.Command{
+		Use:   "put FILE",
+		Short: "Upload a local file, or standard input with -, as a new revision of a file",
+		Example: `  mongodb-client gridfs put cover.png --name covers/polyglot.png --chunk-size 1048576
+  tar cz assets | mongodb-client gridfs put - --name assets.tar.gz --metadata '{"release":"v2"}'`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			return p.run(o, g, arguments[0])
+		},
+	}
+	flagset := cmd.Flags()
+	flagset.StringVar(&p.Name, "name", p.Name, "Name of the file in the bucket, the base name of FILE by default")
+	flagset.StringVar(&p.ID, "id", p.ID, "Extended JSON _id of the file, a new ObjectID by default")
+	flagset.Int32Var(&p.ChunkSize, "chunk-size", p.ChunkSize, "Size of the chunks of the file in bytes, 261120 (255 KiB) by default")
+	flagset.StringVar(&p.Metadata, "metadata", p.Metadata, "Extended JSON document stored as the metadata of the file")
+	return cmd
+}
+
+func (p *gridfsPutOptions) run(o *options, g *gridfsOptions, path string) error {
+	if p.ChunkSize < 0 {
+		return fmt.Errorf("--chunk-size must not be negative")
+	}
+	name := p.Name
+	if len(name) == 0 {
+		if path == "-" {
+			return fmt.Errorf("--name is required to upload standard input")
+		}
+		name = filepath.Base(path)
+	}
+	metadata, err := parseDocument(p.Metadata)
+	if err != nil {
+		return fmt.Errorf("--metadata: %w", err)
+	}
+	putOptions := gridfs.PutOptions{ChunkSize: p.ChunkSize, Metadata: metadata}
+	if len(p.ID) > 0 {
+		if putOptions.ID, err = parseValue(p.ID); err != nil {
+			return fmt.Errorf("--id: %w", err)
+		}
+	}
+	in := io.Reader(os.Stdin)
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		in = file
+	}
+
+	done, bucket, err := g.bucket(o)
+	if err != nil {
+		return err
+	}
+	defer done()
+	ctx, cancel := cmdContext()
+	defer cancel()
+	id, written, err := bucket.Put(ctx, name, in, putOptions)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "uploaded %s, %d bytes\n", name, written)
+	return printDocument(os.Stdout, bson.D{{Key: "_id", Value: id}})
+}
+
+type gridfsGetOptions struct {
+	ID       string
+	Revision int32
+	Output   string
+	Resume   bool
+}
+
+func newGridFSGetCommand(o *options, g *gridfsOptions) *cobra.Command {
+	d := &gridfsGetOptions{
+		Revision: -1,
+	}
+	cmd := &cobra.Command{
+		Use:   "get NAME",
+		Short: "Download a revision of a file to a local file or standard output",
+		Long: `Download a revision of a file to --output, the base name of NAME by default, or to standard output
+with -. With --resume an existing output file is taken to be the beginning of the file, only the rest
+of which is downloaded and appended to it, so that an interrupted download can be continued.`,
+		Example: `  mongodb-client gridfs get covers/polyglot.png
+  mongodb-client gridfs get assets.tar.gz --revision 0 --output - | tar xz
+  mongodb-client gridfs get backups/large.archive --output large.archive --resume`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			return d.run(o, g, arguments[0])
+		},
+	}
+	flagset := cmd.Flags()
+	flagset.StringVar(&d.ID, "id", d.ID, "Extended JSON _id of the file to download instead of a revision of NAME")
+	flagset.Int32Var(&d.Revision, "revision", d.Revision, "Revision of the file, from 0 for the first upload or from -1 for the latest")
+	flagset.StringVarP(&d.Output, "output", "o", d.Output, "File to write, - for standard output")
+	flagset.BoolVar(&d.Resume, "resume", d.Resume, "Append the rest of the file to an existing output file instead of replacing it")
+	return cmd
+}
+
+func (d *gridfsGetOptions) run(o *options, g *gridfsOptions, name string) error {
+	output := d.Output
+	if len(output) == 0 {
+		output = filepath.Base(name)
+	}
+	if d.Resume && output == "-" {
+		return fmt.Errorf("--resume requires an output file")
+	}
+	done, bucket, err := g.bucket(o)
+	if err != nil {
+		return err
+	}
+	defer done()
+	ctx, cancel := cmdContext()
+	defer cancel()
+
+	var file gridfs.File
+	if len(d.ID) > 0 {
+		var id interface{}
+		if id, err = parseValue(d.ID); err != nil {
+			return fmt.Errorf("--id: %w", err)
+		}
+		file, err = bucket.FindID(ctx, id)
+	} else {
+		file, err = bucket.Find(ctx, name, d.Revision)
+	}
+	if errors.Is(err, gridfs.ErrNotFound) {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	out := os.Stdout
+	var offset int64
+	if output != "-" {
+		flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if d.Resume {
+			flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		}
+		if out, err = os.OpenFile(output, flags, 0644); err != nil {
+			return err
+		}
+		defer out.Close()
+		if d.Resume {
+			info, err := out.Stat()
+			if err != nil {
+				return err
+			}
+			if offset = info.Size(); offset > file.Length {
+				return fmt.Errorf("%s has %d bytes, more than the %d bytes of %s", output, offset, file.Length, file.Name)
+			}
+		}
+	}
+	written, err := bucket.Download(ctx, file, out, offset)
+	if err != nil {
+		return fmt.Errorf("download of %s stopped after %d bytes: %w", file.Name, offset+written, err)
+	}
+	if output != "-" {
+		if err := out.Close(); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(os.Stderr, "downloaded %s, %d of %d bytes\n", file.Name, written, file.Length)
+	return nil
+}
+
+type gridfsListOptions struct {
+	Prefix string
+	Filter string
+}
+
+func newGridFSListCommand(o *options, g *gridfsOptions) *cobra.Command {
+	l := &gridfsListOptions{}
+	cmd := &cobra.Command{
+		Use:     "ls",
+		Aliases: []string{"list"},
+		Short:   "List the files of the bucket with all their revisions",
+		Example: `  mongodb-client gridfs ls --prefix covers/
+  mongodb-client gridfs ls --filter '{"metadata.podcast":"polyglot"}' -o json`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			return l.run(o, g)
+		},
+	}
+	flagset := cmd.Flags()
+	flagset.StringVar(&l.Prefix, "prefix", l.Prefix, "Only list the files whose name starts with this prefix")
+	flagset.StringVar(&l.Filter, "filter", l.Filter, "Extended JSON query filter of the documents of the files collection")
+	addJSONFlag(flagset, o)
+	return cmd
+}
+
+// gridfsFile is a file as printed by --output.
+type gridfsFile struct {
+	ID         json.RawMessage `json:"_id"`
+	Name       string          `json:"filename"`
+	Length     int64           `json:"length"`
+	ChunkSize  int32           `json:"chunkSize"`
+	UploadDate time.Time       `json:"uploadDate"`
+	Metadata   json.RawMessage `json:"metadata,omitempty"`
+}
+
+func (l *gridfsListOptions) run(o *options, g *gridfsOptions) error {
+	filter, err := parseDocument(l.Filter)
+	if err != nil {
+		return fmt.Errorf("--filter: %w", err)
+	}
+	if len(l.Prefix) > 0 {
+		filter = append(filter, bson.E{Key: "filename", Value: primitive.Regex{Pattern: "^" + regexp.QuoteMeta(l.Prefix)}})
+	}
+	out, err := o.printer()
+	if err != nil {
+		return err
+	}
+	done, bucket, err := g.bucket(o)
+	if err != nil {
+		return err
+	}
+	defer done()
+	ctx, cancel := cmdContext()
+	defer cancel()
+	files, err := bucket.Files(ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	listed := make([]gridfsFile, 0, len(files))
+	for _, file := range files {
+		listing := gridfsFile{ID: json.RawMessage(extJSON(file.ID)), Name: file.Name, Length: file.Length, ChunkSize: file.ChunkSize, UploadDate: file.UploadDate}
+		if len(file.Metadata) > 0 {
+			listing.Metadata = json.RawMessage(extJSON(bson.RawValue{Type: bson.TypeEmbeddedDocument, Value: file.Metadata}))
+		}
+		listed = append(listed, listing)
+	}
+	return out.print(os.Stdout, listed, func(stdout io.Writer) error {
+		w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tID\tLENGTH\tCHUNK SIZE\tUPLOADED")
+		for _, file := range files {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\n", file.Name, extJSON(file.ID), file.Length, file.ChunkSize, file.UploadDate.Format(time.RFC3339))
+		}
+		return w.Flush()
+	})
+}
+
+type gridfsRemoveOptions struct {
+	ID string
+}
+
+func newGridFSRemoveCommand(o *options, g *gridfsOptions) *cobra.Command {
+	r := &gridfsRemoveOptions{}
+	cmd := &cobra.Command{
+		Use:     "rm [NAME]",
+		Aliases: []string{"delete"},
+		Short:   "Delete every revision of a file, or the file of --id",
+		Example: `  mongodb-client gridfs rm covers/polyglot.png
+  mongodb-client gridfs rm --id '{"$oid":"5f9b3b3b9d3b3b3b3b3b3b3b"}'`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			if (len(arguments) == 0) == (len(r.ID) == 0) {
+				return fmt.Errorf("exactly one of NAME or --id is required")
+			}
+			var name string
+			if len(arguments) > 0 {
+				name = arguments[0]
+			}
+			return r.run(o, g, name)
+		},
+	}
+	cmd.Flags().StringVar(&r.ID, "id", r.ID, "Extended JSON _id of the file to delete")
+	return cmd
+}
+
+func (r *gridfsRemoveOptions) run(o *options, g *gridfsOptions, name string) error {
+	var id interface{}
+	if len(r.ID) > 0 {
+		var err error
+		if id, err = parseValue(r.ID); err != nil {
+			return fmt.Errorf("--id: %w", err)
+		}
+	}
+	done, bucket, err := g.bucket(o)
+	if err != nil {
+		return err
+	}
+	defer done()
+	ctx, cancel := cmdContext()
+	defer cancel()
+
+	if id != nil {
+		if err := bucket.Delete(ctx, id); err != nil {
+			return fmt.Errorf("%s: %w", r.ID, err)
+		}
+		fmt.Fprintf(os.Stderr, "deleted %s\n", r.ID)
+		return nil
+	}
+	files, err := bucket.Files(ctx, bson.D{{Key: "filename", Value: name}})
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("%s: %w", name, gridfs.ErrNotFound)
+	}
+	for _, file := range files {
+		if err := bucket.Delete(ctx, file.ID); err != nil && !errors.Is(err, gridfs.ErrNotFound) {
+			return fmt.Errorf("%s %s: %w", name, extJSON(file.ID), err)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "deleted %d revisions of %s\n", len(files), name)
+	return nil
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"crypto/tls"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/auth"
+	"github.com/bradmwilliams/mongodb-client/pkg/client"
+	"github.com/bradmwilliams/mongodb-client/pkg/rpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// grpcServer returns a server of the Documents service, requiring the roles of authenticator and
+// TLS unless they are nil, that refuses to empty the protected collections.
+func (o *options) grpcServer(manager *client.ConnectionManager, authenticator *auth.Authenticator, tlsConfig *tls.Config) *grpc.Server {
+	documents := rpc.NewServer(manager)
+	documents.CheckEmpty = o.checkProtected
+	var serverOptions []grpc.ServerOption
+	if tlsConfig != nil {
+		serverOptions = append(serverOptions, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	if authenticator != nil {
+		serverOptions = append(serverOptions, documents.Authorization(authenticator)...)
+	}
+	server := grpc.NewServer(serverOptions...)
+	rpc.RegisterDocumentsServer(server, documents)
+	return server
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/api"
+	"github.com/bradmwilliams/mongodb-client/pkg/auth"
+	"github.com/bradmwilliams/mongodb-client/pkg/client"
+	"github.com/bradmwilliams/mongodb-client/pkg/config"
+	"github.com/bradmwilliams/mongodb-client/pkg/tenancy"
+	"github.com/bradmwilliams/mongodb-client/pkg/ws"
+)
+
+// httpAuthenticator returns the authenticator of the users, OIDC provider and roles of cfg.
+func httpAuthenticator(cfg *config.HTTPConfig) (*auth.Authenticator, error) {
+	users := make([]auth.User, 0, len(cfg.Users))
+	for _, user := range cfg.Users {
+		users = append(users, auth.User{
+			Name:         user.Name,
+			PasswordHash: user.PasswordHash,
+			TokenSHA256:  user.TokenSHA256,
+			Roles:        user.Roles,
+			Tenant:       user.Tenant,
+		})
+	}
+	roles := make(map[string]auth.Role, len(cfg.Roles))
+	for name, role := range cfg.Roles {
+		roles[name] = auth.Role{Read: role.Read, ReadWrite: role.ReadWrite, Metrics: role.Metrics, Debug: role.Debug}
+	}
+	var verifier *auth.OIDCVerifier
+	if cfg.OIDC != nil {
+		var err error
+		if verifier, err = auth.NewOIDCVerifier(cfg.OIDC.IssuerURL, cfg.OIDC.Audience, cfg.OIDC.UsernameClaim, cfg.OIDC.RolesClaim, cfg.OIDC.TenantClaim); err != nil {
+			return nil, err
+		}
+	}
+	return auth.NewAuthenticator(users, verifier, roles)
+}
+
+// securitySchemes returns the OpenAPI security schemes of the credentials accepted by cfg.
+func securitySchemes(cfg *config.HTTPConfig) map[string]api.SecurityScheme {
+	if cfg == nil {
+		return nil
+	}
+	schemes := map[string]api.SecurityScheme{}
+	for _, user := range cfg.Users {
+		if len(user.PasswordHash) > 0 {
+			schemes["basic"] = api.SecurityScheme{Type: "http", Scheme: "basic"}
+		}
+		if len(user.TokenSHA256) > 0 {
+			schemes["bearer"] = api.SecurityScheme{Type: "http", Scheme: "bearer"}
+		}
+	}
+	if cfg.OIDC != nil {
+		schemes["bearer"] = api.SecurityScheme{Type: "http", Scheme: "bearer", BearerFormat: "JWT"}
+		schemes["oidc"] = api.SecurityScheme{
+			Type:             "openIdConnect",
+			OpenIDConnectURL: strings.TrimSuffix(cfg.OIDC.IssuerURL, "/") + "/.well-known/openid-configuration",
+		}
+	}
+	return schemes
+}
+
+// apiPermission requires read access to the database of the request for GET and HEAD, read-write
+// access otherwise. Requests for malformed paths only need to be authenticated, the API rejects them.
+func apiPermission(r *http.Request) auth.Permission {
+	resource, _ := api.ParseResource(r.URL.Path)
+	return auth.Permission{
+		Database: resource.Database,
+		Write:    r.Method != http.MethodGet && r.Method != http.MethodHead,
+	}
+}
+
+// watchPermission requires read access to the database of the watched collection.
+func watchPermission(r *http.Request) auth.Permission {
+	database, _, _ := ws.ParseNamespace(r.URL.Path)
+	return auth.Permission{Database: database}
+}
+
+func metricsPermission(*http.Request) auth.Permission {
+	return auth.Permission{Metrics: true}
+}
+
+func debugPermission(*http.Request) auth.Permission {
+	return auth.Permission{Debug: true}
+}
+
+// apiTenants returns the resolution of the tenants of the API configured by cfg, nil when the API
+// serves no tenants.
+func apiTenants(cfg *config.TenancyConfig) (*api.Tenants, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	t, err := tenancy.New(tenancy.Strategy(cfg.Strategy))
+	if err != nil {
+		return nil, fmt.Errorf("invalid tenancy configuration: %w", err)
+	}
+	return &api.Tenants{Tenancy: t, Resolve: requestTenant}, nil
+}
+
+// requestTenant returns the tenant of the caller of r: the tenant it is bound to, which the tenant
+// header may only repeat, or the tenant header for the callers bound to none.
+func requestTenant(r *http.Request) (string, error) {
+	header := r.Header.Get(api.TenantHeader)
+	if identity := auth.FromContext(r.Context()); identity != nil && len(identity.Tenant) > 0 {
+		if len(header) > 0 && header != identity.Tenant {
+			return "", fmt.Errorf("%s is bound to the tenant %s", identity.Name, identity.Tenant)
+		}
+		return identity.Tenant, nil
+	}
+	return header, nil
+}
+
+// openAPIHandler returns the handler of the OpenAPI document, requiring authentication and only
+// describing the databases readable by the caller unless authenticator is nil, and those of the
+// tenant of the caller unless tenants is nil.
+func openAPIHandler(manager *client.ConnectionManager, cfg *config.HTTPConfig, authenticator *auth.Authenticator, tenants *api.Tenants) http.Handler {
+	handler := api.NewOpenAPIHandler(manager, securitySchemes(cfg))
+	handler.Tenants = tenants
+	if authenticator == nil {
+		return handler
+	}
+	handler.Readable = func(ctx context.Context, database string) bool {
+		identity := auth.FromContext(ctx)
+		return identity != nil && authenticator.Authorize(identity, auth.Permission{Database: database})
+	}
+	return authenticator.Require(handler, nil)
+}
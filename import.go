@@ -0,0 +1,401 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/bulk"
+	"github.com/bradmwilliams/mongodb-client/pkg/client"
+	"github.com/spf13/cobra"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type importOptions struct {
+	Database        string
+	Collection      string
+	File            string
+	Type            string
+	Fields          string
+	HeaderLine      bool
+	Mode            string
+	UpsertFields    []string
+	BatchSize       int
+	Workers         int
+	StopOnError     bool
+	Drop            bool
+	UseTransactions bool
+}
+
+func newImportCommand(o *options) *cobra.Command {
+	i := &importOptions{
+		Type:         "json",
+		Mode:         writeModeInsert,
+		UpsertFields: []string{"_id"},
+		BatchSize:    1000,
+		Workers:      1,
+	}
+	cmd := &cobra.Command{
+		Use:   "import",
+		Short: "Load NDJSON, JSON array or CSV data into a collection",
+		Example: `  mongodb-client import --collection episodes --file episodes.ndjson --workers 4
+  mongodb-client import --collection episodes --type csv --headerline --file episodes.csv \
+    --fields 'title.string(),duration.int32(),published.date(2006-01-02)' --mode upsert --upsert-fields title`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			return i.run(o)
+		},
+	}
+
+	flagset := cmd.Flags()
+	flagset.StringVar(&i.Database, "db", i.Database, "Database to import into, defaults to MONGODB_DATABASE")
+	flagset.StringVar(&i.Collection, "collection", i.Collection, "Collection to import into")
+	flagset.StringVar(&i.File, "file", i.File, "File to import, defaults to standard input")
+	flagset.StringVar(&i.Type, "type", i.Type, "Input type: json (NDJSON or a JSON array) or csv")
+	flagset.StringVar(&i.Fields, "fields", i.Fields, "Comma-separated csv columns, each optionally typed as name.type(), e.g. 'name.string(),age.int32()'")
+	flagset.BoolVar(&i.HeaderLine, "headerline", i.HeaderLine, "Use the first csv line as the column spec, ignored for columns given by --fields")
+	flagset.StringVar(&i.Mode, "mode", i.Mode, "Write mode: insert, upsert (replace matching documents) or merge (set fields of matching documents)")
+	flagset.StringSliceVar(&i.UpsertFields, "upsert-fields", i.UpsertFields, "Fields identifying the existing document in upsert and merge mode")
+	flagset.IntVar(&i.BatchSize, "batch-size", i.BatchSize, "Number of documents written per request")
+	flagset.IntVar(&i.Workers, "workers", i.Workers, "Number of batches written concurrently")
+	flagset.BoolVar(&i.StopOnError, "stop-on-error", i.StopOnError, "Stop the import at the first batch that fails")
+	flagset.BoolVar(&i.Drop, "drop", i.Drop, "Drop the collection before importing")
+	flagset.BoolVar(&i.UseTransactions, "use-transactions", i.UseTransactions, "Write every batch in a transaction, so that it is applied entirely or not at all, requires a replica set")
+	cmd.MarkFlagRequired("collection")
+	return cmd
+}
+
+// documentSource returns documents until io.EOF.
+type documentSource interface {
+	Next() (bson.D, error)
+}
+
+type importBatch struct {
+	number int
+	models []mongo.WriteModel
+}
+
+func (i *importOptions) run(o *options) error {
+	switch i.Mode {
+	case writeModeInsert, writeModeUpsert, writeModeMerge:
+	default:
+		return fmt.Errorf("--mode must be one of insert, upsert or merge")
+	}
+	if i.Mode != writeModeInsert && len(i.UpsertFields) == 0 {
+		return fmt.Errorf("--upsert-fields is required in %s mode", i.Mode)
+	}
+	if i.BatchSize < 1 || i.Workers < 1 {
+		return fmt.Errorf("--batch-size and --workers must be at least 1")
+	}
+
+	input := io.Reader(os.Stdin)
+	if len(i.File) > 0 && i.File != "-" {
+		file, err := os.Open(i.File)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		input = file
+	}
+
+	var source documentSource
+	switch i.Type {
+	case "json":
+		source = newDocumentReader(input)
+	case "csv":
+		reader, err := newCSVDocumentReader(input, i.Fields, i.HeaderLine)
+		if err != nil {
+			return err
+		}
+		source = reader
+	default:
+		return fmt.Errorf("--type must be json or csv")
+	}
+
+	manager, err := o.connect()
+	if err != nil {
+		return err
+	}
+	defer disconnect(manager)
+
+	database := i.Database
+	if len(database) == 0 {
+		database = manager.Database()
+	}
+	collection := manager.Primary().Database(database).Collection(i.Collection)
+	if i.Drop {
+		if err := o.checkProtected(database, i.Collection); err != nil {
+			return err
+		}
+		if err := o.confirm("Drop %s.%s before importing", database, i.Collection); err != nil {
+			return err
+		}
+		ctx, cancel := manager.ContextFor(client.WriteOperation)
+		err := collection.Drop(ctx)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("unable to drop %s.%s: %w", database, i.Collection, err)
+		}
+	}
+
+	ctx, cancel := cmdContext()
+	defer cancel()
+
+	batches := make(chan importBatch)
+	var summary bulk.Result
+	var failed int
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	for w := 0; w < i.Workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range batches {
+				result, err := i.write(ctx, manager, collection, batch.models)
+				lock.Lock()
+				summary.Add(result)
+				if err != nil {
+					failed++
+					fmt.Fprintf(os.Stderr, "batch %d (%d documents) failed: %v\n", batch.number, len(batch.models), err)
+					if i.StopOnError {
+						cancel()
+					}
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+
+	readErr := i.read(ctx, source, batches)
+	close(batches)
+	wg.Wait()
+
+	fmt.Fprintln(os.Stdout, summary)
+	if readErr != nil {
+		return readErr
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d batch(es) failed", failed)
+	}
+	return nil
+}
+
+// read splits the documents of source into batches until the input is exhausted or ctx is cancelled.
+func (i *importOptions) read(ctx context.Context, source documentSource, batches chan<- importBatch) error {
+	number := 1
+	models := make([]mongo.WriteModel, 0, i.BatchSize)
+	send := func() bool {
+		select {
+		case batches <- importBatch{number: number, models: models}:
+			number++
+			models = make([]mongo.WriteModel, 0, i.BatchSize)
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	for count := 1; ; count++ {
+		doc, err := source.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("document %d: %w", count, err)
+		}
+		model, err := writeModel(i.Mode, i.UpsertFields, doc)
+		if err != nil {
+			return fmt.Errorf("document %d: %w", count, err)
+		}
+		models = append(models, model)
+		if len(models) == i.BatchSize && !send() {
+			return nil
+		}
+	}
+	if len(models) > 0 {
+		send()
+	}
+	return nil
+}
+
+func (i *importOptions) write(ctx context.Context, manager *client.ConnectionManager, collection *mongo.Collection, models []mongo.WriteModel) (bulk.Result, error) {
+	// Batches are read at the size of --batch-size, so that each is written in a single request.
+	writeOptions := bulk.Options{BatchSize: len(models), Timeout: manager.Timeout(client.WriteOperation)}
+	if i.UseTransactions {
+		var result bulk.Result
+		err := manager.Txn(ctx, func(sess mongo.SessionContext) error {
+			var err error
+			writeOptions.Ordered = true
+			result, err = bulk.Write(sess, collection, models, writeOptions)
+			return err
+		})
+		if err != nil {
+			// Nothing of the batch was applied.
+			return bulk.Result{}, err
+		}
+		return result, nil
+	}
+	// Unordered writes let the server apply every valid document of a batch even if some fail.
+	if i.Mode != writeModeInsert {
+		writeOptions.Retry = manager.Retry
+	}
+	return bulk.Write(ctx, collection, models, writeOptions)
+}
+
+// csvColumn is a named csv column and the conversion of its cells into BSON values.
+type csvColumn struct {
+	path    []string
+	convert func(string) (interface{}, error)
+}
+
+// csvDocumentReader converts csv records into documents, dotted column names create nested documents.
+type csvDocumentReader struct {
+	reader  *csv.Reader
+	columns []csvColumn
+}
+
+func newCSVDocumentReader(r io.Reader, fields string, headerLine bool) (*csvDocumentReader, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+
+	var specs []string
+	if len(fields) > 0 {
+		specs = strings.Split(fields, ",")
+	}
+	if headerLine {
+		header, err := reader.Read()
+		if err != nil {
+			return nil, fmt.Errorf("unable to read csv header line: %w", err)
+		}
+		if len(specs) == 0 {
+			specs = append([]string(nil), header...)
+		}
+	}
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("csv input requires --fields or --headerline")
+	}
+
+	columns := make([]csvColumn, 0, len(specs))
+	for _, spec := range specs {
+		column, err := parseCSVColumn(strings.TrimSpace(spec))
+		if err != nil {
+			return nil, err
+		}
+		columns = append(columns, column)
+	}
+	return &csvDocumentReader{reader: reader, columns: columns}, nil
+}
+
+// parseCSVColumn parses a column spec such as "name", "age.int32()" or "created.date(2006-01-02)".
+func parseCSVColumn(spec string) (csvColumn, error) {
+	name, kind, argument := spec, "auto", ""
+	if strings.HasSuffix(spec, ")") {
+		if open := strings.LastIndex(spec, "("); open > 0 {
+			if dot := strings.LastIndex(spec[:open], "."); dot > 0 {
+				name, kind, argument = spec[:dot], spec[dot+1:open], spec[open+1:len(spec)-1]
+			}
+		}
+	}
+
+	column := csvColumn{path: strings.Split(name, ".")}
+	switch kind {
+	case "auto":
+		column.convert = autoValue
+	case "string":
+		column.convert = func(s string) (interface{}, error) { return s, nil }
+	case "int32":
+		column.convert = func(s string) (interface{}, error) {
+			v, err := strconv.ParseInt(s, 10, 32)
+			return int32(v), err
+		}
+	case "int64":
+		column.convert = func(s string) (interface{}, error) { return strconv.ParseInt(s, 10, 64) }
+	case "double":
+		column.convert = func(s string) (interface{}, error) { return strconv.ParseFloat(s, 64) }
+	case "decimal":
+		column.convert = func(s string) (interface{}, error) { return primitive.ParseDecimal128(s) }
+	case "boolean":
+		column.convert = func(s string) (interface{}, error) { return strconv.ParseBool(s) }
+	case "objectId":
+		column.convert = func(s string) (interface{}, error) { return primitive.ObjectIDFromHex(s) }
+	case "date":
+		layout := time.RFC3339
+		if len(argument) > 0 {
+			layout = argument
+		}
+		column.convert = func(s string) (interface{}, error) { return time.Parse(layout, s) }
+	default:
+		return csvColumn{}, fmt.Errorf("unsupported type %q in column spec %q", kind, spec)
+	}
+	return column, nil
+}
+
+// autoValue guesses the type of an untyped cell the way mongoimport does: numbers, booleans, then strings.
+func autoValue(s string) (interface{}, error) {
+	if v, err := strconv.ParseInt(s, 10, 32); err == nil {
+		return int32(v), nil
+	}
+	if v, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return v, nil
+	}
+	if v, err := strconv.ParseFloat(s, 64); err == nil {
+		return v, nil
+	}
+	if v, err := strconv.ParseBool(s); err == nil {
+		return v, nil
+	}
+	return s, nil
+}
+
+func (c *csvDocumentReader) Next() (bson.D, error) {
+	record, err := c.reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	doc := bson.D{}
+	for i, value := range record {
+		if i >= len(c.columns) {
+			return nil, fmt.Errorf("record has %d fields but only %d columns are defined", len(record), len(c.columns))
+		}
+		if len(value) == 0 {
+			// Empty cells are omitted rather than stored as empty strings.
+			continue
+		}
+		column := c.columns[i]
+		converted, err := column.convert(value)
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %w", strings.Join(column.path, "."), err)
+		}
+		doc = setPath(doc, column.path, converted)
+	}
+	return doc, nil
+}
+
+// setPath sets the value at the dotted path within doc, creating intermediate documents.
+func setPath(doc bson.D, path []string, value interface{}) bson.D {
+	for i, e := range doc {
+		if e.Key != path[0] {
+			continue
+		}
+		if len(path) == 1 {
+			doc[i].Value = value
+			return doc
+		}
+		nested, _ := e.Value.(bson.D)
+		doc[i].Value = setPath(nested, path[1:], value)
+		return doc
+	}
+	if len(path) == 1 {
+		return append(doc, bson.E{Key: path[0], Value: value})
+	}
+	return append(doc, bson.E{Key: path[0], Value: setPath(bson.D{}, path[1:], value)})
+}
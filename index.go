@@ -0,0 +1,443 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/client"
+	"github.com/bradmwilliams/mongodb-client/pkg/provision"
+	"github.com/spf13/cobra"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func newIndexCommand(o *options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "index",
+		Short: "List, create and drop indexes, or reconcile them with a manifest",
+	}
+	cmd.AddCommand(newIndexListCommand(o))
+	cmd.AddCommand(newIndexCreateCommand(o))
+	cmd.AddCommand(newIndexDropCommand(o))
+	cmd.AddCommand(newIndexSyncCommand(o))
+	return cmd
+}
+
+type indexListOptions struct {
+	Database   string
+	Collection string
+}
+
+func newIndexListCommand(o *options) *cobra.Command {
+	l := &indexListOptions{}
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the indexes of collections with their size and usage",
+		Long: `List the indexes of a collection, or of every collection of a database, with their keys and
+options, their size in bytes and the number of operations that used them since the server started
+or the index was built, as reported by $indexStats for the server the command runs on.`,
+		Example: `  mongodb-client index list --collection episodes
+  mongodb-client index list --db sampledb -o json`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			return l.run(o)
+		},
+	}
+
+	flagset := cmd.Flags()
+	flagset.StringVar(&l.Database, "db", l.Database, "Database of the collections, defaults to MONGODB_DATABASE")
+	flagset.StringVar(&l.Collection, "collection", l.Collection, "Collection whose indexes are listed, every collection of the database when empty")
+	addJSONFlag(flagset, o)
+	return cmd
+}
+
+// indexListing is an index with its statistics.
+type indexListing struct {
+	Collection         string          `json:"collection"`
+	Name               string          `json:"name"`
+	Keys               []string        `json:"keys"`
+	Unique             bool            `json:"unique,omitempty"`
+	Sparse             bool            `json:"sparse,omitempty"`
+	ExpireAfterSeconds *int32          `json:"expireAfterSeconds,omitempty"`
+	PartialFilter      json.RawMessage `json:"partialFilterExpression,omitempty"`
+	Size               int64           `json:"size"`
+	Ops                int64           `json:"ops"`
+	Since              *time.Time      `json:"since,omitempty"`
+}
+
+func (l *indexListOptions) run(o *options) error {
+	out, err := o.printer()
+	if err != nil {
+		return err
+	}
+	manager, err := o.connect()
+	if err != nil {
+		return err
+	}
+	defer disconnect(manager)
+
+	database := l.Database
+	if len(database) == 0 {
+		database = manager.Database()
+	}
+	db := manager.Primary().Database(database)
+
+	ctx, cancel := manager.ContextFor(client.AdminOperation)
+	defer cancel()
+	collections := []string{l.Collection}
+	if len(l.Collection) == 0 {
+		if collections, err = db.ListCollectionNames(ctx, bson.D{{Key: "type", Value: "collection"}}); err != nil {
+			return fmt.Errorf("unable to list the collections of %s: %w", database, err)
+		}
+		sort.Strings(collections)
+	}
+
+	var listings []indexListing
+	for _, name := range collections {
+		collectionListings, err := listIndexes(ctx, db.Collection(name))
+		if err != nil {
+			return err
+		}
+		listings = append(listings, collectionListings...)
+	}
+
+	return out.print(os.Stdout, listings, func(w io.Writer) error {
+		return printIndexes(w, listings)
+	})
+}
+
+// printIndexes writes listings to out as a table.
+func printIndexes(out io.Writer, listings []indexListing) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "COLLECTION\tNAME\tKEYS\tOPTIONS\tSIZE\tOPS\tSINCE")
+	for _, listing := range listings {
+		var indexOptions []string
+		if listing.Unique {
+			indexOptions = append(indexOptions, "unique")
+		}
+		if listing.Sparse {
+			indexOptions = append(indexOptions, "sparse")
+		}
+		if listing.ExpireAfterSeconds != nil {
+			indexOptions = append(indexOptions, fmt.Sprintf("ttl=%ds", *listing.ExpireAfterSeconds))
+		}
+		if len(listing.PartialFilter) > 0 {
+			indexOptions = append(indexOptions, "partial="+string(listing.PartialFilter))
+		}
+		since := "-"
+		if listing.Since != nil {
+			since = listing.Since.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d\t%s\n", listing.Collection, listing.Name, strings.Join(listing.Keys, ","), strings.Join(indexOptions, ","), listing.Size, listing.Ops, since)
+	}
+	return w.Flush()
+}
+
+// listIndexes returns the indexes of collection with their size from collStats and their usage
+// from $indexStats.
+func listIndexes(ctx context.Context, collection *mongo.Collection) ([]indexListing, error) {
+	namespace := collection.Database().Name() + "." + collection.Name()
+	indexes, err := provision.ListIndexes(ctx, collection)
+	if err != nil {
+		return nil, err
+	}
+
+	var stats struct {
+		IndexSizes map[string]int64 `bson:"indexSizes"`
+	}
+	if err := collection.Database().RunCommand(ctx, bson.D{{Key: "collStats", Value: collection.Name()}}).Decode(&stats); err != nil {
+		return nil, fmt.Errorf("unable to read the statistics of %s: %w", namespace, err)
+	}
+	cursor, err := collection.Aggregate(ctx, mongo.Pipeline{{{Key: "$indexStats", Value: bson.D{}}}})
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the index usage of %s: %w", namespace, err)
+	}
+	var usages []struct {
+		Name     string `bson:"name"`
+		Accesses struct {
+			Ops   int64     `bson:"ops"`
+			Since time.Time `bson:"since"`
+		} `bson:"accesses"`
+	}
+	if err := cursor.All(ctx, &usages); err != nil {
+		return nil, fmt.Errorf("unable to read the index usage of %s: %w", namespace, err)
+	}
+
+	listings := make([]indexListing, 0, len(indexes))
+	for _, index := range indexes {
+		listing := indexListing{
+			Collection:         collection.Name(),
+			Name:               index.Name,
+			Keys:               index.Keys(),
+			Unique:             index.Unique,
+			Sparse:             index.Sparse,
+			ExpireAfterSeconds: index.ExpireAfterSeconds,
+			Size:               stats.IndexSizes[index.Name],
+		}
+		if len(index.PartialFilterExpression) > 0 {
+			if listing.PartialFilter, err = bson.MarshalExtJSON(index.PartialFilterExpression, false, false); err != nil {
+				return nil, err
+			}
+		}
+		for _, usage := range usages {
+			if usage.Name == index.Name {
+				since := usage.Accesses.Since
+				listing.Ops, listing.Since = usage.Accesses.Ops, &since
+			}
+		}
+		listings = append(listings, listing)
+	}
+	return listings, nil
+}
+
+type indexCreateOptions struct {
+	Database   string
+	Collection string
+	Spec       string
+	Index      provision.Index
+	TTL        time.Duration
+	Partial    string
+}
+
+func newIndexCreateCommand(o *options) *cobra.Command {
+	c := &indexCreateOptions{}
+	cmd := &cobra.Command{
+		Use:   "create",
+		Short: "Create indexes from flags or a JSON spec",
+		Long: `Create an index described by flags, or the indexes of a JSON spec holding an index or an array
+of indexes in the notation of the provision manifest:
+
+  {"name": "recent", "keys": ["podcast", "-published"], "unique": true, "sparse": true,
+   "expireAfterSeconds": 86400, "partialFilterExpression": {"published": {"$exists": true}},
+   "background": true}
+
+Keys prefixed with - are descending, keys suffixed with :text, :2dsphere or :hashed have that type.`,
+		Example: `  mongodb-client index create --collection episodes --keys podcast,-published
+  mongodb-client index create --collection sessions --keys lastSeen --ttl 24h
+  mongodb-client index create --collection episodes --spec indexes.json`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			return c.run(o)
+		},
+	}
+
+	flagset := cmd.Flags()
+	flagset.StringVar(&c.Database, "db", c.Database, "Database of the collection, defaults to MONGODB_DATABASE")
+	flagset.StringVar(&c.Collection, "collection", c.Collection, "Collection to index")
+	flagset.StringVar(&c.Spec, "spec", c.Spec, "JSON index or array of indexes, or a file holding them, instead of the other index flags")
+	flagset.StringSliceVar(&c.Index.Keys, "keys", c.Index.Keys, "Keys of the index, such as podcast,-published or title:text")
+	flagset.StringVar(&c.Index.Name, "name", c.Index.Name, "Name of the index, defaults to the name the server gives to the keys")
+	flagset.BoolVar(&c.Index.Unique, "unique", c.Index.Unique, "Reject documents with the same keys")
+	flagset.BoolVar(&c.Index.Sparse, "sparse", c.Index.Sparse, "Leave the documents without the keys out of the index")
+	flagset.BoolVar(&c.Index.Background, "background", c.Index.Background, "Build the index without blocking the collection on servers before 4.2")
+	flagset.DurationVar(&c.TTL, "ttl", c.TTL, "Remove the documents this long after the date of their single key")
+	flagset.StringVar(&c.Partial, "partial-filter", c.Partial, "Extended JSON query document selecting the documents indexed")
+	cmd.MarkFlagRequired("collection")
+	return cmd
+}
+
+// indexes returns the indexes requested by the flags.
+func (c *indexCreateOptions) indexes() ([]provision.Index, error) {
+	if len(c.Spec) == 0 {
+		if len(c.Index.Keys) == 0 {
+			return nil, fmt.Errorf("--keys or --spec is required")
+		}
+		if c.TTL > 0 {
+			seconds := int32(c.TTL / time.Second)
+			c.Index.ExpireAfterSeconds = &seconds
+		}
+		if len(c.Partial) > 0 {
+			c.Index.PartialFilterExpression = json.RawMessage(c.Partial)
+		}
+		return []provision.Index{c.Index}, nil
+	}
+	if len(c.Index.Keys) > 0 || len(c.Index.Name) > 0 || c.TTL > 0 || len(c.Partial) > 0 {
+		return nil, fmt.Errorf("--spec cannot be combined with the other index flags")
+	}
+	data := []byte(c.Spec)
+	if trimmed := strings.TrimSpace(c.Spec); !strings.HasPrefix(trimmed, "{") && !strings.HasPrefix(trimmed, "[") {
+		var err error
+		if data, err = ioutil.ReadFile(c.Spec); err != nil {
+			return nil, fmt.Errorf("--spec: %w", err)
+		}
+	}
+	if trimmed := strings.TrimSpace(string(data)); strings.HasPrefix(trimmed, "{") {
+		data = []byte("[" + trimmed + "]")
+	}
+	var indexes []provision.Index
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&indexes); err != nil {
+		return nil, fmt.Errorf("--spec: %w", err)
+	}
+	return indexes, nil
+}
+
+func (c *indexCreateOptions) run(o *options) error {
+	indexes, err := c.indexes()
+	if err != nil {
+		return err
+	}
+	models := make([]mongo.IndexModel, 0, len(indexes))
+	for _, index := range indexes {
+		model, err := index.Model()
+		if err != nil {
+			return err
+		}
+		models = append(models, model)
+	}
+
+	manager, err := o.connect()
+	if err != nil {
+		return err
+	}
+	defer disconnect(manager)
+
+	database := c.Database
+	if len(database) == 0 {
+		database = manager.Database()
+	}
+	// Index builds take as long as the collection is large.
+	ctx, cancel := cmdContext()
+	defer cancel()
+	names, err := manager.Primary().Database(database).Collection(c.Collection).Indexes().CreateMany(ctx, models)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "Created index %s of %s.%s\n", name, database, c.Collection)
+	}
+	return nil
+}
+
+type indexDropOptions struct {
+	Database   string
+	Collection string
+	Names      []string
+}
+
+func newIndexDropCommand(o *options) *cobra.Command {
+	d := &indexDropOptions{}
+	cmd := &cobra.Command{
+		Use:     "drop",
+		Short:   "Drop indexes by name",
+		Example: `  mongodb-client index drop --collection episodes --name podcast_1_published_-1`,
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			return d.run(o)
+		},
+	}
+
+	flagset := cmd.Flags()
+	flagset.StringVar(&d.Database, "db", d.Database, "Database of the collection, defaults to MONGODB_DATABASE")
+	flagset.StringVar(&d.Collection, "collection", d.Collection, "Collection of the indexes")
+	flagset.StringSliceVar(&d.Names, "name", d.Names, "Names of the indexes to drop")
+	cmd.MarkFlagRequired("collection")
+	cmd.MarkFlagRequired("name")
+	return cmd
+}
+
+func (d *indexDropOptions) run(o *options) error {
+	for _, name := range d.Names {
+		if name == "_id_" || name == "*" {
+			return fmt.Errorf("--name %s cannot be dropped", name)
+		}
+	}
+	manager, err := o.connect()
+	if err != nil {
+		return err
+	}
+	defer disconnect(manager)
+
+	database := d.Database
+	if len(database) == 0 {
+		database = manager.Database()
+	}
+	if err := o.checkProtected(database, d.Collection); err != nil {
+		return err
+	}
+	if err := o.confirm("Drop the indexes %s of %s.%s", strings.Join(d.Names, ", "), database, d.Collection); err != nil {
+		return err
+	}
+	ctx, cancel := manager.ContextFor(client.AdminOperation)
+	defer cancel()
+	indexes := manager.Primary().Database(database).Collection(d.Collection).Indexes()
+	for _, name := range d.Names {
+		if _, err := indexes.DropOne(ctx, name); err != nil {
+			return fmt.Errorf("unable to drop index %s: %w", name, err)
+		}
+		fmt.Fprintf(os.Stderr, "Dropped index %s of %s.%s\n", name, database, d.Collection)
+	}
+	return nil
+}
+
+type indexSyncOptions struct {
+	Manifest string
+	Prune    bool
+	Check    bool
+}
+
+func newIndexSyncCommand(o *options) *cobra.Command {
+	s := &indexSyncOptions{}
+	cmd := &cobra.Command{
+		Use:   "sync",
+		Short: "Reconcile the indexes of collections with a manifest",
+		Long: `Create the indexes declared for the collections of a provision manifest, and recreate those
+whose keys or options differ, leaving the rest of the manifest aside. Every difference is printed,
+--check only reports them and fails when there are any, such as in a CI pipeline.`,
+		Example: `  mongodb-client index sync --manifest manifest.yaml --check
+  mongodb-client index sync --manifest manifest.yaml --prune`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			return s.run(o)
+		},
+	}
+
+	flagset := cmd.Flags()
+	flagset.StringVar(&s.Manifest, "manifest", s.Manifest, "YAML or JSON provision manifest declaring the indexes of collections")
+	flagset.BoolVar(&s.Prune, "prune", s.Prune, "Drop the indexes of the declared collections that are not declared")
+	flagset.BoolVar(&s.Check, "check", s.Check, "Only report the drift, failing when the indexes differ from the manifest")
+	cmd.MarkFlagRequired("manifest")
+	return cmd
+}
+
+func (s *indexSyncOptions) run(o *options) error {
+	manifest, err := provision.Load(s.Manifest)
+	if err != nil {
+		return err
+	}
+	manager, err := o.connect()
+	if err != nil {
+		return err
+	}
+	defer disconnect(manager)
+
+	ctx, cancel := cmdContext()
+	defer cancel()
+	var changes []provision.Change
+	for _, database := range manifest.Databases {
+		for _, collection := range database.Collections {
+			collectionChanges, err := provision.PlanIndexes(ctx, manager.Primary().Database(database.Name).Collection(collection.Name), collection.Indexes, s.Prune)
+			if err != nil {
+				return err
+			}
+			changes = append(changes, collectionChanges...)
+		}
+	}
+	if err := applyChanges(ctx, changes, s.Check, os.Stdout); err != nil {
+		return err
+	}
+	if s.Check && len(changes) > 0 {
+		return fmt.Errorf("%d indexes differ from the manifest", len(changes))
+	}
+	return nil
+}
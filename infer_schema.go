@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/client"
+	"github.com/bradmwilliams/mongodb-client/pkg/schema"
+	"github.com/spf13/cobra"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type inferSchemaOptions struct {
+	Database   string
+	Collection string
+	SampleSize int32
+	Format     string
+	TypeName   string
+}
+
+func newInferSchemaCommand(o *options) *cobra.Command {
+	i := &inferSchemaOptions{SampleSize: 1000}
+	cmd := &cobra.Command{
+		Use:   "infer-schema",
+		Short: "Infer the JSON Schema and Go structs of the documents of a collection",
+		Long: `Sample documents of a collection at random and infer their fields, with their types, whether they
+are present in every document and the structure of nested documents and arrays.
+
+--format json-schema prints a MongoDB JSON Schema that schema set and schema validate-data accept,
+--format go prints Go struct definitions with bson tags, fields that are missing from some
+documents or null are tagged omitempty. Both are printed by default.`,
+		Example: `  mongodb-client infer-schema --collection episodes --sample-size 5000
+  mongodb-client infer-schema --collection episodes --format json-schema > episodes.schema.json
+  mongodb-client infer-schema --collection podcasts --format go --type-name Podcast`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			return i.run(o)
+		},
+	}
+	flagset := cmd.Flags()
+	flagset.StringVar(&i.Database, "db", i.Database, "Database of the collection, defaults to MONGODB_DATABASE")
+	flagset.StringVar(&i.Collection, "collection", i.Collection, "Collection to sample")
+	flagset.Int32Var(&i.SampleSize, "sample-size", i.SampleSize, "Number of documents sampled")
+	flagset.StringVar(&i.Format, "format", i.Format, "Only print json-schema or go")
+	flagset.StringVar(&i.TypeName, "type-name", i.TypeName, "Name of the Go struct of the documents, defaults to the collection name in the singular")
+	cmd.MarkFlagRequired("collection")
+	return cmd
+}
+
+func (i *inferSchemaOptions) run(o *options) error {
+	if i.SampleSize < 1 {
+		return fmt.Errorf("--sample-size must be at least 1")
+	}
+	switch i.Format {
+	case "", "json-schema", "go":
+	default:
+		return fmt.Errorf("--format must be json-schema or go")
+	}
+	manager, err := o.connect()
+	if err != nil {
+		return err
+	}
+	defer disconnect(manager)
+	database := i.Database
+	if len(database) == 0 {
+		database = manager.Database()
+	}
+	ctx, cancel := cmdContext()
+	defer cancel()
+	cursor, err := manager.Primary().Database(database).Collection(i.Collection).Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$sample", Value: bson.D{{Key: "size", Value: i.SampleSize}}}},
+	})
+	if err != nil {
+		return fmt.Errorf("unable to sample %s.%s: %w", database, i.Collection, err)
+	}
+	// The sample is folded into the schema as it is read, so that a large one is not held in memory.
+	root := schema.New()
+	if err := client.Each(ctx, cursor, root.AddDocument); err != nil {
+		return fmt.Errorf("unable to sample %s.%s: %w", database, i.Collection, err)
+	}
+	if root.Seen == 0 {
+		return fmt.Errorf("%s.%s has no documents to infer a schema from", database, i.Collection)
+	}
+	fmt.Fprintf(os.Stderr, "Inferred the schema of %s.%s from %d documents\n", database, i.Collection, root.Seen)
+
+	if i.Format != "go" {
+		data, err := bson.MarshalExtJSON(root.JSONSchema(), false, false)
+		if err != nil {
+			return err
+		}
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, data, "", "  "); err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stdout, indented.String())
+	}
+	if i.Format != "json-schema" {
+		name := i.TypeName
+		if len(name) == 0 {
+			name = schema.TypeName(i.Collection)
+		}
+		source, err := root.GoStructs(name)
+		if err != nil {
+			return err
+		}
+		if len(i.Format) == 0 {
+			fmt.Fprintln(os.Stdout)
+		}
+		os.Stdout.Write(source)
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/bulk"
+	"github.com/bradmwilliams/mongodb-client/pkg/client"
+	"github.com/spf13/cobra"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type insertOptions struct {
+	Database   string
+	Collection string
+	File       string
+	UpsertOn   []string
+	BatchSize  int
+	Ordered    bool
+}
+
+func newInsertCommand(o *options) *cobra.Command {
+	i := &insertOptions{
+		BatchSize: 1000,
+		Ordered:   true,
+	}
+	cmd := &cobra.Command{
+		Use:   "insert",
+		Short: "Insert or upsert NDJSON or JSON array documents read from standard input or a file",
+		Example: `  mongodb-client insert --collection podcasts < podcasts.ndjson
+  mongodb-client insert --collection episodes --file episodes.json --upsert-on _id`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			return i.run(o)
+		},
+	}
+
+	flagset := cmd.Flags()
+	flagset.StringVar(&i.Database, "db", i.Database, "Database to write to, defaults to MONGODB_DATABASE")
+	flagset.StringVar(&i.Collection, "collection", i.Collection, "Collection to write to")
+	flagset.StringVar(&i.File, "file", i.File, "File containing the documents, defaults to standard input")
+	flagset.StringSliceVar(&i.UpsertOn, "upsert-on", i.UpsertOn, "Replace the existing document matching these fields instead of inserting, inserting it when there is no match")
+	flagset.IntVar(&i.BatchSize, "batch-size", i.BatchSize, "Number of documents written per request")
+	flagset.BoolVar(&i.Ordered, "ordered", i.Ordered, "Stop at the first failed write of a batch instead of continuing with the rest")
+	cmd.MarkFlagRequired("collection")
+	return cmd
+}
+
+func (i *insertOptions) run(o *options) error {
+	if i.BatchSize < 1 {
+		return fmt.Errorf("--batch-size must be at least 1")
+	}
+
+	input := io.Reader(os.Stdin)
+	if len(i.File) > 0 && i.File != "-" {
+		file, err := os.Open(i.File)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		input = file
+	}
+
+	manager, err := o.connect()
+	if err != nil {
+		return err
+	}
+	defer disconnect(manager)
+
+	database := i.Database
+	if len(database) == 0 {
+		database = manager.Database()
+	}
+	collection := manager.Primary().Database(database).Collection(i.Collection)
+
+	writeOptions := bulk.Options{Ordered: i.Ordered, BatchSize: i.BatchSize, Timeout: manager.Timeout(client.WriteOperation)}
+	if len(i.UpsertOn) > 0 {
+		// Replacements are idempotent, inserts are not since the driver generates a new _id on every attempt.
+		writeOptions.Retry = manager.Retry
+	}
+	writer := bulk.NewWriter(collection, writeOptions)
+	ctx := context.Background()
+	reader := newDocumentReader(input)
+	for count := 1; ; count++ {
+		doc, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("document %d: %w", count, err)
+		}
+		model, err := i.model(doc)
+		if err != nil {
+			return fmt.Errorf("document %d: %w", count, err)
+		}
+		if err := writer.Add(ctx, model); err != nil {
+			return fmt.Errorf("write failed (%s): %w", writer.Result(), err)
+		}
+	}
+	if err := writer.Flush(ctx); err != nil {
+		return fmt.Errorf("write failed (%s): %w", writer.Result(), err)
+	}
+
+	fmt.Fprintln(os.Stdout, writer.Result())
+	return nil
+}
+
+// model returns the write for doc, an insert or a replacement keyed on the --upsert-on fields.
+func (i *insertOptions) model(doc bson.D) (mongo.WriteModel, error) {
+	if len(i.UpsertOn) == 0 {
+		return writeModel(writeModeInsert, nil, doc)
+	}
+	return writeModel(writeModeUpsert, i.UpsertOn, doc)
+}
+
+const (
+	// writeModeInsert inserts every document.
+	writeModeInsert = "insert"
+	// writeModeUpsert replaces the document with the same key fields, inserting it if there is none.
+	writeModeUpsert = "upsert"
+	// writeModeMerge sets the fields of the document on the one with the same key fields, inserting it if there is none.
+	writeModeMerge = "merge"
+)
+
+// writeModel returns the bulk write for doc in the given mode, matching existing documents on keys.
+func writeModel(mode string, keys []string, doc bson.D) (mongo.WriteModel, error) {
+	if mode == writeModeInsert {
+		return mongo.NewInsertOneModel().SetDocument(doc), nil
+	}
+	filter := bson.D{}
+	for _, key := range keys {
+		value, ok := lookup(doc, key)
+		if !ok {
+			return nil, fmt.Errorf("key field %q is missing", key)
+		}
+		filter = append(filter, bson.E{Key: key, Value: value})
+	}
+	switch mode {
+	case writeModeUpsert:
+		return mongo.NewReplaceOneModel().SetFilter(filter).SetReplacement(doc).SetUpsert(true), nil
+	case writeModeMerge:
+		return mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(bson.D{{Key: "$set", Value: doc}}).SetUpsert(true), nil
+	}
+	return nil, fmt.Errorf("unknown write mode %q", mode)
+}
+
+// lookup returns the value of the top-level field key of doc.
+func lookup(doc bson.D, key string) (interface{}, bool) {
+	for _, e := range doc {
+		if e.Key == key {
+			return e.Value, true
+		}
+	}
+	return nil, false
+}
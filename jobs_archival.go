@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/archival"
+	"github.com/bradmwilliams/mongodb-client/pkg/config"
+	"github.com/bradmwilliams/mongodb-client/pkg/jobs"
+	"github.com/bradmwilliams/mongodb-client/pkg/objectstore"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// defaultArchiveTimeout bounds an archive run unless the config file sets a timeout.
+const defaultArchiveTimeout = time.Hour
+
+// archivalJobs returns a job for every archive of the config file, named "archive-<name>", the
+// archives without a database move the documents of database.
+func archivalJobs(cfg *config.Config, database string) ([]configuredJob, error) {
+	names := make([]string, 0, len(cfg.Archives))
+	for name := range cfg.Archives {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var archivalJobs []configuredJob
+	for _, name := range names {
+		policy, err := archivalPolicy(cfg, name, database)
+		if err != nil {
+			return nil, err
+		}
+		archivalJobs = append(archivalJobs, configuredJob{
+			spec: jobs.Spec{
+				Name:    "archive-" + name,
+				Timeout: defaultArchiveTimeout,
+				Handler: policy.Run,
+			},
+			config: cfg.Archives[name].JobConfig,
+		})
+	}
+	return archivalJobs, nil
+}
+
+// archivalPolicy returns the policy of the archive called name of the config file.
+func archivalPolicy(cfg *config.Config, name, database string) (*archival.Policy, error) {
+	archiveConfig, ok := cfg.Archives[name]
+	if !ok {
+		return nil, fmt.Errorf("no archive %s in the config file", name)
+	}
+	policy := &archival.Policy{
+		Name:         name,
+		Database:     archiveConfig.Database,
+		Collection:   archiveConfig.Collection,
+		Field:        archiveConfig.Field,
+		ToDatabase:   archiveConfig.ToDatabase,
+		ToCollection: archiveConfig.ToCollection,
+		ChunkSize:    archiveConfig.ChunkSize,
+	}
+	if len(policy.Database) == 0 {
+		policy.Database = database
+	}
+	if archiveConfig.MaxAge != nil {
+		policy.MaxAge = archiveConfig.MaxAge.Duration
+	}
+	if len(archiveConfig.Filter) > 0 {
+		if err := bson.UnmarshalExtJSON(archiveConfig.Filter, false, &policy.Filter); err != nil {
+			return nil, fmt.Errorf("archive %s: filter is not an Extended JSON document: %w", name, err)
+		}
+	}
+	if len(archiveConfig.Destination) > 0 {
+		bucket, prefix, err := objectstore.Open(archiveConfig.Destination, objectstore.Options{
+			ServerSideEncryption: archiveConfig.ServerSideEncryption,
+			KMSKeyID:             archiveConfig.KMSKeyID,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("archive %s: %w", name, err)
+		}
+		policy.Bucket, policy.Prefix = bucket, prefix
+	}
+	if err := policy.Validate(); err != nil {
+		return nil, fmt.Errorf("archive %s: %w", name, err)
+	}
+	return policy, nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/backup"
+	"github.com/bradmwilliams/mongodb-client/pkg/config"
+	"github.com/bradmwilliams/mongodb-client/pkg/jobs"
+	"github.com/bradmwilliams/mongodb-client/pkg/objectstore"
+)
+
+// defaultBackupTimeout bounds a backup run unless the config file sets a timeout, dumps take far
+// longer than the other jobs.
+const defaultBackupTimeout = time.Hour
+
+// configuredJob is a job defined by the config file and its scheduling settings.
+type configuredJob struct {
+	spec   jobs.Spec
+	config config.JobConfig
+}
+
+// backupJobs returns a job for every backup of the config file, named "backup-<name>".
+func backupJobs(cfg *config.Config) ([]configuredJob, error) {
+	names := make([]string, 0, len(cfg.Backups))
+	for name := range cfg.Backups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var backupJobs []configuredJob
+	for _, name := range names {
+		backupConfig := cfg.Backups[name]
+		if len(backupConfig.Destination) == 0 {
+			return nil, fmt.Errorf("backup %s: destination is required", name)
+		}
+		if backupConfig.Retention.KeepLast < 0 {
+			return nil, fmt.Errorf("backup %s: retention.keepLast must not be negative", name)
+		}
+		bucket, prefix, err := objectstore.Open(backupConfig.Destination, objectstore.Options{
+			ServerSideEncryption: backupConfig.ServerSideEncryption,
+			KMSKeyID:             backupConfig.KMSKeyID,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("backup %s: %w", name, err)
+		}
+		b := &backup.Backup{
+			Name:      name,
+			Databases: backupConfig.Databases,
+			Bucket:    bucket,
+			Prefix:    prefix,
+			Gzip:      backupConfig.Gzip == nil || *backupConfig.Gzip,
+			Retention: backup.Retention{KeepLast: backupConfig.Retention.KeepLast},
+		}
+		if backupConfig.Retention.MaxAge != nil {
+			b.Retention.MaxAge = backupConfig.Retention.MaxAge.Duration
+		}
+		b.Oplog = backupConfig.Oplog != nil
+		backupJobs = append(backupJobs, configuredJob{
+			spec: jobs.Spec{
+				Name:    "backup-" + name,
+				Timeout: defaultBackupTimeout,
+				Handler: b.Run,
+			},
+			config: backupConfig.JobConfig,
+		})
+		if backupConfig.Oplog != nil {
+			backupJobs = append(backupJobs, configuredJob{
+				spec: jobs.Spec{
+					Name:    "backup-" + name + "-oplog",
+					Timeout: defaultBackupTimeout,
+					Handler: b.RunOplogCapture,
+				},
+				config: *backupConfig.Oplog,
+			})
+		}
+	}
+	return backupJobs, nil
+}
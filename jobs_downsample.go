@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/config"
+	"github.com/bradmwilliams/mongodb-client/pkg/downsample"
+	"github.com/bradmwilliams/mongodb-client/pkg/jobs"
+)
+
+// defaultDownsampleTimeout bounds an aggregation unless the config file sets a timeout, the first
+// run aggregates every measurement collected so far.
+const defaultDownsampleTimeout = time.Hour
+
+// downsampleJobs returns a job for every downsampling policy of the config file, named
+// "downsample-<name>", the policies without a database aggregate the collections of database.
+func downsampleJobs(cfg *config.Config, database string) ([]configuredJob, error) {
+	names := make([]string, 0, len(cfg.Downsample))
+	for name := range cfg.Downsample {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var downsampleJobs []configuredJob
+	for _, name := range names {
+		policy, err := downsamplePolicy(cfg, name, database)
+		if err != nil {
+			return nil, err
+		}
+		downsampleJobs = append(downsampleJobs, configuredJob{
+			spec: jobs.Spec{
+				Name:    "downsample-" + name,
+				Timeout: defaultDownsampleTimeout,
+				Handler: policy.Run,
+			},
+			config: cfg.Downsample[name].JobConfig,
+		})
+	}
+	return downsampleJobs, nil
+}
+
+// downsamplePolicy returns the downsampling policy called name of the config file.
+func downsamplePolicy(cfg *config.Config, name, database string) (*downsample.Policy, error) {
+	policyConfig, ok := cfg.Downsample[name]
+	if !ok {
+		return nil, fmt.Errorf("no downsampling policy %s in the config file", name)
+	}
+	policy := &downsample.Policy{
+		Name:       name,
+		Database:   policyConfig.Database,
+		Source:     policyConfig.Collection,
+		Target:     policyConfig.RollupCollection,
+		TimeField:  policyConfig.TimeField,
+		MetaField:  policyConfig.MetaField,
+		Aggregates: policyConfig.Aggregates,
+	}
+	if len(policy.Database) == 0 {
+		policy.Database = database
+	}
+	if policyConfig.Interval != nil {
+		policy.Interval = policyConfig.Interval.Duration
+	}
+	if policyConfig.Lag != nil {
+		policy.Lag = policyConfig.Lag.Duration
+	}
+	if err := policy.Validate(); err != nil {
+		return nil, fmt.Errorf("downsample %s: %w", name, err)
+	}
+	return policy, nil
+}
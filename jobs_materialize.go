@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/auth"
+	"github.com/bradmwilliams/mongodb-client/pkg/config"
+	"github.com/bradmwilliams/mongodb-client/pkg/jobs"
+	"github.com/bradmwilliams/mongodb-client/pkg/materialize"
+)
+
+// defaultMaterializeTimeout bounds a refresh unless the config file sets a timeout.
+const defaultMaterializeTimeout = 30 * time.Minute
+
+// materializedViews returns the materialized views of the config file, sorted by name, the views
+// without a database aggregate the collections of database.
+func materializedViews(cfg *config.Config, database string) ([]*materialize.View, error) {
+	names := make([]string, 0, len(cfg.MaterializedViews))
+	for name := range cfg.MaterializedViews {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	views := make([]*materialize.View, 0, len(names))
+	for _, name := range names {
+		viewConfig := cfg.MaterializedViews[name]
+		view := &materialize.View{
+			Name:     name,
+			Database: viewConfig.Database,
+			Source:   viewConfig.Collection,
+			Timeout:  defaultMaterializeTimeout,
+		}
+		if len(view.Database) == 0 {
+			view.Database = database
+		}
+		if viewConfig.Timeout != nil {
+			view.Timeout = viewConfig.Timeout.Duration
+		}
+		if len(viewConfig.Pipeline) > 0 {
+			pipeline, err := parsePipeline(string(viewConfig.Pipeline))
+			if err != nil {
+				return nil, fmt.Errorf("materialized view %s: %w", name, err)
+			}
+			view.Pipeline = pipeline
+		}
+		if err := view.Validate(); err != nil {
+			return nil, fmt.Errorf("materialized view %s: %w", name, err)
+		}
+		views = append(views, view)
+	}
+	return views, nil
+}
+
+// materializeJobs returns a job refreshing every view, named "materialize-<name>".
+func materializeJobs(cfg *config.Config, views []*materialize.View) []configuredJob {
+	var materializeJobs []configuredJob
+	for _, view := range views {
+		materializeJobs = append(materializeJobs, configuredJob{
+			spec: jobs.Spec{
+				Name:    "materialize-" + view.Name,
+				Timeout: view.Timeout,
+				Handler: view.Run,
+			},
+			config: cfg.MaterializedViews[view.Name].JobConfig,
+		})
+	}
+	return materializeJobs
+}
+
+// materializePermission requires read access to the database of the view for GET, and read-write
+// access to refresh it. Listing the views, which may belong to any database, requires read access to
+// every database.
+func materializePermission(handler *materialize.Handler) func(r *http.Request) auth.Permission {
+	return func(r *http.Request) auth.Permission {
+		name, refresh := materialize.ParsePath(r.URL.Path)
+		view := handler.View(name)
+		if view == nil {
+			return auth.Permission{}
+		}
+		return auth.Permission{Database: view.Database, Write: refresh}
+	}
+}
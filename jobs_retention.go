@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/config"
+	"github.com/bradmwilliams/mongodb-client/pkg/jobs"
+	"github.com/bradmwilliams/mongodb-client/pkg/retention"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// defaultRetentionTimeout bounds a purge unless the config file sets a timeout, rate limited purges
+// of large collections take a while.
+const defaultRetentionTimeout = time.Hour
+
+// retentionJobs returns a job for every retention policy of the config file, named
+// "retention-<name>", the policies without a database purge the collections of database.
+func retentionJobs(cfg *config.Config, database string) ([]configuredJob, error) {
+	names := make([]string, 0, len(cfg.Retention))
+	for name := range cfg.Retention {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var retentionJobs []configuredJob
+	for _, name := range names {
+		policyConfig := cfg.Retention[name]
+		policy := &retention.Policy{
+			Name:       name,
+			Database:   policyConfig.Database,
+			Collection: policyConfig.Collection,
+			Field:      policyConfig.Field,
+			Archive:    policyConfig.ArchiveCollection,
+			BatchSize:  policyConfig.BatchSize,
+			Rate:       policyConfig.Rate,
+			TTLIndex:   policyConfig.TTLIndex,
+		}
+		if len(policy.Database) == 0 {
+			policy.Database = database
+		}
+		if policyConfig.MaxAge != nil {
+			policy.MaxAge = policyConfig.MaxAge.Duration
+		}
+		if len(policyConfig.Filter) > 0 {
+			if err := bson.UnmarshalExtJSON(policyConfig.Filter, false, &policy.Filter); err != nil {
+				return nil, fmt.Errorf("retention %s: filter is not an Extended JSON document: %w", name, err)
+			}
+		}
+		if err := policy.Validate(); err != nil {
+			return nil, fmt.Errorf("retention %s: %w", name, err)
+		}
+		retentionJobs = append(retentionJobs, configuredJob{
+			spec: jobs.Spec{
+				Name:    "retention-" + name,
+				Timeout: defaultRetentionTimeout,
+				Handler: policy.Run,
+			},
+			config: policyConfig.JobConfig,
+		})
+	}
+	return retentionJobs, nil
+}
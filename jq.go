@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/jq"
+	"github.com/spf13/pflag"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const jqUsage = `jq expression applied to each document before it is written, such as '{title, year: .released}' or 'select(.duration > 25) | .title', evaluated on the BSON values so ObjectIds, dates and numbers keep their type`
+
+// addJQFlag adds the --jq flag, whose expression is returned by parseJQ.
+func addJQFlag(flagset *pflag.FlagSet, expression *string) {
+	flagset.StringVar(expression, "jq", *expression, jqUsage)
+}
+
+// parseJQ parses the expression of --jq, nil when it is empty.
+func parseJQ(expression string) (*jq.Expression, error) {
+	if len(expression) == 0 {
+		return nil, nil
+	}
+	expr, err := jq.Parse(expression)
+	if err != nil {
+		return nil, fmt.Errorf("--jq: %w", err)
+	}
+	return expr, nil
+}
+
+// jqWriter returns a function writing the outputs of expr for each document with write. The
+// outputs must be documents.
+func jqWriter(expr *jq.Expression, write func(bson.Raw) error) func(bson.Raw) error {
+	return func(doc bson.Raw) error {
+		outputs, err := expr.Apply(doc)
+		if err != nil {
+			return err
+		}
+		for _, output := range outputs {
+			transformed, ok := output.(bson.D)
+			if !ok {
+				return fmt.Errorf("--jq must output documents, not %v", output)
+			}
+			raw, err := bson.Marshal(transformed)
+			if err != nil {
+				return err
+			}
+			if err := write(raw); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/lint"
+	"github.com/spf13/pflag"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"k8s.io/klog"
+)
+
+// defaultCollScanThreshold is the number of documents from which the filters no index supports are
+// warned about.
+const defaultCollScanThreshold = 100000
+
+// lintOptions control the checks of the filters and pipelines run by a command.
+type lintOptions struct {
+	// Only checks them without running them.
+	Only bool
+	// Threshold is the number of documents from which a filter no index supports is warned about,
+	// zero disables the check.
+	Threshold int64
+}
+
+func addLintFlags(flagset *pflag.FlagSet, l *lintOptions) {
+	l.Threshold = defaultCollScanThreshold
+	flagset.BoolVar(&l.Only, "lint-only", l.Only, "Check the filter or pipeline, printing its problems, without running it")
+	addCollScanThresholdFlag(flagset, &l.Threshold)
+}
+
+func addCollScanThresholdFlag(flagset *pflag.FlagSet, threshold *int64) {
+	flagset.Int64Var(threshold, "collscan-threshold", *threshold, "Warn about the filters no index supports on collections of at least this many documents (0 disables the check)")
+}
+
+// check checks the filter, or the pipeline when it is not nil, run on collection and prints its
+// problems to stderr. It fails when the server would reject them. Collection scans are not warned
+// about when they can not be checked, such as without the privilege to run $indexStats.
+func (l *lintOptions) check(ctx context.Context, collection *mongo.Collection, filter bson.D, pipeline []bson.D) error {
+	problems, err := lintQuery(ctx, collection, filter, pipeline, l.Threshold)
+	if err != nil {
+		klog.V(2).Infof("Unable to check the indexes supporting the query: %v", err)
+	}
+	for _, problem := range problems {
+		fmt.Fprintln(os.Stderr, problem)
+	}
+	if n := lint.Errors(problems); n > 0 {
+		return fmt.Errorf("the query has %d errors", n)
+	}
+	if l.Only && len(problems) == 0 {
+		fmt.Fprintln(os.Stderr, "No problems found")
+	}
+	return nil
+}
+
+// lintQuery returns the problems of the filter, or of the pipeline when it is not nil, and warns
+// about collection scans when threshold is positive and collection is set. The problems found
+// before an error checking the indexes are returned with it.
+func lintQuery(ctx context.Context, collection *mongo.Collection, filter bson.D, pipeline []bson.D, threshold int64) ([]lint.Problem, error) {
+	var problems []lint.Problem
+	path := "filter"
+	if pipeline != nil {
+		problems = lint.Pipeline(pipeline)
+		filter, path = lint.LeadingMatch(pipeline), "pipeline.0.$match"
+	} else {
+		problems = lint.Filter(filter)
+	}
+	if lint.Errors(problems) > 0 || threshold <= 0 || collection == nil {
+		return problems, nil
+	}
+	scan, err := lint.CollectionScan(ctx, collection, path, filter, threshold)
+	return append(problems, scan...), err
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+)
+
+type listenTLSOptions struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+}
+
+func (t listenTLSOptions) validate() error {
+	if (len(t.CertFile) > 0) != (len(t.KeyFile) > 0) {
+		return fmt.Errorf("--listen-tls-cert and --listen-tls-key must be specified together")
+	}
+	if len(t.ClientCAFile) > 0 && len(t.CertFile) == 0 {
+		return fmt.Errorf("--listen-client-ca requires --listen-tls-cert and --listen-tls-key")
+	}
+	return nil
+}
+
+// certificate serves a key pair from disk, reading it again when the certificate file changes so
+// that rotated certificates are picked up without a restart.
+type certificate struct {
+	certFile, keyFile string
+
+	lock     sync.Mutex
+	modified time.Time
+	current  *tls.Certificate
+}
+
+func (c *certificate) load() (*tls.Certificate, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	info, err := os.Stat(c.certFile)
+	if err != nil {
+		if c.current != nil {
+			return c.current, nil
+		}
+		return nil, err
+	}
+	if c.current != nil && info.ModTime().Equal(c.modified) {
+		return c.current, nil
+	}
+	pair, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		// The key may not have been written yet while the certificate is rotated.
+		if c.current != nil {
+			klog.Warningf("Unable to reload the listen certificate, serving the previous one: %v", err)
+			return c.current, nil
+		}
+		return nil, err
+	}
+	c.current, c.modified = &pair, info.ModTime()
+	return c.current, nil
+}
+
+// tlsConfig returns the server configuration of the listen address, nil when it serves plain HTTP.
+func (t listenTLSOptions) tlsConfig() (*tls.Config, error) {
+	if len(t.CertFile) == 0 {
+		return nil, nil
+	}
+	cert := &certificate{certFile: t.CertFile, keyFile: t.KeyFile}
+	if _, err := cert.load(); err != nil {
+		return nil, fmt.Errorf("unable to load --listen-tls-cert and --listen-tls-key: %w", err)
+	}
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return cert.load()
+		},
+	}
+	if len(t.ClientCAFile) > 0 {
+		data, err := ioutil.ReadFile(t.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read --listen-client-ca: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("--listen-client-ca contains no PEM encoded certificates")
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// listen serves handler on addr until the server fails, over HTTPS unless config is nil.
+func listen(addr string, handler http.Handler, config *tls.Config) error {
+	server := &http.Server{Addr: addr, Handler: handler, TLSConfig: config}
+	if config == nil {
+		klog.Infof("Listening on %s for UI and metrics", addr)
+		return server.ListenAndServe()
+	}
+	klog.Infof("Listening on %s for UI and metrics over HTTPS", addr)
+	// The certificate is served by GetCertificate.
+	return server.ListenAndServeTLS("", "")
+}
@@ -2,385 +2,817 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"github.com/bradmwilliams/mongodb-client/pkg/api"
+	"github.com/bradmwilliams/mongodb-client/pkg/audit"
+	"github.com/bradmwilliams/mongodb-client/pkg/auth"
+	"github.com/bradmwilliams/mongodb-client/pkg/client"
+	"github.com/bradmwilliams/mongodb-client/pkg/config"
+	"github.com/bradmwilliams/mongodb-client/pkg/featureflag"
+	"github.com/bradmwilliams/mongodb-client/pkg/fixtures"
+	"github.com/bradmwilliams/mongodb-client/pkg/graphql"
+	"github.com/bradmwilliams/mongodb-client/pkg/jobs"
+	"github.com/bradmwilliams/mongodb-client/pkg/leaderelection"
+	"github.com/bradmwilliams/mongodb-client/pkg/lock"
+	"github.com/bradmwilliams/mongodb-client/pkg/materialize"
+	"github.com/bradmwilliams/mongodb-client/pkg/migrate"
+	"github.com/bradmwilliams/mongodb-client/pkg/provision"
+	"github.com/bradmwilliams/mongodb-client/pkg/ui"
+	"github.com/bradmwilliams/mongodb-client/pkg/ws"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/cobra"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	mongoOptions "go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
-	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/klog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 )
 
 type options struct {
-	ListenAddr string
-	DryRun     bool
+	ListenAddr     string
+	ListenTLS      listenTLSOptions
+	EnableAPI      bool
+	EnableGraphQL  bool
+	EnableWatch    bool
+	EnableDebug    bool
+	EnableUI       bool
+	AllowedOrigins []string
+	Idempotency    api.Idempotency
+	GRPCListenAddr string
+	DryRun         bool
+	ConfigFile     string
+	// ConfigReloadInterval is how often the config file is checked for changes, it is only reloaded
+	// on SIGHUP when zero.
+	ConfigReloadInterval time.Duration
+	// Profile is the profile of the config file connected to instead of the MONGODB_* environment
+	// variables.
+	Profile string
+	// ReadOnly makes every client refuse the commands that could write.
+	ReadOnly bool
+	// Yes confirms the destructive operations without asking.
+	Yes bool
+	// OneShot applies the manifest, migrations and fixture sets and exits, as an init container.
+	OneShot     bool
+	Output      string
+	Manifest    string
+	Migrations  string
+	Fixtures    string
+	FixtureSets []string
+	Compressors []string
+
+	MaxPoolSize     uint64
+	MinPoolSize     uint64
+	MaxConnIdleTime time.Duration
+	ConnectTimeout  time.Duration
+	// RetryWrites and RetryReads let the driver retry an operation once after a failover or a
+	// network error, they are enabled by default.
+	RetryWrites bool
+	RetryReads  bool
+
+	OperationTimeout time.Duration
+	Retry            client.RetryPolicy
+	Breaker          client.BreakerConfig
+
+	Schedule       string
+	ScheduleJitter time.Duration
+	RunOnStart     bool
+
+	Identity       string
+	LockCollection string
+	LockTTL        time.Duration
+	LeaderElection leaderElectionOptions
+	// FeatureFlagCollection holds the feature flags toggling the jobs, none when empty.
+	FeatureFlagCollection string
+
+	// audit records the audited commands of the clients, nil when the config file has no audit
+	// section.
+	audit *audit.Logger
+
+	// profiles are the clients of the profiles of the jobs, kept when the config file is reloaded.
+	profiles *profileClients
+
+	// loadedConfig is the content of --config once it has been read.
+	loadedConfig *config.Config
+
+	// poolStats collects the pool events of the clients for the debug endpoints.
+	poolStats *client.PoolStats
 }
 
-func (o *options) Run() error {
-	stopCh := wait.NeverStop
+type leaderElectionOptions struct {
+	Enabled       bool
+	Name          string
+	Collection    string
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
+}
 
-	klog.Infof("Starting...")
+var supportedCompressors = []string{"snappy", "zlib", "zstd"}
 
-	if len(o.ListenAddr) > 0 {
-		http.DefaultServeMux.Handle("/metrics", promhttp.Handler())
-		go func() {
-			klog.Infof("Listening on %s for UI and metrics", o.ListenAddr)
-			if err := http.ListenAndServe(o.ListenAddr, nil); err != nil {
-				klog.Exitf("Server exited: %v", err)
+func (o *options) validate() error {
+	if err := ejson.validate(); err != nil {
+		return err
+	}
+	if _, err := o.printer(); err != nil {
+		return err
+	}
+	for _, compressor := range o.Compressors {
+		supported := false
+		for _, s := range supportedCompressors {
+			if compressor == s {
+				supported = true
+				break
 			}
-		}()
+		}
+		if !supported {
+			return fmt.Errorf("unsupported compressor %q, must be one of: %s", compressor, strings.Join(supportedCompressors, ", "))
+		}
 	}
-
-	var ok bool
-	var databaseHost, databasePort, databaseUserName, databaseUserPassword, databaseAdminPassword, databaseName string
-
-	if databaseHost, ok = os.LookupEnv("MONGODB_HOST"); !ok || len(databaseHost) == 0 {
-		klog.Fatal("MONGODB_HOST is not defined")
+	if o.MaxPoolSize > 0 && o.MinPoolSize > o.MaxPoolSize {
+		return fmt.Errorf("--min-pool-size (%d) must not be greater than --max-pool-size (%d)", o.MinPoolSize, o.MaxPoolSize)
 	}
-
-	if databasePort, ok = os.LookupEnv("MONGODB_PORT"); !ok || len(databasePort) == 0 {
-		klog.Fatal("MONGODB_PORT is not defined")
+	if o.MaxConnIdleTime < 0 {
+		return fmt.Errorf("--max-conn-idle-time must not be negative")
 	}
-
-	if databaseUserName, ok = os.LookupEnv("MONGODB_USER"); !ok || len(databaseUserName) == 0 {
-		klog.Fatal("MONGODB_USER is not defined")
+	if o.ConnectTimeout < 0 {
+		return fmt.Errorf("--connect-timeout must not be negative")
 	}
-
-	if databaseUserPassword, ok = os.LookupEnv("MONGODB_PASSWORD"); !ok || len(databaseUserPassword) == 0 {
-		klog.Fatal("MONGODB_PASSWORD is not defined")
+	if len(o.Idempotency.Collection) > 0 && o.Idempotency.TTL <= 0 {
+		return fmt.Errorf("--api-idempotency-ttl must be greater than zero")
+	}
+	if o.OperationTimeout <= 0 {
+		return fmt.Errorf("--operation-timeout must be greater than zero")
+	}
+	if o.Retry.Attempts < 1 {
+		return fmt.Errorf("--retry-attempts must be at least 1")
+	}
+	if o.Retry.Backoff < 0 || o.Retry.MaxBackoff < 0 {
+		return fmt.Errorf("--retry-backoff and --retry-max-backoff must not be negative")
+	}
+	if o.Retry.Jitter < 0 {
+		return fmt.Errorf("--retry-jitter must not be negative")
+	}
+	if o.Breaker.Threshold < 1 {
+		return fmt.Errorf("--circuit-breaker-threshold must be at least 1")
+	}
+	if o.Breaker.Backoff <= 0 || o.Breaker.MaxBackoff < 0 {
+		return fmt.Errorf("--circuit-breaker-backoff must be greater than zero and --circuit-breaker-max-backoff must not be negative")
 	}
+	if _, err := jobs.ParseSchedule(o.Schedule); err != nil {
+		return fmt.Errorf("--schedule: %w", err)
+	}
+	if o.ScheduleJitter < 0 {
+		return fmt.Errorf("--schedule-jitter must not be negative")
+	}
+	if o.ConfigReloadInterval < 0 {
+		return fmt.Errorf("--config-reload-interval must not be negative")
+	}
+	if o.OneShot && len(o.Manifest) == 0 && len(o.Migrations) == 0 && len(o.FixtureSets) == 0 {
+		return fmt.Errorf("--one-shot requires --manifest, --migrations or --fixture-set")
+	}
+	if o.LockTTL < 3*time.Second {
+		return fmt.Errorf("--lock-ttl must be at least 3s")
+	}
+	if err := o.ListenTLS.validate(); err != nil {
+		return err
+	}
+	if o.LeaderElection.Enabled {
+		if o.LeaderElection.LeaseDuration <= o.LeaderElection.RenewDeadline {
+			return fmt.Errorf("--leader-election-lease-duration must be greater than --leader-election-renew-deadline")
+		}
+		if o.LeaderElection.RenewDeadline <= o.LeaderElection.RetryPeriod {
+			return fmt.Errorf("--leader-election-renew-deadline must be greater than --leader-election-retry-period")
+		}
+	}
+	return nil
+}
 
-	if databaseAdminPassword, ok = os.LookupEnv("MONGODB_ADMIN_PASSWORD"); !ok || len(databaseAdminPassword) == 0 {
-		klog.Fatal("MONGODB_ADMIN_PASSWORD is not defined")
+// clientOptions returns the driver options used for every connection made by this client.
+func (o *options) clientOptions() *mongoOptions.ClientOptions {
+	opts := mongoOptions.Client()
+	if len(o.Compressors) > 0 {
+		opts.SetCompressors(o.Compressors)
+	}
+	if o.MaxPoolSize > 0 {
+		opts.SetMaxPoolSize(o.MaxPoolSize)
+	}
+	if o.MinPoolSize > 0 {
+		opts.SetMinPoolSize(o.MinPoolSize)
+	}
+	if o.MaxConnIdleTime > 0 {
+		opts.SetMaxConnIdleTime(o.MaxConnIdleTime)
 	}
+	if o.ConnectTimeout > 0 {
+		opts.SetConnectTimeout(o.ConnectTimeout)
+	}
+	// Only disabling retries is applied, so retryWrites=false and retryReads=false in the URI win.
+	if !o.RetryWrites {
+		opts.SetRetryWrites(false)
+	}
+	if !o.RetryReads {
+		opts.SetRetryReads(false)
+	}
+	if o.poolStats != nil {
+		opts.SetPoolMonitor(o.poolStats.Monitor())
+	}
+	if o.audit != nil {
+		opts.SetMonitor(o.audit.Monitor())
+	}
+	return opts
+}
 
-	if databaseName, ok = os.LookupEnv("MONGODB_DATABASE"); !ok || len(databaseName) == 0 {
-		klog.Fatal("MONGODB_DATABASE is not defined")
+// loadConfig returns the content of --config, an empty config when it is not set.
+func (o *options) loadConfig() (*config.Config, error) {
+	if o.loadedConfig != nil {
+		return o.loadedConfig, nil
 	}
+	cfg := &config.Config{}
+	if len(o.ConfigFile) > 0 {
+		var err error
+		if cfg, err = config.Load(o.ConfigFile); err != nil {
+			return nil, err
+		}
+	}
+	o.loadedConfig = cfg
+	return cfg, nil
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+// connectionConfig returns the connection details of the environment with the options of the
+// flags, and the client-side encryption of the config file.
+func (o *options) connectionConfig() (client.Config, error) {
+	var connection client.Config
+	var err error
+	if len(o.Profile) > 0 {
+		connection, err = o.profileConnection(o.Profile)
+	} else {
+		connection, err = client.ConfigFromEnvironment()
+	}
+	if err != nil {
+		return connection, err
+	}
+	connection.ClientOptions = o.clientOptions()
+	connection.ReadOnly = connection.ReadOnly || o.ReadOnly
+	connection.OperationTimeout = o.OperationTimeout
+	connection.Retry = o.Retry
 
-	// mongodb://[username:password@]host1[:port1][,...hostN[:portN]][/[defaultauthdb][?options]]
-	connectString := fmt.Sprintf("mongodb://%s:%s@%s:%s/%s", databaseUserName, databaseUserPassword, databaseHost, databasePort, databaseName)
-	client, err := mongo.Connect(ctx, mongoOptions.Client().ApplyURI(connectString))
-	defer func() {
-		if err = client.Disconnect(ctx); err != nil {
-			panic(err)
+	cfg, err := o.loadConfig()
+	if err != nil {
+		return connection, err
+	}
+	if cfg.Timeouts != nil {
+		if connection.Timeouts, err = operationTimeouts(cfg.Timeouts); err != nil {
+			return connection, fmt.Errorf("invalid timeouts configuration: %w", err)
 		}
-	}()
-
-	adminConnectString := fmt.Sprintf("mongodb://admin:%s@%s:%s/admin", databaseAdminPassword, databaseHost, databasePort)
-	adminClient, err := mongo.Connect(ctx, mongoOptions.Client().ApplyURI(adminConnectString))
-	defer func() {
-		if err = adminClient.Disconnect(ctx); err != nil {
-			panic(err)
+		if cfg.Timeouts.Connect != nil {
+			connection.ClientOptions.SetConnectTimeout(cfg.Timeouts.Connect.Duration)
 		}
-	}()
-
-	klog.Infof("Checking access to database...")
-	err = wait.PollImmediate(15*time.Second, 60*time.Second, func() (done bool, err error) {
-		err = client.Ping(ctx, readpref.Primary())
+	}
+	if cfg.Encryption != nil {
+		cse, err := encryptionConfig(cfg.Encryption)
 		if err != nil {
-			klog.Warningf("Unable to ping database: %v", err)
-			return false, nil
+			return connection, fmt.Errorf("invalid encryption configuration: %w", err)
 		}
-		klog.Infof("Ping successful")
-		return true, nil
-	})
+		connection.AutoEncryption = cse.AutoEncryptionOptions
+	}
+	return connection, nil
+}
+
+// operationTimeouts returns the timeouts of the operations of the config file.
+func operationTimeouts(cfg *config.TimeoutsConfig) (client.Timeouts, error) {
+	var timeouts client.Timeouts
+	for _, timeout := range []struct {
+		name  string
+		value *config.Duration
+		into  *time.Duration
+	}{
+		{"connect", cfg.Connect, nil},
+		{"read", cfg.Read, &timeouts.Read},
+		{"write", cfg.Write, &timeouts.Write},
+		{"aggregate", cfg.Aggregate, &timeouts.Aggregate},
+		{"admin", cfg.Admin, &timeouts.Admin},
+	} {
+		if timeout.value == nil {
+			continue
+		}
+		if timeout.value.Duration <= 0 {
+			return timeouts, fmt.Errorf("%s must be greater than zero", timeout.name)
+		}
+		if timeout.into != nil {
+			*timeout.into = timeout.value.Duration
+		}
+	}
+	return timeouts, nil
+}
+
+// connectionManager returns a manager, not yet connected, for the database described by the environment.
+func (o *options) connectionManager() (*client.ConnectionManager, error) {
+	connection, err := o.connectionConfig()
 	if err != nil {
-		klog.Fatal(err)
+		return nil, err
 	}
+	return client.NewConnectionManager(connection), nil
+}
 
-	initializeDatabase(adminClient)
+// connect validates the options and returns a connected manager, used by the one-off subcommands.
+func (o *options) connect() (*client.ConnectionManager, error) {
+	if err := o.validate(); err != nil {
+		return nil, validationError(err)
+	}
+	manager, err := o.connectionManager()
+	if err != nil {
+		return nil, validationError(err)
+	}
+	ctx, cancel := manager.Context()
+	defer cancel()
+	if err := manager.Connect(ctx); err != nil {
+		manager.Disconnect(ctx)
+		return nil, connectivityError(err)
+	}
+	return manager, nil
+}
 
-	create(client)
-	structures(client)
-	read(client)
-	update(client)
-	delete(client)
+// disconnect closes the connections of manager, logging any failure.
+func disconnect(manager *client.ConnectionManager) {
+	ctx, cancel := manager.Context()
+	defer cancel()
+	if err := manager.Disconnect(ctx); err != nil {
+		klog.Errorf("Unable to disconnect from database: %v", err)
+	}
+}
 
-	go mainProcessLoop(stopCh)
+// cmdContext returns a context for long-running subcommands that is cancelled when the process is interrupted.
+func cmdContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
 
-	<-stopCh
-	klog.Infof("Exit...")
-	return nil
+// operationContext returns the context of an operation of kind bounded by maxTime, as set by
+// --max-time, or by the timeout of kind when it is zero.
+func operationContext(manager *client.ConnectionManager, kind client.OperationKind, maxTime time.Duration) (context.Context, context.CancelFunc) {
+	if maxTime > 0 {
+		return context.WithTimeout(context.Background(), maxTime)
+	}
+	return manager.ContextFor(kind)
 }
 
-func initializeDatabase(client *mongo.Client) {
-	klog.Infof("Initializing database...")
+// serveMetrics serves the metrics of long-running subcommands on addr in the background.
+func serveMetrics(addr string) {
+	go func() {
+		klog.Infof("Listening on %s for metrics", addr)
+		if err := http.ListenAndServe(addr, promhttp.Handler()); err != nil {
+			klog.Exitf("Server exited: %v", err)
+		}
+	}()
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+func (o *options) Run() error {
+	// The jobs, the leases and the servers are stopped on SIGTERM or SIGINT, so that the locks and
+	// the lease are released before exiting.
+	signalCtx, stop := cmdContext()
+	defer stop()
+	stopCh := signalCtx.Done()
 
-	databases, err := client.ListDatabaseNames(ctx, bson.M{})
-	if err != nil {
-		klog.Fatal(err)
+	if err := o.validate(); err != nil {
+		return validationError(err)
 	}
-	fmt.Println(databases)
-}
 
-func create(client *mongo.Client) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	klog.Infof("Starting...")
 
-	quickstartDatabase := client.Database("sampledb")
-	podcastsCollection := quickstartDatabase.Collection("podcasts")
-	episodesCollection := quickstartDatabase.Collection("episodes")
+	cfg, err := o.loadConfig()
+	if err != nil {
+		return validationError(err)
+	}
+	if err := applyLogLevel(cfg); err != nil {
+		return validationError(fmt.Errorf("invalid configuration: %w", err))
+	}
+	var manifest *provision.Manifest
+	if len(o.Manifest) > 0 {
+		if manifest, err = provision.Load(o.Manifest); err != nil {
+			return validationError(err)
+		}
+	}
 
-	podcastResult, err := podcastsCollection.InsertOne(ctx, bson.D{
-		{"title", "The Polyglot Developer Podcast"},
-		{"author", "Nic Raboy"},
-		{"tags", bson.A{"development", "programming", "coding"}},
-	})
+	if o.EnableDebug {
+		o.poolStats = client.NewPoolStats()
+	}
+	manager, err := o.connectionManager()
 	if err != nil {
-		klog.Fatal(err)
+		return validationError(err)
+	}
+	breaker := client.NewCircuitBreaker(o.Breaker, manager.Ping)
+	// The runs skipped on lock contention or a feature flag say nothing about the database.
+	breaker.Ignore = func(err error) bool { return errors.Is(err, jobs.ErrSkipped) }
+	views, err := materializedViews(cfg, manager.Database())
+	if err != nil {
+		return validationError(err)
 	}
 
-	episodeResult, err := episodesCollection.InsertMany(ctx, []interface{}{
-		bson.D{
-			{"podcast", podcastResult.InsertedID},
-			{"title", "GraphQL for API Development"},
-			{"description", "Learn about GraphQL from the co-creator of GraphQL, Lee Byron."},
-			{"duration", 25},
-		},
-		bson.D{
-			{"podcast", podcastResult.InsertedID},
-			{"title", "Progressive Web Application Development"},
-			{"description", "Learn about PWA development with Tara Manicsic."},
-			{"duration", 32},
-		},
-	})
+	var authenticator *auth.Authenticator
+	if cfg.HTTP != nil {
+		if authenticator, err = httpAuthenticator(cfg.HTTP); err != nil {
+			return validationError(fmt.Errorf("invalid http configuration: %w", err))
+		}
+	}
+	tenants, err := apiTenants(cfg.Tenancy)
 	if err != nil {
-		klog.Fatal(err)
+		return validationError(err)
+	}
+	if tenants != nil && (o.EnableWatch || o.EnableGraphQL || len(o.GRPCListenAddr) > 0) {
+		return validationError(fmt.Errorf("--enable-watch, --enable-graphql and --grpc-listen do not map the namespaces of tenants, they can not be used with the tenancy section of --config"))
+	}
+	tlsConfig, err := o.ListenTLS.tlsConfig()
+	if err != nil {
+		return validationError(err)
+	}
+
+	var schema *graphql.Handler
+	var restAPI *api.Handler
+	if len(o.ListenAddr) > 0 && !o.OneShot {
+		// A mux of its own keeps the handlers registered on the default mux by imported packages,
+		// such as /debug/vars, from being served.
+		mux := http.NewServeMux()
+		restAPI = api.NewHandler(manager)
+		restAPI.Tenants = tenants
+		restAPI.CheckEmpty = o.checkProtected
+		if len(o.Idempotency.Collection) > 0 {
+			restAPI.Idempotency = &o.Idempotency
+		}
+		var metrics, apiHandler http.Handler = promhttp.Handler(), restAPI
+		if authenticator != nil {
+			metrics = authenticator.Require(metrics, metricsPermission)
+			apiHandler = authenticator.Require(apiHandler, apiPermission)
+		} else if o.EnableAPI {
+			klog.Warningf("The API is enabled without authentication, anyone reaching %s can read and write every database", o.ListenAddr)
+		}
+		mux.Handle("/metrics", metrics)
+		if o.EnableAPI {
+			mux.Handle(api.Prefix, apiHandler)
+			mux.Handle(api.OpenAPIPath, openAPIHandler(manager, cfg.HTTP, authenticator, tenants))
+			refresher := materialize.NewHandler(views, manager.Primary, materialize.Executor(jobExecutor(manager, breaker)))
+			refresher.Connected = manager.Connected
+			var handler http.Handler = refresher
+			if authenticator != nil {
+				handler = authenticator.Require(handler, materializePermission(refresher))
+			}
+			mux.Handle(materialize.Prefix, handler)
+		}
+		if o.EnableWatch {
+			var handler http.Handler = ws.NewHandler(manager, o.AllowedOrigins)
+			if authenticator != nil {
+				handler = auth.QueryToken(authenticator.Require(handler, watchPermission))
+			}
+			mux.Handle(ws.Prefix, handler)
+		}
+		if o.EnableGraphQL {
+			if len(cfg.GraphQL) == 0 {
+				return fmt.Errorf("--enable-graphql requires collections in the graphql section of --config")
+			}
+			var handler http.Handler
+			schema, handler = graphqlHandler(manager, cfg.GraphQL, authenticator)
+			mux.Handle(graphql.Path, handler)
+		}
+		if o.EnableDebug {
+			wrap := func(handler http.Handler) http.Handler { return handler }
+			if authenticator != nil {
+				wrap = func(handler http.Handler) http.Handler { return authenticator.Require(handler, debugPermission) }
+			}
+			debugHandlers(mux, manager, o.poolStats, wrap)
+		}
+		if o.EnableUI {
+			dashboard := ui.NewHandler(manager, breaker)
+			var handler http.Handler = dashboard
+			if authenticator != nil {
+				dashboard.Readable = func(ctx context.Context, database string) bool {
+					return authenticator.Authorize(auth.FromContext(ctx), auth.Permission{Database: database})
+				}
+				handler = authenticator.Require(handler, nil)
+			}
+			mux.Handle(ui.Prefix, handler)
+		}
+		mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case !manager.Connected():
+				http.Error(w, "not connected to database", http.StatusServiceUnavailable)
+			case breaker.State() != client.BreakerClosed:
+				http.Error(w, fmt.Sprintf("circuit breaker is %s", breaker.State()), http.StatusServiceUnavailable)
+			default:
+				fmt.Fprintln(w, "ok")
+			}
+		})
+		go func() {
+			if err := listen(o.ListenAddr, mux, tlsConfig); err != nil {
+				klog.Exitf("Server exited: %v", err)
+			}
+		}()
 	}
-	fmt.Printf("Inserted %v documents into episode collection!\n", len(episodeResult.InsertedIDs))
-}
 
-func read(client *mongo.Client) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	if len(o.GRPCListenAddr) > 0 && !o.OneShot {
+		if authenticator == nil {
+			klog.Warningf("The gRPC service is enabled without authentication, anyone reaching %s can read and write every database", o.GRPCListenAddr)
+		}
+		server := o.grpcServer(manager, authenticator, tlsConfig)
+		listener, err := net.Listen("tcp", o.GRPCListenAddr)
+		if err != nil {
+			return fmt.Errorf("unable to listen on --grpc-listen: %w", err)
+		}
+		go func() {
+			klog.Infof("Serving gRPC on %s", o.GRPCListenAddr)
+			if err := server.Serve(listener); err != nil {
+				klog.Exitf("gRPC server exited: %v", err)
+			}
+		}()
+		defer server.GracefulStop()
+	}
 
-	quickstartDatabase := client.Database("sampledb")
-	podcastsCollection := quickstartDatabase.Collection("podcasts")
-	episodesCollection := quickstartDatabase.Collection("episodes")
+	defer disconnect(manager)
 
-	// ALL
-	fmt.Println("Getting episodes via ALL()")
-	cursor, err := episodesCollection.Find(ctx, bson.M{})
+	// Connect waits for the deployment longer than the operation timeout, each following step has
+	// a context of its own.
+	ctx, cancel := manager.Context()
+	err = manager.Connect(ctx)
+	cancel()
 	if err != nil {
-		klog.Fatal(err)
+		return connectivityError(err)
 	}
-	var episodes []bson.M
-	if err = cursor.All(ctx, &episodes); err != nil {
-		klog.Fatal(err)
+	if schema != nil {
+		ctx, cancel := manager.ContextFor(client.ReadOperation)
+		err := schema.Load(ctx)
+		cancel()
+		if err != nil {
+			return err
+		}
+	}
+	if restAPI != nil && o.EnableAPI {
+		if err := manager.RetryFor(client.AdminOperation, restAPI.EnsureIndexes); err != nil {
+			return fmt.Errorf("unable to create the idempotency key indexes: %w", err)
+		}
 	}
-	fmt.Println(episodes)
 
-	// Iterate
-	fmt.Println("Iterating over episodes")
-	cursor, err = episodesCollection.Find(ctx, bson.M{})
-	if err != nil {
-		klog.Fatal(err)
+	if manifest != nil {
+		klog.Infof("Provisioning the database from %s...", o.Manifest)
+		if err := reconcile(context.Background(), manager.Admin(), manifest, provision.Options{}, o.DryRun, os.Stdout); err != nil {
+			return err
+		}
 	}
-	defer cursor.Close(ctx)
-	for cursor.Next(ctx) {
-		var episode bson.M
-		if err = cursor.Decode(&episode); err != nil {
-			klog.Fatal(err)
+
+	if len(o.Migrations) > 0 {
+		migrations := &migrateOptions{Dir: o.Migrations, Collection: migrate.DefaultCollection}
+		migrator, err := migrations.migrator(o, manager)
+		if err != nil {
+			return err
+		}
+		applied, err := migrator.Up(context.Background(), 0, o.DryRun)
+		printMigrations(applied, "applied", o.DryRun)
+		if err != nil {
+			return err
 		}
-		fmt.Println(episode)
 	}
 
-	// FindOne
-	fmt.Println("FindOne")
-	var podcast bson.M
-	if err = podcastsCollection.FindOne(ctx, bson.M{}).Decode(&podcast); err != nil {
-		klog.Fatal(err)
+	if len(o.FixtureSets) > 0 {
+		klog.Infof("Loading the fixture sets %s...", strings.Join(o.FixtureSets, ", "))
+		if err := loadFixtures(context.Background(), manager, o.Fixtures, o.FixtureSets, manager.Database(), fixtures.Options{Upsert: true}, o.DryRun); err != nil {
+			return err
+		}
+	}
+	if o.OneShot {
+		klog.Infof("Initialized the database, exiting")
+		return nil
 	}
-	fmt.Println(podcast)
 
-	// Filters
-	fmt.Println("Filtering (duration of 25)")
-	filterCursor, err := episodesCollection.Find(ctx, bson.M{"duration": 25})
+	identity, err := o.identity()
 	if err != nil {
-		klog.Fatal(err)
+		return err
 	}
-	var episodesFiltered []bson.M
-	if err = filterCursor.All(ctx, &episodesFiltered); err != nil {
-		klog.Fatal(err)
+	locker := lock.NewLocker(manager.Primary().Database(manager.Database()).Collection(o.LockCollection), identity, o.LockTTL)
+	if err := manager.RetryFor(client.AdminOperation, locker.EnsureIndexes); err != nil {
+		return fmt.Errorf("unable to create lock indexes: %w", err)
 	}
-	fmt.Println(episodesFiltered)
 
-	// Sorting
-	fmt.Println("Sorting, descending by duration > 24")
-	opts := mongoOptions.Find()
-	opts.SetSort(bson.D{{"duration", -1}})
-	sortCursor, err := episodesCollection.Find(ctx, bson.D{{"duration", bson.D{{"$gt", 24}}}}, opts)
-	if err != nil {
-		klog.Fatal(err)
+	var flags *featureflag.Store
+	if len(o.FeatureFlagCollection) > 0 {
+		flags = featureflag.NewStore(manager.Primary().Database(manager.Database()).Collection(o.FeatureFlagCollection))
+		if err := manager.RetryFor(client.ReadOperation, flags.Load); err != nil {
+			return fmt.Errorf("unable to load the feature flags: %w", err)
+		}
+		go flags.Watch(signalCtx)
 	}
-	var episodesSorted []bson.M
-	if err = sortCursor.All(ctx, &episodesSorted); err != nil {
-		klog.Fatal(err)
+
+	registry := jobs.NewRegistry()
+	if err := o.registerJobs(registry, cfg, locker, flags, manager.Database(), views); err != nil {
+		return err
 	}
-	fmt.Println(episodesSorted)
-}
+	runner := jobs.NewRunner(registry, manager.Primary(), jobExecutor(manager, breaker))
+	if len(o.ConfigFile) > 0 {
+		reloader := &configReloader{o: o, runner: runner, jobs: func(registry *jobs.Registry, cfg *config.Config) error {
+			views, err := materializedViews(cfg, manager.Database())
+			if err != nil {
+				return err
+			}
+			return o.registerJobs(registry, cfg, locker, flags, manager.Database(), views)
+		}}
+		go reloader.run(o.ConfigReloadInterval, stopCh)
+	}
+	run := runner.Run
+	if o.LeaderElection.Enabled {
+		elector, err := o.leaderElector(manager, runner, identity)
+		if err != nil {
+			return err
+		}
+		run = elector.Run
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		run(stopCh)
+	}()
 
-func update(client *mongo.Client) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	<-stopCh
+	// A second signal exits without waiting for the jobs.
+	stop()
+	klog.Infof("Exit...")
+	<-done
+	return nil
+}
 
-	quickstartDatabase := client.Database("sampledb")
-	podcastsCollection := quickstartDatabase.Collection("podcasts")
-
-	// UpdateOne()
-	fmt.Println("Updating by ID (610414778b0a99f9bc7f248b)")
-	id, _ := primitive.ObjectIDFromHex("610414778b0a99f9bc7f248b")
-	result, err := podcastsCollection.UpdateOne(
-		ctx,
-		bson.M{"_id": id},
-		bson.D{
-			{"$set", bson.D{{"author", "Nic Raboy"}}},
+// registerJobs adds the built-in background jobs, the backups, retention policies, archives and
+// downsampling policies of the config file and the refreshes of views to registry, applying the
+// scheduling defaults from the command line and the per-job overrides from the config file. The
+// policies and archives without a database apply to database. With flags, the jobs are skipped while
+// their feature flag is false.
+func (o *options) registerJobs(registry *jobs.Registry, cfg *config.Config, locker *lock.Locker, flags *featureflag.Store, database string, views []*materialize.View) error {
+	specs := []jobs.Spec{
+		{
+			Name:    "heartbeat",
+			Timeout: time.Minute,
+			Handler: func(ctx context.Context, c *mongo.Client) error {
+				return c.Ping(ctx, readpref.Primary())
+			},
 		},
-	)
+	}
+	jobConfigs := map[string]config.JobConfig{}
+	for name, jobConfig := range cfg.Jobs {
+		jobConfigs[name] = jobConfig
+	}
+	cfg, err := o.withProfileDatabases(cfg)
 	if err != nil {
-		klog.Fatal(err)
+		return err
 	}
-	fmt.Printf("Updated %v Documents!\n", result.ModifiedCount)
 
-	// UpdateMany()
-	fmt.Println("Updating by filter")
-	result, err = podcastsCollection.UpdateMany(
-		ctx,
-		bson.M{"title": "The Polyglot Developer Podcast"},
-		bson.D{
-			{"$set", bson.D{{"author", "Nicolas Raboy"}}},
-		},
-	)
+	backups, err := backupJobs(cfg)
 	if err != nil {
-		klog.Fatal(err)
-	}
-	fmt.Printf("Updated %v Documents!\n", result.ModifiedCount)
-
-	// ReplaceOne()
-	fmt.Println("Replacing document by filter")
-	result, err = podcastsCollection.ReplaceOne(
-		ctx,
-		bson.M{"author": "Nic Raboy"},
-		bson.M{
-			"title":  "The Nic Raboy Show",
-			"author": "Nicolas Raboy",
-		},
-	)
-	fmt.Printf("Replaced %v Documents!\n", result.ModifiedCount)
-}
-
-func delete(client *mongo.Client) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	quickstartDatabase := client.Database("sampledb")
-	podcastsCollection := quickstartDatabase.Collection("podcasts")
-	episodesCollection := quickstartDatabase.Collection("episodes")
-
-	// DeleteOne
-	fmt.Println("Deleting Document by filter")
-	result, err := podcastsCollection.DeleteOne(ctx, bson.M{"title": "The Polyglot Developer Podcast"})
+		return err
+	}
+	policies, err := retentionJobs(cfg, database)
 	if err != nil {
-		klog.Fatal(err)
+		return err
 	}
-	fmt.Printf("DeleteOne removed %v document(s)\n", result.DeletedCount)
-
-	// DeleteMany
-	fmt.Println("Deleting Multiple Documents by filter")
-	result, err = episodesCollection.DeleteMany(ctx, bson.M{"duration": 25})
+	archives, err := archivalJobs(cfg, database)
 	if err != nil {
-		klog.Fatal(err)
+		return err
 	}
-	fmt.Printf("DeleteMany removed %v document(s)\n", result.DeletedCount)
-
-	// Drop
-	fmt.Println("Dropping entire collection")
-	if err = podcastsCollection.Drop(ctx); err != nil {
-		klog.Fatal(err)
+	rollups, err := downsampleJobs(cfg, database)
+	if err != nil {
+		return err
+	}
+	refreshes := materializeJobs(cfg, views)
+	for _, configured := range append(append(append(append(backups, policies...), archives...), rollups...), refreshes...) {
+		specs = append(specs, configured.spec)
+		jobConfigs[configured.spec.Name] = configured.config
 	}
-}
 
-type Podcast struct {
-	ID     primitive.ObjectID `bson:"_id,omitempty"`
-	Title  string             `bson:"title,omitempty"`
-	Author string             `bson:"author,omitempty"`
-	Tags   []string           `bson:"tags,omitempty"`
-}
+	if o.profiles == nil {
+		o.profiles = &profileClients{o: o}
+	}
+	known := sets.NewString()
+	for _, spec := range specs {
+		known.Insert(spec.Name)
+		jobConfig := jobConfigs[spec.Name]
+		if jobConfig.Disabled {
+			klog.Infof("Job %s is disabled", spec.Name)
+			continue
+		}
 
-type Episode struct {
-	ID          primitive.ObjectID `bson:"_id,omitempty"`
-	Podcast     primitive.ObjectID `bson:"podcast,omitempty"`
-	Title       string             `bson:"title,omitempty"`
-	Description string             `bson:"description,omitempty"`
-	Duration    int32              `bson:"duration,omitempty"`
-}
+		expression := o.Schedule
+		if len(jobConfig.Schedule) > 0 {
+			expression = jobConfig.Schedule
+		}
+		schedule, err := jobs.ParseSchedule(expression)
+		if err != nil {
+			return fmt.Errorf("job %s: %w", spec.Name, err)
+		}
+		jitter := o.ScheduleJitter
+		if jobConfig.Jitter != nil {
+			jitter = jobConfig.Jitter.Duration
+		}
+		spec.Schedule = jobs.WithJitter(schedule, jitter)
+		if jobConfig.Timeout != nil {
+			spec.Timeout = jobConfig.Timeout.Duration
+		}
+		spec.RunOnStart = o.RunOnStart
+		if jobConfig.RunOnStart != nil {
+			spec.RunOnStart = *jobConfig.RunOnStart
+		}
+		if len(jobConfig.Profile) > 0 {
+			if _, err := o.profile(jobConfig.Profile); err != nil {
+				return fmt.Errorf("job %s: %w", spec.Name, err)
+			}
+			spec.Handler = onProfile(o.profiles, jobConfig.Profile, spec.Handler)
+		}
+		if jobConfig.Exclusive {
+			spec.Handler = exclusive(locker, "job/"+spec.Name, spec.Handler)
+		}
+		if flags != nil {
+			spec.Handler = toggled(flags, spec.Name, spec.Handler)
+		}
 
-func structures(client *mongo.Client) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+		if err := registry.Register(jobs.New(spec)); err != nil {
+			return err
+		}
+	}
 
-	quickstartDatabase := client.Database("sampledb")
-	podcastsCollection := quickstartDatabase.Collection("podcasts")
-	episodesCollection := quickstartDatabase.Collection("episodes")
+	for name := range cfg.Jobs {
+		if !known.Has(name) {
+			klog.Warningf("Ignoring configuration for unknown job %q", name)
+		}
+	}
+	return nil
+}
 
-	// Reading into GO Types
-	fmt.Println("Reading into Go Types")
-	var episodes []Episode
-	cursor, err := episodesCollection.Find(ctx, bson.M{"duration": bson.D{{"$gt", 25}}})
-	if err != nil {
-		panic(err)
+// onProfile wraps handler so that it runs against the deployment of the profile called name.
+func onProfile(profiles *profileClients, name string, handler jobs.Handler) jobs.Handler {
+	return func(ctx context.Context, _ *mongo.Client) error {
+		c, err := profiles.get(ctx, name)
+		if err != nil {
+			return err
+		}
+		return handler(ctx, c)
 	}
-	if err = cursor.All(ctx, &episodes); err != nil {
-		panic(err)
+}
+
+// exclusive wraps handler so that it is skipped while another instance holds the lock called name.
+func exclusive(locker *lock.Locker, name string, handler jobs.Handler) jobs.Handler {
+	return func(ctx context.Context, c *mongo.Client) error {
+		err := locker.Do(ctx, name, func(ctx context.Context) error {
+			return handler(ctx, c)
+		})
+		if errors.Is(err, lock.ErrLocked) {
+			return fmt.Errorf("%w: %v", jobs.ErrSkipped, err)
+		}
+		return err
 	}
-	fmt.Println(episodes)
+}
 
-	// Creating using GO Types
-	fmt.Println("Creating using Go Types")
-	podcast := Podcast{
-		Title:  "The Polyglot Developer",
-		Author: "Nic Raboy",
-		Tags:   []string{"development", "programming", "coding"},
+// identity returns the unique name of this instance used for leases and locks.
+func (o *options) identity() (string, error) {
+	if len(o.Identity) > 0 {
+		return o.Identity, nil
 	}
-	insertResult, err := podcastsCollection.InsertOne(ctx, podcast)
+	hostname, err := os.Hostname()
 	if err != nil {
-		panic(err)
+		return "", fmt.Errorf("unable to determine instance identity: %w", err)
 	}
-	fmt.Println(insertResult.InsertedID)
+	return fmt.Sprintf("%s_%s", hostname, primitive.NewObjectID().Hex()), nil
 }
 
-func mainProcessLoop(stopCh <-chan struct{}) {
-	// Loop, every 5 minutes, forever...
-	wait.Until(func() {
-		start := time.Now()
-		_, err := processLoop()
-		duration := time.Since(start)
-
-		if err != nil {
-			klog.Errorf("processLoop failed: %v", err)
-			return
-		}
-
-		klog.Infof("processLoop finished in: %d ms", duration.Milliseconds())
-	}, 5*time.Minute, stopCh)
+// leaderElector returns an elector that only runs the background jobs while this instance holds the lease.
+func (o *options) leaderElector(manager *client.ConnectionManager, runner *jobs.Runner, identity string) (*leaderelection.LeaderElector, error) {
+	return leaderelection.NewLeaderElector(leaderelection.Config{
+		Collection:    manager.Primary().Database(manager.Database()).Collection(o.LeaderElection.Collection),
+		Name:          o.LeaderElection.Name,
+		Identity:      identity,
+		LeaseDuration: o.LeaderElection.LeaseDuration,
+		RenewDeadline: o.LeaderElection.RenewDeadline,
+		RetryPeriod:   o.LeaderElection.RetryPeriod,
+		OnStartedLeading: func(ctx context.Context) {
+			klog.Infof("Became leader, starting background jobs")
+			runner.Run(ctx.Done())
+		},
+		OnStoppedLeading: func() {
+			klog.Infof("Lost leadership, background jobs stopped")
+		},
+	})
 }
 
-func processLoop() (bool, error) {
-	return true, nil
+// jobExecutor runs every job behind the circuit breaker and the retry policy of manager.
+func jobExecutor(manager *client.ConnectionManager, breaker *client.CircuitBreaker) jobs.Executor {
+	return func(ctx context.Context, fn func(ctx context.Context) error) error {
+		err := breaker.Execute(ctx, func(ctx context.Context) error {
+			return manager.Retry(ctx, fn)
+		})
+		if err == client.ErrCircuitOpen {
+			return fmt.Errorf("%w: database is unavailable", jobs.ErrSkipped)
+		}
+		return err
+	}
 }
 
 func main() {
@@ -390,24 +822,166 @@ func main() {
 	original.Set("v", "2")
 
 	opt := &options{
-		ListenAddr: ":8080",
+		ListenAddr:       ":8080",
+		OperationTimeout: client.DefaultOperationTimeout,
+		RetryWrites:      true,
+		RetryReads:       true,
+		Idempotency:      api.Idempotency{TTL: 24 * time.Hour},
+		Retry:            client.DefaultRetryPolicy,
+		Breaker:          client.DefaultBreakerConfig,
+		Schedule:         "*/5 * * * *",
+		RunOnStart:       true,
+		Fixtures:         "fixtures",
+		LockCollection:   "locks",
+		LockTTL:          time.Minute,
+		LeaderElection: leaderElectionOptions{
+			Name:          "mongodb-client",
+			Collection:    "leases",
+			LeaseDuration: 15 * time.Second,
+			RenewDeadline: 10 * time.Second,
+			RetryPeriod:   2 * time.Second,
+		},
+		ConfigReloadInterval: 10 * time.Second,
 	}
 
 	cmd := &cobra.Command{
+		Use:   "mongodb-client",
+		Short: "Runs the background database jobs, or one of the subcommands against the configured database",
+		Long: `Runs the background database jobs and serves the endpoints enabled by the flags, or one of the
+subcommands against the configured database.
+
+With --one-shot, the deployment is reconciled with --manifest, the --migrations are applied and the
+--fixture-set are loaded, then the process exits instead of running the jobs, so it can run as the
+init container of the pods of an application. The process, and every subcommand, exits with:
+
+  0  success
+  1  any other failure
+  2  the database could not be reached
+  3  invalid flags, config file, manifest, migrations or fixtures, which retrying does not fix`,
+		PersistentPreRunE: func(cmd *cobra.Command, arguments []string) error {
+			return opt.startAudit(cmd.CommandPath())
+		},
 		Run: func(cmd *cobra.Command, arguments []string) {
 			if err := opt.Run(); err != nil {
-				klog.Exitf("Run error: %v", err)
+				exit("Run error: %v", err)
 			}
 		},
 	}
 
+	persistent := cmd.PersistentFlags()
+	persistent.StringVar(&opt.ConfigFile, "config", opt.ConfigFile, "Path to a YAML or JSON configuration file")
+	persistent.StringVar(&opt.Profile, "profile", opt.Profile, "Connect with this profile of the config file instead of the MONGODB_* environment variables")
+	persistent.BoolVar(&opt.ReadOnly, "read-only", opt.ReadOnly, "Refuse every command that could write data, users or the configuration of the deployment, whatever the subcommand or API request issues it; disables --compressors")
+	persistent.BoolVar(&opt.Yes, "yes", opt.Yes, "Confirm the drops, deletions of every matching document and restores over existing collections without asking, required when there is no terminal to ask on")
+	persistent.StringVarP(&opt.Output, "output", "o", opt.Output, fmt.Sprintf("Output format of the results of the subcommands: %s, defaults to tables for lists and JSON for documents", strings.Join(outputFormats, ", ")))
+	persistent.StringVar(&ejson.Mode, "ejson", ejson.Mode, fmt.Sprintf("Extended JSON documents are printed as: %s, canonical keeps the type of every number and date", strings.Join(ejsonModes, " or ")))
+	persistent.BoolVar(&ejson.StrictNumbers, "strict-numbers", ejson.StrictNumbers, "Reject the numbers of documents that a double would round instead of warning about them, and print longs beyond 2^53 as {\"$numberLong\": ...} in relaxed Extended JSON")
+	persistent.IntVar(&ejson.Indent, "indent", ejson.Indent, "Number of spaces printed documents are indented with, 0 prints each document on a single line")
+	persistent.StringSliceVar(&opt.Compressors, "compressors", opt.Compressors, "Comma-separated list of compressors to enable on the database connection, in order of preference (snappy, zlib, zstd), ignored with --read-only")
+	persistent.Uint64Var(&opt.MaxPoolSize, "max-pool-size", opt.MaxPoolSize, "Maximum number of connections in the connection pool (0 uses the driver default)")
+	persistent.Uint64Var(&opt.MinPoolSize, "min-pool-size", opt.MinPoolSize, "Minimum number of connections kept open in the connection pool")
+	persistent.DurationVar(&opt.MaxConnIdleTime, "max-conn-idle-time", opt.MaxConnIdleTime, "Maximum amount of time a connection may remain idle in the pool before being closed (0 means no limit)")
+	persistent.DurationVar(&opt.ConnectTimeout, "connect-timeout", opt.ConnectTimeout, "Timeout for establishing a new connection to the database (0 uses the driver default)")
+	persistent.BoolVar(&opt.RetryWrites, "retry-writes", opt.RetryWrites, "Let the driver retry a write once after a failover or a network error, --retry-writes=false disables it")
+	persistent.BoolVar(&opt.RetryReads, "retry-reads", opt.RetryReads, "Let the driver retry a read once after a failover or a network error, --retry-reads=false disables it")
+	persistent.DurationVar(&opt.OperationTimeout, "operation-timeout", opt.OperationTimeout, "Timeout applied to each individual database operation")
+	persistent.IntVar(&opt.Retry.Attempts, "retry-attempts", opt.Retry.Attempts, "Number of times an operation is attempted when it fails with a transient error")
+	persistent.DurationVar(&opt.Retry.Backoff, "retry-backoff", opt.Retry.Backoff, "Delay before the first retry of a failed operation, doubled after every attempt")
+	persistent.DurationVar(&opt.Retry.MaxBackoff, "retry-max-backoff", opt.Retry.MaxBackoff, "Maximum delay between two attempts of a failed operation")
+	persistent.Float64Var(&opt.Retry.Jitter, "retry-jitter", opt.Retry.Jitter, "Fraction of the retry delay added as random jitter")
+	persistent.AddGoFlag(original.Lookup("v"))
+
 	flagset := cmd.Flags()
 	flagset.BoolVar(&opt.DryRun, "dry-run", opt.DryRun, "Perform no actions")
+	flagset.BoolVar(&opt.OneShot, "one-shot", opt.OneShot, "Apply --manifest, --migrations and --fixture-set then exit, instead of serving and running the background jobs")
+	flagset.DurationVar(&opt.ConfigReloadInterval, "config-reload-interval", opt.ConfigReloadInterval, "Interval at which --config is checked for changes, which reschedule the jobs and set the log level without a restart, as SIGHUP does, 0 only reloads it on SIGHUP")
+	flagset.StringVar(&opt.Migrations, "migrations", opt.Migrations, "Apply the migrations of this directory, and those registered in Go, on start, see the migrate command, only listing them with --dry-run")
+	flagset.StringVar(&opt.Fixtures, "fixtures", opt.Fixtures, "Directory of the fixture sets loaded on start, see the fixtures command")
+	flagset.StringSliceVar(&opt.FixtureSets, "fixture-set", opt.FixtureSets, "Load these fixture sets of --fixtures on start, replacing their documents, only printing them with --dry-run")
+	flagset.StringVar(&opt.Manifest, "manifest", opt.Manifest, "Reconcile the deployment with this manifest on start, see the provision command, only printing the changes with --dry-run")
 	flagset.StringVar(&opt.ListenAddr, "listen", opt.ListenAddr, "The address to serve information on")
-
-	flagset.AddGoFlag(original.Lookup("v"))
-
-	if err := cmd.Execute(); err != nil {
-		klog.Exitf("Execute error: %v", err)
+	flagset.StringVar(&opt.ListenTLS.CertFile, "listen-tls-cert", opt.ListenTLS.CertFile, "PEM encoded certificate to serve the listen address over HTTPS with, reloaded when it changes")
+	flagset.StringVar(&opt.ListenTLS.KeyFile, "listen-tls-key", opt.ListenTLS.KeyFile, "PEM encoded private key of --listen-tls-cert")
+	flagset.StringVar(&opt.ListenTLS.ClientCAFile, "listen-client-ca", opt.ListenTLS.ClientCAFile, "PEM encoded CA certificates that must have signed the client certificates of the listen address")
+	flagset.StringVar(&opt.GRPCListenAddr, "grpc-listen", opt.GRPCListenAddr, "The address to serve the Documents gRPC service on, such as :9090, using the certificates of the listen address")
+	flagset.BoolVar(&opt.EnableAPI, "enable-api", opt.EnableAPI, "Serve a REST API for CRUD operations at /api/v1/{db}/{collection} on the listen address, described at /api/openapi.json, and the materialized views of the config file at /api/materialized/")
+	flagset.StringVar(&opt.Idempotency.Collection, "api-idempotency-collection", opt.Idempotency.Collection, "Collection of the application database recording the responses of the API write requests with an Idempotency-Key header, whose retries then get the recorded response, disabled when empty")
+	flagset.DurationVar(&opt.Idempotency.TTL, "api-idempotency-ttl", opt.Idempotency.TTL, "Time the responses of the requests with an Idempotency-Key header are kept")
+	flagset.BoolVar(&opt.EnableWatch, "enable-websocket-watch", opt.EnableWatch, "Stream the changes of collections to WebSocket clients at /ws/watch/{db}/{collection} on the listen address")
+	flagset.StringSliceVar(&opt.AllowedOrigins, "websocket-allowed-origins", opt.AllowedOrigins, "Origins of the pages allowed to open WebSocket connections, * for any, defaults to the same origin")
+	flagset.BoolVar(&opt.EnableDebug, "enable-debug-endpoints", opt.EnableDebug, "Serve profiles at /debug/pprof/ and runtime and connection pool statistics at /debug/vars on the listen address")
+	flagset.BoolVar(&opt.EnableUI, "enable-ui", opt.EnableUI, "Serve a read-only dashboard of the databases, collections and health of the client at /ui/ on the listen address")
+	flagset.BoolVar(&opt.EnableGraphQL, "enable-graphql", opt.EnableGraphQL, "Serve the collections of the graphql section of the config file at /graphql on the listen address")
+	flagset.IntVar(&opt.Breaker.Threshold, "circuit-breaker-threshold", opt.Breaker.Threshold, "Number of consecutive failed iterations after which database work is suspended")
+	flagset.DurationVar(&opt.Breaker.Backoff, "circuit-breaker-backoff", opt.Breaker.Backoff, "Delay before the database is first probed while the circuit breaker is open, doubled after every failed probe")
+	flagset.DurationVar(&opt.Breaker.MaxBackoff, "circuit-breaker-max-backoff", opt.Breaker.MaxBackoff, "Maximum delay between two probes while the circuit breaker is open")
+	flagset.StringVar(&opt.Schedule, "schedule", opt.Schedule, "Default cron expression (or @every <duration>) for background jobs without a schedule in the config file")
+	flagset.DurationVar(&opt.ScheduleJitter, "schedule-jitter", opt.ScheduleJitter, "Maximum random delay added to every scheduled job activation")
+	flagset.BoolVar(&opt.RunOnStart, "run-on-start", opt.RunOnStart, "Run every background job once immediately on start, in addition to its schedule")
+	flagset.StringVar(&opt.Identity, "identity", opt.Identity, "Unique identity of this instance used for leases and locks, defaults to the hostname with a random suffix")
+	flagset.StringVar(&opt.LockCollection, "lock-collection", opt.LockCollection, "Collection of the application database in which distributed locks are stored")
+	flagset.DurationVar(&opt.LockTTL, "lock-ttl", opt.LockTTL, "Time after which a distributed lock expires unless renewed by its holder")
+	flagset.StringVar(&opt.FeatureFlagCollection, "feature-flag-collection", opt.FeatureFlagCollection, "Collection of the application database holding feature flags, see the feature-flag command, whose flag jobs.NAME.enabled skips the job NAME while false")
+	flagset.BoolVar(&opt.LeaderElection.Enabled, "leader-elect", opt.LeaderElection.Enabled, "Only run background jobs on the instance holding the leader lease, so several replicas can be deployed")
+	flagset.StringVar(&opt.LeaderElection.Name, "leader-election-name", opt.LeaderElection.Name, "Name of the leader lease")
+	flagset.StringVar(&opt.LeaderElection.Collection, "leader-election-collection", opt.LeaderElection.Collection, "Collection of the application database in which leases are stored")
+	flagset.DurationVar(&opt.LeaderElection.LeaseDuration, "leader-election-lease-duration", opt.LeaderElection.LeaseDuration, "Time followers wait after the last renewal before taking over the lease")
+	flagset.DurationVar(&opt.LeaderElection.RenewDeadline, "leader-election-renew-deadline", opt.LeaderElection.RenewDeadline, "Time the leader keeps retrying to renew the lease before giving up leadership")
+	flagset.DurationVar(&opt.LeaderElection.RetryPeriod, "leader-election-retry-period", opt.LeaderElection.RetryPeriod, "Interval between attempts to acquire or renew the lease")
+
+	cmd.AddCommand(newShellCommand(opt))
+	cmd.AddCommand(newQueryCommand(opt))
+	cmd.AddCommand(newSQLCommand(opt))
+	cmd.AddCommand(newOIDCommand(opt))
+	cmd.AddCommand(newCountCommand(opt))
+	cmd.AddCommand(newEstimatedCountCommand(opt))
+	cmd.AddCommand(newDistinctCommand(opt))
+	cmd.AddCommand(newFindAndModifyCommand(opt))
+	cmd.AddCommand(newQueueCommand(opt))
+	cmd.AddCommand(newFeatureFlagCommand(opt))
+	cmd.AddCommand(newSearchCommand(opt))
+	cmd.AddCommand(newSearchIndexCommand(opt))
+	cmd.AddCommand(newGeoCommand(opt))
+	cmd.AddCommand(newAggregateCommand(opt))
+	cmd.AddCommand(newInsertCommand(opt))
+	cmd.AddCommand(newExportCommand(opt))
+	cmd.AddCommand(newImportCommand(opt))
+	cmd.AddCommand(newDumpCommand(opt))
+	cmd.AddCommand(newRestoreCommand(opt))
+	cmd.AddCommand(newArchiveCommand(opt))
+	cmd.AddCommand(newGridFSCommand(opt))
+	cmd.AddCommand(newEncryptionCommand(opt))
+	cmd.AddCommand(newTimeSeriesCommand(opt))
+	cmd.AddCommand(newCappedCommand(opt))
+	cmd.AddCommand(newViewCommand(opt))
+	cmd.AddCommand(newCopyCommand(opt))
+	cmd.AddCommand(newDiffCommand(opt))
+	cmd.AddCommand(newCheckRefsCommand(opt))
+	cmd.AddCommand(newDedupeCommand(opt))
+	cmd.AddCommand(newWatchCommand(opt))
+	cmd.AddCommand(newTailCommand(opt))
+	cmd.AddCommand(newSyncCommand(opt))
+	cmd.AddCommand(newProvisionCommand(opt))
+	cmd.AddCommand(newIndexCommand(opt))
+	cmd.AddCommand(newAdminCommand(opt))
+	cmd.AddCommand(newStatsCommand(opt))
+	cmd.AddCommand(newProfileCommand(opt))
+	cmd.AddCommand(newSchemaCommand(opt))
+	cmd.AddCommand(newInferSchemaCommand(opt))
+	cmd.AddCommand(newMigrateCommand(opt))
+	cmd.AddCommand(newFixturesCommand(opt))
+	cmd.AddCommand(newGenerateCommand(opt))
+	cmd.AddCommand(newBenchCommand(opt))
+	cmd.AddCommand(newAuditCommand(opt))
+	cmd.AddCommand(newOperatorCommand(opt))
+	cmd.AddCommand(newSidecarCommand(opt))
+
+	cmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return validationError(err)
+	})
+	err := cmd.Execute()
+	opt.stopAudit()
+	if err != nil {
+		exit("Execute error: %v", err)
 	}
 }
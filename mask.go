@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/archive"
+	"github.com/bradmwilliams/mongodb-client/pkg/mask"
+	"github.com/spf13/pflag"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const maskUsage = "YAML or JSON file of rules masking the fields of the documents by namespace with the hash, redact, faker or partial strategies"
+
+func addMaskFlag(flagset *pflag.FlagSet, file *string) {
+	flagset.StringVar(file, "mask", *file, maskUsage)
+}
+
+// maskTransform returns a transform masking the documents with the rules of file, nil when file is
+// empty.
+func maskTransform(file string) (func(archive.Namespace, bson.Raw) (bson.Raw, error), error) {
+	if len(file) == 0 {
+		return nil, nil
+	}
+	masker, err := mask.Load(file)
+	if err != nil {
+		return nil, fmt.Errorf("--mask: %w", err)
+	}
+	return func(namespace archive.Namespace, doc bson.Raw) (bson.Raw, error) {
+		masked, err := masker.Collection(namespace.Database, namespace.Collection).Apply(doc)
+		if err != nil {
+			return nil, fmt.Errorf("unable to mask a document of %s: %w", namespace, err)
+		}
+		return masked, nil
+	}, nil
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/client"
+	"github.com/bradmwilliams/mongodb-client/pkg/lock"
+	"github.com/bradmwilliams/mongodb-client/pkg/migrate"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+type migrateOptions struct {
+	Database   string
+	Dir        string
+	Collection string
+}
+
+func (m *migrateOptions) addFlags(flagset *pflag.FlagSet) {
+	flagset.StringVar(&m.Database, "db", m.Database, "Database to migrate, defaults to MONGODB_DATABASE")
+	flagset.StringVar(&m.Dir, "dir", m.Dir, "Directory of the migration scripts, named <version>_<name>.yaml or .json")
+	flagset.StringVar(&m.Collection, "collection", m.Collection, "Collection of the database recording the applied migrations")
+}
+
+func newMigrateCommand(o *options) *cobra.Command {
+	m := &migrateOptions{
+		Collection: migrate.DefaultCollection,
+	}
+	cmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Apply, revert and list versioned schema and data migrations",
+		Long: `Apply versioned migrations to a database, in order of version, each of them once.
+
+Migrations are the scripts of --dir, together with the migrations registered in Go by the
+application. A script is a YAML or JSON file called <version>_<name>.yaml whose up and down lists
+hold steps, each of them one of:
+
+  command: <database command>
+  aggregate: <collection>, pipeline: [<stages>]
+  updateMany: <collection>, filter: <query>, update: <update document or pipeline>
+  deleteMany: <collection>, filter: <query>
+
+The applied migrations are recorded in --collection. A lock in the --lock-collection of the
+database keeps several instances from migrating at once.`,
+		Example: `  mongodb-client migrate status --dir migrations
+  mongodb-client migrate up --dir migrations
+  mongodb-client migrate down --dir migrations --steps 2`,
+	}
+	cmd.AddCommand(newMigrateUpCommand(o, m))
+	cmd.AddCommand(newMigrateDownCommand(o, m))
+	cmd.AddCommand(newMigrateStatusCommand(o, m))
+	return cmd
+}
+
+// migrator returns the migrator of the scripts of --dir and of the registered migrations.
+func (m *migrateOptions) migrator(o *options, manager *client.ConnectionManager) (*migrate.Migrator, error) {
+	migrations := migrate.Registered()
+	if len(m.Dir) > 0 {
+		scripts, err := migrate.LoadDir(m.Dir)
+		if err != nil {
+			return nil, validationError(err)
+		}
+		migrations = append(migrations, scripts...)
+	}
+	database := m.Database
+	if len(database) == 0 {
+		database = manager.Database()
+	}
+	identity, err := o.identity()
+	if err != nil {
+		return nil, err
+	}
+	db := manager.Primary().Database(database)
+	migrator, err := migrate.New(db, lock.NewLocker(db.Collection(o.LockCollection), identity, o.LockTTL), migrations)
+	if err != nil {
+		return nil, validationError(err)
+	}
+	migrator.Collection = m.Collection
+	return migrator, nil
+}
+
+type migrateUpOptions struct {
+	To     int64
+	DryRun bool
+}
+
+func newMigrateUpCommand(o *options, m *migrateOptions) *cobra.Command {
+	u := &migrateUpOptions{}
+	cmd := &cobra.Command{
+		Use:   "up",
+		Short: "Apply the pending migrations",
+		Example: `  mongodb-client migrate up --dir migrations
+  mongodb-client migrate up --dir migrations --to 20210801120000 --dry-run`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			return u.run(o, m)
+		},
+	}
+
+	flagset := cmd.Flags()
+	m.addFlags(flagset)
+	flagset.Int64Var(&u.To, "to", u.To, "Apply the migrations up to and including this version, all of them by default")
+	flagset.BoolVar(&u.DryRun, "dry-run", u.DryRun, "List the migrations that would be applied without applying them")
+	return cmd
+}
+
+func (u *migrateUpOptions) run(o *options, m *migrateOptions) error {
+	if u.To < 0 {
+		return fmt.Errorf("--to must not be negative")
+	}
+	manager, err := o.connect()
+	if err != nil {
+		return err
+	}
+	defer disconnect(manager)
+	migrator, err := m.migrator(o, manager)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := cmdContext()
+	defer cancel()
+	applied, err := migrator.Up(ctx, u.To, u.DryRun)
+	printMigrations(applied, "applied", u.DryRun)
+	return err
+}
+
+type migrateDownOptions struct {
+	To     int64
+	Steps  int
+	DryRun bool
+}
+
+func newMigrateDownCommand(o *options, m *migrateOptions) *cobra.Command {
+	d := &migrateDownOptions{
+		Steps: 1,
+	}
+	cmd := &cobra.Command{
+		Use:   "down",
+		Short: "Revert the last applied migrations",
+		Example: `  mongodb-client migrate down --dir migrations
+  mongodb-client migrate down --dir migrations --to 20210801120000`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			return d.run(o, m)
+		},
+	}
+
+	flagset := cmd.Flags()
+	m.addFlags(flagset)
+	flagset.IntVar(&d.Steps, "steps", d.Steps, "Number of migrations to revert, latest first")
+	flagset.Int64Var(&d.To, "to", d.To, "Revert the migrations later than this version instead of --steps")
+	flagset.BoolVar(&d.DryRun, "dry-run", d.DryRun, "List the migrations that would be reverted without reverting them")
+	return cmd
+}
+
+func (d *migrateDownOptions) run(o *options, m *migrateOptions) error {
+	if d.To < 0 {
+		return fmt.Errorf("--to must not be negative")
+	}
+	if d.To == 0 && d.Steps < 1 {
+		return fmt.Errorf("--steps must be at least 1")
+	}
+	manager, err := o.connect()
+	if err != nil {
+		return err
+	}
+	defer disconnect(manager)
+	migrator, err := m.migrator(o, manager)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := cmdContext()
+	defer cancel()
+	reverted, err := migrator.Down(ctx, d.To, d.Steps, d.DryRun)
+	printMigrations(reverted, "reverted", d.DryRun)
+	return err
+}
+
+// printMigrations lists the migrations that were applied or reverted on standard error.
+func printMigrations(migrations []migrate.Migration, done string, dryRun bool) {
+	if dryRun {
+		done = "to be " + done
+	}
+	for _, migration := range migrations {
+		fmt.Fprintf(os.Stderr, "%d %s: %s\n", migration.Version, migration.Name, done)
+	}
+	if len(migrations) == 0 {
+		fmt.Fprintf(os.Stderr, "No migrations %s\n", done)
+	}
+}
+
+func newMigrateStatusCommand(o *options, m *migrateOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "status",
+		Short:   "List the migrations and whether they are applied",
+		Example: `  mongodb-client migrate status --dir migrations`,
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			out, err := o.printer()
+			if err != nil {
+				return err
+			}
+			manager, err := o.connect()
+			if err != nil {
+				return err
+			}
+			defer disconnect(manager)
+			migrator, err := m.migrator(o, manager)
+			if err != nil {
+				return err
+			}
+
+			ctx, cancel := manager.Context()
+			defer cancel()
+			statuses, err := migrator.Status(ctx)
+			if err != nil {
+				return err
+			}
+			return out.print(os.Stdout, statuses, func(stdout io.Writer) error {
+				w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+				fmt.Fprintln(w, "VERSION\tNAME\tSTATE\tAPPLIED\tDURATION")
+				for _, status := range statuses {
+					state, applied, duration := "pending", "", ""
+					if status.Applied != nil {
+						state = "applied"
+						applied = status.Applied.AppliedAt.Format(time.RFC3339)
+						duration = (time.Duration(status.Applied.Duration) * time.Millisecond).String()
+					}
+					if !status.Known {
+						state = "applied, not defined"
+					}
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", strconv.FormatInt(status.Version, 10), status.Name, state, applied, duration)
+				}
+				return w.Flush()
+			})
+		},
+	}
+	m.addFlags(cmd.Flags())
+	return cmd
+}
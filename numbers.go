@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/shopspring/decimal"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"k8s.io/klog"
+)
+
+// maxSafeInteger is the largest integer every JSON parser reads exactly, since most of them read
+// numbers as doubles.
+const maxSafeInteger = 1 << 53
+
+// preciseNumbers returns the Extended JSON text with the plain numbers that a double can not hold
+// rewritten so that they are not rounded when the text is parsed: the integers beyond the range of
+// a long become Decimal128 values. The decimal numbers a double would round are reported, as an
+// error with --strict-numbers and as a warning otherwise, since they should be written
+// {"$numberDecimal": "..."} to be stored exactly.
+func (e ejsonOptions) preciseNumbers(text string) (string, error) {
+	return rewriteNumbers(text, func(literal string) (string, error) {
+		if isInteger(literal) {
+			if _, err := strconv.ParseInt(literal, 10, 64); err == nil {
+				return literal, nil
+			}
+			if _, err := primitive.ParseDecimal128(literal); err == nil {
+				return fmt.Sprintf(`{"$numberDecimal":"%s"}`, literal), nil
+			}
+		}
+		f, err := strconv.ParseFloat(literal, 64)
+		if err != nil {
+			// The parser of the driver reports the numbers out of the range of a double.
+			return literal, nil
+		}
+		exact, err := decimal.NewFromString(literal)
+		if err != nil || exact.Equal(decimal.NewFromFloat(f)) {
+			return literal, nil
+		}
+		problem := fmt.Sprintf(`the number %s can not be stored exactly as a double, write it as {"$numberDecimal": "%s"} to keep its precision`, literal, literal)
+		if e.StrictNumbers {
+			return "", fmt.Errorf("%s", problem)
+		}
+		klog.Warningf("Rounding %s to %s: %s", literal, strconv.FormatFloat(f, 'g', -1, 64), problem)
+		return literal, nil
+	})
+}
+
+// safeLongs returns the relaxed Extended JSON text with the integers that parsers reading numbers
+// as doubles would round written {"$numberLong": "..."}.
+func safeLongs(text []byte) []byte {
+	rewritten, _ := rewriteNumbers(string(text), func(literal string) (string, error) {
+		n, err := strconv.ParseInt(literal, 10, 64)
+		if !isInteger(literal) || err != nil || (n <= maxSafeInteger && n >= -maxSafeInteger) {
+			return literal, nil
+		}
+		return fmt.Sprintf(`{"$numberLong":"%s"}`, literal), nil
+	})
+	return []byte(rewritten)
+}
+
+// isInteger reports whether the JSON number literal has neither a fraction nor an exponent.
+func isInteger(literal string) bool {
+	return !strings.ContainsAny(literal, ".eE")
+}
+
+// rewriteNumbers returns the JSON text with every number literal, outside of the strings, replaced
+// by the text replace returns for it.
+func rewriteNumbers(text string, replace func(literal string) (string, error)) (string, error) {
+	var out strings.Builder
+	inString, escaped := false, false
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case inString:
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+		case c == '"':
+			inString = true
+		case c == '-' || (c >= '0' && c <= '9'):
+			end := i + 1
+			for end < len(text) && strings.IndexByte("0123456789+-.eE", text[end]) >= 0 {
+				end++
+			}
+			replaced, err := replace(text[i:end])
+			if err != nil {
+				return "", err
+			}
+			out.WriteString(replaced)
+			i = end - 1
+			continue
+		}
+		out.WriteByte(c)
+	}
+	return out.String(), nil
+}
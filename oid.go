@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func newOIDCommand(o *options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "oid",
+		Short: "Generate and decode ObjectIDs and build _id filters for time ranges",
+		Long: `Generate ObjectIDs, decode the parts of existing ones, and build the filters selecting the
+documents of which the ObjectID was generated in a time range, since ObjectIDs start with the
+second they were generated in.
+
+An ObjectID is 12 bytes: a 4-byte timestamp in seconds since the epoch, a 5-byte value random per
+process, which older drivers wrote as a 3-byte machine identifier and a 2-byte process identifier,
+and a 3-byte counter.`,
+	}
+	cmd.AddCommand(newOIDNewCommand(o))
+	cmd.AddCommand(newOIDDecodeCommand(o))
+	cmd.AddCommand(newOIDRangeCommand(o))
+	return cmd
+}
+
+func newOIDNewCommand(o *options) *cobra.Command {
+	var count int
+	var at string
+	cmd := &cobra.Command{
+		Use:   "new",
+		Short: "Print new ObjectIDs",
+		Long: `Print new ObjectIDs in hexadecimal, one per line. With --time they are generated as if at that
+time, to insert test documents that look older than they are.`,
+		Example: `  mongodb-client oid new
+  mongodb-client oid new --count 3 --time 2024-01-02T15:04:05Z`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			if count < 1 {
+				return fmt.Errorf("--count must be at least 1")
+			}
+			t := time.Now()
+			if len(at) > 0 {
+				var err error
+				if t, err = parseOIDTime(at, t); err != nil {
+					return fmt.Errorf("--time: %w", err)
+				}
+			}
+			for i := 0; i < count; i++ {
+				fmt.Fprintln(os.Stdout, primitive.NewObjectIDFromTimestamp(t).Hex())
+			}
+			return nil
+		},
+	}
+	flagset := cmd.Flags()
+	flagset.IntVarP(&count, "count", "n", 1, "Number of ObjectIDs to print")
+	flagset.StringVar(&at, "time", at, "Generate the ObjectIDs at this time instead of now, see \"oid range --help\" for the formats")
+	return cmd
+}
+
+// objectIDParts are the parts of an ObjectID printed by oid decode.
+type objectIDParts struct {
+	ObjectID string    `json:"objectId"`
+	Time     time.Time `json:"time"`
+	Random   string    `json:"random"`
+	Machine  string    `json:"machine"`
+	Process  uint16    `json:"process"`
+	Counter  uint32    `json:"counter"`
+}
+
+func decodeObjectID(id primitive.ObjectID) objectIDParts {
+	return objectIDParts{
+		ObjectID: id.Hex(),
+		Time:     id.Timestamp().UTC(),
+		Random:   hex.EncodeToString(id[4:9]),
+		Machine:  hex.EncodeToString(id[4:7]),
+		Process:  binary.BigEndian.Uint16(id[7:9]),
+		Counter:  uint32(id[9])<<16 | uint32(id[10])<<8 | uint32(id[11]),
+	}
+}
+
+// parseObjectID parses an ObjectID written in hexadecimal, as ObjectId("...") or as {"$oid": "..."}
+// so that the values copied from a shell or from Extended JSON are accepted as is.
+func parseObjectID(value string) (primitive.ObjectID, error) {
+	text := strings.TrimSpace(value)
+	if strings.HasPrefix(text, "{") {
+		var doc struct {
+			ID primitive.ObjectID `bson:"id"`
+		}
+		if err := bson.UnmarshalExtJSON([]byte(`{"id":`+text+`}`), false, &doc); err == nil {
+			return doc.ID, nil
+		}
+	}
+	text = strings.TrimSuffix(strings.TrimPrefix(text, "ObjectId("), ")")
+	text = strings.Trim(text, `"'`)
+	id, err := primitive.ObjectIDFromHex(text)
+	if err != nil {
+		return primitive.NilObjectID, fmt.Errorf("%q is not an ObjectID, expected 24 hexadecimal digits", value)
+	}
+	return id, nil
+}
+
+func newOIDDecodeCommand(o *options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "decode OID...",
+		Short: "Print the time, random value and counter of ObjectIDs",
+		Long: `Print the parts of ObjectIDs written in hexadecimal, as ObjectId("...") or as {"$oid": "..."}:
+the time they were generated, in UTC, their random value, read as a machine and a process identifier
+by older drivers, and their counter.`,
+		Example: `  mongodb-client oid decode 5f0c8a8e9d1e2a3b4c5d6e7f
+  mongodb-client oid decode 'ObjectId("5f0c8a8e9d1e2a3b4c5d6e7f")' --output json`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			parts := make([]objectIDParts, 0, len(arguments))
+			for _, argument := range arguments {
+				id, err := parseObjectID(argument)
+				if err != nil {
+					return err
+				}
+				parts = append(parts, decodeObjectID(id))
+			}
+			out, err := o.printer()
+			if err != nil {
+				return err
+			}
+			return out.print(os.Stdout, parts, func(stdout io.Writer) error {
+				w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+				fmt.Fprintln(w, "OBJECTID\tTIME\tRANDOM\tMACHINE\tPROCESS\tCOUNTER")
+				for _, p := range parts {
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d\n", p.ObjectID, p.Time.Format(time.RFC3339), p.Random, p.Machine, p.Process, p.Counter)
+				}
+				return w.Flush()
+			})
+		},
+	}
+}
+
+func newOIDRangeCommand(o *options) *cobra.Command {
+	var from, to, day, field string
+	cmd := &cobra.Command{
+		Use:   "range",
+		Short: "Print the filter selecting the documents of which the ObjectID was generated in a time range",
+		Long: `Print the filter, as a line of Extended JSON for --filter, selecting the documents of which the
+ObjectID of a field was generated from --from, included, to --to, excluded. Either bound may be
+omitted, and --day selects a whole day in UTC.
+
+Times are RFC 3339 times, days written 2006-01-02, seconds since the epoch, or durations before now
+such as 90m, 36h or 7d. Since the timestamps of ObjectIDs are seconds, the fractions of the bounds
+are truncated.`,
+		Example: `  mongodb-client oid range --day 2024-01-02
+  mongodb-client oid range --from 7d
+  mongodb-client query --collection episodes --filter "$(mongodb-client oid range --from 2024-01-02T09:00:00Z --to 2024-01-02T17:00:00Z)"`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			now := time.Now()
+			var bounds bson.D
+			if len(day) > 0 {
+				if len(from) > 0 || len(to) > 0 {
+					return fmt.Errorf("--day can not be used with --from or --to")
+				}
+				start, err := time.Parse("2006-01-02", day)
+				if err != nil {
+					return fmt.Errorf("--day: %q is not a day, expected 2006-01-02", day)
+				}
+				from, to = start.Format(time.RFC3339), start.AddDate(0, 0, 1).Format(time.RFC3339)
+			}
+			if len(from) == 0 && len(to) == 0 {
+				return fmt.Errorf("one of --from, --to or --day is required")
+			}
+			var start, end time.Time
+			var err error
+			if len(from) > 0 {
+				if start, err = parseOIDTime(from, now); err != nil {
+					return fmt.Errorf("--from: %w", err)
+				}
+				bounds = append(bounds, bson.E{Key: "$gte", Value: minObjectID(start)})
+			}
+			if len(to) > 0 {
+				if end, err = parseOIDTime(to, now); err != nil {
+					return fmt.Errorf("--to: %w", err)
+				}
+				bounds = append(bounds, bson.E{Key: "$lt", Value: minObjectID(end)})
+			}
+			if len(from) > 0 && len(to) > 0 && !end.After(start) {
+				return fmt.Errorf("--to must be after --from")
+			}
+			return writeLine(os.Stdout, bson.D{{Key: field, Value: bounds}})
+		},
+	}
+	flagset := cmd.Flags()
+	flagset.StringVar(&from, "from", from, "Select the ObjectIDs generated at or after this time")
+	flagset.StringVar(&to, "to", to, "Select the ObjectIDs generated before this time")
+	flagset.StringVar(&day, "day", day, "Select the ObjectIDs generated on this day in UTC, written 2006-01-02")
+	flagset.StringVar(&field, "field", "_id", "Field holding the ObjectIDs")
+	return cmd
+}
+
+// minObjectID returns the smallest ObjectID generated in the second of t, which every ObjectID
+// generated at or after that second sorts after.
+func minObjectID(t time.Time) primitive.ObjectID {
+	var id primitive.ObjectID
+	binary.BigEndian.PutUint32(id[0:4], uint32(t.Unix()))
+	return id
+}
+
+// parseOIDTime parses an RFC 3339 time, a day written 2006-01-02 in UTC, seconds since the epoch,
+// or a duration before now such as 36h or 7d.
+func parseOIDTime(value string, now time.Time) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0).UTC(), nil
+	}
+	if strings.HasSuffix(value, "d") {
+		if days, err := strconv.Atoi(strings.TrimSuffix(value, "d")); err == nil && days >= 0 {
+			return now.AddDate(0, 0, -days), nil
+		}
+	}
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("%q is not a time, expected 2006-01-02T15:04:05Z, 2006-01-02, seconds since the epoch or a duration before now such as 36h or 7d", value)
+}
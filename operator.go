@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/kube"
+	"github.com/bradmwilliams/mongodb-client/pkg/operator"
+	"github.com/spf13/cobra"
+	"k8s.io/klog"
+	"sigs.k8s.io/yaml"
+)
+
+type operatorOptions struct {
+	Namespace     string
+	AllNamespaces bool
+	Resync        time.Duration
+	MetricsListen string
+}
+
+func newOperatorCommand(o *options) *cobra.Command {
+	p := &operatorOptions{Resync: 5 * time.Minute}
+	cmd := &cobra.Command{
+		Use:   "operator",
+		Short: "Reconcile the MongoDBDatabase, MongoDBUser and MongoDBIndex resources of a Kubernetes cluster with the database",
+		Long: fmt.Sprintf(`Watch the MongoDBDatabase, MongoDBUser and MongoDBIndex custom resources of the namespace of the
+pod, or of --namespace, and reconcile them with the deployment using the admin client, as the
+provision command does with a manifest. The outcome of the last reconciliation of each resource is
+written to its status as the Ready condition. Every resource is reconciled again every
+--resync-period, which also undoes the changes made to the deployment since.
+
+MongoDBDatabase declares a database with its collections and roles, as the databases of a
+manifest, MongoDBUser a user whose password is read from a Secret of its namespace and set again
+when the Secret changes, and MongoDBIndex an index of a collection. The databases, users and indexes
+of deleted resources are left alone. The definitions of the resources, of the API group %s, are
+printed by the crds subcommand.
+
+The resources of a namespace may only declare the databases and grant the roles listed for it in
+the operator section of the config file, the resources of the namespaces it does not list are
+refused:
+
+  operator:
+    namespaces:
+      shop:
+        databases: [shop, shop_*]
+        roles: [read, readWrite]`, operator.Group),
+		Example: `  mongodb-client operator crds | kubectl apply -f -
+  mongodb-client operator --all-namespaces
+
+  apiVersion: ` + operator.Group + "/" + operator.Version + `
+  kind: MongoDBUser
+  metadata:
+    name: app
+  spec:
+    database: sampledb
+    roles: [{role: readWrite}]
+    passwordSecretRef: {name: app-mongodb, key: password}`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			return p.run(o)
+		},
+	}
+	flagset := cmd.Flags()
+	flagset.StringVar(&p.Namespace, "namespace", p.Namespace, "Namespace of the resources, defaults to the namespace of the pod")
+	flagset.BoolVar(&p.AllNamespaces, "all-namespaces", p.AllNamespaces, "Reconcile the resources of every namespace")
+	flagset.DurationVar(&p.Resync, "resync-period", p.Resync, "Interval after which every resource is reconciled again")
+	flagset.StringVar(&p.MetricsListen, "metrics-listen", p.MetricsListen, "Serve the metrics of the client on this address, such as :8081")
+	cmd.AddCommand(newOperatorCRDsCommand())
+	return cmd
+}
+
+func (p *operatorOptions) run(o *options) error {
+	if p.Resync < time.Second {
+		return fmt.Errorf("--resync-period must be at least 1s")
+	}
+	if p.AllNamespaces && len(p.Namespace) > 0 {
+		return fmt.Errorf("--namespace and --all-namespaces are exclusive")
+	}
+	cfg, err := o.loadConfig()
+	if err != nil {
+		return err
+	}
+	if cfg.Operator == nil || len(cfg.Operator.Namespaces) == 0 {
+		return fmt.Errorf("the operator requires the namespaces of the operator section of the config, with the databases and roles granted to their resources")
+	}
+	kubeClient, err := kube.NewInClusterClient()
+	if err != nil {
+		return err
+	}
+	manager, err := o.connect()
+	if err != nil {
+		return err
+	}
+	defer disconnect(manager)
+	if len(p.MetricsListen) > 0 {
+		serveMetrics(p.MetricsListen)
+	}
+
+	op := operator.New(kubeClient, manager.Admin())
+	op.Resync = p.Resync
+	op.Grants = make(map[string]operator.Grants, len(cfg.Operator.Namespaces))
+	for namespace, grants := range cfg.Operator.Namespaces {
+		op.Grants[namespace] = operator.Grants{Databases: grants.Databases, Roles: grants.Roles}
+	}
+	switch {
+	case p.AllNamespaces:
+	case len(p.Namespace) > 0:
+		op.Namespace = p.Namespace
+	default:
+		op.Namespace = kubeClient.Namespace
+	}
+	if _, ok := op.Grants[op.Namespace]; len(op.Namespace) > 0 && !ok {
+		return fmt.Errorf("namespace %s is not listed in the operator section of the config", op.Namespace)
+	}
+	ctx, cancel := cmdContext()
+	defer cancel()
+	klog.Infof("Reconciling the resources of %s", namespaceDescription(op.Namespace))
+	op.Run(ctx)
+	return nil
+}
+
+func namespaceDescription(namespace string) string {
+	if len(namespace) == 0 {
+		return "every namespace"
+	}
+	return "namespace " + namespace
+}
+
+func newOperatorCRDsCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "crds",
+		Short: "Print the definitions of the custom resources reconciled by the operator",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			for _, definition := range operator.CustomResourceDefinitions() {
+				data, err := yaml.Marshal(definition)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(os.Stdout, "---\n%s", data)
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/objectstore"
+	"github.com/spf13/pflag"
+)
+
+// addStorageFlags adds the flags configuring how objects are written to object stores.
+func addStorageFlags(flagset *pflag.FlagSet, options *objectstore.Options) {
+	flagset.StringVar(&options.ServerSideEncryption, "sse", options.ServerSideEncryption, "S3 server-side encryption of the written object: AES256 or aws:kms")
+	flagset.StringVar(&options.KMSKeyID, "sse-kms-key-id", options.KMSKeyID, "Customer managed key for the written object: the KMS key id for S3, the Cloud KMS key name for GCS or the encryption scope for Azure")
+	flagset.IntVar(&options.PartSize, "part-size", objectstore.DefaultPartSize, "Size in bytes of the parts uploaded at a time to object stores")
+}
+
+// openOutput returns a writer for location, which is standard output when empty or "-", a local
+// path, or an s3://, gs:// or azblob:// URL. The output is only complete once Close succeeds.
+func openOutput(ctx context.Context, location string, options objectstore.Options) (objectstore.Writer, error) {
+	if len(location) == 0 || location == "-" {
+		return stdoutWriter{}, nil
+	}
+	bucket, key, err := objectstore.Open(location, options)
+	if err != nil {
+		return nil, err
+	}
+	return bucket.NewWriter(ctx, key)
+}
+
+type stdoutWriter struct{}
+
+func (stdoutWriter) Write(p []byte) (int, error) {
+	return os.Stdout.Write(p)
+}
+
+func (stdoutWriter) Close() error {
+	return nil
+}
+
+func (stdoutWriter) Abort() error {
+	return nil
+}
@@ -0,0 +1,534 @@
+// Package api serves a REST API for simple data access to the collections of the database.
+//
+// Collections are addressed as /api/v1/{db}/{collection} and single documents as
+// /api/v1/{db}/{collection}/{id}. Request and response bodies are relaxed Extended JSON.
+//
+// Every request runs in a causally consistent session whose token is returned in the
+// X-Causal-Token header. Clients sending it back with their next request read their own writes and
+// never read older data than they did, whichever member serves the request.
+//
+// When the handler records idempotency keys, the write requests carrying an Idempotency-Key header
+// are applied once: the retries of a request get its recorded response.
+//
+// When the handler serves several tenants, the databases and collections of the paths are those of
+// the tenant of the request, mapped to the namespaces of the tenant by its tenancy.
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/client"
+	"github.com/bradmwilliams/mongodb-client/pkg/tenancy"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"k8s.io/klog"
+)
+
+// Prefix is the path under which the API is served.
+const Prefix = "/api/v1/"
+
+// CausalTokenHeader holds the causal token of the session of a request in its response, and the
+// token the session of a request advances to.
+const CausalTokenHeader = "X-Causal-Token"
+
+const (
+	// DefaultLimit is the number of documents returned by a query without limit.
+	DefaultLimit = 100
+	// MaxLimit is the largest accepted limit.
+	MaxLimit = 1000
+	// maxBodyBytes bounds request bodies to the maximum BSON document size.
+	maxBodyBytes = 16 << 20
+)
+
+// Handler serves the REST API.
+type Handler struct {
+	manager *client.ConnectionManager
+
+	// Idempotency records the responses of the write requests with an idempotency key, the
+	// header is ignored when nil.
+	Idempotency *Idempotency
+	// Tenants resolves the tenant of the requests, every namespace is served as is when nil.
+	Tenants *Tenants
+	// CheckEmpty, when it is set, is called before a request with all=true updates or deletes
+	// every document of a collection, the request is forbidden when it returns an error.
+	CheckEmpty func(database, collection string) error
+}
+
+// NewHandler returns a handler serving the API with the connections of manager. It expects to be
+// mounted at Prefix.
+func NewHandler(manager *client.ConnectionManager) *Handler {
+	return &Handler{manager: manager}
+}
+
+// httpError is an error with the status code it is reported with.
+type httpError struct {
+	code int
+	err  error
+}
+
+func (e *httpError) Error() string {
+	return e.err.Error()
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return &httpError{code: http.StatusBadRequest, err: fmt.Errorf(format, args...)}
+}
+
+// Resource is the target of a request.
+type Resource struct {
+	Database   string
+	Collection string
+	// ID is the raw document identifier from the path, empty for collection requests.
+	ID string
+}
+
+// ParseResource parses the path of a request below Prefix.
+func ParseResource(path string) (Resource, error) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(path, Prefix), "/"), "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		return Resource{}, &httpError{code: http.StatusNotFound, err: errors.New("expected /api/v1/{db}/{collection}[/{id}]")}
+	}
+	resource := Resource{Database: parts[0], Collection: parts[1]}
+	if len(parts) == 3 {
+		resource.ID = parts[2]
+	}
+	if err := CheckNamespace(resource.Database, resource.Collection); err != nil {
+		return resource, &httpError{code: http.StatusBadRequest, err: err}
+	}
+	return resource, nil
+}
+
+// CheckNamespace rejects invalid database names and the collections that may not be accessed
+// remotely: system collections and names with a $.
+func CheckNamespace(database, collection string) error {
+	if len(database) == 0 || strings.ContainsAny(database, "$. ") {
+		return fmt.Errorf("invalid database name %q", database)
+	}
+	if len(collection) == 0 || strings.Contains(collection, "$") || strings.HasPrefix(collection, "system.") {
+		return fmt.Errorf("invalid collection name %q", collection)
+	}
+	return nil
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.manager.Connected() {
+		writeError(w, r, &httpError{code: http.StatusServiceUnavailable, err: errors.New("not connected to database")})
+		return
+	}
+	resource, err := ParseResource(r.URL.Path)
+	var tenant, database, name string
+	if err == nil {
+		tenant, err = h.Tenants.tenant(r)
+	}
+	if err == nil {
+		if database, name, err = h.Tenants.tenancy().Namespace(tenant, resource.Database, resource.Collection); err != nil {
+			err = &httpError{code: http.StatusBadRequest, err: err}
+		}
+	}
+	var after *client.CausalToken
+	if value := r.Header.Get(CausalTokenHeader); err == nil && len(value) > 0 {
+		if after, err = client.ParseCausalToken(value); err != nil {
+			err = &httpError{code: http.StatusBadRequest, err: err}
+		}
+	}
+	if err == nil {
+		kind := client.WriteOperation
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			kind = client.ReadOperation
+		}
+		ctx, cancel := context.WithTimeout(tenancy.NewContext(r.Context(), tenant), h.manager.Timeout(kind))
+		defer cancel()
+		collection := h.manager.Primary().Database(database).Collection(name)
+		serve := func(w http.ResponseWriter) error {
+			_, err := h.manager.WithSession(ctx, after, func(sess mongo.SessionContext) error {
+				return h.serve(sess, &tokenWriter{ResponseWriter: w, session: sess}, r, collection, resource)
+			})
+			return err
+		}
+		if key := r.Header.Get(IdempotencyKeyHeader); len(key) > 0 && kind == client.WriteOperation && h.Idempotency != nil {
+			err = h.idempotent(ctx, w, r, key, serve)
+		} else {
+			err = serve(w)
+		}
+	}
+	if err != nil {
+		writeError(w, r, err)
+	}
+}
+
+// tokenWriter sets the causal token of session in the headers of the response when it is written,
+// once the operations of the request are done.
+type tokenWriter struct {
+	http.ResponseWriter
+	session mongo.Session
+}
+
+func (w *tokenWriter) WriteHeader(code int) {
+	if token := client.SessionToken(w.session); token != nil {
+		w.Header().Set(CausalTokenHeader, token.String())
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (h *Handler) serve(ctx context.Context, w http.ResponseWriter, r *http.Request, collection *mongo.Collection, resource Resource) error {
+	var id interface{}
+	if len(resource.ID) > 0 {
+		id = ParseID(resource.ID)
+	}
+	switch {
+	case r.Method == http.MethodGet && id == nil:
+		return h.find(ctx, w, r, collection)
+	case r.Method == http.MethodGet:
+		return h.get(ctx, w, collection, id)
+	case r.Method == http.MethodPost && id == nil:
+		return h.insert(ctx, w, r, collection)
+	case r.Method == http.MethodPut && id != nil:
+		return h.replace(ctx, w, r, collection, id)
+	case r.Method == http.MethodPatch:
+		return h.update(ctx, w, r, collection, id)
+	case r.Method == http.MethodDelete:
+		return h.delete(ctx, w, r, collection, id)
+	}
+	return &httpError{code: http.StatusMethodNotAllowed, err: fmt.Errorf("%s is not supported on %s", r.Method, r.URL.Path)}
+}
+
+// ParseID parses a document identifier from a path: ObjectIds as 24 hex characters, Extended JSON
+// values such as {"$numberInt":"42"}, and strings otherwise.
+func ParseID(value string) interface{} {
+	if oid, err := primitive.ObjectIDFromHex(value); err == nil {
+		return oid
+	}
+	if strings.HasPrefix(value, "{") {
+		var doc bson.D
+		if err := bson.UnmarshalExtJSON([]byte(`{"id":`+value+`}`), false, &doc); err == nil && len(doc) == 1 {
+			return doc[0].Value
+		}
+	}
+	return value
+}
+
+// document parses a query parameter holding an Extended JSON document.
+func document(r *http.Request, name string) (bson.D, error) {
+	value := r.URL.Query().Get(name)
+	doc := bson.D{}
+	if len(value) == 0 {
+		return doc, nil
+	}
+	if err := bson.UnmarshalExtJSON([]byte(value), false, &doc); err != nil {
+		return nil, badRequest("invalid %s: %v", name, err)
+	}
+	return doc, nil
+}
+
+// integer parses a non-negative integer query parameter.
+func integer(r *http.Request, name string, defaultValue int64) (int64, error) {
+	value := r.URL.Query().Get(name)
+	if len(value) == 0 {
+		return defaultValue, nil
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, badRequest("%s must be a non-negative integer", name)
+	}
+	return n, nil
+}
+
+// readBody returns the request body, either a single Extended JSON document or, when array is true,
+// also an array of documents.
+func readBody(r *http.Request, array bool) ([]bson.D, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, maxBodyBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxBodyBytes {
+		return nil, &httpError{code: http.StatusRequestEntityTooLarge, err: errors.New("request body exceeds 16MiB")}
+	}
+	trimmed := strings.TrimSpace(string(data))
+	if array && strings.HasPrefix(trimmed, "[") {
+		var raws []json.RawMessage
+		if err := json.Unmarshal(data, &raws); err != nil {
+			return nil, badRequest("invalid JSON array: %v", err)
+		}
+		docs := make([]bson.D, 0, len(raws))
+		for i, raw := range raws {
+			var doc bson.D
+			if err := bson.UnmarshalExtJSON(raw, false, &doc); err != nil {
+				return nil, badRequest("invalid document %d: %v", i, err)
+			}
+			docs = append(docs, doc)
+		}
+		return docs, nil
+	}
+	var doc bson.D
+	if err := bson.UnmarshalExtJSON(data, false, &doc); err != nil {
+		return nil, badRequest("invalid JSON document: %v", err)
+	}
+	return []bson.D{doc}, nil
+}
+
+func (h *Handler) find(ctx context.Context, w http.ResponseWriter, r *http.Request, collection *mongo.Collection) error {
+	filter, err := document(r, "filter")
+	if err != nil {
+		return err
+	}
+	sort, err := document(r, "sort")
+	if err != nil {
+		return err
+	}
+	projection, err := document(r, "projection")
+	if err != nil {
+		return err
+	}
+	limit, err := integer(r, "limit", DefaultLimit)
+	if err != nil {
+		return err
+	}
+	if limit == 0 || limit > MaxLimit {
+		return badRequest("limit must be between 1 and %d", MaxLimit)
+	}
+	skip, err := integer(r, "skip", 0)
+	if err != nil {
+		return err
+	}
+	findOptions := options.Find().SetLimit(limit).SetSkip(skip)
+	if len(sort) > 0 {
+		findOptions.SetSort(sort)
+	}
+	if len(projection) > 0 {
+		findOptions.SetProjection(projection)
+	}
+
+	findOptions.MaxTime = client.MaxTime(ctx)
+	cursor, err := collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	var docs []bson.Raw
+	for cursor.Next(ctx) {
+		docs = append(docs, append(bson.Raw(nil), cursor.Current...))
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	return writeDocuments(w, http.StatusOK, docs)
+}
+
+func (h *Handler) get(ctx context.Context, w http.ResponseWriter, collection *mongo.Collection, id interface{}) error {
+	doc, err := collection.FindOne(ctx, bson.D{{Key: "_id", Value: id}}, &options.FindOneOptions{MaxTime: client.MaxTime(ctx)}).DecodeBytes()
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, doc)
+}
+
+func (h *Handler) insert(ctx context.Context, w http.ResponseWriter, r *http.Request, collection *mongo.Collection) error {
+	docs, err := readBody(r, true)
+	if err != nil {
+		return err
+	}
+	if len(docs) == 0 {
+		return badRequest("no documents to insert")
+	}
+	models := make([]interface{}, 0, len(docs))
+	for _, doc := range docs {
+		models = append(models, doc)
+	}
+	result, err := collection.InsertMany(ctx, models)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusCreated, bson.D{{Key: "insertedIds", Value: result.InsertedIDs}})
+}
+
+func (h *Handler) replace(ctx context.Context, w http.ResponseWriter, r *http.Request, collection *mongo.Collection, id interface{}) error {
+	docs, err := readBody(r, false)
+	if err != nil {
+		return err
+	}
+	replacement := bson.D{}
+	for _, e := range docs[0] {
+		// The _id of the path wins, the server rejects replacements that change it.
+		if e.Key != "_id" {
+			replacement = append(replacement, e)
+		}
+	}
+	upsert := r.URL.Query().Get("upsert") == "true"
+	result, err := collection.ReplaceOne(ctx, bson.D{{Key: "_id", Value: id}}, replacement, options.Replace().SetUpsert(upsert))
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 && result.UpsertedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return writeUpdateResult(w, result)
+}
+
+func (h *Handler) update(ctx context.Context, w http.ResponseWriter, r *http.Request, collection *mongo.Collection, id interface{}) error {
+	docs, err := readBody(r, false)
+	if err != nil {
+		return err
+	}
+	update := docs[0]
+	if len(update) == 0 {
+		return badRequest("empty update")
+	}
+	// Bodies without update operators set the given fields.
+	if !strings.HasPrefix(update[0].Key, "$") {
+		update = bson.D{{Key: "$set", Value: update}}
+	}
+
+	if id != nil {
+		result, err := collection.UpdateOne(ctx, bson.D{{Key: "_id", Value: id}}, update)
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return mongo.ErrNoDocuments
+		}
+		return writeUpdateResult(w, result)
+	}
+	filter, err := document(r, "filter")
+	if err != nil {
+		return err
+	}
+	if len(filter) == 0 {
+		if r.URL.Query().Get("all") != "true" {
+			return badRequest("updating every document of a collection requires all=true")
+		}
+		if err := h.checkEmpty(collection); err != nil {
+			return err
+		}
+	}
+	result, err := collection.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	return writeUpdateResult(w, result)
+}
+
+func (h *Handler) delete(ctx context.Context, w http.ResponseWriter, r *http.Request, collection *mongo.Collection, id interface{}) error {
+	var result *mongo.DeleteResult
+	var err error
+	if id != nil {
+		if result, err = collection.DeleteOne(ctx, bson.D{{Key: "_id", Value: id}}); err == nil && result.DeletedCount == 0 {
+			return mongo.ErrNoDocuments
+		}
+	} else {
+		filter, filterErr := document(r, "filter")
+		if filterErr != nil {
+			return filterErr
+		}
+		if len(filter) == 0 {
+			if r.URL.Query().Get("all") != "true" {
+				return badRequest("deleting every document of a collection requires all=true")
+			}
+			if err := h.checkEmpty(collection); err != nil {
+				return err
+			}
+		}
+		result, err = collection.DeleteMany(ctx, filter)
+	}
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, bson.D{{Key: "deletedCount", Value: result.DeletedCount}})
+}
+
+// checkEmpty returns why every document of collection may not be updated or deleted, nil when
+// they may.
+func (h *Handler) checkEmpty(collection *mongo.Collection) error {
+	if h.CheckEmpty == nil {
+		return nil
+	}
+	if err := h.CheckEmpty(collection.Database().Name(), collection.Name()); err != nil {
+		return &httpError{code: http.StatusForbidden, err: err}
+	}
+	return nil
+}
+
+func writeUpdateResult(w http.ResponseWriter, result *mongo.UpdateResult) error {
+	doc := bson.D{
+		{Key: "matchedCount", Value: result.MatchedCount},
+		{Key: "modifiedCount", Value: result.ModifiedCount},
+	}
+	if result.UpsertedID != nil {
+		doc = append(doc, bson.E{Key: "upsertedId", Value: result.UpsertedID})
+	}
+	return writeJSON(w, http.StatusOK, doc)
+}
+
+// writeJSON writes doc as relaxed Extended JSON.
+func writeJSON(w http.ResponseWriter, code int, doc interface{}) error {
+	data, err := bson.MarshalExtJSON(doc, false, false)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// writeDocuments writes docs as a JSON array of relaxed Extended JSON documents.
+func writeDocuments(w http.ResponseWriter, code int, docs []bson.Raw) error {
+	body := []byte{'['}
+	for i, doc := range docs {
+		if i > 0 {
+			body = append(body, ',')
+		}
+		data, err := bson.MarshalExtJSON(doc, false, false)
+		if err != nil {
+			return err
+		}
+		body = append(body, data...)
+	}
+	body = append(body, ']', '\n')
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_, err := w.Write(body)
+	return err
+}
+
+// writeError reports err as {"error": "..."} with a status code derived from it.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	code := http.StatusInternalServerError
+	var status *httpError
+	var commandErr mongo.CommandError
+	switch {
+	case errors.As(err, &status):
+		code = status.code
+	case errors.Is(err, mongo.ErrNoDocuments):
+		code, err = http.StatusNotFound, errors.New("document not found")
+	case mongo.IsDuplicateKeyError(err):
+		code = http.StatusConflict
+	case errors.Is(err, context.DeadlineExceeded) || mongo.IsTimeout(err):
+		code = http.StatusGatewayTimeout
+	case errors.As(err, &commandErr) && commandErr.Code == 2: // BadValue
+		code = http.StatusBadRequest
+	case errors.As(err, &commandErr) && commandErr.Code == 13: // Unauthorized, also returned in read-only mode
+		code = http.StatusForbidden
+	case mongo.IsNetworkError(err):
+		code = http.StatusServiceUnavailable
+	}
+	var writeErr mongo.WriteException
+	if errors.As(err, &writeErr) && code == http.StatusInternalServerError {
+		code = http.StatusBadRequest
+	}
+	if code >= 500 {
+		klog.Errorf("%s %s failed: %v", r.Method, r.URL.Path, err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
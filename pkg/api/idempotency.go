@@ -0,0 +1,163 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/client"
+	"github.com/bradmwilliams/mongodb-client/pkg/tenancy"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"k8s.io/klog"
+)
+
+const (
+	// IdempotencyKeyHeader holds a key unique to a write request, which the client sends again
+	// when it retries the request.
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayedHeader is set to true on the responses replayed for a retried request.
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+	// maxIdempotencyKeyLength bounds the keys, which are stored as _id.
+	maxIdempotencyKeyLength = 255
+)
+
+// Idempotency records the responses of the write requests carrying an Idempotency-Key header, so
+// a client retrying a request whose response it did not receive, during a failover for instance,
+// gets the recorded response instead of applying its writes twice.
+type Idempotency struct {
+	// Collection of the database of the manager holding the responses by key.
+	Collection string
+	// TTL is the time a response is kept, a retry after it applies the request again.
+	TTL time.Duration
+}
+
+// idempotencyRecord is the document of a key, whose status is zero while its request is served.
+type idempotencyRecord struct {
+	Key string `bson:"_id"`
+	// Fingerprint is the hash of the request, a key may not be reused for another request.
+	Fingerprint string    `bson:"fingerprint"`
+	Status      int       `bson:"status,omitempty"`
+	ContentType string    `bson:"contentType,omitempty"`
+	Body        []byte    `bson:"body,omitempty"`
+	CreatedAt   time.Time `bson:"createdAt"`
+	ExpiresAt   time.Time `bson:"expiresAt"`
+}
+
+func (h *Handler) idempotencyCollection() *mongo.Collection {
+	return h.manager.Primary().Database(h.manager.Database()).Collection(h.Idempotency.Collection)
+}
+
+// EnsureIndexes creates the TTL index removing the expired idempotency keys, when they are enabled.
+func (h *Handler) EnsureIndexes(ctx context.Context) error {
+	if h.Idempotency == nil {
+		return nil
+	}
+	_, err := h.idempotencyCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0).SetName("expiresAt_ttl"),
+	})
+	return err
+}
+
+// responseRecorder keeps a copy of the response it writes.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *responseRecorder) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseRecorder) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+// idempotent serves r with serve the first time key is received, and replays the recorded response
+// for the retries of the request. The key is claimed while the request is served, the concurrent
+// retries are rejected with a conflict, and released when serving fails so the request can be
+// retried. The keys of the tenants are recorded apart, so a tenant never gets the response of
+// another.
+func (h *Handler) idempotent(ctx context.Context, w http.ResponseWriter, r *http.Request, key string, serve func(w http.ResponseWriter) error) error {
+	if len(key) > maxIdempotencyKeyLength {
+		return badRequest("%s must not exceed %d characters", IdempotencyKeyHeader, maxIdempotencyKeyLength)
+	}
+	id := key
+	if tenant := tenancy.FromContext(ctx); len(tenant) > 0 {
+		id = tenant + "/" + key
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, maxBodyBytes+1))
+	if err != nil {
+		return err
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(data))
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s %s\n", r.Method, r.URL.RequestURI())
+	hash.Write(data)
+	fingerprint := hex.EncodeToString(hash.Sum(nil))
+
+	collection := h.idempotencyCollection()
+	now := time.Now().UTC()
+	// A claim left by a process that stopped while serving the request expires with the timeout of
+	// the request.
+	claim := idempotencyRecord{Key: id, Fingerprint: fingerprint, CreatedAt: now, ExpiresAt: now.Add(h.manager.Timeout(client.WriteOperation) + time.Minute)}
+	if _, err := collection.InsertOne(ctx, claim); mongo.IsDuplicateKeyError(err) {
+		var record idempotencyRecord
+		if err := collection.FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&record); err != nil {
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				err = &httpError{code: http.StatusConflict, err: fmt.Errorf("the request with %s %q was released, retry it", IdempotencyKeyHeader, key)}
+			}
+			return err
+		}
+		switch {
+		case record.Fingerprint != fingerprint:
+			return &httpError{code: http.StatusUnprocessableEntity, err: fmt.Errorf("%s %q was used by another request", IdempotencyKeyHeader, key)}
+		case record.Status == 0:
+			return &httpError{code: http.StatusConflict, err: fmt.Errorf("the request with %s %q is in progress", IdempotencyKeyHeader, key)}
+		}
+		if len(record.ContentType) > 0 {
+			w.Header().Set("Content-Type", record.ContentType)
+		}
+		w.Header().Set(IdempotentReplayedHeader, "true")
+		w.WriteHeader(record.Status)
+		_, err := w.Write(record.Body)
+		return err
+	} else if err != nil {
+		return fmt.Errorf("unable to claim %s %q: %w", IdempotencyKeyHeader, key, err)
+	}
+
+	recorder := &responseRecorder{ResponseWriter: w}
+	err = serve(recorder)
+	if err != nil || recorder.status == 0 || recorder.status >= http.StatusInternalServerError {
+		if _, releaseErr := collection.DeleteOne(context.Background(), bson.D{{Key: "_id", Value: id}}); releaseErr != nil {
+			klog.Warningf("Unable to release %s %q: %v", IdempotencyKeyHeader, key, releaseErr)
+		}
+		return err
+	}
+	_, err = collection.UpdateOne(context.Background(), bson.D{{Key: "_id", Value: id}}, bson.D{{Key: "$set", Value: bson.D{
+		{Key: "status", Value: recorder.status},
+		{Key: "contentType", Value: recorder.Header().Get("Content-Type")},
+		{Key: "body", Value: recorder.body.Bytes()},
+		{Key: "expiresAt", Value: time.Now().UTC().Add(h.Idempotency.TTL)},
+	}}})
+	if err != nil {
+		// The response was sent, the retries are rejected until the claim expires.
+		klog.Warningf("Unable to record the response of %s %q: %v", IdempotencyKeyHeader, key, err)
+	}
+	return nil
+}
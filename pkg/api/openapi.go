@@ -0,0 +1,319 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/client"
+	"k8s.io/klog"
+)
+
+// OpenAPIPath is where the OpenAPI document of the REST API is served.
+const OpenAPIPath = "/api/openapi.json"
+
+// SecurityScheme is an OpenAPI security scheme accepted by the API.
+type SecurityScheme struct {
+	Type             string `json:"type"`
+	Scheme           string `json:"scheme,omitempty"`
+	BearerFormat     string `json:"bearerFormat,omitempty"`
+	OpenIDConnectURL string `json:"openIdConnectUrl,omitempty"`
+	Description      string `json:"description,omitempty"`
+}
+
+// OpenAPIHandler serves an OpenAPI 3 document describing the REST API with a path per collection
+// of the deployment, so that typed clients can be generated from it. Collections are listed when
+// the document is requested.
+type OpenAPIHandler struct {
+	manager *client.ConnectionManager
+	schemes map[string]SecurityScheme
+	// Readable reports whether the caller of ctx may read database, the other databases are left
+	// out. It is nil when every database is described.
+	Readable func(ctx context.Context, database string) bool
+	// Tenants resolves the tenant of the requests, whose own collections are described. Every
+	// collection is described when nil.
+	Tenants *Tenants
+}
+
+// NewOpenAPIHandler returns a handler describing the databases of manager, with the security
+// schemes required by the API.
+func NewOpenAPIHandler(manager *client.ConnectionManager, schemes map[string]SecurityScheme) *OpenAPIHandler {
+	return &OpenAPIHandler{manager: manager, schemes: schemes}
+}
+
+// internalDatabases are never described.
+var internalDatabases = map[string]bool{"admin": true, "config": true, "local": true}
+
+// namespaces returns the collections of the databases the caller may read, by database, as named
+// by tenant.
+func (h *OpenAPIHandler) namespaces(ctx context.Context, tenant string) (map[string][]string, error) {
+	c, err := h.Tenants.tenancy().Client(h.manager.Primary(), tenant)
+	if err != nil {
+		return nil, err
+	}
+	databases, err := c.ListDatabaseNames(ctx)
+	if err != nil {
+		return nil, err
+	}
+	namespaces := map[string][]string{}
+	for _, database := range databases {
+		if internalDatabases[database] || (h.Readable != nil && !h.Readable(ctx, database)) {
+			continue
+		}
+		collections, err := c.ListCollectionNames(ctx, database)
+		if err != nil {
+			return nil, err
+		}
+		for _, collection := range collections {
+			if CheckNamespace(database, collection) == nil {
+				namespaces[database] = append(namespaces[database], collection)
+			}
+		}
+		sort.Strings(namespaces[database])
+	}
+	return namespaces, nil
+}
+
+func (h *OpenAPIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.manager.Connected() {
+		http.Error(w, "not connected to database", http.StatusServiceUnavailable)
+		return
+	}
+	tenant, err := h.Tenants.tenant(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), h.manager.Timeout(client.ReadOperation))
+	defer cancel()
+	namespaces, err := h.namespaces(ctx, tenant)
+	if err != nil {
+		klog.Errorf("Unable to describe the API: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(OpenAPI(namespaces, h.schemes))
+}
+
+func ref(name string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+func jsonContent(schema interface{}) map[string]interface{} {
+	return map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}}
+}
+
+func response(description string, schema interface{}) map[string]interface{} {
+	return map[string]interface{}{"description": description, "content": jsonContent(schema)}
+}
+
+func queryParameter(name, description string, schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"name": name, "in": "query", "description": description, "schema": schema}
+}
+
+func documentParameter(name, description string) map[string]interface{} {
+	return queryParameter(name, description+", as Extended JSON", map[string]interface{}{"type": "string"})
+}
+
+var causalTokenParameter = map[string]interface{}{
+	"name":        CausalTokenHeader,
+	"in":          "header",
+	"description": "Causal token of a previous response, the request then reads the writes of the previous requests and no older data",
+	"schema":      map[string]interface{}{"type": "string"},
+}
+
+var idempotencyKeyParameter = map[string]interface{}{
+	"name":        IdempotencyKeyHeader,
+	"in":          "header",
+	"description": "Key unique to the request, sent again when retrying it: the retries get the response of the first request instead of applying its writes again, when the server records idempotency keys",
+	"schema":      map[string]interface{}{"type": "string", "maxLength": maxIdempotencyKeyLength},
+}
+
+var allParameter = queryParameter("all", "Required to apply the operation to every document when the filter is empty, forbidden on the protected namespaces", map[string]interface{}{"type": "boolean"})
+
+// operationID returns a valid identifier for the operation of a collection, such as
+// findPodcastsEpisodes for find on podcasts.episodes.
+func operationID(operation, database, collection string) string {
+	var id strings.Builder
+	id.WriteString(operation)
+	for _, part := range strings.FieldsFunc(database+"."+collection, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9')
+	}) {
+		id.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return id.String()
+}
+
+// withErrors adds the error responses shared by every operation.
+func withErrors(responses map[string]interface{}) map[string]interface{} {
+	for _, code := range []string{"400", "404", "503"} {
+		responses[code] = map[string]interface{}{"$ref": "#/components/responses/Error" + code}
+	}
+	return responses
+}
+
+// collectionPaths returns the operations of a collection and of its documents.
+func collectionPaths(database, collection string) (map[string]interface{}, map[string]interface{}) {
+	tags := []string{database + "." + collection}
+	id := func(operation string) string { return operationID(operation, database, collection) }
+	documents := map[string]interface{}{
+		"parameters": []interface{}{causalTokenParameter},
+		"get": map[string]interface{}{
+			"operationId": id("find"),
+			"summary":     "Find documents",
+			"tags":        tags,
+			"parameters": []interface{}{
+				documentParameter("filter", "Query document"),
+				documentParameter("sort", "Sort document"),
+				documentParameter("projection", "Projection document"),
+				queryParameter("limit", "Maximum number of documents", map[string]interface{}{"type": "integer", "minimum": 1, "maximum": MaxLimit, "default": DefaultLimit}),
+				queryParameter("skip", "Number of documents to skip", map[string]interface{}{"type": "integer", "minimum": 0, "default": 0}),
+			},
+			"responses": withErrors(map[string]interface{}{"200": response("Matching documents", map[string]interface{}{"type": "array", "items": ref("Document")})}),
+		},
+		"post": map[string]interface{}{
+			"operationId": id("insert"),
+			"summary":     "Insert a document or an array of documents",
+			"tags":        tags,
+			"parameters":  []interface{}{idempotencyKeyParameter},
+			"requestBody": map[string]interface{}{"required": true, "content": jsonContent(map[string]interface{}{
+				"oneOf": []interface{}{ref("Document"), map[string]interface{}{"type": "array", "items": ref("Document")}},
+			})},
+			"responses": withErrors(map[string]interface{}{
+				"201": response("Inserted", ref("InsertResult")),
+				"409": map[string]interface{}{"$ref": "#/components/responses/Error409"},
+			}),
+		},
+		"patch": map[string]interface{}{
+			"operationId": id("updateMany"),
+			"summary":     "Update the documents matching a filter, fields are set unless the body holds update operators",
+			"tags":        tags,
+			"parameters":  []interface{}{documentParameter("filter", "Query document"), allParameter, idempotencyKeyParameter},
+			"requestBody": map[string]interface{}{"required": true, "content": jsonContent(ref("Document"))},
+			"responses":   withErrors(map[string]interface{}{"200": response("Updated", ref("UpdateResult"))}),
+		},
+		"delete": map[string]interface{}{
+			"operationId": id("deleteMany"),
+			"summary":     "Delete the documents matching a filter",
+			"tags":        tags,
+			"parameters":  []interface{}{documentParameter("filter", "Query document"), allParameter, idempotencyKeyParameter},
+			"responses":   withErrors(map[string]interface{}{"200": response("Deleted", ref("DeleteResult"))}),
+		},
+	}
+	document := map[string]interface{}{
+		"parameters": []interface{}{map[string]interface{}{
+			"name":        "id",
+			"in":          "path",
+			"required":    true,
+			"description": "Document _id: an ObjectId in hex, an Extended JSON value or a string",
+			"schema":      map[string]interface{}{"type": "string"},
+		}, causalTokenParameter},
+		"get": map[string]interface{}{
+			"operationId": id("get"),
+			"summary":     "Get a document",
+			"tags":        tags,
+			"responses":   withErrors(map[string]interface{}{"200": response("The document", ref("Document"))}),
+		},
+		"put": map[string]interface{}{
+			"operationId": id("replace"),
+			"summary":     "Replace a document",
+			"tags":        tags,
+			"parameters":  []interface{}{queryParameter("upsert", "Insert the document when it does not exist", map[string]interface{}{"type": "boolean"}), idempotencyKeyParameter},
+			"requestBody": map[string]interface{}{"required": true, "content": jsonContent(ref("Document"))},
+			"responses":   withErrors(map[string]interface{}{"200": response("Replaced", ref("UpdateResult"))}),
+		},
+		"patch": map[string]interface{}{
+			"operationId": id("update"),
+			"summary":     "Update a document, fields are set unless the body holds update operators",
+			"tags":        tags,
+			"parameters":  []interface{}{idempotencyKeyParameter},
+			"requestBody": map[string]interface{}{"required": true, "content": jsonContent(ref("Document"))},
+			"responses":   withErrors(map[string]interface{}{"200": response("Updated", ref("UpdateResult"))}),
+		},
+		"delete": map[string]interface{}{
+			"operationId": id("delete"),
+			"summary":     "Delete a document",
+			"tags":        tags,
+			"parameters":  []interface{}{idempotencyKeyParameter},
+			"responses":   withErrors(map[string]interface{}{"200": response("Deleted", ref("DeleteResult"))}),
+		},
+	}
+	return documents, document
+}
+
+// OpenAPI returns the OpenAPI 3 document of the API serving the collections of namespaces, keyed
+// by database, with the security schemes it requires.
+func OpenAPI(namespaces map[string][]string, schemes map[string]SecurityScheme) map[string]interface{} {
+	paths := map[string]interface{}{}
+	for database, collections := range namespaces {
+		for _, collection := range collections {
+			base := Prefix + database + "/" + collection
+			paths[base], paths[base+"/{id}"] = collectionPaths(database, collection)
+		}
+	}
+
+	errorResponses := map[string]interface{}{}
+	for code, description := range map[string]string{
+		"400": "Invalid request",
+		"404": "Document or path not found",
+		"409": "Duplicate key",
+		"503": "Not connected to the database",
+	} {
+		errorResponses["Error"+code] = response(description, ref("ErrorResponse"))
+	}
+	doc := map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "mongodb-client REST API",
+			"version":     "v1",
+			"description": "CRUD operations on the collections of the deployment. Documents, filters, sorts and projections are relaxed Extended JSON.",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": map[string]interface{}{
+				"Document": map[string]interface{}{"type": "object", "additionalProperties": true},
+				"InsertResult": map[string]interface{}{
+					"type":       "object",
+					"properties": map[string]interface{}{"insertedIds": map[string]interface{}{"type": "array", "items": map[string]interface{}{}}},
+				},
+				"UpdateResult": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"matchedCount":  map[string]interface{}{"type": "integer"},
+						"modifiedCount": map[string]interface{}{"type": "integer"},
+						"upsertedId":    map[string]interface{}{},
+					},
+				},
+				"DeleteResult": map[string]interface{}{
+					"type":       "object",
+					"properties": map[string]interface{}{"deletedCount": map[string]interface{}{"type": "integer"}},
+				},
+				"ErrorResponse": map[string]interface{}{
+					"type":       "object",
+					"properties": map[string]interface{}{"error": map[string]interface{}{"type": "string"}},
+				},
+			},
+			"responses": errorResponses,
+		},
+	}
+	if len(schemes) > 0 {
+		components := doc["components"].(map[string]interface{})
+		components["securitySchemes"] = schemes
+		var security []interface{}
+		names := make([]string, 0, len(schemes))
+		for name := range schemes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			security = append(security, map[string]interface{}{name: []string{}})
+		}
+		doc["security"] = security
+	}
+	return doc
+}
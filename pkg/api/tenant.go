@@ -0,0 +1,48 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/tenancy"
+)
+
+// TenantHeader names the tenant of a request, for the callers that are not bound to one.
+const TenantHeader = "X-Tenant-ID"
+
+// Tenants resolves the tenant of the requests to an API serving several tenants, whose databases
+// and collections are mapped to those of the tenant.
+type Tenants struct {
+	Tenancy *tenancy.Tenancy
+	// Resolve returns the tenant of a request, an error when the caller may not act for the tenant
+	// it asks for. The tenant is read from TenantHeader when nil.
+	Resolve func(r *http.Request) (string, error)
+}
+
+// tenant returns the tenant of r, empty when t is nil.
+func (t *Tenants) tenant(r *http.Request) (string, error) {
+	if t == nil {
+		return "", nil
+	}
+	tenant := r.Header.Get(TenantHeader)
+	if t.Resolve != nil {
+		var err error
+		if tenant, err = t.Resolve(r); err != nil {
+			return "", &httpError{code: http.StatusForbidden, err: err}
+		}
+	}
+	if len(tenant) == 0 {
+		return "", badRequest("the %s header is required", TenantHeader)
+	}
+	if err := tenancy.CheckTenant(tenant); err != nil {
+		return "", &httpError{code: http.StatusBadRequest, err: err}
+	}
+	return tenant, nil
+}
+
+// tenancy returns the tenancy of t, nil when t is nil.
+func (t *Tenants) tenancy() *tenancy.Tenancy {
+	if t == nil {
+		return nil
+	}
+	return t.Tenancy
+}
@@ -0,0 +1,465 @@
+// Package archival moves the old documents of collections to cold storage, an archive collection or
+// gzip NDJSON objects of a directory or object store, and records every move in a manifest from which
+// the documents can be restored.
+package archival
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"time"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/bulk"
+	"github.com/bradmwilliams/mongodb-client/pkg/client"
+	"github.com/bradmwilliams/mongodb-client/pkg/objectstore"
+	"github.com/bradmwilliams/mongodb-client/pkg/retention"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"k8s.io/klog"
+)
+
+var (
+	archivalArchivedDocuments = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mongodb_client_archival_archived_documents_total",
+		Help: "Number of documents moved to cold storage by each archive.",
+	}, []string{"archive"})
+	archivalLastSuccessTimestamp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "mongodb_client_archival_last_success_timestamp_seconds",
+		Help: "Unix time of the last complete run of each archive.",
+	}, []string{"archive"})
+)
+
+func init() {
+	prometheus.MustRegister(archivalArchivedDocuments, archivalLastSuccessTimestamp)
+}
+
+const (
+	// ManifestCollection holds the manifest entries of the archive collections, in the database of
+	// the archive collection.
+	ManifestCollection = "archive_manifests"
+	// DefaultChunkSize is the number of documents moved at a time.
+	DefaultChunkSize = 10000
+)
+
+// timestampFormat is part of every object name, it sorts in chronological order.
+const timestampFormat = "20060102T150405Z"
+
+// Policy selects the documents of a collection to archive, as for a retention policy, and where they
+// are moved to: an archive collection, or gzip NDJSON objects of a bucket.
+type Policy struct {
+	// Name identifies the archive, it is part of the keys of its objects.
+	Name       string
+	Database   string
+	Collection string
+	// Field, MaxAge and Filter select the documents, see retention.Expired.
+	Field  string
+	MaxAge time.Duration
+	Filter bson.D
+	// ToCollection is the archive collection of ToDatabase, Database when it is empty.
+	ToDatabase   string
+	ToCollection string
+	// Bucket holds the objects below Prefix instead, one per chunk, and the manifest.
+	Bucket objectstore.Bucket
+	Prefix string
+	// ChunkSize is the number of documents read, written and deleted at a time, which are held in
+	// memory, DefaultChunkSize when it is not set.
+	ChunkSize int
+}
+
+// Entry records a chunk of documents moved by an archive.
+type Entry struct {
+	Archive string `json:"archive" bson:"archive"`
+	// Location is the key of the object of the documents, or the <database>.<collection> of the
+	// archive collection.
+	Location string `json:"location" bson:"location"`
+	// Database and Collection are where the documents were archived from.
+	Database   string `json:"database" bson:"database"`
+	Collection string `json:"collection" bson:"collection"`
+	Documents  int64  `json:"documents" bson:"documents"`
+	// FirstID and LastID are the lowest and highest _id of the documents, in Extended JSON.
+	FirstID string    `json:"firstID" bson:"firstID"`
+	LastID  string    `json:"lastID" bson:"lastID"`
+	Created time.Time `json:"created" bson:"created"`
+}
+
+// Manifest is the object listing the entries of an archive to a bucket.
+type Manifest struct {
+	Archive string  `json:"archive"`
+	Entries []Entry `json:"entries"`
+}
+
+// Validate reports whether the policy is complete and consistent.
+func (p *Policy) Validate() error {
+	if len(p.Collection) == 0 {
+		return fmt.Errorf("a collection is required")
+	}
+	if (len(p.Field) == 0) != (p.MaxAge == 0) {
+		return fmt.Errorf("field and maxAge must be set together")
+	}
+	if p.MaxAge < 0 {
+		return fmt.Errorf("maxAge must not be negative")
+	}
+	if len(p.Field) == 0 && len(p.Filter) == 0 {
+		return fmt.Errorf("field and maxAge, or a filter, are required")
+	}
+	if (len(p.ToCollection) == 0) == (p.Bucket == nil) {
+		return fmt.Errorf("exactly one of an archive collection or a destination is required")
+	}
+	if len(p.ToCollection) > 0 && p.toDatabase() == p.Database && p.ToCollection == p.Collection {
+		return fmt.Errorf("the archive collection must not be the collection itself")
+	}
+	if p.ChunkSize < 0 {
+		return fmt.Errorf("chunkSize must not be negative")
+	}
+	return nil
+}
+
+func (p *Policy) toDatabase() string {
+	if len(p.ToDatabase) > 0 {
+		return p.ToDatabase
+	}
+	return p.Database
+}
+
+func (p *Policy) chunkSize() int {
+	if p.ChunkSize < 1 {
+		return DefaultChunkSize
+	}
+	return p.ChunkSize
+}
+
+// key returns the key of the object of the archive with the given base name.
+func (p *Policy) key(name string) string {
+	return path.Join(p.Prefix, p.Name, name)
+}
+
+// Run moves the documents of the policy that are old enough at the time it starts, chunk after
+// chunk: a chunk is written to the archive, recorded in the manifest and only then deleted, so that
+// a failure leaves documents in both places rather than in neither. It has the signature of a job
+// handler.
+func (p *Policy) Run(ctx context.Context, c *mongo.Client) error {
+	now := time.Now()
+	source := c.Database(p.Database).Collection(p.Collection)
+	filter := retention.Expired(p.Field, p.MaxAge, p.Filter, now)
+	findOptions := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(int64(p.chunkSize()))
+	var archived int64
+	for chunk := 1; ; chunk++ {
+		cursor, err := source.Find(ctx, filter, findOptions)
+		if err != nil {
+			return fmt.Errorf("unable to find the documents of %s to archive: %w", p.Collection, err)
+		}
+		var docs []bson.Raw
+		if err := cursor.All(ctx, &docs); err != nil {
+			return fmt.Errorf("unable to read the documents of %s to archive: %w", p.Collection, err)
+		}
+		if len(docs) == 0 {
+			break
+		}
+
+		ids := make(bson.A, 0, len(docs))
+		for _, doc := range docs {
+			ids = append(ids, doc.Lookup("_id"))
+		}
+		entry := Entry{
+			Archive:    p.Name,
+			Database:   p.Database,
+			Collection: p.Collection,
+			Documents:  int64(len(docs)),
+			FirstID:    extJSON(docs[0].Lookup("_id")),
+			LastID:     extJSON(docs[len(docs)-1].Lookup("_id")),
+			Created:    now.UTC(),
+		}
+		if p.Bucket != nil {
+			entry.Location = p.key(fmt.Sprintf("%s.%s-%s-%04d.ndjson.gz", p.Database, p.Collection, now.UTC().Format(timestampFormat), chunk))
+			err = writeObject(ctx, p.Bucket, entry.Location, docs)
+		} else {
+			entry.Location = p.toDatabase() + "." + p.ToCollection
+			err = copyDocuments(ctx, c.Database(p.toDatabase()).Collection(p.ToCollection), docs)
+		}
+		if err != nil {
+			return fmt.Errorf("unable to archive the documents of %s to %s: %w", p.Collection, entry.Location, err)
+		}
+		if err := p.addEntry(ctx, c, entry); err != nil {
+			return err
+		}
+
+		result, err := source.DeleteMany(ctx, bson.D{
+			{Key: "$and", Value: bson.A{filter, bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: ids}}}}}},
+		})
+		if err != nil {
+			return fmt.Errorf("unable to delete the archived documents of %s: %w", p.Collection, err)
+		}
+		archived += result.DeletedCount
+		archivalArchivedDocuments.WithLabelValues(p.Name).Add(float64(result.DeletedCount))
+		if len(docs) < p.chunkSize() {
+			break
+		}
+	}
+	if archived > 0 {
+		klog.Infof("Archive %s moved %d documents of %s.%s", p.Name, archived, p.Database, p.Collection)
+	}
+	archivalLastSuccessTimestamp.WithLabelValues(p.Name).SetToCurrentTime()
+	return nil
+}
+
+// writeObject writes docs as gzip canonical Extended JSON lines to the object key, which only
+// becomes visible once complete.
+func writeObject(ctx context.Context, bucket objectstore.Bucket, key string, docs []bson.Raw) error {
+	out, err := bucket.NewWriter(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer out.Abort()
+	buffered := bufio.NewWriter(out)
+	compressed := gzip.NewWriter(buffered)
+	for _, doc := range docs {
+		line, err := bson.MarshalExtJSON(doc, true, false)
+		if err != nil {
+			return err
+		}
+		if _, err := compressed.Write(append(line, '\n')); err != nil {
+			return err
+		}
+	}
+	if err := compressed.Close(); err != nil {
+		return err
+	}
+	if err := buffered.Flush(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// copyDocuments replaces docs in the archive collection, so that the documents of a run that failed
+// before deleting them are archived again.
+func copyDocuments(ctx context.Context, archive *mongo.Collection, docs []bson.Raw) error {
+	models := make([]mongo.WriteModel, 0, len(docs))
+	for _, doc := range docs {
+		models = append(models, replace(doc))
+	}
+	_, err := bulk.Write(ctx, archive, models, bulk.Options{Ordered: true})
+	return err
+}
+
+func replace(doc bson.Raw) mongo.WriteModel {
+	return mongo.NewReplaceOneModel().SetFilter(bson.D{{Key: "_id", Value: doc.Lookup("_id")}}).SetReplacement(doc).SetUpsert(true)
+}
+
+// manifestKey is the key of the manifest of an archive to a bucket.
+func (p *Policy) manifestKey() string {
+	return p.key("manifest.json")
+}
+
+// Entries returns the entries of the manifest of the archive, oldest first.
+func (p *Policy) Entries(ctx context.Context, c *mongo.Client) ([]Entry, error) {
+	if p.Bucket == nil {
+		cursor, err := c.Database(p.toDatabase()).Collection(ManifestCollection).Find(ctx, bson.D{{Key: "archive", Value: p.Name}},
+			options.Find().SetSort(bson.D{{Key: "created", Value: 1}, {Key: "_id", Value: 1}}))
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the manifest of %s: %w", p.Name, err)
+		}
+		var entries []Entry
+		if err := cursor.All(ctx, &entries); err != nil {
+			return nil, fmt.Errorf("unable to read the manifest of %s: %w", p.Name, err)
+		}
+		return entries, nil
+	}
+
+	key := p.manifestKey()
+	objects, err := p.Bucket.List(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find the manifest of %s: %w", p.Name, err)
+	}
+	exists := false
+	for _, object := range objects {
+		exists = exists || object.Key == key
+	}
+	if !exists {
+		return nil, nil
+	}
+	in, err := p.Bucket.NewReader(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the manifest of %s: %w", p.Name, err)
+	}
+	defer in.Close()
+	manifest := Manifest{}
+	if err := json.NewDecoder(in).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("unable to parse the manifest %s: %w", key, err)
+	}
+	return manifest.Entries, nil
+}
+
+// addEntry records entry in the manifest. The manifest of a bucket is rewritten, runs of the same
+// archive must not overlap.
+func (p *Policy) addEntry(ctx context.Context, c *mongo.Client, entry Entry) error {
+	if p.Bucket == nil {
+		if _, err := c.Database(p.toDatabase()).Collection(ManifestCollection).InsertOne(ctx, entry); err != nil {
+			return fmt.Errorf("unable to record %s in the manifest of %s: %w", entry.Location, p.Name, err)
+		}
+		return nil
+	}
+	entries, err := p.Entries(ctx, c)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(Manifest{Archive: p.Name, Entries: append(entries, entry)}, "", "  ")
+	if err != nil {
+		return err
+	}
+	out, err := p.Bucket.NewWriter(ctx, p.manifestKey())
+	if err != nil {
+		return fmt.Errorf("unable to write the manifest of %s: %w", p.Name, err)
+	}
+	defer out.Abort()
+	if _, err := out.Write(data); err != nil {
+		return fmt.Errorf("unable to write the manifest of %s: %w", p.Name, err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("unable to write the manifest of %s: %w", p.Name, err)
+	}
+	return nil
+}
+
+// RestoreOptions select the archived documents that are restored and where to.
+type RestoreOptions struct {
+	// Locations are the objects of the entries restored, all of them when empty. An archive
+	// collection is restored as a whole.
+	Locations []string
+	// Filter restricts the documents restored from an archive collection.
+	Filter bson.D
+	// Database and Collection are where the documents are restored to, where they were archived
+	// from by default.
+	Database   string
+	Collection string
+	// BatchSize is the number of documents written per request, 1000 when it is not set.
+	BatchSize int
+}
+
+// Restore writes the archived documents back, replacing the documents with the same _id, so that a
+// restore can be run again. The objects are restored oldest first, their documents leave the
+// archive as it is.
+func (p *Policy) Restore(ctx context.Context, c *mongo.Client, opts RestoreOptions) (bulk.Result, error) {
+	database, collection := opts.Database, opts.Collection
+	if len(database) == 0 {
+		database = p.Database
+	}
+	if len(collection) == 0 {
+		collection = p.Collection
+	}
+	writer := bulk.NewWriter(c.Database(database).Collection(collection), bulk.Options{BatchSize: opts.BatchSize})
+	var err error
+	if p.Bucket == nil {
+		if len(opts.Locations) > 0 {
+			return bulk.Result{}, fmt.Errorf("the documents of the archive collection of %s cannot be restored by location, use a filter", p.Name)
+		}
+		err = p.restoreCollection(ctx, c, opts.Filter, writer)
+	} else {
+		if len(opts.Filter) > 0 {
+			return bulk.Result{}, fmt.Errorf("the objects of %s cannot be filtered, select them by location", p.Name)
+		}
+		err = p.restoreObjects(ctx, c, opts.Locations, writer)
+	}
+	if err == nil {
+		err = add(writer.Flush(ctx))
+	}
+	result := writer.Result()
+	if err == nil && len(result.Failures) > 0 {
+		err = &bulk.Error{Failures: result.Failures}
+	}
+	return result, err
+}
+
+func (p *Policy) restoreCollection(ctx context.Context, c *mongo.Client, filter bson.D, writer *bulk.Writer) error {
+	if filter == nil {
+		filter = bson.D{}
+	}
+	cursor, err := c.Database(p.toDatabase()).Collection(p.ToCollection).Find(ctx, filter)
+	if err != nil {
+		return fmt.Errorf("unable to read the archive collection %s: %w", p.ToCollection, err)
+	}
+	return client.Each(ctx, cursor, func(doc bson.Raw) error {
+		return add(writer.Add(ctx, replace(append(bson.Raw(nil), doc...))))
+	})
+}
+
+func (p *Policy) restoreObjects(ctx context.Context, c *mongo.Client, locations []string, writer *bulk.Writer) error {
+	entries, err := p.Entries(ctx, c)
+	if err != nil {
+		return err
+	}
+	selected := map[string]bool{}
+	for _, location := range locations {
+		selected[location] = false
+	}
+	for _, entry := range entries {
+		if restored, ok := selected[entry.Location]; len(locations) > 0 && (!ok || restored) {
+			continue
+		}
+		selected[entry.Location] = true
+		if err := restoreObject(ctx, p.Bucket, entry.Location, writer); err != nil {
+			return fmt.Errorf("unable to restore %s: %w", entry.Location, err)
+		}
+	}
+	for _, location := range locations {
+		if !selected[location] {
+			return fmt.Errorf("%s is not an entry of the manifest of %s", location, p.Name)
+		}
+	}
+	return nil
+}
+
+func restoreObject(ctx context.Context, bucket objectstore.Bucket, key string, writer *bulk.Writer) error {
+	in, err := bucket.NewReader(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	decompressed, err := gzip.NewReader(bufio.NewReader(in))
+	if err != nil {
+		return err
+	}
+	reader := bufio.NewReader(decompressed)
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if len(data) > 0 {
+			var doc bson.Raw
+			if err := bson.UnmarshalExtJSON(data, true, &doc); err != nil {
+				return fmt.Errorf("line %d: %w", line, err)
+			}
+			if err := add(writer.Add(ctx, replace(doc))); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// add ignores the failures of some writes of a batch, which the result of the writer collects.
+func add(err error) error {
+	if errors.As(err, new(*bulk.Error)) {
+		return nil
+	}
+	return err
+}
+
+// extJSON returns value as relaxed Extended JSON.
+func extJSON(value bson.RawValue) string {
+	data, err := bson.MarshalExtJSON(bson.D{{Key: "v", Value: value}}, false, false)
+	if err != nil {
+		return value.String()
+	}
+	// Only the value of the wrapping document is kept: {"v":<value>}.
+	return string(data[5 : len(data)-1])
+}
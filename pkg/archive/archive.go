@@ -0,0 +1,285 @@
+// Package archive reads and writes the archive format of mongodump --archive, so that dumps can be
+// restored with mongorestore --archive and vice versa.
+//
+// An archive starts with a magic number, a header document and one metadata document per
+// collection followed by a terminator. The documents of each collection follow in blocks that are
+// introduced by a namespace header and closed by a terminator. The last block of a collection is
+// an EOF namespace header carrying the CRC-64 of all of its documents.
+package archive
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc64"
+	"io"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// MagicNumber identifies the start of an archive.
+const MagicNumber uint32 = 0x8199e26d
+
+// FormatVersion is the archive format version written and understood by this package.
+const FormatVersion = "0.1"
+
+// terminator is the int32 -1 that ends the prelude and every block of documents.
+var terminator = []byte{0xff, 0xff, 0xff, 0xff}
+
+var crcTable = crc64.MakeTable(crc64.ECMA)
+
+// Header describes the archive as a whole.
+type Header struct {
+	ConcurrentCollections int32  `bson:"concurrent_collections"`
+	FormatVersion         string `bson:"version"`
+	ServerVersion         string `bson:"server_version"`
+	ToolVersion           string `bson:"tool_version"`
+}
+
+// CollectionMetadata describes one collection of the archive. Metadata holds the canonical
+// Extended JSON document with the collection options and indexes, as written by mongodump.
+type CollectionMetadata struct {
+	Database   string `bson:"db"`
+	Collection string `bson:"collection"`
+	Metadata   string `bson:"metadata"`
+	Size       int64  `bson:"size"`
+	Type       string `bson:"type"`
+}
+
+// namespaceHeader introduces a block of documents of a collection.
+type namespaceHeader struct {
+	Database   string `bson:"db"`
+	Collection string `bson:"collection"`
+	EOF        bool   `bson:"EOF"`
+	CRC        int64  `bson:"CRC"`
+}
+
+// Namespace identifies a collection of the archive.
+type Namespace struct {
+	Database   string
+	Collection string
+}
+
+func (n Namespace) String() string {
+	return n.Database + "." + n.Collection
+}
+
+// Writer writes an archive. The documents of a collection must be written between Begin and End,
+// collections are written one after the other.
+type Writer struct {
+	w       io.Writer
+	current *Namespace
+	block   bool
+	crc     hash.Hash64
+}
+
+// NewWriter writes the prelude of an archive holding the given collections to w.
+func NewWriter(w io.Writer, header Header, collections []CollectionMetadata) (*Writer, error) {
+	if len(header.FormatVersion) == 0 {
+		header.FormatVersion = FormatVersion
+	}
+	magic := make([]byte, 4)
+	binary.LittleEndian.PutUint32(magic, MagicNumber)
+	if _, err := w.Write(magic); err != nil {
+		return nil, err
+	}
+	if err := writeDocument(w, header); err != nil {
+		return nil, err
+	}
+	for _, collection := range collections {
+		if err := writeDocument(w, collection); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := w.Write(terminator); err != nil {
+		return nil, err
+	}
+	return &Writer{w: w}, nil
+}
+
+// Begin starts the documents of a collection.
+func (a *Writer) Begin(namespace Namespace) error {
+	if a.current != nil {
+		return fmt.Errorf("collection %s has not been ended", a.current)
+	}
+	a.current = &namespace
+	a.block = false
+	a.crc = crc64.New(crcTable)
+	return nil
+}
+
+// Write appends a raw BSON document to the current collection.
+func (a *Writer) Write(doc bson.Raw) error {
+	if a.current == nil {
+		return errors.New("no collection has been started")
+	}
+	if !a.block {
+		if err := writeDocument(a.w, namespaceHeader{Database: a.current.Database, Collection: a.current.Collection}); err != nil {
+			return err
+		}
+		a.block = true
+	}
+	a.crc.Write(doc)
+	_, err := a.w.Write(doc)
+	return err
+}
+
+// End completes the current collection.
+func (a *Writer) End() error {
+	if a.current == nil {
+		return errors.New("no collection has been started")
+	}
+	if a.block {
+		if _, err := a.w.Write(terminator); err != nil {
+			return err
+		}
+	}
+	eof := namespaceHeader{Database: a.current.Database, Collection: a.current.Collection, EOF: true, CRC: int64(a.crc.Sum64())}
+	a.current = nil
+	if err := writeDocument(a.w, eof); err != nil {
+		return err
+	}
+	_, err := a.w.Write(terminator)
+	return err
+}
+
+func writeDocument(w io.Writer, value interface{}) error {
+	data, err := bson.Marshal(value)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// Reader reads an archive.
+type Reader struct {
+	r           io.Reader
+	header      Header
+	collections []CollectionMetadata
+	current     *Namespace
+	crcs        map[Namespace]hash.Hash64
+}
+
+// NewReader reads the prelude of the archive in r.
+func NewReader(r io.Reader) (*Reader, error) {
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return nil, fmt.Errorf("unable to read archive: %w", err)
+	}
+	if binary.LittleEndian.Uint32(magic) != MagicNumber {
+		return nil, errors.New("not an archive, the magic number does not match (is the archive compressed?)")
+	}
+	a := &Reader{r: r, crcs: map[Namespace]hash.Hash64{}}
+	data, err := a.readDocument()
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, errors.New("archive has no header")
+	}
+	if err := bson.Unmarshal(data, &a.header); err != nil {
+		return nil, fmt.Errorf("invalid archive header: %w", err)
+	}
+	if a.header.FormatVersion != FormatVersion {
+		return nil, fmt.Errorf("unsupported archive format version %q", a.header.FormatVersion)
+	}
+	for {
+		data, err := a.readDocument()
+		if err != nil {
+			return nil, err
+		}
+		if data == nil {
+			break
+		}
+		var metadata CollectionMetadata
+		if err := bson.Unmarshal(data, &metadata); err != nil {
+			return nil, fmt.Errorf("invalid collection metadata: %w", err)
+		}
+		a.collections = append(a.collections, metadata)
+	}
+	return a, nil
+}
+
+// Header returns the archive header.
+func (a *Reader) Header() Header {
+	return a.header
+}
+
+// Collections returns the metadata of the collections in the archive.
+func (a *Reader) Collections() []CollectionMetadata {
+	return a.collections
+}
+
+// Next returns the next document of the archive and the collection it belongs to. Once all
+// documents of a collection have been read and their checksum verified, Next returns the
+// collection with a nil document. Next returns io.EOF at the end of the archive.
+func (a *Reader) Next() (Namespace, bson.Raw, error) {
+	for {
+		data, err := a.readDocument()
+		if err == io.EOF && a.current == nil {
+			return Namespace{}, nil, io.EOF
+		}
+		if err != nil {
+			return Namespace{}, nil, err
+		}
+
+		if a.current != nil {
+			if data == nil {
+				// End of the block, the next document is a namespace header.
+				a.current = nil
+				continue
+			}
+			a.crcs[*a.current].Write(data)
+			return *a.current, data, nil
+		}
+
+		if data == nil {
+			return Namespace{}, nil, errors.New("invalid archive, expected a namespace header")
+		}
+		var header namespaceHeader
+		if err := bson.Unmarshal(data, &header); err != nil {
+			return Namespace{}, nil, fmt.Errorf("invalid namespace header: %w", err)
+		}
+		namespace := Namespace{Database: header.Database, Collection: header.Collection}
+		crc, ok := a.crcs[namespace]
+		if !ok {
+			crc = crc64.New(crcTable)
+			a.crcs[namespace] = crc
+		}
+		if !header.EOF {
+			a.current = &namespace
+			continue
+		}
+
+		if end, err := a.readDocument(); err != nil || end != nil {
+			return Namespace{}, nil, fmt.Errorf("invalid archive, expected a terminator after the end of %s", namespace)
+		}
+		if sum := int64(crc.Sum64()); sum != header.CRC {
+			return Namespace{}, nil, fmt.Errorf("checksum mismatch for %s, the archive is corrupt", namespace)
+		}
+		return namespace, nil, nil
+	}
+}
+
+// readDocument reads the next BSON document, returning nil for a terminator.
+func (a *Reader) readDocument() (bson.Raw, error) {
+	length := make([]byte, 4)
+	if _, err := io.ReadFull(a.r, length); err != nil {
+		return nil, err
+	}
+	size := int32(binary.LittleEndian.Uint32(length))
+	if size == -1 {
+		return nil, nil
+	}
+	if size < 5 {
+		return nil, fmt.Errorf("invalid archive, document of %d bytes", size)
+	}
+	data := make([]byte, size)
+	copy(data, length)
+	if _, err := io.ReadFull(a.r, data[4:]); err != nil {
+		return nil, fmt.Errorf("invalid archive, truncated document: %w", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,254 @@
+package archive
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"k8s.io/klog"
+)
+
+// ToolVersion is written to the header of archives created by Dump.
+const ToolVersion = "mongodb-client"
+
+// systemDatabases are skipped unless they are dumped explicitly.
+var systemDatabases = map[string]bool{"admin": true, "config": true, "local": true}
+
+// DumpOptions selects what Dump writes.
+type DumpOptions struct {
+	// Databases to dump, all databases except admin, config and local when empty.
+	Databases []string
+	// Collection restricts the dump to a single collection of each database.
+	Collection string
+	// Oplog adds the oplog entries written while the dump was running, so that restoring them
+	// yields the state at the end of the dump. It requires a replica set.
+	Oplog bool
+	// Transform changes every document of the collections before it is written, such as to mask
+	// its sensitive fields, when it is set. It is not applied to the oplog entries.
+	Transform func(namespace Namespace, doc bson.Raw) (bson.Raw, error)
+}
+
+// DumpResult counts the documents written per collection.
+type DumpResult struct {
+	Namespace Namespace
+	Documents int64
+}
+
+type dumpCollection struct {
+	metadata CollectionMetadata
+	data     bool
+}
+
+// Dump writes the selected collections with their options and indexes to w as an archive.
+func Dump(ctx context.Context, client *mongo.Client, w io.Writer, options DumpOptions) ([]DumpResult, error) {
+	databases := options.Databases
+	if len(databases) == 0 {
+		names, err := client.ListDatabaseNames(ctx, bson.D{})
+		if err != nil {
+			return nil, fmt.Errorf("unable to list databases: %w", err)
+		}
+		for _, name := range names {
+			if !systemDatabases[name] {
+				databases = append(databases, name)
+			}
+		}
+	}
+
+	var collections []dumpCollection
+	for _, database := range databases {
+		found, err := listCollections(ctx, client.Database(database), options.Collection)
+		if err != nil {
+			return nil, err
+		}
+		collections = append(collections, found...)
+	}
+
+	header, err := newHeader(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := make([]CollectionMetadata, 0, len(collections)+1)
+	for _, collection := range collections {
+		metadata = append(metadata, collection.metadata)
+	}
+	var oplogStart primitive.Timestamp
+	if options.Oplog {
+		if oplogStart, err = OplogTop(ctx, client); err != nil {
+			return nil, err
+		}
+		metadata = append(metadata, CollectionMetadata{Database: OplogNamespace.Database, Collection: OplogNamespace.Collection})
+	}
+	archive, err := NewWriter(w, header, metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []DumpResult
+	for _, collection := range collections {
+		if !collection.data {
+			continue
+		}
+		namespace := Namespace{Database: collection.metadata.Database, Collection: collection.metadata.Collection}
+		count, err := dumpDocuments(ctx, client.Database(namespace.Database).Collection(namespace.Collection), archive, namespace, options.Transform)
+		if err != nil {
+			return results, fmt.Errorf("unable to dump %s: %w", namespace, err)
+		}
+		klog.V(2).Infof("Dumped %d documents of %s", count, namespace)
+		results = append(results, DumpResult{Namespace: namespace, Documents: count})
+	}
+
+	if options.Oplog {
+		// Entries from the start of the dump on are included since the dump has seen an unknown
+		// subset of their effects, replaying them is idempotent.
+		oplogEnd, err := OplogTop(ctx, client)
+		if err != nil {
+			return results, err
+		}
+		count, _, err := writeOplog(ctx, client, archive, prior(oplogStart), oplogEnd)
+		if err != nil {
+			return results, fmt.Errorf("unable to dump the oplog: %w", err)
+		}
+		results = append(results, DumpResult{Namespace: OplogNamespace, Documents: count})
+	}
+	return results, nil
+}
+
+// prior returns the timestamp immediately before ts.
+func prior(ts primitive.Timestamp) primitive.Timestamp {
+	if ts.I > 0 {
+		return primitive.Timestamp{T: ts.T, I: ts.I - 1}
+	}
+	return primitive.Timestamp{T: ts.T - 1, I: ^uint32(0)}
+}
+
+// newHeader returns the header of an archive written from the server of client.
+func newHeader(ctx context.Context, client *mongo.Client) (Header, error) {
+	header := Header{FormatVersion: FormatVersion, ToolVersion: ToolVersion, ConcurrentCollections: 1}
+	var buildInfo struct {
+		Version string `bson:"version"`
+	}
+	if err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "buildInfo", Value: 1}}).Decode(&buildInfo); err != nil {
+		return header, fmt.Errorf("unable to determine the server version: %w", err)
+	}
+	header.ServerVersion = buildInfo.Version
+	return header, nil
+}
+
+func listCollections(ctx context.Context, database *mongo.Database, name string) ([]dumpCollection, error) {
+	filter := bson.D{}
+	if len(name) > 0 {
+		filter = append(filter, bson.E{Key: "name", Value: name})
+	}
+	specifications, err := database.ListCollectionSpecifications(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the collections of %s: %w", database.Name(), err)
+	}
+
+	var collections []dumpCollection
+	for _, specification := range specifications {
+		if strings.HasPrefix(specification.Name, "system.") {
+			continue
+		}
+		switch specification.Type {
+		case "collection", "view", "":
+		default:
+			klog.Warningf("Skipping %s.%s, collections of type %s cannot be dumped", database.Name(), specification.Name, specification.Type)
+			continue
+		}
+
+		options := specification.Options
+		if options == nil {
+			options, _ = bson.Marshal(bson.D{})
+		}
+		doc := bson.D{{Key: "options", Value: options}}
+		data := specification.Type != "view"
+		var size int64
+		if data {
+			indexes, err := listIndexes(ctx, database.Collection(specification.Name))
+			if err != nil {
+				return nil, err
+			}
+			doc = append(doc, bson.E{Key: "indexes", Value: indexes})
+			size = collectionSize(ctx, database, specification.Name)
+		}
+		if specification.UUID != nil {
+			doc = append(doc, bson.E{Key: "uuid", Value: hex.EncodeToString(specification.UUID.Data)})
+		}
+		doc = append(doc, bson.E{Key: "collectionName", Value: specification.Name}, bson.E{Key: "type", Value: specification.Type})
+		encoded, err := bson.MarshalExtJSON(doc, true, false)
+		if err != nil {
+			return nil, err
+		}
+
+		collections = append(collections, dumpCollection{
+			metadata: CollectionMetadata{
+				Database:   database.Name(),
+				Collection: specification.Name,
+				Metadata:   string(encoded),
+				Size:       size,
+				Type:       specification.Type,
+			},
+			data: data,
+		})
+	}
+	return collections, nil
+}
+
+func listIndexes(ctx context.Context, collection *mongo.Collection) ([]bson.Raw, error) {
+	cursor, err := collection.Indexes().List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the indexes of %s: %w", collection.Name(), err)
+	}
+	defer cursor.Close(ctx)
+	var indexes []bson.Raw
+	for cursor.Next(ctx) {
+		indexes = append(indexes, append(bson.Raw(nil), cursor.Current...))
+	}
+	return indexes, cursor.Err()
+}
+
+// collectionSize returns the uncompressed size of the collection, which mongorestore uses for
+// progress reporting only.
+func collectionSize(ctx context.Context, database *mongo.Database, name string) int64 {
+	var stats struct {
+		Size int64 `bson:"size"`
+	}
+	if err := database.RunCommand(ctx, bson.D{{Key: "collStats", Value: name}}).Decode(&stats); err != nil {
+		klog.V(4).Infof("Unable to determine the size of %s.%s: %v", database.Name(), name, err)
+	}
+	return stats.Size
+}
+
+func dumpDocuments(ctx context.Context, collection *mongo.Collection, archive *Writer, namespace Namespace, transform func(Namespace, bson.Raw) (bson.Raw, error)) (int64, error) {
+	if err := archive.Begin(namespace); err != nil {
+		return 0, err
+	}
+	cursor, err := collection.Find(ctx, bson.D{})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+	var count int64
+	for cursor.Next(ctx) {
+		doc := cursor.Current
+		if transform != nil {
+			if doc, err = transform(namespace, doc); err != nil {
+				return count, err
+			}
+		}
+		if err := archive.Write(doc); err != nil {
+			return count, err
+		}
+		count++
+	}
+	if err := cursor.Err(); err != nil {
+		return count, err
+	}
+	return count, archive.End()
+}
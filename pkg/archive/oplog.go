@@ -0,0 +1,243 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"k8s.io/klog"
+)
+
+// OplogNamespace holds the oplog entries of an archive, as written by mongodump --oplog.
+var OplogNamespace = Namespace{Collection: "oplog"}
+
+// applyOpsBatchBytes bounds the size of a single applyOps command well below the 16MiB limit.
+const applyOpsBatchBytes = 8 << 20
+
+// oplogEntry holds the fields of an oplog entry needed to decide whether to replay it.
+type oplogEntry struct {
+	Timestamp primitive.Timestamp `bson:"ts"`
+	Operation string              `bson:"op"`
+	Namespace string              `bson:"ns"`
+}
+
+// timestampAfter reports whether a is later than b.
+func timestampAfter(a, b primitive.Timestamp) bool {
+	return a.T > b.T || (a.T == b.T && a.I > b.I)
+}
+
+// OplogTop returns the timestamp of the most recent oplog entry.
+func OplogTop(ctx context.Context, client *mongo.Client) (primitive.Timestamp, error) {
+	var entry oplogEntry
+	err := client.Database("local").Collection("oplog.rs").FindOne(ctx, bson.D{}, options.FindOne().SetSort(bson.D{{Key: "$natural", Value: -1}})).Decode(&entry)
+	if err == mongo.ErrNoDocuments {
+		return primitive.Timestamp{}, errors.New("the oplog is empty, oplog capture requires a replica set")
+	}
+	if err != nil {
+		return primitive.Timestamp{}, fmt.Errorf("unable to read the oplog, oplog capture requires a replica set: %w", err)
+	}
+	return entry.Timestamp, nil
+}
+
+// OplogStart returns the timestamp of the oldest oplog entry still available.
+func OplogStart(ctx context.Context, client *mongo.Client) (primitive.Timestamp, error) {
+	var entry oplogEntry
+	err := client.Database("local").Collection("oplog.rs").FindOne(ctx, bson.D{}, options.FindOne().SetSort(bson.D{{Key: "$natural", Value: 1}})).Decode(&entry)
+	if err != nil {
+		return primitive.Timestamp{}, fmt.Errorf("unable to read the oplog: %w", err)
+	}
+	return entry.Timestamp, nil
+}
+
+// writeOplog writes the oplog entries after from and up to and including to as the oplog
+// namespace of archive, returning the number of entries and the timestamp of the last one.
+func writeOplog(ctx context.Context, client *mongo.Client, archive *Writer, from, to primitive.Timestamp) (int64, primitive.Timestamp, error) {
+	last := from
+	if err := archive.Begin(OplogNamespace); err != nil {
+		return 0, last, err
+	}
+	filter := bson.D{{Key: "ts", Value: bson.D{{Key: "$gt", Value: from}, {Key: "$lte", Value: to}}}}
+	cursor, err := client.Database("local").Collection("oplog.rs").Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "$natural", Value: 1}}))
+	if err != nil {
+		return 0, last, fmt.Errorf("unable to read the oplog: %w", err)
+	}
+	defer cursor.Close(ctx)
+	var count int64
+	for cursor.Next(ctx) {
+		if err := archive.Write(cursor.Current); err != nil {
+			return count, last, err
+		}
+		if t, i, ok := cursor.Current.Lookup("ts").TimestampOK(); ok {
+			last = primitive.Timestamp{T: t, I: i}
+		}
+		count++
+	}
+	if err := cursor.Err(); err != nil {
+		return count, last, err
+	}
+	return count, last, archive.End()
+}
+
+// CaptureOplog writes an archive to w holding only the oplog entries after from and up to and
+// including to. It returns the number of entries and the timestamp of the last one.
+func CaptureOplog(ctx context.Context, client *mongo.Client, w io.Writer, from, to primitive.Timestamp) (int64, primitive.Timestamp, error) {
+	header, err := newHeader(ctx, client)
+	if err != nil {
+		return 0, from, err
+	}
+	archive, err := NewWriter(w, header, []CollectionMetadata{{Database: OplogNamespace.Database, Collection: OplogNamespace.Collection}})
+	if err != nil {
+		return 0, from, err
+	}
+	return writeOplog(ctx, client, archive, from, to)
+}
+
+// oplogApplier replays oplog entries with applyOps, in batches.
+type oplogApplier struct {
+	client   *mongo.Client
+	selected func(Namespace) bool
+	// after skips entries up to and including this timestamp, which have already been applied.
+	after primitive.Timestamp
+	// until skips entries later than this timestamp unless it is zero.
+	until   primitive.Timestamp
+	batch   bson.A
+	size    int
+	applied int64
+	// last is the timestamp of the last queued entry, done the one of the last applied entry.
+	last primitive.Timestamp
+	done primitive.Timestamp
+}
+
+// add queues a raw oplog entry, applying the queue once it is large enough.
+func (a *oplogApplier) add(ctx context.Context, doc bson.Raw) error {
+	var entry oplogEntry
+	if err := bson.Unmarshal(doc, &entry); err != nil {
+		return fmt.Errorf("invalid oplog entry: %w", err)
+	}
+	if !timestampAfter(entry.Timestamp, a.after) {
+		return nil
+	}
+	if !a.until.IsZero() && timestampAfter(entry.Timestamp, a.until) {
+		return nil
+	}
+	if entry.Operation == "n" || !a.replayed(entry.Namespace) {
+		return nil
+	}
+
+	// The collection UUIDs recorded in the entries refer to the original collections, the server
+	// would reject them for the restored ones.
+	entryDoc := bson.D{}
+	elements, err := doc.Elements()
+	if err != nil {
+		return err
+	}
+	for _, element := range elements {
+		if element.Key() == "ui" {
+			continue
+		}
+		entryDoc = append(entryDoc, bson.E{Key: element.Key(), Value: element.Value()})
+	}
+	if a.size+len(doc) > applyOpsBatchBytes {
+		if err := a.flush(ctx); err != nil {
+			return err
+		}
+	}
+	a.batch = append(a.batch, entryDoc)
+	a.size += len(doc)
+	a.last = entry.Timestamp
+	return nil
+}
+
+// replayed reports whether entries of the namespace ns are replayed. Entries for internal
+// databases are never replayed, commands are replayed for the selected databases.
+func (a *oplogApplier) replayed(ns string) bool {
+	database, collection := ns, ""
+	if index := strings.Index(ns, "."); index >= 0 {
+		database, collection = ns[:index], ns[index+1:]
+	}
+	if len(database) == 0 || database == "local" || database == "config" || strings.HasPrefix(collection, "system.") {
+		return false
+	}
+	if database == "admin" {
+		// Commands of transactions and other multi-namespace operations are only replayed when
+		// everything is restored, since they cannot be filtered.
+		return collection == "$cmd" && a.selected(Namespace{})
+	}
+	if collection == "$cmd" {
+		collection = ""
+	}
+	return a.selected(Namespace{Database: database, Collection: collection})
+}
+
+func (a *oplogApplier) flush(ctx context.Context) error {
+	if len(a.batch) == 0 {
+		return nil
+	}
+	if err := a.client.Database("admin").RunCommand(ctx, bson.D{{Key: "applyOps", Value: a.batch}}).Err(); err != nil {
+		return fmt.Errorf("unable to replay the oplog up to %d.%d: %w", a.last.T, a.last.I, err)
+	}
+	a.applied += int64(len(a.batch))
+	a.done = a.last
+	klog.V(4).Infof("Replayed %d oplog entries up to %d.%d", len(a.batch), a.last.T, a.last.I)
+	a.batch = a.batch[:0]
+	a.size = 0
+	return nil
+}
+
+// ReplayOplog applies the oplog entries of an archive read from r that are later than after and,
+// unless options.ToTimestamp is zero, not later than options.ToTimestamp. It returns the number of
+// entries applied and the timestamp of the last one, or after when none were applied.
+func ReplayOplog(ctx context.Context, client *mongo.Client, r io.Reader, options RestoreOptions, after primitive.Timestamp) (int64, primitive.Timestamp, error) {
+	archive, err := NewReader(r)
+	if err != nil {
+		return 0, after, err
+	}
+	applier := options.applier(client, after)
+	for {
+		namespace, doc, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return applier.applied, applier.lastApplied(), err
+		}
+		if namespace != OplogNamespace || doc == nil {
+			continue
+		}
+		if err := applier.add(ctx, doc); err != nil {
+			return applier.applied, applier.lastApplied(), err
+		}
+	}
+	err = applier.flush(ctx)
+	return applier.applied, applier.lastApplied(), err
+}
+
+func (o RestoreOptions) applier(client *mongo.Client, after primitive.Timestamp) *oplogApplier {
+	return &oplogApplier{
+		client: client,
+		selected: func(namespace Namespace) bool {
+			if len(namespace.Database) == 0 {
+				return len(o.Database) == 0 && len(o.Collection) == 0
+			}
+			if len(namespace.Collection) == 0 {
+				return len(o.Database) == 0 || namespace.Database == o.Database
+			}
+			return o.selected(namespace)
+		},
+		after: after,
+		until: o.ToTimestamp,
+		last:  after,
+		done:  after,
+	}
+}
+
+// lastApplied returns the timestamp of the last entry applied so far.
+func (a *oplogApplier) lastApplied() primitive.Timestamp {
+	return a.done
+}
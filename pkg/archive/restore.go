@@ -0,0 +1,270 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/bulk"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"k8s.io/klog"
+)
+
+// codeNamespaceExists is returned by the server when creating a collection that already exists.
+const codeNamespaceExists = 48
+
+// codeDuplicateKey is returned by the server for documents whose _id or unique keys already exist.
+const codeDuplicateKey = 11000
+
+// RestoreOptions selects what Restore reads from an archive and how it is written.
+type RestoreOptions struct {
+	// Database restricts the restore to a single database of the archive.
+	Database string
+	// Collection restricts the restore to a single collection of each database.
+	Collection string
+	// ToDatabase restores the collections into this database instead of their original one.
+	ToDatabase string
+	// Drop drops each collection before restoring it.
+	Drop bool
+	// CheckDrop, when it is set, is called before each collection is dropped, the restore fails
+	// without dropping it when it returns an error.
+	CheckDrop func(database, collection string) error
+	// NoIndexRestore skips creating the indexes recorded in the archive.
+	NoIndexRestore bool
+	// BatchSize is the number of documents inserted per request.
+	BatchSize int
+	// ReplayOplog applies the oplog entries of the archive once the collections are restored.
+	ReplayOplog bool
+	// ToTimestamp stops the oplog replay after the entries with this timestamp, the whole oplog
+	// is replayed when it is zero.
+	ToTimestamp primitive.Timestamp
+}
+
+// RestoreResult counts the documents restored per collection. Documents whose _id already exist
+// are skipped like mongorestore does and counted as duplicates. The result for OplogNamespace
+// counts the replayed oplog entries and records the timestamp of the last one.
+type RestoreResult struct {
+	Namespace     Namespace
+	Documents     int64
+	Duplicates    int64
+	LastTimestamp primitive.Timestamp
+}
+
+// metadata is the decoded Metadata field of a CollectionMetadata.
+type metadata struct {
+	Options bson.D   `bson:"options"`
+	Indexes []bson.D `bson:"indexes"`
+}
+
+// Restore reads an archive from r and restores the selected collections.
+func Restore(ctx context.Context, client *mongo.Client, r io.Reader, options RestoreOptions) ([]RestoreResult, error) {
+	if options.BatchSize < 1 {
+		options.BatchSize = 1000
+	}
+	if len(options.ToDatabase) > 0 && options.ReplayOplog {
+		return nil, errors.New("the oplog cannot be replayed into a different database")
+	}
+	archive, err := NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	klog.V(2).Infof("Restoring archive of server version %s written by %s", archive.Header().ServerVersion, archive.Header().ToolVersion)
+
+	restored := map[Namespace]*metadata{}
+	var views []CollectionMetadata
+	databases := map[string]bool{}
+	for _, collection := range archive.Collections() {
+		if collection.Database == OplogNamespace.Database && collection.Collection == OplogNamespace.Collection {
+			continue
+		}
+		if !options.selected(Namespace{Database: collection.Database, Collection: collection.Collection}) {
+			continue
+		}
+		databases[collection.Database] = true
+		if len(options.ToDatabase) > 0 && len(databases) > 1 {
+			return nil, errors.New("the archive holds more than one database, select one to restore into a different database")
+		}
+
+		var m metadata
+		if err := bson.UnmarshalExtJSON([]byte(collection.Metadata), true, &m); err != nil {
+			return nil, fmt.Errorf("invalid metadata for %s.%s: %w", collection.Database, collection.Collection, err)
+		}
+		target := client.Database(options.target(collection.Database))
+		if options.Drop {
+			if options.CheckDrop != nil {
+				if err := options.CheckDrop(target.Name(), collection.Collection); err != nil {
+					return nil, err
+				}
+			}
+			if err := target.Collection(collection.Collection).Drop(ctx); err != nil {
+				return nil, fmt.Errorf("unable to drop %s.%s: %w", target.Name(), collection.Collection, err)
+			}
+		}
+		if collection.Type == "view" {
+			// Views are created last since they may be defined on collections restored later.
+			views = append(views, collection)
+			continue
+		}
+		if err := CreateCollection(ctx, target, collection.Collection, m.Options); err != nil {
+			return nil, err
+		}
+		restored[Namespace{Database: collection.Database, Collection: collection.Collection}] = &m
+	}
+
+	var results []RestoreResult
+	writers := map[Namespace]*bulk.Writer{}
+	counts := map[Namespace]*RestoreResult{}
+	// The oplog follows the collections, it is replayed once all of them are restored.
+	applier := options.applier(client, primitive.Timestamp{})
+	for {
+		namespace, doc, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return results, err
+		}
+		if namespace == OplogNamespace {
+			if !options.ReplayOplog {
+				continue
+			}
+			if doc != nil {
+				err = applier.add(ctx, doc)
+			} else {
+				err = applier.flush(ctx)
+			}
+			if err != nil {
+				return results, err
+			}
+			continue
+		}
+		m, ok := restored[namespace]
+		if !ok {
+			continue
+		}
+		result, ok := counts[namespace]
+		if !ok {
+			result = &RestoreResult{Namespace: Namespace{Database: options.target(namespace.Database), Collection: namespace.Collection}}
+			counts[namespace] = result
+		}
+		collection := client.Database(result.Namespace.Database).Collection(namespace.Collection)
+		writer, ok := writers[namespace]
+		if !ok {
+			writer = bulk.NewWriter(collection, bulk.Options{BatchSize: options.BatchSize})
+			writers[namespace] = writer
+		}
+
+		if doc != nil {
+			if err := ignoreDuplicates(writer.Add(ctx, mongo.NewInsertOneModel().SetDocument(doc))); err != nil {
+				return results, fmt.Errorf("unable to restore %s: %w", result.Namespace, err)
+			}
+			continue
+		}
+		if err := ignoreDuplicates(writer.Flush(ctx)); err != nil {
+			return results, fmt.Errorf("unable to restore %s: %w", result.Namespace, err)
+		}
+		// Only duplicates are left among the failures.
+		result.Documents = writer.Result().Inserted
+		result.Duplicates = int64(len(writer.Result().Failures))
+
+		if !options.NoIndexRestore {
+			if err := CreateIndexes(ctx, collection, m.Indexes); err != nil {
+				return results, err
+			}
+		}
+		klog.V(2).Infof("Restored %d documents of %s", result.Documents, result.Namespace)
+		results = append(results, *result)
+	}
+
+	if options.ReplayOplog {
+		if err := applier.flush(ctx); err != nil {
+			return results, err
+		}
+		klog.V(2).Infof("Replayed %d oplog entries", applier.applied)
+		results = append(results, RestoreResult{Namespace: OplogNamespace, Documents: applier.applied, LastTimestamp: applier.lastApplied()})
+	}
+
+	for _, view := range views {
+		var m metadata
+		if err := bson.UnmarshalExtJSON([]byte(view.Metadata), true, &m); err != nil {
+			return results, fmt.Errorf("invalid metadata for %s.%s: %w", view.Database, view.Collection, err)
+		}
+		if err := CreateCollection(ctx, client.Database(options.target(view.Database)), view.Collection, m.Options); err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+func (o RestoreOptions) selected(namespace Namespace) bool {
+	if len(o.Database) > 0 && namespace.Database != o.Database {
+		return false
+	}
+	return len(o.Collection) == 0 || namespace.Collection == o.Collection
+}
+
+func (o RestoreOptions) target(database string) string {
+	if len(o.ToDatabase) > 0 {
+		return o.ToDatabase
+	}
+	return database
+}
+
+// CreateCollection creates a collection or view with options, such as those recorded in an archive.
+// An existing collection is left as it is.
+func CreateCollection(ctx context.Context, database *mongo.Database, name string, options bson.D) error {
+	command := append(bson.D{{Key: "create", Value: name}}, options...)
+	err := database.RunCommand(ctx, command).Err()
+	var commandErr mongo.CommandError
+	if errors.As(err, &commandErr) && commandErr.Code == codeNamespaceExists {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to create %s.%s: %w", database.Name(), name, err)
+	}
+	return nil
+}
+
+// ignoreDuplicates returns err unless it only reports documents whose _id already exists.
+func ignoreDuplicates(err error) error {
+	var failed *bulk.Error
+	if errors.As(err, &failed) && failed.Only(codeDuplicateKey) {
+		return nil
+	}
+	return err
+}
+
+// CreateIndexes creates indexes, specifications as listed by listIndexes, on collection. The _id index
+// is skipped as the server creates it with the collection.
+func CreateIndexes(ctx context.Context, collection *mongo.Collection, indexes []bson.D) error {
+	var specifications bson.A
+	for _, index := range indexes {
+		specification := bson.D{}
+		var name interface{}
+		for _, e := range index {
+			switch e.Key {
+			case "v", "ns":
+				// The index version and namespace are chosen by the server.
+				continue
+			case "name":
+				name = e.Value
+			}
+			specification = append(specification, e)
+		}
+		if name == "_id_" {
+			continue
+		}
+		specifications = append(specifications, specification)
+	}
+	if len(specifications) == 0 {
+		return nil
+	}
+	command := bson.D{{Key: "createIndexes", Value: collection.Name()}, {Key: "indexes", Value: specifications}}
+	if err := collection.Database().RunCommand(ctx, command).Err(); err != nil {
+		return fmt.Errorf("unable to create the indexes of %s.%s: %w", collection.Database().Name(), collection.Name(), err)
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+// Package atlas manages the Atlas Search indexes of a cluster through the Atlas Admin API, which
+// the database connection can not reach.
+package atlas
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// DefaultURL is the base URL of the Atlas Admin API.
+const DefaultURL = "https://cloud.mongodb.com/api/atlas/v1.0"
+
+// Client sends requests about the cluster ClusterName of the project GroupID. Requests are
+// authenticated by an API key read from ATLAS_PUBLIC_KEY and ATLAS_PRIVATE_KEY, with HTTP digest
+// authentication.
+type Client struct {
+	url         string
+	groupID     string
+	clusterName string
+	publicKey   string
+	privateKey  string
+	http        *http.Client
+}
+
+// NewClient returns a client of the cluster, using the API at baseURL, DefaultURL when empty.
+func NewClient(baseURL, groupID, clusterName string, timeout time.Duration) (*Client, error) {
+	if len(baseURL) == 0 {
+		baseURL = DefaultURL
+	}
+	if !strings.HasPrefix(baseURL, "https://") && !strings.HasPrefix(baseURL, "http://") {
+		return nil, fmt.Errorf("invalid Atlas Admin API URL %q, must be an http:// or https:// URL", baseURL)
+	}
+	if len(groupID) == 0 || len(clusterName) == 0 {
+		return nil, fmt.Errorf("the project (groupID) and the name of the cluster are required")
+	}
+	client := &Client{
+		url:         strings.TrimSuffix(baseURL, "/"),
+		groupID:     groupID,
+		clusterName: clusterName,
+		publicKey:   os.Getenv("ATLAS_PUBLIC_KEY"),
+		privateKey:  os.Getenv("ATLAS_PRIVATE_KEY"),
+		http:        &http.Client{Timeout: timeout},
+	}
+	if len(client.publicKey) == 0 || len(client.privateKey) == 0 {
+		return nil, fmt.Errorf("ATLAS_PUBLIC_KEY and ATLAS_PRIVATE_KEY must be set to use the Atlas Admin API")
+	}
+	return client, nil
+}
+
+// SearchIndex is an Atlas Search index with its definition.
+type SearchIndex struct {
+	ID             string `json:"indexID,omitempty"`
+	Name           string `json:"name"`
+	Database       string `json:"database"`
+	CollectionName string `json:"collectionName"`
+	// Status is IN_PROGRESS while the index is built, then STEADY.
+	Status string `json:"status,omitempty"`
+	// Mappings defaults to dynamic mappings, which index every field of a supported type.
+	Mappings json.RawMessage `json:"mappings,omitempty"`
+	Analyzer string          `json:"analyzer,omitempty"`
+	// Analyzers and Synonyms are passed through as they are written.
+	Analyzers json.RawMessage `json:"analyzers,omitempty"`
+	Synonyms  json.RawMessage `json:"synonyms,omitempty"`
+}
+
+// responseError is returned for unexpected responses.
+type responseError struct {
+	method string
+	path   string
+	code   int
+	body   string
+}
+
+func (e *responseError) Error() string {
+	return fmt.Sprintf("%s %s: unexpected status %d: %s", e.method, e.path, e.code, strings.TrimSpace(e.body))
+}
+
+// SearchIndexes returns the search indexes of the collection.
+func (c *Client) SearchIndexes(ctx context.Context, database, collection string) ([]SearchIndex, error) {
+	var indexes []SearchIndex
+	path := fmt.Sprintf("/fts/indexes/%s/%s", url.PathEscape(database), url.PathEscape(collection))
+	if err := c.do(ctx, http.MethodGet, path, nil, &indexes); err != nil {
+		return nil, fmt.Errorf("unable to list the search indexes of %s.%s: %w", database, collection, err)
+	}
+	return indexes, nil
+}
+
+// CreateSearchIndex starts building index and returns it with its ID.
+func (c *Client) CreateSearchIndex(ctx context.Context, index SearchIndex) (*SearchIndex, error) {
+	if len(index.Mappings) == 0 {
+		index.Mappings = json.RawMessage(`{"dynamic": true}`)
+	}
+	body, err := json.Marshal(index)
+	if err != nil {
+		return nil, err
+	}
+	var created SearchIndex
+	if err := c.do(ctx, http.MethodPost, "/fts/indexes", body, &created); err != nil {
+		return nil, fmt.Errorf("unable to create the search index %s of %s.%s: %w", index.Name, index.Database, index.CollectionName, err)
+	}
+	return &created, nil
+}
+
+// DeleteSearchIndex deletes the search index with the ID id.
+func (c *Client) DeleteSearchIndex(ctx context.Context, id string) error {
+	if err := c.do(ctx, http.MethodDelete, "/fts/indexes/"+url.PathEscape(id), nil, nil); err != nil {
+		return fmt.Errorf("unable to delete the search index %s: %w", id, err)
+	}
+	return nil
+}
+
+// do sends a request about the cluster and decodes the JSON response into out unless it is nil. The
+// request is sent a second time with the digest credentials answering the challenge of the first.
+func (c *Client) do(ctx context.Context, method, path string, body []byte, out interface{}) error {
+	path = fmt.Sprintf("/groups/%s/clusters/%s%s", url.PathEscape(c.groupID), url.PathEscape(c.clusterName), path)
+	resp, err := c.send(ctx, method, path, body, "")
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		authorization, err := c.digest(method, c.url+path, challenge)
+		if err != nil {
+			return err
+		}
+		if resp, err = c.send(ctx, method, path, body, authorization); err != nil {
+			return err
+		}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return &responseError{method: method, path: path, code: resp.StatusCode, body: string(data)}
+	}
+	if out == nil {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (c *Client) send(ctx context.Context, method, path string, body []byte, authorization string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.url+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if len(body) > 0 {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if len(authorization) > 0 {
+		req.Header.Set("Authorization", authorization)
+	}
+	return c.http.Do(req)
+}
+
+// digest returns the Authorization header answering the digest challenge of the API for a request
+// of method to rawURL, as described by RFC 2617 with the MD5 algorithm and the auth qop.
+func (c *Client) digest(method, rawURL, challenge string) (string, error) {
+	if !strings.HasPrefix(challenge, "Digest ") {
+		return "", fmt.Errorf("the Atlas Admin API rejected the credentials without a digest challenge")
+	}
+	params := challengeParams(strings.TrimPrefix(challenge, "Digest "))
+	if algorithm := params["algorithm"]; len(algorithm) > 0 && algorithm != "MD5" {
+		return "", fmt.Errorf("unsupported digest algorithm %s", algorithm)
+	}
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	uri := parsed.RequestURI()
+	nonce := make([]byte, 8)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	cnonce, nc := hex.EncodeToString(nonce), "00000001"
+	ha1 := md5Hex(c.publicKey + ":" + params["realm"] + ":" + c.privateKey)
+	ha2 := md5Hex(method + ":" + uri)
+	response := md5Hex(strings.Join([]string{ha1, params["nonce"], nc, cnonce, "auth", ha2}, ":"))
+	return fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s", qop=auth, nc=%s, cnonce="%s", response="%s", algorithm=MD5`,
+		c.publicKey, params["realm"], params["nonce"], uri, nc, cnonce, response), nil
+}
+
+// challengeParams parses the comma-separated key=value parameters of a challenge, whose quoted values
+// may hold commas.
+func challengeParams(challenge string) map[string]string {
+	params := map[string]string{}
+	var parts []string
+	quoted, start := false, 0
+	for i, r := range challenge {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == ',' && !quoted:
+			parts = append(parts, challenge[start:i])
+			start = i + 1
+		}
+	}
+	parts = append(parts, challenge[start:])
+	for _, part := range parts {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) == 2 {
+			params[kv[0]] = strings.Trim(kv[1], `"`)
+		}
+	}
+	return params
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
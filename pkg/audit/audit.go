@@ -0,0 +1,358 @@
+// Package audit records the operations of the clients that change data, users or the deployment:
+// who ran them, when, on which namespace, the shape of their filters without the values, and their
+// result counts. The records are written by a command monitor, whatever code issues the commands,
+// to an append-only file and to a collection.
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"sync"
+	"time"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/client"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo"
+	"k8s.io/klog"
+)
+
+// maxFilters bounds the filter shapes recorded for a command holding many statements.
+const maxFilters = 10
+
+// Record is an audited operation.
+type Record struct {
+	Time time.Time `json:"time" bson:"time"`
+	// Actor is who ran the operation: the authenticated caller of a request, or the user running
+	// the client.
+	Actor string `json:"actor" bson:"actor"`
+	// Source is the command line or the server the operation was run by.
+	Source     string `json:"source" bson:"source"`
+	Host       string `json:"host" bson:"host"`
+	Command    string `json:"command" bson:"command"`
+	Database   string `json:"database" bson:"database"`
+	Collection string `json:"collection,omitempty" bson:"collection,omitempty"`
+	// Filters are the shapes of the filters of the statements of the command, their values
+	// replaced by "?".
+	Filters []string `json:"filters,omitempty" bson:"filters,omitempty"`
+	// Statements is the number of documents inserted, or of updates or deletes, sent.
+	Statements int `json:"statements,omitempty" bson:"statements,omitempty"`
+	// N is the number of documents inserted, matched or deleted, as reported by the server.
+	N           int64  `json:"n" bson:"n"`
+	Modified    int64  `json:"modified,omitempty" bson:"modified,omitempty"`
+	Upserted    int64  `json:"upserted,omitempty" bson:"upserted,omitempty"`
+	WriteErrors int    `json:"writeErrors,omitempty" bson:"writeErrors,omitempty"`
+	DurationMS  int64  `json:"durationMillis" bson:"durationMillis"`
+	Error       string `json:"error,omitempty" bson:"error,omitempty"`
+}
+
+// Namespace returns the database.collection of the record, the database for database commands.
+func (r Record) Namespace() string {
+	if len(r.Collection) == 0 {
+		return r.Database
+	}
+	return r.Database + "." + r.Collection
+}
+
+// Sink stores records.
+type Sink interface {
+	Write(record Record) error
+	Close() error
+}
+
+// Logger records the audited commands of the clients it monitors to its sinks.
+type Logger struct {
+	source string
+	host   string
+	sinks  []Sink
+	// Actor returns who runs the operation of ctx, the user running the client when it returns an
+	// empty name or is nil.
+	Actor func(ctx context.Context) string
+	user  string
+
+	lock    sync.Mutex
+	started map[int64]*Record
+}
+
+// NewLogger returns a logger of the commands run by source writing to sinks.
+func NewLogger(source string, sinks ...Sink) *Logger {
+	l := &Logger{source: source, sinks: sinks, started: map[int64]*Record{}, user: "unknown"}
+	l.host, _ = os.Hostname()
+	if current, err := user.Current(); err == nil {
+		l.user = current.Username
+	}
+	return l
+}
+
+// Monitor returns the command monitor to set on the clients whose commands are audited.
+func (l *Logger) Monitor() *event.CommandMonitor {
+	return &event.CommandMonitor{Started: l.commandStarted, Succeeded: l.commandSucceeded, Failed: l.commandFailed}
+}
+
+// Close closes the sinks of the logger.
+func (l *Logger) Close() error {
+	var errs []error
+	for _, sink := range l.sinks {
+		if err := sink.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("unable to close the audit sinks: %v", errs)
+	}
+	return nil
+}
+
+func (l *Logger) commandStarted(ctx context.Context, e *event.CommandStartedEvent) {
+	if client.ReadOnlyCommand(e.CommandName, e.Command) {
+		return
+	}
+	record := &Record{
+		Time:     time.Now().UTC(),
+		Actor:    l.user,
+		Source:   l.source,
+		Host:     l.host,
+		Command:  e.CommandName,
+		Database: e.DatabaseName,
+	}
+	if l.Actor != nil {
+		if actor := l.Actor(ctx); len(actor) > 0 {
+			record.Actor = actor
+		}
+	}
+	describe(record, e.Command)
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.started[e.RequestID] = record
+}
+
+func (l *Logger) finished(e event.CommandFinishedEvent) *Record {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	record, ok := l.started[e.RequestID]
+	if !ok {
+		return nil
+	}
+	delete(l.started, e.RequestID)
+	record.DurationMS = e.DurationNanos / int64(time.Millisecond)
+	return record
+}
+
+func (l *Logger) commandSucceeded(_ context.Context, e *event.CommandSucceededEvent) {
+	if record := l.finished(e.CommandFinishedEvent); record != nil {
+		results(record, e.Reply)
+		l.write(*record)
+	}
+}
+
+func (l *Logger) commandFailed(_ context.Context, e *event.CommandFailedEvent) {
+	if record := l.finished(e.CommandFinishedEvent); record != nil {
+		record.Error = e.Failure
+		l.write(*record)
+	}
+}
+
+// write writes record to every sink. The commands are already done, the failures can only be
+// reported.
+func (l *Logger) write(record Record) {
+	for _, sink := range l.sinks {
+		if err := sink.Write(record); err != nil {
+			klog.Errorf("Unable to write the audit record of %s on %s: %v", record.Command, record.Namespace(), err)
+		}
+	}
+}
+
+// describe sets the collection, the statements and the filter shapes of the command on record.
+func describe(record *Record, command bson.Raw) {
+	if collection, ok := command.Lookup(record.Command).StringValueOK(); ok {
+		record.Collection = collection
+	}
+	var filters []bson.Raw
+	switch record.Command {
+	case "insert":
+		documents, _ := command.Lookup("documents").Array().Values()
+		record.Statements = len(documents)
+	case "update", "delete":
+		field := record.Command + "s"
+		statements, _ := command.Lookup(field).Array().Values()
+		record.Statements = len(statements)
+		for _, statement := range statements {
+			if doc, ok := statement.DocumentOK(); ok {
+				if filter, ok := doc.Lookup("q").DocumentOK(); ok {
+					filters = append(filters, filter)
+				}
+			}
+		}
+	case "findAndModify":
+		record.Statements = 1
+		if filter, ok := command.Lookup("query").DocumentOK(); ok {
+			filters = append(filters, filter)
+		}
+	}
+	seen := map[string]bool{}
+	for _, filter := range filters {
+		data, err := bson.MarshalExtJSON(Shape(filter), false, false)
+		if err != nil || seen[string(data)] {
+			continue
+		}
+		if len(record.Filters) == maxFilters {
+			break
+		}
+		seen[string(data)] = true
+		record.Filters = append(record.Filters, string(data))
+	}
+}
+
+// results sets the counts of the reply of the command on record.
+func results(record *Record, reply bson.Raw) {
+	if errmsg, ok := reply.Lookup("errmsg").StringValueOK(); ok {
+		if ok, _ := reply.Lookup("ok").AsInt64OK(); ok == 0 {
+			record.Error = errmsg
+		}
+	}
+	n := reply.Lookup("n")
+	if record.Command == "findAndModify" {
+		n = reply.Lookup("lastErrorObject", "n")
+	}
+	record.N, _ = n.AsInt64OK()
+	record.Modified, _ = reply.Lookup("nModified").AsInt64OK()
+	if upserted, ok := reply.Lookup("upserted").ArrayOK(); ok {
+		values, _ := upserted.Values()
+		record.Upserted = int64(len(values))
+	}
+	if writeErrors, ok := reply.Lookup("writeErrors").ArrayOK(); ok {
+		values, _ := writeErrors.Values()
+		record.WriteErrors = len(values)
+	}
+}
+
+// Shape returns filter with its values replaced by "?", keeping the fields and operators, so that
+// what was matched is recorded without the data it was matched with.
+func Shape(filter bson.Raw) bson.D {
+	elements, _ := filter.Elements()
+	shape := make(bson.D, 0, len(elements))
+	for _, element := range elements {
+		shape = append(shape, bson.E{Key: element.Key(), Value: shapeValue(element.Value())})
+	}
+	return shape
+}
+
+func shapeValue(value bson.RawValue) interface{} {
+	if doc, ok := value.DocumentOK(); ok {
+		return Shape(doc)
+	}
+	if array, ok := value.ArrayOK(); ok {
+		// Arrays of conditions, as those of $and and $or, keep their shapes.
+		values, _ := array.Values()
+		shapes := bson.A{}
+		for _, v := range values {
+			if _, ok := v.DocumentOK(); ok {
+				shapes = append(shapes, shapeValue(v))
+			}
+		}
+		if len(shapes) > 0 {
+			return shapes
+		}
+	}
+	return "?"
+}
+
+// FileSink appends the records to a file, one JSON document per line, synced on every record.
+type FileSink struct {
+	lock sync.Mutex
+	file *os.File
+}
+
+// NewFileSink opens the file at path for appending, creating it readable by its owner only.
+func NewFileSink(path string) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open the audit file: %w", err)
+	}
+	return &FileSink{file: file}, nil
+}
+
+func (s *FileSink) Write(record Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, err := s.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+func (s *FileSink) Close() error {
+	return s.file.Close()
+}
+
+// CollectionSink inserts the records into a collection, connected to when the first record is
+// written. The client of the collection must not be monitored by the logger.
+type CollectionSink struct {
+	connect func(ctx context.Context) (*mongo.Collection, func(), error)
+	timeout time.Duration
+
+	lock       sync.Mutex
+	collection *mongo.Collection
+	disconnect func()
+}
+
+// NewCollectionSink returns a sink of the collection returned by connect, with the function
+// disconnecting its client, whose inserts are bounded by timeout.
+func NewCollectionSink(connect func(ctx context.Context) (*mongo.Collection, func(), error), timeout time.Duration) *CollectionSink {
+	return &CollectionSink{connect: connect, timeout: timeout}
+}
+
+func (s *CollectionSink) Write(record Record) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	if s.collection == nil {
+		collection, disconnect, err := s.connect(ctx)
+		if err != nil {
+			return fmt.Errorf("unable to connect to the audit collection: %w", err)
+		}
+		s.collection, s.disconnect = collection, disconnect
+	}
+	_, err := s.collection.InsertOne(ctx, record)
+	return err
+}
+
+func (s *CollectionSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.disconnect != nil {
+		s.disconnect()
+	}
+	return nil
+}
+
+// ReadFile returns the records of the audit file read from r that match, in the order they were
+// written.
+func ReadFile(r io.Reader, match func(Record) bool) ([]Record, error) {
+	var records []Record
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("invalid audit record on line %d: %w", line, err)
+		}
+		if match == nil || match(record) {
+			records = append(records, record)
+		}
+	}
+	return records, scanner.Err()
+}
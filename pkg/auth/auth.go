@@ -0,0 +1,203 @@
+// Package auth authenticates the requests to the listen address and authorizes them with roles
+// granting read or read-write access per database.
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/crypto/bcrypt"
+	"k8s.io/klog"
+)
+
+var requestsDenied = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "mongodb_client_http_requests_denied_total",
+	Help: "Number of requests to the listen address that were rejected, by reason: unauthenticated or forbidden.",
+}, []string{"reason"})
+
+func init() {
+	prometheus.MustRegister(requestsDenied)
+}
+
+// User authenticates with basic auth or a bearer token.
+type User struct {
+	Name string
+	// PasswordHash is the bcrypt hash of the basic auth password.
+	PasswordHash string
+	// TokenSHA256 is the hex encoded SHA-256 of the bearer token.
+	TokenSHA256 string
+	Roles       []string
+	// Tenant is the tenant the user acts for, the user picks one per request when empty.
+	Tenant string
+}
+
+// Role grants access to databases, "*" stands for every database.
+type Role struct {
+	Read      []string
+	ReadWrite []string
+	Metrics   bool
+	Debug     bool
+}
+
+// Identity is an authenticated caller.
+type Identity struct {
+	Name  string
+	Roles []string
+	// Tenant is the tenant the caller is bound to, empty when the caller is not bound to one.
+	Tenant string
+}
+
+// Permission is what a request needs to be allowed.
+type Permission struct {
+	// Database is accessed by the request, reading unless Write is set.
+	Database string
+	Write    bool
+	// Metrics is set for the requests to /metrics, Debug for those to the debug endpoints.
+	Metrics bool
+	Debug   bool
+}
+
+// Authenticator checks the credentials of requests against the configured users and OIDC provider.
+type Authenticator struct {
+	users  []User
+	oidc   *OIDCVerifier
+	roles  map[string]Role
+	byName map[string]*User
+}
+
+// NewAuthenticator returns an authenticator for users and, unless nil, the tokens accepted by oidc.
+func NewAuthenticator(users []User, oidc *OIDCVerifier, roles map[string]Role) (*Authenticator, error) {
+	a := &Authenticator{users: users, oidc: oidc, roles: roles, byName: map[string]*User{}}
+	for i, user := range users {
+		if len(user.Name) == 0 {
+			return nil, errors.New("every HTTP user needs a name")
+		}
+		if len(user.PasswordHash) == 0 && len(user.TokenSHA256) == 0 {
+			return nil, fmt.Errorf("HTTP user %s needs a passwordHash or a tokenSHA256", user.Name)
+		}
+		if _, err := hex.DecodeString(user.TokenSHA256); err != nil {
+			return nil, fmt.Errorf("HTTP user %s: tokenSHA256 must be hex encoded", user.Name)
+		}
+		for _, role := range user.Roles {
+			if _, ok := roles[role]; !ok {
+				return nil, fmt.Errorf("HTTP user %s has the undefined role %s", user.Name, role)
+			}
+		}
+		a.byName[user.Name] = &a.users[i]
+	}
+	return a, nil
+}
+
+type identityKey struct{}
+
+// NewContext returns a copy of ctx carrying the identity of the caller.
+func NewContext(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// FromContext returns the identity of the caller of the request of ctx, nil when authentication is
+// disabled.
+func FromContext(ctx context.Context) *Identity {
+	identity, _ := ctx.Value(identityKey{}).(*Identity)
+	return identity
+}
+
+// Authenticate returns the identity of the caller of r.
+func (a *Authenticator) Authenticate(r *http.Request) (*Identity, error) {
+	if username, password, ok := r.BasicAuth(); ok {
+		user, found := a.byName[username]
+		if !found || len(user.PasswordHash) == 0 || bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+			return nil, errors.New("invalid username or password")
+		}
+		return &Identity{Name: user.Name, Roles: user.Roles, Tenant: user.Tenant}, nil
+	}
+
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return nil, errors.New("missing credentials")
+	}
+	token := strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+	sum := sha256.Sum256([]byte(token))
+	for _, user := range a.users {
+		expected, _ := hex.DecodeString(user.TokenSHA256)
+		if len(expected) > 0 && subtle.ConstantTimeCompare(expected, sum[:]) == 1 {
+			return &Identity{Name: user.Name, Roles: user.Roles, Tenant: user.Tenant}, nil
+		}
+	}
+	// Static tokens are opaque, only tokens shaped like a JWT are worth verifying with the provider.
+	if a.oidc != nil && strings.Count(token, ".") == 2 {
+		return a.oidc.Verify(r.Context(), token)
+	}
+	return nil, errors.New("invalid bearer token")
+}
+
+// Authorize reports whether identity holds a role granting permission.
+func (a *Authenticator) Authorize(identity *Identity, permission Permission) bool {
+	for _, name := range identity.Roles {
+		role, ok := a.roles[name]
+		if !ok {
+			continue
+		}
+		if permission.Metrics || permission.Debug {
+			if (permission.Metrics && role.Metrics) || (permission.Debug && role.Debug) {
+				return true
+			}
+			continue
+		}
+		if matches(role.ReadWrite, permission.Database) || (!permission.Write && matches(role.Read, permission.Database)) {
+			return true
+		}
+	}
+	return false
+}
+
+func matches(databases []string, database string) bool {
+	for _, candidate := range databases {
+		if candidate == "*" || candidate == database {
+			return true
+		}
+	}
+	return false
+}
+
+// QueryToken wraps next so that the access_token query parameter of requests without credentials
+// is used as their bearer token, for clients that cannot set headers such as the WebSocket API of
+// browsers.
+func QueryToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token := r.URL.Query().Get("access_token"); len(token) > 0 && len(r.Header.Get("Authorization")) == 0 {
+			r = r.Clone(r.Context())
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Require wraps next so that it is only called by callers holding the permission returned by
+// permission for the request, or by any authenticated caller when permission is nil.
+func (a *Authenticator) Require(next http.Handler, permission func(*http.Request) Permission) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, err := a.Authenticate(r)
+		if err != nil {
+			requestsDenied.WithLabelValues("unauthenticated").Inc()
+			klog.V(4).Infof("Rejecting unauthenticated %s %s: %v", r.Method, r.URL.Path, err)
+			w.Header().Set("WWW-Authenticate", `Basic realm="mongodb-client", Bearer`)
+			http.Error(w, "authentication required", http.StatusUnauthorized)
+			return
+		}
+		if permission != nil && !a.Authorize(identity, permission(r)) {
+			requestsDenied.WithLabelValues("forbidden").Inc()
+			klog.V(2).Infof("Rejecting %s %s by %s: no role grants access", r.Method, r.URL.Path, identity.Name)
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), identity)))
+	})
+}
@@ -0,0 +1,336 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OIDCVerifier validates the ID tokens of an OpenID Connect provider: their signature with the keys
+// published by the provider, their issuer, audience and expiry.
+type OIDCVerifier struct {
+	issuer        string
+	audience      string
+	usernameClaim string
+	rolesClaim    string
+	tenantClaim   string
+	client        *http.Client
+
+	lock    sync.Mutex
+	jwksURL string
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+// NewOIDCVerifier returns a verifier for the tokens of issuer intended for audience. The tenant of
+// the identities is read from tenantClaim unless empty.
+func NewOIDCVerifier(issuer, audience, usernameClaim, rolesClaim, tenantClaim string) (*OIDCVerifier, error) {
+	if len(issuer) == 0 || len(audience) == 0 {
+		return nil, errors.New("OIDC requires an issuerURL and an audience")
+	}
+	if len(usernameClaim) == 0 {
+		usernameClaim = "sub"
+	}
+	if len(rolesClaim) == 0 {
+		rolesClaim = "roles"
+	}
+	return &OIDCVerifier{
+		issuer:        strings.TrimSuffix(issuer, "/"),
+		audience:      audience,
+		usernameClaim: usernameClaim,
+		rolesClaim:    rolesClaim,
+		tenantClaim:   tenantClaim,
+		client:        &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+// Verify returns the identity described by a valid token.
+func (v *OIDCVerifier) Verify(ctx context.Context, token string) (*Identity, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed token header: %w", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token signature: %w", err)
+	}
+	key, err := v.key(ctx, header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Algorithm, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed token claims: %w", err)
+	}
+	if issuer, _ := claims["iss"].(string); strings.TrimSuffix(issuer, "/") != v.issuer {
+		return nil, fmt.Errorf("token issued by %q instead of %q", issuer, v.issuer)
+	}
+	if !hasAudience(claims["aud"], v.audience) {
+		return nil, errors.New("token not intended for this audience")
+	}
+	now := float64(time.Now().Unix())
+	// A minute of leeway allows for clock skew between the provider and this process.
+	if exp, ok := claims["exp"].(float64); !ok || exp+60 < now {
+		return nil, errors.New("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && nbf-60 > now {
+		return nil, errors.New("token not valid yet")
+	}
+
+	name, _ := claims[v.usernameClaim].(string)
+	if len(name) == 0 {
+		return nil, fmt.Errorf("token without %s claim", v.usernameClaim)
+	}
+	identity := &Identity{Name: name}
+	switch roles := claims[v.rolesClaim].(type) {
+	case []interface{}:
+		for _, role := range roles {
+			if s, ok := role.(string); ok {
+				identity.Roles = append(identity.Roles, s)
+			}
+		}
+	case string:
+		identity.Roles = strings.Fields(roles)
+	}
+	if len(v.tenantClaim) > 0 {
+		identity.Tenant, _ = claims[v.tenantClaim].(string)
+	}
+	return identity, nil
+}
+
+func decodeSegment(segment string, out interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+func hasAudience(claim interface{}, audience string) bool {
+	switch aud := claim.(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, a := range aud {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func verifySignature(algorithm string, key crypto.PublicKey, signed, signature []byte) error {
+	var hash crypto.Hash
+	switch algorithm {
+	case "RS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "ES512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported token algorithm %q", algorithm)
+	}
+	var digest []byte
+	switch hash {
+	case crypto.SHA256:
+		sum := sha256.Sum256(signed)
+		digest = sum[:]
+	case crypto.SHA384:
+		sum := sha512.Sum384(signed)
+		digest = sum[:]
+	default:
+		sum := sha512.Sum512(signed)
+		digest = sum[:]
+	}
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(algorithm, "RS") {
+			return errors.New("token algorithm does not match the key")
+		}
+		if err := rsa.VerifyPKCS1v15(k, hash, digest, signature); err != nil {
+			return errors.New("invalid token signature")
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if !strings.HasPrefix(algorithm, "ES") || len(signature) != 2*size {
+			return errors.New("token algorithm does not match the key")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return errors.New("invalid token signature")
+		}
+		return nil
+	}
+	return errors.New("unsupported key type")
+}
+
+// key returns the signing key with id kid, fetching the keys of the provider again when it is
+// unknown, at most once a minute. The keys are fetched without the lock held, so that the tokens
+// signed with the known keys are verified while the provider is slow to answer.
+func (v *OIDCVerifier) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	v.lock.Lock()
+	key, ok := v.keys[kid]
+	jwksURL := v.jwksURL
+	fetch := !ok && time.Since(v.fetched) >= time.Minute
+	if fetch {
+		// Recorded before fetching so that the concurrent requests do not fetch the keys again.
+		v.fetched = time.Now()
+	}
+	v.lock.Unlock()
+	if ok {
+		return key, nil
+	}
+	if !fetch {
+		return nil, fmt.Errorf("unknown token signing key %q", kid)
+	}
+
+	keys, jwksURL, err := v.fetchKeys(ctx, jwksURL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch the keys of %s: %w", v.issuer, err)
+	}
+	v.lock.Lock()
+	v.keys, v.jwksURL = keys, jwksURL
+	v.lock.Unlock()
+
+	key, ok = keys[kid]
+	if !ok {
+		// Providers with a single key may omit the key id from their tokens.
+		if len(kid) == 0 && len(keys) == 1 {
+			for _, only := range keys {
+				return only, nil
+			}
+		}
+		return nil, fmt.Errorf("unknown token signing key %q", kid)
+	}
+	return key, nil
+}
+
+type jsonWebKey struct {
+	KeyID string `json:"kid"`
+	Type  string `json:"kty"`
+	Use   string `json:"use"`
+	N     string `json:"n"`
+	E     string `json:"e"`
+	Curve string `json:"crv"`
+	X     string `json:"x"`
+	Y     string `json:"y"`
+}
+
+func (v *OIDCVerifier) getJSON(ctx context.Context, url string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: unexpected status %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// fetchKeys returns the signing keys published at jwksURL, and jwksURL itself, read from the
+// discovery document of the issuer when it is empty.
+func (v *OIDCVerifier) fetchKeys(ctx context.Context, jwksURL string) (map[string]crypto.PublicKey, string, error) {
+	if len(jwksURL) == 0 {
+		var discovery struct {
+			JWKSURI string `json:"jwks_uri"`
+		}
+		if err := v.getJSON(ctx, v.issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+			return nil, "", err
+		}
+		if len(discovery.JWKSURI) == 0 {
+			return nil, "", errors.New("the discovery document has no jwks_uri")
+		}
+		jwksURL = discovery.JWKSURI
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := v.getJSON(ctx, jwksURL, &set); err != nil {
+		return nil, "", err
+	}
+	keys := map[string]crypto.PublicKey{}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.KeyID] = key
+	}
+	return keys, jwksURL, nil
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Type {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Curve)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Type)
+}
@@ -0,0 +1,239 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// provider is an OpenID Connect provider publishing an RSA and an EC signing key.
+type provider struct {
+	server *httptest.Server
+	rsaKey *rsa.PrivateKey
+	ecKey  *ecdsa.PrivateKey
+
+	lock sync.Mutex
+	// fetches counts the requests of the keys, blocked holds them while it is not nil.
+	fetches int
+	blocked chan struct{}
+}
+
+func newProvider(t *testing.T) *provider {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &provider{rsaKey: rsaKey, ecKey: ecKey}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"issuer": p.server.URL, "jwks_uri": p.server.URL + "/jwks"})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		p.lock.Lock()
+		p.fetches++
+		blocked := p.blocked
+		p.lock.Unlock()
+		if blocked != nil {
+			<-blocked
+		}
+		encode := base64.RawURLEncoding.EncodeToString
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []jsonWebKey{
+			{KeyID: "rsa", Type: "RSA", Use: "sig", N: encode(rsaKey.N.Bytes()), E: encode(big.NewInt(int64(rsaKey.E)).Bytes())},
+			{KeyID: "ec", Type: "EC", Use: "sig", Curve: "P-256", X: encode(ecKey.X.Bytes()), Y: encode(ecKey.Y.Bytes())},
+		}})
+	})
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+func (p *provider) fetchCount() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.fetches
+}
+
+// claims returns valid claims of the provider for the audience api, with the changes of overrides.
+func (p *provider) claims(overrides map[string]interface{}) map[string]interface{} {
+	claims := map[string]interface{}{
+		"iss":   p.server.URL,
+		"aud":   "api",
+		"sub":   "alice",
+		"roles": []string{"reader"},
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	}
+	for name, value := range overrides {
+		claims[name] = value
+	}
+	return claims
+}
+
+// sign returns the token of claims signed with the key kid of the provider and announced with
+// algorithm, which may not be the algorithm of the key.
+func (p *provider) sign(t *testing.T, algorithm, kid string, claims map[string]interface{}) string {
+	header, err := json.Marshal(jwtHeader{Algorithm: algorithm, KeyID: kid})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	var signature []byte
+	if kid == "ec" {
+		r, s, err := ecdsa.Sign(rand.Reader, p.ecKey, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	} else {
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, p.rsaKey, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestOIDCVerifierVerify(t *testing.T) {
+	p := newProvider(t)
+	tests := []struct {
+		name      string
+		algorithm string
+		kid       string
+		claims    map[string]interface{}
+		err       string
+	}{
+		{name: "RSA", algorithm: "RS256", kid: "rsa"},
+		{name: "EC", algorithm: "ES256", kid: "ec"},
+		{name: "algorithm of another key type", algorithm: "ES256", kid: "rsa", err: "does not match the key"},
+		{name: "unsigned", algorithm: "none", kid: "rsa", err: "unsupported token algorithm"},
+		{name: "symmetric algorithm", algorithm: "HS256", kid: "rsa", err: "unsupported token algorithm"},
+		{name: "expired", algorithm: "RS256", kid: "rsa", claims: map[string]interface{}{"exp": time.Now().Add(-2 * time.Minute).Unix()}, err: "token expired"},
+		{name: "without expiry", algorithm: "RS256", kid: "rsa", claims: map[string]interface{}{"exp": nil}, err: "token expired"},
+		{name: "not valid yet", algorithm: "RS256", kid: "rsa", claims: map[string]interface{}{"nbf": time.Now().Add(time.Hour).Unix()}, err: "not valid yet"},
+		{name: "other audience", algorithm: "RS256", kid: "rsa", claims: map[string]interface{}{"aud": "other"}, err: "audience"},
+		{name: "one of the audiences", algorithm: "RS256", kid: "rsa", claims: map[string]interface{}{"aud": []string{"other", "api"}}},
+		{name: "other issuer", algorithm: "RS256", kid: "rsa", claims: map[string]interface{}{"iss": "https://issuer.example.com"}, err: "issued by"},
+		{name: "unknown key", algorithm: "RS256", kid: "rotated", err: "unknown token signing key"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			v, err := NewOIDCVerifier(p.server.URL, "api", "", "", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			identity, err := v.Verify(context.Background(), p.sign(t, test.algorithm, test.kid, p.claims(test.claims)))
+			if len(test.err) > 0 {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected an error containing %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if identity.Name != "alice" || len(identity.Roles) != 1 || identity.Roles[0] != "reader" {
+				t.Errorf("unexpected identity %+v", identity)
+			}
+		})
+	}
+}
+
+func TestOIDCVerifierTamperedToken(t *testing.T) {
+	p := newProvider(t)
+	v, err := NewOIDCVerifier(p.server.URL, "api", "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(p.sign(t, "RS256", "rsa", p.claims(nil)), ".")
+	payload, err := json.Marshal(p.claims(map[string]interface{}{"roles": []string{"admin"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts[1] = base64.RawURLEncoding.EncodeToString(payload)
+	if _, err := v.Verify(context.Background(), strings.Join(parts, ".")); err == nil || !strings.Contains(err.Error(), "invalid token signature") {
+		t.Fatalf("expected the signature to be invalid, got %v", err)
+	}
+}
+
+func TestOIDCVerifierUnknownKeyFetchedOnceAMinute(t *testing.T) {
+	p := newProvider(t)
+	v, err := NewOIDCVerifier(p.server.URL, "api", "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := v.Verify(context.Background(), p.sign(t, "RS256", "rotated", p.claims(nil))); err == nil {
+			t.Fatal("expected the key to be unknown")
+		}
+	}
+	if fetches := p.fetchCount(); fetches != 1 {
+		t.Errorf("expected the keys to be fetched once, they were fetched %d times", fetches)
+	}
+}
+
+func TestOIDCVerifierKnownKeysDuringFetch(t *testing.T) {
+	p := newProvider(t)
+	v, err := NewOIDCVerifier(p.server.URL, "api", "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	known := p.sign(t, "RS256", "rsa", p.claims(nil))
+	if _, err := v.Verify(context.Background(), known); err != nil {
+		t.Fatal(err)
+	}
+
+	// A token signed with an unknown key fetches the keys again, from a provider that does not answer.
+	release := make(chan struct{})
+	p.lock.Lock()
+	p.blocked = release
+	p.lock.Unlock()
+	v.lock.Lock()
+	v.fetched = time.Time{}
+	v.lock.Unlock()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		v.Verify(context.Background(), p.sign(t, "RS256", "rotated", p.claims(nil)))
+	}()
+	for p.fetchCount() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	verified := make(chan error, 1)
+	go func() {
+		_, err := v.Verify(context.Background(), known)
+		verified <- err
+	}()
+	select {
+	case err := <-verified:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("the token signed with a known key waited for the keys to be fetched")
+	}
+	close(release)
+	<-done
+}
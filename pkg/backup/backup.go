@@ -0,0 +1,221 @@
+// Package backup writes scheduled archives of databases to a directory or object store and prunes
+// old ones according to a retention policy.
+package backup
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/archive"
+	"github.com/bradmwilliams/mongodb-client/pkg/objectstore"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.mongodb.org/mongo-driver/mongo"
+	"k8s.io/klog"
+)
+
+var (
+	backupLastSuccessTimestamp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "mongodb_client_backup_last_success_timestamp_seconds",
+		Help: "Unix time of the last archive successfully written by each backup.",
+	}, []string{"backup"})
+	backupSizeBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "mongodb_client_backup_size_bytes",
+		Help: "Size in bytes of the last archive written by each backup.",
+	}, []string{"backup"})
+	backupArchives = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "mongodb_client_backup_archives",
+		Help: "Number of archives kept by each backup after applying its retention policy.",
+	}, []string{"backup"})
+)
+
+func init() {
+	prometheus.MustRegister(backupLastSuccessTimestamp, backupSizeBytes, backupArchives)
+}
+
+// timestampFormat is part of every archive name, it sorts in chronological order.
+const timestampFormat = "20060102T150405Z"
+
+// Retention decides which archives are kept. An archive is kept if it is one of the KeepLast most
+// recent ones or younger than MaxAge. When neither is set every archive is kept.
+type Retention struct {
+	KeepLast int
+	MaxAge   time.Duration
+}
+
+// Backup dumps databases to archives in a directory or object store.
+type Backup struct {
+	// Name identifies the backup, it prefixes the archive names.
+	Name string
+	// Databases to dump, all databases except admin, config and local when empty.
+	Databases []string
+	// Bucket the archives are written to, below Prefix.
+	Bucket objectstore.Bucket
+	Prefix string
+	// Gzip compresses the archives.
+	Gzip      bool
+	Retention Retention
+	// Oplog includes the oplog entries written during each dump in its archive, which makes it
+	// a consistent starting point for the oplog slices written by OplogCapture.
+	Oplog bool
+}
+
+// Archive is a full archive written by a backup.
+type Archive struct {
+	Key     string
+	Created time.Time
+}
+
+func (b *Backup) extension() string {
+	if b.Gzip {
+		return ".archive.gz"
+	}
+	return ".archive"
+}
+
+// key returns the key of the archive with the given base name.
+func (b *Backup) key(name string) string {
+	if len(b.Prefix) == 0 {
+		return name
+	}
+	return path.Join(b.Prefix, name)
+}
+
+// Run writes a new archive and then removes the archives that fall out of the retention policy.
+func (b *Backup) Run(ctx context.Context, client *mongo.Client) error {
+	now := time.Now()
+	key := b.key(fmt.Sprintf("%s-%s%s", b.Name, now.UTC().Format(timestampFormat), b.extension()))
+	size, err := b.write(ctx, client, key)
+	if err != nil {
+		return err
+	}
+	klog.Infof("Backup %s wrote %s (%d bytes)", b.Name, key, size)
+	backupLastSuccessTimestamp.WithLabelValues(b.Name).SetToCurrentTime()
+	backupSizeBytes.WithLabelValues(b.Name).Set(float64(size))
+
+	return b.prune(ctx, now)
+}
+
+// write dumps to the archive, which only becomes visible once complete so that partial archives
+// are never mistaken for backups.
+func (b *Backup) write(ctx context.Context, client *mongo.Client, key string) (int64, error) {
+	out, err := b.Bucket.NewWriter(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	defer out.Abort()
+
+	counted := &countingWriter{w: out}
+	buffered := bufio.NewWriter(counted)
+	w := io.Writer(buffered)
+	var compressed *gzip.Writer
+	if b.Gzip {
+		compressed = gzip.NewWriter(buffered)
+		w = compressed
+	}
+	if _, err := archive.Dump(ctx, client, w, archive.DumpOptions{Databases: b.Databases, Oplog: b.Oplog}); err != nil {
+		return 0, fmt.Errorf("backup %s failed: %w", b.Name, err)
+	}
+	if compressed != nil {
+		if err := compressed.Close(); err != nil {
+			return 0, err
+		}
+	}
+	if err := buffered.Flush(); err != nil {
+		return 0, err
+	}
+	if err := out.Close(); err != nil {
+		return 0, err
+	}
+	return counted.n, nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Archives returns the full archives of this backup, most recent first.
+func (b *Backup) Archives(ctx context.Context) ([]Archive, error) {
+	prefix := b.key(b.Name + "-")
+	objects, err := b.Bucket.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	var archives []Archive
+	for _, object := range objects {
+		if !strings.HasPrefix(object.Key, prefix) || !strings.HasSuffix(object.Key, b.extension()) {
+			continue
+		}
+		created, err := time.Parse(timestampFormat, strings.TrimSuffix(strings.TrimPrefix(object.Key, prefix), b.extension()))
+		if err != nil {
+			// Oplog slices and unrelated objects.
+			continue
+		}
+		archives = append(archives, Archive{Key: object.Key, Created: created})
+	}
+	sort.Slice(archives, func(i, j int) bool { return archives[i].Created.After(archives[j].Created) })
+	return archives, nil
+}
+
+// prune removes the archives of this backup that are not kept by the retention policy, together
+// with the oplog slices that end before the oldest archive kept.
+func (b *Backup) prune(ctx context.Context, now time.Time) error {
+	archives, err := b.Archives(ctx)
+	if err != nil {
+		return err
+	}
+
+	kept := 0
+	var oldest time.Time
+	for i, candidate := range archives {
+		if b.Retention.keeps(i, now.Sub(candidate.Created)) {
+			kept++
+			oldest = candidate.Created
+			continue
+		}
+		if err := b.Bucket.Delete(ctx, candidate.Key); err != nil {
+			return fmt.Errorf("unable to remove expired archive: %w", err)
+		}
+		klog.V(2).Infof("Backup %s removed expired archive %s", b.Name, candidate.Key)
+	}
+	backupArchives.WithLabelValues(b.Name).Set(float64(kept))
+	if kept == 0 {
+		return nil
+	}
+
+	slices, err := b.OplogSlices(ctx)
+	if err != nil {
+		return err
+	}
+	for _, slice := range slices {
+		if int64(slice.End.T) >= oldest.Unix() {
+			continue
+		}
+		if err := b.Bucket.Delete(ctx, slice.Key); err != nil {
+			return fmt.Errorf("unable to remove expired oplog slice: %w", err)
+		}
+		klog.V(2).Infof("Backup %s removed expired oplog slice %s", b.Name, slice.Key)
+	}
+	return nil
+}
+
+// keeps reports whether the archive at position index, counted from the most recent one, is kept.
+func (r Retention) keeps(index int, age time.Duration) bool {
+	if r.KeepLast <= 0 && r.MaxAge <= 0 {
+		return true
+	}
+	return (r.KeepLast > 0 && index < r.KeepLast) || (r.MaxAge > 0 && age <= r.MaxAge)
+}
@@ -0,0 +1,197 @@
+package backup
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/archive"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"k8s.io/klog"
+)
+
+var backupOplogLastTimestamp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "mongodb_client_backup_oplog_last_timestamp_seconds",
+	Help: "Unix time of the most recent oplog entry archived by each backup.",
+}, []string{"backup"})
+
+func init() {
+	prometheus.MustRegister(backupOplogLastTimestamp)
+}
+
+// OplogSlice is an archive holding the oplog entries after Start up to and including End.
+type OplogSlice struct {
+	Key   string
+	Start primitive.Timestamp
+	End   primitive.Timestamp
+}
+
+func formatTimestamp(ts primitive.Timestamp) string {
+	return fmt.Sprintf("%010d.%010d", ts.T, ts.I)
+}
+
+func parseTimestamp(value string) (primitive.Timestamp, error) {
+	var ts primitive.Timestamp
+	_, err := fmt.Sscanf(value, "%d.%d", &ts.T, &ts.I)
+	return ts, err
+}
+
+// OplogSlices returns the oplog slices of this backup, oldest first.
+func (b *Backup) OplogSlices(ctx context.Context) ([]OplogSlice, error) {
+	prefix := b.key(b.Name + "-oplog-")
+	objects, err := b.Bucket.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	var slices []OplogSlice
+	for _, object := range objects {
+		if !strings.HasPrefix(object.Key, prefix) || !strings.HasSuffix(object.Key, b.extension()) {
+			continue
+		}
+		parts := strings.Split(strings.TrimSuffix(strings.TrimPrefix(object.Key, prefix), b.extension()), "-")
+		if len(parts) != 2 {
+			continue
+		}
+		start, err := parseTimestamp(parts[0])
+		if err != nil {
+			continue
+		}
+		end, err := parseTimestamp(parts[1])
+		if err != nil {
+			continue
+		}
+		slices = append(slices, OplogSlice{Key: object.Key, Start: start, End: end})
+	}
+	sort.Slice(slices, func(i, j int) bool { return timestampBefore(slices[i].Start, slices[j].Start) })
+	return slices, nil
+}
+
+func timestampBefore(a, b primitive.Timestamp) bool {
+	return a.T < b.T || (a.T == b.T && a.I < b.I)
+}
+
+// RunOplogCapture archives the oplog entries written since the previous slice, or since the most
+// recent full archive when there is no slice yet. Together with the full archives the slices allow
+// restoring any point in time since the oldest archive kept.
+func (b *Backup) RunOplogCapture(ctx context.Context, client *mongo.Client) error {
+	slices, err := b.OplogSlices(ctx)
+	if err != nil {
+		return err
+	}
+	archives, err := b.Archives(ctx)
+	if err != nil {
+		return err
+	}
+	if len(archives) == 0 {
+		klog.V(2).Infof("Backup %s has no full archive yet, not capturing the oplog", b.Name)
+		return nil
+	}
+	// Archives are named after the time their dump started, which precedes its first oplog entry.
+	from := primitive.Timestamp{T: uint32(archives[0].Created.Unix())}
+	if len(slices) > 0 && timestampBefore(from, slices[len(slices)-1].End) {
+		from = slices[len(slices)-1].End
+	}
+
+	to, err := archive.OplogTop(ctx, client)
+	if err != nil {
+		return err
+	}
+	if !timestampBefore(from, to) {
+		return nil
+	}
+	oldest, err := archive.OplogStart(ctx, client)
+	if err != nil {
+		return err
+	}
+	var gap error
+	if timestampBefore(from, oldest) {
+		// Capture what is left so that later slices continue, but report the missing entries.
+		gap = fmt.Errorf("backup %s: the oplog has rolled over since %s, entries up to %s are lost until the next full archive",
+			b.Name, time.Unix(int64(from.T), 0).UTC().Format(time.RFC3339), time.Unix(int64(oldest.T), 0).UTC().Format(time.RFC3339))
+		from = primitive.Timestamp{T: oldest.T, I: oldest.I - 1}
+		if oldest.I == 0 {
+			from = primitive.Timestamp{T: oldest.T - 1, I: ^uint32(0)}
+		}
+	}
+
+	key := b.key(fmt.Sprintf("%s-oplog-%s-%s%s", b.Name, formatTimestamp(from), formatTimestamp(to), b.extension()))
+	count, err := b.writeOplog(ctx, client, key, from, to)
+	if err != nil {
+		return err
+	}
+	klog.V(2).Infof("Backup %s archived %d oplog entries to %s", b.Name, count, key)
+	backupOplogLastTimestamp.WithLabelValues(b.Name).Set(float64(to.T))
+	return gap
+}
+
+func (b *Backup) writeOplog(ctx context.Context, client *mongo.Client, key string, from, to primitive.Timestamp) (int64, error) {
+	out, err := b.Bucket.NewWriter(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	defer out.Abort()
+
+	buffered := bufio.NewWriter(out)
+	w := io.Writer(buffered)
+	var compressed *gzip.Writer
+	if b.Gzip {
+		compressed = gzip.NewWriter(buffered)
+		w = compressed
+	}
+	count, _, err := archive.CaptureOplog(ctx, client, w, from, to)
+	if err != nil {
+		return 0, fmt.Errorf("backup %s oplog capture failed: %w", b.Name, err)
+	}
+	if compressed != nil {
+		if err := compressed.Close(); err != nil {
+			return 0, err
+		}
+	}
+	if err := buffered.Flush(); err != nil {
+		return 0, err
+	}
+	return count, out.Close()
+}
+
+// PointInTime returns the archive and the oplog slices to restore the state at until: the most
+// recent archive created before until and the slices that follow it. The most recent archive and
+// all of its slices are returned when until is zero.
+func (b *Backup) PointInTime(ctx context.Context, until primitive.Timestamp) (Archive, []OplogSlice, error) {
+	archives, err := b.Archives(ctx)
+	if err != nil {
+		return Archive{}, nil, err
+	}
+	var base *Archive
+	for i := range archives {
+		if until.IsZero() || archives[i].Created.Unix() <= int64(until.T) {
+			base = &archives[i]
+			break
+		}
+	}
+	if base == nil {
+		return Archive{}, nil, fmt.Errorf("backup %s has no archive created before the requested time", b.Name)
+	}
+
+	slices, err := b.OplogSlices(ctx)
+	if err != nil {
+		return Archive{}, nil, err
+	}
+	var selected []OplogSlice
+	for _, slice := range slices {
+		if int64(slice.End.T) < base.Created.Unix() {
+			continue
+		}
+		if !until.IsZero() && timestampBefore(until, slice.Start) {
+			break
+		}
+		selected = append(selected, slice)
+	}
+	return *base, selected, nil
+}
@@ -0,0 +1,341 @@
+// Package bench runs weighted workloads of reads and writes against a collection with concurrent
+// workers and measures their throughput, latency percentiles and errors.
+package bench
+
+import (
+	"context"
+	"fmt"
+	"math/bits"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/generate"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	operationsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mongodb_client_bench_operations_total",
+		Help: "Number of benchmark operations run, partitioned by operation and result.",
+	}, []string{"operation", "result"})
+	operationDurationSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "mongodb_client_bench_operation_duration_seconds",
+		Help:    "Latency of benchmark operations in seconds.",
+		Buckets: prometheus.ExponentialBuckets(0.0001, 2, 18),
+	}, []string{"operation"})
+)
+
+func init() {
+	prometheus.MustRegister(operationsTotal, operationDurationSeconds)
+}
+
+// Options change how the workload is run.
+type Options struct {
+	// Concurrency is the number of workers running operations, 8 when it is not set.
+	Concurrency int
+	// Duration bounds the run, which lasts until ctx is done when it is not set.
+	Duration time.Duration
+	// Rate limits the number of operations started per second across the workers, when it is set.
+	Rate float64
+	// Seed seeds the random sources of the workers, which pick the operations and the values of
+	// their templates.
+	Seed int64
+	// Report is called with the statistics of the run every ReportInterval, 10 seconds when it is not
+	// set.
+	Report         func(Report)
+	ReportInterval time.Duration
+}
+
+// Report are the statistics of the operations run so far.
+type Report struct {
+	Elapsed    time.Duration
+	Operations []Statistics
+}
+
+// Total returns the statistics of all the operations together.
+func (r Report) Total() Statistics {
+	total := Statistics{Name: "total", Elapsed: r.Elapsed}
+	latencies := &histogram{}
+	for _, op := range r.Operations {
+		total.Count += op.Count
+		total.Errors += op.Errors
+		total.Documents += op.Documents
+		latencies.add(op.latencies)
+	}
+	total.summarize(latencies)
+	return total
+}
+
+// Statistics are those of an operation.
+type Statistics struct {
+	Name string
+	// Count is the number of operations run, Errors those that failed.
+	Count  int64
+	Errors int64
+	// Documents is the number of documents read or written.
+	Documents int64
+	Elapsed   time.Duration
+	// Latencies of the successful operations.
+	P50 time.Duration
+	P90 time.Duration
+	P99 time.Duration
+	Max time.Duration
+
+	latencies *histogram
+}
+
+// Rate returns the number of operations run per second.
+func (s Statistics) Rate() float64 {
+	if s.Elapsed <= 0 {
+		return 0
+	}
+	return float64(s.Count) / s.Elapsed.Seconds()
+}
+
+// ErrorRate returns the fraction of the operations that failed.
+func (s Statistics) ErrorRate() float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	return float64(s.Errors) / float64(s.Count)
+}
+
+func (s *Statistics) summarize(h *histogram) {
+	s.P50, s.P90, s.P99, s.Max = h.quantile(0.5), h.quantile(0.9), h.quantile(0.99), h.max
+}
+
+// Run runs w against collection, resolving the references of its templates with resolve, until
+// opts.Duration elapses or ctx is done, and returns the final statistics.
+func Run(ctx context.Context, collection *mongo.Collection, w *Workload, resolve generate.Resolver, opts Options) (Report, error) {
+	if opts.Concurrency < 1 {
+		opts.Concurrency = 8
+	}
+	if opts.ReportInterval <= 0 {
+		opts.ReportInterval = 10 * time.Second
+	}
+	operations, err := w.compile(ctx, resolve)
+	if err != nil {
+		return Report{}, err
+	}
+	var totalWeight int
+	for _, op := range operations {
+		totalWeight += op.weight
+	}
+	stats := make([]*stats, len(operations))
+	for i, op := range operations {
+		stats[i] = newStats(op.name)
+	}
+
+	parent := ctx
+	if opts.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Duration)
+		defer cancel()
+	}
+	var ticks <-chan time.Time
+	if opts.Rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / opts.Rate))
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+
+	started := time.Now()
+	report := func() Report {
+		r := Report{Elapsed: time.Since(started)}
+		for _, s := range stats {
+			r.Operations = append(r.Operations, s.snapshot(r.Elapsed))
+		}
+		return r
+	}
+	done := make(chan struct{})
+	var reporting sync.WaitGroup
+	if opts.Report != nil {
+		reporting.Add(1)
+		go func() {
+			defer reporting.Done()
+			ticker := time.NewTicker(opts.ReportInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+					opts.Report(report())
+				}
+			}
+		}()
+	}
+
+	var workers sync.WaitGroup
+	for i := 0; i < opts.Concurrency; i++ {
+		workers.Add(1)
+		go func(r *rand.Rand) {
+			defer workers.Done()
+			for {
+				if ticks != nil {
+					select {
+					case <-ctx.Done():
+						return
+					case <-ticks:
+					}
+				}
+				if ctx.Err() != nil {
+					return
+				}
+				n := r.Intn(totalWeight)
+				i := 0
+				for n >= operations[i].weight {
+					n -= operations[i].weight
+					i++
+				}
+				start := time.Now()
+				documents, err := operations[i].run(ctx, r, collection)
+				latency := time.Since(start)
+				if err != nil && ctx.Err() != nil {
+					// Operations interrupted by the end of the run are not counted.
+					return
+				}
+				stats[i].record(latency, documents, err)
+			}
+		}(rand.New(rand.NewSource(opts.Seed + int64(i))))
+	}
+	workers.Wait()
+	close(done)
+	reporting.Wait()
+
+	final := report()
+	// Without a duration the run lasts until it is interrupted.
+	if err := parent.Err(); err != nil && opts.Duration > 0 {
+		return final, fmt.Errorf("the benchmark was interrupted: %w", err)
+	}
+	return final, nil
+}
+
+// stats accumulates the results of an operation.
+type stats struct {
+	name     string
+	success  prometheus.Counter
+	failure  prometheus.Counter
+	duration prometheus.Observer
+
+	lock      sync.Mutex
+	count     int64
+	errors    int64
+	documents int64
+	latencies histogram
+}
+
+func newStats(name string) *stats {
+	return &stats{
+		name:     name,
+		success:  operationsTotal.WithLabelValues(name, "success"),
+		failure:  operationsTotal.WithLabelValues(name, "error"),
+		duration: operationDurationSeconds.WithLabelValues(name),
+	}
+}
+
+func (s *stats) record(latency time.Duration, documents int64, err error) {
+	if err != nil {
+		s.failure.Inc()
+	} else {
+		s.success.Inc()
+		s.duration.Observe(latency.Seconds())
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.count++
+	if err != nil {
+		s.errors++
+		return
+	}
+	s.documents += documents
+	s.latencies.record(latency)
+}
+
+func (s *stats) snapshot(elapsed time.Duration) Statistics {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	latencies := s.latencies
+	statistics := Statistics{Name: s.name, Count: s.count, Errors: s.errors, Documents: s.documents, Elapsed: elapsed, latencies: &latencies}
+	statistics.summarize(&latencies)
+	return statistics
+}
+
+// subBuckets is the number of buckets per power of two of a histogram, the precision of its
+// quantiles is 1/subBuckets.
+const subBuckets = 16
+
+// histogram counts latencies in microseconds in buckets whose width doubles every subBuckets
+// buckets, so that it takes constant memory whatever the duration of the run.
+type histogram struct {
+	counts [(64 - 4 + 1) * subBuckets]int64
+	total  int64
+	max    time.Duration
+}
+
+func bucket(us uint64) int {
+	exp := bits.Len64(us)
+	if exp <= 4 {
+		return int(us)
+	}
+	return (exp-4)*subBuckets + int(us>>uint(exp-5))&(subBuckets-1)
+}
+
+// upper returns the largest value of bucket i.
+func upper(i int) uint64 {
+	if i < subBuckets {
+		return uint64(i)
+	}
+	exp := i/subBuckets + 4
+	return (uint64(subBuckets+i%subBuckets+1) << uint(exp-5)) - 1
+}
+
+func (h *histogram) record(latency time.Duration) {
+	us := latency.Microseconds()
+	if us < 0 {
+		us = 0
+	}
+	h.counts[bucket(uint64(us))]++
+	h.total++
+	if latency > h.max {
+		h.max = latency
+	}
+}
+
+func (h *histogram) add(other *histogram) {
+	if other == nil {
+		return
+	}
+	for i, count := range other.counts {
+		h.counts[i] += count
+	}
+	h.total += other.total
+	if other.max > h.max {
+		h.max = other.max
+	}
+}
+
+// quantile returns the latency under which the fraction q of the latencies are.
+func (h *histogram) quantile(q float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	rank := int64(q*float64(h.total) + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+	var seen int64
+	for i, count := range h.counts {
+		seen += count
+		if seen >= rank {
+			latency := time.Duration(upper(i)) * time.Microsecond
+			if latency > h.max {
+				return h.max
+			}
+			return latency
+		}
+	}
+	return h.max
+}
@@ -0,0 +1,259 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/client"
+	"github.com/bradmwilliams/mongodb-client/pkg/generate"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"sigs.k8s.io/yaml"
+)
+
+// Workload is the file of the operations run against a collection, such as:
+//
+//	operations:
+//	- name: episodes-of-podcast
+//	  weight: 8
+//	  find:
+//	    filter: {podcast: {$ref: podcasts}}
+//	    limit: 20
+//	- name: new-episode
+//	  weight: 2
+//	  insert: {podcast: {$ref: podcasts}, title: {$fake: sentence}}
+//
+// The documents are templates of the generate package, so that every operation runs with different
+// values.
+type Workload struct {
+	Operations []Operation `json:"operations"`
+}
+
+// Operation is an operation of a workload, exactly one of Find, Insert, Update, Delete or Aggregate
+// is set.
+type Operation struct {
+	Name string `json:"name"`
+	// Weight is the share of the operation among those of the workload, 1 when it is not set.
+	Weight int `json:"weight,omitempty"`
+
+	Find *Find `json:"find,omitempty"`
+	// Insert is the template of the inserted documents.
+	Insert json.RawMessage `json:"insert,omitempty"`
+	// Update updates the first document matching its filter.
+	Update *Update `json:"update,omitempty"`
+	// Delete deletes the first document matching its filter.
+	Delete *Delete `json:"delete,omitempty"`
+	// Aggregate is the pipeline of an aggregation, all the results of which are read.
+	Aggregate json.RawMessage `json:"aggregate,omitempty"`
+}
+
+// Find reads all the documents matching Filter.
+type Find struct {
+	Filter     json.RawMessage `json:"filter,omitempty"`
+	Sort       json.RawMessage `json:"sort,omitempty"`
+	Projection json.RawMessage `json:"projection,omitempty"`
+	Limit      int64           `json:"limit,omitempty"`
+}
+
+// Update is an update document, or pipeline, applied to the first document matching Filter.
+type Update struct {
+	Filter json.RawMessage `json:"filter,omitempty"`
+	Update json.RawMessage `json:"update"`
+}
+
+// Delete removes the first document matching Filter.
+type Delete struct {
+	Filter json.RawMessage `json:"filter,omitempty"`
+}
+
+// LoadWorkload reads the workload of a YAML or JSON file. The keys of the documents of a YAML file
+// are sorted by name, which matters for sort specifications, while those of a JSON file keep their
+// order.
+func LoadWorkload(path string) (*Workload, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read workload: %w", err)
+	}
+	workload := &Workload{}
+	if filepath.Ext(path) == ".json" {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(workload)
+	} else {
+		err = yaml.UnmarshalStrict(data, workload)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse workload %s: %w", path, err)
+	}
+	return workload, nil
+}
+
+// operation is a compiled operation, run returns the number of documents it read or wrote.
+type operation struct {
+	name   string
+	weight int
+	run    func(ctx context.Context, r *rand.Rand, coll *mongo.Collection) (int64, error)
+}
+
+// compile returns the operations of w, resolving the references of their templates with resolve.
+func (w *Workload) compile(ctx context.Context, resolve generate.Resolver) ([]operation, error) {
+	if len(w.Operations) == 0 {
+		return nil, fmt.Errorf("the workload has no operations")
+	}
+	names := map[string]bool{}
+	var operations []operation
+	for i, op := range w.Operations {
+		if len(op.Name) == 0 {
+			return nil, fmt.Errorf("operation %d has no name", i+1)
+		}
+		if names[op.Name] {
+			return nil, fmt.Errorf("operation %s is defined twice", op.Name)
+		}
+		names[op.Name] = true
+		if op.Weight < 0 {
+			return nil, fmt.Errorf("operation %s: weight must not be negative", op.Name)
+		}
+		weight := op.Weight
+		if weight == 0 {
+			weight = 1
+		}
+		run, err := op.compile(ctx, resolve)
+		if err != nil {
+			return nil, fmt.Errorf("operation %s: %w", op.Name, err)
+		}
+		operations = append(operations, operation{name: op.Name, weight: weight, run: run})
+	}
+	return operations, nil
+}
+
+func (op Operation) compile(ctx context.Context, resolve generate.Resolver) (func(ctx context.Context, r *rand.Rand, coll *mongo.Collection) (int64, error), error) {
+	set := 0
+	for _, present := range []bool{op.Find != nil, len(op.Insert) > 0, op.Update != nil, op.Delete != nil, len(op.Aggregate) > 0} {
+		if present {
+			set++
+		}
+	}
+	if set != 1 {
+		return nil, fmt.Errorf("exactly one of find, insert, update, delete or aggregate must be set")
+	}
+
+	switch {
+	case op.Find != nil:
+		filter, err := template(ctx, "filter", op.Find.Filter, resolve)
+		if err != nil {
+			return nil, err
+		}
+		sort, err := template(ctx, "sort", op.Find.Sort, resolve)
+		if err != nil {
+			return nil, err
+		}
+		projection, err := template(ctx, "projection", op.Find.Projection, resolve)
+		if err != nil {
+			return nil, err
+		}
+		return func(ctx context.Context, r *rand.Rand, coll *mongo.Collection) (int64, error) {
+			findOptions := options.Find().SetLimit(op.Find.Limit)
+			if s := sort.Document(r, 0); len(s) > 0 {
+				findOptions.SetSort(s)
+			}
+			if p := projection.Document(r, 0); len(p) > 0 {
+				findOptions.SetProjection(p)
+			}
+			cursor, err := coll.Find(ctx, filter.Document(r, 0), findOptions)
+			if err != nil {
+				return 0, err
+			}
+			return count(ctx, cursor)
+		}, nil
+	case len(op.Insert) > 0:
+		document, err := template(ctx, "insert", op.Insert, resolve)
+		if err != nil {
+			return nil, err
+		}
+		return func(ctx context.Context, r *rand.Rand, coll *mongo.Collection) (int64, error) {
+			if _, err := coll.InsertOne(ctx, document.Document(r, 0)); err != nil {
+				return 0, err
+			}
+			return 1, nil
+		}, nil
+	case op.Update != nil:
+		filter, err := template(ctx, "filter", op.Update.Filter, resolve)
+		if err != nil {
+			return nil, err
+		}
+		if len(op.Update.Update) == 0 {
+			return nil, fmt.Errorf("update has no update")
+		}
+		// The update may be a pipeline, which is compiled as the value of a wrapping document.
+		update, err := template(ctx, "update", wrap(op.Update.Update), resolve)
+		if err != nil {
+			return nil, err
+		}
+		return func(ctx context.Context, r *rand.Rand, coll *mongo.Collection) (int64, error) {
+			result, err := coll.UpdateOne(ctx, filter.Document(r, 0), update.Document(r, 0)[0].Value)
+			if err != nil {
+				return 0, err
+			}
+			return result.ModifiedCount, nil
+		}, nil
+	case op.Delete != nil:
+		filter, err := template(ctx, "filter", op.Delete.Filter, resolve)
+		if err != nil {
+			return nil, err
+		}
+		return func(ctx context.Context, r *rand.Rand, coll *mongo.Collection) (int64, error) {
+			result, err := coll.DeleteOne(ctx, filter.Document(r, 0))
+			if err != nil {
+				return 0, err
+			}
+			return result.DeletedCount, nil
+		}, nil
+	}
+	pipeline, err := template(ctx, "aggregate", wrap(op.Aggregate), resolve)
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context, r *rand.Rand, coll *mongo.Collection) (int64, error) {
+		cursor, err := coll.Aggregate(ctx, pipeline.Document(r, 0)[0].Value)
+		if err != nil {
+			return 0, err
+		}
+		return count(ctx, cursor)
+	}, nil
+}
+
+// wrap returns data as the value of a document, {"v": <data>}.
+func wrap(data json.RawMessage) json.RawMessage {
+	return json.RawMessage(`{"v":` + string(data) + `}`)
+}
+
+// template compiles an Extended JSON document, an empty one when data is empty.
+func template(ctx context.Context, field string, data json.RawMessage, resolve generate.Resolver) (*generate.Template, error) {
+	doc := bson.D{}
+	if len(data) > 0 {
+		if err := bson.UnmarshalExtJSON(data, false, &doc); err != nil {
+			return nil, fmt.Errorf("%s is not an Extended JSON document: %w", field, err)
+		}
+	}
+	t, err := generate.Compile(ctx, doc, resolve)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", field, err)
+	}
+	return t, nil
+}
+
+// count reads all the documents of cursor and returns their number.
+func count(ctx context.Context, cursor *mongo.Cursor) (int64, error) {
+	var n int64
+	err := client.Each(ctx, cursor, func(bson.Raw) error {
+		n++
+		return nil
+	})
+	return n, err
+}
@@ -0,0 +1,213 @@
+// Package bulk writes mixed inserts, updates, replacements and deletes to a collection in batches,
+// reporting the writes that failed by their position.
+package bulk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Options change how writes are sent.
+type Options struct {
+	// Ordered stops at the first write that fails. Otherwise the other writes of its batch are still
+	// applied, and so are the following batches.
+	Ordered bool
+	// BatchSize is the maximum number of writes sent per request, 1000 when it is not set.
+	BatchSize int
+	// Timeout bounds every request, retries included, when it is set.
+	Timeout time.Duration
+	// Retry runs every request when it is set, such as the Retry of a connection manager. Only
+	// idempotent writes should be retried: the driver generates a new _id for every attempt of an
+	// insert of a document without one.
+	Retry func(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// Result counts the applied writes.
+type Result struct {
+	Inserted int64
+	Matched  int64
+	Modified int64
+	Upserted int64
+	Deleted  int64
+	// Failures are the writes that failed.
+	Failures []Failure
+}
+
+func (r *Result) count(result *mongo.BulkWriteResult) {
+	r.Inserted += result.InsertedCount
+	r.Matched += result.MatchedCount
+	r.Modified += result.ModifiedCount
+	r.Upserted += result.UpsertedCount
+	r.Deleted += result.DeletedCount
+}
+
+// Add adds the counts and failures of other, such as the result of a concurrent Write.
+func (r *Result) Add(other Result) {
+	r.Inserted += other.Inserted
+	r.Matched += other.Matched
+	r.Modified += other.Modified
+	r.Upserted += other.Upserted
+	r.Deleted += other.Deleted
+	r.Failures = append(r.Failures, other.Failures...)
+}
+
+func (r Result) String() string {
+	return fmt.Sprintf("inserted: %d, matched: %d, modified: %d, upserted: %d, deleted: %d, failed: %d",
+		r.Inserted, r.Matched, r.Modified, r.Upserted, r.Deleted, len(r.Failures))
+}
+
+// Failure is a write rejected by the server.
+type Failure struct {
+	// Index is the position of the write among all the writes of the Writer, from 0.
+	Index   int
+	Code    int
+	Message string
+}
+
+// Error is returned for a batch of which some writes failed.
+type Error struct {
+	Failures []Failure
+}
+
+// Error summarizes the failures by code rather than listing every write.
+func (e *Error) Error() string {
+	counts := map[int]int{}
+	examples := map[int]string{}
+	var codes []int
+	for _, failure := range e.Failures {
+		if _, ok := counts[failure.Code]; !ok {
+			codes = append(codes, failure.Code)
+			examples[failure.Code] = failure.Message
+		}
+		counts[failure.Code]++
+	}
+	var parts []string
+	for _, code := range codes {
+		parts = append(parts, fmt.Sprintf("%d x code %d (e.g. %s)", counts[code], code, examples[code]))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Only reports whether every failure has one of codes, such as duplicate keys that can be ignored.
+func (e *Error) Only(codes ...int) bool {
+	for _, failure := range e.Failures {
+		found := false
+		for _, code := range codes {
+			found = found || failure.Code == code
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Writer buffers writes to a collection and sends them in batches. It is not safe for concurrent use.
+type Writer struct {
+	collection *mongo.Collection
+	options    Options
+	pending    []mongo.WriteModel
+	// sent is the number of writes sent before the pending ones.
+	sent   int
+	result Result
+}
+
+// NewWriter returns a Writer of the documents of collection.
+func NewWriter(collection *mongo.Collection, opts Options) *Writer {
+	if opts.BatchSize < 1 {
+		opts.BatchSize = 1000
+	}
+	return &Writer{collection: collection, options: opts}
+}
+
+// Add buffers writes, sending a batch whenever BatchSize writes are pending. It returns the error of
+// a batch that failed, an *Error when only some of its writes did.
+func (w *Writer) Add(ctx context.Context, models ...mongo.WriteModel) error {
+	for _, model := range models {
+		w.pending = append(w.pending, model)
+		if len(w.pending) >= w.options.BatchSize {
+			if err := w.Flush(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Flush sends the pending writes, see Add.
+func (w *Writer) Flush(ctx context.Context) error {
+	if len(w.pending) == 0 {
+		return nil
+	}
+	batch := w.pending
+	offset := w.sent
+	w.pending = nil
+	w.sent += len(batch)
+
+	if w.options.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, w.options.Timeout)
+		defer cancel()
+	}
+	var result *mongo.BulkWriteResult
+	write := func(ctx context.Context) error {
+		var err error
+		result, err = w.collection.BulkWrite(ctx, batch, options.BulkWrite().SetOrdered(w.options.Ordered))
+		return err
+	}
+	var err error
+	if w.options.Retry != nil {
+		err = w.options.Retry(ctx, write)
+	} else {
+		err = write(ctx)
+	}
+	if result != nil {
+		w.result.count(result)
+	}
+	var bulk mongo.BulkWriteException
+	if errors.As(err, &bulk) && bulk.WriteConcernError == nil && len(bulk.WriteErrors) > 0 {
+		failed := &Error{}
+		for _, writeErr := range bulk.WriteErrors {
+			failed.Failures = append(failed.Failures, Failure{Index: offset + writeErr.Index, Code: writeErr.Code, Message: writeErr.Message})
+		}
+		w.result.Failures = append(w.result.Failures, failed.Failures...)
+		return failed
+	}
+	return err
+}
+
+// Result returns the counts of the writes sent so far.
+func (w *Writer) Result() Result {
+	return w.result
+}
+
+// Write sends models in batches and returns the counts of the applied writes. Unless opts.Ordered is
+// set every batch is sent even when writes of previous ones failed; the returned error is then an
+// *Error holding all the failures.
+func Write(ctx context.Context, collection *mongo.Collection, models []mongo.WriteModel, opts Options) (Result, error) {
+	w := NewWriter(collection, opts)
+	for _, model := range models {
+		if err := w.Add(ctx, model); !w.continues(err) {
+			return w.Result(), err
+		}
+	}
+	if err := w.Flush(ctx); !w.continues(err) {
+		return w.Result(), err
+	}
+	if failures := w.Result().Failures; len(failures) > 0 {
+		return w.Result(), &Error{Failures: failures}
+	}
+	return w.Result(), nil
+}
+
+// continues reports whether writing goes on after a batch returned err.
+func (w *Writer) continues(err error) bool {
+	var failed *Error
+	return err == nil || (errors.As(err, &failed) && !w.options.Ordered)
+}
@@ -0,0 +1,264 @@
+// Package changestream consumes change streams durably: the resume token of the last handled event
+// is persisted so that a restarted consumer continues where it stopped.
+package changestream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/client"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"k8s.io/klog"
+)
+
+var (
+	eventsProcessed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mongodb_client_change_stream_events_processed_total",
+		Help: "Number of change events handled by each consumer.",
+	}, []string{"consumer"})
+	eventErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mongodb_client_change_stream_errors_total",
+		Help: "Number of times each consumer failed to handle an event or lost its stream.",
+	}, []string{"consumer"})
+	lagSeconds = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "mongodb_client_change_stream_lag_seconds",
+		Help: "Seconds between the cluster time of the last event handled by each consumer and the time it was handled.",
+	}, []string{"consumer"})
+	lastEventTimestamp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "mongodb_client_change_stream_last_event_timestamp_seconds",
+		Help: "Cluster time of the last event handled by each consumer.",
+	}, []string{"consumer"})
+)
+
+func init() {
+	prometheus.MustRegister(eventsProcessed, eventErrors, lagSeconds, lastEventTimestamp)
+}
+
+// ErrInvalidated is returned when the watched collection or database was dropped or renamed, the
+// stream cannot be resumed past that point.
+var ErrInvalidated = errors.New("the change stream was invalidated")
+
+// DefaultCheckpointInterval is how often the resume token is saved while no events arrive.
+const DefaultCheckpointInterval = 10 * time.Second
+
+// Watcher opens change streams, it is implemented by *mongo.Client, *mongo.Database and
+// *mongo.Collection.
+type Watcher interface {
+	Watch(ctx context.Context, pipeline interface{}, opts ...*options.ChangeStreamOptions) (*mongo.ChangeStream, error)
+}
+
+// Handler handles a single change event. The event is only acknowledged, and never delivered again,
+// once the handler returns nil.
+type Handler func(ctx context.Context, event bson.Raw) error
+
+// BatchHandler handles consecutive change events at once, they are acknowledged together once the
+// handler returns nil.
+type BatchHandler func(ctx context.Context, events []bson.Raw) error
+
+// Consumer delivers the events of a change stream to a handler at least once, resuming from the
+// last acknowledged event after failures and restarts.
+type Consumer struct {
+	// Name identifies the consumer in the token store and metrics.
+	Name    string
+	Watcher Watcher
+	// Pipeline filters or transforms the events.
+	Pipeline []bson.D
+	// Options of the change stream, the resume token is set by the consumer.
+	Options *options.ChangeStreamOptions
+	// Store persists the resume tokens, without a store they are only kept in memory to resume after
+	// transient failures.
+	Store TokenStore
+	// BatchSize is the maximum number of events passed to a BatchHandler. Events that have already
+	// been received are grouped, the consumer does not wait for a batch to fill up.
+	BatchSize int
+	// CheckpointInterval is how often the token is saved while no events arrive, so that a filtered
+	// stream does not fall behind the oplog. DefaultCheckpointInterval when zero.
+	CheckpointInterval time.Duration
+	// Retry controls how the stream is reopened after transient failures.
+	Retry client.RetryPolicy
+}
+
+type event struct {
+	OperationType string              `bson:"operationType"`
+	ClusterTime   primitive.Timestamp `bson:"clusterTime"`
+}
+
+// Run consumes the stream until ctx is cancelled, the handler fails or the stream cannot be resumed.
+// Transient failures reopen the stream from the last saved token.
+func (c *Consumer) Run(ctx context.Context, handler Handler) error {
+	return c.RunBatches(ctx, func(ctx context.Context, events []bson.Raw) error {
+		for _, event := range events {
+			if err := handler(ctx, event); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// RunBatches is like Run but hands up to BatchSize events at a time to handler.
+func (c *Consumer) RunBatches(ctx context.Context, handler BatchHandler) error {
+	if c.Store == nil {
+		c.Store = NewMemoryStore()
+	}
+	if c.BatchSize < 1 {
+		c.BatchSize = 1
+	}
+	if c.CheckpointInterval <= 0 {
+		c.CheckpointInterval = DefaultCheckpointInterval
+	}
+	if c.Retry.Attempts < 1 {
+		c.Retry = client.DefaultRetryPolicy
+	}
+	failures := 0
+	for {
+		progressed, err := c.consume(ctx, handler)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if progressed {
+			failures = 0
+		}
+		var handlerErr *handlerError
+		if errors.As(err, &handlerErr) {
+			return handlerErr.err
+		}
+		if errors.Is(err, ErrInvalidated) || !client.IsRetryable(err) {
+			return err
+		}
+		eventErrors.WithLabelValues(c.Name).Inc()
+		failures++
+		if failures >= c.Retry.Attempts {
+			return fmt.Errorf("giving up after %d attempts: %w", failures, err)
+		}
+		delay := c.Retry.Backoff << uint(failures-1)
+		if c.Retry.MaxBackoff > 0 && (delay > c.Retry.MaxBackoff || delay <= 0) {
+			delay = c.Retry.MaxBackoff
+		}
+		klog.Warningf("Change stream of consumer %s failed, resuming in %s: %v", c.Name, delay, err)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+	}
+}
+
+// handlerError wraps the errors of the handler, which are not retried by Run.
+type handlerError struct {
+	err error
+}
+
+func (e *handlerError) Error() string {
+	return e.err.Error()
+}
+
+func (e *handlerError) Unwrap() error {
+	return e.err
+}
+
+// consume opens the stream from the saved token and handles events until an error occurs. It
+// reports whether any event was handled.
+func (c *Consumer) consume(ctx context.Context, handler BatchHandler) (bool, error) {
+	token, err := c.Store.Load(ctx, c.Name)
+	if err != nil {
+		return false, fmt.Errorf("unable to load the resume token of %s: %w", c.Name, err)
+	}
+	streamOptions := options.MergeChangeStreamOptions(c.Options)
+	if token != nil {
+		klog.V(2).Infof("Resuming change stream consumer %s", c.Name)
+		streamOptions.SetResumeAfter(token)
+	}
+	pipeline := c.Pipeline
+	if pipeline == nil {
+		pipeline = []bson.D{}
+	}
+	stream, err := c.Watcher.Watch(ctx, pipeline, streamOptions)
+	if err != nil {
+		return false, fmt.Errorf("unable to open the change stream: %w", err)
+	}
+	defer stream.Close(context.Background())
+
+	progressed := false
+	saved := time.Now()
+	var batch []bson.Raw
+	var batchTime primitive.Timestamp
+	for {
+		received := stream.TryNext(ctx)
+		if !received && stream.Err() == nil && ctx.Err() == nil && len(batch) == 0 {
+			// No event arrived so the consumer is caught up, the post batch token moves on nonetheless.
+			lagSeconds.WithLabelValues(c.Name).Set(0)
+			if time.Since(saved) >= c.CheckpointInterval {
+				if err := c.save(ctx, token, stream.ResumeToken()); err != nil {
+					return progressed, err
+				}
+				token, saved = stream.ResumeToken(), time.Now()
+			}
+			continue
+		}
+
+		var invalidated bool
+		if received {
+			var e event
+			if err := bson.Unmarshal(stream.Current, &e); err != nil {
+				return progressed, &handlerError{err: fmt.Errorf("invalid change event: %w", err)}
+			}
+			if e.OperationType == "invalidate" {
+				invalidated = true
+			} else {
+				batch = append(batch, append(bson.Raw(nil), stream.Current...))
+				batchTime = e.ClusterTime
+				if len(batch) < c.BatchSize {
+					continue
+				}
+			}
+		}
+
+		// The batch is handled once it is full, no further event arrived or the stream ended.
+		if len(batch) > 0 {
+			if err := handler(ctx, batch); err != nil {
+				eventErrors.WithLabelValues(c.Name).Inc()
+				return progressed, &handlerError{err: err}
+			}
+			progressed = true
+			eventsProcessed.WithLabelValues(c.Name).Add(float64(len(batch)))
+			if !batchTime.IsZero() {
+				lastEventTimestamp.WithLabelValues(c.Name).Set(float64(batchTime.T))
+				lagSeconds.WithLabelValues(c.Name).Set(time.Since(time.Unix(int64(batchTime.T), 0)).Seconds())
+			}
+			batch = batch[:0]
+			if !invalidated {
+				if err := c.save(ctx, nil, stream.ResumeToken()); err != nil {
+					return progressed, err
+				}
+				token, saved = stream.ResumeToken(), time.Now()
+			}
+		}
+		if invalidated {
+			return progressed, ErrInvalidated
+		}
+		if err := stream.Err(); err != nil {
+			return progressed, err
+		}
+		if err := ctx.Err(); err != nil {
+			return progressed, err
+		}
+	}
+}
+
+// save stores token unless it equals previous.
+func (c *Consumer) save(ctx context.Context, previous, token bson.Raw) error {
+	if token == nil || (previous != nil && string(previous) == string(token)) {
+		return nil
+	}
+	if err := c.Store.Save(ctx, c.Name, token); err != nil {
+		return fmt.Errorf("unable to save the resume token of %s: %w", c.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package changestream
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TokenStore persists the resume token of each consumer.
+type TokenStore interface {
+	// Load returns the saved token of the consumer, nil when there is none.
+	Load(ctx context.Context, name string) (bson.Raw, error)
+	Save(ctx context.Context, name string, token bson.Raw) error
+}
+
+// memoryStore keeps the tokens of the consumers of a single process.
+type memoryStore struct {
+	tokens map[string]bson.Raw
+}
+
+// NewMemoryStore returns a TokenStore that keeps the tokens in memory only.
+func NewMemoryStore() TokenStore {
+	return &memoryStore{}
+}
+
+func (s *memoryStore) Load(ctx context.Context, name string) (bson.Raw, error) {
+	return s.tokens[name], nil
+}
+
+func (s *memoryStore) Save(ctx context.Context, name string, token bson.Raw) error {
+	if s.tokens == nil {
+		s.tokens = map[string]bson.Raw{}
+	}
+	s.tokens[name] = token
+	return nil
+}
+
+// collectionStore keeps one document per consumer in a collection.
+type collectionStore struct {
+	collection *mongo.Collection
+}
+
+type tokenDocument struct {
+	Name      string    `bson:"_id"`
+	Token     bson.Raw  `bson:"token"`
+	UpdatedAt time.Time `bson:"updatedAt"`
+}
+
+// NewCollectionStore returns a TokenStore saving the tokens in collection, keyed by consumer name.
+func NewCollectionStore(collection *mongo.Collection) TokenStore {
+	return &collectionStore{collection: collection}
+}
+
+func (s *collectionStore) Load(ctx context.Context, name string) (bson.Raw, error) {
+	var doc tokenDocument
+	err := s.collection.FindOne(ctx, bson.M{"_id": name}).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return doc.Token, nil
+}
+
+func (s *collectionStore) Save(ctx context.Context, name string, token bson.Raw) error {
+	_, err := s.collection.ReplaceOne(ctx,
+		bson.M{"_id": name},
+		tokenDocument{Name: name, Token: token, UpdatedAt: time.Now().UTC()},
+		options.Replace().SetUpsert(true),
+	)
+	return err
+}
+
+// fileStore keeps the tokens of all consumers in a JSON file, replaced atomically on every save.
+type fileStore struct {
+	path string
+}
+
+// NewFileStore returns a TokenStore saving the tokens as canonical Extended JSON in the file at path.
+func NewFileStore(path string) TokenStore {
+	return &fileStore{path: path}
+}
+
+func (s *fileStore) read() (map[string]json.RawMessage, error) {
+	tokens := map[string]json.RawMessage{}
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return tokens, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, fmt.Errorf("invalid resume token file %s: %w", s.path, err)
+	}
+	return tokens, nil
+}
+
+func (s *fileStore) Load(ctx context.Context, name string) (bson.Raw, error) {
+	tokens, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	value, ok := tokens[name]
+	if !ok {
+		return nil, nil
+	}
+	var token bson.Raw
+	if err := bson.UnmarshalExtJSON(value, true, &token); err != nil {
+		return nil, fmt.Errorf("invalid resume token for %s in %s: %w", name, s.path, err)
+	}
+	return token, nil
+}
+
+func (s *fileStore) Save(ctx context.Context, name string, token bson.Raw) error {
+	tokens, err := s.read()
+	if err != nil {
+		return err
+	}
+	value, err := bson.MarshalExtJSON(token, true, false)
+	if err != nil {
+		return err
+	}
+	tokens[name] = value
+	data, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// The file is replaced by a rename so that a crash never leaves a partially written token.
+	file, err := ioutil.TempFile(filepath.Dir(s.path), "."+filepath.Base(s.path)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), s.path)
+}
@@ -0,0 +1,161 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog"
+)
+
+// BreakerState is the state of a CircuitBreaker; its numeric value is exported as a metric.
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerHalfOpen
+	BreakerOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerHalfOpen:
+		return "half-open"
+	case BreakerOpen:
+		return "open"
+	}
+	return "unknown"
+}
+
+// ErrCircuitOpen is returned by CircuitBreaker.Execute while the database is considered unavailable.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+var breakerStateGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "mongodb_client_circuit_breaker_state",
+	Help: "State of the database circuit breaker (0 = closed, 1 = half-open, 2 = open).",
+})
+
+func init() {
+	prometheus.MustRegister(breakerStateGauge)
+}
+
+// BreakerConfig configures when a CircuitBreaker opens and how it probes for recovery.
+type BreakerConfig struct {
+	// Threshold is the number of consecutive failures after which the breaker opens.
+	Threshold int
+	// Backoff is the delay before the first recovery probe; it doubles after every failed probe.
+	Backoff time.Duration
+	// MaxBackoff caps the delay between two probes.
+	MaxBackoff time.Duration
+}
+
+var DefaultBreakerConfig = BreakerConfig{
+	Threshold:  3,
+	Backoff:    15 * time.Second,
+	MaxBackoff: 5 * time.Minute,
+}
+
+// CircuitBreaker stops operations from being attempted once the database has failed repeatedly,
+// and probes it in the background until it recovers. Only the errors of the database being
+// unreachable or unavailable, those IsRetryable reports, count as failures: the other errors of
+// operations neither open the breaker nor reset its count of failures.
+type CircuitBreaker struct {
+	config BreakerConfig
+	probe  func(ctx context.Context) error
+	// Ignore reports the errors that do not count as failures even when they are retryable, such
+	// as those of operations that were skipped.
+	Ignore func(err error) bool
+
+	lock     sync.Mutex
+	state    BreakerState
+	failures int
+}
+
+// NewCircuitBreaker returns a closed breaker that uses probe to check whether the database has recovered.
+func NewCircuitBreaker(config BreakerConfig, probe func(ctx context.Context) error) *CircuitBreaker {
+	if config.Threshold <= 0 {
+		config.Threshold = DefaultBreakerConfig.Threshold
+	}
+	if config.Backoff <= 0 {
+		config.Backoff = DefaultBreakerConfig.Backoff
+	}
+	breakerStateGauge.Set(float64(BreakerClosed))
+	return &CircuitBreaker{config: config, probe: probe}
+}
+
+// State returns the current state of the breaker.
+func (b *CircuitBreaker) State() BreakerState {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.state
+}
+
+// Execute runs fn unless the breaker is open, recording its outcome.
+func (b *CircuitBreaker) Execute(ctx context.Context, fn func(ctx context.Context) error) error {
+	if b.State() != BreakerClosed {
+		return ErrCircuitOpen
+	}
+	err := fn(ctx)
+	b.record(err)
+	return err
+}
+
+func (b *CircuitBreaker) record(err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if err == nil {
+		b.failures = 0
+		return
+	}
+	if !IsRetryable(err) || (b.Ignore != nil && b.Ignore(err)) {
+		return
+	}
+	b.failures++
+	if b.state == BreakerClosed && b.failures >= b.config.Threshold {
+		klog.Warningf("Circuit breaker opening after %d consecutive failures: %v", b.failures, err)
+		b.setState(BreakerOpen)
+		go b.recover()
+	}
+}
+
+func (b *CircuitBreaker) recover() {
+	delay := b.config.Backoff
+	for {
+		time.Sleep(delay)
+
+		b.lock.Lock()
+		b.setState(BreakerHalfOpen)
+		b.lock.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), delay)
+		err := b.probe(ctx)
+		cancel()
+
+		b.lock.Lock()
+		if err == nil {
+			klog.Infof("Circuit breaker closing, database is reachable again")
+			b.failures = 0
+			b.setState(BreakerClosed)
+			b.lock.Unlock()
+			return
+		}
+		b.setState(BreakerOpen)
+		b.lock.Unlock()
+
+		delay *= 2
+		if b.config.MaxBackoff > 0 && delay > b.config.MaxBackoff {
+			delay = b.config.MaxBackoff
+		}
+		klog.Warningf("Circuit breaker probe failed, next probe in %s: %v", delay, err)
+	}
+}
+
+func (b *CircuitBreaker) setState(state BreakerState) {
+	b.state = state
+	breakerStateGauge.Set(float64(state))
+}
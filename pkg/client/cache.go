@@ -0,0 +1,338 @@
+package client
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"k8s.io/klog"
+)
+
+var (
+	cacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mongodb_client_cache_requests_total",
+		Help: "Number of reads through the cache, partitioned by namespace and result: hit, miss, or bypass for the collections not watched.",
+	}, []string{"namespace", "result"})
+	cacheInvalidations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mongodb_client_cache_invalidations_total",
+		Help: "Number of invalidations of the cached reads of each namespace.",
+	}, []string{"namespace"})
+)
+
+func init() {
+	prometheus.MustRegister(cacheRequests, cacheInvalidations)
+}
+
+// CacheBackend stores the results of the reads of a Cache by namespace, database.collection. A
+// backend shared by several processes, such as RedisCache, must be invalidated by every process
+// writing to the namespace or watching it.
+type CacheBackend interface {
+	// Get returns the value stored under key, and whether there is one.
+	Get(ctx context.Context, namespace, key string) ([]byte, bool, error)
+	Set(ctx context.Context, namespace, key string, value []byte, ttl time.Duration) error
+	// Invalidate removes every value of namespace.
+	Invalidate(ctx context.Context, namespace string) error
+}
+
+// Cache serves Find and FindOne from a backend for the collections it watches, and invalidates all
+// the cached reads of a collection when the change stream of the collection reports any change. It
+// suits collections of static or rarely changing data. The reads of the collections that are not
+// watched, or whose change stream is being reopened, go to the database.
+type Cache struct {
+	Backend CacheBackend
+	// TTL bounds the lifetime of the cached reads, zero keeps them until invalidated or evicted.
+	TTL time.Duration
+
+	lock    sync.RWMutex
+	watched map[string]bool
+	// generations count the invalidations of each namespace, a read is only cached when no
+	// invalidation happened since it started.
+	generations map[string]uint64
+}
+
+// NewCache returns a cache of the reads of the collections it watches, stored in backend.
+func NewCache(backend CacheBackend, ttl time.Duration) *Cache {
+	return &Cache{Backend: backend, TTL: ttl, watched: map[string]bool{}, generations: map[string]uint64{}}
+}
+
+func namespace(collection *mongo.Collection) string {
+	return collection.Database().Name() + "." + collection.Name()
+}
+
+// Watch caches the reads of collection and invalidates them on its changes until ctx is cancelled.
+// It blocks, reopening the change stream after failures, and stops caching the collection while
+// the stream is closed.
+func (c *Cache) Watch(ctx context.Context, collection *mongo.Collection) error {
+	ns := namespace(collection)
+	defer c.setWatched(ns, false)
+	delay := time.Second
+	for {
+		start := time.Now()
+		err := c.watch(ctx, collection, ns)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if time.Since(start) > time.Minute {
+			delay = time.Second
+		}
+		klog.Warningf("Cache change stream of %s failed, reopening in %s: %v", ns, delay, err)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+		if delay < time.Minute {
+			delay *= 2
+		}
+	}
+}
+
+// watch opens the change stream of collection, then discards the reads cached before it was open
+// and caches the reads of collection until the stream fails.
+func (c *Cache) watch(ctx context.Context, collection *mongo.Collection, ns string) error {
+	defer c.setWatched(ns, false)
+	// Only the occurrence of an event matters, not its content.
+	pipeline := mongo.Pipeline{{{Key: "$project", Value: bson.D{{Key: "operationType", Value: 1}}}}}
+	stream, err := collection.Watch(ctx, pipeline)
+	if err != nil {
+		return fmt.Errorf("unable to watch %s: %w", ns, err)
+	}
+	defer stream.Close(context.Background())
+	if err := c.invalidate(ctx, ns); err != nil {
+		return err
+	}
+	c.setWatched(ns, true)
+	for stream.Next(ctx) {
+		if err := c.invalidate(ctx, ns); err != nil {
+			return err
+		}
+	}
+	return stream.Err()
+}
+
+func (c *Cache) invalidate(ctx context.Context, ns string) error {
+	c.lock.Lock()
+	c.generations[ns]++
+	c.lock.Unlock()
+	if err := c.Backend.Invalidate(ctx, ns); err != nil {
+		return fmt.Errorf("unable to invalidate the cache of %s: %w", ns, err)
+	}
+	cacheInvalidations.WithLabelValues(ns).Inc()
+	return nil
+}
+
+func (c *Cache) setWatched(ns string, watched bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if watched {
+		c.watched[ns] = true
+	} else {
+		delete(c.watched, ns)
+	}
+}
+
+// generation returns the number of invalidations of ns when it is watched, and whether it is.
+func (c *Cache) generation(ns string) (uint64, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.generations[ns], c.watched[ns]
+}
+
+// cacheKey returns the key of a read, the hash of its operation, filter and options, or false
+// when they cannot be encoded.
+func cacheKey(operation string, filter, opts interface{}) (string, bool) {
+	if filter == nil {
+		filter = bson.D{}
+	}
+	data, err := bson.MarshalExtJSON(bson.D{
+		{Key: "op", Value: operation},
+		{Key: "filter", Value: filter},
+		{Key: "options", Value: opts},
+	}, true, false)
+	if err != nil {
+		return "", false
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), true
+}
+
+// lookup returns the cached document of the read called key of ns, nil on a miss or a bypass.
+func (c *Cache) lookup(ctx context.Context, ns, key string, ok bool) bson.Raw {
+	if _, watched := c.generation(ns); !ok || !watched {
+		cacheRequests.WithLabelValues(ns, "bypass").Inc()
+		return nil
+	}
+	value, found, err := c.Backend.Get(ctx, ns, key)
+	if err != nil {
+		klog.Warningf("Unable to read the cache of %s: %v", ns, err)
+	}
+	if !found || err != nil {
+		cacheRequests.WithLabelValues(ns, "miss").Inc()
+		return nil
+	}
+	cacheRequests.WithLabelValues(ns, "hit").Inc()
+	return value
+}
+
+// store caches the document of the read called key of ns, unless ns was invalidated or stopped
+// being watched since generation, when the read started. The lock is held while the document is
+// written, so an invalidation either sees the document or prevents it from being written.
+func (c *Cache) store(ctx context.Context, ns, key string, ok bool, generation uint64, doc interface{}) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if !ok || !c.watched[ns] || c.generations[ns] != generation {
+		return
+	}
+	value, err := bson.Marshal(doc)
+	if err == nil {
+		err = c.Backend.Set(ctx, ns, key, value, c.TTL)
+	}
+	if err != nil {
+		klog.Warningf("Unable to write the cache of %s: %v", ns, err)
+	}
+}
+
+// Find decodes the documents of collection matching filter into results, a pointer to a slice,
+// from the cache when possible.
+func (c *Cache) Find(ctx context.Context, collection *mongo.Collection, filter interface{}, results interface{}, opts ...*options.FindOptions) error {
+	ns := namespace(collection)
+	generation, _ := c.generation(ns)
+	key, ok := cacheKey("find", filter, options.MergeFindOptions(opts...))
+	if cached := c.lookup(ctx, ns, key, ok); cached != nil {
+		return cached.Lookup("docs").Unmarshal(results)
+	}
+
+	if filter == nil {
+		filter = bson.D{}
+	}
+	cursor, err := collection.Find(ctx, filter, opts...)
+	if err != nil {
+		return err
+	}
+	var docs []bson.Raw
+	if err := cursor.All(ctx, &docs); err != nil {
+		return err
+	}
+	if docs == nil {
+		docs = []bson.Raw{}
+	}
+	cached := bson.D{{Key: "docs", Value: docs}}
+	c.store(ctx, ns, key, ok, generation, cached)
+	value, err := bson.Marshal(cached)
+	if err != nil {
+		return err
+	}
+	return bson.Raw(value).Lookup("docs").Unmarshal(results)
+}
+
+// FindOne decodes the first document of collection matching filter into result, from the cache
+// when possible. It returns mongo.ErrNoDocuments when none matches, which is cached as well.
+func (c *Cache) FindOne(ctx context.Context, collection *mongo.Collection, filter interface{}, result interface{}, opts ...*options.FindOneOptions) error {
+	ns := namespace(collection)
+	generation, _ := c.generation(ns)
+	key, ok := cacheKey("findOne", filter, options.MergeFindOneOptions(opts...))
+	if cached := c.lookup(ctx, ns, key, ok); cached != nil {
+		doc, found := cached.Lookup("doc").DocumentOK()
+		if !found {
+			return mongo.ErrNoDocuments
+		}
+		return bson.Unmarshal(doc, result)
+	}
+
+	if filter == nil {
+		filter = bson.D{}
+	}
+	doc, err := collection.FindOne(ctx, filter, opts...).DecodeBytes()
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		c.store(ctx, ns, key, ok, generation, bson.D{})
+		return err
+	case err != nil:
+		return err
+	}
+	c.store(ctx, ns, key, ok, generation, bson.D{{Key: "doc", Value: doc}})
+	return bson.Unmarshal(doc, result)
+}
+
+// MemoryCache is a CacheBackend holding up to a number of values in memory, evicting the least
+// recently used first.
+type MemoryCache struct {
+	lock    sync.Mutex
+	max     int
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+type memoryEntry struct {
+	namespace string
+	key       string
+	value     []byte
+	expires   time.Time
+}
+
+// NewMemoryCache returns a backend holding up to max values.
+func NewMemoryCache(max int) *MemoryCache {
+	return &MemoryCache{max: max, entries: map[string]*list.Element{}, order: list.New()}
+}
+
+func (m *MemoryCache) Get(ctx context.Context, namespace, key string) ([]byte, bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	element, ok := m.entries[namespace+"/"+key]
+	if !ok {
+		return nil, false, nil
+	}
+	entry := element.Value.(*memoryEntry)
+	if !entry.expires.IsZero() && time.Now().After(entry.expires) {
+		m.remove(element)
+		return nil, false, nil
+	}
+	m.order.MoveToFront(element)
+	return entry.value, true, nil
+}
+
+func (m *MemoryCache) Set(ctx context.Context, namespace, key string, value []byte, ttl time.Duration) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	entry := &memoryEntry{namespace: namespace, key: key, value: value}
+	if ttl > 0 {
+		entry.expires = time.Now().Add(ttl)
+	}
+	if element, ok := m.entries[namespace+"/"+key]; ok {
+		element.Value = entry
+		m.order.MoveToFront(element)
+		return nil
+	}
+	m.entries[namespace+"/"+key] = m.order.PushFront(entry)
+	for m.max > 0 && m.order.Len() > m.max {
+		m.remove(m.order.Back())
+	}
+	return nil
+}
+
+func (m *MemoryCache) Invalidate(ctx context.Context, namespace string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for element := m.order.Front(); element != nil; {
+		next := element.Next()
+		if element.Value.(*memoryEntry).namespace == namespace {
+			m.remove(element)
+		}
+		element = next
+	}
+	return nil
+}
+
+func (m *MemoryCache) remove(element *list.Element) {
+	entry := m.order.Remove(element).(*memoryEntry)
+	delete(m.entries, entry.namespace+"/"+entry.key)
+}
@@ -0,0 +1,272 @@
+package client
+
+import (
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/bson/bsonoptions"
+	"go.mongodb.org/mongo-driver/bson/bsonrw"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// UUIDRepresentation is how UUIDs are stored as BSON binaries. The standard representation is the
+// binary subtype 4 holding the bytes of the UUID in order, the legacy ones are the binary subtype 3
+// in the byte order of the drivers that wrote them before subtype 4 existed.
+type UUIDRepresentation string
+
+const (
+	UUIDStandard     UUIDRepresentation = "standard"
+	UUIDPythonLegacy UUIDRepresentation = "pythonLegacy"
+	UUIDJavaLegacy   UUIDRepresentation = "javaLegacy"
+	UUIDCSharpLegacy UUIDRepresentation = "csharpLegacy"
+)
+
+// TimePrecision is how the nanoseconds of a time.Time are stored in a BSON date, which holds
+// milliseconds.
+type TimePrecision string
+
+const (
+	// TimeTruncate drops the fraction of a millisecond, as the driver does.
+	TimeTruncate TimePrecision = "truncate"
+	// TimeRound rounds to the nearest millisecond.
+	TimeRound TimePrecision = "round"
+	// TimeStrict rejects the times with a fraction of a millisecond, so that every time read back
+	// equals the time written.
+	TimeStrict TimePrecision = "strict"
+)
+
+// CodecConfig selects the codecs of the registry of the clients. The zero value keeps the encoding
+// of the driver for times and stores UUIDs in the standard representation.
+type CodecConfig struct {
+	UUIDRepresentation UUIDRepresentation
+	TimePrecision      TimePrecision
+	// LocalTime decodes dates in the local time zone instead of UTC.
+	LocalTime bool
+	// Hooks register more codecs after those of the configuration and of RegisterCodecs, the last
+	// codec registered for a type wins.
+	Hooks []func(*bsoncodec.RegistryBuilder)
+}
+
+// UUID is a UUID stored as a BSON binary in the UUIDRepresentation of the registry.
+type UUID [16]byte
+
+// ParseUUID parses a UUID written as 32 hexadecimal digits, optionally separated by dashes.
+func ParseUUID(s string) (UUID, error) {
+	var id UUID
+	data, err := hex.DecodeString(strings.ReplaceAll(s, "-", ""))
+	if err != nil || len(data) != len(id) {
+		return id, fmt.Errorf("%q is not a UUID", s)
+	}
+	copy(id[:], data)
+	return id, nil
+}
+
+func (id UUID) String() string {
+	s := hex.EncodeToString(id[:])
+	return s[0:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:]
+}
+
+var (
+	tUUID    = reflect.TypeOf(UUID{})
+	tTime    = reflect.TypeOf(time.Time{})
+	tDecimal = reflect.TypeOf(decimal.Decimal{})
+
+	hooksLock sync.Mutex
+	hooks     []func(*bsoncodec.RegistryBuilder)
+)
+
+// RegisterCodecs adds hook to the hooks registering codecs in the registries of every client
+// created afterwards, so that packages importing the client plug their own codecs in, typically
+// from an init function.
+func RegisterCodecs(hook func(*bsoncodec.RegistryBuilder)) {
+	hooksLock.Lock()
+	defer hooksLock.Unlock()
+	hooks = append(hooks, hook)
+}
+
+// Validate reports whether the representation and the precision are known.
+func (c CodecConfig) Validate() error {
+	switch c.UUIDRepresentation {
+	case "", UUIDStandard, UUIDPythonLegacy, UUIDJavaLegacy, UUIDCSharpLegacy:
+	default:
+		return fmt.Errorf("unknown UUID representation %q, must be one of: %s, %s, %s, %s", c.UUIDRepresentation, UUIDStandard, UUIDPythonLegacy, UUIDJavaLegacy, UUIDCSharpLegacy)
+	}
+	switch c.TimePrecision {
+	case "", TimeTruncate, TimeRound, TimeStrict:
+	default:
+		return fmt.Errorf("unknown time precision %q, must be one of: %s, %s, %s", c.TimePrecision, TimeTruncate, TimeRound, TimeStrict)
+	}
+	return nil
+}
+
+// NewRegistry returns the registry of the default codecs of the driver, plus those of UUID, of
+// time.Time with the precision of the configuration, and of decimal.Decimal stored as Decimal128,
+// then the codecs of RegisterCodecs and of the hooks of the configuration.
+func NewRegistry(config CodecConfig) (*bsoncodec.Registry, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	rb := bson.NewRegistryBuilder()
+	uuids := uuidCodec{representation: config.UUIDRepresentation}
+	rb.RegisterTypeEncoder(tUUID, bsoncodec.ValueEncoderFunc(uuids.encode))
+	rb.RegisterTypeDecoder(tUUID, bsoncodec.ValueDecoderFunc(uuids.decode))
+	rb.RegisterTypeEncoder(tTime, bsoncodec.ValueEncoderFunc(timeEncoder(config.TimePrecision)))
+	rb.RegisterTypeDecoder(tTime, bsoncodec.NewTimeCodec(bsonoptions.TimeCodec().SetUseLocalTimeZone(config.LocalTime)))
+	rb.RegisterTypeEncoder(tDecimal, bsoncodec.ValueEncoderFunc(encodeDecimal))
+	rb.RegisterTypeDecoder(tDecimal, bsoncodec.ValueDecoderFunc(decodeDecimal))
+	hooksLock.Lock()
+	registered := append([]func(*bsoncodec.RegistryBuilder){}, hooks...)
+	hooksLock.Unlock()
+	for _, hook := range append(registered, config.Hooks...) {
+		hook(rb)
+	}
+	return rb.Build(), nil
+}
+
+type uuidCodec struct {
+	representation UUIDRepresentation
+}
+
+// order converts the bytes of a UUID to or from the legacy byte order of the representation, both
+// are the same permutation.
+func (c uuidCodec) order(id UUID) UUID {
+	switch c.representation {
+	case UUIDJavaLegacy:
+		for i := 0; i < 4; i++ {
+			id[i], id[7-i] = id[7-i], id[i]
+			id[8+i], id[15-i] = id[15-i], id[8+i]
+		}
+	case UUIDCSharpLegacy:
+		id[0], id[1], id[2], id[3] = id[3], id[2], id[1], id[0]
+		id[4], id[5] = id[5], id[4]
+		id[6], id[7] = id[7], id[6]
+	}
+	return id
+}
+
+func (c uuidCodec) encode(_ bsoncodec.EncodeContext, vw bsonrw.ValueWriter, val reflect.Value) error {
+	if !val.IsValid() || val.Type() != tUUID {
+		return bsoncodec.ValueEncoderError{Name: "UUIDEncodeValue", Types: []reflect.Type{tUUID}, Received: val}
+	}
+	id := val.Interface().(UUID)
+	if len(c.representation) == 0 || c.representation == UUIDStandard {
+		return vw.WriteBinaryWithSubtype(id[:], bsontype.BinaryUUID)
+	}
+	id = c.order(id)
+	return vw.WriteBinaryWithSubtype(id[:], bsontype.BinaryUUIDOld)
+}
+
+// decode reads both subtypes, whatever the representation written, so that collections holding
+// both can be read while they are migrated. Subtype 3 binaries are read in the byte order of the
+// legacy representation, as is when the representation is standard.
+func (c uuidCodec) decode(_ bsoncodec.DecodeContext, vr bsonrw.ValueReader, val reflect.Value) error {
+	if !val.CanSet() || val.Type() != tUUID {
+		return bsoncodec.ValueDecoderError{Name: "UUIDDecodeValue", Types: []reflect.Type{tUUID}, Received: val}
+	}
+	if vr.Type() == bsontype.Null {
+		val.Set(reflect.Zero(tUUID))
+		return vr.ReadNull()
+	}
+	if vr.Type() != bsontype.Binary {
+		return fmt.Errorf("cannot decode %v into a UUID", vr.Type())
+	}
+	data, subtype, err := vr.ReadBinary()
+	if err != nil {
+		return err
+	}
+	var id UUID
+	if len(data) != len(id) || (subtype != bsontype.BinaryUUID && subtype != bsontype.BinaryUUIDOld) {
+		return fmt.Errorf("cannot decode a binary of subtype %d and %d bytes into a UUID", subtype, len(data))
+	}
+	copy(id[:], data)
+	if subtype == bsontype.BinaryUUIDOld {
+		id = c.order(id)
+	}
+	val.Set(reflect.ValueOf(id))
+	return nil
+}
+
+func timeEncoder(precision TimePrecision) bsoncodec.ValueEncoderFunc {
+	return func(_ bsoncodec.EncodeContext, vw bsonrw.ValueWriter, val reflect.Value) error {
+		if !val.IsValid() || val.Type() != tTime {
+			return bsoncodec.ValueEncoderError{Name: "TimeEncodeValue", Types: []reflect.Type{tTime}, Received: val}
+		}
+		t := val.Interface().(time.Time)
+		switch precision {
+		case TimeRound:
+			t = t.Round(time.Millisecond)
+		case TimeStrict:
+			if t.Nanosecond()%int(time.Millisecond) != 0 {
+				return fmt.Errorf("time %s has a fraction of a millisecond, which a BSON date can not hold", t.Format(time.RFC3339Nano))
+			}
+		}
+		return vw.WriteDateTime(int64(primitive.NewDateTimeFromTime(t)))
+	}
+}
+
+func encodeDecimal(_ bsoncodec.EncodeContext, vw bsonrw.ValueWriter, val reflect.Value) error {
+	if !val.IsValid() || val.Type() != tDecimal {
+		return bsoncodec.ValueEncoderError{Name: "DecimalEncodeValue", Types: []reflect.Type{tDecimal}, Received: val}
+	}
+	d := val.Interface().(decimal.Decimal)
+	d128, ok := primitive.ParseDecimal128FromBigInt(d.Coefficient(), int(d.Exponent()))
+	if !ok {
+		return fmt.Errorf("decimal %s does not fit in a Decimal128", d)
+	}
+	return vw.WriteDecimal128(d128)
+}
+
+// decodeDecimal reads Decimal128 values exactly, and the integers and doubles stored by clients
+// that do not write decimals.
+func decodeDecimal(_ bsoncodec.DecodeContext, vr bsonrw.ValueReader, val reflect.Value) error {
+	if !val.CanSet() || val.Type() != tDecimal {
+		return bsoncodec.ValueDecoderError{Name: "DecimalDecodeValue", Types: []reflect.Type{tDecimal}, Received: val}
+	}
+	var d decimal.Decimal
+	switch vr.Type() {
+	case bsontype.Decimal128:
+		d128, err := vr.ReadDecimal128()
+		if err != nil {
+			return err
+		}
+		bi, exp, err := d128.BigInt()
+		if err != nil {
+			return fmt.Errorf("cannot decode %s into a decimal: %w", d128, err)
+		}
+		d = decimal.NewFromBigInt(bi, int32(exp))
+	case bsontype.Int32:
+		n, err := vr.ReadInt32()
+		if err != nil {
+			return err
+		}
+		d = decimal.NewFromInt32(n)
+	case bsontype.Int64:
+		n, err := vr.ReadInt64()
+		if err != nil {
+			return err
+		}
+		d = decimal.NewFromInt(n)
+	case bsontype.Double:
+		f, err := vr.ReadDouble()
+		if err != nil {
+			return err
+		}
+		d = decimal.NewFromFloat(f)
+	case bsontype.Null:
+		if err := vr.ReadNull(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("cannot decode %v into a decimal", vr.Type())
+	}
+	val.Set(reflect.ValueOf(d))
+	return nil
+}
@@ -0,0 +1,62 @@
+package client
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Each calls fn with the documents of cursor as they are read, one batch at a time, and closes it.
+// The document passed to fn is only valid until fn returns: it must be copied to be kept. Each stops
+// at the first error returned by fn.
+func Each(ctx context.Context, cursor *mongo.Cursor, fn func(doc bson.Raw) error) error {
+	defer cursor.Close(context.Background())
+	for cursor.Next(ctx) {
+		if err := fn(cursor.Current); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// Decode calls fn with the documents of cursor as they are read, after decoding each of them with
+// newValue, which returns a pointer to decode into such as func() interface{} { return &T{} }. It
+// closes the cursor and stops at the first error returned by fn.
+func Decode(ctx context.Context, cursor *mongo.Cursor, newValue func() interface{}, fn func(value interface{}) error) error {
+	return Each(ctx, cursor, func(doc bson.Raw) error {
+		value := newValue()
+		if err := bson.Unmarshal(doc, value); err != nil {
+			return err
+		}
+		return fn(value)
+	})
+}
+
+// Stream sends copies of the documents of cursor on the returned channel from a new goroutine,
+// holding up to buffer documents read ahead of the receiver. The document channel is closed once
+// the cursor is exhausted or ctx is done, after which the error channel yields the error of the
+// cursor, if any, and is closed. The receiver must either drain the documents or cancel ctx.
+func Stream(ctx context.Context, cursor *mongo.Cursor, buffer int) (<-chan bson.Raw, <-chan error) {
+	if buffer < 0 {
+		buffer = 0
+	}
+	docs := make(chan bson.Raw, buffer)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(errs)
+		err := Each(ctx, cursor, func(doc bson.Raw) error {
+			select {
+			case docs <- append(bson.Raw(nil), doc...):
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+		close(docs)
+		if err != nil {
+			errs <- err
+		}
+	}()
+	return docs, errs
+}
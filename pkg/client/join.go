@@ -0,0 +1,197 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Join embeds in every document the documents of From whose ForeignField equals its LocalField.
+type Join struct {
+	From         string
+	LocalField   string
+	ForeignField string
+	// As is the field holding the joined documents, LocalField by default so that a reference is
+	// replaced by the document it references.
+	As string
+	// One embeds the first joined document instead of the array of joined documents, the field is
+	// removed when there is none. It suits references to a single document, such as the podcast of
+	// an episode.
+	One bool
+}
+
+// Field returns the field holding the joined documents.
+func (j Join) Field() string {
+	if len(j.As) == 0 {
+		return j.LocalField
+	}
+	return j.As
+}
+
+// Validate reports whether the join is complete.
+func (j Join) Validate() error {
+	if len(j.From) == 0 || strings.Contains(j.From, "$") {
+		return fmt.Errorf("invalid joined collection %q", j.From)
+	}
+	if len(j.LocalField) == 0 || len(j.ForeignField) == 0 {
+		return fmt.Errorf("join with %s: a local and a foreign field are required", j.From)
+	}
+	if strings.HasPrefix(j.Field(), "$") {
+		return fmt.Errorf("join with %s: invalid field %q", j.From, j.Field())
+	}
+	return nil
+}
+
+// Stages returns the $lookup stage of the join, followed by the stage keeping its first document
+// when One is set.
+func (j Join) Stages() mongo.Pipeline {
+	stages := mongo.Pipeline{{{Key: "$lookup", Value: bson.D{
+		{Key: "from", Value: j.From},
+		{Key: "localField", Value: j.LocalField},
+		{Key: "foreignField", Value: j.ForeignField},
+		{Key: "as", Value: j.Field()},
+	}}}}
+	if j.One {
+		// $arrayElemAt of an empty array is missing, which removes the field.
+		stages = append(stages, bson.D{{Key: "$addFields", Value: bson.D{
+			{Key: j.Field(), Value: bson.D{{Key: "$arrayElemAt", Value: bson.A{"$" + j.Field(), 0}}}},
+		}}})
+	}
+	return stages
+}
+
+// JoinQuery finds documents with the documents they reference, one page at a time.
+type JoinQuery struct {
+	// Filter selects the documents before they are joined.
+	Filter bson.D
+	Joins  []Join
+	// Match selects the joined documents, it may refer to the fields of Joins.
+	Match      bson.D
+	Sort       bson.D
+	Skip       int64
+	Limit      int64
+	Projection bson.D
+}
+
+// Validate reports whether the query and its joins are complete.
+func (q *JoinQuery) Validate() error {
+	if q.Skip < 0 || q.Limit < 0 {
+		return fmt.Errorf("skip and limit must not be negative")
+	}
+	fields := map[string]bool{}
+	for _, join := range q.Joins {
+		if err := join.Validate(); err != nil {
+			return err
+		}
+		if fields[join.Field()] {
+			return fmt.Errorf("several joins are embedded as %s", join.Field())
+		}
+		fields[join.Field()] = true
+	}
+	return nil
+}
+
+// Pipeline returns the aggregation of the page of joined documents. The page is selected before
+// the joins when neither Match nor Sort refer to joined fields, so that only the documents of the
+// page are joined.
+func (q *JoinQuery) Pipeline() mongo.Pipeline {
+	var pipeline mongo.Pipeline
+	if len(q.Filter) > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: q.Filter}})
+	}
+	if len(q.Match) == 0 && !q.sortsJoined() {
+		pipeline = append(pipeline, q.page()...)
+		pipeline = append(pipeline, q.joins()...)
+	} else {
+		pipeline = append(pipeline, q.joins()...)
+		if len(q.Match) > 0 {
+			pipeline = append(pipeline, bson.D{{Key: "$match", Value: q.Match}})
+		}
+		pipeline = append(pipeline, q.page()...)
+	}
+	if len(q.Projection) > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$project", Value: q.Projection}})
+	}
+	return pipeline
+}
+
+// CountPipeline returns the aggregation counting the joined documents of every page, as a single
+// document {count}. The documents are only joined when Match refers to joined fields.
+func (q *JoinQuery) CountPipeline() mongo.Pipeline {
+	var pipeline mongo.Pipeline
+	if len(q.Filter) > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: q.Filter}})
+	}
+	if len(q.Match) > 0 {
+		pipeline = append(pipeline, q.joins()...)
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: q.Match}})
+	}
+	return append(pipeline, bson.D{{Key: "$count", Value: "count"}})
+}
+
+// Find runs the query on collection and returns the cursor of the joined documents.
+func (q *JoinQuery) Find(ctx context.Context, collection *mongo.Collection, opts ...*options.AggregateOptions) (*mongo.Cursor, error) {
+	if err := q.Validate(); err != nil {
+		return nil, err
+	}
+	return collection.Aggregate(ctx, q.Pipeline(), opts...)
+}
+
+// Count returns the number of joined documents of every page of the query on collection.
+func (q *JoinQuery) Count(ctx context.Context, collection *mongo.Collection) (int64, error) {
+	if err := q.Validate(); err != nil {
+		return 0, err
+	}
+	cursor, err := collection.Aggregate(ctx, q.CountPipeline())
+	if err != nil {
+		return 0, err
+	}
+	var counts []struct {
+		Count int64 `bson:"count"`
+	}
+	if err := cursor.All(ctx, &counts); err != nil {
+		return 0, err
+	}
+	if len(counts) == 0 {
+		return 0, nil
+	}
+	return counts[0].Count, nil
+}
+
+func (q *JoinQuery) joins() mongo.Pipeline {
+	var pipeline mongo.Pipeline
+	for _, join := range q.Joins {
+		pipeline = append(pipeline, join.Stages()...)
+	}
+	return pipeline
+}
+
+func (q *JoinQuery) page() mongo.Pipeline {
+	var pipeline mongo.Pipeline
+	if len(q.Sort) > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$sort", Value: q.Sort}})
+	}
+	if q.Skip > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$skip", Value: q.Skip}})
+	}
+	if q.Limit > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$limit", Value: q.Limit}})
+	}
+	return pipeline
+}
+
+// sortsJoined reports whether Sort refers to a field replaced by a join.
+func (q *JoinQuery) sortsJoined() bool {
+	for _, e := range q.Sort {
+		for _, join := range q.Joins {
+			if e.Key == join.Field() || strings.HasPrefix(e.Key, join.Field()+".") {
+				return true
+			}
+		}
+	}
+	return false
+}
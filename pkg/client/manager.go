@@ -0,0 +1,386 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+)
+
+const DefaultOperationTimeout = 10 * time.Second
+
+// DefaultBackoff retries connectivity checks for roughly a minute before giving up.
+var DefaultBackoff = wait.Backoff{
+	Duration: 1 * time.Second,
+	Factor:   2,
+	Jitter:   0.1,
+	Steps:    7,
+}
+
+// Config describes how to reach the database and how long individual operations may take.
+type Config struct {
+	Host          string
+	Port          string
+	Database      string
+	User          string
+	Password      string
+	AdminPassword string
+	// URI and AdminURI, when they are set, are the connection strings of the application and admin
+	// clients instead of those built from the host, port and credentials.
+	URI      string
+	AdminURI string
+
+	// ClientOptions are merged into the options of every client created by the manager.
+	ClientOptions *options.ClientOptions
+	// AutoEncryption, when it is set, returns the automatic encryption options of the clients
+	// created by Connect. It is given a client of the key vault that is not encrypted, and that is
+	// disconnected afterwards, to look up the data keys.
+	AutoEncryption func(ctx context.Context, keyVault *mongo.Client) (*options.AutoEncryptionOptions, error)
+
+	OperationTimeout time.Duration
+	// Timeouts override OperationTimeout for some kinds of operations.
+	Timeouts Timeouts
+	Backoff  wait.Backoff
+	Retry    RetryPolicy
+	// Codecs select the codecs of the registry of the clients.
+	Codecs CodecConfig
+	// ReadOnly makes every client of the manager refuse the commands that could write, see ReadOnly.
+	ReadOnly bool
+}
+
+// ConfigFromEnvironment reads the connection details from the MONGODB_* environment variables.
+func ConfigFromEnvironment() (Config, error) {
+	config := Config{
+		OperationTimeout: DefaultOperationTimeout,
+		Backoff:          DefaultBackoff,
+		Retry:            DefaultRetryPolicy,
+	}
+
+	for _, env := range []struct {
+		name  string
+		value *string
+	}{
+		{"MONGODB_HOST", &config.Host},
+		{"MONGODB_PORT", &config.Port},
+		{"MONGODB_USER", &config.User},
+		{"MONGODB_PASSWORD", &config.Password},
+		{"MONGODB_ADMIN_PASSWORD", &config.AdminPassword},
+		{"MONGODB_DATABASE", &config.Database},
+	} {
+		value, ok := os.LookupEnv(env.name)
+		if !ok || len(value) == 0 {
+			return config, fmt.Errorf("%s is not defined", env.name)
+		}
+		*env.value = value
+	}
+
+	return config, nil
+}
+
+// ConnectionManager owns the lifecycle of the application and admin clients.
+type ConnectionManager struct {
+	config Config
+
+	primary  *mongo.Client
+	admin    *mongo.Client
+	registry *bsoncodec.Registry
+
+	connected int32
+}
+
+func NewConnectionManager(config Config) *ConnectionManager {
+	if config.OperationTimeout <= 0 {
+		config.OperationTimeout = DefaultOperationTimeout
+	}
+	if config.Backoff.Steps <= 0 {
+		config.Backoff = DefaultBackoff
+	}
+	return &ConnectionManager{config: config}
+}
+
+// connectStrings returns the connection strings of the application and admin clients.
+func (m *ConnectionManager) connectStrings() (string, string) {
+	// mongodb://[username:password@]host1[:port1][,...hostN[:portN]][/[defaultauthdb][?options]]
+	connectString := fmt.Sprintf("mongodb://%s:%s@%s:%s/%s", m.config.User, m.config.Password, m.config.Host, m.config.Port, m.config.Database)
+	adminConnectString := fmt.Sprintf("mongodb://admin:%s@%s:%s/admin", m.config.AdminPassword, m.config.Host, m.config.Port)
+	if len(m.config.URI) > 0 {
+		connectString, adminConnectString = m.config.URI, m.config.URI
+		if len(m.config.AdminURI) > 0 {
+			adminConnectString = m.config.AdminURI
+		}
+	}
+	return connectString, adminConnectString
+}
+
+// Connect creates both clients and waits, with exponential backoff, until each of them can reach the primary.
+func (m *ConnectionManager) Connect(ctx context.Context) error {
+	connectString, adminConnectString := m.connectStrings()
+	if err := m.buildRegistry(); err != nil {
+		return err
+	}
+	var autoEncryption *options.AutoEncryptionOptions
+	if m.config.AutoEncryption != nil {
+		var err error
+		if autoEncryption, err = m.autoEncryption(ctx, adminConnectString); err != nil {
+			return err
+		}
+	}
+
+	primary, err := mongo.Connect(ctx, m.clientOptions(connectString).SetAutoEncryptionOptions(autoEncryption))
+	if err != nil {
+		return fmt.Errorf("unable to create database client: %w", err)
+	}
+	m.primary = primary
+
+	admin, err := mongo.Connect(ctx, m.clientOptions(adminConnectString).SetAutoEncryptionOptions(autoEncryption))
+	if err != nil {
+		return fmt.Errorf("unable to create admin client: %w", err)
+	}
+	m.admin = admin
+
+	klog.Infof("Checking access to database...")
+	if err := m.waitForPing("database", m.primary); err != nil {
+		return err
+	}
+	if err := m.waitForPing("admin", m.admin); err != nil {
+		return err
+	}
+	atomic.StoreInt32(&m.connected, 1)
+	return nil
+}
+
+// ConnectApplication creates the application client alone, without waiting for it to reach the
+// deployment nor configuring automatic encryption, for the processes that only check the health of
+// the deployment. Admin returns nil afterwards.
+func (m *ConnectionManager) ConnectApplication(ctx context.Context) error {
+	connectString, _ := m.connectStrings()
+	if err := m.buildRegistry(); err != nil {
+		return err
+	}
+	primary, err := mongo.Connect(ctx, m.clientOptions(connectString))
+	if err != nil {
+		return fmt.Errorf("unable to create database client: %w", err)
+	}
+	m.primary = primary
+	return nil
+}
+
+// autoEncryption looks up the data keys with a temporary admin client of the key vault and returns
+// the encryption options of the clients.
+func (m *ConnectionManager) autoEncryption(ctx context.Context, connectString string) (*options.AutoEncryptionOptions, error) {
+	keyVault, err := mongo.Connect(ctx, m.clientOptions(connectString))
+	if err != nil {
+		return nil, fmt.Errorf("unable to create key vault client: %w", err)
+	}
+	defer keyVault.Disconnect(context.Background())
+	opts, err := m.config.AutoEncryption(ctx, keyVault)
+	if err != nil {
+		return nil, fmt.Errorf("unable to set up client-side encryption: %w", err)
+	}
+	return opts, nil
+}
+
+// ConnectDirect returns a client of the single server host, or of the configured host when it is
+// empty, that does not discover the other members of its deployment. It is meant for commands that
+// must reach a given server, such as replSetInitiate on a member that is not part of a replica set
+// yet. The client authenticates as admin unless anonymous is set, which is needed before the first
+// user exists. The caller disconnects it.
+func (m *ConnectionManager) ConnectDirect(ctx context.Context, host string, anonymous bool) (*mongo.Client, error) {
+	if len(host) == 0 {
+		host = m.Address()
+	}
+	connectString := fmt.Sprintf("mongodb://admin:%s@%s/admin", m.config.AdminPassword, host)
+	if anonymous {
+		connectString = fmt.Sprintf("mongodb://%s/admin", host)
+	}
+	if err := m.buildRegistry(); err != nil {
+		return nil, err
+	}
+	c, err := mongo.Connect(ctx, m.clientOptions(connectString).SetDirect(true))
+	if err != nil {
+		return nil, fmt.Errorf("unable to create a client of %s: %w", host, err)
+	}
+	if err := c.Ping(ctx, readpref.PrimaryPreferred()); err != nil {
+		c.Disconnect(ctx)
+		return nil, fmt.Errorf("unable to reach %s: %w", host, err)
+	}
+	return c, nil
+}
+
+// Connected reports whether Connect has completed successfully.
+func (m *ConnectionManager) Connected() bool {
+	return atomic.LoadInt32(&m.connected) == 1
+}
+
+// Ping checks that the primary is reachable through the application client, which fails before the
+// client is created.
+func (m *ConnectionManager) Ping(ctx context.Context) error {
+	if m.primary == nil {
+		return errors.New("not connected to database")
+	}
+	return m.primary.Ping(ctx, readpref.Primary())
+}
+
+func (m *ConnectionManager) waitForPing(name string, c *mongo.Client) error {
+	attempt := 0
+	err := wait.ExponentialBackoff(m.config.Backoff, func() (bool, error) {
+		attempt++
+		ctx, cancel := m.Context()
+		defer cancel()
+		if err := c.Ping(ctx, readpref.Primary()); err != nil {
+			klog.Warningf("Unable to ping %s (attempt %d): %v", name, attempt, err)
+			return false, nil
+		}
+		klog.Infof("Ping of %s successful", name)
+		return true, nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to reach %s after %d attempts: %w", name, attempt, err)
+	}
+	return nil
+}
+
+// buildRegistry builds the codec registry of the clients once.
+func (m *ConnectionManager) buildRegistry() error {
+	if m.registry != nil {
+		return nil
+	}
+	registry, err := NewRegistry(m.config.Codecs)
+	if err != nil {
+		return fmt.Errorf("unable to build the codec registry: %w", err)
+	}
+	m.registry = registry
+	return nil
+}
+
+func (m *ConnectionManager) clientOptions(uri string) *options.ClientOptions {
+	opts := options.MergeClientOptions(options.Client().ApplyURI(uri).SetRegistry(m.registry), m.config.ClientOptions)
+	if m.config.ReadOnly {
+		opts = ReadOnly(opts)
+	}
+	return opts
+}
+
+// Disconnect closes every client that was successfully created.
+func (m *ConnectionManager) Disconnect(ctx context.Context) error {
+	var errs []error
+	for _, c := range []*mongo.Client{m.primary, m.admin} {
+		if c == nil {
+			continue
+		}
+		if err := c.Disconnect(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("unable to disconnect: %v", errs)
+	}
+	return nil
+}
+
+// Primary returns the client authenticated against the application database.
+func (m *ConnectionManager) Primary() *mongo.Client {
+	return m.primary
+}
+
+// Admin returns the client authenticated against the admin database.
+func (m *ConnectionManager) Admin() *mongo.Client {
+	return m.admin
+}
+
+// Registry returns the codec registry of the clients, to marshal and unmarshal documents outside of
+// operations with the same codecs. It is nil until Connect has been called.
+func (m *ConnectionManager) Registry() *bsoncodec.Registry {
+	return m.registry
+}
+
+// Address returns the host:port of the configured server.
+func (m *ConnectionManager) Address() string {
+	return m.config.Host + ":" + m.config.Port
+}
+
+// Database returns the name of the application database.
+func (m *ConnectionManager) Database() string {
+	return m.config.Database
+}
+
+// OperationTimeout returns the timeout applied to individual database operations.
+func (m *ConnectionManager) OperationTimeout() time.Duration {
+	return m.config.OperationTimeout
+}
+
+// Timeout returns the timeout of the operations of kind.
+func (m *ConnectionManager) Timeout(kind OperationKind) time.Duration {
+	return m.config.Timeouts.Timeout(kind, m.config.OperationTimeout)
+}
+
+// ContextFor returns a context bounded by the timeout of the operations of kind.
+func (m *ConnectionManager) ContextFor(kind OperationKind) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), m.Timeout(kind))
+}
+
+// Retry runs fn using the manager's retry policy.
+func (m *ConnectionManager) Retry(ctx context.Context, fn func(ctx context.Context) error) error {
+	return Retry(ctx, m.config.Retry, fn)
+}
+
+// RetryFor runs fn using the manager's retry policy, bounded by the timeout of the operations of
+// kind.
+func (m *ConnectionManager) RetryFor(kind OperationKind, fn func(ctx context.Context) error) error {
+	ctx, cancel := m.ContextFor(kind)
+	defer cancel()
+	return m.Retry(ctx, fn)
+}
+
+// Context returns a context bounded by the configured operation timeout.
+func (m *ConnectionManager) Context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), m.config.OperationTimeout)
+}
+
+// ConnectionString returns the connection string of the configured deployment authenticating as
+// user with password against database, for the clients of other processes. The hosts and options
+// of the configured URI are kept, without its credentials.
+func (m *ConnectionManager) ConnectionString(user, password, database string) (string, error) {
+	credentials := url.UserPassword(user, password).String()
+	if len(m.config.URI) == 0 {
+		return fmt.Sprintf("mongodb://%s@%s/%s", credentials, m.Address(), url.PathEscape(database)), nil
+	}
+	scheme := "mongodb://"
+	if strings.HasPrefix(m.config.URI, "mongodb+srv://") {
+		scheme = "mongodb+srv://"
+	}
+	rest := strings.TrimPrefix(m.config.URI, scheme)
+	hosts, query := rest, ""
+	if i := strings.IndexAny(rest, "/?"); i >= 0 {
+		hosts = rest[:i]
+		if j := strings.Index(rest, "?"); j >= 0 {
+			query = rest[j+1:]
+		}
+	}
+	if i := strings.LastIndex(hosts, "@"); i >= 0 {
+		hosts = hosts[i+1:]
+	}
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return "", fmt.Errorf("invalid options in the connection string: %w", err)
+	}
+	// The user authenticates against its own database.
+	params.Del("authSource")
+	params.Del("authMechanism")
+	connectString := scheme + credentials + "@" + hosts + "/" + url.PathEscape(database)
+	if len(params) > 0 {
+		connectString += "?" + params.Encode()
+	}
+	return connectString, nil
+}
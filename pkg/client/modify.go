@@ -0,0 +1,128 @@
+package client
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// FindAndModify atomically changes the first document matching Filter in Sort order and returns
+// it. Exactly one of Update, Replacement and Remove is set.
+type FindAndModify struct {
+	Filter interface{}
+	Sort   interface{}
+	// Projection selects the fields of the returned document.
+	Projection interface{}
+	// Update is an update document, such as {"$inc": {"n": 1}}, or an aggregation pipeline.
+	Update      interface{}
+	Replacement interface{}
+	Remove      bool
+	// Upsert inserts a document when none matches Filter, built from the equality conditions of
+	// Filter and Update, or from Replacement.
+	Upsert bool
+	// ReturnAfter returns the document once modified, or inserted by Upsert, instead of the document
+	// before its modification.
+	ReturnAfter bool
+	// ArrayFilters select the elements of arrays modified by the $[identifier] operators of Update.
+	ArrayFilters []interface{}
+}
+
+// Validate reports whether exactly one modification is set and the options apply to it.
+func (m *FindAndModify) Validate() error {
+	set := 0
+	for _, modification := range []bool{m.Update != nil, m.Replacement != nil, m.Remove} {
+		if modification {
+			set++
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("exactly one of an update, a replacement and a removal is required")
+	}
+	if m.Remove && (m.Upsert || m.ReturnAfter) {
+		return fmt.Errorf("a removal can not upsert nor return the document after its removal")
+	}
+	if len(m.ArrayFilters) > 0 && m.Update == nil {
+		return fmt.Errorf("array filters only apply to updates")
+	}
+	return nil
+}
+
+// Run applies the modification to the first matching document of collection and decodes the
+// returned document into out. It returns mongo.ErrNoDocuments when no document matched, or when
+// Upsert inserted a document and ReturnAfter is not set.
+func (m *FindAndModify) Run(ctx context.Context, collection *mongo.Collection, out interface{}) error {
+	if err := m.Validate(); err != nil {
+		return err
+	}
+	filter := m.Filter
+	if filter == nil {
+		filter = bson.D{}
+	}
+	returnDocument := options.Before
+	if m.ReturnAfter {
+		returnDocument = options.After
+	}
+
+	var result *mongo.SingleResult
+	switch {
+	case m.Remove:
+		opts := options.FindOneAndDelete()
+		if m.Sort != nil {
+			opts.SetSort(m.Sort)
+		}
+		if m.Projection != nil {
+			opts.SetProjection(m.Projection)
+		}
+		result = collection.FindOneAndDelete(ctx, filter, opts)
+	case m.Replacement != nil:
+		opts := options.FindOneAndReplace().SetUpsert(m.Upsert).SetReturnDocument(returnDocument)
+		if m.Sort != nil {
+			opts.SetSort(m.Sort)
+		}
+		if m.Projection != nil {
+			opts.SetProjection(m.Projection)
+		}
+		result = collection.FindOneAndReplace(ctx, filter, m.Replacement, opts)
+	default:
+		opts := options.FindOneAndUpdate().SetUpsert(m.Upsert).SetReturnDocument(returnDocument)
+		if m.Sort != nil {
+			opts.SetSort(m.Sort)
+		}
+		if m.Projection != nil {
+			opts.SetProjection(m.Projection)
+		}
+		if len(m.ArrayFilters) > 0 {
+			opts.SetArrayFilters(options.ArrayFilters{Filters: m.ArrayFilters})
+		}
+		result = collection.FindOneAndUpdate(ctx, filter, m.Update, opts)
+	}
+	return result.Decode(out)
+}
+
+// NextValue atomically adds delta to the counter called name of collection, created at zero when it
+// does not exist, and returns its new value. Counters are documents {_id: name, value}, the values
+// returned for a name are unique as long as delta is positive.
+func NextValue(ctx context.Context, collection *mongo.Collection, name string, delta int64) (int64, error) {
+	modify := &FindAndModify{
+		Filter:      bson.D{{Key: "_id", Value: name}},
+		Update:      bson.D{{Key: "$inc", Value: bson.D{{Key: "value", Value: delta}}}},
+		Upsert:      true,
+		ReturnAfter: true,
+	}
+	var counter struct {
+		Value int64 `bson:"value"`
+	}
+	// Concurrent upserts of a missing counter may both try to insert it, the loser is retried once
+	// the counter exists.
+	err := modify.Run(ctx, collection, &counter)
+	if mongo.IsDuplicateKeyError(err) {
+		err = modify.Run(ctx, collection, &counter)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("unable to increment counter %s: %w", name, err)
+	}
+	return counter.Value, nil
+}
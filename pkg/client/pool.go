@@ -0,0 +1,74 @@
+package client
+
+import (
+	"sync"
+
+	"go.mongodb.org/mongo-driver/event"
+)
+
+// PoolStats counts the connection pool events of the clients it monitors, by server address.
+type PoolStats struct {
+	lock    sync.Mutex
+	servers map[string]*ServerPoolStats
+}
+
+// ServerPoolStats are the pool counters of a server.
+type ServerPoolStats struct {
+	// Open is the number of established connections, InUse the number of them checked out.
+	Open  int64 `json:"open"`
+	InUse int64 `json:"inUse"`
+
+	Created          int64 `json:"created"`
+	Closed           int64 `json:"closed"`
+	CheckedOut       int64 `json:"checkedOut"`
+	CheckOutFailures int64 `json:"checkOutFailures"`
+	Cleared          int64 `json:"cleared"`
+}
+
+// NewPoolStats returns empty pool statistics, collected by its Monitor.
+func NewPoolStats() *PoolStats {
+	return &PoolStats{servers: map[string]*ServerPoolStats{}}
+}
+
+// Monitor returns the pool monitor to set on the clients to observe.
+func (s *PoolStats) Monitor() *event.PoolMonitor {
+	return &event.PoolMonitor{Event: s.record}
+}
+
+func (s *PoolStats) record(e *event.PoolEvent) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	server, ok := s.servers[e.Address]
+	if !ok {
+		server = &ServerPoolStats{}
+		s.servers[e.Address] = server
+	}
+	switch e.Type {
+	case event.ConnectionCreated:
+		server.Created++
+		server.Open++
+	case event.ConnectionClosed:
+		server.Closed++
+		server.Open--
+	case event.GetSucceeded:
+		server.CheckedOut++
+		server.InUse++
+	case event.GetFailed:
+		server.CheckOutFailures++
+	case event.ConnectionReturned:
+		server.InUse--
+	case event.PoolCleared:
+		server.Cleared++
+	}
+}
+
+// Snapshot returns a copy of the current counters, by server address.
+func (s *PoolStats) Snapshot() map[string]ServerPoolStats {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	snapshot := make(map[string]ServerPoolStats, len(s.servers))
+	for address, server := range s.servers {
+		snapshot[address] = *server
+	}
+	return snapshot
+}
@@ -0,0 +1,283 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"k8s.io/klog"
+)
+
+// readOnlyCommands are the commands that never change data, users or the configuration of a
+// deployment, the only ones sent by read-only clients. aggregate is also refused when its pipeline
+// writes with $out or $merge.
+var readOnlyCommands = map[string]bool{
+	"abortTransaction": true, "aggregate": true, "authenticate": true, "buildInfo": true, "buildinfo": true,
+	"collStats": true, "commitTransaction": true, "connPoolStats": true, "connectionStatus": true,
+	"count": true, "currentOp": true, "dataSize": true, "dbStats": true, "distinct": true,
+	"endSessions": true, "explain": true, "find": true, "getCmdLineOpts": true,
+	"getDefaultRWConcern": true, "getLog": true, "getMore": true, "getParameter": true,
+	"getnonce": true, "hello": true, "hostInfo": true, "isMaster": true, "ismaster": true,
+	"killCursors": true, "listCollections": true, "listCommands": true, "listDatabases": true,
+	"listIndexes": true, "lockInfo": true, "ping": true, "refreshSessions": true,
+	"replSetGetConfig": true, "replSetGetStatus": true, "rolesInfo": true, "saslContinue": true,
+	"saslStart": true, "serverStatus": true, "startSession": true, "top": true, "usersInfo": true,
+	"whatsmyuri": true,
+}
+
+const (
+	opQuery      = 2004
+	opMsg        = 2013
+	headerLength = 16
+	// checksumPresent and moreToCome are flags of OP_MSG, the sender of a message with moreToCome
+	// expects no reply.
+	checksumPresent = 1 << 0
+	moreToCome      = 1 << 1
+	// unauthorized is the code of the error returned for the refused commands, so that they are
+	// reported as the server reports the commands a user may not run.
+	unauthorized = 13
+)
+
+// ReadOnly returns opts set so that the clients created with them refuse every command that could
+// write, whatever the caller: the connections inspect the commands before sending them, and answer
+// those that are not known to only read with an Unauthorized error without sending them. The
+// connections set up TLS themselves, since the driver would encrypt the commands before they are
+// inspected. Compression is disabled for the same reason, the compressors of opts are dropped with
+// a warning. It must be applied after the options of the URI.
+func ReadOnly(opts *options.ClientOptions) *options.ClientOptions {
+	var dialer options.ContextDialer = &net.Dialer{KeepAlive: 300 * time.Second}
+	if opts.Dialer != nil {
+		dialer = opts.Dialer
+	}
+	opts.SetDialer(&readOnlyDialer{dialer: dialer, tls: opts.TLSConfig})
+	opts.TLSConfig = nil
+	if len(opts.Compressors) > 0 {
+		klog.Warningf("Compression is disabled in read-only mode, ignoring the compressors %s", strings.Join(opts.Compressors, ", "))
+		opts.Compressors = nil
+	}
+	return opts
+}
+
+type readOnlyDialer struct {
+	dialer options.ContextDialer
+	tls    *tls.Config
+}
+
+func (d *readOnlyDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := d.dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	if d.tls != nil {
+		config := d.tls.Clone()
+		if len(config.ServerName) == 0 {
+			config.ServerName = address
+			if host, _, err := net.SplitHostPort(address); err == nil {
+				config.ServerName = host
+			}
+		}
+		tlsConn := tls.Client(conn, config)
+		if deadline, ok := ctx.Deadline(); ok {
+			tlsConn.SetDeadline(deadline)
+		}
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		tlsConn.SetDeadline(time.Time{})
+		conn = tlsConn
+	}
+	return &readOnlyConn{Conn: conn}, nil
+}
+
+// readOnlyConn sends the read-only commands written by the driver, which writes a wire message per
+// call, and queues the replies of those it refuses, which the driver reads before the replies of
+// the server.
+type readOnlyConn struct {
+	net.Conn
+
+	lock    sync.Mutex
+	replies bytes.Buffer
+}
+
+func (c *readOnlyConn) Write(b []byte) (int, error) {
+	message, err := inspectWireMessage(b)
+	if err != nil {
+		// The message can not be inspected, the driver gets a network error rather than the command
+		// being sent.
+		return 0, err
+	}
+	if message.refusal == nil {
+		return c.Conn.Write(b)
+	}
+	if message.flags&moreToCome != 0 {
+		// Unacknowledged writes get no reply, their refusal is only logged.
+		klog.Warningf("Dropping unacknowledged command: %v", message.refusal)
+		return len(b), nil
+	}
+	klog.V(2).Infof("Refusing command: %v", message.refusal)
+	reply, err := errorReply(message.requestID, message.refusal)
+	if err != nil {
+		return 0, err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.replies.Write(reply)
+	return len(b), nil
+}
+
+func (c *readOnlyConn) Read(b []byte) (int, error) {
+	c.lock.Lock()
+	if c.replies.Len() > 0 {
+		defer c.lock.Unlock()
+		return c.replies.Read(b)
+	}
+	c.lock.Unlock()
+	return c.Conn.Read(b)
+}
+
+// wireMessage is a message written by the driver.
+type wireMessage struct {
+	requestID int32
+	flags     uint32
+	// refusal is why the command of the message may not be sent, nil when it only reads.
+	refusal error
+}
+
+// inspectWireMessage returns the wire message b. Legacy messages are only sent for the handshake of
+// the connections, the others are an error.
+func inspectWireMessage(b []byte) (wireMessage, error) {
+	var message wireMessage
+	if len(b) < headerLength+4 || int(binary.LittleEndian.Uint32(b)) != len(b) {
+		return message, errors.New("read-only mode: malformed wire message")
+	}
+	message.requestID = int32(binary.LittleEndian.Uint32(b[4:]))
+	switch opCode := binary.LittleEndian.Uint32(b[12:]); opCode {
+	case opQuery:
+		// OP_QUERY: flags, full collection name, number to skip and to return, then the query.
+		end := bytes.IndexByte(b[headerLength+4:], 0)
+		if end < 0 {
+			return message, errors.New("read-only mode: malformed OP_QUERY")
+		}
+		collection := string(b[headerLength+4 : headerLength+4+end])
+		query, err := wireDocument(b, headerLength+4+end+9)
+		if err != nil {
+			return message, fmt.Errorf("read-only mode: malformed OP_QUERY: %w", err)
+		}
+		if wrapped, ok := query.Lookup("$query").DocumentOK(); ok {
+			query = wrapped
+		}
+		if name := commandName(query); !strings.HasSuffix(collection, ".$cmd") || (name != "isMaster" && name != "ismaster" && name != "hello") {
+			return message, fmt.Errorf("read-only mode: legacy %s on %s is not allowed", name, collection)
+		}
+		return message, nil
+	case opMsg:
+		message.flags = binary.LittleEndian.Uint32(b[headerLength:])
+		end := len(b)
+		if message.flags&checksumPresent != 0 {
+			end -= 4
+		}
+		// Sections are a kind, then a document for kind 0 or a sized sequence of documents for
+		// kind 1, only the document is inspected.
+		for pos := headerLength + 4; pos+5 <= end; {
+			size := int(binary.LittleEndian.Uint32(b[pos+1:]))
+			if size < 5 || pos+1+size > end {
+				break
+			}
+			if b[pos] == 0 {
+				body, err := wireDocument(b[:end], pos+1)
+				if err != nil {
+					return message, fmt.Errorf("read-only mode: malformed OP_MSG: %w", err)
+				}
+				message.refusal = refuseCommand(body)
+				return message, nil
+			}
+			pos += 1 + size
+		}
+		return message, errors.New("read-only mode: OP_MSG without a body")
+	default:
+		return message, fmt.Errorf("read-only mode: the wire message of opcode %d is not allowed", opCode)
+	}
+}
+
+// wireDocument returns the BSON document at offset pos of b.
+func wireDocument(b []byte, pos int) (bson.Raw, error) {
+	if pos+4 > len(b) {
+		return nil, errors.New("truncated document")
+	}
+	size := int(binary.LittleEndian.Uint32(b[pos:]))
+	if size < 5 || pos+size > len(b) {
+		return nil, errors.New("truncated document")
+	}
+	doc := bson.Raw(b[pos : pos+size])
+	return doc, doc.Validate()
+}
+
+func commandName(command bson.Raw) string {
+	elements, err := command.Elements()
+	if err != nil || len(elements) == 0 {
+		return ""
+	}
+	return elements[0].Key()
+}
+
+// ReadOnlyCommand reports whether the command called name only reads, as the commands sent by
+// read-only clients. The command may be empty, as the security sensitive commands are reported to
+// command monitors.
+func ReadOnlyCommand(name string, command bson.Raw) bool {
+	if name != "aggregate" {
+		return readOnlyCommands[name]
+	}
+	return refuseCommand(command) == nil
+}
+
+// refuseCommand returns why command may not be sent by a read-only client, nil when it only reads.
+func refuseCommand(command bson.Raw) error {
+	name := commandName(command)
+	if !readOnlyCommands[name] {
+		return fmt.Errorf("read-only mode: the command %s is not allowed", name)
+	}
+	if name != "aggregate" {
+		return nil
+	}
+	stages, _ := command.Lookup("pipeline").ArrayOK()
+	values, _ := stages.Values()
+	for _, value := range values {
+		stage, ok := value.DocumentOK()
+		if !ok {
+			continue
+		}
+		if stageName := commandName(stage); stageName == "$out" || stageName == "$merge" {
+			return fmt.Errorf("read-only mode: aggregate with a %s stage is not allowed", stageName)
+		}
+	}
+	return nil
+}
+
+// errorReply returns the OP_MSG replying to the request requestID with the error of refusal.
+func errorReply(requestID int32, refusal error) ([]byte, error) {
+	body, err := bson.Marshal(bson.D{
+		{Key: "ok", Value: 0.0},
+		{Key: "errmsg", Value: refusal.Error()},
+		{Key: "code", Value: unauthorized},
+		{Key: "codeName", Value: "Unauthorized"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	reply := make([]byte, headerLength+4+1, headerLength+4+1+len(body))
+	reply = append(reply, body...)
+	binary.LittleEndian.PutUint32(reply, uint32(len(reply)))
+	binary.LittleEndian.PutUint32(reply[8:], uint32(requestID))
+	binary.LittleEndian.PutUint32(reply[12:], opMsg)
+	return reply, nil
+}
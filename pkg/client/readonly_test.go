@@ -0,0 +1,366 @@
+package client
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+const opReply = 1
+
+// fakeServer is a standalone server answering every command with ok, which records the names of
+// the commands it receives.
+type fakeServer struct {
+	listener net.Listener
+
+	lock     sync.Mutex
+	commands []string
+}
+
+// newFakeServer returns a server listening on the loopback interface, with TLS when config is not
+// nil, until the end of the test.
+func newFakeServer(t *testing.T, config *tls.Config) *fakeServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config != nil {
+		listener = tls.NewListener(listener, config)
+	}
+	s := &fakeServer{listener: listener}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeServer) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		var header [4]byte
+		if _, err := io.ReadFull(conn, header[:]); err != nil {
+			return
+		}
+		message := make([]byte, binary.LittleEndian.Uint32(header[:]))
+		copy(message, header[:])
+		if _, err := io.ReadFull(conn, message[4:]); err != nil {
+			return
+		}
+		requestID := binary.LittleEndian.Uint32(message[4:])
+		var command bson.Raw
+		var reply []byte
+		switch binary.LittleEndian.Uint32(message[12:]) {
+		case opQuery:
+			end := headerLength + 4
+			for message[end] != 0 {
+				end++
+			}
+			command, _ = wireDocument(message, end+9)
+			if wrapped, ok := command.Lookup("$query").DocumentOK(); ok {
+				command = wrapped
+			}
+			// OP_REPLY: flags, cursor id, starting from and number returned, then the documents.
+			reply = make([]byte, headerLength+20)
+			binary.LittleEndian.PutUint32(reply[headerLength+16:], 1)
+			reply = append(reply, s.reply(command)...)
+			binary.LittleEndian.PutUint32(reply[12:], opReply)
+		case opMsg:
+			flags := binary.LittleEndian.Uint32(message[headerLength:])
+			command, _ = wireDocument(message, headerLength+5)
+			if flags&moreToCome != 0 {
+				s.record(command)
+				continue
+			}
+			reply = make([]byte, headerLength+5)
+			reply = append(reply, s.reply(command)...)
+			binary.LittleEndian.PutUint32(reply[12:], opMsg)
+		default:
+			return
+		}
+		binary.LittleEndian.PutUint32(reply, uint32(len(reply)))
+		binary.LittleEndian.PutUint32(reply[8:], requestID)
+		if _, err := conn.Write(reply); err != nil {
+			return
+		}
+	}
+}
+
+// reply records command and returns its reply, the description of the server for the handshakes.
+func (s *fakeServer) reply(command bson.Raw) []byte {
+	reply := bson.D{{Key: "ok", Value: 1.0}}
+	if name := s.record(command); name == "isMaster" || name == "ismaster" || name == "hello" {
+		reply = bson.D{
+			{Key: "ismaster", Value: true},
+			{Key: "maxBsonObjectSize", Value: 16 * 1024 * 1024},
+			{Key: "maxMessageSizeBytes", Value: 48000000},
+			{Key: "maxWriteBatchSize", Value: 100000},
+			{Key: "minWireVersion", Value: 0},
+			{Key: "maxWireVersion", Value: 8},
+			{Key: "ok", Value: 1.0},
+		}
+	}
+	data, _ := bson.Marshal(reply)
+	return data
+}
+
+func (s *fakeServer) record(command bson.Raw) string {
+	name := commandName(command)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.commands = append(s.commands, name)
+	return name
+}
+
+func (s *fakeServer) received(name string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, command := range s.commands {
+		if command == name {
+			return true
+		}
+	}
+	return false
+}
+
+// selfSignedTLS returns the configurations of a server with a self-signed certificate for
+// 127.0.0.1, and of a client trusting it.
+func selfSignedTLS(t *testing.T) (server, client *tls.Config) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "mongod"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(certificate)
+	server = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	return server, &tls.Config{RootCAs: pool}
+}
+
+func TestReadOnlyClient(t *testing.T) {
+	serverTLS, clientTLS := selfSignedTLS(t)
+	tests := []struct {
+		name   string
+		server *tls.Config
+		client *tls.Config
+	}{
+		{name: "plain"},
+		{name: "tls", server: serverTLS, client: clientTLS},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newFakeServer(t, test.server)
+			opts := options.Client().
+				ApplyURI("mongodb://" + server.listener.Addr().String()).
+				SetDirect(true).
+				SetMaxPoolSize(1).
+				SetServerSelectionTimeout(5 * time.Second).
+				SetCompressors([]string{"zlib"})
+			if test.client != nil {
+				opts.SetTLSConfig(test.client)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			c, err := mongo.Connect(ctx, ReadOnly(opts))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Disconnect(ctx)
+
+			if err := c.Ping(ctx, nil); err != nil {
+				t.Fatalf("the ping was refused: %v", err)
+			}
+			if !server.received("isMaster") || !server.received("ping") {
+				t.Fatalf("the handshake and the ping did not reach the server")
+			}
+
+			collection := c.Database("test").Collection("episodes")
+			_, err = collection.InsertOne(ctx, bson.D{{Key: "title", Value: "pilot"}})
+			var commandErr mongo.CommandError
+			if !errors.As(err, &commandErr) || commandErr.Code != unauthorized {
+				t.Errorf("expected the insert to be refused as unauthorized, got %v", err)
+			}
+			pipeline := mongo.Pipeline{{{Key: "$out", Value: "copy"}}}
+			if _, err := collection.Aggregate(ctx, pipeline); err == nil || !strings.Contains(err.Error(), "$out") {
+				t.Errorf("expected the aggregate with $out to be refused, got %v", err)
+			}
+
+			// Unacknowledged writes expect no reply, the connection must stay usable after them.
+			unacknowledged := c.Database("test").Collection("episodes", options.Collection().SetWriteConcern(writeconcern.New(writeconcern.W(0))))
+			if _, err := unacknowledged.InsertOne(ctx, bson.D{{Key: "title", Value: "pilot"}}); err != nil && !errors.Is(err, mongo.ErrUnacknowledgedWrite) {
+				t.Errorf("unexpected error of the unacknowledged insert: %v", err)
+			}
+			if err := c.Ping(ctx, nil); err != nil {
+				t.Errorf("the ping after the refused commands failed: %v", err)
+			}
+			if server.received("insert") || server.received("aggregate") {
+				t.Errorf("refused commands reached the server")
+			}
+		})
+	}
+}
+
+// msg returns an OP_MSG of requestID with flags and sections, followed by a checksum when the flags
+// have checksumPresent.
+func msg(requestID int32, flags uint32, sections ...[]byte) []byte {
+	b := make([]byte, headerLength+4)
+	binary.LittleEndian.PutUint32(b[4:], uint32(requestID))
+	binary.LittleEndian.PutUint32(b[12:], opMsg)
+	binary.LittleEndian.PutUint32(b[headerLength:], flags)
+	for _, section := range sections {
+		b = append(b, section...)
+	}
+	if flags&checksumPresent != 0 {
+		b = append(b, 0xde, 0xad, 0xbe, 0xef)
+	}
+	binary.LittleEndian.PutUint32(b, uint32(len(b)))
+	return b
+}
+
+// body returns a section of kind 0 holding doc.
+func body(t *testing.T, doc bson.D) []byte {
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append([]byte{0}, data...)
+}
+
+// sequence returns a section of kind 1 holding docs as identifier.
+func sequence(t *testing.T, identifier string, docs ...bson.D) []byte {
+	b := append([]byte{1, 0, 0, 0, 0}, identifier...)
+	b = append(b, 0)
+	for _, doc := range docs {
+		data, err := bson.Marshal(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b = append(b, data...)
+	}
+	binary.LittleEndian.PutUint32(b[1:], uint32(len(b)-1))
+	return b
+}
+
+func TestInspectWireMessage(t *testing.T) {
+	find := bson.D{{Key: "find", Value: "episodes"}, {Key: "$db", Value: "test"}}
+	insert := bson.D{{Key: "insert", Value: "episodes"}, {Key: "$db", Value: "test"}}
+	document := bson.D{{Key: "title", Value: "pilot"}}
+
+	isMaster, err := bson.Marshal(bson.D{{Key: "isMaster", Value: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := func(collection string) []byte {
+		b := make([]byte, headerLength+4)
+		binary.LittleEndian.PutUint32(b[12:], opQuery)
+		b = append(b, collection...)
+		b = append(b, 0, 0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff)
+		b = append(b, isMaster...)
+		binary.LittleEndian.PutUint32(b, uint32(len(b)))
+		return b
+	}
+	truncated := msg(1, 0, body(t, find))
+	binary.LittleEndian.PutUint32(truncated, uint32(len(truncated)+1))
+
+	tests := []struct {
+		name    string
+		message []byte
+		refused bool
+		invalid bool
+	}{
+		{name: "read", message: msg(1, 0, body(t, find))},
+		{name: "write", message: msg(1, 0, body(t, insert)), refused: true},
+		{name: "read with a checksum", message: msg(1, checksumPresent, body(t, find))},
+		{name: "write with a checksum", message: msg(1, checksumPresent, body(t, insert)), refused: true},
+		{name: "write with its documents first", message: msg(1, checksumPresent, sequence(t, "documents", document), body(t, insert)), refused: true},
+		{name: "unacknowledged write", message: msg(1, moreToCome, body(t, insert), sequence(t, "documents", document)), refused: true},
+		{name: "legacy handshake", message: query("admin.$cmd")},
+		{name: "legacy query", message: query("test.episodes"), invalid: true},
+		{name: "without a body", message: msg(1, 0, sequence(t, "documents", document)), invalid: true},
+		{name: "truncated", message: truncated, invalid: true},
+		{name: "unknown opcode", message: func() []byte {
+			b := msg(1, 0, body(t, find))
+			binary.LittleEndian.PutUint32(b[12:], 2002)
+			return b
+		}(), invalid: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			message, err := inspectWireMessage(test.message)
+			if test.invalid {
+				if err == nil {
+					t.Fatal("expected the message to be invalid")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if refused := message.refusal != nil; refused != test.refused {
+				t.Errorf("expected refused to be %t, got %v", test.refused, message.refusal)
+			}
+		})
+	}
+}
+
+func TestErrorReply(t *testing.T) {
+	reply, err := errorReply(42, errors.New("read-only mode: the command insert is not allowed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int(binary.LittleEndian.Uint32(reply)) != len(reply) {
+		t.Fatalf("the length of the reply is %d, it has %d bytes", binary.LittleEndian.Uint32(reply), len(reply))
+	}
+	if responseTo := binary.LittleEndian.Uint32(reply[8:]); responseTo != 42 {
+		t.Errorf("expected the reply to answer the request 42, it answers %d", responseTo)
+	}
+	doc, err := wireDocument(reply, headerLength+5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := doc.Lookup("code").Int32(); code != unauthorized {
+		t.Errorf("expected the code %d, got %d", unauthorized, code)
+	}
+}
@@ -0,0 +1,196 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RedisCache is a CacheBackend storing the values in Redis, shared by the processes using the same
+// server. The values of a namespace are stored under a generation that Invalidate increments, the
+// values of previous generations are left to expire with their TTL or to be evicted by Redis.
+type RedisCache struct {
+	// Addr is the host:port of the server.
+	Addr     string
+	Password string
+	DB       int
+	// Prefix is prepended to the keys, it separates the caches sharing a server.
+	Prefix string
+
+	lock   sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// NewRedisCache returns a backend storing the values in the Redis server at addr.
+func NewRedisCache(addr, password string, db int) *RedisCache {
+	return &RedisCache{Addr: addr, Password: password, DB: db, Prefix: "mongodb-client:"}
+}
+
+func (r *RedisCache) generationKey(namespace string) string {
+	return r.Prefix + namespace + ":generation"
+}
+
+func (r *RedisCache) Get(ctx context.Context, namespace, key string) ([]byte, bool, error) {
+	generation, err := r.do(ctx, "GET", r.generationKey(namespace))
+	if err != nil {
+		return nil, false, err
+	}
+	if generation == nil {
+		generation = []byte("0")
+	}
+	value, err := r.do(ctx, "GET", r.Prefix+namespace+":"+string(generation.([]byte))+":"+key)
+	if err != nil || value == nil {
+		return nil, false, err
+	}
+	return value.([]byte), true, nil
+}
+
+func (r *RedisCache) Set(ctx context.Context, namespace, key string, value []byte, ttl time.Duration) error {
+	generation, err := r.do(ctx, "GET", r.generationKey(namespace))
+	if err != nil {
+		return err
+	}
+	if generation == nil {
+		generation = []byte("0")
+	}
+	args := []string{"SET", r.Prefix + namespace + ":" + string(generation.([]byte)) + ":" + key, string(value)}
+	if ttl > 0 {
+		args = append(args, "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	}
+	_, err = r.do(ctx, args...)
+	return err
+}
+
+func (r *RedisCache) Invalidate(ctx context.Context, namespace string) error {
+	_, err := r.do(ctx, "INCR", r.generationKey(namespace))
+	return err
+}
+
+// Close closes the connection to the server.
+func (r *RedisCache) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.conn == nil {
+		return nil
+	}
+	err := r.conn.Close()
+	r.conn = nil
+	return err
+}
+
+// do sends a command on the connection to the server, opened on first use and reopened after any
+// failure, and returns its reply: nil, a []byte, an int64 or a []interface{}.
+func (r *RedisCache) do(ctx context.Context, args ...string) (interface{}, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.conn == nil {
+		if err := r.dial(ctx); err != nil {
+			return nil, fmt.Errorf("unable to connect to redis at %s: %w", r.Addr, err)
+		}
+	}
+	reply, err := r.roundTrip(ctx, args...)
+	var redisErr redisError
+	if err != nil && !errors.As(err, &redisErr) {
+		r.conn.Close()
+		r.conn = nil
+	}
+	return reply, err
+}
+
+func (r *RedisCache) dial(ctx context.Context) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", r.Addr)
+	if err != nil {
+		return err
+	}
+	r.conn, r.reader = conn, bufio.NewReader(conn)
+	if len(r.Password) > 0 {
+		if _, err := r.roundTrip(ctx, "AUTH", r.Password); err != nil {
+			r.conn.Close()
+			r.conn = nil
+			return err
+		}
+	}
+	if r.DB != 0 {
+		if _, err := r.roundTrip(ctx, "SELECT", strconv.Itoa(r.DB)); err != nil {
+			r.conn.Close()
+			r.conn = nil
+			return err
+		}
+	}
+	return nil
+}
+
+// redisError is an error reply of the server, after which the connection remains usable.
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+func (r *RedisCache) roundTrip(ctx context.Context, args ...string) (interface{}, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(DefaultOperationTimeout)
+	}
+	r.conn.SetDeadline(deadline)
+	var command strings.Builder
+	fmt.Fprintf(&command, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&command, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(r.conn, command.String()); err != nil {
+		return nil, err
+	}
+	return r.readReply()
+}
+
+func (r *RedisCache) readReply() (interface{}, error) {
+	line, err := r.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, fmt.Errorf("invalid redis reply %q", line)
+	}
+	kind, rest := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return []byte(rest), nil
+	case '-':
+		return nil, redisError(rest)
+	case ':':
+		return strconv.ParseInt(rest, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(rest)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(r.reader, data); err != nil {
+			return nil, err
+		}
+		return data[:n], nil
+	case '*':
+		n, err := strconv.Atoi(rest)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		values := make([]interface{}, n)
+		for i := range values {
+			if values[i], err = r.readReply(); err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("invalid redis reply %q", line)
+}
@@ -0,0 +1,144 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+	"k8s.io/klog"
+)
+
+// RetryPolicy controls how many times, and how far apart, a failing operation is retried.
+type RetryPolicy struct {
+	// Attempts is the total number of times the operation is tried, including the first.
+	Attempts int
+	// Backoff is the delay before the first retry; it doubles after each subsequent failure.
+	Backoff time.Duration
+	// MaxBackoff caps the delay between two attempts.
+	MaxBackoff time.Duration
+	// Jitter adds up to Jitter*delay of random delay to every retry.
+	Jitter float64
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	Attempts:   5,
+	Backoff:    500 * time.Millisecond,
+	MaxBackoff: 30 * time.Second,
+	Jitter:     0.2,
+}
+
+// Server error codes that indicate a transient condition, such as a replica set election in progress.
+var retryableCodes = []int{
+	6,     // HostUnreachable
+	7,     // HostNotFound
+	89,    // NetworkTimeout
+	91,    // ShutdownInProgress
+	112,   // WriteConflict
+	189,   // PrimarySteppedDown
+	262,   // ExceededTimeLimit
+	9001,  // SocketException
+	10107, // NotWritablePrimary
+	11600, // InterruptedAtShutdown
+	11602, // InterruptedDueToReplStateChange
+	13435, // NotPrimaryNoSecondaryOk
+	13436, // NotPrimaryOrSecondary
+}
+
+var retryableLabels = []string{
+	"RetryableWriteError",
+	"TransientTransactionError",
+}
+
+// RetryableError marks an error returned by an operation as safe to retry.
+type RetryableError struct {
+	Err error
+}
+
+func (e RetryableError) Error() string {
+	return e.Err.Error()
+}
+
+func (e RetryableError) Unwrap() error {
+	return e.Err
+}
+
+// IsRetryable reports whether err is a transient driver error that is expected to succeed if
+// the operation is attempted again.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var retryable RetryableError
+	if errors.As(err, &retryable) {
+		return true
+	}
+	if mongo.IsNetworkError(err) {
+		return true
+	}
+	var selection topology.ServerSelectionError
+	if errors.As(err, &selection) {
+		return true
+	}
+	var serverErr mongo.ServerError
+	if errors.As(err, &serverErr) {
+		for _, label := range retryableLabels {
+			if serverErr.HasErrorLabel(label) {
+				return true
+			}
+		}
+		for _, code := range retryableCodes {
+			if serverErr.HasErrorCode(code) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Retry runs fn until it succeeds, returns an error that is not retryable, the policy's attempts
+// are exhausted, or ctx is done.
+func Retry(ctx context.Context, policy RetryPolicy, fn func(ctx context.Context) error) error {
+	if policy.Attempts <= 0 {
+		policy.Attempts = 1
+	}
+	delay := policy.Backoff
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = fn(ctx); err == nil {
+			return nil
+		}
+		if !IsRetryable(err) {
+			return err
+		}
+		if attempt >= policy.Attempts {
+			return fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+		}
+
+		sleep := delay
+		if policy.Jitter > 0 {
+			sleep += time.Duration(rand.Float64() * policy.Jitter * float64(delay))
+		}
+		klog.V(2).Infof("Retryable error on attempt %d/%d, retrying in %s: %v", attempt, policy.Attempts, sleep, err)
+
+		timer := time.NewTimer(sleep)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+
+		delay *= 2
+		if policy.MaxBackoff > 0 && delay > policy.MaxBackoff {
+			delay = policy.MaxBackoff
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CausalToken is the cluster time and operation time of a causally consistent session, which let a
+// later session, possibly of another process, read its own writes and never read older data than
+// the session did, on any member of the deployment.
+type CausalToken struct {
+	ClusterTime   bson.Raw            `bson:"clusterTime"`
+	OperationTime primitive.Timestamp `bson:"operationTime"`
+}
+
+// SessionToken returns the causal token of session, nil before its first operation.
+func SessionToken(session mongo.Session) *CausalToken {
+	clusterTime, operationTime := session.ClusterTime(), session.OperationTime()
+	if clusterTime == nil || operationTime == nil {
+		return nil
+	}
+	return &CausalToken{ClusterTime: clusterTime, OperationTime: *operationTime}
+}
+
+// String encodes the token as URL-safe base64 BSON, to be passed in headers.
+func (t *CausalToken) String() string {
+	data, err := bson.Marshal(t)
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// ParseCausalToken decodes a token encoded by String.
+func ParseCausalToken(value string) (*CausalToken, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid causal token: %w", err)
+	}
+	var token CausalToken
+	if err := bson.Unmarshal(data, &token); err != nil {
+		return nil, fmt.Errorf("invalid causal token: %w", err)
+	}
+	if token.ClusterTime == nil || token.OperationTime.IsZero() {
+		return nil, fmt.Errorf("invalid causal token: the cluster time and the operation time are required")
+	}
+	return &token, nil
+}
+
+// WithSession runs fn in a causally consistent session of c, which first advances to after when it
+// is not nil, and returns the token of the session once fn returns. The operations of fn must use
+// sess as their context. The reads of the session see its writes, and the writes of the sessions it
+// advanced to, even on secondaries, provided they use the majority read and write concerns.
+func (m *ConnectionManager) WithSession(ctx context.Context, after *CausalToken, fn func(sess mongo.SessionContext) error) (*CausalToken, error) {
+	return WithSession(ctx, m.primary, after, fn)
+}
+
+// WithSession is ConnectionManager.WithSession for the client c.
+func WithSession(ctx context.Context, c *mongo.Client, after *CausalToken, fn func(sess mongo.SessionContext) error) (*CausalToken, error) {
+	session, err := c.StartSession(options.Session().SetCausalConsistency(true))
+	if err != nil {
+		return nil, fmt.Errorf("unable to start a session: %w", err)
+	}
+	defer session.EndSession(context.Background())
+	if after != nil {
+		if err := session.AdvanceClusterTime(after.ClusterTime); err != nil {
+			return nil, fmt.Errorf("invalid causal token: %w", err)
+		}
+		if err := session.AdvanceOperationTime(&after.OperationTime); err != nil {
+			return nil, fmt.Errorf("invalid causal token: %w", err)
+		}
+	}
+	err = mongo.WithSession(ctx, session, fn)
+	token := SessionToken(session)
+	if token == nil {
+		token = after
+	}
+	return token, err
+}
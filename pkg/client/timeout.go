@@ -0,0 +1,78 @@
+package client
+
+import (
+	"context"
+	"time"
+)
+
+// OperationKind classifies operations by the time they are expected to take.
+type OperationKind int
+
+const (
+	// ReadOperation is a query, a count or a lookup of a document.
+	ReadOperation OperationKind = iota
+	// WriteOperation is an insert, an update or a delete.
+	WriteOperation
+	// AggregateOperation is an aggregation, which may scan whole collections.
+	AggregateOperation
+	// AdminOperation is an administrative command, such as an index build or a replica set change.
+	AdminOperation
+)
+
+func (k OperationKind) String() string {
+	switch k {
+	case ReadOperation:
+		return "read"
+	case WriteOperation:
+		return "write"
+	case AggregateOperation:
+		return "aggregate"
+	case AdminOperation:
+		return "admin"
+	}
+	return "unknown"
+}
+
+// Timeouts bound the operations of each kind, the zero ones are bounded by the operation timeout.
+type Timeouts struct {
+	Read      time.Duration
+	Write     time.Duration
+	Aggregate time.Duration
+	Admin     time.Duration
+}
+
+// Timeout returns the timeout of the operations of kind, fallback when it is not set.
+func (t Timeouts) Timeout(kind OperationKind, fallback time.Duration) time.Duration {
+	var timeout time.Duration
+	switch kind {
+	case ReadOperation:
+		timeout = t.Read
+	case WriteOperation:
+		timeout = t.Write
+	case AggregateOperation:
+		timeout = t.Aggregate
+	case AdminOperation:
+		timeout = t.Admin
+	}
+	if timeout <= 0 {
+		return fallback
+	}
+	return timeout
+}
+
+// MaxTime returns the time left before the deadline of ctx, to be set as the MaxTime option of a
+// query or an aggregation: the server then stops working on the operation once the client gave up
+// on it, instead of holding its resources until it completes. It is nil, no limit, when ctx has no
+// deadline.
+func MaxTime(ctx context.Context) *time.Duration {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return nil
+	}
+	// A deadline that passed would round to a maxTimeMS of zero, which the server reads as no limit.
+	left := time.Until(deadline)
+	if left < time.Millisecond {
+		left = time.Millisecond
+	}
+	return &left
+}
@@ -0,0 +1,74 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"k8s.io/klog"
+)
+
+const (
+	transientTransactionError      = "TransientTransactionError"
+	unknownTransactionCommitResult = "UnknownTransactionCommitResult"
+)
+
+// Txn runs fn in a transaction on the primary client, retried according to the manager's retry
+// policy.
+func (m *ConnectionManager) Txn(ctx context.Context, fn func(sess mongo.SessionContext) error) error {
+	return Txn(ctx, m.primary, m.config.Retry, fn)
+}
+
+// Txn runs fn in a transaction of a new session of c and commits it. The transaction is run again
+// when it fails with a TransientTransactionError, and the commit is retried when its outcome is
+// unknown (UnknownTransactionCommitResult), up to policy.Attempts times each. As fn may run several
+// times, its writes must all go through sess. Transactions require a replica set or a sharded
+// cluster.
+func Txn(ctx context.Context, c *mongo.Client, policy RetryPolicy, fn func(sess mongo.SessionContext) error) error {
+	if policy.Attempts <= 0 {
+		policy.Attempts = 1
+	}
+	session, err := c.StartSession()
+	if err != nil {
+		return fmt.Errorf("unable to start a session: %w", err)
+	}
+	defer session.EndSession(context.Background())
+
+	for attempt := 1; ; attempt++ {
+		err = mongo.WithSession(ctx, session, func(sess mongo.SessionContext) error {
+			if err := session.StartTransaction(); err != nil {
+				return err
+			}
+			if err := fn(sess); err != nil {
+				// The error of fn matters more than a failure to abort, which the server times out.
+				session.AbortTransaction(context.Background())
+				return err
+			}
+			return commit(sess, session, policy.Attempts)
+		})
+		if err == nil || !hasErrorLabel(err, transientTransactionError) || ctx.Err() != nil {
+			return err
+		}
+		if attempt >= policy.Attempts {
+			return fmt.Errorf("giving up on the transaction after %d attempts: %w", attempt, err)
+		}
+		klog.V(2).Infof("Transient transaction error on attempt %d/%d, retrying: %v", attempt, policy.Attempts, err)
+	}
+}
+
+// commit commits the transaction of session, retrying up to attempts times while its outcome is unknown.
+func commit(ctx context.Context, session mongo.Session, attempts int) error {
+	for attempt := 1; ; attempt++ {
+		err := session.CommitTransaction(ctx)
+		if err == nil || !hasErrorLabel(err, unknownTransactionCommitResult) || ctx.Err() != nil || attempt >= attempts {
+			return err
+		}
+		klog.V(2).Infof("Unknown transaction commit result on attempt %d/%d, retrying: %v", attempt, attempts, err)
+	}
+}
+
+func hasErrorLabel(err error, label string) bool {
+	var serverErr mongo.ServerError
+	return errors.As(err, &serverErr) && serverErr.HasErrorLabel(label)
+}
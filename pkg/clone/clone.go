@@ -0,0 +1,417 @@
+// Package clone copies collections between deployments, splitting each of them into ranges of _id
+// that are read and written by parallel workers.
+package clone
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/archive"
+	"github.com/bradmwilliams/mongodb-client/pkg/bulk"
+	"github.com/bradmwilliams/mongodb-client/pkg/client"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"k8s.io/klog"
+)
+
+// codeDuplicateKey is returned by the server for documents whose _id already exists.
+const codeDuplicateKey = 11000
+
+const (
+	// samplesPerPartition is the number of _id values sampled per partition to place their bounds.
+	samplesPerPartition = 20
+	// minPartitionSize is the number of documents under which a collection is not split.
+	minPartitionSize = 10000
+)
+
+// Options select the collections to copy and how they are written.
+type Options struct {
+	// Database is the source database.
+	Database string
+	// Collections restricts the copy to these collections of Database, all of them when empty.
+	Collections []string
+	// ToDatabase is the destination database, Database when empty.
+	ToDatabase string
+	// Filter restricts the copied documents.
+	Filter bson.D
+	// Workers is the number of ranges of each collection copied in parallel, 4 when it is not set.
+	Workers int
+	// BatchSize is the number of documents read and inserted per request, 1000 when it is not set.
+	BatchSize int
+	// Drop drops each destination collection before copying it. Otherwise the documents whose _id
+	// already exists are skipped and counted as duplicates.
+	Drop bool
+	// CheckDrop, when it is set, is called before each destination collection is dropped, the copy
+	// fails without dropping it when it returns an error.
+	CheckDrop func(database, collection string) error
+	// NoIndexes skips creating the indexes of the source collections.
+	NoIndexes bool
+	// Progress is called with the progress of the collection being copied every ProgressInterval,
+	// 5 seconds when it is not set, and once it is copied.
+	Progress         func(Progress)
+	ProgressInterval time.Duration
+	// Transform changes every document before it is written, such as to mask its sensitive fields,
+	// when it is set. It is called with the source namespace of the document and must keep its _id.
+	Transform func(namespace archive.Namespace, doc bson.Raw) (bson.Raw, error)
+}
+
+// Progress is the progress of the copy of a collection.
+type Progress struct {
+	Namespace archive.Namespace
+	Copied    int64
+	// Total is the estimated number of documents to copy.
+	Total   int64
+	Elapsed time.Duration
+}
+
+// Rate returns the number of documents copied per second.
+func (p Progress) Rate() float64 {
+	if p.Elapsed <= 0 {
+		return 0
+	}
+	return float64(p.Copied) / p.Elapsed.Seconds()
+}
+
+// Remaining estimates the time left to copy the collection at the current rate, 0 when unknown.
+func (p Progress) Remaining() time.Duration {
+	rate := p.Rate()
+	if rate == 0 || p.Total <= p.Copied {
+		return 0
+	}
+	return time.Duration(float64(p.Total-p.Copied) / rate * float64(time.Second))
+}
+
+// Result counts the documents copied to a collection.
+type Result struct {
+	Source      archive.Namespace
+	Destination archive.Namespace
+	Documents   int64
+	Duplicates  int64
+	Indexes     int
+}
+
+// Copy copies the selected collections and views of source to destination, with their options and
+// indexes. The collections are copied one after the other and the views last, since they may be
+// defined on the copied collections.
+func Copy(ctx context.Context, source, destination *mongo.Client, opts Options) ([]Result, error) {
+	if opts.Workers < 1 {
+		opts.Workers = 4
+	}
+	if opts.BatchSize < 1 {
+		opts.BatchSize = 1000
+	}
+	if opts.ProgressInterval <= 0 {
+		opts.ProgressInterval = 5 * time.Second
+	}
+	if len(opts.ToDatabase) == 0 {
+		opts.ToDatabase = opts.Database
+	}
+
+	filter := bson.D{}
+	if len(opts.Collections) > 0 {
+		filter = append(filter, bson.E{Key: "name", Value: bson.D{{Key: "$in", Value: opts.Collections}}})
+	}
+	specifications, err := source.Database(opts.Database).ListCollectionSpecifications(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the collections of %s: %w", opts.Database, err)
+	}
+	if len(opts.Collections) > 0 && len(specifications) < len(opts.Collections) {
+		found := map[string]bool{}
+		for _, specification := range specifications {
+			found[specification.Name] = true
+		}
+		for _, name := range opts.Collections {
+			if !found[name] {
+				return nil, fmt.Errorf("%s.%s does not exist", opts.Database, name)
+			}
+		}
+	}
+
+	var results []Result
+	var views []*mongo.CollectionSpecification
+	for _, specification := range specifications {
+		if strings.HasPrefix(specification.Name, "system.") || specification.Name == CutoverCollection {
+			continue
+		}
+		switch specification.Type {
+		case "view":
+			views = append(views, specification)
+			continue
+		case "collection", "":
+		default:
+			klog.Warningf("Skipping %s.%s, collections of type %s cannot be copied", opts.Database, specification.Name, specification.Type)
+			continue
+		}
+		result, err := copyCollection(ctx, source, destination, specification, opts)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, result)
+	}
+	for _, view := range views {
+		if err := create(ctx, destination, view, opts); err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+// create creates the destination of a collection or view with the options of its source.
+func create(ctx context.Context, destination *mongo.Client, specification *mongo.CollectionSpecification, opts Options) error {
+	target := destination.Database(opts.ToDatabase)
+	if opts.Drop {
+		if opts.CheckDrop != nil {
+			if err := opts.CheckDrop(target.Name(), specification.Name); err != nil {
+				return err
+			}
+		}
+		if err := target.Collection(specification.Name).Drop(ctx); err != nil {
+			return fmt.Errorf("unable to drop %s.%s: %w", target.Name(), specification.Name, err)
+		}
+	}
+	var collectionOptions bson.D
+	if specification.Options != nil {
+		if err := bson.Unmarshal(specification.Options, &collectionOptions); err != nil {
+			return fmt.Errorf("invalid options for %s.%s: %w", opts.Database, specification.Name, err)
+		}
+	}
+	return archive.CreateCollection(ctx, target, specification.Name, collectionOptions)
+}
+
+func copyCollection(ctx context.Context, source, destination *mongo.Client, specification *mongo.CollectionSpecification, opts Options) (Result, error) {
+	from := source.Database(opts.Database).Collection(specification.Name)
+	to := destination.Database(opts.ToDatabase).Collection(specification.Name)
+	result := Result{
+		Source:      archive.Namespace{Database: opts.Database, Collection: specification.Name},
+		Destination: archive.Namespace{Database: opts.ToDatabase, Collection: specification.Name},
+	}
+	if err := create(ctx, destination, specification, opts); err != nil {
+		return result, err
+	}
+
+	var total int64
+	var err error
+	if len(opts.Filter) == 0 {
+		total, err = from.EstimatedDocumentCount(ctx)
+	} else {
+		total, err = from.CountDocuments(ctx, opts.Filter)
+	}
+	if err != nil {
+		return result, fmt.Errorf("unable to count the documents of %s: %w", result.Source, err)
+	}
+	ranges, err := partitions(ctx, from, opts.Workers, total)
+	if err != nil {
+		return result, fmt.Errorf("unable to partition %s: %w", result.Source, err)
+	}
+	klog.V(2).Infof("Copying about %d documents of %s in %d ranges", total, result.Source, len(ranges))
+
+	var copied int64
+	started := time.Now()
+	progress := func() Progress {
+		return Progress{Namespace: result.Source, Copied: atomic.LoadInt64(&copied), Total: total, Elapsed: time.Since(started)}
+	}
+	done := make(chan struct{})
+	var reporting sync.WaitGroup
+	if opts.Progress != nil {
+		reporting.Add(1)
+		go func() {
+			defer reporting.Done()
+			ticker := time.NewTicker(opts.ProgressInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+					opts.Progress(progress())
+				}
+			}
+		}()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	work := make(chan bson.D, len(ranges))
+	for _, r := range ranges {
+		work <- r
+	}
+	close(work)
+	var (
+		lock     sync.Mutex
+		workers  sync.WaitGroup
+		firstErr error
+		counts   bulk.Result
+	)
+	for i := 0; i < opts.Workers && i < len(ranges); i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for r := range work {
+				written, err := copyRange(ctx, from, to, and(opts.Filter, r), opts, &copied)
+				lock.Lock()
+				counts.Add(written)
+				if err != nil && firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+	workers.Wait()
+	close(done)
+	reporting.Wait()
+	result.Documents = counts.Inserted
+	result.Duplicates = int64(len(counts.Failures))
+	if firstErr != nil {
+		return result, fmt.Errorf("unable to copy %s: %w", result.Source, firstErr)
+	}
+	if opts.Progress != nil {
+		opts.Progress(progress())
+	}
+
+	if !opts.NoIndexes {
+		indexes, err := listIndexes(ctx, from)
+		if err != nil {
+			return result, err
+		}
+		if err := archive.CreateIndexes(ctx, to, indexes); err != nil {
+			return result, err
+		}
+		for _, index := range indexes {
+			if name, _ := index.Map()["name"].(string); name != "_id_" {
+				result.Indexes++
+			}
+		}
+	}
+	return result, nil
+}
+
+// copyRange inserts the documents of from matching filter into to, adding the number of documents
+// read to copied. Documents whose _id already exists are skipped and returned as failures.
+func copyRange(ctx context.Context, from, to *mongo.Collection, filter bson.D, opts Options, copied *int64) (bulk.Result, error) {
+	writer := bulk.NewWriter(to, bulk.Options{BatchSize: opts.BatchSize})
+	cursor, err := from.Find(ctx, filter, options.Find().SetBatchSize(int32(opts.BatchSize)))
+	if err != nil {
+		return writer.Result(), err
+	}
+	namespace := archive.Namespace{Database: from.Database().Name(), Collection: from.Name()}
+	err = client.Each(ctx, cursor, func(doc bson.Raw) error {
+		// The writer holds on to the document until its batch is sent, while the cursor reuses it.
+		doc = append(bson.Raw(nil), doc...)
+		if opts.Transform != nil {
+			var err error
+			if doc, err = opts.Transform(namespace, doc); err != nil {
+				return err
+			}
+		}
+		if err := ignoreDuplicates(writer.Add(ctx, mongo.NewInsertOneModel().SetDocument(doc))); err != nil {
+			return err
+		}
+		atomic.AddInt64(copied, 1)
+		return nil
+	})
+	if err != nil {
+		return writer.Result(), err
+	}
+	return writer.Result(), ignoreDuplicates(writer.Flush(ctx))
+}
+
+// ignoreDuplicates returns err unless it only reports documents whose _id already exists.
+func ignoreDuplicates(err error) error {
+	var failed *bulk.Error
+	if errors.As(err, &failed) && failed.Only(codeDuplicateKey) {
+		return nil
+	}
+	return err
+}
+
+// partitions returns filters splitting the documents of collection into about n ranges of _id of
+// similar size, placed from a sample of the _id values. As range queries only match values of the
+// BSON type of their bounds, the ranges are limited to the type of the sampled values and one more
+// filter matches the documents whose _id has another type.
+func partitions(ctx context.Context, collection *mongo.Collection, n int, count int64) ([]bson.D, error) {
+	if n <= 1 || count < minPartitionSize {
+		return []bson.D{{}}, nil
+	}
+	cursor, err := collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$sample", Value: bson.D{{Key: "size", Value: n * samplesPerPartition}}}},
+		{{Key: "$project", Value: bson.D{{Key: "_id", Value: 1}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var ids []bson.RawValue
+	err = client.Each(ctx, cursor, func(doc bson.Raw) error {
+		ids = append(ids, append(bson.Raw(nil), doc...).Lookup("_id"))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) < n {
+		return []bson.D{{}}, nil
+	}
+	idType := ids[0].Type
+	for _, id := range ids {
+		if id.Type != idType {
+			klog.V(2).Infof("Not partitioning %s, its _id values have several types", collection.Name())
+			return []bson.D{{}}, nil
+		}
+	}
+
+	var bounds []bson.RawValue
+	for i := 1; i < n; i++ {
+		bound := ids[i*len(ids)/n]
+		if len(bounds) > 0 && bytes.Equal(bounds[len(bounds)-1].Value, bound.Value) {
+			continue
+		}
+		bounds = append(bounds, bound)
+	}
+	ofType := bson.E{Key: "$type", Value: int32(idType)}
+	var filters []bson.D
+	for i := 0; i <= len(bounds); i++ {
+		condition := bson.D{ofType}
+		if i > 0 {
+			condition = append(condition, bson.E{Key: "$gte", Value: bounds[i-1]})
+		}
+		if i < len(bounds) {
+			condition = append(condition, bson.E{Key: "$lt", Value: bounds[i]})
+		}
+		filters = append(filters, bson.D{{Key: "_id", Value: condition}})
+	}
+	filters = append(filters, bson.D{{Key: "_id", Value: bson.D{{Key: "$not", Value: bson.D{ofType}}}}})
+	return filters, nil
+}
+
+// and returns a filter matching the documents matched by both a and b.
+func and(a, b bson.D) bson.D {
+	switch {
+	case len(a) == 0:
+		return b
+	case len(b) == 0:
+		return a
+	}
+	return bson.D{{Key: "$and", Value: bson.A{a, b}}}
+}
+
+func listIndexes(ctx context.Context, collection *mongo.Collection) ([]bson.D, error) {
+	cursor, err := collection.Indexes().List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the indexes of %s: %w", collection.Name(), err)
+	}
+	var indexes []bson.D
+	err = client.Decode(ctx, cursor, func() interface{} { return &bson.D{} }, func(value interface{}) error {
+		indexes = append(indexes, *value.(*bson.D))
+		return nil
+	})
+	return indexes, err
+}
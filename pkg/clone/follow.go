@@ -0,0 +1,318 @@
+package clone
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/archive"
+	"github.com/bradmwilliams/mongodb-client/pkg/bulk"
+	"github.com/bradmwilliams/mongodb-client/pkg/changestream"
+	"github.com/bradmwilliams/mongodb-client/pkg/client"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"k8s.io/klog"
+)
+
+// CutoverCollection is the collection of the source database in which Cutover records its
+// requests. It is never copied.
+const CutoverCollection = "mongodb_client_cutover"
+
+// errCutover stops the consumer of a Follower once a cutover request has been applied.
+var errCutover = errors.New("cutover")
+
+// Follower copies the collections of a database and then applies the changes made to them until a
+// cutover is requested, so that the source can be replaced by the destination with a short pause of
+// the writes. The lag of the destination is exported by the change stream metrics of the consumer
+// Name.
+type Follower struct {
+	// Name identifies the follower in the token store and the metrics.
+	Name        string
+	Source      *mongo.Client
+	Destination *mongo.Client
+	// Options select the collections, the Filter is not supported as changes cannot be filtered
+	// consistently.
+	Options Options
+	// Store persists the position in the change stream of the source. When it holds one, the copy is
+	// skipped and the changes are applied from there.
+	Store changestream.TokenStore
+	// Resync copies the collections again even when the store holds a position.
+	Resync bool
+	Retry  client.RetryPolicy
+
+	lock        sync.Mutex
+	applied     int64
+	clusterTime primitive.Timestamp
+}
+
+// Applied returns the number of changes applied so far and the cluster time of the last one.
+func (f *Follower) Applied() (int64, primitive.Timestamp) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.applied, f.clusterTime
+}
+
+// Run copies the collections unless the store holds a position, then applies the changes until ctx
+// is cancelled, an error occurs or a cutover request is applied, in which case it returns nil.
+func (f *Follower) Run(ctx context.Context) ([]Result, error) {
+	if len(f.Options.Filter) > 0 {
+		return nil, errors.New("changes cannot be followed for a filtered copy")
+	}
+	if f.Store == nil {
+		f.Store = changestream.NewMemoryStore()
+	}
+	if len(f.Options.ToDatabase) == 0 {
+		f.Options.ToDatabase = f.Options.Database
+	}
+	if f.Options.BatchSize < 1 {
+		f.Options.BatchSize = 1000
+	}
+	source := f.Source.Database(f.Options.Database)
+
+	token, err := f.Store.Load(ctx, f.Name)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load the resume token of %s: %w", f.Name, err)
+	}
+	var results []Result
+	if token == nil || f.Resync {
+		// The position is taken before the copy starts, so that the changes made during the copy are
+		// applied afterwards. Applying them again to the documents already copied is harmless.
+		stream, err := source.Watch(ctx, []bson.D{})
+		if err != nil {
+			return nil, fmt.Errorf("unable to open the change stream of %s: %w", source.Name(), err)
+		}
+		start := stream.ResumeToken()
+		stream.Close(ctx)
+		if start == nil {
+			return nil, fmt.Errorf("the server did not return a resume token for %s", source.Name())
+		}
+		if results, err = Copy(ctx, f.Source, f.Destination, f.Options); err != nil {
+			return results, err
+		}
+		if err := f.Store.Save(ctx, f.Name, start); err != nil {
+			return results, fmt.Errorf("unable to save the resume token of %s: %w", f.Name, err)
+		}
+	} else {
+		klog.Infof("Resuming %s, the collections are not copied again", f.Name)
+	}
+
+	var pipeline []bson.D
+	if len(f.Options.Collections) > 0 {
+		collections := append([]string{CutoverCollection}, f.Options.Collections...)
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: bson.D{{Key: "$or", Value: bson.A{
+			bson.D{{Key: "ns.coll", Value: bson.D{{Key: "$in", Value: collections}}}},
+			// Database level events such as dropDatabase have no collection.
+			bson.D{{Key: "ns.coll", Value: bson.D{{Key: "$exists", Value: false}}}},
+		}}}}})
+	}
+	consumer := &changestream.Consumer{
+		Name:      f.Name,
+		Watcher:   source,
+		Pipeline:  pipeline,
+		Options:   options.ChangeStream().SetFullDocument(options.UpdateLookup),
+		Store:     f.Store,
+		BatchSize: f.Options.BatchSize,
+		Retry:     f.Retry,
+	}
+	err = consumer.RunBatches(ctx, f.apply)
+	if errors.Is(err, errCutover) {
+		return results, nil
+	}
+	return results, err
+}
+
+type changeEvent struct {
+	OperationType string              `bson:"operationType"`
+	ClusterTime   primitive.Timestamp `bson:"clusterTime"`
+	Namespace     struct {
+		Collection string `bson:"coll"`
+	} `bson:"ns"`
+	To struct {
+		Database   string `bson:"db"`
+		Collection string `bson:"coll"`
+	} `bson:"to"`
+	DocumentKey  bson.Raw `bson:"documentKey"`
+	FullDocument bson.Raw `bson:"fullDocument"`
+}
+
+// apply applies the events in order, writing the consecutive document changes of a collection at
+// once.
+func (f *Follower) apply(ctx context.Context, events []bson.Raw) error {
+	destination := f.Destination.Database(f.Options.ToDatabase)
+	// Replacements and deletes by _id are idempotent, they can be retried.
+	writeOptions := bulk.Options{Ordered: true, BatchSize: f.Options.BatchSize, Retry: func(ctx context.Context, fn func(ctx context.Context) error) error {
+		return client.Retry(ctx, f.Retry, fn)
+	}}
+	var pending []mongo.WriteModel
+	var pendingCollection string
+	flush := func() error {
+		if len(pending) == 0 {
+			return nil
+		}
+		_, err := bulk.Write(ctx, destination.Collection(pendingCollection), pending, writeOptions)
+		if err != nil {
+			return fmt.Errorf("unable to apply the changes of %s.%s: %w", f.Options.Database, pendingCollection, err)
+		}
+		pending = pending[:0]
+		return nil
+	}
+
+	for i, raw := range events {
+		var event changeEvent
+		if err := bson.Unmarshal(raw, &event); err != nil {
+			return fmt.Errorf("invalid change event: %w", err)
+		}
+		collection := event.Namespace.Collection
+		if collection != pendingCollection {
+			if err := flush(); err != nil {
+				return err
+			}
+			pendingCollection = collection
+		}
+		var model mongo.WriteModel
+		switch event.OperationType {
+		case "insert", "update", "replace":
+			if collection == CutoverCollection {
+				if event.OperationType != "insert" {
+					continue
+				}
+				if err := flush(); err != nil {
+					return err
+				}
+				f.count(int64(i), event.ClusterTime)
+				return f.cutover(ctx, event.DocumentKey)
+			}
+			// An update whose document no longer exists is followed by its delete.
+			if event.FullDocument == nil {
+				continue
+			}
+			document := event.FullDocument
+			if f.Options.Transform != nil {
+				var err error
+				if document, err = f.Options.Transform(archive.Namespace{Database: f.Options.Database, Collection: collection}, document); err != nil {
+					return err
+				}
+			}
+			model = mongo.NewReplaceOneModel().SetFilter(idFilter(event.DocumentKey)).SetReplacement(document).SetUpsert(true)
+		case "delete":
+			model = mongo.NewDeleteOneModel().SetFilter(idFilter(event.DocumentKey))
+		case "drop", "rename":
+			// The pending changes of the collection precede it.
+			if err := flush(); err != nil {
+				return err
+			}
+			if err := f.applyDDL(ctx, destination, event); err != nil {
+				return err
+			}
+			continue
+		default:
+			// dropDatabase is followed by an invalidate, other events do not affect documents.
+			continue
+		}
+		pending = append(pending, model)
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	var last changeEvent
+	if len(events) > 0 {
+		if err := bson.Unmarshal(events[len(events)-1], &last); err != nil {
+			return fmt.Errorf("invalid change event: %w", err)
+		}
+	}
+	f.count(int64(len(events)), last.ClusterTime)
+	return nil
+}
+
+func (f *Follower) count(applied int64, clusterTime primitive.Timestamp) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.applied += applied
+	if !clusterTime.IsZero() {
+		f.clusterTime = clusterTime
+	}
+}
+
+// cutover acknowledges the cutover request with key on the source, every change made before it
+// being applied.
+func (f *Follower) cutover(ctx context.Context, key bson.Raw) error {
+	requests := f.Source.Database(f.Options.Database).Collection(CutoverCollection)
+	_, err := requests.UpdateOne(ctx, idFilter(key), bson.D{{Key: "$set", Value: bson.D{{Key: "appliedAt", Value: time.Now()}}}})
+	if err != nil {
+		return fmt.Errorf("unable to acknowledge the cutover: %w", err)
+	}
+	klog.Infof("Cutover requested, every change of %s has been applied", f.Options.Database)
+	return errCutover
+}
+
+// applyDDL drops or renames a collection of the destination. A collection renamed out of the copied
+// database is dropped.
+func (f *Follower) applyDDL(ctx context.Context, destination *mongo.Database, event changeEvent) error {
+	collection := event.Namespace.Collection
+	if event.OperationType == "rename" && event.To.Database == f.Options.Database {
+		return rename(ctx, destination, collection, event.To.Collection)
+	}
+	if f.Options.CheckDrop != nil {
+		if err := f.Options.CheckDrop(destination.Name(), collection); err != nil {
+			return err
+		}
+	}
+	if err := destination.Collection(collection).Drop(ctx); err != nil {
+		return fmt.Errorf("unable to drop %s.%s: %w", destination.Name(), collection, err)
+	}
+	return nil
+}
+
+// idFilter returns the filter matching the document of a change event by its _id. The document key
+// may also hold the shard key of the source, which the destination may not share.
+func idFilter(key bson.Raw) bson.D {
+	return bson.D{{Key: "_id", Value: key.Lookup("_id")}}
+}
+
+func rename(ctx context.Context, database *mongo.Database, from, to string) error {
+	if from == to {
+		return nil
+	}
+	command := bson.D{
+		{Key: "renameCollection", Value: database.Name() + "." + from},
+		{Key: "to", Value: database.Name() + "." + to},
+		{Key: "dropTarget", Value: true},
+	}
+	if err := database.Client().Database("admin").RunCommand(ctx, command).Err(); err != nil {
+		return fmt.Errorf("unable to rename %s.%s to %s: %w", database.Name(), from, to, err)
+	}
+	return nil
+}
+
+// Cutover requests the followers of database on source to stop once they have applied every change
+// made before the request, and waits until one of them has. The writes to the source should be
+// stopped first.
+func Cutover(ctx context.Context, source *mongo.Client, database string, interval time.Duration) error {
+	requests := source.Database(database).Collection(CutoverCollection)
+	id := primitive.NewObjectID()
+	if _, err := requests.InsertOne(ctx, bson.D{{Key: "_id", Value: id}, {Key: "requestedAt", Value: time.Now()}}); err != nil {
+		return fmt.Errorf("unable to request the cutover: %w", err)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		var request struct {
+			AppliedAt *time.Time `bson:"appliedAt"`
+		}
+		if err := requests.FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&request); err != nil {
+			return fmt.Errorf("unable to read the cutover request: %w", err)
+		}
+		if request.AppliedAt != nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("no follower applied the cutover: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
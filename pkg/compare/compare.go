@@ -0,0 +1,269 @@
+// Package compare finds the documents that differ between two collections, which may belong to
+// different deployments, by _id and content, and optionally makes the target match the source.
+package compare
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/bulk"
+	"github.com/bradmwilliams/mongodb-client/pkg/client"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Kinds of differences.
+const (
+	// Missing documents are in the source only.
+	Missing = "missing"
+	// Extra documents are in the target only.
+	Extra = "extra"
+	// Mismatched documents have different contents.
+	Mismatched = "mismatched"
+)
+
+// Options change how the collections are compared.
+type Options struct {
+	// Filter restricts the compared documents of both collections.
+	Filter bson.D
+	// BatchSize is the number of documents looked up per request, 1000 when it is not set.
+	BatchSize int
+	// Fields reports the fields that differ between mismatched documents.
+	Fields bool
+	// Repair inserts the missing documents, replaces the mismatched ones and deletes the extra ones
+	// from the target.
+	Repair bool
+}
+
+// Difference is a document that differs between the collections.
+type Difference struct {
+	Kind string
+	ID   bson.RawValue
+	// Fields are the fields that differ, when Options.Fields is set.
+	Fields []Field
+}
+
+// Field is a field whose value differs, Source or Target has no Type when it is absent.
+type Field struct {
+	Path   string
+	Source bson.RawValue
+	Target bson.RawValue
+}
+
+// Result counts the compared documents and the differences found.
+type Result struct {
+	Compared   int64
+	Missing    int64
+	Extra      int64
+	Mismatched int64
+	// Repaired is the number of differences written to the target.
+	Repaired int64
+	// RepairFailures are the writes to the target that failed, by their position among the repairs.
+	RepairFailures []bulk.Failure
+}
+
+// Differences returns the number of differences found.
+func (r Result) Differences() int64 {
+	return r.Missing + r.Extra + r.Mismatched
+}
+
+// Compare reads the documents of source and looks up each batch of them in target by _id, then
+// looks up the documents of target in source to find the extra ones. Documents are equal when
+// their encodings have the same hash, so that fields in a different order are a difference. report
+// is called with every difference and Compare stops at the first error it returns.
+func Compare(ctx context.Context, source, target *mongo.Collection, opts Options, report func(Difference) error) (Result, error) {
+	if opts.BatchSize < 1 {
+		opts.BatchSize = 1000
+	}
+	c := &comparison{source: source, target: target, options: opts, report: report}
+	if opts.Repair {
+		c.writer = bulk.NewWriter(target, bulk.Options{BatchSize: opts.BatchSize})
+	}
+
+	err := c.scan(ctx, source, func(ctx context.Context, batch []bson.Raw) error {
+		c.result.Compared += int64(len(batch))
+		found, err := c.lookup(ctx, target, batch)
+		if err != nil {
+			return err
+		}
+		for _, doc := range batch {
+			id := doc.Lookup("_id")
+			other, ok := found[string(id.Value)+string(id.Type)]
+			switch {
+			case !ok:
+				c.result.Missing++
+				if err := c.difference(ctx, Difference{Kind: Missing, ID: id}, mongo.NewInsertOneModel().SetDocument(doc)); err != nil {
+					return err
+				}
+			case sha256.Sum256(doc) != sha256.Sum256(other):
+				c.result.Mismatched++
+				difference := Difference{Kind: Mismatched, ID: id}
+				if opts.Fields {
+					difference.Fields = Fields(doc, other)
+				}
+				model := mongo.NewReplaceOneModel().SetFilter(bson.D{{Key: "_id", Value: id}}).SetReplacement(doc)
+				if err := c.difference(ctx, difference, model); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return c.result, err
+	}
+
+	err = c.scan(ctx, target, func(ctx context.Context, batch []bson.Raw) error {
+		found, err := c.lookup(ctx, source, batch)
+		if err != nil {
+			return err
+		}
+		for _, doc := range batch {
+			id := doc.Lookup("_id")
+			if _, ok := found[string(id.Value)+string(id.Type)]; ok {
+				continue
+			}
+			c.result.Extra++
+			if err := c.difference(ctx, Difference{Kind: Extra, ID: id}, mongo.NewDeleteOneModel().SetFilter(bson.D{{Key: "_id", Value: id}})); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return c.result, err
+	}
+	if c.writer != nil {
+		if err := c.writer.Flush(ctx); err != nil {
+			if !errors.As(err, new(*bulk.Error)) {
+				return c.result, fmt.Errorf("unable to repair %s: %w", target.Name(), err)
+			}
+		}
+		c.result.Repaired = c.repaired()
+		c.result.RepairFailures = c.writer.Result().Failures
+	}
+	return c.result, nil
+}
+
+type comparison struct {
+	source  *mongo.Collection
+	target  *mongo.Collection
+	options Options
+	report  func(Difference) error
+	writer  *bulk.Writer
+	result  Result
+}
+
+// scan calls fn with batches of the documents of collection matching the filter.
+func (c *comparison) scan(ctx context.Context, collection *mongo.Collection, fn func(ctx context.Context, batch []bson.Raw) error) error {
+	cursor, err := collection.Find(ctx, c.options.Filter, options.Find().SetBatchSize(int32(c.options.BatchSize)))
+	if err != nil {
+		return fmt.Errorf("unable to read %s: %w", collection.Name(), err)
+	}
+	var batch []bson.Raw
+	err = client.Each(ctx, cursor, func(doc bson.Raw) error {
+		batch = append(batch, append(bson.Raw(nil), doc...))
+		if len(batch) < c.options.BatchSize {
+			return nil
+		}
+		err := fn(ctx, batch)
+		batch = nil
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if len(batch) > 0 {
+		return fn(ctx, batch)
+	}
+	return nil
+}
+
+// lookup returns the documents of collection with the _id of the documents of batch, keyed by the
+// type and encoding of their _id.
+func (c *comparison) lookup(ctx context.Context, collection *mongo.Collection, batch []bson.Raw) (map[string]bson.Raw, error) {
+	ids := make(bson.A, 0, len(batch))
+	for _, doc := range batch {
+		ids = append(ids, doc.Lookup("_id"))
+	}
+	cursor, err := collection.Find(ctx, bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: ids}}}}, options.Find().SetBatchSize(int32(len(batch))))
+	if err != nil {
+		return nil, fmt.Errorf("unable to look up documents of %s: %w", collection.Name(), err)
+	}
+	found := map[string]bson.Raw{}
+	err = client.Each(ctx, cursor, func(doc bson.Raw) error {
+		id := doc.Lookup("_id")
+		found[string(id.Value)+string(id.Type)] = append(bson.Raw(nil), doc...)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to look up documents of %s: %w", collection.Name(), err)
+	}
+	return found, nil
+}
+
+// difference reports a difference and queues the write repairing it.
+func (c *comparison) difference(ctx context.Context, difference Difference, repair mongo.WriteModel) error {
+	if c.report != nil {
+		if err := c.report(difference); err != nil {
+			return err
+		}
+	}
+	if c.writer == nil {
+		return nil
+	}
+	if err := c.writer.Add(ctx, repair); err != nil && !errors.As(err, new(*bulk.Error)) {
+		return fmt.Errorf("unable to repair %s: %w", c.target.Name(), err)
+	}
+	return nil
+}
+
+// repaired returns the number of repairs applied.
+func (c *comparison) repaired() int64 {
+	result := c.writer.Result()
+	return result.Inserted + result.Modified + result.Deleted
+}
+
+// Fields returns the fields of source and target whose values differ, descending into the fields
+// that are documents in both. Arrays are compared as a whole.
+func Fields(source, target bson.Raw) []Field {
+	return fields("", source, target)
+}
+
+func fields(prefix string, source, target bson.Raw) []Field {
+	var differences []Field
+	sourceElements, _ := source.Elements()
+	for _, element := range sourceElements {
+		key := element.Key()
+		path := key
+		if len(prefix) > 0 {
+			path = prefix + "." + key
+		}
+		value := element.Value()
+		other, err := target.LookupErr(key)
+		switch {
+		case err != nil:
+			differences = append(differences, Field{Path: path, Source: value})
+		case value.Type == bson.TypeEmbeddedDocument && other.Type == bson.TypeEmbeddedDocument:
+			differences = append(differences, fields(path, value.Document(), other.Document())...)
+		case value.Type != other.Type || !bytes.Equal(value.Value, other.Value):
+			differences = append(differences, Field{Path: path, Source: value, Target: other})
+		}
+	}
+	targetElements, _ := target.Elements()
+	for _, element := range targetElements {
+		if _, err := source.LookupErr(element.Key()); err == nil {
+			continue
+		}
+		path := element.Key()
+		if len(prefix) > 0 {
+			path = prefix + "." + path
+		}
+		differences = append(differences, Field{Path: path, Target: element.Value()})
+	}
+	return differences
+}
@@ -0,0 +1,494 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Config is the content of the file passed with --config.
+type Config struct {
+	// Jobs holds per-job settings keyed by job name.
+	Jobs map[string]JobConfig `json:"jobs,omitempty"`
+	// Backups defines scheduled backups keyed by backup name, each runs as the job "backup-<name>".
+	Backups map[string]BackupConfig `json:"backups,omitempty"`
+	// Retention defines the purges of expired documents keyed by policy name, each runs as the job
+	// "retention-<name>".
+	Retention map[string]RetentionPolicyConfig `json:"retention,omitempty"`
+	// Archives define the moves of old documents to cold storage keyed by archive name, each runs as
+	// the job "archive-<name>".
+	Archives map[string]ArchiveConfig `json:"archives,omitempty"`
+	// Downsample defines the aggregations of raw measurements into rollup collections keyed by
+	// policy name, each runs as the job "downsample-<name>".
+	Downsample map[string]DownsampleConfig `json:"downsample,omitempty"`
+	// MaterializedViews defines the aggregations maintaining summary collections keyed by view name,
+	// each runs as the job "materialize-<name>".
+	MaterializedViews map[string]MaterializedViewConfig `json:"materializedViews,omitempty"`
+	// Encryption encrypts the fields of the documents on the client before they reach the server.
+	Encryption *EncryptionConfig `json:"encryption,omitempty"`
+	// HTTP requires the requests to the listen address, except /readyz, to be authenticated.
+	HTTP *HTTPConfig `json:"http,omitempty"`
+	// GraphQL lists the collections served at /graphql, keyed by the name of their GraphQL type.
+	GraphQL map[string]GraphQLCollectionConfig `json:"graphql,omitempty"`
+	// Atlas identifies the Atlas cluster of the database, whose search indexes are managed through the
+	// Atlas Admin API.
+	Atlas *AtlasConfig `json:"atlas,omitempty"`
+	// Timeouts bound the database operations by kind, those that are not set are bounded by
+	// --operation-timeout.
+	Timeouts *TimeoutsConfig `json:"timeouts,omitempty"`
+	// Profiles are named connections to other deployments keyed by name, selected with --profile
+	// instead of the MONGODB_* environment variables, and referenced by jobs and by the copy and
+	// diff commands.
+	Profiles map[string]ProfileConfig `json:"profiles,omitempty"`
+	// Tenancy lets the REST API serve several tenants, each in namespaces of its own, from the
+	// deployment. The services that do not map the namespaces, watch, GraphQL and gRPC, are refused
+	// with it.
+	Tenancy *TenancyConfig `json:"tenancy,omitempty"`
+	// Protected lists the namespaces that are never dropped or emptied, in addition to the admin,
+	// config and local databases: databases, such as billing, collections, such as
+	// analytics.events, or patterns, such as analytics.raw_*.
+	Protected []string `json:"protected,omitempty"`
+	// Audit records the operations that change data, users or the deployment, see the audit
+	// command.
+	Audit *AuditConfig `json:"audit,omitempty"`
+	// LogLevel is the verbosity of the logs of the background process, instead of --v.
+	LogLevel *int `json:"logLevel,omitempty"`
+	// Operator grants the databases and roles the custom resources of each namespace may declare,
+	// the operator command requires it.
+	Operator *OperatorConfig `json:"operator,omitempty"`
+}
+
+// OperatorConfig holds the grants of the namespaces whose resources the operator reconciles.
+type OperatorConfig struct {
+	// Namespaces are the grants keyed by namespace, the resources of the other namespaces are
+	// refused.
+	Namespaces map[string]OperatorGrantsConfig `json:"namespaces"`
+}
+
+// OperatorGrantsConfig restricts the resources of a namespace.
+type OperatorGrantsConfig struct {
+	// Databases are the names, or patterns such as app_*, of the databases the resources may
+	// declare, grant roles of and be granted privileges on.
+	Databases []string `json:"databases"`
+	// Roles are the built-in or user-defined roles the users may be granted and the roles may
+	// inherit, such as read and readWrite.
+	Roles []string `json:"roles,omitempty"`
+}
+
+// AuditConfig is where the audit records are written, to a file, a collection or both.
+type AuditConfig struct {
+	// File is appended a JSON record per line.
+	File string `json:"file,omitempty"`
+	// Collection is the collection of the records, in Database or the application database. It is
+	// written by a client of its own, which is not audited and not read-only.
+	Collection string `json:"collection,omitempty"`
+	Database   string `json:"database,omitempty"`
+}
+
+// TenancyConfig maps the databases and collections named by the tenants to their own.
+type TenancyConfig struct {
+	// Strategy is database, for a database named <tenant>_<database> per tenant and database, or
+	// prefix, for collections named <tenant>_<collection> in shared databases.
+	Strategy string `json:"strategy"`
+}
+
+// ProfileConfig is a named connection. The passwords are read from environment variables rather
+// than written in the file.
+type ProfileConfig struct {
+	// URI is the connection string of the application client, such as
+	// mongodb+srv://cluster0.example.net/analytics. It may hold the credentials and options.
+	URI string `json:"uri"`
+	// AdminURI is the connection string of the admin client, defaults to URI.
+	AdminURI string `json:"adminURI,omitempty"`
+	// Database is the application database, defaults to the database of URI.
+	Database string `json:"database,omitempty"`
+	// Username and PasswordEnv, the variable holding its password, authenticate the application
+	// client when URI has no credentials.
+	Username    string `json:"username,omitempty"`
+	PasswordEnv string `json:"passwordEnv,omitempty"`
+	// AdminUsername and AdminPasswordEnv authenticate the admin client.
+	AdminUsername    string `json:"adminUsername,omitempty"`
+	AdminPasswordEnv string `json:"adminPasswordEnv,omitempty"`
+	// TLS secures the connections of both clients.
+	TLS *ProfileTLSConfig `json:"tls,omitempty"`
+	// ReadOnly refuses every command that could write through the clients of the profile, as
+	// --read-only does. Their connections are not compressed.
+	ReadOnly bool `json:"readOnly,omitempty"`
+}
+
+// ProfileTLSConfig enables TLS for the connections of a profile.
+type ProfileTLSConfig struct {
+	// CAFile holds the certificates of the authorities of the servers, the system ones by default.
+	CAFile string `json:"caFile,omitempty"`
+	// CertificateKeyFile holds the certificate and private key of the client, in PEM.
+	CertificateKeyFile string `json:"certificateKeyFile,omitempty"`
+	// Insecure skips the verification of the certificates and host names of the servers.
+	Insecure bool `json:"insecure,omitempty"`
+}
+
+// TimeoutsConfig bounds the database operations by kind. The queries and aggregations are also
+// bounded on the server with maxTimeMS, so that the server stops working on them once the client
+// gives up.
+type TimeoutsConfig struct {
+	// Connect bounds the establishment of new connections, it overrides --connect-timeout.
+	Connect *Duration `json:"connect,omitempty"`
+	// Read bounds queries, counts and lookups of documents.
+	Read *Duration `json:"read,omitempty"`
+	// Write bounds inserts, updates and deletes.
+	Write *Duration `json:"write,omitempty"`
+	// Aggregate bounds aggregations.
+	Aggregate *Duration `json:"aggregate,omitempty"`
+	// Admin bounds administrative commands, such as index builds and replica set changes.
+	Admin *Duration `json:"admin,omitempty"`
+}
+
+// JobConfig overrides the defaults of a single background job.
+type JobConfig struct {
+	// Schedule is a cron expression, or a descriptor such as "@hourly" or "@every 10m".
+	Schedule string `json:"schedule,omitempty"`
+	// Jitter delays every activation by a random amount up to this duration.
+	Jitter *Duration `json:"jitter,omitempty"`
+	// Timeout bounds a single run of the job.
+	Timeout *Duration `json:"timeout,omitempty"`
+	// RunOnStart runs the job as soon as the process starts, in addition to its schedule.
+	RunOnStart *bool `json:"runOnStart,omitempty"`
+	// Exclusive prevents the job from running on more than one instance at a time using a distributed lock.
+	Exclusive bool `json:"exclusive,omitempty"`
+	// Disabled prevents the job from being scheduled.
+	Disabled bool `json:"disabled,omitempty"`
+	// Profile runs the job against the deployment of this profile instead of the main connection.
+	Profile string `json:"profile,omitempty"`
+}
+
+// BackupConfig defines a backup job that periodically dumps databases to archives.
+type BackupConfig struct {
+	// JobConfig schedules the backup like any other job.
+	JobConfig `json:",inline"`
+	// Databases to dump, all databases except admin, config and local when empty.
+	Databases []string `json:"databases,omitempty"`
+	// Destination is the directory or the s3://, gs:// or azblob:// URL the archives are written to.
+	Destination string `json:"destination"`
+	// ServerSideEncryption is the S3 server-side encryption algorithm, AES256 or aws:kms.
+	ServerSideEncryption string `json:"serverSideEncryption,omitempty"`
+	// KMSKeyID is the customer managed key objects are encrypted with: the KMS key id for S3, the
+	// Cloud KMS key name for GCS or the encryption scope for Azure.
+	KMSKeyID string `json:"kmsKeyID,omitempty"`
+	// Gzip compresses the archives, defaults to true.
+	Gzip *bool `json:"gzip,omitempty"`
+	// Retention decides which archives are kept.
+	Retention RetentionConfig `json:"retention,omitempty"`
+	// Oplog enables point-in-time recovery by archiving the oplog between full archives, in the
+	// job "backup-<name>-oplog".
+	Oplog *JobConfig `json:"oplog,omitempty"`
+}
+
+// RetentionConfig keeps an archive if it is one of the KeepLast most recent ones or younger than
+// MaxAge. Every archive is kept when neither is set.
+type RetentionConfig struct {
+	KeepLast int       `json:"keepLast,omitempty"`
+	MaxAge   *Duration `json:"maxAge,omitempty"`
+}
+
+// RetentionPolicyConfig deletes the documents of a collection whose field is older than maxAge, or
+// that match filter, or both.
+type RetentionPolicyConfig struct {
+	// JobConfig schedules the purge like any other job.
+	JobConfig `json:",inline"`
+	// Database defaults to the application database.
+	Database   string `json:"database,omitempty"`
+	Collection string `json:"collection"`
+	// Field is a date field, or _id to use the creation time of ObjectIDs.
+	Field  string    `json:"field,omitempty"`
+	MaxAge *Duration `json:"maxAge,omitempty"`
+	// Filter is an Extended JSON query of the expired documents.
+	Filter json.RawMessage `json:"filter,omitempty"`
+	// ArchiveCollection receives a copy of the expired documents before they are deleted.
+	ArchiveCollection string `json:"archiveCollection,omitempty"`
+	// BatchSize is the number of documents deleted per request, defaults to 1000.
+	BatchSize int `json:"batchSize,omitempty"`
+	// Rate is the maximum number of documents deleted per second, unlimited when 0.
+	Rate float64 `json:"rate,omitempty"`
+	// TTLIndex creates a TTL index on field instead, with filter as its partial filter expression,
+	// so that the server deletes the documents itself.
+	TTLIndex bool `json:"ttlIndex,omitempty"`
+}
+
+// ArchiveConfig moves the documents of a collection whose field is older than maxAge, or that match
+// filter, or both, to an archive collection or to gzip NDJSON files of a destination, recording them
+// in a manifest.
+type ArchiveConfig struct {
+	// JobConfig schedules the archive like any other job.
+	JobConfig `json:",inline"`
+	// Database defaults to the application database.
+	Database   string `json:"database,omitempty"`
+	Collection string `json:"collection"`
+	// Field is a date field, or _id to use the creation time of ObjectIDs.
+	Field  string    `json:"field,omitempty"`
+	MaxAge *Duration `json:"maxAge,omitempty"`
+	// Filter is an Extended JSON query of the documents to archive.
+	Filter json.RawMessage `json:"filter,omitempty"`
+	// ToCollection is the archive collection, of toDatabase or else of database.
+	ToDatabase   string `json:"toDatabase,omitempty"`
+	ToCollection string `json:"toCollection,omitempty"`
+	// Destination is instead the directory or the s3://, gs:// or azblob:// URL the files are
+	// written to, below <name>/ with the manifest.json of the archive.
+	Destination          string `json:"destination,omitempty"`
+	ServerSideEncryption string `json:"serverSideEncryption,omitempty"`
+	KMSKeyID             string `json:"kmsKeyID,omitempty"`
+	// ChunkSize is the number of documents per file, or per manifest entry of an archive
+	// collection, defaults to 10000.
+	ChunkSize int `json:"chunkSize,omitempty"`
+}
+
+// EncryptionConfig enables the automatic client-side field level encryption of the connections to the
+// database.
+type EncryptionConfig struct {
+	// KeyVault is the database.collection namespace of the data keys, defaults to
+	// encryption.__keyVault.
+	KeyVault string `json:"keyVault,omitempty"`
+	// KMS configures the providers of the master keys the data keys are encrypted with.
+	KMS KMSConfig `json:"kms"`
+	// Fields lists the encrypted fields of the documents keyed by database.collection namespace and
+	// then by field, with dots for the fields of embedded documents.
+	Fields map[string]map[string]EncryptedFieldConfig `json:"fields,omitempty"`
+	// Queryable lists the fields of the collections with Queryable Encryption, on MongoDB 7.0 and
+	// later, keyed by namespace and then by field. Their encryption is part of the options of the
+	// collections, see the encryption create-collection command.
+	Queryable map[string]map[string]QueryableFieldConfig `json:"queryable,omitempty"`
+	// BypassAutoEncryption only decrypts the documents that are read.
+	BypassAutoEncryption bool `json:"bypassAutoEncryption,omitempty"`
+	// Mongocryptd configures the process analyzing the commands to encrypt.
+	Mongocryptd *MongocryptdConfig `json:"mongocryptd,omitempty"`
+}
+
+// KMSConfig holds the credentials and master keys of each KMS provider. The secrets that are not set
+// are read from the environment variables of the SDKs of the providers.
+type KMSConfig struct {
+	Local *LocalKMSConfig `json:"local,omitempty"`
+	AWS   *AWSKMSConfig   `json:"aws,omitempty"`
+	GCP   *GCPKMSConfig   `json:"gcp,omitempty"`
+	Azure *AzureKMSConfig `json:"azure,omitempty"`
+}
+
+// LocalKMSConfig is a master key kept in a file, for development.
+type LocalKMSConfig struct {
+	// KeyFile holds the 96 bytes of the master key, raw or base64 encoded.
+	KeyFile string `json:"keyFile"`
+}
+
+// AWSKMSConfig is a customer master key of AWS KMS.
+type AWSKMSConfig struct {
+	// AccessKeyID, SecretAccessKey and SessionToken default to AWS_ACCESS_KEY_ID,
+	// AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN.
+	AccessKeyID     string `json:"accessKeyId,omitempty"`
+	SecretAccessKey string `json:"secretAccessKey,omitempty"`
+	SessionToken    string `json:"sessionToken,omitempty"`
+	// Region and Key, the ARN of the master key, are only needed to create data keys.
+	Region string `json:"region,omitempty"`
+	Key    string `json:"key,omitempty"`
+}
+
+// GCPKMSConfig is a key of Google Cloud KMS.
+type GCPKMSConfig struct {
+	// CredentialsFile is the JSON key of a service account, it defaults to
+	// GOOGLE_APPLICATION_CREDENTIALS.
+	CredentialsFile string `json:"credentialsFile,omitempty"`
+	// Endpoint defaults to oauth2.googleapis.com.
+	Endpoint string `json:"endpoint,omitempty"`
+	// ProjectID, Location, KeyRing and KeyName locate the master key, they are only needed to
+	// create data keys.
+	ProjectID string `json:"projectId,omitempty"`
+	Location  string `json:"location,omitempty"`
+	KeyRing   string `json:"keyRing,omitempty"`
+	KeyName   string `json:"keyName,omitempty"`
+}
+
+// AzureKMSConfig is a key of an Azure Key Vault.
+type AzureKMSConfig struct {
+	// TenantID, ClientID and ClientSecret default to AZURE_TENANT_ID, AZURE_CLIENT_ID and
+	// AZURE_CLIENT_SECRET.
+	TenantID     string `json:"tenantId,omitempty"`
+	ClientID     string `json:"clientId,omitempty"`
+	ClientSecret string `json:"clientSecret,omitempty"`
+	// KeyVaultEndpoint and KeyName locate the master key, they are only needed to create data
+	// keys.
+	KeyVaultEndpoint string `json:"keyVaultEndpoint,omitempty"`
+	KeyName          string `json:"keyName,omitempty"`
+}
+
+// EncryptedFieldConfig is an encrypted field.
+type EncryptedFieldConfig struct {
+	// KeyAltName is the name of the data key of the field.
+	KeyAltName string `json:"keyAltName"`
+	// Algorithm is deterministic, for the fields that are queried by equality, or random, the
+	// default.
+	Algorithm string `json:"algorithm,omitempty"`
+	// BSONType is the type of the values, such as string, int or date, required by deterministic
+	// encryption.
+	BSONType string `json:"bsonType,omitempty"`
+}
+
+// QueryableFieldConfig is a field encrypted with Queryable Encryption.
+type QueryableFieldConfig struct {
+	// KeyAltName is the name of the data key of the field, created with the collection.
+	KeyAltName string `json:"keyAltName"`
+	// BSONType is the type of the values, such as string, int or date.
+	BSONType string `json:"bsonType"`
+	// Query is equality for a field that can be queried by equality, empty for one that can only
+	// be read.
+	Query string `json:"query,omitempty"`
+	// Contention is the contention factor of a queried field, the server default when not set.
+	Contention *int64 `json:"contention,omitempty"`
+}
+
+// MongocryptdConfig configures mongocryptd, which the driver spawns from the PATH by default.
+type MongocryptdConfig struct {
+	URI         string   `json:"uri,omitempty"`
+	BypassSpawn bool     `json:"bypassSpawn,omitempty"`
+	SpawnPath   string   `json:"spawnPath,omitempty"`
+	SpawnArgs   []string `json:"spawnArgs,omitempty"`
+}
+
+// DownsampleConfig aggregates the measurements of a collection, usually a time series collection,
+// into rollups of fixed windows.
+type DownsampleConfig struct {
+	// JobConfig schedules the aggregation like any other job.
+	JobConfig `json:",inline"`
+	// Database defaults to the application database.
+	Database   string `json:"database,omitempty"`
+	Collection string `json:"collection"`
+	// RollupCollection receives a document per window and metaField value, keyed by _id {t, meta}.
+	RollupCollection string `json:"rollupCollection"`
+	TimeField        string `json:"timeField"`
+	MetaField        string `json:"metaField,omitempty"`
+	// Interval is the duration of the windows, such as "5m" or "1h".
+	Interval *Duration `json:"interval"`
+	// Aggregates lists the operators, avg, min, max or sum, applied to each field. The rollups hold
+	// them as <field>_<operator>, and the number of measurements as count.
+	Aggregates map[string][]string `json:"aggregates,omitempty"`
+	// Lag delays the aggregation of a window after its end for the measurements that arrive late.
+	Lag *Duration `json:"lag,omitempty"`
+}
+
+// MaterializedViewConfig runs an aggregation pipeline ending with a $merge or an $out stage, which
+// writes its result to the collection of the view.
+type MaterializedViewConfig struct {
+	// JobConfig schedules the refreshes like any other job, its timeout also bounds the refreshes
+	// requested through the API.
+	JobConfig `json:",inline"`
+	// Database defaults to the application database.
+	Database   string `json:"database,omitempty"`
+	Collection string `json:"collection"`
+	// Pipeline is an Extended JSON array of stages, such as
+	// [{"$group": {"_id": "$podcast", "episodes": {"$sum": 1}}}, {"$merge": {"into": "podcast_stats"}}].
+	Pipeline json.RawMessage `json:"pipeline"`
+}
+
+// HTTPConfig defines who may access the listen address and what they may do.
+type HTTPConfig struct {
+	// Users authenticate with basic auth or a bearer token.
+	Users []HTTPUserConfig `json:"users,omitempty"`
+	// OIDC accepts bearer tokens issued by an OpenID Connect provider.
+	OIDC *OIDCConfig `json:"oidc,omitempty"`
+	// Roles grant access, keyed by role name.
+	Roles map[string]RoleConfig `json:"roles,omitempty"`
+}
+
+// HTTPUserConfig is a user of the listen address. Only hashes of the secrets are configured.
+type HTTPUserConfig struct {
+	Name string `json:"name"`
+	// PasswordHash is the bcrypt hash of the basic auth password.
+	PasswordHash string `json:"passwordHash,omitempty"`
+	// TokenSHA256 is the hex encoded SHA-256 of the bearer token.
+	TokenSHA256 string   `json:"tokenSHA256,omitempty"`
+	Roles       []string `json:"roles,omitempty"`
+	// Tenant binds the user to a tenant, users without one pick the tenant of each request with
+	// the tenant header.
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// OIDCConfig validates the ID tokens of an OpenID Connect provider.
+type OIDCConfig struct {
+	// IssuerURL is the issuer of the tokens, its discovery document lists the signing keys.
+	IssuerURL string `json:"issuerURL"`
+	// Audience must be one of the audiences of the tokens.
+	Audience string `json:"audience"`
+	// UsernameClaim names the user, defaults to sub.
+	UsernameClaim string `json:"usernameClaim,omitempty"`
+	// RolesClaim lists the roles of the user, defaults to roles.
+	RolesClaim string `json:"rolesClaim,omitempty"`
+	// TenantClaim names the tenant the user is bound to, tokens without it are not bound to one.
+	TenantClaim string `json:"tenantClaim,omitempty"`
+}
+
+// RoleConfig grants access to databases, "*" stands for every database.
+type RoleConfig struct {
+	// Read allows reading the documents of these databases.
+	Read []string `json:"read,omitempty"`
+	// ReadWrite allows reading and writing the documents of these databases.
+	ReadWrite []string `json:"readWrite,omitempty"`
+	// Metrics allows scraping /metrics.
+	Metrics bool `json:"metrics,omitempty"`
+	// Debug allows profiling with /debug/pprof/ and reading /debug/vars.
+	Debug bool `json:"debug,omitempty"`
+}
+
+// GraphQLCollectionConfig is a collection served over GraphQL.
+type GraphQLCollectionConfig struct {
+	// Database defaults to the application database.
+	Database string `json:"database,omitempty"`
+	// Collection defaults to the name of the entry.
+	Collection string `json:"collection,omitempty"`
+	// Fields declares the GraphQL types of the fields, such as String, Int, DateTime, JSON or
+	// [String]. They are inferred from a sample of sampleSize documents when empty.
+	Fields     map[string]string `json:"fields,omitempty"`
+	SampleSize int               `json:"sampleSize,omitempty"`
+}
+
+// AtlasConfig is the Atlas cluster of the database. The API key is read from ATLAS_PUBLIC_KEY and
+// ATLAS_PRIVATE_KEY.
+type AtlasConfig struct {
+	// GroupID is the ID of the project of the cluster.
+	GroupID     string `json:"groupID"`
+	ClusterName string `json:"clusterName"`
+	// URL is the base URL of the Atlas Admin API, defaults to https://cloud.mongodb.com/api/atlas/v1.0.
+	URL string `json:"url,omitempty"`
+}
+
+// Duration is a time.Duration that is written as a string such as "90s" in the config file.
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("duration must be a string such as \"30s\": %w", err)
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	d.Duration = duration
+	return nil
+}
+
+// Load reads a YAML or JSON config file.
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read config file: %w", err)
+	}
+	config := &Config{}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, fmt.Errorf("unable to parse config file %s: %w", path, err)
+	}
+	return config, nil
+}
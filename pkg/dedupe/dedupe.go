@@ -0,0 +1,221 @@
+// Package dedupe finds the clusters of documents of a collection that share a key, and removes all
+// but one document of each of them.
+package dedupe
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/client"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Winner selections, the document of a cluster that is kept.
+const (
+	// Newest keeps the document with the highest By field.
+	Newest = "newest"
+	// Oldest keeps the document with the lowest By field.
+	Oldest = "oldest"
+	// MostComplete keeps the document with the most fields that are not null.
+	MostComplete = "most-complete"
+)
+
+// Options select the duplicates and how they are removed.
+type Options struct {
+	// Fields are the dotted paths of the key, the documents missing any of them are not grouped.
+	Fields []string
+	// Key is an expression of the key of the documents instead of Fields, the _id of a $group stage
+	// such as {"email": {"$toLower": "$email"}}. The documents whose key is null are not grouped.
+	Key interface{}
+	// Filter restricts the documents that are grouped.
+	Filter bson.D
+	// Winner is Newest, Oldest or MostComplete, Newest by default.
+	Winner string
+	// By is the field ordering the documents for Newest and Oldest, _id by default. The ties, and
+	// the ties of MostComplete, are broken by the lowest _id.
+	By string
+	// Remove deletes the documents of the clusters but their winners.
+	Remove bool
+	// BatchSize is the number of documents deleted per transaction, 1000 when it is not set. The
+	// documents of a cluster are always deleted in the same transaction.
+	BatchSize int
+	// Retry is the policy of the transactions.
+	Retry client.RetryPolicy
+}
+
+// Cluster is a set of documents sharing a key.
+type Cluster struct {
+	Key bson.RawValue
+	// Winner is the _id of the document that is kept and Losers are the _ids of the others.
+	Winner bson.RawValue
+	Losers []bson.RawValue
+}
+
+// Result counts the duplicates of a collection.
+type Result struct {
+	Clusters int64
+	// Duplicates is the number of documents of the clusters but their winners.
+	Duplicates int64
+	// Removed is the number of documents deleted.
+	Removed int64
+}
+
+// completenessField is the computed field counting the fields of the documents for MostComplete.
+const completenessField = "__fields"
+
+// Run groups the documents of coll by their key and calls report with every cluster of more than one
+// document, stopping at the first error it returns. With Remove, the losers of the clusters are
+// deleted in transactions that first check that their winners still exist, so that a cluster is
+// never left without any of its documents. Transactions require a replica set or a sharded cluster.
+func Run(ctx context.Context, coll *mongo.Collection, opts Options, report func(Cluster) error) (Result, error) {
+	var result Result
+	pipeline, err := opts.pipeline()
+	if err != nil {
+		return result, err
+	}
+	if opts.BatchSize < 1 {
+		opts.BatchSize = 1000
+	}
+	cursor, err := coll.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return result, fmt.Errorf("unable to group the documents of %s: %w", coll.Name(), err)
+	}
+
+	var batch []Cluster
+	var size int
+	remove := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		removed, err := removeLosers(ctx, coll, batch, opts.Retry)
+		if err != nil {
+			return err
+		}
+		result.Removed += removed
+		batch, size = nil, 0
+		return nil
+	}
+	err = client.Each(ctx, cursor, func(doc bson.Raw) error {
+		ids, _ := doc.Lookup("ids").Array().Values()
+		cluster := Cluster{Key: copyValue(doc.Lookup("_id")), Winner: copyValue(ids[0])}
+		for _, id := range ids[1:] {
+			cluster.Losers = append(cluster.Losers, copyValue(id))
+		}
+		result.Clusters++
+		result.Duplicates += int64(len(cluster.Losers))
+		if err := report(cluster); err != nil {
+			return err
+		}
+		if !opts.Remove {
+			return nil
+		}
+		if size > 0 && size+len(cluster.Losers) > opts.BatchSize {
+			if err := remove(); err != nil {
+				return err
+			}
+		}
+		batch = append(batch, cluster)
+		size += len(cluster.Losers)
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+	return result, remove()
+}
+
+func (o Options) pipeline() (mongo.Pipeline, error) {
+	if (len(o.Fields) == 0) == (o.Key == nil) {
+		return nil, fmt.Errorf("exactly one of the fields or the expression of the key is required")
+	}
+	match := append(bson.D(nil), o.Filter...)
+	key := o.Key
+	if len(o.Fields) > 0 {
+		fields := bson.D{}
+		for _, field := range o.Fields {
+			// The names of the fields of an expression cannot hold dots.
+			fields = append(fields, bson.E{Key: strings.ReplaceAll(field, ".", "_"), Value: "$" + field})
+			match = append(match, bson.E{Key: field, Value: bson.D{{Key: "$exists", Value: true}}})
+		}
+		key = fields
+	}
+
+	by := o.By
+	if len(by) == 0 {
+		by = "_id"
+	}
+	var pipeline mongo.Pipeline
+	if len(match) > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: match}})
+	}
+	var sort bson.D
+	switch o.Winner {
+	case "", Newest:
+		sort = bson.D{{Key: by, Value: -1}}
+	case Oldest:
+		sort = bson.D{{Key: by, Value: 1}}
+	case MostComplete:
+		pipeline = append(pipeline, bson.D{{Key: "$addFields", Value: bson.D{{Key: completenessField, Value: bson.D{{Key: "$size", Value: bson.D{{Key: "$filter", Value: bson.D{
+			{Key: "input", Value: bson.D{{Key: "$objectToArray", Value: "$$ROOT"}}},
+			{Key: "cond", Value: bson.D{{Key: "$ne", Value: bson.A{"$$this.v", nil}}}},
+		}}}}}}}}})
+		sort = bson.D{{Key: completenessField, Value: -1}}
+	default:
+		return nil, fmt.Errorf("unknown winner %q, must be one of %s, %s or %s", o.Winner, Newest, Oldest, MostComplete)
+	}
+	if sort[0].Key != "_id" {
+		sort = append(sort, bson.E{Key: "_id", Value: 1})
+	}
+	// $push keeps the order of the sorted documents, the winner of a cluster comes first.
+	return append(pipeline,
+		bson.D{{Key: "$sort", Value: sort}},
+		bson.D{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: key},
+			{Key: "ids", Value: bson.D{{Key: "$push", Value: "$_id"}}},
+			{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}},
+		}}},
+		bson.D{{Key: "$match", Value: bson.D{{Key: "_id", Value: bson.D{{Key: "$ne", Value: nil}}}, {Key: "count", Value: bson.D{{Key: "$gt", Value: 1}}}}}},
+	), nil
+}
+
+// removeLosers deletes the losers of clusters in a transaction, which is aborted when any of their
+// winners was deleted since they were grouped.
+func removeLosers(ctx context.Context, coll *mongo.Collection, clusters []Cluster, policy client.RetryPolicy) (int64, error) {
+	winners := bson.A{}
+	losers := bson.A{}
+	for _, cluster := range clusters {
+		winners = append(winners, cluster.Winner)
+		for _, id := range cluster.Losers {
+			losers = append(losers, id)
+		}
+	}
+	var removed int64
+	err := client.Txn(ctx, coll.Database().Client(), policy, func(sess mongo.SessionContext) error {
+		count, err := coll.CountDocuments(sess, bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: winners}}}})
+		if err != nil {
+			return fmt.Errorf("unable to count the winners: %w", err)
+		}
+		if count != int64(len(winners)) {
+			return fmt.Errorf("%d of the %d winners were deleted since the documents were grouped", int64(len(winners))-count, len(winners))
+		}
+		deleted, err := coll.DeleteMany(sess, bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: losers}}}})
+		if err != nil {
+			return err
+		}
+		removed = deleted.DeletedCount
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("unable to remove the duplicates of %d clusters of %s: %w", len(clusters), coll.Name(), err)
+	}
+	return removed, nil
+}
+
+// copyValue returns value with its own copy of the bytes of the cursor.
+func copyValue(value bson.RawValue) bson.RawValue {
+	value.Value = append([]byte(nil), value.Value...)
+	return value
+}
@@ -0,0 +1,277 @@
+// Package downsample aggregates the raw measurements of a time series collection into a rollup
+// collection, one document per window of a fixed interval and per source of measurements. Runs
+// resume from the last window of the rollup collection, so that they can be scheduled as a job.
+package downsample
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/bulk"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"k8s.io/klog"
+)
+
+var (
+	downsampleRollups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mongodb_client_downsample_rollups_total",
+		Help: "Number of rollup documents written by each downsampling policy.",
+	}, []string{"policy"})
+	downsampleWatermark = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "mongodb_client_downsample_watermark_timestamp_seconds",
+		Help: "Unix time of the end of the last window aggregated by each downsampling policy.",
+	}, []string{"policy"})
+	downsampleLastSuccessTimestamp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "mongodb_client_downsample_last_success_timestamp_seconds",
+		Help: "Unix time of the last complete run of each downsampling policy.",
+	}, []string{"policy"})
+)
+
+func init() {
+	prometheus.MustRegister(downsampleRollups, downsampleWatermark, downsampleLastSuccessTimestamp)
+}
+
+// Operators are the accumulators that can be applied to the fields of the measurements.
+var Operators = []string{"avg", "min", "max", "sum"}
+
+// windowsPerAggregation bounds the number of windows aggregated per request.
+const windowsPerAggregation = 1000
+
+// Policy aggregates the measurements of Source into Target.
+type Policy struct {
+	// Name identifies the policy in the logs and the metrics.
+	Name     string
+	Database string
+	// Source holds the raw measurements, usually a time series collection.
+	Source string
+	// Target receives the rollups, keyed by _id {t, meta} so that a window written again replaces
+	// the previous one. It is a regular collection, the measurements of time series collections
+	// can not be replaced.
+	Target string
+	// TimeField is the date of the measurements, and of the start of the windows of the rollups.
+	TimeField string
+	// MetaField identifies the source of the measurements, there is a rollup per window and value
+	// of MetaField when it is set.
+	MetaField string
+	// Interval is the duration of the windows, aligned on the Unix epoch.
+	Interval time.Duration
+	// Aggregates lists the Operators applied to each field, the rollups hold them as
+	// <field>_<operator> and the number of measurements as count.
+	Aggregates map[string][]string
+	// Lag delays the aggregation of a window after its end, for the measurements that arrive late.
+	Lag time.Duration
+}
+
+// Validate reports whether the policy is complete and consistent.
+func (p *Policy) Validate() error {
+	if len(p.Source) == 0 || len(p.Target) == 0 {
+		return fmt.Errorf("a source and a target collection are required")
+	}
+	if p.Source == p.Target {
+		return fmt.Errorf("the target collection must not be the source collection")
+	}
+	if len(p.TimeField) == 0 {
+		return fmt.Errorf("a timeField is required")
+	}
+	if p.Interval < time.Second || p.Interval%time.Millisecond != 0 {
+		return fmt.Errorf("interval must be at least 1s and a whole number of milliseconds")
+	}
+	if p.Lag < 0 {
+		return fmt.Errorf("lag must not be negative")
+	}
+	outputs := map[string]string{"_id": "", "count": "", p.TimeField: ""}
+	if len(p.MetaField) > 0 {
+		outputs[p.MetaField] = ""
+	}
+	for field, operators := range p.Aggregates {
+		if len(field) == 0 || strings.HasPrefix(field, "$") {
+			return fmt.Errorf("invalid aggregated field %q", field)
+		}
+		for _, operator := range operators {
+			if !validOperator(operator) {
+				return fmt.Errorf("field %s: unsupported operator %q, must be one of %s", field, operator, strings.Join(Operators, ", "))
+			}
+			output := outputField(field, operator)
+			if previous, ok := outputs[output]; ok {
+				return fmt.Errorf("field %s: %s of the rollups is also %s", field, output, previous)
+			}
+			outputs[output] = field + " " + operator
+		}
+	}
+	return nil
+}
+
+func validOperator(operator string) bool {
+	for _, o := range Operators {
+		if o == operator {
+			return true
+		}
+	}
+	return false
+}
+
+// outputField returns the field of the rollups holding operator applied to field.
+func outputField(field, operator string) string {
+	return strings.ReplaceAll(field, ".", "_") + "_" + operator
+}
+
+// Run aggregates the windows that ended Lag ago and were not aggregated yet, from the window of the
+// last rollup of Target, or of the first measurement of Source. It has the signature of a job
+// handler.
+func (p *Policy) Run(ctx context.Context, c *mongo.Client) error {
+	db := c.Database(p.Database)
+	source, target := db.Collection(p.Source), db.Collection(p.Target)
+
+	begin, ok, err := p.watermark(ctx, source, target)
+	if err != nil {
+		return err
+	}
+	end := p.truncate(time.Now().Add(-p.Lag))
+	if !ok || !begin.Before(end) {
+		downsampleLastSuccessTimestamp.WithLabelValues(p.Name).SetToCurrentTime()
+		return nil
+	}
+
+	var written int64
+	for start := begin; start.Before(end); {
+		stop := start.Add(windowsPerAggregation * p.Interval)
+		if stop.After(end) {
+			stop = end
+		}
+		count, err := p.aggregate(ctx, source, target, start, stop)
+		written += count
+		downsampleRollups.WithLabelValues(p.Name).Add(float64(count))
+		if err != nil {
+			return err
+		}
+		downsampleWatermark.WithLabelValues(p.Name).Set(float64(stop.Unix()))
+		start = stop
+	}
+	klog.Infof("Downsampling policy %s wrote %d rollups of %s.%s to %s from %s to %s", p.Name, written, p.Database, p.Source, p.Target, begin.Format(time.RFC3339), end.Format(time.RFC3339))
+	downsampleLastSuccessTimestamp.WithLabelValues(p.Name).SetToCurrentTime()
+	return nil
+}
+
+// watermark returns the start of the first window to aggregate, the last window of the rollups or
+// else the window of the first measurement, false when there is no measurement yet.
+func (p *Policy) watermark(ctx context.Context, source, target *mongo.Collection) (time.Time, bool, error) {
+	var last struct {
+		ID struct {
+			T time.Time `bson:"t"`
+		} `bson:"_id"`
+	}
+	// The window comes first in the _id of the rollups, which are sorted by window by their index.
+	err := target.FindOne(ctx, bson.D{}, options.FindOne().
+		SetSort(bson.D{{Key: "_id", Value: -1}}).
+		SetProjection(bson.D{{Key: "_id.t", Value: 1}})).Decode(&last)
+	switch {
+	case err == nil:
+		// The last window is aggregated again, a run that failed may have written only some of
+		// its rollups.
+		return last.ID.T, true, nil
+	case err != mongo.ErrNoDocuments:
+		return time.Time{}, false, fmt.Errorf("unable to find the last rollup of %s: %w", p.Target, err)
+	}
+
+	var first bson.Raw
+	err = source.FindOne(ctx, bson.D{{Key: p.TimeField, Value: bson.D{{Key: "$type", Value: "date"}}}}, options.FindOne().
+		SetSort(bson.D{{Key: p.TimeField, Value: 1}}).
+		SetProjection(bson.D{{Key: p.TimeField, Value: 1}})).Decode(&first)
+	switch {
+	case err == mongo.ErrNoDocuments:
+		return time.Time{}, false, nil
+	case err != nil:
+		return time.Time{}, false, fmt.Errorf("unable to find the first measurement of %s: %w", p.Source, err)
+	}
+	t, ok := first.Lookup(strings.Split(p.TimeField, ".")...).TimeOK()
+	if !ok {
+		return time.Time{}, false, fmt.Errorf("the first measurement of %s has no date %s", p.Source, p.TimeField)
+	}
+	return p.truncate(t), true, nil
+}
+
+// truncate returns the start of the window of t, aligned on the Unix epoch like the windows of the
+// pipeline. time.Time.Truncate aligns on the zero time instead.
+func (p *Policy) truncate(t time.Time) time.Time {
+	ms, interval := t.UnixNano()/int64(time.Millisecond), p.Interval.Milliseconds()
+	start := ms - ms%interval
+	if ms%interval < 0 {
+		start -= interval
+	}
+	return time.Unix(0, start*int64(time.Millisecond)).UTC()
+}
+
+// Pipeline returns the aggregation of the measurements from start to stop into rollups.
+func (p *Policy) Pipeline(start, stop time.Time) mongo.Pipeline {
+	timeField := "$" + p.TimeField
+	ms := p.Interval.Milliseconds()
+	window := bson.D{{Key: "$toDate", Value: bson.D{{Key: "$subtract", Value: bson.A{
+		bson.D{{Key: "$toLong", Value: timeField}},
+		bson.D{{Key: "$mod", Value: bson.A{bson.D{{Key: "$toLong", Value: timeField}}, ms}}},
+	}}}}}
+	id := bson.D{{Key: "t", Value: window}}
+	if len(p.MetaField) > 0 {
+		id = append(id, bson.E{Key: "meta", Value: "$" + p.MetaField})
+	}
+
+	group := bson.D{{Key: "_id", Value: id}, {Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}}}
+	project := bson.D{{Key: "_id", Value: 1}, {Key: p.TimeField, Value: "$_id.t"}, {Key: "count", Value: 1}}
+	if len(p.MetaField) > 0 {
+		project = append(project, bson.E{Key: p.MetaField, Value: "$_id.meta"})
+	}
+	fields := make([]string, 0, len(p.Aggregates))
+	for field := range p.Aggregates {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		for _, operator := range p.Aggregates[field] {
+			output := outputField(field, operator)
+			group = append(group, bson.E{Key: output, Value: bson.D{{Key: "$" + operator, Value: "$" + field}}})
+			project = append(project, bson.E{Key: output, Value: 1})
+		}
+	}
+
+	return mongo.Pipeline{
+		{{Key: "$match", Value: bson.D{{Key: p.TimeField, Value: bson.D{{Key: "$gte", Value: start}, {Key: "$lt", Value: stop}}}}}},
+		{{Key: "$group", Value: group}},
+		{{Key: "$project", Value: project}},
+		{{Key: "$sort", Value: bson.D{{Key: "_id.t", Value: 1}}}},
+	}
+}
+
+// aggregate writes the rollups of the windows from start to stop and returns how many it wrote.
+func (p *Policy) aggregate(ctx context.Context, source, target *mongo.Collection, start, stop time.Time) (int64, error) {
+	cursor, err := source.Aggregate(ctx, p.Pipeline(start, stop), options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return 0, fmt.Errorf("unable to aggregate the measurements of %s: %w", p.Source, err)
+	}
+	defer cursor.Close(context.Background())
+
+	writer := bulk.NewWriter(target, bulk.Options{Ordered: true})
+	for cursor.Next(ctx) {
+		// The current document is only valid until the next one is read, the writer keeps it longer.
+		rollup := make(bson.Raw, len(cursor.Current))
+		copy(rollup, cursor.Current)
+		model := mongo.NewReplaceOneModel().
+			SetFilter(bson.D{{Key: "_id", Value: rollup.Lookup("_id")}}).
+			SetReplacement(rollup).
+			SetUpsert(true)
+		if err := writer.Add(ctx, model); err != nil {
+			return writer.Result().Upserted + writer.Result().Matched, fmt.Errorf("unable to write the rollups to %s: %w", p.Target, err)
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return writer.Result().Upserted + writer.Result().Matched, fmt.Errorf("unable to aggregate the measurements of %s: %w", p.Source, err)
+	}
+	if err := writer.Flush(ctx); err != nil {
+		return writer.Result().Upserted + writer.Result().Matched, fmt.Errorf("unable to write the rollups to %s: %w", p.Target, err)
+	}
+	return writer.Result().Upserted + writer.Result().Matched, nil
+}
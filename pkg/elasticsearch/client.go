@@ -0,0 +1,167 @@
+// Package elasticsearch keeps Elasticsearch or OpenSearch indices in sync with MongoDB collections,
+// using the REST APIs directly.
+package elasticsearch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Client sends requests to an Elasticsearch or OpenSearch cluster. Credentials are read from
+// ELASTICSEARCH_API_KEY, or ELASTICSEARCH_USERNAME and ELASTICSEARCH_PASSWORD.
+type Client struct {
+	url      string
+	apiKey   string
+	username string
+	password string
+	http     *http.Client
+}
+
+// NewClient returns a client for the cluster at url.
+func NewClient(url string, timeout time.Duration) (*Client, error) {
+	if !strings.HasPrefix(url, "https://") && !strings.HasPrefix(url, "http://") {
+		return nil, fmt.Errorf("invalid Elasticsearch URL %q, must be an http:// or https:// URL", url)
+	}
+	return &Client{
+		url:      strings.TrimSuffix(url, "/"),
+		apiKey:   os.Getenv("ELASTICSEARCH_API_KEY"),
+		username: os.Getenv("ELASTICSEARCH_USERNAME"),
+		password: os.Getenv("ELASTICSEARCH_PASSWORD"),
+		http:     &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// responseError is returned for unexpected responses.
+type responseError struct {
+	method string
+	path   string
+	code   int
+	body   string
+}
+
+func (e *responseError) Error() string {
+	return fmt.Sprintf("%s %s: unexpected status %d: %s", e.method, e.path, e.code, strings.TrimSpace(e.body))
+}
+
+// do sends a request and decodes the JSON response into out unless it is nil.
+func (c *Client) do(ctx context.Context, method, path, contentType string, body []byte, out interface{}) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.url+path, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	if len(contentType) > 0 {
+		req.Header.Set("Content-Type", contentType)
+	}
+	switch {
+	case len(c.apiKey) > 0:
+		req.Header.Set("Authorization", "ApiKey "+c.apiKey)
+	case len(c.username) > 0:
+		req.SetBasicAuth(c.username, c.password)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return resp.StatusCode, &responseError{method: method, path: path, code: resp.StatusCode, body: string(data)}
+	}
+	if out == nil {
+		io.Copy(ioutil.Discard, resp.Body)
+		return resp.StatusCode, nil
+	}
+	return resp.StatusCode, json.NewDecoder(resp.Body).Decode(out)
+}
+
+// EnsureIndex creates index with the settings and mappings of body unless it exists.
+func (c *Client) EnsureIndex(ctx context.Context, index string, body []byte) error {
+	code, err := c.do(ctx, http.MethodHead, "/"+index, "", nil, nil)
+	if err == nil {
+		return nil
+	}
+	if code != http.StatusNotFound {
+		return err
+	}
+	if len(body) == 0 {
+		body = []byte("{}")
+	}
+	if _, err := c.do(ctx, http.MethodPut, "/"+index, "application/json", body, nil); err != nil {
+		return fmt.Errorf("unable to create index %s: %w", index, err)
+	}
+	return nil
+}
+
+// action is a single operation of a bulk request, Document is nil for deletions.
+type action struct {
+	ID       string
+	Document map[string]interface{}
+}
+
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		ID     string          `json:"_id"`
+		Status int             `json:"status"`
+		Error  json.RawMessage `json:"error"`
+	} `json:"items"`
+}
+
+// Bulk indexes and deletes documents of index in a single request.
+func (c *Client) Bulk(ctx context.Context, index string, actions []action) error {
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	for _, a := range actions {
+		operation := "index"
+		if a.Document == nil {
+			operation = "delete"
+		}
+		if err := encoder.Encode(map[string]interface{}{operation: map[string]string{"_index": index, "_id": a.ID}}); err != nil {
+			return err
+		}
+		if a.Document != nil {
+			if err := encoder.Encode(a.Document); err != nil {
+				return fmt.Errorf("unable to encode document %s: %w", a.ID, err)
+			}
+		}
+	}
+
+	var response bulkResponse
+	if _, err := c.do(ctx, http.MethodPost, "/_bulk", "application/x-ndjson", body.Bytes(), &response); err != nil {
+		return err
+	}
+	if !response.Errors {
+		return nil
+	}
+	// The failure is reported with the highest status of the failed items, so that a request whose
+	// items were only rejected for being too many is retried.
+	var failures []string
+	status := 0
+	for _, item := range response.Items {
+		for operation, result := range item {
+			// Deleting a document that was never indexed is not a failure.
+			if result.Status < 300 || (operation == "delete" && result.Status == http.StatusNotFound) {
+				continue
+			}
+			failures = append(failures, fmt.Sprintf("%s %s: %d %s", operation, result.ID, result.Status, result.Error))
+			if result.Status > status {
+				status = result.Status
+			}
+		}
+	}
+	if len(failures) == 0 {
+		return nil
+	}
+	if len(failures) > 3 {
+		failures = append(failures[:3], fmt.Sprintf("and %d more", len(failures)-3))
+	}
+	return &responseError{method: http.MethodPost, path: "/_bulk", code: status, body: strings.Join(failures, "; ")}
+}
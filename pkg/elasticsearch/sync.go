@@ -0,0 +1,329 @@
+package elasticsearch
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/changestream"
+	"github.com/bradmwilliams/mongodb-client/pkg/client"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"k8s.io/klog"
+)
+
+var syncedDocuments = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "mongodb_client_search_sync_documents_total",
+	Help: "Number of documents indexed or deleted by each search index sync, by operation.",
+}, []string{"sync", "operation"})
+
+func init() {
+	prometheus.MustRegister(syncedDocuments)
+}
+
+// Mapping selects and renames the fields copied to the index. Field names are top level fields of
+// the documents.
+type Mapping struct {
+	// Include copies only these fields, every field when empty.
+	Include []string
+	// Exclude skips these fields.
+	Exclude []string
+	// Rename maps field names to the names used in the index.
+	Rename map[string]string
+}
+
+// Sync keeps an index in sync with a collection: the collection is scanned once, then the changes
+// are tailed with a change stream whose resume token is persisted in Store.
+type Sync struct {
+	// Name identifies the sync in the token store and metrics.
+	Name       string
+	Client     *Client
+	Collection *mongo.Collection
+	Index      string
+	// IndexBody holds the settings and mappings used to create the index when it does not exist.
+	IndexBody []byte
+	Mapping   Mapping
+	// BatchSize is the maximum number of documents per bulk request.
+	BatchSize int
+	// Store persists the resume token, without it the collection is scanned on every start.
+	Store changestream.TokenStore
+	// Resync scans the collection again even though a resume token was saved.
+	Resync bool
+	Retry  client.RetryPolicy
+}
+
+// Run scans the collection unless a resume token was saved, then applies the changes until ctx is
+// cancelled or an error occurs.
+func (s *Sync) Run(ctx context.Context) error {
+	if s.BatchSize < 1 {
+		s.BatchSize = 500
+	}
+	if s.Store == nil {
+		s.Store = changestream.NewMemoryStore()
+	}
+	if err := s.Client.EnsureIndex(ctx, s.Index, s.IndexBody); err != nil {
+		return err
+	}
+
+	token, err := s.Store.Load(ctx, s.Name)
+	if err != nil {
+		return fmt.Errorf("unable to load the resume token of %s: %w", s.Name, err)
+	}
+	if token == nil || s.Resync {
+		if err := s.scan(ctx); err != nil {
+			return err
+		}
+	}
+
+	consumer := &changestream.Consumer{
+		Name:      s.Name,
+		Watcher:   s.Collection,
+		Options:   options.ChangeStream().SetFullDocument(options.UpdateLookup),
+		Store:     s.Store,
+		BatchSize: s.BatchSize,
+		Retry:     s.Retry,
+	}
+	return consumer.RunBatches(ctx, s.apply)
+}
+
+// scan indexes every document of the collection. The change stream position is saved before the
+// scan starts, so that the changes made during the scan are applied afterwards.
+func (s *Sync) scan(ctx context.Context) error {
+	stream, err := s.Collection.Watch(ctx, []bson.D{})
+	if err != nil {
+		return fmt.Errorf("unable to open the change stream: %w", err)
+	}
+	start := stream.ResumeToken()
+	stream.Close(ctx)
+	if start == nil {
+		return fmt.Errorf("the server did not return a resume token for %s", s.Collection.Name())
+	}
+
+	klog.Infof("Indexing every document of %s.%s into %s", s.Collection.Database().Name(), s.Collection.Name(), s.Index)
+	cursor, err := s.Collection.Find(ctx, bson.D{}, options.Find().SetBatchSize(int32(s.BatchSize)))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	var batch []action
+	var count int
+	for cursor.Next(ctx) {
+		a, err := s.Mapping.index(cursor.Current)
+		if err != nil {
+			return err
+		}
+		batch = append(batch, a)
+		if len(batch) >= s.BatchSize {
+			if err := s.bulk(ctx, batch); err != nil {
+				return err
+			}
+			count += len(batch)
+			batch = batch[:0]
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	if err := s.bulk(ctx, batch); err != nil {
+		return err
+	}
+	count += len(batch)
+	klog.Infof("Indexed %d documents into %s", count, s.Index)
+	return s.Store.Save(ctx, s.Name, start)
+}
+
+type changeEvent struct {
+	OperationType string   `bson:"operationType"`
+	DocumentKey   bson.Raw `bson:"documentKey"`
+	FullDocument  bson.Raw `bson:"fullDocument"`
+}
+
+// apply turns change events into bulk actions. Updates index the looked up document, or delete it
+// when it no longer exists.
+func (s *Sync) apply(ctx context.Context, events []bson.Raw) error {
+	var batch []action
+	for _, raw := range events {
+		var event changeEvent
+		if err := bson.Unmarshal(raw, &event); err != nil {
+			return fmt.Errorf("invalid change event: %w", err)
+		}
+		switch event.OperationType {
+		case "insert", "update", "replace", "delete":
+		default:
+			// Drops and renames invalidate the stream, other events do not affect documents.
+			continue
+		}
+		if event.FullDocument != nil && event.OperationType != "delete" {
+			a, err := s.Mapping.index(event.FullDocument)
+			if err != nil {
+				return err
+			}
+			batch = append(batch, a)
+			continue
+		}
+		id, err := documentID(event.DocumentKey)
+		if err != nil {
+			return err
+		}
+		batch = append(batch, action{ID: id})
+	}
+	return s.bulk(ctx, batch)
+}
+
+func (s *Sync) bulk(ctx context.Context, batch []action) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	err := client.Retry(ctx, s.Retry, func(ctx context.Context) error {
+		err := s.Client.Bulk(ctx, s.Index, batch)
+		if retryable(err) {
+			return client.RetryableError{Err: err}
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	for _, a := range batch {
+		operation := "index"
+		if a.Document == nil {
+			operation = "delete"
+		}
+		syncedDocuments.WithLabelValues(s.Name, operation).Inc()
+	}
+	return nil
+}
+
+// retryable reports whether a failed bulk request may succeed when sent again.
+func retryable(err error) bool {
+	var response *responseError
+	if errors.As(err, &response) {
+		return response.code == http.StatusTooManyRequests || response.code >= 500
+	}
+	return err != nil && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
+
+// documentID returns the index identifier of the document with key: the hex of an ObjectId, the
+// value of a string and canonical Extended JSON otherwise.
+func documentID(key bson.Raw) (string, error) {
+	value, err := key.LookupErr("_id")
+	if err != nil {
+		return "", fmt.Errorf("document without _id: %w", err)
+	}
+	if oid, ok := value.ObjectIDOK(); ok {
+		return oid.Hex(), nil
+	}
+	if s, ok := value.StringValueOK(); ok {
+		return s, nil
+	}
+	data, err := bson.MarshalExtJSON(bson.D{{Key: "_id", Value: value}}, true, false)
+	return string(data), err
+}
+
+// index returns the action indexing doc with the fields selected by the mapping.
+func (m Mapping) index(doc bson.Raw) (action, error) {
+	id, err := documentID(doc)
+	if err != nil {
+		return action{}, err
+	}
+	elements, err := doc.Elements()
+	if err != nil {
+		return action{}, err
+	}
+	include := map[string]bool{}
+	for _, field := range m.Include {
+		include[field] = true
+	}
+	exclude := map[string]bool{}
+	for _, field := range m.Exclude {
+		exclude[field] = true
+	}
+	source := map[string]interface{}{}
+	for _, element := range elements {
+		key := element.Key()
+		// _id is a metadata field of the index, it is not part of the source.
+		if key == "_id" || exclude[key] || (len(include) > 0 && !include[key]) {
+			continue
+		}
+		if renamed, ok := m.Rename[key]; ok {
+			key = renamed
+		}
+		source[key], err = jsonValue(element.Value())
+		if err != nil {
+			return action{}, fmt.Errorf("document %s, field %s: %w", id, element.Key(), err)
+		}
+	}
+	return action{ID: id, Document: source}, nil
+}
+
+// jsonValue converts a BSON value to the plain JSON representation indexed by Elasticsearch:
+// ObjectIds become hex strings, dates RFC 3339 strings and decimals strings.
+func jsonValue(value bson.RawValue) (interface{}, error) {
+	switch value.Type {
+	case bsontype.EmbeddedDocument:
+		elements, err := value.Document().Elements()
+		if err != nil {
+			return nil, err
+		}
+		doc := make(map[string]interface{}, len(elements))
+		for _, element := range elements {
+			if doc[element.Key()], err = jsonValue(element.Value()); err != nil {
+				return nil, err
+			}
+		}
+		return doc, nil
+	case bsontype.Array:
+		values, err := value.Array().Values()
+		if err != nil {
+			return nil, err
+		}
+		array := make([]interface{}, 0, len(values))
+		for _, v := range values {
+			converted, err := jsonValue(v)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, converted)
+		}
+		return array, nil
+	case bsontype.ObjectID:
+		return value.ObjectID().Hex(), nil
+	case bsontype.DateTime:
+		return value.Time().UTC().Format(time.RFC3339Nano), nil
+	case bsontype.Timestamp:
+		t, _ := value.Timestamp()
+		return time.Unix(int64(t), 0).UTC().Format(time.RFC3339), nil
+	case bsontype.Decimal128:
+		return value.Decimal128().String(), nil
+	case bsontype.Double:
+		return value.Double(), nil
+	case bsontype.Int32:
+		return value.Int32(), nil
+	case bsontype.Int64:
+		return value.Int64(), nil
+	case bsontype.String:
+		return value.StringValue(), nil
+	case bsontype.Boolean:
+		return value.Boolean(), nil
+	case bsontype.Null, bsontype.Undefined:
+		return nil, nil
+	case bsontype.Binary:
+		_, data := value.Binary()
+		return base64.StdEncoding.EncodeToString(data), nil
+	case bsontype.Regex:
+		pattern, _ := value.Regex()
+		return pattern, nil
+	case bsontype.Symbol:
+		return value.Symbol(), nil
+	case bsontype.JavaScript:
+		return value.JavaScript(), nil
+	}
+	// The remaining types have no JSON equivalent, they are indexed as Extended JSON.
+	return value.String(), nil
+}
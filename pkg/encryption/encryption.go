@@ -0,0 +1,353 @@
+// Package encryption configures the automatic client-side field level encryption of the driver and
+// manages the data keys of its key vault. The fields of the documents are encrypted with data keys
+// before they are sent to the server and decrypted when they are read back, the data keys are
+// themselves encrypted by the master key of a KMS provider: local, aws, gcp or azure.
+//
+// Encryption is done by libmongocrypt, which requires building with the cse tag, and the queries
+// are analyzed by mongocryptd, which is spawned by the driver unless it is already running.
+package encryption
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DefaultKeyVault is the namespace of the data keys used by the drivers and tools.
+const DefaultKeyVault = "encryption.__keyVault"
+
+// The KMS providers supported by the driver.
+const (
+	Local = "local"
+	AWS   = "aws"
+	GCP   = "gcp"
+	Azure = "azure"
+)
+
+// The algorithms of the encrypted fields. Deterministic encryption always produces the same value
+// for the same input so that the field can be queried for equality, random encryption does not.
+const (
+	Deterministic = "AEAD_AES_256_CBC_HMAC_SHA_512-Deterministic"
+	Random        = "AEAD_AES_256_CBC_HMAC_SHA_512-Random"
+)
+
+// Config describes the key vault, the KMS providers and the encrypted fields of each namespace.
+type Config struct {
+	// KeyVault is the namespace of the data keys, DefaultKeyVault when it is not set.
+	KeyVault string
+	// Providers holds the credentials of each KMS provider, in the form expected by the driver.
+	Providers map[string]map[string]interface{}
+	// MasterKeys holds the master key new data keys are encrypted with for each of the providers
+	// but local, which has a single key.
+	MasterKeys map[string]interface{}
+	// Fields lists the encrypted fields of each database.collection namespace.
+	Fields map[string][]Field
+	// Queryable lists the queryable encrypted fields of each database.collection namespace, for
+	// MongoDB 7.0 and later.
+	Queryable map[string][]QueryableField
+	// BypassAutoEncryption only decrypts the documents that are read, the fields that are written
+	// are left as they are and mongocryptd is not needed.
+	BypassAutoEncryption bool
+	// ExtraOptions configure mongocryptd: mongocryptdURI, mongocryptdBypassSpawn,
+	// mongocryptdSpawnPath and mongocryptdSpawnArgs.
+	ExtraOptions map[string]interface{}
+}
+
+// Field is an encrypted field of the documents of a namespace.
+type Field struct {
+	// Path is the field, with dots for the fields of embedded documents.
+	Path string
+	// KeyAltName names the data key the field is encrypted with.
+	KeyAltName string
+	// Algorithm is Deterministic or Random.
+	Algorithm string
+	// BSONType is the type of the values of the field, such as string or date. It is required by
+	// deterministic encryption.
+	BSONType string
+}
+
+// Validate reports whether the configuration is complete and consistent.
+func (c *Config) Validate() error {
+	if _, _, err := SplitNamespace(c.keyVault()); err != nil {
+		return fmt.Errorf("keyVault: %w", err)
+	}
+	if len(c.Providers) == 0 {
+		return fmt.Errorf("at least one KMS provider is required")
+	}
+	for provider := range c.Providers {
+		switch provider {
+		case Local, AWS, GCP, Azure:
+		default:
+			return fmt.Errorf("unsupported KMS provider %q, must be one of %s, %s, %s or %s", provider, Local, AWS, GCP, Azure)
+		}
+	}
+	for namespace, fields := range c.Fields {
+		if _, _, err := SplitNamespace(namespace); err != nil {
+			return err
+		}
+		paths := make(map[string]bool)
+		for _, field := range fields {
+			if len(field.Path) == 0 || strings.HasPrefix(field.Path, "$") {
+				return fmt.Errorf("%s: invalid field %q", namespace, field.Path)
+			}
+			if len(field.KeyAltName) == 0 {
+				return fmt.Errorf("%s: field %s has no keyAltName", namespace, field.Path)
+			}
+			switch field.Algorithm {
+			case Deterministic:
+				if len(field.BSONType) == 0 {
+					return fmt.Errorf("%s: field %s is encrypted deterministically and requires a bsonType", namespace, field.Path)
+				}
+			case Random:
+			default:
+				return fmt.Errorf("%s: field %s has an unsupported algorithm %q", namespace, field.Path, field.Algorithm)
+			}
+			for path := range paths {
+				if path == field.Path || strings.HasPrefix(path, field.Path+".") || strings.HasPrefix(field.Path, path+".") {
+					return fmt.Errorf("%s: fields %s and %s overlap", namespace, path, field.Path)
+				}
+			}
+			paths[field.Path] = true
+		}
+	}
+	return c.validateQueryable()
+}
+
+func (c *Config) keyVault() string {
+	if len(c.KeyVault) == 0 {
+		return DefaultKeyVault
+	}
+	return c.KeyVault
+}
+
+// SplitNamespace returns the database and the collection of a database.collection namespace.
+func SplitNamespace(namespace string) (string, string, error) {
+	parts := strings.SplitN(namespace, ".", 2)
+	if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return "", "", fmt.Errorf("namespace %q must be database.collection", namespace)
+	}
+	return parts[0], parts[1], nil
+}
+
+// AutoEncryptionOptions returns the options of a client encrypting the fields of the config. The
+// schemas of the namespaces refer to the data keys by _id, those are looked up by their keyAltNames
+// in the key vault through keyVault, a client that is not encrypted itself.
+func (c *Config) AutoEncryptionOptions(ctx context.Context, keyVault *mongo.Client) (*options.AutoEncryptionOptions, error) {
+	schemaMap, err := c.SchemaMap(ctx, keyVault)
+	if err != nil {
+		return nil, err
+	}
+	opts := options.AutoEncryption().
+		SetKeyVaultNamespace(c.keyVault()).
+		SetKmsProviders(c.Providers).
+		SetBypassAutoEncryption(c.BypassAutoEncryption)
+	if len(schemaMap) > 0 {
+		opts.SetSchemaMap(schemaMap)
+	}
+	if len(c.ExtraOptions) > 0 {
+		opts.SetExtraOptions(c.ExtraOptions)
+	}
+	return opts, nil
+}
+
+// SchemaMap returns the $jsonSchema of the encrypted fields of every namespace, with the _id of
+// their data keys.
+func (c *Config) SchemaMap(ctx context.Context, keyVault *mongo.Client) (map[string]interface{}, error) {
+	if len(c.Fields) == 0 {
+		return nil, nil
+	}
+	var names []string
+	for _, fields := range c.Fields {
+		for _, field := range fields {
+			names = append(names, field.KeyAltName)
+		}
+	}
+	ids, err := c.keyIDs(ctx, keyVault, names)
+	if err != nil {
+		return nil, err
+	}
+
+	schemaMap := make(map[string]interface{})
+	for namespace, fields := range c.Fields {
+		schema := bson.M{"bsonType": "object", "properties": bson.M{}}
+		for _, field := range fields {
+			id, ok := ids[field.KeyAltName]
+			if !ok {
+				return nil, fmt.Errorf("%s: field %s is encrypted with the data key %s that is not in %s", namespace, field.Path, field.KeyAltName, c.keyVault())
+			}
+			encrypt := bson.M{"algorithm": field.Algorithm, "keyId": bson.A{id}}
+			if len(field.BSONType) > 0 {
+				encrypt["bsonType"] = field.BSONType
+			}
+			// The embedded documents of a dotted path are nested objects of the schema.
+			properties := schema["properties"].(bson.M)
+			segments := strings.Split(field.Path, ".")
+			for _, segment := range segments[:len(segments)-1] {
+				nested, ok := properties[segment].(bson.M)
+				if !ok {
+					nested = bson.M{"bsonType": "object", "properties": bson.M{}}
+					properties[segment] = nested
+				}
+				properties = nested["properties"].(bson.M)
+			}
+			properties[segments[len(segments)-1]] = bson.M{"encrypt": encrypt}
+		}
+		schemaMap[namespace] = schema
+	}
+	return schemaMap, nil
+}
+
+// keyIDs returns the _id of the data keys with the given keyAltNames.
+func (c *Config) keyIDs(ctx context.Context, keyVault *mongo.Client, names []string) (map[string]primitive.Binary, error) {
+	keys, err := c.keys(ctx, keyVault, bson.D{{Key: "keyAltNames", Value: bson.D{{Key: "$in", Value: names}}}})
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[string]primitive.Binary)
+	for _, key := range keys {
+		for _, name := range key.AltNames {
+			ids[name] = key.ID
+		}
+	}
+	return ids, nil
+}
+
+// Key is a data key of the key vault.
+type Key struct {
+	ID       primitive.Binary `bson:"_id"`
+	AltNames []string         `bson:"keyAltNames,omitempty"`
+	// MasterKey describes the master key the data key is encrypted with, its provider in
+	// particular.
+	MasterKey bson.M    `bson:"masterKey"`
+	Created   time.Time `bson:"creationDate"`
+	Updated   time.Time `bson:"updateDate"`
+}
+
+// Provider returns the KMS provider of the master key of the data key.
+func (k Key) Provider() string {
+	provider, _ := k.MasterKey["provider"].(string)
+	return provider
+}
+
+// Keys returns the data keys of the key vault, by creation date.
+func (c *Config) Keys(ctx context.Context, keyVault *mongo.Client) ([]Key, error) {
+	return c.keys(ctx, keyVault, bson.D{})
+}
+
+func (c *Config) keys(ctx context.Context, keyVault *mongo.Client, filter bson.D) ([]Key, error) {
+	coll, err := c.keyVaultCollection(keyVault)
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := coll.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "creationDate", Value: 1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the data keys of %s: %w", c.keyVault(), err)
+	}
+	var keys []Key
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, fmt.Errorf("unable to read the data keys of %s: %w", c.keyVault(), err)
+	}
+	return keys, nil
+}
+
+func (c *Config) keyVaultCollection(keyVault *mongo.Client) (*mongo.Collection, error) {
+	database, collection, err := SplitNamespace(c.keyVault())
+	if err != nil {
+		return nil, err
+	}
+	return keyVault.Database(database).Collection(collection), nil
+}
+
+// EnsureKeyVault creates the unique index on the keyAltNames of the data keys recommended by the
+// drivers, so that a name always refers to a single key.
+func (c *Config) EnsureKeyVault(ctx context.Context, keyVault *mongo.Client) error {
+	coll, err := c.keyVaultCollection(keyVault)
+	if err != nil {
+		return err
+	}
+	_, err = coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "keyAltNames", Value: 1}},
+		Options: options.Index().
+			SetName("keyAltNames_1").
+			SetUnique(true).
+			SetPartialFilterExpression(bson.D{{Key: "keyAltNames", Value: bson.D{{Key: "$exists", Value: true}}}}),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to create the keyAltNames index of %s: %w", c.keyVault(), err)
+	}
+	return nil
+}
+
+// CreateKey creates a data key called name encrypted by the master key of provider and returns its
+// _id.
+func (c *Config) CreateKey(ctx context.Context, keyVault *mongo.Client, provider, name string) (primitive.Binary, error) {
+	if _, ok := c.Providers[provider]; !ok {
+		return primitive.Binary{}, fmt.Errorf("the KMS provider %s is not configured", provider)
+	}
+	opts := options.DataKey().SetKeyAltNames([]string{name})
+	if provider != Local {
+		masterKey, ok := c.MasterKeys[provider]
+		if !ok {
+			return primitive.Binary{}, fmt.Errorf("the KMS provider %s has no master key", provider)
+		}
+		opts.SetMasterKey(masterKey)
+	}
+	if err := c.EnsureKeyVault(ctx, keyVault); err != nil {
+		return primitive.Binary{}, err
+	}
+	clientEncryption, err := mongo.NewClientEncryption(keyVault, options.ClientEncryption().
+		SetKeyVaultNamespace(c.keyVault()).
+		SetKmsProviders(c.Providers))
+	if err != nil {
+		return primitive.Binary{}, fmt.Errorf("unable to set up client-side encryption: %w", err)
+	}
+	defer clientEncryption.Close(context.Background())
+	id, err := clientEncryption.CreateDataKey(ctx, provider, opts)
+	if err != nil {
+		return primitive.Binary{}, fmt.Errorf("unable to create the data key %s: %w", name, err)
+	}
+	return id, nil
+}
+
+// DeleteKey deletes the data key called name. The values encrypted with it can not be decrypted
+// anymore.
+func (c *Config) DeleteKey(ctx context.Context, keyVault *mongo.Client, name string) error {
+	coll, err := c.keyVaultCollection(keyVault)
+	if err != nil {
+		return err
+	}
+	result, err := coll.DeleteOne(ctx, bson.D{{Key: "keyAltNames", Value: name}})
+	if err != nil {
+		return fmt.Errorf("unable to delete the data key %s: %w", name, err)
+	}
+	if result.DeletedCount == 0 {
+		return fmt.Errorf("the data key %s does not exist in %s", name, c.keyVault())
+	}
+	return nil
+}
+
+// KeyUsers returns the encrypted and queryable encrypted fields, as namespace.path, of every data key name of the config.
+func (c *Config) KeyUsers() map[string][]string {
+	users := make(map[string][]string)
+	for namespace, fields := range c.Fields {
+		for _, field := range fields {
+			users[field.KeyAltName] = append(users[field.KeyAltName], namespace+"."+field.Path)
+		}
+	}
+	for namespace, fields := range c.Queryable {
+		for _, field := range fields {
+			users[field.KeyAltName] = append(users[field.KeyAltName], namespace+"."+field.Path)
+		}
+	}
+	for _, fields := range users {
+		sort.Strings(fields)
+	}
+	return users
+}
@@ -0,0 +1,324 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Equality is the query type of the queryable encrypted fields that can be matched by equality, the
+// only one of MongoDB 7.0.
+const Equality = "equality"
+
+// minQueryableVersion is the first server version with the stable Queryable Encryption protocol.
+const minQueryableVersion = 7
+
+// QueryableField is a field of a collection with Queryable Encryption. Unlike the fields encrypted
+// by a schema, its encryption is part of the options of the collection on the server.
+type QueryableField struct {
+	// Path is the field, with dots for the fields of embedded documents.
+	Path string
+	// KeyAltName names the data key of the field, it is created with the collection when it does
+	// not exist.
+	KeyAltName string
+	// BSONType is the type of the values of the field.
+	BSONType string
+	// Query is Equality for a field that can be queried, empty for one that can only be read.
+	Query string
+	// Contention trades the confidentiality of the values of a queried field with few distinct
+	// values against the throughput of its concurrent writes, the server default when nil.
+	Contention *int64
+}
+
+func (c *Config) validateQueryable() error {
+	for namespace, fields := range c.Queryable {
+		if _, _, err := SplitNamespace(namespace); err != nil {
+			return err
+		}
+		if _, ok := c.Fields[namespace]; ok {
+			return fmt.Errorf("%s has both fields encrypted by a schema and queryable encrypted fields", namespace)
+		}
+		paths := make(map[string]bool)
+		for _, field := range fields {
+			if len(field.Path) == 0 || strings.HasPrefix(field.Path, "$") || field.Path == "_id" || strings.HasPrefix(field.Path, "_id.") {
+				return fmt.Errorf("%s: invalid queryable field %q", namespace, field.Path)
+			}
+			if len(field.KeyAltName) == 0 || len(field.BSONType) == 0 {
+				return fmt.Errorf("%s: queryable field %s requires a keyAltName and a bsonType", namespace, field.Path)
+			}
+			switch field.Query {
+			case "", Equality:
+			default:
+				return fmt.Errorf("%s: queryable field %s has an unsupported query type %q, must be %s", namespace, field.Path, field.Query, Equality)
+			}
+			if field.Contention != nil && (*field.Contention < 0 || len(field.Query) == 0) {
+				return fmt.Errorf("%s: the contention of queryable field %s must not be negative and requires a query type", namespace, field.Path)
+			}
+			for path := range paths {
+				if path == field.Path || strings.HasPrefix(path, field.Path+".") || strings.HasPrefix(field.Path, path+".") {
+					return fmt.Errorf("%s: queryable fields %s and %s overlap", namespace, path, field.Path)
+				}
+			}
+			paths[field.Path] = true
+		}
+	}
+	return nil
+}
+
+// stateCollections returns the names of the metadata collections the server maintains for the
+// queryable fields of collection.
+func stateCollections(collection string) []string {
+	return []string{"enxcol_." + collection + ".esc", "enxcol_." + collection + ".ecoc"}
+}
+
+// EncryptedFields returns the encryptedFields option of the collection of a namespace, with the _id
+// of the data keys of its fields.
+func (c *Config) EncryptedFields(ctx context.Context, keyVault *mongo.Client, namespace string) (bson.D, error) {
+	fields, ok := c.Queryable[namespace]
+	if !ok {
+		return nil, fmt.Errorf("%s has no queryable encrypted fields", namespace)
+	}
+	var names []string
+	for _, field := range fields {
+		names = append(names, field.KeyAltName)
+	}
+	ids, err := c.keyIDs(ctx, keyVault, names)
+	if err != nil {
+		return nil, err
+	}
+	var encryptedFields bson.A
+	for _, field := range fields {
+		id, ok := ids[field.KeyAltName]
+		if !ok {
+			return nil, fmt.Errorf("%s: queryable field %s is encrypted with the data key %s that is not in %s", namespace, field.Path, field.KeyAltName, c.keyVault())
+		}
+		encryptedField := bson.D{
+			{Key: "path", Value: field.Path},
+			{Key: "bsonType", Value: field.BSONType},
+			{Key: "keyId", Value: id},
+		}
+		if len(field.Query) > 0 {
+			query := bson.D{{Key: "queryType", Value: field.Query}}
+			if field.Contention != nil {
+				query = append(query, bson.E{Key: "contention", Value: *field.Contention})
+			}
+			encryptedField = append(encryptedField, bson.E{Key: "queries", Value: query})
+		}
+		encryptedFields = append(encryptedFields, encryptedField)
+	}
+	return bson.D{{Key: "fields", Value: encryptedFields}}, nil
+}
+
+// CreateEncryptedCollection creates the collection of a namespace with its queryable encrypted
+// fields, after the data keys that do not exist yet, encrypted by the master key of provider. It
+// creates the metadata collections and the index on __safeContent__ the way the drivers do, and
+// returns the keyAltNames of the data keys it created.
+func (c *Config) CreateEncryptedCollection(ctx context.Context, keyVault, db *mongo.Client, namespace, provider string) ([]string, error) {
+	database, collection, err := SplitNamespace(namespace)
+	if err != nil {
+		return nil, err
+	}
+	fields, ok := c.Queryable[namespace]
+	if !ok {
+		return nil, fmt.Errorf("%s has no queryable encrypted fields", namespace)
+	}
+	if err := checkQueryableVersion(ctx, db); err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, field := range fields {
+		names = append(names, field.KeyAltName)
+	}
+	ids, err := c.keyIDs(ctx, keyVault, names)
+	if err != nil {
+		return nil, err
+	}
+	var created []string
+	for _, field := range fields {
+		if _, ok := ids[field.KeyAltName]; ok {
+			continue
+		}
+		id, err := c.CreateKey(ctx, keyVault, provider, field.KeyAltName)
+		if err != nil {
+			return created, err
+		}
+		ids[field.KeyAltName] = id
+		created = append(created, field.KeyAltName)
+	}
+	encryptedFields, err := c.EncryptedFields(ctx, keyVault, namespace)
+	if err != nil {
+		return created, err
+	}
+
+	target := db.Database(database)
+	for _, name := range stateCollections(collection) {
+		err := target.RunCommand(ctx, bson.D{
+			{Key: "create", Value: name},
+			{Key: "clusteredIndex", Value: bson.D{{Key: "key", Value: bson.D{{Key: "_id", Value: 1}}}, {Key: "unique", Value: true}}},
+		}).Err()
+		if err != nil {
+			return created, fmt.Errorf("unable to create the metadata collection %s.%s: %w", database, name, err)
+		}
+	}
+	if err := target.RunCommand(ctx, bson.D{{Key: "create", Value: collection}, {Key: "encryptedFields", Value: encryptedFields}}).Err(); err != nil {
+		return created, fmt.Errorf("unable to create %s: %w", namespace, err)
+	}
+	if _, err := target.Collection(collection).Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{Key: "__safeContent__", Value: 1}}}); err != nil {
+		return created, fmt.Errorf("unable to create the __safeContent__ index of %s: %w", namespace, err)
+	}
+	return created, nil
+}
+
+// checkQueryableVersion returns an error when the server is older than MongoDB 7.0.
+func checkQueryableVersion(ctx context.Context, db *mongo.Client) error {
+	var buildInfo struct {
+		Version string `bson:"version"`
+	}
+	if err := db.Database("admin").RunCommand(ctx, bson.D{{Key: "buildInfo", Value: 1}}).Decode(&buildInfo); err != nil {
+		return fmt.Errorf("unable to read the version of the server: %w", err)
+	}
+	major, err := strconv.Atoi(strings.SplitN(buildInfo.Version, ".", 2)[0])
+	if err != nil {
+		return fmt.Errorf("unable to parse the version %q of the server: %w", buildInfo.Version, err)
+	}
+	if major < minQueryableVersion {
+		return fmt.Errorf("MongoDB %d.0 or later is required by Queryable Encryption, the server runs %s", minQueryableVersion, buildInfo.Version)
+	}
+	return nil
+}
+
+// Check is the outcome of one of the verifications of an encrypted collection.
+type Check struct {
+	Name string
+	OK   bool
+	// Skipped is set for the checks that this client can not make.
+	Skipped bool
+	Detail  string
+}
+
+// VerifyEncryptedCollection compares the collection of a namespace on the server with its queryable
+// encrypted fields: the version of the server, the encryptedFields option of the collection, the
+// data keys of the fields, the metadata collections and the __safeContent__ index. Automatic
+// encryption of the queries is reported as skipped, the driver of this client does not support the
+// Queryable Encryption protocol.
+func (c *Config) VerifyEncryptedCollection(ctx context.Context, keyVault, db *mongo.Client, namespace string) ([]Check, error) {
+	database, collection, err := SplitNamespace(namespace)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := c.Queryable[namespace]; !ok {
+		return nil, fmt.Errorf("%s has no queryable encrypted fields", namespace)
+	}
+	var checks []Check
+	check := func(name string, err error, detail string) {
+		if err != nil {
+			checks = append(checks, Check{Name: name, Detail: err.Error()})
+			return
+		}
+		checks = append(checks, Check{Name: name, OK: true, Detail: detail})
+	}
+
+	check("server version", checkQueryableVersion(ctx, db), fmt.Sprintf("MongoDB %d.0 or later", minQueryableVersion))
+
+	expected, err := c.EncryptedFields(ctx, keyVault, namespace)
+	check("data keys", err, fmt.Sprintf("every field has its data key in %s", c.keyVault()))
+
+	target := db.Database(database)
+	specs, err := target.ListCollectionSpecifications(ctx, bson.D{{Key: "name", Value: bson.D{{Key: "$in", Value: append(stateCollections(collection), collection)}}}})
+	if err != nil {
+		return checks, fmt.Errorf("unable to list the collections of %s: %w", database, err)
+	}
+	existing := make(map[string]bson.Raw)
+	for _, spec := range specs {
+		existing[spec.Name] = spec.Options
+	}
+
+	collectionOptions, ok := existing[collection]
+	switch {
+	case !ok:
+		check("encrypted fields", fmt.Errorf("%s does not exist", namespace), "")
+	case expected == nil:
+		check("encrypted fields", fmt.Errorf("the data keys are missing"), "")
+	default:
+		check("encrypted fields", compareEncryptedFields(collectionOptions.Lookup("encryptedFields"), expected), "the options of the collection match the config file")
+	}
+	for _, name := range stateCollections(collection) {
+		var err error
+		if _, ok := existing[name]; !ok {
+			err = fmt.Errorf("%s.%s does not exist", database, name)
+		}
+		check("metadata collection "+name, err, "exists")
+	}
+	if ok {
+		check("__safeContent__ index", checkSafeContentIndex(ctx, target.Collection(collection)), "exists")
+	}
+	checks = append(checks, Check{Name: "automatic encryption", Skipped: true, Detail: "the driver of this client predates Queryable Encryption and crypt_shared"})
+	return checks, nil
+}
+
+// compareEncryptedFields returns an error describing the first difference between the
+// encryptedFields option of a collection and the expected one.
+func compareEncryptedFields(actual bson.RawValue, expected bson.D) error {
+	if actual.Type != bson.TypeEmbeddedDocument {
+		return fmt.Errorf("the collection has no encryptedFields")
+	}
+	var current struct {
+		Fields []struct {
+			Path     string           `bson:"path"`
+			BSONType string           `bson:"bsonType"`
+			KeyID    primitive.Binary `bson:"keyId"`
+			Queries  bson.Raw         `bson:"queries"`
+		} `bson:"fields"`
+	}
+	if err := actual.Unmarshal(&current); err != nil {
+		return fmt.Errorf("unable to decode the encryptedFields of the collection: %w", err)
+	}
+	byPath := make(map[string]int)
+	for i, field := range current.Fields {
+		byPath[field.Path] = i
+	}
+	fields := expected[0].Value.(bson.A)
+	for _, value := range fields {
+		field := value.(bson.D)
+		path := field[0].Value.(string)
+		i, ok := byPath[path]
+		if !ok {
+			return fmt.Errorf("field %s is not encrypted by the collection", path)
+		}
+		if current.Fields[i].BSONType != field[1].Value.(string) {
+			return fmt.Errorf("field %s is a %s instead of a %s", path, current.Fields[i].BSONType, field[1].Value)
+		}
+		if !bytes.Equal(current.Fields[i].KeyID.Data, field[2].Value.(primitive.Binary).Data) {
+			return fmt.Errorf("field %s is encrypted with another data key", path)
+		}
+		queried := len(field) > 3
+		if queried != (len(current.Fields[i].Queries) > 0) {
+			return fmt.Errorf("field %s is queryable in only one of the collection and the config file", path)
+		}
+		delete(byPath, path)
+	}
+	for path := range byPath {
+		return fmt.Errorf("field %s is encrypted by the collection but not in the config file", path)
+	}
+	return nil
+}
+
+func checkSafeContentIndex(ctx context.Context, coll *mongo.Collection) error {
+	specs, err := coll.Indexes().ListSpecifications(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to list the indexes: %w", err)
+	}
+	for _, spec := range specs {
+		if keys, err := spec.KeysDocument.Elements(); err == nil && len(keys) == 1 && keys[0].Key() == "__safeContent__" {
+			return nil
+		}
+	}
+	return fmt.Errorf("the collection has no index on __safeContent__")
+}
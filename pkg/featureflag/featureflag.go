@@ -0,0 +1,285 @@
+// Package featureflag reads feature flags stored as documents of a collection. A Store keeps the
+// flags in memory, so reading them costs no round trip, and reloads them from a change stream as
+// soon as they are set or unset.
+package featureflag
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"k8s.io/klog"
+)
+
+var (
+	flagsLoaded = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "mongodb_client_feature_flags",
+		Help: "Number of feature flags held in memory.",
+	})
+	flagChanges = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "mongodb_client_feature_flag_changes_total",
+		Help: "Number of feature flag changes received from the change stream.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(flagsLoaded, flagChanges)
+}
+
+// errDropped is returned by the change stream when the collection of the flags is dropped or
+// renamed, the stream is then reopened and the flags reloaded.
+var errDropped = errors.New("the feature flag collection was dropped or renamed")
+
+// Flag is the document of a feature flag.
+type Flag struct {
+	Name string `bson:"_id" json:"name"`
+	// Value is a boolean for flags turning features on and off, or any value configuring a feature.
+	Value       bson.RawValue `bson:"value" json:"-"`
+	Description string        `bson:"description,omitempty" json:"description,omitempty"`
+	UpdatedAt   time.Time     `bson:"updatedAt" json:"updatedAt"`
+}
+
+// Store holds the flags of a collection in memory. Its typed getters return the default they are
+// given when the flag is unset or holds a value of another type.
+type Store struct {
+	collection *mongo.Collection
+
+	lock   sync.RWMutex
+	values map[string]bson.RawValue
+}
+
+// NewStore returns a store of the flags of collection, empty until loaded by Load or Watch.
+func NewStore(collection *mongo.Collection) *Store {
+	return &Store{collection: collection, values: map[string]bson.RawValue{}}
+}
+
+// Load replaces the flags in memory with those of the collection.
+func (s *Store) Load(ctx context.Context) error {
+	flags, err := List(ctx, s.collection)
+	if err != nil {
+		return err
+	}
+	values := make(map[string]bson.RawValue, len(flags))
+	for _, flag := range flags {
+		values[flag.Name] = flag.Value
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.values = values
+	flagsLoaded.Set(float64(len(values)))
+	return nil
+}
+
+// Watch loads the flags and applies their changes until ctx is cancelled. The flags are reloaded
+// whenever the change stream has to be reopened, so no change is missed, while the flags in memory
+// keep being served.
+func (s *Store) Watch(ctx context.Context) error {
+	delay := time.Second
+	for {
+		start := time.Now()
+		err := s.watch(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if time.Since(start) > time.Minute {
+			delay = time.Second
+		}
+		klog.Warningf("Feature flag change stream of %s failed, reloading in %s: %v", s.collection.Name(), delay, err)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+		if delay < time.Minute {
+			delay *= 2
+		}
+	}
+}
+
+type changeEvent struct {
+	OperationType string `bson:"operationType"`
+	DocumentKey   struct {
+		Name string `bson:"_id"`
+	} `bson:"documentKey"`
+	FullDocument *Flag `bson:"fullDocument"`
+}
+
+// watch opens the change stream before loading the flags, so the changes made during the load are
+// received, and applies the changes until the stream fails.
+func (s *Store) watch(ctx context.Context) error {
+	stream, err := s.collection.Watch(ctx, mongo.Pipeline{}, options.ChangeStream().SetFullDocument(options.UpdateLookup))
+	if err != nil {
+		return fmt.Errorf("unable to watch the feature flags: %w", err)
+	}
+	defer stream.Close(context.Background())
+	if err := s.Load(ctx); err != nil {
+		return err
+	}
+	for stream.Next(ctx) {
+		var event changeEvent
+		if err := stream.Decode(&event); err != nil {
+			return err
+		}
+		switch event.OperationType {
+		case "insert", "update", "replace":
+			// The document looked up by an update is its current state, nil once deleted.
+			if event.FullDocument == nil {
+				s.unset(event.DocumentKey.Name)
+			} else {
+				s.set(event.FullDocument.Name, event.FullDocument.Value)
+			}
+		case "delete":
+			s.unset(event.DocumentKey.Name)
+		case "drop", "rename", "dropDatabase", "invalidate":
+			return errDropped
+		default:
+			continue
+		}
+		flagChanges.Inc()
+		klog.V(2).Infof("Feature flag %s changed (%s)", event.DocumentKey.Name, event.OperationType)
+	}
+	return stream.Err()
+}
+
+func (s *Store) set(name string, value bson.RawValue) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.values[name] = value
+	flagsLoaded.Set(float64(len(s.values)))
+}
+
+func (s *Store) unset(name string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.values, name)
+	flagsLoaded.Set(float64(len(s.values)))
+}
+
+// Value returns the value of the flag called name, and whether it is set.
+func (s *Store) Value(name string) (bson.RawValue, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	value, ok := s.values[name]
+	return value, ok
+}
+
+// Bool returns the boolean value of the flag called name.
+func (s *Store) Bool(name string, def bool) bool {
+	if value, ok := s.Value(name); ok {
+		if b, ok := value.BooleanOK(); ok {
+			return b
+		}
+	}
+	return def
+}
+
+// String returns the string value of the flag called name.
+func (s *Store) String(name string, def string) string {
+	if value, ok := s.Value(name); ok {
+		if str, ok := value.StringValueOK(); ok {
+			return str
+		}
+	}
+	return def
+}
+
+// Int returns the integer value of the flag called name.
+func (s *Store) Int(name string, def int64) int64 {
+	if value, ok := s.Value(name); ok {
+		if n, ok := value.AsInt64OK(); ok {
+			return n
+		}
+	}
+	return def
+}
+
+// Float returns the numeric value of the flag called name.
+func (s *Store) Float(name string, def float64) float64 {
+	if value, ok := s.Value(name); ok {
+		if f, ok := value.DoubleOK(); ok {
+			return f
+		}
+		if n, ok := value.AsInt64OK(); ok {
+			return float64(n)
+		}
+	}
+	return def
+}
+
+// Duration returns the value of the flag called name, a duration string such as "1m30s".
+func (s *Store) Duration(name string, def time.Duration) time.Duration {
+	if value, ok := s.Value(name); ok {
+		if str, ok := value.StringValueOK(); ok {
+			if d, err := time.ParseDuration(str); err == nil {
+				return d
+			}
+		}
+	}
+	return def
+}
+
+// Decode unmarshals the value of the flag called name into v, and reports whether it is set.
+func (s *Store) Decode(name string, v interface{}) (bool, error) {
+	value, ok := s.Value(name)
+	if !ok {
+		return false, nil
+	}
+	if err := value.Unmarshal(v); err != nil {
+		return true, fmt.Errorf("feature flag %s: %w", name, err)
+	}
+	return true, nil
+}
+
+// Names returns the names of the flags in memory, sorted.
+func (s *Store) Names() []string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	names := make([]string, 0, len(s.values))
+	for name := range s.values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// List returns the flags of collection sorted by name.
+func List(ctx context.Context, collection *mongo.Collection) ([]Flag, error) {
+	cursor, err := collection.Find(ctx, bson.D{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the feature flags: %w", err)
+	}
+	var flags []Flag
+	if err := cursor.All(ctx, &flags); err != nil {
+		return nil, fmt.Errorf("unable to read the feature flags: %w", err)
+	}
+	return flags, nil
+}
+
+// Set sets the flag called name of collection to value. An empty description keeps the current one.
+func Set(ctx context.Context, collection *mongo.Collection, name string, value interface{}, description string) error {
+	fields := bson.D{{Key: "value", Value: value}, {Key: "updatedAt", Value: time.Now().UTC()}}
+	if len(description) > 0 {
+		fields = append(fields, bson.E{Key: "description", Value: description})
+	}
+	_, err := collection.UpdateOne(ctx, bson.D{{Key: "_id", Value: name}}, bson.D{{Key: "$set", Value: fields}}, options.Update().SetUpsert(true))
+	if err != nil {
+		return fmt.Errorf("unable to set feature flag %s: %w", name, err)
+	}
+	return nil
+}
+
+// Unset removes the flag called name of collection, and reports whether it was set.
+func Unset(ctx context.Context, collection *mongo.Collection, name string) (bool, error) {
+	result, err := collection.DeleteOne(ctx, bson.D{{Key: "_id", Value: name}})
+	if err != nil {
+		return false, fmt.Errorf("unable to unset feature flag %s: %w", name, err)
+	}
+	return result.DeletedCount > 0, nil
+}
@@ -0,0 +1,372 @@
+// Package fixtures loads named sets of sample documents from YAML or JSON files into a database.
+// The documents of a set may refer to each other by name, such as an episode to its podcast, and
+// are given stable _ids so that loading a set again replaces its documents instead of duplicating
+// them.
+package fixtures
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/bulk"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// nameField names a document so that other documents can refer to it, it is not written.
+	nameField = "_name"
+	// referenceField is the key of the documents replaced by the _id of the named document they
+	// refer to, such as {"$fixture": "polyglot"}.
+	referenceField = "$fixture"
+)
+
+// extensions are those of the files of the sets, by order of preference.
+var extensions = []string{".yaml", ".yml", ".json"}
+
+// Set is the file <dir>/<name>.yaml of a fixture set, such as:
+//
+//	include: [base]
+//	collections:
+//	  podcasts:
+//	  - _name: polyglot
+//	    title: The Polyglot Developer Podcast
+//	  episodes:
+//	  - podcast: {$fixture: polyglot}
+//	    title: GraphQL for API Development
+type Set struct {
+	// Include are the sets loaded before this one.
+	Include []string `json:"include,omitempty"`
+	// Database of the documents of the set, the database the set is loaded into when it is empty.
+	Database string `json:"database,omitempty"`
+	// Collections are the Extended JSON documents of the set by collection.
+	Collections map[string][]json.RawMessage `json:"collections"`
+}
+
+// Document is a document of a fixture set.
+type Document struct {
+	// Database is empty for the database the fixtures are loaded into.
+	Database   string
+	Collection string
+	// Name is set when the document can be referred to.
+	Name string
+	// ID is the _id of Document once the fixtures are resolved.
+	ID       interface{}
+	Document bson.D
+	// Set is the name of the set the document belongs to.
+	Set string
+}
+
+// Fixtures are the documents of one or more sets and of the sets they include.
+type Fixtures struct {
+	// Sets are the names of the loaded sets, included sets first.
+	Sets      []string
+	Documents []Document
+}
+
+// Sets returns the names of the sets of dir.
+func Sets(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the fixtures: %w", err)
+	}
+	seen := map[string]bool{}
+	var names []string
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		name := strings.TrimSuffix(entry.Name(), ext)
+		if entry.IsDir() || !known(ext) || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func known(ext string) bool {
+	for _, extension := range extensions {
+		if ext == extension {
+			return true
+		}
+	}
+	return false
+}
+
+// Load reads the sets called names from dir, together with the sets they include. A set included
+// several times is loaded once.
+func Load(dir string, names ...string) (*Fixtures, error) {
+	l := &loader{dir: dir, loaded: map[string]bool{}, loading: map[string]bool{}, fixtures: &Fixtures{}}
+	for _, name := range names {
+		if err := l.load(name); err != nil {
+			return nil, err
+		}
+	}
+	return l.fixtures, nil
+}
+
+type loader struct {
+	dir      string
+	loaded   map[string]bool
+	loading  map[string]bool
+	fixtures *Fixtures
+}
+
+func (l *loader) load(name string) error {
+	if l.loaded[name] {
+		return nil
+	}
+	if l.loading[name] {
+		return fmt.Errorf("fixture set %s includes itself", name)
+	}
+	if len(name) == 0 || strings.ContainsAny(name, "/\\") {
+		return fmt.Errorf("invalid fixture set name %q", name)
+	}
+	l.loading[name] = true
+	defer delete(l.loading, name)
+
+	path, err := l.path(name)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("unable to read fixture set: %w", err)
+	}
+	set := &Set{}
+	if err := yaml.UnmarshalStrict(data, set); err != nil {
+		return fmt.Errorf("unable to parse fixture set %s: %w", path, err)
+	}
+	for _, include := range set.Include {
+		if err := l.load(include); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	collections := make([]string, 0, len(set.Collections))
+	for collection := range set.Collections {
+		if len(collection) == 0 || strings.ContainsAny(collection, "$\x00") {
+			return fmt.Errorf("invalid collection name %q in %s", collection, path)
+		}
+		collections = append(collections, collection)
+	}
+	sort.Strings(collections)
+	for _, collection := range collections {
+		for i, data := range set.Collections[collection] {
+			doc := bson.D{}
+			if err := bson.UnmarshalExtJSON(data, false, &doc); err != nil {
+				return fmt.Errorf("document %d of %s in %s is not an Extended JSON document: %w", i+1, collection, path, err)
+			}
+			document := Document{Database: set.Database, Collection: collection, Set: name}
+			for j := 0; j < len(doc); j++ {
+				if doc[j].Key != nameField {
+					continue
+				}
+				n, ok := doc[j].Value.(string)
+				if !ok || len(n) == 0 {
+					return fmt.Errorf("document %d of %s in %s: %s must be a non-empty string", i+1, collection, path, nameField)
+				}
+				document.Name = n
+				doc = append(doc[:j], doc[j+1:]...)
+				break
+			}
+			document.Document = doc
+			l.fixtures.Documents = append(l.fixtures.Documents, document)
+		}
+	}
+	l.loaded[name] = true
+	l.fixtures.Sets = append(l.fixtures.Sets, name)
+	return nil
+}
+
+// path returns the file of the set called name.
+func (l *loader) path(name string) (string, error) {
+	for _, ext := range extensions {
+		path := filepath.Join(l.dir, name+ext)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("unable to read fixture set: %w", err)
+		}
+	}
+	return "", fmt.Errorf("fixture set %s not found in %s", name, l.dir)
+}
+
+// Resolve gives an _id to the documents without one and replaces the references to named documents
+// by their _id. With stable set the _ids are derived from the collection and the name of the
+// documents, or from their contents when they have no name, so that they are the same every time
+// the fixtures are loaded. Otherwise new ObjectIDs are generated.
+func (f *Fixtures) Resolve(stable bool) ([]Document, error) {
+	documents := make([]Document, 0, len(f.Documents))
+	ids := map[string]interface{}{}
+	for _, document := range f.Documents {
+		doc := append(bson.D(nil), document.Document...)
+		var id interface{}
+		for _, element := range doc {
+			if element.Key == "_id" {
+				id = element.Value
+				break
+			}
+		}
+		if id == nil {
+			id = primitive.NewObjectID()
+			if stable {
+				var err error
+				if id, err = stableID(document); err != nil {
+					return nil, err
+				}
+			}
+			doc = append(bson.D{{Key: "_id", Value: id}}, doc...)
+		}
+		if len(document.Name) > 0 {
+			if _, ok := ids[document.Name]; ok {
+				return nil, fmt.Errorf("fixture %s of set %s is defined twice", document.Name, document.Set)
+			}
+			ids[document.Name] = id
+		}
+		document.ID = id
+		document.Document = doc
+		documents = append(documents, document)
+	}
+
+	for i := range documents {
+		value, err := resolve(documents[i].Document, ids)
+		if err != nil {
+			return nil, fmt.Errorf("document of %s in set %s: %w", documents[i].Collection, documents[i].Set, err)
+		}
+		documents[i].Document = value.(bson.D)
+	}
+	return documents, nil
+}
+
+// stableID returns an ObjectID derived from the namespace and the name or contents of document.
+func stableID(document Document) (primitive.ObjectID, error) {
+	key := []byte(document.Name)
+	if len(document.Name) == 0 {
+		data, err := bson.Marshal(document.Document)
+		if err != nil {
+			return primitive.ObjectID{}, fmt.Errorf("unable to encode document of %s in set %s: %w", document.Collection, document.Set, err)
+		}
+		key = data
+	}
+	hash := sha256.New()
+	hash.Write([]byte(document.Database + "\x00" + document.Collection + "\x00"))
+	hash.Write(key)
+	var id primitive.ObjectID
+	copy(id[:], hash.Sum(nil))
+	return id, nil
+}
+
+// resolve returns value with the references to named documents replaced by their _id.
+func resolve(value interface{}, ids map[string]interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case bson.D:
+		if len(v) == 1 && v[0].Key == referenceField {
+			name, ok := v[0].Value.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s must name a fixture", referenceField)
+			}
+			id, ok := ids[name]
+			if !ok {
+				return nil, fmt.Errorf("no fixture called %s", name)
+			}
+			return id, nil
+		}
+		out := make(bson.D, 0, len(v))
+		for _, element := range v {
+			resolved, err := resolve(element.Value, ids)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", element.Key, err)
+			}
+			out = append(out, bson.E{Key: element.Key, Value: resolved})
+		}
+		return out, nil
+	case bson.A:
+		out := make(bson.A, 0, len(v))
+		for i, item := range v {
+			resolved, err := resolve(item, ids)
+			if err != nil {
+				return nil, fmt.Errorf("%d: %w", i, err)
+			}
+			out = append(out, resolved)
+		}
+		return out, nil
+	}
+	return value, nil
+}
+
+// Options change how the documents are written.
+type Options struct {
+	// Upsert replaces the documents with the same _id instead of inserting them, so that loading
+	// the same fixtures again leaves a single copy of each document.
+	Upsert bool
+	// BatchSize is the maximum number of documents written per request, 1000 when it is not set.
+	BatchSize int
+	// Retry runs every request when it is set, it only applies to upserts.
+	Retry func(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// Result counts the writes to a collection.
+type Result struct {
+	Database   string
+	Collection string
+	bulk.Result
+}
+
+// Apply writes documents to c, into database unless they have one, and returns the writes by
+// collection, in the order they were first written to. Inserts of documents that already exist fail
+// and are reported as failures of their collection.
+func Apply(ctx context.Context, c *mongo.Client, database string, documents []Document, opts Options) ([]Result, error) {
+	var results []Result
+	index := map[string]int{}
+	models := map[string][]mongo.WriteModel{}
+	for _, document := range documents {
+		db := document.Database
+		if len(db) == 0 {
+			db = database
+		}
+		namespace := db + "." + document.Collection
+		if _, ok := index[namespace]; !ok {
+			index[namespace] = len(results)
+			results = append(results, Result{Database: db, Collection: document.Collection})
+		}
+		var model mongo.WriteModel = mongo.NewInsertOneModel().SetDocument(document.Document)
+		if opts.Upsert {
+			model = mongo.NewReplaceOneModel().SetFilter(bson.D{{Key: "_id", Value: document.ID}}).SetReplacement(document.Document).SetUpsert(true)
+		}
+		models[namespace] = append(models[namespace], model)
+	}
+
+	var failed bool
+	for i := range results {
+		result := &results[i]
+		namespace := result.Database + "." + result.Collection
+		writeOptions := bulk.Options{BatchSize: opts.BatchSize}
+		if opts.Upsert {
+			writeOptions.Retry = opts.Retry
+		}
+		written, err := bulk.Write(ctx, c.Database(result.Database).Collection(result.Collection), models[namespace], writeOptions)
+		result.Result = written
+		if err != nil {
+			if !errors.As(err, new(*bulk.Error)) {
+				return results, fmt.Errorf("unable to load the fixtures of %s: %w", namespace, err)
+			}
+			failed = true
+		}
+	}
+	if failed {
+		return results, fmt.Errorf("some fixtures could not be written")
+	}
+	return results, nil
+}
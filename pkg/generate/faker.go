@@ -0,0 +1,261 @@
+package generate
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	firstNames = []string{"Ada", "Alan", "Barbara", "Brian", "Carol", "Dennis", "Edsger", "Frances", "Grace", "Guido",
+		"Hedy", "Ivan", "Jean", "John", "Ken", "Linus", "Margaret", "Niklaus", "Radia", "Rob",
+		"Shafi", "Sophie", "Tim", "Yukihiro"}
+	lastNames = []string{"Allen", "Backus", "Cerf", "Dijkstra", "Eich", "Floyd", "Goldberg", "Hamilton", "Hopper", "Kernighan",
+		"Knuth", "Lamport", "Liskov", "Lovelace", "McCarthy", "Perlman", "Pike", "Ritchie", "Stroustrup", "Thompson",
+		"Torvalds", "Turing", "Wilson", "Wirth"}
+	companies = []string{"Acme", "Globex", "Initech", "Umbrella", "Hooli", "Stark Industries", "Wayne Enterprises", "Wonka",
+		"Cyberdyne", "Soylent", "Tyrell", "Vandelay Industries"}
+	cities    = []string{"Amsterdam", "Austin", "Berlin", "Boston", "Brno", "Buenos Aires", "Lisbon", "London", "Nairobi", "Osaka", "Paris", "Raleigh", "Seoul", "Sydney", "Toronto", "Zurich"}
+	countries = []string{"Argentina", "Australia", "Canada", "Czechia", "France", "Germany", "Japan", "Kenya", "Netherlands", "Portugal", "South Korea", "Switzerland", "United Kingdom", "United States"}
+	streets   = []string{"Main Street", "High Street", "Church Road", "Park Avenue", "Station Road", "Mill Lane", "Elm Street", "Oak Avenue", "Bridge Street", "King Street"}
+	domains   = []string{"example.com", "example.org", "example.net"}
+	words     = []string{"alpha", "api", "async", "binary", "buffer", "cache", "cluster", "compile", "cursor", "data",
+		"debug", "deploy", "document", "driver", "index", "kernel", "latency", "lambda", "memory", "network",
+		"node", "pipeline", "query", "queue", "replica", "schema", "server", "shard", "stream", "thread"}
+)
+
+// faker returns a value for the index-th document from r.
+type faker func(r *rand.Rand, index int64) interface{}
+
+// fakers are the functions of {"$fake": <name>}, built from the other fields of the document.
+var fakers = map[string]func(args bson.D) (faker, error){
+	"firstName": constant(func(r *rand.Rand) interface{} { return pick(r, firstNames) }),
+	"lastName":  constant(func(r *rand.Rand) interface{} { return pick(r, lastNames) }),
+	"name": constant(func(r *rand.Rand) interface{} {
+		return pick(r, firstNames) + " " + pick(r, lastNames)
+	}),
+	"username": constant(func(r *rand.Rand) interface{} {
+		return fmt.Sprintf("%s%d", strings.ToLower(pick(r, firstNames)), r.Intn(10000))
+	}),
+	"email": constant(func(r *rand.Rand) interface{} {
+		return fmt.Sprintf("%s.%s%d@%s", strings.ToLower(pick(r, firstNames)), strings.ToLower(pick(r, lastNames)), r.Intn(1000), pick(r, domains))
+	}),
+	"company": constant(func(r *rand.Rand) interface{} { return pick(r, companies) }),
+	"city":    constant(func(r *rand.Rand) interface{} { return pick(r, cities) }),
+	"country": constant(func(r *rand.Rand) interface{} { return pick(r, countries) }),
+	"street": constant(func(r *rand.Rand) interface{} {
+		return fmt.Sprintf("%d %s", 1+r.Intn(999), pick(r, streets))
+	}),
+	"phone": constant(func(r *rand.Rand) interface{} {
+		return fmt.Sprintf("+1-%03d-%03d-%04d", 200+r.Intn(800), r.Intn(1000), r.Intn(10000))
+	}),
+	"url": constant(func(r *rand.Rand) interface{} {
+		return fmt.Sprintf("https://%s/%s/%s", pick(r, domains), pick(r, words), pick(r, words))
+	}),
+	"word":      constant(func(r *rand.Rand) interface{} { return pick(r, words) }),
+	"sentence":  constant(func(r *rand.Rand) interface{} { return sentence(r) }),
+	"paragraph": constant(paragraph),
+	"bool":      constant(func(r *rand.Rand) interface{} { return r.Intn(2) == 0 }),
+	"uuid": constant(func(r *rand.Rand) interface{} {
+		b := make([]byte, 16)
+		r.Read(b)
+		b[6] = b[6]&0x0f | 0x40
+		b[8] = b[8]&0x3f | 0x80
+		return primitive.Binary{Subtype: 4, Data: b}
+	}),
+	"objectId": constant(func(r *rand.Rand) interface{} {
+		var id primitive.ObjectID
+		r.Read(id[:])
+		return id
+	}),
+	"int":      fakeInt,
+	"double":   fakeDouble,
+	"date":     fakeDate,
+	"pick":     fakePick,
+	"sequence": fakeSequence,
+}
+
+// Fakers returns the names of the functions of {"$fake": <name>}.
+func Fakers() []string {
+	names := make([]string, 0, len(fakers))
+	for name := range fakers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func pick(r *rand.Rand, values []string) string {
+	return values[r.Intn(len(values))]
+}
+
+func sentence(r *rand.Rand) string {
+	n := 4 + r.Intn(8)
+	parts := make([]string, n)
+	for i := range parts {
+		parts[i] = pick(r, words)
+	}
+	s := strings.Join(parts, " ")
+	return strings.ToUpper(s[:1]) + s[1:] + "."
+}
+
+func paragraph(r *rand.Rand) interface{} {
+	n := 3 + r.Intn(4)
+	parts := make([]string, n)
+	for i := range parts {
+		parts[i] = sentence(r)
+	}
+	return strings.Join(parts, " ")
+}
+
+// constant returns the builder of a function without arguments.
+func constant(fn func(r *rand.Rand) interface{}) func(args bson.D) (faker, error) {
+	return func(args bson.D) (faker, error) {
+		if len(args) > 0 {
+			return nil, fmt.Errorf("unexpected argument %s", args[0].Key)
+		}
+		return func(r *rand.Rand, index int64) interface{} { return fn(r) }, nil
+	}
+}
+
+// fakeInt returns integers between min and max, both included, 0 and 100 by default.
+func fakeInt(args bson.D) (faker, error) {
+	bounds, err := numbers(args, map[string]float64{"min": 0, "max": 100})
+	if err != nil {
+		return nil, err
+	}
+	min, max := int64(bounds["min"]), int64(bounds["max"])
+	if max < min {
+		return nil, fmt.Errorf("max must not be lower than min")
+	}
+	return func(r *rand.Rand, index int64) interface{} {
+		return min + r.Int63n(max-min+1)
+	}, nil
+}
+
+// fakeDouble returns numbers between min and max, 0 and 1 by default.
+func fakeDouble(args bson.D) (faker, error) {
+	bounds, err := numbers(args, map[string]float64{"min": 0, "max": 1})
+	if err != nil {
+		return nil, err
+	}
+	min, max := bounds["min"], bounds["max"]
+	if max < min {
+		return nil, fmt.Errorf("max must not be lower than min")
+	}
+	return func(r *rand.Rand, index int64) interface{} {
+		return min + r.Float64()*(max-min)
+	}, nil
+}
+
+// fakeSequence returns the index of the document plus start, 0 by default.
+func fakeSequence(args bson.D) (faker, error) {
+	values, err := numbers(args, map[string]float64{"start": 0})
+	if err != nil {
+		return nil, err
+	}
+	start := int64(values["start"])
+	return func(r *rand.Rand, index int64) interface{} {
+		return start + index
+	}, nil
+}
+
+// fakeDate returns dates between from and to, dates or RFC 3339 strings, the last year by default.
+func fakeDate(args bson.D) (faker, error) {
+	to := time.Now().UTC()
+	from := to.AddDate(-1, 0, 0)
+	for _, arg := range args {
+		var t time.Time
+		switch v := arg.Value.(type) {
+		case primitive.DateTime:
+			t = v.Time()
+		case string:
+			parsed, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", arg.Key, err)
+			}
+			t = parsed
+		default:
+			return nil, fmt.Errorf("%s must be a date", arg.Key)
+		}
+		switch arg.Key {
+		case "from":
+			from = t
+		case "to":
+			to = t
+		default:
+			return nil, fmt.Errorf("unexpected argument %s", arg.Key)
+		}
+	}
+	span := to.Sub(from)
+	if span < 0 {
+		return nil, fmt.Errorf("to must not be before from")
+	}
+	return func(r *rand.Rand, index int64) interface{} {
+		return primitive.NewDateTimeFromTime(from.Add(time.Duration(r.Int63n(int64(span) + 1))))
+	}, nil
+}
+
+// fakePick returns one of values, an array of any values.
+func fakePick(args bson.D) (faker, error) {
+	if len(args) != 1 || args[0].Key != "values" {
+		return nil, fmt.Errorf("values must be the only argument")
+	}
+	values, ok := args[0].Value.(bson.A)
+	if !ok || len(values) == 0 {
+		return nil, fmt.Errorf("values must be a non-empty array")
+	}
+	return func(r *rand.Rand, index int64) interface{} {
+		return values[r.Intn(len(values))]
+	}, nil
+}
+
+// numbers returns the numeric arguments of args, which may only be those of defaults.
+func numbers(args bson.D, defaults map[string]float64) (map[string]float64, error) {
+	values := map[string]float64{}
+	for key, value := range defaults {
+		values[key] = value
+	}
+	for _, arg := range args {
+		if _, ok := defaults[arg.Key]; !ok {
+			return nil, fmt.Errorf("unexpected argument %s", arg.Key)
+		}
+		number, ok := toFloat(arg.Value)
+		if !ok {
+			return nil, fmt.Errorf("%s must be a number", arg.Key)
+		}
+		values[arg.Key] = number
+	}
+	return values, nil
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// Faker returns the function of {"$fake": name, <args>}, for the values of documents other than
+// those of a template.
+func Faker(name string, args bson.D) (func(r *rand.Rand) interface{}, error) {
+	build, ok := fakers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown $fake function %s", name)
+	}
+	fn, err := build(args)
+	if err != nil {
+		return nil, fmt.Errorf("$fake %s: %w", name, err)
+	}
+	return func(r *rand.Rand) interface{} { return fn(r, 0) }, nil
+}
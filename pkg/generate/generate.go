@@ -0,0 +1,340 @@
+// Package generate writes volumes of fake documents built from a template to a collection with
+// parallel writers, to populate performance environments without production data.
+package generate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/bulk"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Resolver returns up to size values of field of the documents of collection, the values a
+// reference is chosen from.
+type Resolver func(ctx context.Context, collection, field string, size int) ([]interface{}, error)
+
+// Sample returns a Resolver of the collections of db sampling their documents at random.
+func Sample(db *mongo.Database) Resolver {
+	return func(ctx context.Context, collection, field string, size int) ([]interface{}, error) {
+		cursor, err := db.Collection(collection).Aggregate(ctx, mongo.Pipeline{
+			{{Key: "$sample", Value: bson.D{{Key: "size", Value: size}}}},
+			{{Key: "$project", Value: bson.D{{Key: "_id", Value: 0}, {Key: "value", Value: "$" + field}}}},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable to sample %s: %w", collection, err)
+		}
+		var values []struct {
+			Value interface{} `bson:"value"`
+		}
+		if err := cursor.All(ctx, &values); err != nil {
+			return nil, fmt.Errorf("unable to sample %s: %w", collection, err)
+		}
+		var result []interface{}
+		for _, value := range values {
+			if value.Value != nil {
+				result = append(result, value.Value)
+			}
+		}
+		return result, nil
+	}
+}
+
+// Template builds documents from a document in which the following values are replaced:
+//
+//	{"$fake": "<function>", <arguments>}: a value of a function of Fakers, such as
+//	  {"$fake": "int", "min": 1, "max": 60} or {"$fake": "pick", "values": ["a", "b"]}
+//	{"$array": <template>, "min": 0, "max": 5}: an array of min to max values of the template
+//	{"$ref": "<collection>", "field": "_id", "sample": 1000}: a value of field, _id by default,
+//	  of one of sample documents of collection picked when the template is compiled
+type Template struct {
+	root node
+}
+
+// node builds a value of a template.
+type node func(r *rand.Rand, index int64) interface{}
+
+// Compile returns the template of doc, resolving its references with resolve.
+func Compile(ctx context.Context, doc bson.D, resolve Resolver) (*Template, error) {
+	root, err := compile(ctx, doc, resolve)
+	if err != nil {
+		return nil, err
+	}
+	return &Template{root: root}, nil
+}
+
+// Document returns the index-th document of the template, built from r.
+func (t *Template) Document(r *rand.Rand, index int64) bson.D {
+	return t.root(r, index).(bson.D)
+}
+
+func compile(ctx context.Context, value interface{}, resolve Resolver) (node, error) {
+	switch v := value.(type) {
+	case bson.D:
+		if len(v) > 0 {
+			switch v[0].Key {
+			case "$fake":
+				return compileFake(v)
+			case "$array":
+				return compileArray(ctx, v, resolve)
+			case "$ref":
+				return compileRef(ctx, v, resolve)
+			}
+		}
+		keys := make([]string, len(v))
+		nodes := make([]node, len(v))
+		for i, element := range v {
+			n, err := compile(ctx, element.Value, resolve)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", element.Key, err)
+			}
+			keys[i], nodes[i] = element.Key, n
+		}
+		return func(r *rand.Rand, index int64) interface{} {
+			doc := make(bson.D, len(nodes))
+			for i, n := range nodes {
+				doc[i] = bson.E{Key: keys[i], Value: n(r, index)}
+			}
+			return doc
+		}, nil
+	case bson.A:
+		nodes := make([]node, len(v))
+		for i, item := range v {
+			n, err := compile(ctx, item, resolve)
+			if err != nil {
+				return nil, fmt.Errorf("%d: %w", i, err)
+			}
+			nodes[i] = n
+		}
+		return func(r *rand.Rand, index int64) interface{} {
+			array := make(bson.A, len(nodes))
+			for i, n := range nodes {
+				array[i] = n(r, index)
+			}
+			return array
+		}, nil
+	}
+	return func(r *rand.Rand, index int64) interface{} { return value }, nil
+}
+
+func compileFake(v bson.D) (node, error) {
+	name, ok := v[0].Value.(string)
+	if !ok {
+		return nil, fmt.Errorf("$fake must name a function")
+	}
+	build, ok := fakers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown $fake function %s", name)
+	}
+	fn, err := build(v[1:])
+	if err != nil {
+		return nil, fmt.Errorf("$fake %s: %w", name, err)
+	}
+	return node(fn), nil
+}
+
+func compileArray(ctx context.Context, v bson.D, resolve Resolver) (node, error) {
+	item, err := compile(ctx, v[0].Value, resolve)
+	if err != nil {
+		return nil, fmt.Errorf("$array: %w", err)
+	}
+	bounds, err := numbers(v[1:], map[string]float64{"min": 1, "max": 5})
+	if err != nil {
+		return nil, fmt.Errorf("$array: %w", err)
+	}
+	min, max := int(bounds["min"]), int(bounds["max"])
+	if min < 0 || max < min {
+		return nil, fmt.Errorf("$array: min must not be negative nor greater than max")
+	}
+	return func(r *rand.Rand, index int64) interface{} {
+		array := make(bson.A, min+r.Intn(max-min+1))
+		for i := range array {
+			array[i] = item(r, index)
+		}
+		return array
+	}, nil
+}
+
+func compileRef(ctx context.Context, v bson.D, resolve Resolver) (node, error) {
+	collection, ok := v[0].Value.(string)
+	if !ok || len(collection) == 0 {
+		return nil, fmt.Errorf("$ref must name a collection")
+	}
+	field, size := "_id", 1000
+	for _, arg := range v[1:] {
+		switch arg.Key {
+		case "field":
+			if field, ok = arg.Value.(string); !ok || len(field) == 0 {
+				return nil, fmt.Errorf("$ref %s: field must be a field name", collection)
+			}
+		case "sample":
+			number, ok := toFloat(arg.Value)
+			if !ok || number < 1 {
+				return nil, fmt.Errorf("$ref %s: sample must be a positive number", collection)
+			}
+			size = int(number)
+		default:
+			return nil, fmt.Errorf("$ref %s: unexpected argument %s", collection, arg.Key)
+		}
+	}
+	if resolve == nil {
+		return nil, fmt.Errorf("$ref %s: references cannot be resolved", collection)
+	}
+	values, err := resolve(ctx, collection, field, size)
+	if err != nil {
+		return nil, fmt.Errorf("$ref %s: %w", collection, err)
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("$ref %s: no document has a %s to refer to", collection, field)
+	}
+	return func(r *rand.Rand, index int64) interface{} {
+		return values[r.Intn(len(values))]
+	}, nil
+}
+
+// Options change how many documents are written and how.
+type Options struct {
+	// Count is the number of documents to write.
+	Count int64
+	// Workers is the number of batches written in parallel, 4 when it is not set.
+	Workers int
+	// BatchSize is the number of documents written per request, 1000 when it is not set.
+	BatchSize int
+	// Seed makes the documents the same for the same template and seed: every batch uses a random
+	// source seeded from Seed and the index of its first document.
+	Seed int64
+	// Progress is called with the number of documents written every ProgressInterval, 5 seconds when
+	// it is not set, and once they are all written.
+	Progress         func(Progress)
+	ProgressInterval time.Duration
+}
+
+// Progress is the progress of the writes.
+type Progress struct {
+	Written int64
+	Total   int64
+	Elapsed time.Duration
+}
+
+// Rate returns the number of documents written per second.
+func (p Progress) Rate() float64 {
+	if p.Elapsed <= 0 {
+		return 0
+	}
+	return float64(p.Written) / p.Elapsed.Seconds()
+}
+
+// Remaining estimates the time left to write the documents at the current rate, 0 when unknown.
+func (p Progress) Remaining() time.Duration {
+	rate := p.Rate()
+	if rate == 0 || p.Total <= p.Written {
+		return 0
+	}
+	return time.Duration(float64(p.Total-p.Written) / rate * float64(time.Second))
+}
+
+// Run inserts opts.Count documents of t into collection and returns the counts of the writes. The
+// documents that are rejected, such as those with a duplicate _id, are reported as failures and do
+// not stop the other writes.
+func Run(ctx context.Context, collection *mongo.Collection, t *Template, opts Options) (bulk.Result, error) {
+	if opts.Workers < 1 {
+		opts.Workers = 4
+	}
+	if opts.BatchSize < 1 {
+		opts.BatchSize = 1000
+	}
+	if opts.ProgressInterval <= 0 {
+		opts.ProgressInterval = 5 * time.Second
+	}
+
+	var next, written int64
+	started := time.Now()
+	progress := func() Progress {
+		return Progress{Written: atomic.LoadInt64(&written), Total: opts.Count, Elapsed: time.Since(started)}
+	}
+	done := make(chan struct{})
+	var reporting sync.WaitGroup
+	if opts.Progress != nil {
+		reporting.Add(1)
+		go func() {
+			defer reporting.Done()
+			ticker := time.NewTicker(opts.ProgressInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+					opts.Progress(progress())
+				}
+			}
+		}()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		lock     sync.Mutex
+		workers  sync.WaitGroup
+		firstErr error
+		counts   bulk.Result
+	)
+	for i := 0; i < opts.Workers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			models := make([]mongo.WriteModel, 0, opts.BatchSize)
+			for ctx.Err() == nil {
+				start := atomic.AddInt64(&next, int64(opts.BatchSize)) - int64(opts.BatchSize)
+				if start >= opts.Count {
+					return
+				}
+				end := start + int64(opts.BatchSize)
+				if end > opts.Count {
+					end = opts.Count
+				}
+				r := rand.New(rand.NewSource(opts.Seed + start))
+				models = models[:0]
+				for index := start; index < end; index++ {
+					models = append(models, mongo.NewInsertOneModel().SetDocument(t.Document(r, index)))
+				}
+				result, err := bulk.Write(ctx, collection, models, bulk.Options{BatchSize: opts.BatchSize})
+				atomic.AddInt64(&written, result.Inserted)
+				var failed *bulk.Error
+				if errors.As(err, &failed) {
+					// Failures are indexed from the start of the batch, make them relative to the run.
+					for i := range result.Failures {
+						result.Failures[i].Index += int(start)
+					}
+					err = nil
+				}
+				lock.Lock()
+				counts.Add(result)
+				if err != nil && firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+	workers.Wait()
+	close(done)
+	reporting.Wait()
+	if firstErr != nil {
+		return counts, fmt.Errorf("unable to write to %s: %w", collection.Name(), firstErr)
+	}
+	if opts.Progress != nil {
+		opts.Progress(progress())
+	}
+	if len(counts.Failures) > 0 {
+		return counts, &bulk.Error{Failures: counts.Failures}
+	}
+	return counts, ctx.Err()
+}
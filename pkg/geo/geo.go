@@ -0,0 +1,280 @@
+// Package geo reads and validates GeoJSON geometries and builds the geospatial queries of the
+// 2dsphere indexes, on which MongoDB stores longitudes and latitudes in WGS84.
+package geo
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Geometry is a GeoJSON geometry. Coordinates hold nested arrays of numbers, the positions of the
+// geometry as [longitude, latitude].
+type Geometry struct {
+	Type        string      `json:"type" bson:"type"`
+	Coordinates interface{} `json:"coordinates,omitempty" bson:"coordinates,omitempty"`
+	// Geometries are the members of a GeometryCollection.
+	Geometries []Geometry `json:"geometries,omitempty" bson:"geometries,omitempty"`
+}
+
+// Point returns the Point geometry at lng, lat.
+func Point(lng, lat float64) Geometry {
+	return Geometry{Type: "Point", Coordinates: []interface{}{lng, lat}}
+}
+
+// Load reads the geometry of a GeoJSON file, which holds a geometry, a Feature, or a
+// FeatureCollection whose geometries are combined into a single geometry.
+func Load(path string) (Geometry, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Geometry{}, fmt.Errorf("unable to read GeoJSON: %w", err)
+	}
+	geometry, err := Parse(data)
+	if err != nil {
+		return Geometry{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return geometry, nil
+}
+
+// Parse returns the geometry of a GeoJSON document, see Load, once validated.
+func Parse(data []byte) (Geometry, error) {
+	var object struct {
+		Geometry
+		// Member is the geometry of a Feature.
+		Member   *Geometry `json:"geometry"`
+		Features []struct {
+			Geometry *Geometry `json:"geometry"`
+		} `json:"features"`
+	}
+	if err := json.Unmarshal(data, &object); err != nil {
+		return Geometry{}, fmt.Errorf("invalid GeoJSON: %w", err)
+	}
+	geometry := object.Geometry
+	switch object.Type {
+	case "Feature":
+		if object.Member == nil {
+			return Geometry{}, fmt.Errorf("the feature has no geometry")
+		}
+		geometry = *object.Member
+	case "FeatureCollection":
+		var geometries []Geometry
+		for i, feature := range object.Features {
+			if feature.Geometry == nil {
+				return Geometry{}, fmt.Errorf("feature %d has no geometry", i)
+			}
+			geometries = append(geometries, *feature.Geometry)
+		}
+		var err error
+		if geometry, err = combine(geometries); err != nil {
+			return Geometry{}, err
+		}
+	}
+	return geometry, geometry.Validate()
+}
+
+// combine returns the single geometry of a FeatureCollection: its only geometry, a MultiPolygon
+// of its polygons, a MultiPoint of its points, or else a GeometryCollection.
+func combine(geometries []Geometry) (Geometry, error) {
+	switch len(geometries) {
+	case 0:
+		return Geometry{}, fmt.Errorf("the feature collection has no features")
+	case 1:
+		return geometries[0], nil
+	}
+	polygons, points := true, true
+	for _, geometry := range geometries {
+		polygons = polygons && (geometry.Type == "Polygon" || geometry.Type == "MultiPolygon")
+		points = points && (geometry.Type == "Point" || geometry.Type == "MultiPoint")
+	}
+	if !polygons && !points {
+		return Geometry{Type: "GeometryCollection", Geometries: geometries}, nil
+	}
+	multi := Geometry{Type: "MultiPolygon"}
+	if points {
+		multi.Type = "MultiPoint"
+	}
+	var coordinates []interface{}
+	for _, geometry := range geometries {
+		members, ok := geometry.Coordinates.([]interface{})
+		if !ok {
+			return Geometry{}, fmt.Errorf("%s: coordinates must be an array", geometry.Type)
+		}
+		if geometry.Type == "Polygon" || geometry.Type == "Point" {
+			coordinates = append(coordinates, members)
+		} else {
+			coordinates = append(coordinates, members...)
+		}
+	}
+	multi.Coordinates = coordinates
+	return multi, nil
+}
+
+// Validate reports whether the geometry is valid GeoJSON with positions within the longitudes and
+// latitudes of the Earth, as required by 2dsphere indexes.
+func (g *Geometry) Validate() error {
+	switch g.Type {
+	case "Point":
+		return position(g.Coordinates)
+	case "MultiPoint":
+		return each(g.Coordinates, position)
+	case "LineString":
+		return lineString(g.Coordinates)
+	case "MultiLineString":
+		return each(g.Coordinates, lineString)
+	case "Polygon":
+		return polygon(g.Coordinates)
+	case "MultiPolygon":
+		return each(g.Coordinates, polygon)
+	case "GeometryCollection":
+		if len(g.Geometries) == 0 {
+			return fmt.Errorf("the GeometryCollection has no geometries")
+		}
+		for i := range g.Geometries {
+			if err := g.Geometries[i].Validate(); err != nil {
+				return fmt.Errorf("geometry %d: %w", i, err)
+			}
+		}
+		return nil
+	case "":
+		return fmt.Errorf("the geometry has no type")
+	}
+	return fmt.Errorf("unsupported geometry type %q", g.Type)
+}
+
+// ValidateValue reports whether a value of a document is a valid GeoJSON geometry.
+func ValidateValue(value bson.RawValue) error {
+	doc, ok := value.DocumentOK()
+	if !ok {
+		return fmt.Errorf("the value is a %s, not a GeoJSON object", value.Type)
+	}
+	// Extended JSON turns the numbers of every BSON type into JSON numbers.
+	data, err := bson.MarshalExtJSON(doc, false, false)
+	if err != nil {
+		return err
+	}
+	var geometry Geometry
+	if err := json.Unmarshal(data, &geometry); err != nil {
+		return fmt.Errorf("invalid GeoJSON: %w", err)
+	}
+	return geometry.Validate()
+}
+
+func array(coordinates interface{}) ([]interface{}, error) {
+	values, ok := coordinates.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("coordinates must be an array, not %v", coordinates)
+	}
+	return values, nil
+}
+
+func each(coordinates interface{}, validate func(interface{}) error) error {
+	members, err := array(coordinates)
+	if err != nil {
+		return err
+	}
+	if len(members) == 0 {
+		return fmt.Errorf("coordinates must not be empty")
+	}
+	for i, member := range members {
+		if err := validate(member); err != nil {
+			return fmt.Errorf("member %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// position validates [longitude, latitude], an optional altitude is ignored.
+func position(coordinates interface{}) error {
+	values, err := array(coordinates)
+	if err != nil {
+		return err
+	}
+	if len(values) < 2 || len(values) > 3 {
+		return fmt.Errorf("a position must be [longitude, latitude], not %v", values)
+	}
+	lng, ok1 := values[0].(float64)
+	lat, ok2 := values[1].(float64)
+	switch {
+	case !ok1 || !ok2:
+		return fmt.Errorf("a position must hold numbers, not %v", values)
+	case math.IsNaN(lng) || lng < -180 || lng > 180:
+		return fmt.Errorf("longitude %v is not between -180 and 180", lng)
+	case math.IsNaN(lat) || lat < -90 || lat > 90:
+		return fmt.Errorf("latitude %v is not between -90 and 90, are the coordinates [longitude, latitude]?", lat)
+	}
+	return nil
+}
+
+func lineString(coordinates interface{}) error {
+	positions, err := array(coordinates)
+	if err != nil {
+		return err
+	}
+	if len(positions) < 2 {
+		return fmt.Errorf("a line must have at least 2 positions")
+	}
+	return each(positions, position)
+}
+
+func polygon(coordinates interface{}) error {
+	rings, err := array(coordinates)
+	if err != nil {
+		return err
+	}
+	if len(rings) == 0 {
+		return fmt.Errorf("a polygon must have an exterior ring")
+	}
+	for i, ring := range rings {
+		positions, err := array(ring)
+		if err != nil {
+			return fmt.Errorf("ring %d: %w", i, err)
+		}
+		if len(positions) < 4 {
+			return fmt.Errorf("ring %d: a ring must have at least 4 positions", i)
+		}
+		if err := each(positions, position); err != nil {
+			return fmt.Errorf("ring %d: %w", i, err)
+		}
+		first, last := positions[0].([]interface{}), positions[len(positions)-1].([]interface{})
+		if first[0] != last[0] || first[1] != last[1] {
+			return fmt.Errorf("ring %d: the last position must be the first one to close the ring", i)
+		}
+	}
+	return nil
+}
+
+// Near returns the aggregation of the documents whose field is within maxDistance meters of point,
+// nearest first, with their distance in meters in distanceField. A zero maxDistance is unbounded.
+func Near(field string, point Geometry, minDistance, maxDistance float64, filter bson.D, distanceField string) mongo.Pipeline {
+	near := bson.D{
+		{Key: "near", Value: point},
+		{Key: "key", Value: field},
+		{Key: "distanceField", Value: distanceField},
+		{Key: "spherical", Value: true},
+	}
+	if minDistance > 0 {
+		near = append(near, bson.E{Key: "minDistance", Value: minDistance})
+	}
+	if maxDistance > 0 {
+		near = append(near, bson.E{Key: "maxDistance", Value: maxDistance})
+	}
+	if len(filter) > 0 {
+		near = append(near, bson.E{Key: "query", Value: filter})
+	}
+	return mongo.Pipeline{{{Key: "$geoNear", Value: near}}}
+}
+
+// Within returns the filter of the documents whose field is entirely within geometry, a Polygon or
+// a MultiPolygon.
+func Within(field string, geometry Geometry) bson.D {
+	return bson.D{{Key: field, Value: bson.D{{Key: "$geoWithin", Value: bson.D{{Key: "$geometry", Value: geometry}}}}}}
+}
+
+// Intersects returns the filter of the documents whose field intersects geometry.
+func Intersects(field string, geometry Geometry) bson.D {
+	return bson.D{{Key: field, Value: bson.D{{Key: "$geoIntersects", Value: bson.D{{Key: "$geometry", Value: geometry}}}}}}
+}
@@ -0,0 +1,352 @@
+// Package graphql serves the documents of configured collections over GraphQL, with a type per
+// collection whose fields are declared or inferred from a sample of its documents.
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/api"
+	"github.com/bradmwilliams/mongodb-client/pkg/client"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"k8s.io/klog"
+)
+
+// Path is where the GraphQL endpoint is served.
+const Path = "/graphql"
+
+// maxBodySize limits the size of queries.
+const maxBodySize = 1 << 20
+
+// Collection is a collection served over GraphQL.
+type Collection struct {
+	// Name names the type and the fields of the collection, such as episodes.
+	Name string
+	// Database defaults to the application database.
+	Database   string
+	Collection string
+	// Fields declares the types of the fields, which are inferred from SampleSize documents when
+	// it is empty.
+	Fields     map[string]string
+	SampleSize int
+}
+
+// Handler serves the GraphQL endpoint.
+type Handler struct {
+	manager     *client.ConnectionManager
+	collections []Collection
+	// Authorize reports whether the caller of ctx may read, or write, database. It is nil when
+	// every caller may.
+	Authorize func(ctx context.Context, database string, write bool) bool
+
+	lock   sync.RWMutex
+	schema *graphql.Schema
+}
+
+// NewHandler returns a handler for collections, serving requests once Load has built the schema.
+func NewHandler(manager *client.ConnectionManager, collections []Collection) *Handler {
+	return &Handler{manager: manager, collections: collections}
+}
+
+// Load builds the schema, sampling the collections without declared fields.
+func (h *Handler) Load(ctx context.Context) error {
+	query, mutation := graphql.Fields{}, graphql.Fields{}
+	sort.Slice(h.collections, func(i, j int) bool { return h.collections[i].Name < h.collections[j].Name })
+	for _, c := range h.collections {
+		if !validName.MatchString(c.Name) {
+			return fmt.Errorf("invalid GraphQL collection name %q", c.Name)
+		}
+		database := c.Database
+		if len(database) == 0 {
+			database = h.manager.Database()
+		}
+		name := c.Collection
+		if len(name) == 0 {
+			name = c.Name
+		}
+		if err := api.CheckNamespace(database, name); err != nil {
+			return fmt.Errorf("GraphQL collection %s: %w", c.Name, err)
+		}
+		collection := h.manager.Primary().Database(database).Collection(name)
+
+		fields := c.Fields
+		if len(fields) == 0 {
+			size := c.SampleSize
+			if size <= 0 {
+				size = DefaultSampleSize
+			}
+			var err error
+			if fields, err = infer(ctx, collection, size); err != nil {
+				return err
+			}
+			klog.V(2).Infof("Inferred the GraphQL fields of %s: %v", c.Name, fields)
+		}
+		t, err := objectType(strings.ToUpper(c.Name[:1])+c.Name[1:], fields)
+		if err != nil {
+			return fmt.Errorf("GraphQL collection %s: %w", c.Name, err)
+		}
+		h.addFields(query, mutation, c.Name, t, database, collection)
+	}
+	if len(query) == 0 {
+		return errors.New("no collections are configured for GraphQL")
+	}
+
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query:    graphql.NewObject(graphql.ObjectConfig{Name: "Query", Fields: query}),
+		Mutation: graphql.NewObject(graphql.ObjectConfig{Name: "Mutation", Fields: mutation}),
+	})
+	if err != nil {
+		return fmt.Errorf("invalid GraphQL schema: %w", err)
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.schema = &schema
+	return nil
+}
+
+// resolver wraps resolve with the authorization of the caller and the operation timeout.
+func (h *Handler) resolver(database string, write bool, resolve func(ctx context.Context, p graphql.ResolveParams) (interface{}, error)) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		if h.Authorize != nil && !h.Authorize(p.Context, database, write) {
+			return nil, fmt.Errorf("access to database %s denied", database)
+		}
+		if !h.manager.Connected() {
+			return nil, errors.New("not connected to database")
+		}
+		kind := client.ReadOperation
+		if write {
+			kind = client.WriteOperation
+		}
+		ctx, cancel := context.WithTimeout(p.Context, h.manager.Timeout(kind))
+		defer cancel()
+		return resolve(ctx, p)
+	}
+}
+
+// addFields adds the queries and mutations of a collection:
+//
+//	episodes(filter, sort, limit, skip), episodesById(id), episodesCount(filter)
+//	insertEpisodes(document), updateEpisodes(id, set), deleteEpisodes(id)
+func (h *Handler) addFields(query, mutation graphql.Fields, name string, t *graphql.Object, database string, collection *mongo.Collection) {
+	suffix := strings.ToUpper(name[:1]) + name[1:]
+	query[name] = &graphql.Field{
+		Type:        graphql.NewList(t),
+		Description: fmt.Sprintf("Documents of %s.%s matching filter, at most %d.", database, collection.Name(), api.MaxLimit),
+		Args: graphql.FieldConfigArgument{
+			"filter": {Type: JSON, Description: "Query document"},
+			"sort":   {Type: graphql.NewList(graphql.String), Description: "Fields to sort by, descending when prefixed by -"},
+			"limit":  {Type: graphql.Int, DefaultValue: api.DefaultLimit},
+			"skip":   {Type: graphql.Int, DefaultValue: 0},
+		},
+		Resolve: h.resolver(database, false, func(ctx context.Context, p graphql.ResolveParams) (interface{}, error) {
+			filter, err := toBSON("filter", p.Args["filter"])
+			if err != nil {
+				return nil, err
+			}
+			limit, _ := p.Args["limit"].(int)
+			skip, _ := p.Args["skip"].(int)
+			if limit < 1 || limit > api.MaxLimit || skip < 0 {
+				return nil, fmt.Errorf("limit must be between 1 and %d and skip must not be negative", api.MaxLimit)
+			}
+			findOptions := options.Find().SetLimit(int64(limit)).SetSkip(int64(skip))
+			if fields, ok := p.Args["sort"].([]interface{}); ok && len(fields) > 0 {
+				findOptions.SetSort(sortSpec(fields))
+			}
+			findOptions.MaxTime = client.MaxTime(ctx)
+			cursor, err := collection.Find(ctx, filter, findOptions)
+			if err != nil {
+				return nil, err
+			}
+			results := make([]interface{}, 0, limit)
+			err = client.Decode(ctx, cursor, func() interface{} { return &bson.D{} }, func(value interface{}) error {
+				results = append(results, plain(*value.(*bson.D)))
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+			return results, nil
+		}),
+	}
+	query[name+"ById"] = &graphql.Field{
+		Type: t,
+		Args: graphql.FieldConfigArgument{"id": {Type: graphql.NewNonNull(graphql.ID)}},
+		Resolve: h.resolver(database, false, func(ctx context.Context, p graphql.ResolveParams) (interface{}, error) {
+			return findByID(ctx, collection, p.Args["id"])
+		}),
+	}
+	query[name+"Count"] = &graphql.Field{
+		Type: graphql.Float,
+		Args: graphql.FieldConfigArgument{"filter": {Type: JSON}},
+		Resolve: h.resolver(database, false, func(ctx context.Context, p graphql.ResolveParams) (interface{}, error) {
+			filter, err := toBSON("filter", p.Args["filter"])
+			if err != nil {
+				return nil, err
+			}
+			return collection.CountDocuments(ctx, filter)
+		}),
+	}
+
+	mutation["insert"+suffix] = &graphql.Field{
+		Type: t,
+		Args: graphql.FieldConfigArgument{"document": {Type: graphql.NewNonNull(JSON)}},
+		Resolve: h.resolver(database, true, func(ctx context.Context, p graphql.ResolveParams) (interface{}, error) {
+			doc, err := toBSON("document", p.Args["document"])
+			if err != nil {
+				return nil, err
+			}
+			result, err := collection.InsertOne(ctx, doc)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := doc.Map()["_id"]; !ok {
+				doc = append(bson.D{{Key: "_id", Value: result.InsertedID}}, doc...)
+			}
+			return plain(doc), nil
+		}),
+	}
+	mutation["update"+suffix] = &graphql.Field{
+		Type:        t,
+		Description: "Sets the fields of set and returns the updated document.",
+		Args: graphql.FieldConfigArgument{
+			"id":  {Type: graphql.NewNonNull(graphql.ID)},
+			"set": {Type: graphql.NewNonNull(JSON)},
+		},
+		Resolve: h.resolver(database, true, func(ctx context.Context, p graphql.ResolveParams) (interface{}, error) {
+			set, err := toBSON("set", p.Args["set"])
+			if err != nil {
+				return nil, err
+			}
+			if len(set) == 0 {
+				return nil, errors.New("empty update")
+			}
+			var doc bson.D
+			err = collection.FindOneAndUpdate(ctx, bson.D{{Key: "_id", Value: id(p.Args["id"])}}, bson.D{{Key: "$set", Value: set}},
+				options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&doc)
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return nil, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			return plain(doc), nil
+		}),
+	}
+	mutation["delete"+suffix] = &graphql.Field{
+		Type:        graphql.Boolean,
+		Description: "Deletes a document, returning whether it existed.",
+		Args:        graphql.FieldConfigArgument{"id": {Type: graphql.NewNonNull(graphql.ID)}},
+		Resolve: h.resolver(database, true, func(ctx context.Context, p graphql.ResolveParams) (interface{}, error) {
+			result, err := collection.DeleteOne(ctx, bson.D{{Key: "_id", Value: id(p.Args["id"])}})
+			if err != nil {
+				return nil, err
+			}
+			return result.DeletedCount > 0, nil
+		}),
+	}
+}
+
+// id parses an ID argument like the identifiers of the REST API.
+func id(value interface{}) interface{} {
+	s, _ := value.(string)
+	return api.ParseID(s)
+}
+
+func findByID(ctx context.Context, collection *mongo.Collection, value interface{}) (interface{}, error) {
+	var doc bson.D
+	err := collection.FindOne(ctx, bson.D{{Key: "_id", Value: id(value)}}).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return plain(doc), nil
+}
+
+type request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// isMutation reports whether the operation of a request is a mutation. Invalid queries are left for
+// the executor to report.
+func isMutation(query, operationName string) bool {
+	document, err := parser.Parse(parser.ParseParams{Source: query})
+	if err != nil {
+		return false
+	}
+	for _, definition := range document.Definitions {
+		operation, ok := definition.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		if len(operationName) == 0 || (operation.Name != nil && operation.Name.Value == operationName) {
+			if operation.Operation == ast.OperationTypeMutation {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.lock.RLock()
+	schema := h.schema
+	h.lock.RUnlock()
+	if schema == nil {
+		http.Error(w, "the GraphQL schema is not loaded yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	var req request
+	switch r.Method {
+	case http.MethodGet:
+		// Queries may be sent as GET requests, mutations may not.
+		req.Query = r.URL.Query().Get("query")
+		req.OperationName = r.URL.Query().Get("operationName")
+		if variables := r.URL.Query().Get("variables"); len(variables) > 0 {
+			if err := json.Unmarshal([]byte(variables), &req.Variables); err != nil {
+				http.Error(w, fmt.Sprintf("invalid variables: %v", err), http.StatusBadRequest)
+				return
+			}
+		}
+		if isMutation(req.Query, req.OperationName) {
+			http.Error(w, "mutations must be sent as POST requests", http.StatusMethodNotAllowed)
+			return
+		}
+	case http.MethodPost:
+		if err := json.NewDecoder(io.LimitReader(r.Body, maxBodySize)).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("invalid GraphQL request: %v", err), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "only GET and POST are supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	result := graphql.Do(graphql.Params{
+		Schema:         *schema,
+		RequestString:  req.Query,
+		OperationName:  req.OperationName,
+		VariableValues: req.Variables,
+		Context:        r.Context(),
+	})
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		klog.Errorf("Unable to write the GraphQL response: %v", err)
+	}
+}
@@ -0,0 +1,264 @@
+package graphql
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// DefaultSampleSize is the number of documents sampled to infer the fields of a collection.
+const DefaultSampleSize = 100
+
+var validName = regexp.MustCompile(`^[_A-Za-z][_0-9A-Za-z]*$`)
+
+// JSON holds arbitrary values: nested documents, arrays of mixed types and filters.
+var JSON = graphql.NewScalar(graphql.ScalarConfig{
+	Name:        "JSON",
+	Description: "Any JSON value. Filters and documents accept Extended JSON such as {\"$oid\": \"...\"}, passed as variables since GraphQL literals cannot name fields with a $.",
+	Serialize:   func(value interface{}) interface{} { return value },
+	ParseValue:  func(value interface{}) interface{} { return value },
+	ParseLiteral: func(value ast.Value) interface{} {
+		return literal(value)
+	},
+})
+
+// literal returns the value of an inline argument of the JSON scalar.
+func literal(value ast.Value) interface{} {
+	switch v := value.(type) {
+	case *ast.ObjectValue:
+		object := map[string]interface{}{}
+		for _, field := range v.Fields {
+			object[field.Name.Value] = literal(field.Value)
+		}
+		return object
+	case *ast.ListValue:
+		list := make([]interface{}, 0, len(v.Values))
+		for _, item := range v.Values {
+			list = append(list, literal(item))
+		}
+		return list
+	case *ast.IntValue:
+		if i, err := strconv.ParseInt(v.Value, 10, 64); err == nil {
+			return i
+		}
+		return nil
+	case *ast.FloatValue:
+		if f, err := strconv.ParseFloat(v.Value, 64); err == nil {
+			return f
+		}
+		return nil
+	case *ast.StringValue:
+		return v.Value
+	case *ast.BooleanValue:
+		return v.Value
+	case *ast.EnumValue:
+		return v.Value
+	}
+	return nil
+}
+
+// fieldTypes are the types a field may be declared with, list types are written [Type].
+var fieldTypes = map[string]graphql.Output{
+	"ID":       graphql.ID,
+	"String":   graphql.String,
+	"Int":      graphql.Int,
+	"Float":    graphql.Float,
+	"Boolean":  graphql.Boolean,
+	"DateTime": graphql.DateTime,
+	"JSON":     JSON,
+}
+
+// parseType returns the output type of a declared field type.
+func parseType(name string) (graphql.Output, error) {
+	if strings.HasPrefix(name, "[") && strings.HasSuffix(name, "]") {
+		elem, err := parseType(strings.TrimSpace(name[1 : len(name)-1]))
+		if err != nil {
+			return nil, err
+		}
+		return graphql.NewList(elem), nil
+	}
+	t, ok := fieldTypes[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported field type %q", name)
+	}
+	return t, nil
+}
+
+// bsonType returns the declared type name standing for the values of a BSON type, JSON for the
+// types GraphQL has no scalar for.
+func bsonType(t bsontype.Type) string {
+	switch t {
+	case bsontype.ObjectID:
+		return "ID"
+	case bsontype.String, bsontype.Decimal128, bsontype.Symbol:
+		return "String"
+	case bsontype.Int32:
+		return "Int"
+	// 64-bit integers do not fit the Int of GraphQL.
+	case bsontype.Int64, bsontype.Double:
+		return "Float"
+	case bsontype.Boolean:
+		return "Boolean"
+	case bsontype.DateTime:
+		return "DateTime"
+	}
+	return "JSON"
+}
+
+// valueType returns the declared type name standing for a value, [Type] for arrays whose elements
+// share a type.
+func valueType(value bson.RawValue) string {
+	if value.Type != bsontype.Array {
+		return bsonType(value.Type)
+	}
+	values, err := value.Array().Values()
+	if err != nil || len(values) == 0 {
+		return "JSON"
+	}
+	elem := ""
+	for _, v := range values {
+		t := bsonType(v.Type)
+		if t == "JSON" || (len(elem) > 0 && t != elem) {
+			return "JSON"
+		}
+		elem = t
+	}
+	return "[" + elem + "]"
+}
+
+// infer returns the type of every top level field of a sample of the documents of collection.
+// Fields holding values of different types are JSON, fields whose names GraphQL does not allow
+// are left out.
+func infer(ctx context.Context, collection *mongo.Collection, size int) (map[string]string, error) {
+	cursor, err := collection.Aggregate(ctx, mongo.Pipeline{{{Key: "$sample", Value: bson.D{{Key: "size", Value: size}}}}})
+	if err != nil {
+		return nil, fmt.Errorf("unable to sample %s: %w", collection.Name(), err)
+	}
+	defer cursor.Close(ctx)
+	fields := map[string]string{}
+	for cursor.Next(ctx) {
+		elements, err := cursor.Current.Elements()
+		if err != nil {
+			return nil, err
+		}
+		for _, element := range elements {
+			name, value := element.Key(), element.Value()
+			if !validName.MatchString(name) || value.Type == bsontype.Null || value.Type == bsontype.Undefined {
+				continue
+			}
+			t := valueType(value)
+			if previous, ok := fields[name]; ok && previous != t {
+				t = "JSON"
+			}
+			fields[name] = t
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("unable to sample %s: %w", collection.Name(), err)
+	}
+	if _, ok := fields["_id"]; !ok {
+		fields["_id"] = "ID"
+	}
+	return fields, nil
+}
+
+// objectType returns the type of the documents of a collection with the given field types.
+func objectType(name string, fields map[string]string) (*graphql.Object, error) {
+	names := make([]string, 0, len(fields))
+	for field := range fields {
+		names = append(names, field)
+	}
+	sort.Strings(names)
+	objectFields := graphql.Fields{}
+	for _, field := range names {
+		if !validName.MatchString(field) {
+			return nil, fmt.Errorf("invalid field name %q", field)
+		}
+		t, err := parseType(fields[field])
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", field, err)
+		}
+		objectFields[field] = &graphql.Field{Type: t}
+	}
+	return graphql.NewObject(graphql.ObjectConfig{Name: name, Fields: objectFields}), nil
+}
+
+// plain returns a document as the values GraphQL serializes: ObjectIds as hex strings, dates as
+// time.Time and binary data as base64.
+func plain(value interface{}) interface{} {
+	switch v := value.(type) {
+	case bson.D:
+		doc := make(map[string]interface{}, len(v))
+		for _, e := range v {
+			doc[e.Key] = plain(e.Value)
+		}
+		return doc
+	case bson.A:
+		list := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			list = append(list, plain(item))
+		}
+		return list
+	case primitive.ObjectID:
+		return v.Hex()
+	case primitive.DateTime:
+		return v.Time().UTC()
+	case primitive.Timestamp:
+		return time.Unix(int64(v.T), 0).UTC()
+	case primitive.Decimal128:
+		return v.String()
+	case primitive.Binary:
+		return base64.StdEncoding.EncodeToString(v.Data)
+	case primitive.Regex:
+		return v.String()
+	case primitive.Symbol:
+		return string(v)
+	case primitive.Null, primitive.Undefined:
+		return nil
+	}
+	return value
+}
+
+// toBSON converts the value of a JSON argument holding an Extended JSON document.
+func toBSON(name string, value interface{}) (bson.D, error) {
+	doc := bson.D{}
+	if value == nil {
+		return doc, nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	if err := bson.UnmarshalExtJSON(data, false, &doc); err != nil {
+		return nil, fmt.Errorf("invalid %s, must be a document: %w", name, err)
+	}
+	return doc, nil
+}
+
+// sortSpec converts sort arguments such as ["-duration", "title"] to a sort document.
+func sortSpec(fields []interface{}) bson.D {
+	spec := bson.D{}
+	for _, field := range fields {
+		name, _ := field.(string)
+		switch {
+		case strings.HasPrefix(name, "-"):
+			spec = append(spec, bson.E{Key: name[1:], Value: -1})
+		case len(name) > 0:
+			spec = append(spec, bson.E{Key: strings.TrimPrefix(name, "+"), Value: 1})
+		}
+	}
+	return spec
+}
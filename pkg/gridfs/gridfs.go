@@ -0,0 +1,241 @@
+// Package gridfs streams files to and from the GridFS buckets of a database. Uploads go through the
+// driver, downloads read the chunks directly so that they can start at any offset and resume an
+// interrupted download.
+package gridfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mongogridfs "go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DefaultBucket is the name of the bucket of the drivers and tools.
+const DefaultBucket = "fs"
+
+// ErrNotFound is returned for a file that does not exist.
+var ErrNotFound = errors.New("file not found")
+
+// Bucket holds files in the collections <name>.files and <name>.chunks of a database.
+type Bucket struct {
+	name   string
+	db     *mongo.Database
+	files  *mongo.Collection
+	chunks *mongo.Collection
+}
+
+// NewBucket returns the bucket called name of db, DefaultBucket when name is empty.
+func NewBucket(db *mongo.Database, name string) *Bucket {
+	if len(name) == 0 {
+		name = DefaultBucket
+	}
+	return &Bucket{
+		name:   name,
+		db:     db,
+		files:  db.Collection(name + ".files"),
+		chunks: db.Collection(name + ".chunks"),
+	}
+}
+
+// File is the document of a file of a bucket.
+type File struct {
+	ID         bson.RawValue `bson:"_id"`
+	Name       string        `bson:"filename"`
+	Length     int64         `bson:"length"`
+	ChunkSize  int32         `bson:"chunkSize"`
+	UploadDate time.Time     `bson:"uploadDate"`
+	Metadata   bson.Raw      `bson:"metadata,omitempty"`
+}
+
+// PutOptions change how a file is uploaded.
+type PutOptions struct {
+	// ID is the _id of the file, a new ObjectID when it is nil.
+	ID interface{}
+	// ChunkSize is the size of the chunks of the file in bytes, 255 KiB when it is not set.
+	ChunkSize int32
+	// Metadata is stored with the file.
+	Metadata bson.D
+}
+
+// Put uploads the content of r as a new revision of the file called name and returns its _id. The
+// file only becomes visible once it is complete, an upload that fails deletes the chunks it wrote.
+func (b *Bucket) Put(ctx context.Context, name string, r io.Reader, opts PutOptions) (interface{}, int64, error) {
+	bucket, err := mongogridfs.NewBucket(b.db, options.GridFSBucket().SetName(b.name))
+	if err != nil {
+		return nil, 0, err
+	}
+	uploadOptions := options.GridFSUpload()
+	if opts.ChunkSize > 0 {
+		uploadOptions.SetChunkSizeBytes(opts.ChunkSize)
+	}
+	if len(opts.Metadata) > 0 {
+		uploadOptions.SetMetadata(opts.Metadata)
+	}
+	var stream *mongogridfs.UploadStream
+	id := opts.ID
+	if id == nil {
+		stream, err = bucket.OpenUploadStream(name, uploadOptions)
+	} else {
+		stream, err = bucket.OpenUploadStreamWithID(id, name, uploadOptions)
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("unable to start the upload of %s: %w", name, err)
+	}
+	if id == nil {
+		id = stream.FileID
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		stream.SetWriteDeadline(deadline)
+	}
+	written, err := io.Copy(stream, contextReader{ctx: ctx, r: r})
+	if err != nil {
+		stream.Abort()
+		return nil, written, fmt.Errorf("unable to upload %s: %w", name, err)
+	}
+	if err := stream.Close(); err != nil {
+		return nil, written, fmt.Errorf("unable to complete the upload of %s: %w", name, err)
+	}
+	return id, written, nil
+}
+
+// contextReader stops reading once ctx is done, the driver does not take contexts for uploads.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// Files returns the files matching filter, by name and then upload date.
+func (b *Bucket) Files(ctx context.Context, filter bson.D) ([]File, error) {
+	if filter == nil {
+		filter = bson.D{}
+	}
+	cursor, err := b.files.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "filename", Value: 1}, {Key: "uploadDate", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the files of %s: %w", b.name, err)
+	}
+	var files []File
+	if err := cursor.All(ctx, &files); err != nil {
+		return nil, fmt.Errorf("unable to list the files of %s: %w", b.name, err)
+	}
+	return files, nil
+}
+
+// Find returns a revision of the file called name: 0 is the first upload, 1 the second, -1 the
+// latest, -2 the one before, and so on.
+func (b *Bucket) Find(ctx context.Context, name string, revision int32) (File, error) {
+	order, skip := 1, int64(revision)
+	if revision < 0 {
+		order, skip = -1, int64(-revision-1)
+	}
+	return b.findOne(ctx, bson.D{{Key: "filename", Value: name}}, options.FindOne().
+		SetSort(bson.D{{Key: "uploadDate", Value: order}, {Key: "_id", Value: order}}).
+		SetSkip(skip))
+}
+
+// FindID returns the file whose _id is id.
+func (b *Bucket) FindID(ctx context.Context, id interface{}) (File, error) {
+	return b.findOne(ctx, bson.D{{Key: "_id", Value: id}})
+}
+
+func (b *Bucket) findOne(ctx context.Context, filter bson.D, opts ...*options.FindOneOptions) (File, error) {
+	var file File
+	err := b.files.FindOne(ctx, filter, opts...).Decode(&file)
+	if err == mongo.ErrNoDocuments {
+		return file, ErrNotFound
+	}
+	if err != nil {
+		return file, fmt.Errorf("unable to find the file: %w", err)
+	}
+	return file, nil
+}
+
+// Download writes the content of file to w from offset on and returns the number of bytes written.
+// Only the chunks from the one holding offset are read, a download that failed resumes from the
+// number of bytes it wrote.
+func (b *Bucket) Download(ctx context.Context, file File, w io.Writer, offset int64) (int64, error) {
+	if offset < 0 || offset > file.Length {
+		return 0, fmt.Errorf("offset %d is outside of %s, of %d bytes", offset, file.Name, file.Length)
+	}
+	if offset == file.Length {
+		return 0, nil
+	}
+	if file.ChunkSize <= 0 {
+		return 0, fmt.Errorf("%s has an invalid chunk size of %d", file.Name, file.ChunkSize)
+	}
+	chunkSize := int64(file.ChunkSize)
+	first := offset / chunkSize
+	last := (file.Length - 1) / chunkSize
+	cursor, err := b.chunks.Find(ctx, bson.D{
+		{Key: "files_id", Value: file.ID},
+		{Key: "n", Value: bson.D{{Key: "$gte", Value: first}}},
+	}, options.Find().SetSort(bson.D{{Key: "n", Value: 1}}))
+	if err != nil {
+		return 0, fmt.Errorf("unable to read the chunks of %s: %w", file.Name, err)
+	}
+	defer cursor.Close(context.Background())
+
+	var written int64
+	expected := first
+	for cursor.Next(ctx) {
+		n, ok := cursor.Current.Lookup("n").AsInt64OK()
+		if !ok || n != expected {
+			return written, fmt.Errorf("chunk %d of %s is missing", expected, file.Name)
+		}
+		_, data, ok := cursor.Current.Lookup("data").BinaryOK()
+		if !ok {
+			return written, fmt.Errorf("chunk %d of %s has no data", n, file.Name)
+		}
+		size := chunkSize
+		if n == last {
+			size = file.Length - last*chunkSize
+		}
+		if int64(len(data)) != size {
+			return written, fmt.Errorf("chunk %d of %s has %d bytes instead of %d", n, file.Name, len(data), size)
+		}
+		if n == first {
+			data = data[offset-first*chunkSize:]
+		}
+		count, err := w.Write(data)
+		written += int64(count)
+		if err != nil {
+			return written, err
+		}
+		if n == last {
+			return written, nil
+		}
+		expected++
+	}
+	if err := cursor.Err(); err != nil {
+		return written, fmt.Errorf("unable to read the chunks of %s: %w", file.Name, err)
+	}
+	return written, fmt.Errorf("chunk %d of %s is missing", expected, file.Name)
+}
+
+// Delete deletes the file whose _id is id and its chunks.
+func (b *Bucket) Delete(ctx context.Context, id interface{}) error {
+	// The file is deleted first so that it is never visible without its chunks.
+	result, err := b.files.DeleteOne(ctx, bson.D{{Key: "_id", Value: id}})
+	if err != nil {
+		return fmt.Errorf("unable to delete the file: %w", err)
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	if _, err := b.chunks.DeleteMany(ctx, bson.D{{Key: "files_id", Value: id}}); err != nil {
+		return fmt.Errorf("unable to delete the chunks of the file: %w", err)
+	}
+	return nil
+}
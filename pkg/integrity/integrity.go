@@ -0,0 +1,397 @@
+// Package integrity checks the references between the collections of a database, declared in a
+// reference map, and the uniqueness of their keys, and optionally cleans up the documents that
+// break them.
+package integrity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/bulk"
+	"github.com/bradmwilliams/mongodb-client/pkg/client"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"sigs.k8s.io/yaml"
+)
+
+// Kinds of findings.
+const (
+	// Orphan documents refer to documents that do not exist.
+	Orphan = "orphan"
+	// Dangling documents lack a required reference.
+	Dangling = "dangling"
+	// Duplicate documents share the values of a key.
+	Duplicate = "duplicate"
+)
+
+// Actions cleaning up the findings.
+const (
+	// None leaves the documents as they are, the default.
+	None = "none"
+	// Delete deletes the orphan or dangling documents.
+	Delete = "delete"
+	// Unset removes the missing references from the orphan documents: the field of a single
+	// reference, or the missing values of an array of them.
+	Unset = "unset"
+	// KeepFirst deletes the duplicates of a key but the one with the lowest _id.
+	KeepFirst = "keepFirst"
+)
+
+// Map declares the references and keys of the collections of a database, such as:
+//
+//	references:
+//	- name: episode-podcast
+//	  from: {collection: episodes, field: podcast}
+//	  to: {collection: podcasts}
+//	  required: true
+//	  onOrphan: delete
+//	keys:
+//	- name: podcast-title
+//	  collection: podcasts
+//	  fields: [title]
+type Map struct {
+	References []Reference `json:"references,omitempty"`
+	Keys       []Key       `json:"keys,omitempty"`
+}
+
+// Reference is a field of the documents of a collection holding the values of a field of the
+// documents of another one, the _id by default, or arrays of them.
+type Reference struct {
+	Name string `json:"name"`
+	From Field  `json:"from"`
+	To   Field  `json:"to"`
+	// Required makes the documents without the field, or with a null, dangling.
+	Required bool `json:"required,omitempty"`
+	// OnOrphan is the action of the orphan documents, None, Delete or Unset.
+	OnOrphan string `json:"onOrphan,omitempty"`
+	// OnDangling is the action of the dangling documents, None or Delete.
+	OnDangling string `json:"onDangling,omitempty"`
+}
+
+// Field is a dotted path of the documents of a collection.
+type Field struct {
+	Collection string `json:"collection"`
+	Field      string `json:"field,omitempty"`
+}
+
+// Key is a set of fields that identify the documents of a collection, which no index enforces.
+type Key struct {
+	Name       string   `json:"name"`
+	Collection string   `json:"collection"`
+	Fields     []string `json:"fields"`
+	// OnDuplicate is the action of the duplicates, None or KeepFirst.
+	OnDuplicate string `json:"onDuplicate,omitempty"`
+}
+
+// LoadMap reads the map of a YAML or JSON file.
+func LoadMap(path string) (*Map, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read reference map: %w", err)
+	}
+	m := &Map{}
+	if err := yaml.UnmarshalStrict(data, m); err != nil {
+		return nil, fmt.Errorf("unable to parse reference map %s: %w", path, err)
+	}
+	if err := m.validate(); err != nil {
+		return nil, fmt.Errorf("invalid reference map %s: %w", path, err)
+	}
+	return m, nil
+}
+
+func (m *Map) validate() error {
+	if len(m.References) == 0 && len(m.Keys) == 0 {
+		return fmt.Errorf("no references nor keys are declared")
+	}
+	names := map[string]bool{}
+	name := func(name string) error {
+		if len(name) == 0 {
+			return fmt.Errorf("a reference or key has no name")
+		}
+		if names[name] {
+			return fmt.Errorf("%s is declared twice", name)
+		}
+		names[name] = true
+		return nil
+	}
+	for i := range m.References {
+		r := &m.References[i]
+		if err := name(r.Name); err != nil {
+			return err
+		}
+		if len(r.From.Collection) == 0 || len(r.From.Field) == 0 || len(r.To.Collection) == 0 {
+			return fmt.Errorf("reference %s needs a from collection and field and a to collection", r.Name)
+		}
+		if len(r.To.Field) == 0 {
+			r.To.Field = "_id"
+		}
+		switch r.OnOrphan {
+		case "", None, Delete, Unset:
+		default:
+			return fmt.Errorf("reference %s: onOrphan must be one of %s, %s or %s", r.Name, None, Delete, Unset)
+		}
+		switch r.OnDangling {
+		case "", None, Delete:
+		default:
+			return fmt.Errorf("reference %s: onDangling must be one of %s or %s", r.Name, None, Delete)
+		}
+	}
+	for _, k := range m.Keys {
+		if err := name(k.Name); err != nil {
+			return err
+		}
+		if len(k.Collection) == 0 || len(k.Fields) == 0 {
+			return fmt.Errorf("key %s needs a collection and fields", k.Name)
+		}
+		switch k.OnDuplicate {
+		case "", None, KeepFirst:
+		default:
+			return fmt.Errorf("key %s: onDuplicate must be one of %s or %s", k.Name, None, KeepFirst)
+		}
+	}
+	return nil
+}
+
+// Finding is a document breaking a reference or a key.
+type Finding struct {
+	Kind string
+	// Check is the name of the reference or key.
+	Check      string
+	Collection string
+	// ID is the _id of an orphan or dangling document.
+	ID bson.RawValue
+	// Values are the missing references of an orphan, or the values of the key of duplicates.
+	Values []bson.RawValue
+	// IDs are the _ids of the duplicates, lowest first.
+	IDs []bson.RawValue
+}
+
+// Result counts the findings of a reference or a key.
+type Result struct {
+	Check    string
+	Orphans  int64
+	Dangling int64
+	// Duplicates is the number of values of a key shared by several documents.
+	Duplicates int64
+	// Fixed is the number of documents changed or deleted by the actions.
+	Fixed int64
+	// FixFailures are the writes of the actions that failed.
+	FixFailures []bulk.Failure
+}
+
+// Findings returns the number of findings.
+func (r Result) Findings() int64 {
+	return r.Orphans + r.Dangling + r.Duplicates
+}
+
+// Options change how the checks run.
+type Options struct {
+	// Fix applies the actions of the map.
+	Fix bool
+	// BatchSize is the number of writes of the actions sent per request, 1000 when it is not set.
+	BatchSize int
+}
+
+// Check checks the references and keys of m in db and calls report with every finding, stopping at
+// the first error it returns. The references are looked up by the server with $lookup, an index on
+// their target field keeps it fast.
+func Check(ctx context.Context, db *mongo.Database, m *Map, opts Options, report func(Finding) error) ([]Result, error) {
+	if opts.BatchSize < 1 {
+		opts.BatchSize = 1000
+	}
+	var results []Result
+	for _, r := range m.References {
+		result, err := checkReference(ctx, db, r, opts, report)
+		results = append(results, result)
+		if err != nil {
+			return results, fmt.Errorf("reference %s: %w", r.Name, err)
+		}
+	}
+	for _, k := range m.Keys {
+		result, err := checkKey(ctx, db, k, opts, report)
+		results = append(results, result)
+		if err != nil {
+			return results, fmt.Errorf("key %s: %w", k.Name, err)
+		}
+	}
+	return results, nil
+}
+
+// referenceField is the computed field holding the values of a reference in the pipelines.
+const referenceField = "__ref"
+
+func checkReference(ctx context.Context, db *mongo.Database, r Reference, opts Options, report func(Finding) error) (Result, error) {
+	result := Result{Check: r.Name}
+	collection := db.Collection(r.From.Collection)
+	var writer *bulk.Writer
+	if opts.Fix && (orValue(r.OnOrphan) != None || orValue(r.OnDangling) != None) {
+		writer = bulk.NewWriter(collection, bulk.Options{BatchSize: opts.BatchSize})
+	}
+
+	cursor, err := collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.D{{Key: r.From.Field, Value: bson.D{{Key: "$exists", Value: true}, {Key: "$ne", Value: nil}}}}}},
+		{{Key: "$project", Value: bson.D{
+			{Key: "array", Value: bson.D{{Key: "$isArray", Value: "$" + r.From.Field}}},
+			{Key: referenceField, Value: "$" + r.From.Field},
+		}}},
+		{{Key: "$lookup", Value: bson.D{
+			{Key: "from", Value: r.To.Collection},
+			{Key: "localField", Value: referenceField},
+			{Key: "foreignField", Value: r.To.Field},
+			{Key: "as", Value: "targets"},
+		}}},
+		{{Key: "$project", Value: bson.D{
+			{Key: "array", Value: 1},
+			{Key: "missing", Value: bson.D{{Key: "$setDifference", Value: bson.A{
+				bson.D{{Key: "$cond", Value: bson.A{"$array", "$" + referenceField, bson.A{"$" + referenceField}}}},
+				"$targets." + r.To.Field,
+			}}}},
+		}}},
+		{{Key: "$match", Value: bson.D{{Key: "missing.0", Value: bson.D{{Key: "$exists", Value: true}}}}}},
+	}, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return result, fmt.Errorf("unable to look up the references of %s: %w", r.From.Collection, err)
+	}
+	err = client.Each(ctx, cursor, func(doc bson.Raw) error {
+		result.Orphans++
+		id := copyValue(doc.Lookup("_id"))
+		values, _ := doc.Lookup("missing").Array().Values()
+		finding := Finding{Kind: Orphan, Check: r.Name, Collection: r.From.Collection, ID: id}
+		for _, value := range values {
+			finding.Values = append(finding.Values, copyValue(value))
+		}
+		if err := report(finding); err != nil {
+			return err
+		}
+		if writer == nil {
+			return nil
+		}
+		filter := bson.D{{Key: "_id", Value: id}}
+		switch orValue(r.OnOrphan) {
+		case Delete:
+			return add(ctx, writer, mongo.NewDeleteOneModel().SetFilter(filter))
+		case Unset:
+			update := bson.D{{Key: "$unset", Value: bson.D{{Key: r.From.Field, Value: ""}}}}
+			if array, _ := doc.Lookup("array").BooleanOK(); array {
+				update = bson.D{{Key: "$pull", Value: bson.D{{Key: r.From.Field, Value: bson.D{{Key: "$in", Value: finding.Values}}}}}}
+			}
+			return add(ctx, writer, mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(update))
+		}
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+
+	if r.Required {
+		cursor, err := collection.Find(ctx, bson.D{{Key: r.From.Field, Value: nil}}, options.Find().SetProjection(bson.D{{Key: "_id", Value: 1}}))
+		if err != nil {
+			return result, fmt.Errorf("unable to find the documents of %s without %s: %w", r.From.Collection, r.From.Field, err)
+		}
+		err = client.Each(ctx, cursor, func(doc bson.Raw) error {
+			result.Dangling++
+			id := copyValue(doc.Lookup("_id"))
+			if err := report(Finding{Kind: Dangling, Check: r.Name, Collection: r.From.Collection, ID: id}); err != nil {
+				return err
+			}
+			if writer != nil && r.OnDangling == Delete {
+				return add(ctx, writer, mongo.NewDeleteOneModel().SetFilter(bson.D{{Key: "_id", Value: id}}))
+			}
+			return nil
+		})
+		if err != nil {
+			return result, err
+		}
+	}
+	return result, flush(ctx, writer, &result)
+}
+
+func checkKey(ctx context.Context, db *mongo.Database, k Key, opts Options, report func(Finding) error) (Result, error) {
+	result := Result{Check: k.Name}
+	collection := db.Collection(k.Collection)
+	var writer *bulk.Writer
+	if opts.Fix && k.OnDuplicate == KeepFirst {
+		writer = bulk.NewWriter(collection, bulk.Options{BatchSize: opts.BatchSize})
+	}
+
+	group := bson.D{}
+	for i, field := range k.Fields {
+		group = append(group, bson.E{Key: fmt.Sprintf("k%d", i), Value: "$" + field})
+	}
+	cursor, err := collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: group},
+			{Key: "ids", Value: bson.D{{Key: "$push", Value: "$_id"}}},
+			{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}},
+		}}},
+		{{Key: "$match", Value: bson.D{{Key: "count", Value: bson.D{{Key: "$gt", Value: 1}}}}}},
+	}, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return result, fmt.Errorf("unable to group the documents of %s: %w", k.Collection, err)
+	}
+	err = client.Each(ctx, cursor, func(doc bson.Raw) error {
+		result.Duplicates++
+		finding := Finding{Kind: Duplicate, Check: k.Name, Collection: k.Collection}
+		for i := range k.Fields {
+			finding.Values = append(finding.Values, copyValue(doc.Lookup("_id", fmt.Sprintf("k%d", i))))
+		}
+		ids, _ := doc.Lookup("ids").Array().Values()
+		for _, id := range ids {
+			finding.IDs = append(finding.IDs, copyValue(id))
+		}
+		if err := report(finding); err != nil {
+			return err
+		}
+		if writer == nil {
+			return nil
+		}
+		for _, id := range finding.IDs[1:] {
+			if err := add(ctx, writer, mongo.NewDeleteOneModel().SetFilter(bson.D{{Key: "_id", Value: id}})); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+	return result, flush(ctx, writer, &result)
+}
+
+func orValue(action string) string {
+	if len(action) == 0 {
+		return None
+	}
+	return action
+}
+
+// copyValue returns value with its own copy of the bytes of the cursor.
+func copyValue(value bson.RawValue) bson.RawValue {
+	value.Value = append([]byte(nil), value.Value...)
+	return value
+}
+
+// add queues a write of an action, the writes that fail are counted once flushed.
+func add(ctx context.Context, writer *bulk.Writer, model mongo.WriteModel) error {
+	if err := writer.Add(ctx, model); err != nil && !errors.As(err, new(*bulk.Error)) {
+		return fmt.Errorf("unable to clean up: %w", err)
+	}
+	return nil
+}
+
+func flush(ctx context.Context, writer *bulk.Writer, result *Result) error {
+	if writer == nil {
+		return nil
+	}
+	if err := writer.Flush(ctx); err != nil && !errors.As(err, new(*bulk.Error)) {
+		return fmt.Errorf("unable to clean up: %w", err)
+	}
+	written := writer.Result()
+	result.Fixed = written.Modified + written.Deleted
+	result.FixFailures = written.Failures
+	return nil
+}
@@ -0,0 +1,133 @@
+package jobs
+
+import (
+	"strings"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		expression string
+		err        string
+		// minute, hour, dom, month and dow are the expected fields of the cron expressions.
+		minute, hour, dom, month, dow []int
+	}{
+		{expression: "*/15 * * * *", minute: []int{0, 15, 30, 45}},
+		{expression: "0 9-17/4 * * *", minute: []int{0}, hour: []int{9, 13, 17}},
+		{expression: "30 2 1,15 * *", minute: []int{30}, hour: []int{2}, dom: []int{1, 15}},
+		{expression: "0 0 1 jan,JUL *", minute: []int{0}, hour: []int{0}, dom: []int{1}, month: []int{1, 7}},
+		{expression: "0 0 * * mon-fri", minute: []int{0}, hour: []int{0}, dow: []int{1, 2, 3, 4, 5}},
+		{expression: "0 0 * * 7", minute: []int{0}, hour: []int{0}, dow: []int{0}},
+		{expression: "0 0 * * 5-7", minute: []int{0}, hour: []int{0}, dow: []int{0, 5, 6}},
+		{expression: "0 0 * * 0-7", minute: []int{0}, hour: []int{0}, dow: []int{0, 1, 2, 3, 4, 5, 6}},
+		{expression: "5 4 * * sun", minute: []int{5}, hour: []int{4}, dow: []int{0}},
+		{expression: "@daily", minute: []int{0}, hour: []int{0}},
+		{expression: "@every 90s"},
+		{expression: "* * * *", err: "expected 5 fields"},
+		{expression: "60 * * * *", err: "out of range"},
+		{expression: "* 24 * * *", err: "out of range"},
+		{expression: "* * 0 * *", err: "out of range"},
+		{expression: "* * * 13 *", err: "out of range"},
+		{expression: "* * * * 8", err: "out of range"},
+		{expression: "* * * * 6-2", err: "invalid range"},
+		{expression: "*/0 * * * *", err: "invalid step"},
+		{expression: "* * * foo *", err: "invalid value"},
+		{expression: "@every -1m", err: "must be positive"},
+		{expression: "@every often", err: "invalid schedule"},
+	}
+	for _, test := range tests {
+		t.Run(test.expression, func(t *testing.T) {
+			schedule, err := ParseSchedule(test.expression)
+			if len(test.err) > 0 {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected an error containing %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, ok := schedule.(*cron)
+			if !ok {
+				return
+			}
+			for _, field := range []struct {
+				field    cronField
+				bits     uint64
+				expected []int
+			}{
+				{minuteField, c.minute, test.minute},
+				{hourField, c.hour, test.hour},
+				{domField, c.dom, test.dom},
+				{monthField, c.month, test.month},
+				{dowField, c.dow, test.dow},
+			} {
+				expected := field.expected
+				if expected == nil {
+					// Unrestricted, Sunday is only 0.
+					max := field.field.max
+					if field.field.name == dowField.name {
+						max = 6
+					}
+					for i := field.field.min; i <= max; i++ {
+						expected = append(expected, i)
+					}
+				}
+				var want uint64
+				for _, value := range expected {
+					want |= 1 << uint(value)
+				}
+				if field.bits != want {
+					t.Errorf("%s field: expected %b, got %b", field.field.name, want, field.bits)
+				}
+			}
+		})
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	load := func(name string) *time.Location {
+		location, err := time.LoadLocation(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return location
+	}
+	kolkata, newYork, santiago := load("Asia/Kolkata"), load("America/New_York"), load("America/Santiago")
+	tests := []struct {
+		name       string
+		expression string
+		after      time.Time
+		next       time.Time
+	}{
+		{"next minute", "* * * * *", time.Date(2024, 3, 1, 10, 0, 30, 0, time.UTC), time.Date(2024, 3, 1, 10, 1, 0, 0, time.UTC)},
+		{"strictly after", "0 10 * * *", time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC), time.Date(2024, 3, 2, 10, 0, 0, 0, time.UTC)},
+		{"step", "*/15 * * * *", time.Date(2024, 3, 1, 10, 7, 0, 0, time.UTC), time.Date(2024, 3, 1, 10, 15, 0, 0, time.UTC)},
+		{"next hour", "5 * * * *", time.Date(2024, 3, 1, 10, 6, 0, 0, time.UTC), time.Date(2024, 3, 1, 11, 5, 0, 0, time.UTC)},
+		{"next month", "0 0 1 * *", time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC), time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"next year", "0 0 1 1 *", time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC), time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"leap day", "0 0 29 2 *", time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"never", "0 0 30 2 *", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Time{}},
+		{"day of week", "0 9 * * mon", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 4, 9, 0, 0, 0, time.UTC)},
+		{"sunday as 7", "0 0 * * 5-7", time.Date(2024, 3, 2, 12, 0, 0, 0, time.UTC), time.Date(2024, 3, 3, 0, 0, 0, 0, time.UTC)},
+		{"day of month or week", "0 0 15 * fri", time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 8, 0, 0, 0, 0, time.UTC)},
+		{"half hour offset", "0 11 * * *", time.Date(2024, 3, 1, 12, 0, 0, 0, kolkata), time.Date(2024, 3, 2, 11, 0, 0, 0, kolkata)},
+		{"half hour offset minutes", "15 * * * *", time.Date(2024, 3, 1, 10, 20, 0, 0, kolkata), time.Date(2024, 3, 1, 11, 15, 0, 0, kolkata)},
+		{"skipped hour", "30 2 * * *", time.Date(2024, 3, 10, 0, 0, 0, 0, newYork), time.Date(2024, 3, 11, 2, 30, 0, 0, newYork)},
+		{"skipped midnight", "30 1 * * *", time.Date(2024, 9, 7, 23, 40, 0, 0, santiago), time.Date(2024, 9, 8, 1, 30, 0, 0, santiago)},
+		{"after the clocks go back", "0 3 * * *", time.Date(2024, 11, 3, 0, 30, 0, 0, newYork), time.Date(2024, 11, 3, 3, 0, 0, 0, newYork)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			schedule, err := ParseSchedule(test.expression)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if next := schedule.Next(test.after); !next.Equal(test.next) {
+				t.Errorf("expected %s, got %s", test.next, next)
+			}
+		})
+	}
+}
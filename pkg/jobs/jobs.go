@@ -0,0 +1,126 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Handler performs the work of a job against the database.
+type Handler func(ctx context.Context, client *mongo.Client) error
+
+// Schedule determines when a job runs next.
+type Schedule interface {
+	// Next returns the first activation time strictly after t.
+	Next(t time.Time) time.Time
+}
+
+// Every returns a Schedule that activates at a fixed interval.
+func Every(interval time.Duration) Schedule {
+	return every(interval)
+}
+
+type every time.Duration
+
+func (e every) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(e))
+}
+
+func (e every) String() string {
+	return fmt.Sprintf("every %s", time.Duration(e))
+}
+
+// Job is a unit of background work executed by the Runner.
+type Job interface {
+	Name() string
+	Schedule() Schedule
+	// Timeout bounds a single run of the job, zero means the run is not bounded.
+	Timeout() time.Duration
+	// RunOnStart reports whether the job runs once as soon as the runner starts.
+	RunOnStart() bool
+	Run(ctx context.Context, client *mongo.Client) error
+}
+
+// Spec describes a job built from a Handler.
+type Spec struct {
+	Name       string
+	Schedule   Schedule
+	Timeout    time.Duration
+	RunOnStart bool
+	Handler    Handler
+}
+
+type job struct {
+	spec Spec
+}
+
+// New returns a Job that invokes the handler of spec on its schedule.
+func New(spec Spec) Job {
+	return &job{spec: spec}
+}
+
+func (j *job) Name() string {
+	return j.spec.Name
+}
+
+func (j *job) Schedule() Schedule {
+	return j.spec.Schedule
+}
+
+func (j *job) Timeout() time.Duration {
+	return j.spec.Timeout
+}
+
+func (j *job) RunOnStart() bool {
+	return j.spec.RunOnStart
+}
+
+func (j *job) Run(ctx context.Context, client *mongo.Client) error {
+	return j.spec.Handler(ctx, client)
+}
+
+// Registry holds the jobs known to the process, keyed by name.
+type Registry struct {
+	lock sync.Mutex
+	jobs map[string]Job
+}
+
+func NewRegistry() *Registry {
+	return &Registry{jobs: make(map[string]Job)}
+}
+
+// Register adds job to the registry, rejecting duplicate names.
+func (r *Registry) Register(job Job) error {
+	if len(job.Name()) == 0 {
+		return fmt.Errorf("job name must not be empty")
+	}
+	if job.Schedule() == nil {
+		return fmt.Errorf("job %q has no schedule", job.Name())
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, ok := r.jobs[job.Name()]; ok {
+		return fmt.Errorf("job %q is already registered", job.Name())
+	}
+	r.jobs[job.Name()] = job
+	return nil
+}
+
+// Jobs returns the registered jobs sorted by name.
+func (r *Registry) Jobs() []Job {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	jobs := make([]Job, 0, len(r.jobs))
+	for _, job := range r.jobs {
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].Name() < jobs[j].Name()
+	})
+	return jobs
+}
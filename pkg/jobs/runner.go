@@ -0,0 +1,179 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.mongodb.org/mongo-driver/mongo"
+	"k8s.io/klog"
+)
+
+var (
+	jobRunsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mongodb_client_job_runs_total",
+		Help: "Number of job runs, partitioned by job and result.",
+	}, []string{"job", "result"})
+	jobDurationSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "mongodb_client_job_duration_seconds",
+		Help:    "Duration of job runs in seconds.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 4, 10),
+	}, []string{"job"})
+	jobLastSuccessTimestamp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "mongodb_client_job_last_success_timestamp_seconds",
+		Help: "Unix time of the last successful run of each job.",
+	}, []string{"job"})
+)
+
+func init() {
+	prometheus.MustRegister(jobRunsTotal, jobDurationSeconds, jobLastSuccessTimestamp)
+}
+
+// ErrSkipped may be returned, possibly wrapped, by an Executor that decided not to run a job.
+var ErrSkipped = errors.New("job run skipped")
+
+// Executor wraps every job run, allowing callers to add behaviour such as retries.
+type Executor func(ctx context.Context, fn func(ctx context.Context) error) error
+
+// Runner executes every job of a registry concurrently, each on its own schedule.
+type Runner struct {
+	registry *Registry
+	client   *mongo.Client
+	execute  Executor
+
+	lock sync.Mutex
+	// ctx and loops are the context of the runs and the stop channels of the loops of the jobs by
+	// name while the runner runs.
+	ctx   context.Context
+	loops map[string]chan struct{}
+	wg    sync.WaitGroup
+}
+
+// NewRunner returns a Runner for the jobs in registry. If execute is nil, jobs are invoked directly.
+func NewRunner(registry *Registry, client *mongo.Client, execute Executor) *Runner {
+	if execute == nil {
+		execute = func(ctx context.Context, fn func(ctx context.Context) error) error {
+			return fn(ctx)
+		}
+	}
+	return &Runner{
+		registry: registry,
+		client:   client,
+		execute:  execute,
+	}
+}
+
+// Run starts every registered job and blocks until stopCh is closed and all runs in flight have returned.
+func (r *Runner) Run(stopCh <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	r.lock.Lock()
+	r.ctx, r.loops = ctx, map[string]chan struct{}{}
+	for _, job := range r.registry.Jobs() {
+		r.start(job, job.RunOnStart())
+	}
+	r.lock.Unlock()
+
+	<-stopCh
+	cancel()
+	r.lock.Lock()
+	r.ctx, r.loops = nil, nil
+	r.lock.Unlock()
+	r.wg.Wait()
+}
+
+// Update replaces the jobs of the runner with those of registry. While the runner runs, the jobs
+// are scheduled again from now, without running on start, and the runs in flight are left to
+// complete.
+func (r *Runner) Update(registry *Registry) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.registry = registry
+	if r.ctx == nil {
+		return
+	}
+	for name, stop := range r.loops {
+		close(stop)
+		delete(r.loops, name)
+	}
+	for _, job := range registry.Jobs() {
+		r.start(job, false)
+	}
+}
+
+// start starts the loop of job, r.lock must be held.
+func (r *Runner) start(job Job, runOnStart bool) {
+	stop := make(chan struct{})
+	r.loops[job.Name()] = stop
+	r.wg.Add(1)
+	go func(ctx context.Context) {
+		defer r.wg.Done()
+		r.loop(ctx, job, stop, runOnStart)
+	}(r.ctx)
+}
+
+func (r *Runner) loop(ctx context.Context, job Job, stop <-chan struct{}, runOnStart bool) {
+	klog.Infof("Scheduling job %s (%v)", job.Name(), job.Schedule())
+	if runOnStart {
+		r.RunOnce(ctx, job)
+	}
+	for {
+		next := job.Schedule().Next(time.Now())
+		if next.IsZero() {
+			klog.Warningf("Job %s will never run again, its schedule has no future activation", job.Name())
+			return
+		}
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		r.RunOnce(ctx, job)
+	}
+}
+
+// RunOnce executes a single run of job, recording its outcome.
+func (r *Runner) RunOnce(ctx context.Context, job Job) error {
+	if job.Timeout() > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, job.Timeout())
+		defer cancel()
+	}
+
+	start := time.Now()
+	err := r.execute(ctx, func(ctx context.Context) (err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				err = fmt.Errorf("job panicked: %v", p)
+			}
+		}()
+		return job.Run(ctx, r.client)
+	})
+	duration := time.Since(start)
+	jobDurationSeconds.WithLabelValues(job.Name()).Observe(duration.Seconds())
+
+	if errors.Is(err, ErrSkipped) {
+		jobRunsTotal.WithLabelValues(job.Name(), "skipped").Inc()
+		klog.Warningf("Job %s skipped: %v", job.Name(), err)
+		return err
+	}
+	if err != nil {
+		jobRunsTotal.WithLabelValues(job.Name(), "failure").Inc()
+		klog.Errorf("Job %s failed after %d ms: %v", job.Name(), duration.Milliseconds(), err)
+		return err
+	}
+
+	jobRunsTotal.WithLabelValues(job.Name(), "success").Inc()
+	jobLastSuccessTimestamp.WithLabelValues(job.Name()).SetToCurrentTime()
+	klog.Infof("Job %s finished in: %d ms", job.Name(), duration.Milliseconds())
+	return nil
+}
@@ -0,0 +1,178 @@
+package jq
+
+import (
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// call is a function of one input and the expressions of its arguments.
+type call struct {
+	args []node
+	fn   func(input interface{}, args []node) ([]interface{}, error)
+}
+
+func (c call) eval(input interface{}) ([]interface{}, error) {
+	return c.fn(input, c.args)
+}
+
+// unary returns the implementation of a function without arguments returning a single output.
+func unary(fn func(value interface{}) (interface{}, error)) func(interface{}, []node) ([]interface{}, error) {
+	return func(input interface{}, args []node) ([]interface{}, error) {
+		output, err := fn(input)
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{output}, nil
+	}
+}
+
+// functions are the functions by name, with their number of arguments.
+var functions = map[string]struct {
+	arity int
+	fn    func(input interface{}, args []node) ([]interface{}, error)
+}{
+	"empty":    {0, func(input interface{}, args []node) ([]interface{}, error) { return nil, nil }},
+	"not":      {0, unary(func(value interface{}) (interface{}, error) { return !truthy(value), nil })},
+	"keys":     {0, unary(keys)},
+	"length":   {0, unary(length)},
+	"tostring": {0, unary(tostring)},
+	"type":     {0, unary(func(value interface{}) (interface{}, error) { return typeName(value), nil })},
+	"select": {1, func(input interface{}, args []node) ([]interface{}, error) {
+		conditions, err := args[0].eval(input)
+		if err != nil {
+			return nil, err
+		}
+		var outputs []interface{}
+		for _, condition := range conditions {
+			if truthy(condition) {
+				outputs = append(outputs, input)
+			}
+		}
+		return outputs, nil
+	}},
+	"map": {1, func(input interface{}, args []node) ([]interface{}, error) {
+		return array{pipe{iterate{identity{}}, args[0]}}.eval(input)
+	}},
+	"has": {1, func(input interface{}, args []node) ([]interface{}, error) {
+		return each(args[0], input, func(key interface{}) ([]interface{}, error) {
+			switch v := input.(type) {
+			case bson.D:
+				name, ok := key.(string)
+				if !ok {
+					return nil, fmt.Errorf("cannot check whether an object has a key of type %s", typeName(key))
+				}
+				for _, e := range v {
+					if e.Key == name {
+						return []interface{}{true}, nil
+					}
+				}
+				return []interface{}{false}, nil
+			case bson.A:
+				n, ok := toInt(key)
+				if !ok {
+					return nil, fmt.Errorf("cannot check whether an array has a key of type %s", typeName(key))
+				}
+				return []interface{}{n >= 0 && n < len(v)}, nil
+			}
+			return nil, fmt.Errorf("cannot check whether %s has a key", typeName(input))
+		})
+	}},
+	"del": {1, func(input interface{}, args []node) ([]interface{}, error) {
+		steps, err := path(args[0])
+		if err != nil {
+			return nil, err
+		}
+		output, err := remove(input, steps)
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{output}, nil
+	}},
+}
+
+func newCall(name string, args []node) (node, error) {
+	function, ok := functions[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %s", name)
+	}
+	if len(args) != function.arity {
+		return nil, fmt.Errorf("%s takes %d arguments, not %d", name, function.arity, len(args))
+	}
+	return call{args: args, fn: function.fn}, nil
+}
+
+// path returns the steps of a path expression made of fields and constant indexes, such as
+// .a.b[0], as field names and array indexes.
+func path(n node) ([]interface{}, error) {
+	switch v := n.(type) {
+	case identity:
+		return nil, nil
+	case field:
+		steps, err := path(v.target)
+		return append(steps, v.name), err
+	case indexNode:
+		steps, err := path(v.target)
+		if err != nil {
+			return nil, err
+		}
+		index, ok := v.index.(literal)
+		if !ok {
+			return nil, fmt.Errorf("del only supports constant indexes")
+		}
+		return append(steps, index.value), nil
+	}
+	return nil, fmt.Errorf("del only supports paths such as .a.b[0]")
+}
+
+// remove returns a copy of value without the element at the path steps.
+func remove(value interface{}, steps []interface{}) (interface{}, error) {
+	if len(steps) == 0 {
+		return nil, nil
+	}
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case bson.D:
+		name, ok := steps[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("cannot delete an index of an object")
+		}
+		copied := make(bson.D, 0, len(v))
+		for _, e := range v {
+			if e.Key == name {
+				if len(steps) == 1 {
+					continue
+				}
+				removed, err := remove(e.Value, steps[1:])
+				if err != nil {
+					return nil, err
+				}
+				e.Value = removed
+			}
+			copied = append(copied, e)
+		}
+		return copied, nil
+	case bson.A:
+		n, ok := toInt(steps[0])
+		if !ok {
+			return nil, fmt.Errorf("cannot delete a field of an array")
+		}
+		if n < 0 {
+			n += len(v)
+		}
+		if n < 0 || n >= len(v) {
+			return v, nil
+		}
+		copied := append(bson.A{}, v[:n]...)
+		if len(steps) > 1 {
+			removed, err := remove(v[n], steps[1:])
+			if err != nil {
+				return nil, err
+			}
+			copied = append(copied, removed)
+		}
+		return append(copied, v[n+1:]...), nil
+	}
+	return nil, fmt.Errorf("cannot delete from %s", typeName(value))
+}
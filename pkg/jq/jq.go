@@ -0,0 +1,591 @@
+// Package jq reshapes documents with a subset of the jq language, evaluated on BSON values so the
+// ObjectIds, dates, 64-bit integers and decimals of the documents keep their type:
+//
+//	.title                      the field title, null when missing
+//	.cast[0], .cast[], ."a b"   an element, every element, a field with any name
+//	{title, year: .released}    a new document
+//	[.cast[] | .name]           an array of the outputs of an expression
+//	a | b, a , b, a // b        pipe, several outputs, alternative to null and false
+//	==, !=, <, <=, >, >=, and, or
+//	select(f), map(f), del(path), has(key), keys, length, type, tostring, not, empty
+//
+// Documents are bson.D, arrays bson.A and the other values those of the driver.
+package jq
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Expression is a parsed jq expression.
+type Expression struct {
+	source string
+	root   node
+}
+
+// Parse parses a jq expression.
+func Parse(source string) (*Expression, error) {
+	tokens, err := tokenize(source)
+	if err != nil {
+		return nil, fmt.Errorf("invalid jq expression: %w", err)
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parsePipe()
+	if err == nil && p.peek().kind != tokenEOF {
+		err = p.unexpected()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid jq expression: %w", err)
+	}
+	return &Expression{source: source, root: root}, nil
+}
+
+func (e *Expression) String() string {
+	return e.source
+}
+
+// Apply returns the outputs of the expression for doc, none when it is filtered out.
+func (e *Expression) Apply(doc bson.Raw) ([]interface{}, error) {
+	var input bson.D
+	if err := bson.Unmarshal(doc, &input); err != nil {
+		return nil, err
+	}
+	return e.Eval(input)
+}
+
+// Eval returns the outputs of the expression for value.
+func (e *Expression) Eval(value interface{}) ([]interface{}, error) {
+	outputs, err := e.root.eval(value)
+	if err != nil {
+		return nil, fmt.Errorf("jq %s: %w", e.source, err)
+	}
+	return outputs, nil
+}
+
+// node is an expression, returning its outputs for an input.
+type node interface {
+	eval(input interface{}) ([]interface{}, error)
+}
+
+type identity struct{}
+
+func (identity) eval(input interface{}) ([]interface{}, error) {
+	return []interface{}{input}, nil
+}
+
+type literal struct {
+	value interface{}
+}
+
+func (l literal) eval(input interface{}) ([]interface{}, error) {
+	return []interface{}{l.value}, nil
+}
+
+type pipe struct {
+	left, right node
+}
+
+func (p pipe) eval(input interface{}) ([]interface{}, error) {
+	lefts, err := p.left.eval(input)
+	if err != nil {
+		return nil, err
+	}
+	var outputs []interface{}
+	for _, left := range lefts {
+		rights, err := p.right.eval(left)
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, rights...)
+	}
+	return outputs, nil
+}
+
+type comma struct {
+	left, right node
+}
+
+func (c comma) eval(input interface{}) ([]interface{}, error) {
+	lefts, err := c.left.eval(input)
+	if err != nil {
+		return nil, err
+	}
+	rights, err := c.right.eval(input)
+	if err != nil {
+		return nil, err
+	}
+	return append(lefts, rights...), nil
+}
+
+type field struct {
+	target node
+	name   string
+}
+
+func (f field) eval(input interface{}) ([]interface{}, error) {
+	return each(f.target, input, func(value interface{}) ([]interface{}, error) {
+		switch v := value.(type) {
+		case nil:
+			return []interface{}{nil}, nil
+		case bson.D:
+			for _, e := range v {
+				if e.Key == f.name {
+					return []interface{}{e.Value}, nil
+				}
+			}
+			return []interface{}{nil}, nil
+		}
+		return nil, fmt.Errorf("cannot index %s with %q", typeName(value), f.name)
+	})
+}
+
+type indexNode struct {
+	target, index node
+}
+
+func (i indexNode) eval(input interface{}) ([]interface{}, error) {
+	indexes, err := i.index.eval(input)
+	if err != nil {
+		return nil, err
+	}
+	return each(i.target, input, func(value interface{}) ([]interface{}, error) {
+		var outputs []interface{}
+		for _, index := range indexes {
+			if name, ok := index.(string); ok {
+				values, err := field{literal{value}, name}.eval(nil)
+				if err != nil {
+					return nil, err
+				}
+				outputs = append(outputs, values...)
+				continue
+			}
+			n, ok := toInt(index)
+			if !ok {
+				return nil, fmt.Errorf("cannot index %s with %s", typeName(value), typeName(index))
+			}
+			switch v := value.(type) {
+			case nil:
+				outputs = append(outputs, nil)
+			case bson.A:
+				if n < 0 {
+					n += len(v)
+				}
+				if n < 0 || n >= len(v) {
+					outputs = append(outputs, nil)
+				} else {
+					outputs = append(outputs, v[n])
+				}
+			default:
+				return nil, fmt.Errorf("cannot index %s with a number", typeName(value))
+			}
+		}
+		return outputs, nil
+	})
+}
+
+type iterate struct {
+	target node
+}
+
+func (i iterate) eval(input interface{}) ([]interface{}, error) {
+	return each(i.target, input, func(value interface{}) ([]interface{}, error) {
+		switch v := value.(type) {
+		case bson.A:
+			return append([]interface{}{}, v...), nil
+		case bson.D:
+			outputs := make([]interface{}, 0, len(v))
+			for _, e := range v {
+				outputs = append(outputs, e.Value)
+			}
+			return outputs, nil
+		}
+		return nil, fmt.Errorf("cannot iterate over %s", typeName(value))
+	})
+}
+
+// try drops the errors of its body, with its outputs.
+type try struct {
+	body node
+}
+
+func (t try) eval(input interface{}) ([]interface{}, error) {
+	outputs, err := t.body.eval(input)
+	if err != nil {
+		return nil, nil
+	}
+	return outputs, nil
+}
+
+type array struct {
+	body node
+}
+
+func (a array) eval(input interface{}) ([]interface{}, error) {
+	values := bson.A{}
+	if a.body != nil {
+		outputs, err := a.body.eval(input)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, outputs...)
+	}
+	return []interface{}{values}, nil
+}
+
+type objectEntry struct {
+	key, value node
+}
+
+type object []objectEntry
+
+// eval returns a document for every combination of the outputs of the entries.
+func (o object) eval(input interface{}) ([]interface{}, error) {
+	docs := []bson.D{{}}
+	for _, entry := range o {
+		keys, err := entry.key.eval(input)
+		if err != nil {
+			return nil, err
+		}
+		values, err := entry.value.eval(input)
+		if err != nil {
+			return nil, err
+		}
+		var next []bson.D
+		for _, doc := range docs {
+			for _, key := range keys {
+				name, ok := key.(string)
+				if !ok {
+					return nil, fmt.Errorf("object keys must be strings, not %s", typeName(key))
+				}
+				for _, value := range values {
+					next = append(next, set(doc, name, value))
+				}
+			}
+		}
+		docs = next
+	}
+	outputs := make([]interface{}, 0, len(docs))
+	for _, doc := range docs {
+		outputs = append(outputs, doc)
+	}
+	return outputs, nil
+}
+
+// set returns a copy of doc with the field name set to value.
+func set(doc bson.D, name string, value interface{}) bson.D {
+	copied := make(bson.D, 0, len(doc)+1)
+	found := false
+	for _, e := range doc {
+		if e.Key == name {
+			e.Value, found = value, true
+		}
+		copied = append(copied, e)
+	}
+	if !found {
+		copied = append(copied, bson.E{Key: name, Value: value})
+	}
+	return copied
+}
+
+type binary struct {
+	op          string
+	left, right node
+}
+
+func (b binary) eval(input interface{}) ([]interface{}, error) {
+	lefts, err := b.left.eval(input)
+	if err != nil {
+		return nil, err
+	}
+	var outputs []interface{}
+	switch b.op {
+	case "//":
+		for _, left := range lefts {
+			if truthy(left) {
+				outputs = append(outputs, left)
+			}
+		}
+		if len(outputs) > 0 {
+			return outputs, nil
+		}
+		return b.right.eval(input)
+	case "and", "or":
+		for _, left := range lefts {
+			if b.op == "and" && !truthy(left) || b.op == "or" && truthy(left) {
+				outputs = append(outputs, b.op == "or")
+				continue
+			}
+			rights, err := b.right.eval(input)
+			if err != nil {
+				return nil, err
+			}
+			for _, right := range rights {
+				outputs = append(outputs, truthy(right))
+			}
+		}
+		return outputs, nil
+	}
+	rights, err := b.right.eval(input)
+	if err != nil {
+		return nil, err
+	}
+	for _, left := range lefts {
+		for _, right := range rights {
+			var result bool
+			switch b.op {
+			case "==":
+				result = equal(left, right)
+			case "!=":
+				result = !equal(left, right)
+			default:
+				c, ok := compare(left, right)
+				result = ok && (b.op == "<" && c < 0 || b.op == "<=" && c <= 0 || b.op == ">" && c > 0 || b.op == ">=" && c >= 0)
+			}
+			outputs = append(outputs, result)
+		}
+	}
+	return outputs, nil
+}
+
+// each calls fn with every output of target for input, and returns all their outputs.
+func each(target node, input interface{}, fn func(value interface{}) ([]interface{}, error)) ([]interface{}, error) {
+	values, err := target.eval(input)
+	if err != nil {
+		return nil, err
+	}
+	var outputs []interface{}
+	for _, value := range values {
+		results, err := fn(value)
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, results...)
+	}
+	return outputs, nil
+}
+
+// truthy is false for false and null only.
+func truthy(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	}
+	return true
+}
+
+// typeName returns the jq type of value, or its BSON type for the types jq does not have.
+func typeName(value interface{}) string {
+	switch value.(type) {
+	case nil, primitive.Null, primitive.Undefined:
+		return "null"
+	case bool:
+		return "boolean"
+	case int32, int64, float64, primitive.Decimal128:
+		return "number"
+	case string:
+		return "string"
+	case bson.A:
+		return "array"
+	case bson.D:
+		return "object"
+	case primitive.ObjectID:
+		return "objectId"
+	case primitive.DateTime:
+		return "date"
+	case primitive.Timestamp:
+		return "timestamp"
+	case primitive.Binary:
+		return "binary"
+	case primitive.Regex:
+		return "regex"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// number returns value as a big float when it is a number.
+func number(value interface{}) (*big.Float, bool) {
+	switch v := value.(type) {
+	case int32:
+		return new(big.Float).SetInt64(int64(v)), true
+	case int64:
+		return new(big.Float).SetInt64(v), true
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, false
+		}
+		return new(big.Float).SetFloat64(v), true
+	case primitive.Decimal128:
+		f, _, err := big.ParseFloat(v.String(), 10, 113, big.ToNearestEven)
+		return f, err == nil
+	}
+	return nil, false
+}
+
+func toInt(value interface{}) (int, bool) {
+	n, ok := number(value)
+	if !ok || !n.IsInt() {
+		return 0, false
+	}
+	i, _ := n.Int64()
+	return int(i), true
+}
+
+// compare orders two numbers, strings, dates or ObjectIds, ok is false for other values.
+func compare(a, b interface{}) (int, bool) {
+	if x, ok := number(a); ok {
+		if y, ok := number(b); ok {
+			return x.Cmp(y), true
+		}
+		return 0, false
+	}
+	switch x := a.(type) {
+	case string:
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y), true
+		}
+	case primitive.DateTime:
+		if y, ok := b.(primitive.DateTime); ok {
+			return compareInt64(int64(x), int64(y)), true
+		}
+	case primitive.ObjectID:
+		if y, ok := b.(primitive.ObjectID); ok {
+			return strings.Compare(x.Hex(), y.Hex()), true
+		}
+	case bool:
+		if y, ok := b.(bool); ok && x != y {
+			if y {
+				return -1, true
+			}
+			return 1, true
+		} else if ok {
+			return 0, true
+		}
+	}
+	return 0, false
+}
+
+func compareInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// equal compares numbers by value whatever their type, and documents whatever their field order.
+func equal(a, b interface{}) bool {
+	if c, ok := compare(a, b); ok {
+		return c == 0
+	}
+	switch x := a.(type) {
+	case nil:
+		return b == nil
+	case bson.A:
+		y, ok := b.(bson.A)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !equal(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	case bson.D:
+		y, ok := b.(bson.D)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for _, e := range x {
+			found := false
+			for _, f := range y {
+				if e.Key == f.Key {
+					found = equal(e.Value, f.Value)
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
+
+// keys returns the sorted field names of a document, or the indexes of an array.
+func keys(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case bson.D:
+		names := make([]string, 0, len(v))
+		for _, e := range v {
+			names = append(names, e.Key)
+		}
+		sort.Strings(names)
+		result := make(bson.A, 0, len(names))
+		for _, name := range names {
+			result = append(result, name)
+		}
+		return result, nil
+	case bson.A:
+		result := make(bson.A, 0, len(v))
+		for i := range v {
+			result = append(result, int64(i))
+		}
+		return result, nil
+	}
+	return nil, fmt.Errorf("%s has no keys", typeName(value))
+}
+
+func length(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case nil:
+		return int64(0), nil
+	case string:
+		return int64(utf8.RuneCountInString(v)), nil
+	case bson.A:
+		return int64(len(v)), nil
+	case bson.D:
+		return int64(len(v)), nil
+	case int32:
+		if v < 0 {
+			v = -v
+		}
+		return v, nil
+	case int64:
+		if v < 0 {
+			v = -v
+		}
+		return v, nil
+	case float64:
+		return math.Abs(v), nil
+	}
+	return nil, fmt.Errorf("%s has no length", typeName(value))
+}
+
+// tostring returns strings as they are, ObjectIds in hex and other values as relaxed Extended JSON.
+func tostring(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case primitive.ObjectID:
+		return v.Hex(), nil
+	}
+	data, err := bson.MarshalExtJSON(bson.D{{Key: "v", Value: value}}, false, false)
+	if err != nil {
+		return nil, err
+	}
+	// The value is unwrapped from {"v":...}.
+	return string(data[5 : len(data)-1]), nil
+}
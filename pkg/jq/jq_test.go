@@ -0,0 +1,150 @@
+package jq
+
+import (
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// episode is the Extended JSON of the document the expressions are applied to.
+const episode = `{"_id":{"$oid":"5f1b2c3d4e5f6a7b8c9d0e1f"},"title":"GraphQL for API Development","season":2,"views":{"$numberLong":"12345678901"},"released":{"$date":"2024-03-01T00:00:00Z"},"rating":4.5,"draft":false,"guest":null,"cast":[{"name":"Nic","role":"host"},{"name":"Ada","role":"guest"}],"tags":["api","graphql"],"a b":1}`
+
+// apply returns the relaxed Extended JSON array of the outputs of expression for episode.
+func apply(t *testing.T, expression string) (string, error) {
+	var doc bson.D
+	if err := bson.UnmarshalExtJSON([]byte(episode), false, &doc); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expr, err := Parse(expression)
+	if err != nil {
+		return "", err
+	}
+	outputs, err := expr.Apply(raw)
+	if err != nil {
+		return "", err
+	}
+	data, err := bson.MarshalExtJSON(bson.D{{Key: "outputs", Value: append(bson.A{}, outputs...)}}, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(string(data), `{"outputs":`), "}"), nil
+}
+
+func TestExpressionApply(t *testing.T) {
+	tests := []struct {
+		expression string
+		outputs    string
+	}{
+		{`.title`, `["GraphQL for API Development"]`},
+		{`.missing`, `[null]`},
+		{`.missing.deeper`, `[null]`},
+		{`.cast[0]`, `[{"name":"Nic","role":"host"}]`},
+		{`.cast[-1].name`, `["Ada"]`},
+		{`.cast[5]`, `[null]`},
+		{`.cast[].name`, `["Nic","Ada"]`},
+		{`."a b"`, `[1]`},
+		{`.["title"]`, `["GraphQL for API Development"]`},
+		{`{title, year: .released}`, `[{"title":"GraphQL for API Development","year":{"$date":"2024-03-01T00:00:00Z"}}]`},
+		{`[.cast[] | .name]`, `[["Nic","Ada"]]`},
+		{`.tags[], .season`, `["api","graphql",2]`},
+		{`.guest // .title`, `["GraphQL for API Development"]`},
+		{`.draft // "none"`, `["none"]`},
+		{`.season == 2`, `[true]`},
+		{`.season != 2`, `[false]`},
+		{`.rating < 5`, `[true]`},
+		{`.season >= 3 and .rating > 4`, `[false]`},
+		{`.draft or .season > 1`, `[true]`},
+		{`.cast[] | select(.role == "host") | .name`, `["Nic"]`},
+		{`[.tags | map(length)]`, `[[[3,7]]]`},
+		{`.tags | map(length)`, `[[3,7]]`},
+		{`del(.cast) | has("cast")`, `[false]`},
+		{`del(.cast[0].role) | .cast`, `[[{"name":"Nic"},{"name":"Ada","role":"guest"}]]`},
+		{`del(.tags[0]) | .tags`, `[["graphql"]]`},
+		{`del(.missing) | keys | length`, `[11]`},
+		{`has("title")`, `[true]`},
+		{`.cast | has(1)`, `[true]`},
+		{`keys`, `[["_id","a b","cast","draft","guest","rating","released","season","tags","title","views"]]`},
+		{`.cast | length`, `[2]`},
+		{`.title | length`, `[27]`},
+		{`._id | type`, `["objectId"]`},
+		{`.views | type`, `["number"]`},
+		{`.released | type`, `["date"]`},
+		{`.views, ._id, .released`, `[12345678901,{"$oid":"5f1b2c3d4e5f6a7b8c9d0e1f"},{"$date":"2024-03-01T00:00:00Z"}]`},
+		{`.season | tostring`, `["2"]`},
+		{`._id | tostring`, `["5f1b2c3d4e5f6a7b8c9d0e1f"]`},
+		{`.draft | not`, `[true]`},
+		{`empty`, `[]`},
+		{`.tags[] | select(. != "api")`, `["graphql"]`},
+		{`.cast[0] | {name}`, `[{"name":"Nic"}]`},
+		{`{(.title): 1}`, `[{"GraphQL for API Development":1}]`},
+		{`1, "x", null, true`, `[1,"x",null,true]`},
+		{`.title?`, `["GraphQL for API Development"]`},
+		{`[]`, `[[]]`},
+	}
+	for _, test := range tests {
+		t.Run(test.expression, func(t *testing.T) {
+			outputs, err := apply(t, test.expression)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if outputs != test.outputs {
+				t.Errorf("expected %s, got %s", test.outputs, outputs)
+			}
+		})
+	}
+}
+
+func TestExpressionErrors(t *testing.T) {
+	tests := []struct {
+		expression string
+		err        string
+	}{
+		{`del(.cast[])`, `del only supports paths such as .a.b[0]`},
+		{`.views + 1`, `unexpected character '+' at 7`},
+		{`.title[0]`, `cannot index string with a number`},
+		{`.season.name`, `cannot index number with "name"`},
+		{`.cast[] | .name | .x`, `cannot index string with "x"`},
+		{`select(`, `unexpected end of expression`},
+		{`.tags[`, `unexpected end of expression`},
+		{`unknown`, `unknown function unknown`},
+		{`map(.a; .b)`, `map takes 1 arguments, not 2`},
+		{`"open`, `unterminated string at 0`},
+		{`.season | keys`, `number has no keys`},
+		{`.tags[] as $x`, `unexpected character '$' at 11`},
+		{`.tags[0:1]`, `unexpected ":" at 7`},
+	}
+	for _, test := range tests {
+		t.Run(test.expression, func(t *testing.T) {
+			if _, err := apply(t, test.expression); err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("expected an error containing %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestExpressionKeepsTypes(t *testing.T) {
+	expr, err := Parse("{views, rating: .stats.rating}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := bson.D{{Key: "views", Value: int64(12)}, {Key: "stats", Value: bson.D{{Key: "rating", Value: int32(4)}}}}
+	outputs, err := expr.Eval(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, ok := outputs[0].(bson.D)
+	if len(outputs) != 1 || !ok || len(output) != 2 {
+		t.Fatalf("unexpected outputs %v", outputs)
+	}
+	if _, ok := output[0].Value.(int64); !ok {
+		t.Errorf("expected views to remain an int64, got %T", output[0].Value)
+	}
+	if _, ok := output[1].Value.(int32); !ok {
+		t.Errorf("expected rating to remain an int32, got %T", output[1].Value)
+	}
+}
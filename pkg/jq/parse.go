@@ -0,0 +1,381 @@
+package jq
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenPunct
+	tokenIdent
+	tokenString
+	tokenNumber
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	// value is the decoded string or number.
+	value interface{}
+	pos   int
+}
+
+// punctuation is matched longest first.
+var punctuation = []string{"==", "!=", "<=", ">=", "//", ".", "[", "]", "{", "}", "(", ")", "|", ",", ":", ";", "?", "<", ">"}
+
+func tokenize(source string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(source); {
+		c := rune(source[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+			continue
+		case c == '"':
+			end := i + 1
+			for ; end < len(source) && source[end] != '"'; end++ {
+				if source[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(source) {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			var str string
+			if err := json.Unmarshal([]byte(source[i:end+1]), &str); err != nil {
+				return nil, fmt.Errorf("invalid string at %d: %w", i, err)
+			}
+			tokens = append(tokens, token{kind: tokenString, text: source[i : end+1], value: str, pos: i})
+			i = end + 1
+			continue
+		case c >= '0' && c <= '9' || c == '-' && i+1 < len(source) && source[i+1] >= '0' && source[i+1] <= '9':
+			end := i + 1
+			for end < len(source) && strings.ContainsRune("0123456789.eE+-", rune(source[end])) {
+				if (source[end] == '+' || source[end] == '-') && source[end-1] != 'e' && source[end-1] != 'E' {
+					break
+				}
+				end++
+			}
+			text := source[i:end]
+			var value interface{}
+			if n, err := strconv.ParseInt(text, 10, 64); err == nil {
+				value = n
+			} else if f, err := strconv.ParseFloat(text, 64); err == nil {
+				value = f
+			} else {
+				return nil, fmt.Errorf("invalid number %q at %d", text, i)
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: text, value: value, pos: i})
+			i = end
+			continue
+		case c == '_' || unicode.IsLetter(c):
+			end := i + 1
+			for end < len(source) && (source[end] == '_' || unicode.IsLetter(rune(source[end])) || unicode.IsDigit(rune(source[end]))) {
+				end++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: source[i:end], pos: i})
+			i = end
+			continue
+		}
+		matched := false
+		for _, p := range punctuation {
+			if strings.HasPrefix(source[i:], p) {
+				tokens = append(tokens, token{kind: tokenPunct, text: p, pos: i})
+				i += len(p)
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("unexpected character %q at %d", c, i)
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(source)}), nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// accept consumes the punctuation or keyword text when it is next.
+func (p *parser) accept(text string) bool {
+	t := p.peek()
+	if (t.kind == tokenPunct || t.kind == tokenIdent) && t.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(text string) error {
+	if !p.accept(text) {
+		return p.unexpected()
+	}
+	return nil
+}
+
+func (p *parser) unexpected() error {
+	t := p.peek()
+	if t.kind == tokenEOF {
+		return fmt.Errorf("unexpected end of expression")
+	}
+	return fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+}
+
+// parsePipe parses the lowest precedence level: a | b.
+func (p *parser) parsePipe() (node, error) {
+	left, err := p.parseComma()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("|") {
+		right, err := p.parseComma()
+		if err != nil {
+			return nil, err
+		}
+		left = pipe{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseComma() (node, error) {
+	left, err := p.parseAlternative()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept(",") {
+		right, err := p.parseAlternative()
+		if err != nil {
+			return nil, err
+		}
+		left = comma{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAlternative() (node, error) {
+	return p.parseBinary([]string{"//"}, p.parseOr)
+}
+
+func (p *parser) parseOr() (node, error) {
+	return p.parseBinary([]string{"or"}, p.parseAnd)
+}
+
+func (p *parser) parseAnd() (node, error) {
+	return p.parseBinary([]string{"and"}, p.parseComparison)
+}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parsePostfix()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if p.accept(op) {
+			right, err := p.parsePostfix()
+			if err != nil {
+				return nil, err
+			}
+			return binary{op, left, right}, nil
+		}
+	}
+	return left, nil
+}
+
+// parseBinary parses the left associative operators ops of operands parsed by operand.
+func (p *parser) parseBinary(ops []string, operand func() (node, error)) (node, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		matched := false
+		for _, op := range ops {
+			if p.accept(op) {
+				right, err := operand()
+				if err != nil {
+					return nil, err
+				}
+				left, matched = binary{op, left, right}, true
+				break
+			}
+		}
+		if !matched {
+			return left, nil
+		}
+	}
+}
+
+// parsePostfix parses a term followed by field accesses, indexes, iterations and ?.
+func (p *parser) parsePostfix() (node, error) {
+	term, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.peek().text == "." && p.peek().kind == tokenPunct && p.tokens[p.pos+1].kind == tokenIdent:
+			p.next()
+			term = field{term, p.next().text}
+		case p.peek().text == "." && p.peek().kind == tokenPunct && p.tokens[p.pos+1].kind == tokenString:
+			p.next()
+			term = field{term, p.next().value.(string)}
+		case p.peek().text == "." && p.peek().kind == tokenPunct && p.tokens[p.pos+1].text == "[":
+			p.next()
+		case p.accept("["):
+			if p.accept("]") {
+				term = iterate{term}
+				continue
+			}
+			index, err := p.parsePipe()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			term = indexNode{term, index}
+		case p.accept("?"):
+			term = try{term}
+		default:
+			return term, nil
+		}
+	}
+}
+
+func (p *parser) parseTerm() (node, error) {
+	t := p.peek()
+	switch t.kind {
+	case tokenString, tokenNumber:
+		p.next()
+		return literal{t.value}, nil
+	case tokenIdent:
+		p.next()
+		switch t.text {
+		case "true":
+			return literal{true}, nil
+		case "false":
+			return literal{false}, nil
+		case "null":
+			return literal{nil}, nil
+		}
+		var args []node
+		if p.accept("(") {
+			for {
+				arg, err := p.parsePipe()
+				if err != nil {
+					return nil, err
+				}
+				args = append(args, arg)
+				if p.accept(")") {
+					break
+				}
+				if err := p.expect(";"); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return newCall(t.text, args)
+	case tokenPunct:
+		switch t.text {
+		case ".":
+			p.next()
+			// The field of .name and ."name" is parsed as a postfix of the identity.
+			if next := p.peek(); next.kind == tokenIdent || next.kind == tokenString {
+				p.pos--
+			}
+			return identity{}, nil
+		case "(":
+			p.next()
+			inner, err := p.parsePipe()
+			if err != nil {
+				return nil, err
+			}
+			return inner, p.expect(")")
+		case "[":
+			p.next()
+			if p.accept("]") {
+				return array{}, nil
+			}
+			body, err := p.parsePipe()
+			if err != nil {
+				return nil, err
+			}
+			return array{body}, p.expect("]")
+		case "{":
+			p.next()
+			return p.parseObject()
+		}
+	}
+	return nil, p.unexpected()
+}
+
+// parseObject parses the entries of an object construction after its {: key: value, "key": value,
+// (expression): value, or key alone for key: .key.
+func (p *parser) parseObject() (node, error) {
+	var object object
+	if p.accept("}") {
+		return object, nil
+	}
+	for {
+		var entry objectEntry
+		t := p.next()
+		switch {
+		case t.kind == tokenIdent || t.kind == tokenString:
+			name := t.text
+			if t.kind == tokenString {
+				name = t.value.(string)
+			}
+			entry.key = literal{name}
+			entry.value = field{identity{}, name}
+		case t.kind == tokenPunct && t.text == "(":
+			key, err := p.parsePipe()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			entry.key = key
+		default:
+			p.pos--
+			return nil, p.unexpected()
+		}
+		if p.accept(":") {
+			// The value binds tighter than the comma separating the entries.
+			value, err := p.parseAlternative()
+			if err != nil {
+				return nil, err
+			}
+			entry.value = value
+		} else if entry.value == nil {
+			return nil, p.unexpected()
+		}
+		object = append(object, entry)
+		if p.accept("}") {
+			return object, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,259 @@
+// Package kube is a minimal client of the Kubernetes API for processes running in a pod, using
+// the credentials of the service account of the pod.
+package kube
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// ErrNotInCluster is returned by NewInClusterClient outside of a pod.
+var ErrNotInCluster = errors.New("not running in a Kubernetes pod, KUBERNETES_SERVICE_HOST is not set")
+
+// Client sends requests to the API server with the token of the service account of the pod.
+type Client struct {
+	url  string
+	http *http.Client
+	// Namespace is the namespace of the pod.
+	Namespace string
+	tokenFile string
+}
+
+// NewInClusterClient returns a client of the API server of the cluster running the pod.
+func NewInClusterClient() (*Client, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if len(host) == 0 || len(port) == 0 {
+		return nil, ErrNotInCluster
+	}
+	ca, err := ioutil.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the CA of the API server: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificate found in %s/ca.crt", serviceAccountDir)
+	}
+	namespace, err := ioutil.ReadFile(serviceAccountDir + "/namespace")
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the namespace of the pod: %w", err)
+	}
+	return &Client{
+		url:       "https://" + net.JoinHostPort(host, port),
+		http:      &http.Client{Timeout: 30 * time.Second, Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}},
+		Namespace: strings.TrimSpace(string(namespace)),
+		tokenFile: serviceAccountDir + "/token",
+	}, nil
+}
+
+// StatusError is returned for unsuccessful responses.
+type StatusError struct {
+	Method  string
+	Path    string
+	Code    int
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s %s: unexpected status %d: %s", e.Method, e.Path, e.Code, e.Message)
+}
+
+// IsNotFound reports whether err is a StatusError for a missing object.
+func IsNotFound(err error) bool {
+	var status *StatusError
+	return errors.As(err, &status) && status.Code == http.StatusNotFound
+}
+
+// Do sends a request with a JSON body, unless in is nil, and decodes the JSON response into out
+// unless it is nil. The body of a PATCH is a JSON merge patch.
+func (c *Client) Do(ctx context.Context, method, path string, in, out interface{}) error {
+	resp, err := c.send(ctx, c.http, method, path, in)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// send sends a request with httpClient and returns the response when it is successful.
+func (c *Client) send(ctx context.Context, httpClient *http.Client, method, path string, in interface{}) (*http.Response, error) {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.url+path, body)
+	if err != nil {
+		return nil, err
+	}
+	// The token is read for every request, the kubelet rotates it.
+	token, err := ioutil.ReadFile(c.tokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the service account token: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		contentType := "application/json"
+		if method == http.MethodPatch {
+			contentType = "application/merge-patch+json"
+		}
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		var status struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(data, &status) != nil || len(status.Message) == 0 {
+			status.Message = strings.TrimSpace(string(data))
+		}
+		return nil, &StatusError{Method: method, Path: path, Code: resp.StatusCode, Message: status.Message}
+	}
+	return resp, nil
+}
+
+// WatchEvent is a change of an object of a watch: its Type is ADDED, MODIFIED, DELETED or
+// BOOKMARK, and Object is the object after the change.
+type WatchEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+// IsGone reports whether err is a StatusError for a resource version too old to be watched from,
+// the objects must be listed again.
+func IsGone(err error) bool {
+	var status *StatusError
+	return errors.As(err, &status) && status.Code == http.StatusGone
+}
+
+// Watch calls fn with the changes of the objects of the list at path made after resourceVersion,
+// until the API server ends the watch after timeout, ctx is done or fn fails.
+func (c *Client) Watch(ctx context.Context, path, resourceVersion string, timeout time.Duration, fn func(WatchEvent) error) error {
+	query := url.Values{
+		"watch":               {"1"},
+		"resourceVersion":     {resourceVersion},
+		"allowWatchBookmarks": {"true"},
+		"timeoutSeconds":      {strconv.Itoa(int(timeout / time.Second))},
+	}
+	// The watch lasts longer than the timeout of the other requests.
+	resp, err := c.send(ctx, &http.Client{Transport: c.http.Transport}, http.MethodGet, path+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var event WatchEvent
+		if err := decoder.Decode(&event); err != nil {
+			if err == io.EOF || ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("unable to read the watch of %s: %w", path, err)
+		}
+		if event.Type == "ERROR" {
+			var status struct {
+				Code    int    `json:"code"`
+				Message string `json:"message"`
+			}
+			json.Unmarshal(event.Object, &status)
+			return &StatusError{Method: http.MethodGet, Path: path, Code: status.Code, Message: status.Message}
+		}
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+}
+
+// ObjectMeta is the metadata of an object.
+type ObjectMeta struct {
+	Name            string            `json:"name"`
+	Namespace       string            `json:"namespace,omitempty"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	Annotations     map[string]string `json:"annotations,omitempty"`
+	ResourceVersion string            `json:"resourceVersion,omitempty"`
+	// Generation is incremented by the API server when the spec of the object changes.
+	Generation int64 `json:"generation,omitempty"`
+}
+
+// Secret is a core/v1 Secret, its data is base64 encoded by encoding/json.
+type Secret struct {
+	APIVersion string            `json:"apiVersion"`
+	Kind       string            `json:"kind"`
+	Metadata   ObjectMeta        `json:"metadata"`
+	Type       string            `json:"type,omitempty"`
+	Data       map[string][]byte `json:"data,omitempty"`
+}
+
+func secretPath(namespace, name string) string {
+	return "/api/v1/namespaces/" + namespace + "/secrets/" + name
+}
+
+// GetSecret returns a Secret, IsNotFound reports whether it does not exist.
+func (c *Client) GetSecret(ctx context.Context, namespace, name string) (*Secret, error) {
+	secret := &Secret{}
+	if err := c.Do(ctx, http.MethodGet, secretPath(namespace, name), nil, secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// ApplySecret sets the keys of data in a Secret, creating it with labels when it does not exist.
+// The other keys of an existing Secret are kept.
+func (c *Client) ApplySecret(ctx context.Context, namespace, name string, data map[string][]byte, labels map[string]string) error {
+	secret, err := c.GetSecret(ctx, namespace, name)
+	if IsNotFound(err) {
+		secret = &Secret{
+			APIVersion: "v1",
+			Kind:       "Secret",
+			Metadata:   ObjectMeta{Name: name, Namespace: namespace, Labels: labels},
+			Type:       "Opaque",
+			Data:       data,
+		}
+		if err := c.Do(ctx, http.MethodPost, "/api/v1/namespaces/"+namespace+"/secrets", secret, nil); err != nil {
+			return fmt.Errorf("unable to create secret %s/%s: %w", namespace, name, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to read secret %s/%s: %w", namespace, name, err)
+	}
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	for key, value := range data {
+		secret.Data[key] = value
+	}
+	// The resource version of the read Secret makes the update fail if it changed in the meantime.
+	if err := c.Do(ctx, http.MethodPut, secretPath(namespace, name), secret, nil); err != nil {
+		return fmt.Errorf("unable to update secret %s/%s: %w", namespace, name, err)
+	}
+	return nil
+}
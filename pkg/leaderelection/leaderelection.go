@@ -0,0 +1,189 @@
+package leaderelection
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+)
+
+var isLeaderGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "mongodb_client_leader_election_is_leader",
+	Help: "Whether this instance currently holds the lease (1) or is a standby follower (0).",
+}, []string{"lease"})
+
+func init() {
+	prometheus.MustRegister(isLeaderGauge)
+}
+
+// Config describes a lease and what to do when it is acquired or lost.
+type Config struct {
+	// Collection stores one document per lease.
+	Collection *mongo.Collection
+	// Name identifies the lease within the collection.
+	Name string
+	// Identity is the unique holder identity of this instance.
+	Identity string
+
+	// LeaseDuration is how long followers wait after the last renewal before taking over the lease.
+	LeaseDuration time.Duration
+	// RenewDeadline is how long the leader keeps trying to renew before giving up leadership.
+	RenewDeadline time.Duration
+	// RetryPeriod is the interval between acquire and renew attempts.
+	RetryPeriod time.Duration
+
+	// OnStartedLeading is called when the lease is acquired; ctx is cancelled when it is lost.
+	OnStartedLeading func(ctx context.Context)
+	// OnStoppedLeading is called after leadership has been lost and OnStartedLeading has returned.
+	OnStoppedLeading func()
+}
+
+// LeaderElector competes for a lease stored in a MongoDB collection.
+type LeaderElector struct {
+	config Config
+}
+
+func NewLeaderElector(config Config) (*LeaderElector, error) {
+	if config.Collection == nil {
+		return nil, fmt.Errorf("a lease collection is required")
+	}
+	if len(config.Name) == 0 || len(config.Identity) == 0 {
+		return nil, fmt.Errorf("lease name and identity are required")
+	}
+	if config.LeaseDuration <= config.RenewDeadline {
+		return nil, fmt.Errorf("lease duration must be greater than the renew deadline")
+	}
+	if config.RenewDeadline <= config.RetryPeriod {
+		return nil, fmt.Errorf("renew deadline must be greater than the retry period")
+	}
+	if config.OnStartedLeading == nil {
+		return nil, fmt.Errorf("OnStartedLeading callback is required")
+	}
+	return &LeaderElector{config: config}, nil
+}
+
+// Run competes for the lease until stopCh is closed. Each time the lease is acquired,
+// OnStartedLeading runs until leadership is lost, after which the elector becomes a follower again.
+func (le *LeaderElector) Run(stopCh <-chan struct{}) {
+	isLeaderGauge.WithLabelValues(le.config.Name).Set(0)
+	for {
+		if !le.acquire(stopCh) {
+			return
+		}
+		le.lead(stopCh)
+		select {
+		case <-stopCh:
+			le.release()
+			return
+		default:
+		}
+	}
+}
+
+// acquire blocks until the lease is held by this instance, returning false if stopCh was closed first.
+func (le *LeaderElector) acquire(stopCh <-chan struct{}) bool {
+	klog.Infof("Attempting to acquire lease %s as %s", le.config.Name, le.config.Identity)
+	for {
+		err := le.tryAcquireOrRenew()
+		if err == nil {
+			klog.Infof("Acquired lease %s", le.config.Name)
+			return true
+		}
+		klog.V(4).Infof("Lease %s not acquired: %v", le.config.Name, err)
+
+		select {
+		case <-stopCh:
+			return false
+		case <-time.After(wait.Jitter(le.config.RetryPeriod, 1.2)):
+		}
+	}
+}
+
+func (le *LeaderElector) lead(stopCh <-chan struct{}) {
+	isLeaderGauge.WithLabelValues(le.config.Name).Set(1)
+	defer isLeaderGauge.WithLabelValues(le.config.Name).Set(0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		le.config.OnStartedLeading(ctx)
+	}()
+
+	le.renew(stopCh)
+	cancel()
+	<-done
+
+	klog.Infof("Stopped leading lease %s", le.config.Name)
+	if le.config.OnStoppedLeading != nil {
+		le.config.OnStoppedLeading()
+	}
+}
+
+// renew keeps the lease until a renewal fails for longer than RenewDeadline or stopCh is closed.
+func (le *LeaderElector) renew(stopCh <-chan struct{}) {
+	lastRenew := time.Now()
+	ticker := time.NewTicker(le.config.RetryPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		}
+		if err := le.tryAcquireOrRenew(); err != nil {
+			if time.Since(lastRenew) > le.config.RenewDeadline {
+				klog.Errorf("Failed to renew lease %s within %s: %v", le.config.Name, le.config.RenewDeadline, err)
+				return
+			}
+			klog.Warningf("Failed to renew lease %s: %v", le.config.Name, err)
+			continue
+		}
+		lastRenew = time.Now()
+	}
+}
+
+// tryAcquireOrRenew takes the lease when it is free, expired, or already held by this instance.
+func (le *LeaderElector) tryAcquireOrRenew() error {
+	ctx, cancel := context.WithTimeout(context.Background(), le.config.RetryPeriod)
+	defer cancel()
+
+	now := time.Now().UTC()
+	filter := bson.M{
+		"_id": le.config.Name,
+		"$or": bson.A{
+			bson.M{"holder": le.config.Identity},
+			bson.M{"expiresAt": bson.M{"$lt": now}},
+		},
+	}
+	update := bson.M{"$set": bson.M{
+		"holder":    le.config.Identity,
+		"renewedAt": now,
+		"expiresAt": now.Add(le.config.LeaseDuration),
+	}}
+	_, err := le.config.Collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		// The lease exists and is held by somebody else, so the upsert attempted to insert a second copy.
+		return fmt.Errorf("lease is held by another instance")
+	}
+	return err
+}
+
+// release expires the lease immediately so a follower can take over without waiting for it to lapse.
+func (le *LeaderElector) release() {
+	ctx, cancel := context.WithTimeout(context.Background(), le.config.RetryPeriod)
+	defer cancel()
+	_, err := le.config.Collection.UpdateOne(ctx,
+		bson.M{"_id": le.config.Name, "holder": le.config.Identity},
+		bson.M{"$set": bson.M{"expiresAt": time.Now().UTC()}},
+	)
+	if err != nil {
+		klog.Warningf("Unable to release lease %s: %v", le.config.Name, err)
+	}
+}
@@ -0,0 +1,284 @@
+// Package lint checks query filters and aggregation pipelines before they are run. Unknown
+// operators and malformed stages are errors, which the server would reject, and filters that no
+// index supports on large collections are warnings, since they scan every document.
+package lint
+
+import (
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Severity is how serious a problem is.
+type Severity string
+
+const (
+	// Error is a problem the server rejects.
+	Error Severity = "error"
+	// Warning is a problem of performance.
+	Warning Severity = "warning"
+)
+
+// Problem is a problem found in a filter or a pipeline, at the dotted path of the offending value.
+type Problem struct {
+	Severity Severity `json:"severity"`
+	Path     string   `json:"path"`
+	Message  string   `json:"message"`
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s: %s: %s", p.Severity, p.Path, p.Message)
+}
+
+// Errors returns the number of problems that are errors.
+func Errors(problems []Problem) int {
+	n := 0
+	for _, p := range problems {
+		if p.Severity == Error {
+			n++
+		}
+	}
+	return n
+}
+
+// topLevelOperators are the operators of the top level of filters.
+var topLevelOperators = set("$and", "$or", "$nor", "$expr", "$jsonSchema", "$text", "$where", "$comment", "$alwaysTrue", "$alwaysFalse", "$sampleRate")
+
+// fieldOperators are the operators of the conditions on fields, and of $not and $elemMatch.
+var fieldOperators = set("$eq", "$ne", "$gt", "$gte", "$lt", "$lte", "$in", "$nin", "$not", "$exists", "$type",
+	"$mod", "$regex", "$options", "$all", "$elemMatch", "$size", "$bitsAllClear", "$bitsAllSet", "$bitsAnyClear",
+	"$bitsAnySet", "$geoIntersects", "$geoWithin", "$near", "$nearSphere", "$maxDistance", "$minDistance")
+
+// stages are the aggregation stages.
+var stages = set("$addFields", "$bucket", "$bucketAuto", "$changeStream", "$collStats", "$count", "$currentOp",
+	"$densify", "$documents", "$facet", "$fill", "$geoNear", "$graphLookup", "$group", "$indexStats", "$limit",
+	"$listLocalSessions", "$listSearchIndexes", "$listSessions", "$lookup", "$match", "$merge", "$out",
+	"$planCacheStats", "$project", "$redact", "$replaceRoot", "$replaceWith", "$sample", "$search", "$searchMeta",
+	"$set", "$setWindowFields", "$skip", "$sort", "$sortByCount", "$unionWith", "$unset", "$unwind")
+
+func set(values ...string) map[string]bool {
+	s := make(map[string]bool, len(values))
+	for _, v := range values {
+		s[v] = true
+	}
+	return s
+}
+
+// Filter returns the problems of a query filter.
+func Filter(filter bson.D) []Problem {
+	return checkFilter("filter", filter)
+}
+
+func checkFilter(path string, filter bson.D) []Problem {
+	var problems []Problem
+	for _, e := range filter {
+		at := path + "." + e.Key
+		if !strings.HasPrefix(e.Key, "$") {
+			problems = append(problems, checkCondition(at, e.Value)...)
+			continue
+		}
+		if !topLevelOperators[e.Key] {
+			problems = append(problems, unknown(at, "top-level query operator", e.Key, fieldOperators[e.Key]))
+			continue
+		}
+		switch e.Key {
+		case "$and", "$or", "$nor":
+			branches, ok := e.Value.(bson.A)
+			if !ok || len(branches) == 0 {
+				problems = append(problems, Problem{Error, at, e.Key + " must be a non-empty array of filters"})
+				continue
+			}
+			for i, branch := range branches {
+				doc, ok := branch.(bson.D)
+				if !ok {
+					problems = append(problems, Problem{Error, fmt.Sprintf("%s.%d", at, i), e.Key + " must be an array of filters"})
+					continue
+				}
+				problems = append(problems, checkFilter(fmt.Sprintf("%s.%d", at, i), doc)...)
+			}
+		case "$text":
+			if doc, ok := e.Value.(bson.D); !ok || !hasKey(doc, "$search") {
+				problems = append(problems, Problem{Error, at, "$text requires $search"})
+			}
+		}
+	}
+	return problems
+}
+
+// checkCondition returns the problems of the condition on a field, a value or a document of
+// operators.
+func checkCondition(path string, value interface{}) []Problem {
+	doc, ok := value.(bson.D)
+	if !ok || len(doc) == 0 || !strings.HasPrefix(doc[0].Key, "$") {
+		// Values and documents are matched by equality.
+		return nil
+	}
+	var problems []Problem
+	for _, e := range doc {
+		at := path + "." + e.Key
+		if !strings.HasPrefix(e.Key, "$") {
+			problems = append(problems, Problem{Error, at, "operators and fields can not be mixed in a condition"})
+			continue
+		}
+		if !fieldOperators[e.Key] {
+			// Operators of geospatial shapes and $text are the arguments of their operator.
+			problems = append(problems, unknown(at, "query operator", e.Key, topLevelOperators[e.Key]))
+			continue
+		}
+		switch e.Key {
+		case "$in", "$nin", "$all":
+			if _, ok := e.Value.(bson.A); !ok {
+				problems = append(problems, Problem{Error, at, e.Key + " must be an array"})
+			}
+		case "$not":
+			switch e.Value.(type) {
+			case bson.D:
+				problems = append(problems, checkCondition(at, e.Value)...)
+			default:
+				if !isRegex(e.Value) {
+					problems = append(problems, Problem{Error, at, "$not must be a regular expression or a document of operators"})
+				}
+			}
+		case "$elemMatch":
+			sub, ok := e.Value.(bson.D)
+			if !ok {
+				problems = append(problems, Problem{Error, at, "$elemMatch must be a document"})
+			} else if len(sub) > 0 && strings.HasPrefix(sub[0].Key, "$") && fieldOperators[sub[0].Key] {
+				problems = append(problems, checkCondition(at, sub)...)
+			} else {
+				problems = append(problems, checkFilter(at, sub)...)
+			}
+		case "$size":
+			if _, ok := toInt(e.Value); !ok {
+				problems = append(problems, Problem{Error, at, "$size must be a number"})
+			}
+		}
+	}
+	return problems
+}
+
+// unknown returns the problem of an unknown operator, which may be known in another position.
+func unknown(path, kind, operator string, elsewhere bool) Problem {
+	message := fmt.Sprintf("unknown %s %s", kind, operator)
+	if elsewhere {
+		if kind == "query operator" {
+			message += ", which is only allowed at the top level of a filter"
+		} else {
+			message += ", which only applies to a field"
+		}
+	}
+	return Problem{Error, path, message}
+}
+
+// Pipeline returns the problems of an aggregation pipeline and the filters of its $match stages.
+func Pipeline(pipeline []bson.D) []Problem {
+	return checkPipeline("pipeline", pipeline)
+}
+
+func checkPipeline(path string, pipeline []bson.D) []Problem {
+	var problems []Problem
+	for i, stage := range pipeline {
+		at := fmt.Sprintf("%s.%d", path, i)
+		if len(stage) != 1 {
+			problems = append(problems, Problem{Error, at, fmt.Sprintf("a stage must have a single field, not %d", len(stage))})
+			continue
+		}
+		name, value := stage[0].Key, stage[0].Value
+		at += "." + name
+		if !stages[name] {
+			problems = append(problems, Problem{Error, at, "unknown stage " + name})
+			continue
+		}
+		if (name == "$out" || name == "$merge") && i != len(pipeline)-1 {
+			problems = append(problems, Problem{Error, at, name + " must be the last stage"})
+		}
+		switch name {
+		case "$match":
+			doc, ok := value.(bson.D)
+			if !ok {
+				problems = append(problems, Problem{Error, at, "$match must be a filter document"})
+				continue
+			}
+			problems = append(problems, checkFilter(at, doc)...)
+		case "$facet":
+			doc, ok := value.(bson.D)
+			if !ok {
+				problems = append(problems, Problem{Error, at, "$facet must be a document of pipelines"})
+				continue
+			}
+			for _, facet := range doc {
+				sub, err := subPipeline(facet.Value)
+				if err != nil {
+					problems = append(problems, Problem{Error, at + "." + facet.Key, err.Error()})
+					continue
+				}
+				problems = append(problems, checkPipeline(at+"."+facet.Key, sub)...)
+			}
+		case "$lookup", "$unionWith":
+			doc, ok := value.(bson.D)
+			if !ok {
+				continue
+			}
+			for _, e := range doc {
+				if e.Key != "pipeline" {
+					continue
+				}
+				sub, err := subPipeline(e.Value)
+				if err != nil {
+					problems = append(problems, Problem{Error, at + ".pipeline", err.Error()})
+					continue
+				}
+				problems = append(problems, checkPipeline(at+".pipeline", sub)...)
+			}
+		case "$limit", "$skip":
+			if n, ok := toInt(value); !ok || n < 0 || name == "$limit" && n == 0 {
+				problems = append(problems, Problem{Error, at, name + " must be a positive integer"})
+			}
+		}
+	}
+	return problems
+}
+
+func subPipeline(value interface{}) ([]bson.D, error) {
+	array, ok := value.(bson.A)
+	if !ok {
+		return nil, fmt.Errorf("a pipeline must be an array of stages")
+	}
+	pipeline := make([]bson.D, 0, len(array))
+	for _, stage := range array {
+		doc, ok := stage.(bson.D)
+		if !ok {
+			return nil, fmt.Errorf("a pipeline must be an array of stages")
+		}
+		pipeline = append(pipeline, doc)
+	}
+	return pipeline, nil
+}
+
+func isRegex(value interface{}) bool {
+	_, ok := value.(primitive.Regex)
+	return ok
+}
+
+func hasKey(doc bson.D, key string) bool {
+	for _, e := range doc {
+		if e.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+func toInt(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case float64:
+		return int64(v), v == float64(int64(v))
+	}
+	return 0, false
+}
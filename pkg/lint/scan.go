@@ -0,0 +1,181 @@
+package lint
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// CollectionScan returns a warning when collection holds at least threshold documents, counted
+// by $collStats, and none of the indexes listed by $indexStats supports filter, so that the query
+// would scan every document. A filter is supported by an index of which the first field is one of
+// the fields it matches, and a $or by indexes supporting each of its branches. Empty filters are
+// not reported, they scan the collection on purpose.
+func CollectionScan(ctx context.Context, collection *mongo.Collection, path string, filter bson.D, threshold int64) ([]Problem, error) {
+	if len(filter) == 0 {
+		return nil, nil
+	}
+	count, err := documentCount(ctx, collection)
+	if err != nil {
+		return nil, err
+	}
+	if count < threshold {
+		return nil, nil
+	}
+	prefixes, err := indexPrefixes(ctx, collection)
+	if err != nil {
+		return nil, err
+	}
+	if supported(filter, prefixes) {
+		return nil, nil
+	}
+	fields := filterFields(filter)
+	message := fmt.Sprintf("no index supports the filter, the query would scan the %d documents of %s", count, collection.Name())
+	if len(fields) > 0 {
+		sort.Strings(fields)
+		message = fmt.Sprintf("no index supports the fields %s, the query would scan the %d documents of %s", strings.Join(fields, ", "), count, collection.Name())
+	}
+	return []Problem{{Warning, path, message}}, nil
+}
+
+// LeadingMatch returns the filter of the $match stage starting pipeline, the only one that can
+// use the indexes of the collection, nil when there is none.
+func LeadingMatch(pipeline []bson.D) bson.D {
+	if len(pipeline) == 0 || len(pipeline[0]) != 1 || pipeline[0][0].Key != "$match" {
+		return nil
+	}
+	filter, _ := pipeline[0][0].Value.(bson.D)
+	return filter
+}
+
+// documentCount returns the number of documents of collection, summed over the shards.
+func documentCount(ctx context.Context, collection *mongo.Collection) (int64, error) {
+	cursor, err := collection.Aggregate(ctx, mongo.Pipeline{{{Key: "$collStats", Value: bson.D{{Key: "count", Value: bson.D{}}}}}})
+	if err != nil {
+		return 0, fmt.Errorf("unable to count the documents of %s: %w", collection.Name(), err)
+	}
+	var stats []struct {
+		Count int64 `bson:"count"`
+	}
+	if err := cursor.All(ctx, &stats); err != nil {
+		return 0, fmt.Errorf("unable to count the documents of %s: %w", collection.Name(), err)
+	}
+	var count int64
+	for _, s := range stats {
+		count += s.Count
+	}
+	return count, nil
+}
+
+// indexPrefixes returns the first field of every index of collection, and "$text" for a text index.
+func indexPrefixes(ctx context.Context, collection *mongo.Collection) (map[string]bool, error) {
+	cursor, err := collection.Aggregate(ctx, mongo.Pipeline{{{Key: "$indexStats", Value: bson.D{}}}})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the indexes of %s: %w", collection.Name(), err)
+	}
+	var indexes []struct {
+		Key bson.D `bson:"key"`
+	}
+	if err := cursor.All(ctx, &indexes); err != nil {
+		return nil, fmt.Errorf("unable to list the indexes of %s: %w", collection.Name(), err)
+	}
+	prefixes := map[string]bool{"_id": true}
+	for _, index := range indexes {
+		if len(index.Key) == 0 {
+			continue
+		}
+		if index.Key[0].Value == "text" || index.Key[0].Key == "_fts" {
+			prefixes["$text"] = true
+			continue
+		}
+		prefixes[index.Key[0].Key] = true
+	}
+	return prefixes, nil
+}
+
+// supported reports whether an index of which the first field is in prefixes can select the
+// documents matching filter.
+func supported(filter bson.D, prefixes map[string]bool) bool {
+	for _, e := range filter {
+		switch e.Key {
+		case "$and":
+			branches, _ := e.Value.(bson.A)
+			for _, branch := range branches {
+				if doc, ok := branch.(bson.D); ok && supported(doc, prefixes) {
+					return true
+				}
+			}
+		case "$or":
+			branches, _ := e.Value.(bson.A)
+			all := len(branches) > 0
+			for _, branch := range branches {
+				doc, ok := branch.(bson.D)
+				all = all && ok && supported(doc, prefixes)
+			}
+			if all {
+				return true
+			}
+		case "$text":
+			if prefixes["$text"] {
+				return true
+			}
+		default:
+			if !strings.HasPrefix(e.Key, "$") && prefixes[e.Key] && indexable(e.Value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// indexable reports whether the condition on a field can select its documents from an index,
+// which negations such as $ne, $nin and $not can not.
+func indexable(value interface{}) bool {
+	doc, ok := value.(bson.D)
+	if !ok || len(doc) == 0 || !strings.HasPrefix(doc[0].Key, "$") {
+		return true
+	}
+	for _, e := range doc {
+		switch e.Key {
+		case "$ne", "$nin", "$not":
+		case "$exists":
+			if exists, ok := e.Value.(bool); ok && exists {
+				return true
+			}
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+// filterFields returns the fields matched by filter, including those of its $and, $or and $nor.
+func filterFields(filter bson.D) []string {
+	seen := map[string]bool{}
+	var fields []string
+	var collect func(bson.D)
+	collect = func(doc bson.D) {
+		for _, e := range doc {
+			switch e.Key {
+			case "$and", "$or", "$nor":
+				branches, _ := e.Value.(bson.A)
+				for _, branch := range branches {
+					if sub, ok := branch.(bson.D); ok {
+						collect(sub)
+					}
+				}
+			default:
+				if !strings.HasPrefix(e.Key, "$") && !seen[e.Key] {
+					seen[e.Key] = true
+					fields = append(fields, e.Key)
+				}
+			}
+		}
+	}
+	collect(filter)
+	return fields
+}
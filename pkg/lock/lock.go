@@ -0,0 +1,210 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"k8s.io/klog"
+)
+
+// ErrLocked is returned when the lock is currently held by another owner.
+var ErrLocked = errors.New("lock is held by another owner")
+
+// ErrLost is returned by Release and Do once the lock has expired without being renewed or has
+// been taken over by another owner.
+var ErrLost = errors.New("lock was lost")
+
+// Locker hands out TTL based locks stored as documents of a collection.
+type Locker struct {
+	collection *mongo.Collection
+	owner      string
+	ttl        time.Duration
+	heartbeat  time.Duration
+}
+
+// NewLocker returns a Locker whose locks expire after ttl unless renewed. Held locks are renewed
+// every ttl/3.
+func NewLocker(collection *mongo.Collection, owner string, ttl time.Duration) *Locker {
+	return &Locker{
+		collection: collection,
+		owner:      owner,
+		ttl:        ttl,
+		heartbeat:  ttl / 3,
+	}
+}
+
+// EnsureIndexes creates a TTL index that removes lock documents once they have expired.
+func (l *Locker) EnsureIndexes(ctx context.Context) error {
+	_, err := l.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0).SetName("expiresAt_ttl"),
+	})
+	return err
+}
+
+// Lock is a held lock that is renewed in the background until released or lost.
+type Lock struct {
+	locker *Locker
+	name   string
+	token  primitive.ObjectID
+
+	stop     chan struct{}
+	stopped  chan struct{}
+	lost     chan struct{}
+	lostOnce sync.Once
+}
+
+type document struct {
+	Name       string             `bson:"_id"`
+	Owner      string             `bson:"owner"`
+	Token      primitive.ObjectID `bson:"token"`
+	AcquiredAt time.Time          `bson:"acquiredAt"`
+	ExpiresAt  time.Time          `bson:"expiresAt"`
+}
+
+// Acquire takes the lock called name, returning ErrLocked if another owner holds an unexpired lock.
+func (l *Locker) Acquire(ctx context.Context, name string) (*Lock, error) {
+	now := time.Now().UTC()
+	token := primitive.NewObjectID()
+	result := l.collection.FindOneAndUpdate(ctx,
+		bson.M{"_id": name, "expiresAt": bson.M{"$lt": now}},
+		bson.M{"$set": bson.M{
+			"owner":      l.owner,
+			"token":      token,
+			"acquiredAt": now,
+			"expiresAt":  now.Add(l.ttl),
+		}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	)
+	var held document
+	if err := result.Decode(&held); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, fmt.Errorf("%s: %w", name, ErrLocked)
+		}
+		return nil, fmt.Errorf("unable to acquire lock %s: %w", name, err)
+	}
+	if held.Token != token {
+		return nil, fmt.Errorf("%s: %w", name, ErrLocked)
+	}
+
+	lock := &Lock{
+		locker:  l,
+		name:    name,
+		token:   token,
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+		lost:    make(chan struct{}),
+	}
+	go lock.renew()
+	return lock, nil
+}
+
+// Do runs fn while holding the lock called name. The context passed to fn is cancelled if the lock is lost.
+func (l *Locker) Do(ctx context.Context, name string, fn func(ctx context.Context) error) error {
+	lock, err := l.Acquire(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-lock.Lost():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	err = fn(ctx)
+
+	releaseCtx, releaseCancel := context.WithTimeout(context.Background(), l.heartbeat)
+	defer releaseCancel()
+	if releaseErr := lock.Release(releaseCtx); releaseErr != nil {
+		if err == nil {
+			err = releaseErr
+		} else {
+			klog.Warningf("Unable to release lock %s: %v", name, releaseErr)
+		}
+	}
+	return err
+}
+
+// Lost is closed when the lock has been stolen or has expired without being renewed.
+func (lk *Lock) Lost() <-chan struct{} {
+	return lk.lost
+}
+
+// Release stops renewing the lock and removes it so another owner can acquire it immediately.
+func (lk *Lock) Release(ctx context.Context) error {
+	close(lk.stop)
+	<-lk.stopped
+
+	select {
+	case <-lk.lost:
+		return fmt.Errorf("%s: %w", lk.name, ErrLost)
+	default:
+	}
+
+	result, err := lk.locker.collection.DeleteOne(ctx, bson.M{"_id": lk.name, "token": lk.token})
+	if err != nil {
+		return fmt.Errorf("unable to release lock %s: %w", lk.name, err)
+	}
+	if result.DeletedCount == 0 {
+		lk.markLost()
+		return fmt.Errorf("%s: %w", lk.name, ErrLost)
+	}
+	return nil
+}
+
+func (lk *Lock) renew() {
+	defer close(lk.stopped)
+
+	ticker := time.NewTicker(lk.locker.heartbeat)
+	defer ticker.Stop()
+	lastRenew := time.Now()
+	for {
+		select {
+		case <-lk.stop:
+			return
+		case <-lk.lost:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), lk.locker.heartbeat)
+		now := time.Now().UTC()
+		result, err := lk.locker.collection.UpdateOne(ctx,
+			bson.M{"_id": lk.name, "token": lk.token},
+			bson.M{"$set": bson.M{"expiresAt": now.Add(lk.locker.ttl)}},
+		)
+		cancel()
+
+		switch {
+		case err != nil:
+			klog.Warningf("Unable to renew lock %s: %v", lk.name, err)
+			if time.Since(lastRenew) >= lk.locker.ttl {
+				klog.Errorf("Lock %s expired without being renewed", lk.name)
+				lk.markLost()
+			}
+		case result.MatchedCount == 0:
+			klog.Errorf("Lock %s was stolen by another owner", lk.name)
+			lk.markLost()
+		default:
+			lastRenew = time.Now()
+		}
+	}
+}
+
+func (lk *Lock) markLost() {
+	lk.lostOnce.Do(func() {
+		close(lk.lost)
+	})
+}
@@ -0,0 +1,322 @@
+// Package mask replaces the sensitive fields of documents, as configured by namespace and field,
+// so that production data can be loaded into staging environments. The replacements derive from
+// the original values, so that the same value is masked the same way in every document and every
+// run with the same salt, and references between collections still match.
+package mask
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"path"
+	"strings"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/generate"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sigs.k8s.io/yaml"
+)
+
+// Strategies of the fields.
+const (
+	// Hash replaces a value by a keyed hash of it: a hex string, an ObjectID for ObjectIDs and a
+	// positive integer for integers.
+	Hash = "hash"
+	// Redact replaces a value by a constant, "REDACTED" unless Value is set.
+	Redact = "redact"
+	// Faker replaces a value by a fake one of the function Faker of the generate package.
+	Faker = "faker"
+	// Partial replaces the characters of a value but the KeepFirst first and KeepLast last ones.
+	Partial = "partial"
+)
+
+// Config is the file of the masking rules, such as:
+//
+//	salt: change-me
+//	rules:
+//	- namespace: sampledb.podcasts
+//	  fields:
+//	    author: {strategy: faker, faker: name}
+//	    contact.email: hash
+//	    contact.phone: {strategy: partial, keepLast: 4}
+//	- namespace: "*.users"
+//	  fields:
+//	    password: redact
+type Config struct {
+	// Salt keys the hashes and seeds the fake values, so that they cannot be computed from the
+	// original values without it.
+	Salt  string `json:"salt,omitempty"`
+	Rules []Rule `json:"rules"`
+}
+
+// Rule masks fields of the collections whose <database>.<collection> matches Namespace, a pattern
+// of path.Match.
+type Rule struct {
+	Namespace string `json:"namespace"`
+	// Fields are dotted paths, which cross arrays, and their masks.
+	Fields map[string]Field `json:"fields"`
+}
+
+// Field is the mask of a field, a strategy name alone in the file when it takes no options.
+type Field struct {
+	Strategy string `json:"strategy"`
+	// Length truncates the hex strings of Hash, when it is set.
+	Length int `json:"length,omitempty"`
+	// Value replaces the values of Redact, an Extended JSON value.
+	Value json.RawMessage `json:"value,omitempty"`
+	// Faker names the function of Faker and Args are its arguments, such as {"min": 1}.
+	Faker string          `json:"faker,omitempty"`
+	Args  json.RawMessage `json:"args,omitempty"`
+	// KeepFirst and KeepLast are the characters kept by Partial, Char replaces the others, "*" by
+	// default.
+	KeepFirst int    `json:"keepFirst,omitempty"`
+	KeepLast  int    `json:"keepLast,omitempty"`
+	Char      string `json:"char,omitempty"`
+}
+
+// UnmarshalJSON accepts a strategy name for a field without options.
+func (f *Field) UnmarshalJSON(data []byte) error {
+	var strategy string
+	if err := json.Unmarshal(data, &strategy); err == nil {
+		*f = Field{Strategy: strategy}
+		return nil
+	}
+	type field Field
+	return json.Unmarshal(data, (*field)(f))
+}
+
+// Load returns the masker of the rules of a YAML or JSON file.
+func Load(file string) (*Masker, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read masking rules: %w", err)
+	}
+	var config Config
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return nil, fmt.Errorf("unable to parse masking rules %s: %w", file, err)
+	}
+	masker, err := New(config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid masking rules %s: %w", file, err)
+	}
+	return masker, nil
+}
+
+// Masker masks the documents of the collections of its rules.
+type Masker struct {
+	salt  []byte
+	rules []rule
+}
+
+type rule struct {
+	namespace string
+	fields    []field
+}
+
+type field struct {
+	path []string
+	mask func(value interface{}) interface{}
+}
+
+// New returns the masker of config.
+func New(config Config) (*Masker, error) {
+	m := &Masker{salt: []byte(config.Salt)}
+	for i, r := range config.Rules {
+		if _, err := path.Match(r.Namespace, ""); err != nil || len(r.Namespace) == 0 {
+			return nil, fmt.Errorf("rule %d: invalid namespace pattern %q", i+1, r.Namespace)
+		}
+		compiled := rule{namespace: r.Namespace}
+		for name, f := range r.Fields {
+			parts := strings.Split(name, ".")
+			if parts[0] == "_id" {
+				return nil, fmt.Errorf("rule %s: _id cannot be masked, it identifies the documents", r.Namespace)
+			}
+			mask, err := m.compile(f)
+			if err != nil {
+				return nil, fmt.Errorf("rule %s, field %s: %w", r.Namespace, name, err)
+			}
+			compiled.fields = append(compiled.fields, field{path: parts, mask: mask})
+		}
+		m.rules = append(m.rules, compiled)
+	}
+	return m, nil
+}
+
+func (m *Masker) compile(f Field) (func(value interface{}) interface{}, error) {
+	switch f.Strategy {
+	case Hash:
+		if f.Length < 0 {
+			return nil, fmt.Errorf("length must not be negative")
+		}
+		return func(value interface{}) interface{} {
+			sum := m.hash(value)
+			switch value.(type) {
+			case primitive.ObjectID:
+				var id primitive.ObjectID
+				copy(id[:], sum)
+				return id
+			case int32:
+				return int32(binary.BigEndian.Uint32(sum) >> 1)
+			case int64:
+				return int64(binary.BigEndian.Uint64(sum) >> 1)
+			}
+			s := hex.EncodeToString(sum)
+			if f.Length > 0 && f.Length < len(s) {
+				s = s[:f.Length]
+			}
+			return s
+		}, nil
+	case Redact:
+		var replacement interface{} = "REDACTED"
+		if len(f.Value) > 0 {
+			wrapped := bson.D{}
+			if err := bson.UnmarshalExtJSON([]byte(`{"v":`+string(f.Value)+`}`), false, &wrapped); err != nil {
+				return nil, fmt.Errorf("value is not an Extended JSON value: %w", err)
+			}
+			replacement = wrapped[0].Value
+		}
+		return func(value interface{}) interface{} { return replacement }, nil
+	case Faker:
+		args := bson.D{}
+		if len(f.Args) > 0 {
+			if err := bson.UnmarshalExtJSON(f.Args, false, &args); err != nil {
+				return nil, fmt.Errorf("args is not an Extended JSON document: %w", err)
+			}
+		}
+		fake, err := generate.Faker(f.Faker, args)
+		if err != nil {
+			return nil, err
+		}
+		return func(value interface{}) interface{} {
+			// The fake value is drawn from a source seeded by the hash of the original one.
+			return fake(rand.New(rand.NewSource(int64(binary.BigEndian.Uint64(m.hash(value))))))
+		}, nil
+	case Partial:
+		if f.KeepFirst < 0 || f.KeepLast < 0 {
+			return nil, fmt.Errorf("keepFirst and keepLast must not be negative")
+		}
+		char := f.Char
+		if len(char) == 0 {
+			char = "*"
+		}
+		return func(value interface{}) interface{} {
+			s, ok := value.(string)
+			if !ok {
+				s = fmt.Sprint(value)
+			}
+			runes := []rune(s)
+			if f.KeepFirst+f.KeepLast >= len(runes) {
+				return strings.Repeat(char, len(runes))
+			}
+			return string(runes[:f.KeepFirst]) + strings.Repeat(char, len(runes)-f.KeepFirst-f.KeepLast) + string(runes[len(runes)-f.KeepLast:])
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown strategy %q, must be one of %s, %s, %s or %s", f.Strategy, Hash, Redact, Faker, Partial)
+}
+
+// hash returns the keyed hash of the type and encoding of value.
+func (m *Masker) hash(value interface{}) []byte {
+	mac := hmac.New(sha256.New, m.salt)
+	if s, ok := value.(string); ok {
+		mac.Write([]byte(s))
+	} else if t, data, err := bson.MarshalValue(value); err == nil {
+		mac.Write([]byte{byte(t)})
+		mac.Write(data)
+	} else {
+		mac.Write([]byte(fmt.Sprint(value)))
+	}
+	return mac.Sum(nil)
+}
+
+// Collection returns the masks of the collection of database, nil when none of the rules applies
+// to it.
+func (m *Masker) Collection(database, collection string) *Collection {
+	if m == nil {
+		return nil
+	}
+	namespace := database + "." + collection
+	var fields []field
+	for _, r := range m.rules {
+		if matched, _ := path.Match(r.namespace, namespace); matched {
+			fields = append(fields, r.fields...)
+		}
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	return &Collection{fields: fields}
+}
+
+// Collection masks the documents of a collection.
+type Collection struct {
+	fields []field
+}
+
+// Apply returns doc with its masked fields replaced, doc itself when it has none of them. A nil
+// Collection returns doc.
+func (c *Collection) Apply(doc bson.Raw) (bson.Raw, error) {
+	if c == nil {
+		return doc, nil
+	}
+	var decoded bson.D
+	if err := bson.Unmarshal(doc, &decoded); err != nil {
+		return nil, fmt.Errorf("unable to decode document: %w", err)
+	}
+	var changed bool
+	for _, f := range c.fields {
+		var masked bool
+		decoded, masked = apply(decoded, f.path, f.mask)
+		changed = changed || masked
+	}
+	if !changed {
+		return doc, nil
+	}
+	data, err := bson.Marshal(decoded)
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode masked document: %w", err)
+	}
+	return data, nil
+}
+
+// apply masks the values at path of doc, descending into the documents and the arrays along it, and
+// reports whether it found any.
+func apply(doc bson.D, path []string, mask func(interface{}) interface{}) (bson.D, bool) {
+	var found bool
+	for i, element := range doc {
+		if element.Key != path[0] {
+			continue
+		}
+		var masked bool
+		doc[i].Value, masked = applyValue(element.Value, path[1:], mask)
+		found = found || masked
+	}
+	return doc, found
+}
+
+func applyValue(value interface{}, path []string, mask func(interface{}) interface{}) (interface{}, bool) {
+	switch v := value.(type) {
+	case bson.A:
+		var found bool
+		for i, item := range v {
+			var masked bool
+			v[i], masked = applyValue(item, path, mask)
+			found = found || masked
+		}
+		return v, found
+	case bson.D:
+		if len(path) > 0 {
+			return apply(v, path, mask)
+		}
+	case nil:
+		return nil, false
+	}
+	if len(path) > 0 {
+		return value, false
+	}
+	return mask(value), true
+}
@@ -0,0 +1,339 @@
+// Package materialize maintains materialized views: collections holding the result of an
+// aggregation pipeline ending in $merge or $out, refreshed on a schedule as a job or on demand
+// through the HTTP API.
+package materialize
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/jobs"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"k8s.io/klog"
+)
+
+var (
+	refreshesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mongodb_client_materialized_view_refreshes_total",
+		Help: "Number of refreshes of each materialized view, partitioned by trigger and result.",
+	}, []string{"view", "trigger", "result"})
+	refreshDurationSeconds = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "mongodb_client_materialized_view_refresh_duration_seconds",
+		Help: "Duration of the last successful refresh of each materialized view in seconds.",
+	}, []string{"view"})
+	lastRefreshTimestamp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "mongodb_client_materialized_view_last_refresh_timestamp_seconds",
+		Help: "Unix time of the start of the last successful refresh of each materialized view, the time its content reflects.",
+	}, []string{"view"})
+)
+
+func init() {
+	prometheus.MustRegister(refreshesTotal, refreshDurationSeconds, lastRefreshTimestamp)
+}
+
+// Triggers of a refresh, in the metrics.
+const (
+	Scheduled = "schedule"
+	OnDemand  = "api"
+)
+
+// Prefix is the path under which the materialized views are served.
+const Prefix = "/api/materialized/"
+
+// ErrRefreshing is returned when a refresh is requested while the view is being refreshed.
+var ErrRefreshing = errors.New("the view is already being refreshed")
+
+// View is an aggregation of Source whose last stage writes its result to a collection.
+type View struct {
+	// Name identifies the view in the logs, the metrics and the HTTP API.
+	Name     string
+	Database string
+	Source   string
+	// Pipeline ends with a $merge or an $out stage.
+	Pipeline []bson.D
+	// Timeout bounds the refreshes requested through the HTTP API, the scheduled ones are bounded
+	// by the timeout of their job.
+	Timeout time.Duration
+
+	lock       sync.Mutex
+	refreshing bool
+	status     Status
+}
+
+// Status is the outcome of the last refreshes of a view.
+type Status struct {
+	Name     string `json:"name"`
+	Database string `json:"database"`
+	Source   string `json:"source"`
+	// Target is the collection written by the last stage, database.collection when it is written
+	// to another database.
+	Target     string `json:"target"`
+	Refreshing bool   `json:"refreshing"`
+	// LastRefresh is the start of the last successful refresh, the time the content reflects.
+	LastRefresh *time.Time `json:"lastRefresh,omitempty"`
+	// LastDuration is the duration of the last successful refresh, such as "1.5s".
+	LastDuration string     `json:"lastDuration,omitempty"`
+	LastError    string     `json:"lastError,omitempty"`
+	LastFailure  *time.Time `json:"lastFailure,omitempty"`
+}
+
+// Validate reports whether the view is complete and its pipeline writes its result.
+func (v *View) Validate() error {
+	if len(v.Source) == 0 {
+		return fmt.Errorf("a source collection is required")
+	}
+	if len(v.Pipeline) == 0 {
+		return fmt.Errorf("a pipeline ending with $merge or $out is required")
+	}
+	for i, stage := range v.Pipeline {
+		if len(stage) != 1 {
+			return fmt.Errorf("stage %d must have exactly one operator", i)
+		}
+		last := i == len(v.Pipeline)-1
+		switch operator := stage[0].Key; {
+		case (operator == "$merge" || operator == "$out") && !last:
+			return fmt.Errorf("stage %d: %s must be the last stage", i, operator)
+		case operator != "$merge" && operator != "$out" && last:
+			return fmt.Errorf("the last stage must be $merge or $out, not %s", operator)
+		}
+	}
+	database, collection := v.Target()
+	if len(collection) == 0 {
+		return fmt.Errorf("the last stage does not name its output collection")
+	}
+	if database == v.Database && collection == v.Source {
+		return fmt.Errorf("the output collection must not be the source collection")
+	}
+	return nil
+}
+
+// Target returns the database and the collection written by the last stage of the pipeline, which
+// is either the name of a collection of Database or a document with db and coll, or into for $merge.
+func (v *View) Target() (string, string) {
+	if len(v.Pipeline) == 0 || len(v.Pipeline[len(v.Pipeline)-1]) == 0 {
+		return v.Database, ""
+	}
+	spec := v.Pipeline[len(v.Pipeline)-1][0].Value
+	if spec, ok := spec.(bson.D); ok {
+		if into, ok := lookup(spec, "into"); ok {
+			if spec, ok = into.(bson.D); !ok {
+				collection, _ := into.(string)
+				return v.Database, collection
+			}
+		}
+		database, collection := v.Database, ""
+		if db, ok := lookup(spec, "db"); ok {
+			database, _ = db.(string)
+		}
+		if coll, ok := lookup(spec, "coll"); ok {
+			collection, _ = coll.(string)
+		}
+		return database, collection
+	}
+	collection, _ := spec.(string)
+	return v.Database, collection
+}
+
+func lookup(doc bson.D, key string) (interface{}, bool) {
+	for _, e := range doc {
+		if e.Key == key {
+			return e.Value, true
+		}
+	}
+	return nil, false
+}
+
+// Run refreshes the view. It has the signature of a job handler, the run is skipped while a refresh
+// requested through the API is in progress.
+func (v *View) Run(ctx context.Context, c *mongo.Client) error {
+	err := v.Refresh(ctx, c, Scheduled)
+	if errors.Is(err, ErrRefreshing) {
+		return fmt.Errorf("%w: %v", jobs.ErrSkipped, err)
+	}
+	return err
+}
+
+// Refresh runs the pipeline of the view, recording its outcome for trigger. It returns
+// ErrRefreshing without running it while another refresh is in progress.
+func (v *View) Refresh(ctx context.Context, c *mongo.Client, trigger string) error {
+	v.lock.Lock()
+	if v.refreshing {
+		v.lock.Unlock()
+		return ErrRefreshing
+	}
+	v.refreshing = true
+	v.lock.Unlock()
+
+	start := time.Now()
+	err := v.aggregate(ctx, c)
+	duration := time.Since(start)
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.refreshing = false
+	if err != nil {
+		failure := time.Now()
+		v.status.LastError, v.status.LastFailure = err.Error(), &failure
+		refreshesTotal.WithLabelValues(v.Name, trigger, "failure").Inc()
+		return err
+	}
+	v.status.LastRefresh, v.status.LastDuration = &start, duration.Round(time.Millisecond).String()
+	refreshesTotal.WithLabelValues(v.Name, trigger, "success").Inc()
+	refreshDurationSeconds.WithLabelValues(v.Name).Set(duration.Seconds())
+	lastRefreshTimestamp.WithLabelValues(v.Name).Set(float64(start.UnixNano()) / float64(time.Second))
+	database, collection := v.Target()
+	klog.Infof("Materialized view %s refreshed %s.%s from %s.%s in %d ms", v.Name, database, collection, v.Database, v.Source, duration.Milliseconds())
+	return nil
+}
+
+func (v *View) aggregate(ctx context.Context, c *mongo.Client) error {
+	cursor, err := c.Database(v.Database).Collection(v.Source).Aggregate(ctx, v.Pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return fmt.Errorf("unable to refresh the materialized view %s: %w", v.Name, err)
+	}
+	// $merge and $out return no documents, the aggregation is complete once it returned.
+	return cursor.Close(ctx)
+}
+
+// Status returns the outcome of the last refreshes of the view.
+func (v *View) Status() Status {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	status := v.status
+	status.Name, status.Database, status.Source, status.Refreshing = v.Name, v.Database, v.Source, v.refreshing
+	database, collection := v.Target()
+	status.Target = collection
+	if database != v.Database {
+		status.Target = database + "." + collection
+	}
+	return status
+}
+
+// Executor wraps every refresh requested through the HTTP API, like the runs of the jobs.
+type Executor func(ctx context.Context, fn func(ctx context.Context) error) error
+
+// Handler lists the materialized views with GET Prefix and refreshes one with
+// POST Prefix{name}/refresh, waiting for the refresh to complete.
+type Handler struct {
+	views   map[string]*View
+	client  func() *mongo.Client
+	execute Executor
+
+	// Connected reports whether the client is connected, the refreshes are unavailable while it
+	// returns false. The client is assumed to be connected when it is nil.
+	Connected func() bool
+}
+
+// NewHandler returns a handler of views refreshed with the client returned by client, through
+// execute when it is not nil. It expects to be mounted at Prefix.
+func NewHandler(views []*View, client func() *mongo.Client, execute Executor) *Handler {
+	if execute == nil {
+		execute = func(ctx context.Context, fn func(ctx context.Context) error) error {
+			return fn(ctx)
+		}
+	}
+	byName := make(map[string]*View, len(views))
+	for _, view := range views {
+		byName[view.Name] = view
+	}
+	return &Handler{views: byName, client: client, execute: execute}
+}
+
+// ParsePath returns the name of the view of a path below Prefix, empty for the list of views, and
+// whether it requests a refresh.
+func ParsePath(path string) (name string, refresh bool) {
+	rest := strings.Trim(strings.TrimPrefix(path, Prefix), "/")
+	if strings.HasSuffix(rest, "/refresh") {
+		return strings.TrimSuffix(rest, "/refresh"), true
+	}
+	return rest, false
+}
+
+// View returns the view called name, nil when there is none.
+func (h *Handler) View(name string) *View {
+	return h.views[name]
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name, refresh := ParsePath(r.URL.Path)
+	switch {
+	case len(name) == 0 && !refresh:
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+			return
+		}
+		names := make([]string, 0, len(h.views))
+		for name := range h.views {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		statuses := make([]Status, 0, len(names))
+		for _, name := range names {
+			statuses = append(statuses, h.views[name].Status())
+		}
+		writeJSON(w, http.StatusOK, statuses)
+		return
+	case h.views[name] == nil:
+		writeError(w, http.StatusNotFound, fmt.Errorf("no materialized view %q", name))
+		return
+	case !refresh:
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+			return
+		}
+		writeJSON(w, http.StatusOK, h.views[name].Status())
+		return
+	case r.Method != http.MethodPost:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed, use POST", r.Method))
+		return
+	}
+
+	if h.Connected != nil && !h.Connected() {
+		writeError(w, http.StatusServiceUnavailable, errors.New("not connected to database"))
+		return
+	}
+
+	view := h.views[name]
+	ctx := r.Context()
+	if view.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, view.Timeout)
+		defer cancel()
+	}
+	err := h.execute(ctx, func(ctx context.Context) error {
+		return view.Refresh(ctx, h.client(), OnDemand)
+	})
+	switch {
+	case errors.Is(err, ErrRefreshing):
+		writeError(w, http.StatusConflict, err)
+	case errors.Is(err, context.DeadlineExceeded) || mongo.IsTimeout(err):
+		writeError(w, http.StatusGatewayTimeout, err)
+	case err != nil:
+		klog.Errorf("%s %s failed: %v", r.Method, r.URL.Path, err)
+		writeError(w, http.StatusInternalServerError, err)
+	default:
+		writeJSON(w, http.StatusOK, view.Status())
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		klog.Errorf("Unable to write response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,263 @@
+// Package migrate applies versioned migrations to a database, in order, and records the applied
+// ones in a collection so that each of them runs once. Migrations are Go functions registered by
+// the application or scripts of database commands read from a directory.
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/client"
+	"github.com/bradmwilliams/mongodb-client/pkg/lock"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"k8s.io/klog"
+)
+
+// DefaultCollection is the collection in which the applied migrations are recorded.
+const DefaultCollection = "schema_migrations"
+
+// lockName is the lock held while migrations are applied or reverted.
+const lockName = "schema_migrations"
+
+// ErrIrreversible is returned when reverting a migration without a Down function.
+var ErrIrreversible = errors.New("the migration cannot be reverted")
+
+// Func changes the database of a migration.
+type Func func(ctx context.Context, db *mongo.Database) error
+
+// Migration changes a database from the previous version to Version.
+type Migration struct {
+	// Version orders the migrations, such as a date like 20210801120000.
+	Version int64
+	Name    string
+	Up      Func
+	// Down reverts Up, the migration is irreversible without it.
+	Down Func
+	// Source is where the migration was defined, such as the path of its script.
+	Source string
+}
+
+var (
+	registryLock sync.Mutex
+	registry     []Migration
+)
+
+// Register adds a migration defined in Go to those returned by Registered, typically from an init
+// function of the package that defines it.
+func Register(migration Migration) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	if len(migration.Source) == 0 {
+		migration.Source = "go"
+	}
+	registry = append(registry, migration)
+}
+
+// Registered returns the migrations added with Register.
+func Registered() []Migration {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	return append([]Migration(nil), registry...)
+}
+
+// Record is the document of an applied migration.
+type Record struct {
+	Version   int64     `bson:"_id" json:"version"`
+	Name      string    `bson:"name" json:"name"`
+	AppliedAt time.Time `bson:"appliedAt" json:"appliedAt"`
+	// Duration of the migration, in milliseconds.
+	Duration int64 `bson:"durationMillis" json:"durationMillis"`
+}
+
+// Status is the state of a known or applied migration.
+type Status struct {
+	Version int64  `json:"version"`
+	Name    string `json:"name"`
+	// Applied is nil for pending migrations.
+	Applied *Record `json:"applied,omitempty"`
+	// Known is false for applied migrations that are not defined anymore.
+	Known bool `json:"known"`
+}
+
+// Migrator applies migrations to a database.
+type Migrator struct {
+	Database *mongo.Database
+	// Collection of Database recording the applied migrations, DefaultCollection when empty.
+	Collection string
+	// Locker guards against migrations being applied concurrently, by several replicas of an
+	// application starting at once for instance.
+	Locker *lock.Locker
+
+	migrations []Migration
+}
+
+// New returns a migrator of db applying migrations, which it sorts by version.
+func New(db *mongo.Database, locker *lock.Locker, migrations []Migration) (*Migrator, error) {
+	sorted := append([]Migration(nil), migrations...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	for i, migration := range sorted {
+		if migration.Version <= 0 {
+			return nil, fmt.Errorf("migration %s of %s has no positive version", migration.Name, migration.Source)
+		}
+		if migration.Up == nil {
+			return nil, fmt.Errorf("migration %d of %s has no up step", migration.Version, migration.Source)
+		}
+		if i > 0 && sorted[i-1].Version == migration.Version {
+			return nil, fmt.Errorf("migration %d is defined by both %s and %s", migration.Version, sorted[i-1].Source, migration.Source)
+		}
+	}
+	return &Migrator{Database: db, Locker: locker, migrations: sorted}, nil
+}
+
+func (m *Migrator) collection() *mongo.Collection {
+	name := m.Collection
+	if len(name) == 0 {
+		name = DefaultCollection
+	}
+	return m.Database.Collection(name)
+}
+
+// applied returns the records of the applied migrations by version.
+func (m *Migrator) applied(ctx context.Context) (map[int64]*Record, error) {
+	cursor, err := m.collection().Find(ctx, bson.D{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the applied migrations: %w", err)
+	}
+	records := map[int64]*Record{}
+	err = client.Decode(ctx, cursor, func() interface{} { return &Record{} }, func(value interface{}) error {
+		record := value.(*Record)
+		records[record.Version] = record
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the applied migrations: %w", err)
+	}
+	return records, nil
+}
+
+// Status returns the state of the known and applied migrations, by version.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	records, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var statuses []Status
+	for _, migration := range m.migrations {
+		statuses = append(statuses, Status{Version: migration.Version, Name: migration.Name, Applied: records[migration.Version], Known: true})
+		delete(records, migration.Version)
+	}
+	for _, record := range records {
+		statuses = append(statuses, Status{Version: record.Version, Name: record.Name, Applied: record})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, nil
+}
+
+// Up applies the pending migrations up to and including version to, all of them when it is 0, in
+// order, and returns those it applied. It stops at the first one that fails. With dryRun the
+// pending migrations are returned without being applied.
+func (m *Migrator) Up(ctx context.Context, to int64, dryRun bool) ([]Migration, error) {
+	var done []Migration
+	err := m.locked(ctx, dryRun, func(ctx context.Context) error {
+		records, err := m.applied(ctx)
+		if err != nil {
+			return err
+		}
+		for _, migration := range m.migrations {
+			if to > 0 && migration.Version > to {
+				break
+			}
+			if records[migration.Version] != nil {
+				continue
+			}
+			if !dryRun {
+				if err := m.up(ctx, migration); err != nil {
+					return err
+				}
+			}
+			done = append(done, migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Down reverts the applied migrations later than version to, or the last steps applied ones when to
+// is 0, latest first, and returns those it reverted.
+func (m *Migrator) Down(ctx context.Context, to int64, steps int, dryRun bool) ([]Migration, error) {
+	var done []Migration
+	err := m.locked(ctx, dryRun, func(ctx context.Context) error {
+		records, err := m.applied(ctx)
+		if err != nil {
+			return err
+		}
+		known := map[int64]Migration{}
+		for _, migration := range m.migrations {
+			known[migration.Version] = migration
+		}
+		var versions []int64
+		for version := range records {
+			versions = append(versions, version)
+		}
+		sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+		for _, version := range versions {
+			if (to > 0 && version <= to) || (to <= 0 && len(done) >= steps) {
+				break
+			}
+			migration, ok := known[version]
+			if !ok {
+				return fmt.Errorf("migration %d %s is applied but not defined, it cannot be reverted", version, records[version].Name)
+			}
+			if migration.Down == nil {
+				return fmt.Errorf("migration %d %s: %w", version, migration.Name, ErrIrreversible)
+			}
+			if !dryRun {
+				if err := m.down(ctx, migration); err != nil {
+					return err
+				}
+			}
+			done = append(done, migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// locked runs fn holding the migrations lock, unless it only reads the state.
+func (m *Migrator) locked(ctx context.Context, readOnly bool, fn func(ctx context.Context) error) error {
+	if readOnly || m.Locker == nil {
+		return fn(ctx)
+	}
+	return m.Locker.Do(ctx, lockName, fn)
+}
+
+func (m *Migrator) up(ctx context.Context, migration Migration) error {
+	klog.Infof("Applying migration %d %s", migration.Version, migration.Name)
+	started := time.Now()
+	if err := migration.Up(ctx, m.Database); err != nil {
+		return fmt.Errorf("migration %d %s failed: %w", migration.Version, migration.Name, err)
+	}
+	record := Record{Version: migration.Version, Name: migration.Name, AppliedAt: time.Now().UTC(), Duration: time.Since(started).Milliseconds()}
+	_, err := m.collection().ReplaceOne(ctx, bson.D{{Key: "_id", Value: record.Version}}, record, options.Replace().SetUpsert(true))
+	if err != nil {
+		return fmt.Errorf("migration %d %s was applied but could not be recorded: %w", migration.Version, migration.Name, err)
+	}
+	return nil
+}
+
+func (m *Migrator) down(ctx context.Context, migration Migration) error {
+	klog.Infof("Reverting migration %d %s", migration.Version, migration.Name)
+	if err := migration.Down(ctx, m.Database); err != nil {
+		return fmt.Errorf("reverting migration %d %s failed: %w", migration.Version, migration.Name, err)
+	}
+	if _, err := m.collection().DeleteOne(ctx, bson.D{{Key: "_id", Value: migration.Version}}); err != nil {
+		return fmt.Errorf("migration %d %s was reverted but is still recorded: %w", migration.Version, migration.Name, err)
+	}
+	return nil
+}
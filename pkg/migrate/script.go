@@ -0,0 +1,208 @@
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"sigs.k8s.io/yaml"
+)
+
+// Script is a migration defined in a YAML or JSON file called <version>_<name>.yaml, such as
+// 20210801120000_add_episode_podcast_index.yaml.
+type Script struct {
+	Up   []Step `json:"up"`
+	Down []Step `json:"down,omitempty"`
+}
+
+// Step is a single operation of a script, exactly one of Command, Aggregate, UpdateMany or
+// DeleteMany is set. The documents are Extended JSON.
+type Step struct {
+	// Command is a database command, such as {"createIndexes": "episodes", "indexes": [...]}.
+	Command json.RawMessage `json:"command,omitempty"`
+	// Aggregate is a collection on which Pipeline is run, which typically ends with $merge or $out.
+	Aggregate string          `json:"aggregate,omitempty"`
+	Pipeline  json.RawMessage `json:"pipeline,omitempty"`
+	// UpdateMany is a collection of which the documents matching Filter are changed by Update, an
+	// update document or pipeline.
+	UpdateMany string          `json:"updateMany,omitempty"`
+	Update     json.RawMessage `json:"update,omitempty"`
+	// DeleteMany is a collection from which the documents matching Filter are deleted.
+	DeleteMany string          `json:"deleteMany,omitempty"`
+	Filter     json.RawMessage `json:"filter,omitempty"`
+}
+
+// LoadDir returns the migrations of the scripts of dir, files with a .yaml, .yml or .json
+// extension.
+func LoadDir(dir string) ([]Migration, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the migrations: %w", err)
+	}
+	var migrations []Migration
+	for _, entry := range entries {
+		switch filepath.Ext(entry.Name()) {
+		case ".yaml", ".yml", ".json":
+		default:
+			continue
+		}
+		if entry.IsDir() {
+			continue
+		}
+		migration, err := LoadScript(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, migration)
+	}
+	return migrations, nil
+}
+
+// LoadScript returns the migration of the script at path.
+func LoadScript(path string) (Migration, error) {
+	base := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	parts := strings.SplitN(base, "_", 2)
+	version, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || len(parts) < 2 || len(parts[1]) == 0 {
+		return Migration{}, fmt.Errorf("migration %s is not named <version>_<name>", path)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Migration{}, fmt.Errorf("unable to read migration: %w", err)
+	}
+	var script Script
+	if err := yaml.UnmarshalStrict(data, &script); err != nil {
+		return Migration{}, fmt.Errorf("unable to parse migration %s: %w", path, err)
+	}
+	if len(script.Up) == 0 {
+		return Migration{}, fmt.Errorf("migration %s has no up steps", path)
+	}
+	up, err := compile(script.Up)
+	if err != nil {
+		return Migration{}, fmt.Errorf("invalid up steps of %s: %w", path, err)
+	}
+	migration := Migration{Version: version, Name: parts[1], Up: up, Source: path}
+	if len(script.Down) > 0 {
+		if migration.Down, err = compile(script.Down); err != nil {
+			return Migration{}, fmt.Errorf("invalid down steps of %s: %w", path, err)
+		}
+	}
+	return migration, nil
+}
+
+// compile parses the documents of steps and returns a function running them in order.
+func compile(steps []Step) (Func, error) {
+	var funcs []Func
+	for i, step := range steps {
+		fn, err := step.compile()
+		if err != nil {
+			return nil, fmt.Errorf("step %d: %w", i+1, err)
+		}
+		funcs = append(funcs, fn)
+	}
+	return func(ctx context.Context, db *mongo.Database) error {
+		for i, fn := range funcs {
+			if err := fn(ctx, db); err != nil {
+				return fmt.Errorf("step %d: %w", i+1, err)
+			}
+		}
+		return nil
+	}, nil
+}
+
+func (s Step) compile() (Func, error) {
+	set := 0
+	for _, present := range []bool{len(s.Command) > 0, len(s.Aggregate) > 0, len(s.UpdateMany) > 0, len(s.DeleteMany) > 0} {
+		if present {
+			set++
+		}
+	}
+	if set != 1 {
+		return nil, fmt.Errorf("exactly one of command, aggregate, updateMany or deleteMany must be set")
+	}
+
+	switch {
+	case len(s.Command) > 0:
+		command, err := document("command", s.Command)
+		if err != nil {
+			return nil, err
+		}
+		return func(ctx context.Context, db *mongo.Database) error {
+			return db.RunCommand(ctx, command).Err()
+		}, nil
+	case len(s.Aggregate) > 0:
+		pipeline, err := pipeline("pipeline", s.Pipeline)
+		if err != nil {
+			return nil, err
+		}
+		return func(ctx context.Context, db *mongo.Database) error {
+			cursor, err := db.Collection(s.Aggregate).Aggregate(ctx, pipeline)
+			if err != nil {
+				return err
+			}
+			return cursor.Close(ctx)
+		}, nil
+	case len(s.UpdateMany) > 0:
+		filter, err := document("filter", s.Filter)
+		if err != nil {
+			return nil, err
+		}
+		var update interface{}
+		if trimmed := bytes.TrimSpace(s.Update); len(trimmed) > 0 && trimmed[0] == '[' {
+			update, err = pipeline("update", s.Update)
+		} else {
+			update, err = document("update", s.Update)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection(s.UpdateMany).UpdateMany(ctx, filter, update)
+			return err
+		}, nil
+	}
+	filter, err := document("filter", s.Filter)
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context, db *mongo.Database) error {
+		_, err := db.Collection(s.DeleteMany).DeleteMany(ctx, filter)
+		return err
+	}, nil
+}
+
+// document parses an Extended JSON document, an empty one when data is empty.
+func document(field string, data json.RawMessage) (bson.D, error) {
+	doc := bson.D{}
+	if len(data) == 0 {
+		return doc, nil
+	}
+	if err := bson.UnmarshalExtJSON(data, false, &doc); err != nil {
+		return nil, fmt.Errorf("%s is not an Extended JSON document: %w", field, err)
+	}
+	return doc, nil
+}
+
+// pipeline parses an array of Extended JSON stages.
+func pipeline(field string, data json.RawMessage) ([]bson.D, error) {
+	var stages []json.RawMessage
+	if err := json.Unmarshal(data, &stages); err != nil {
+		return nil, fmt.Errorf("%s must be an array of stages: %w", field, err)
+	}
+	result := make([]bson.D, 0, len(stages))
+	for i, stage := range stages {
+		doc, err := document(fmt.Sprintf("%s stage %d", field, i+1), stage)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, doc)
+	}
+	return result, nil
+}
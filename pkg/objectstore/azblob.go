@@ -0,0 +1,293 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const azureVersion = "2020-04-08"
+
+// azureBucket implements Bucket for a container of Azure Blob storage. The account is read from
+// AZURE_STORAGE_ACCOUNT, requests are authorized with the shared key in AZURE_STORAGE_KEY or the
+// SAS token in AZURE_STORAGE_SAS_TOKEN.
+type azureBucket struct {
+	account   string
+	container string
+	key       []byte
+	sas       url.Values
+	options   Options
+	client    *http.Client
+}
+
+func newAzureBucket(container string, options Options) (*azureBucket, error) {
+	a := &azureBucket{
+		account:   os.Getenv("AZURE_STORAGE_ACCOUNT"),
+		container: container,
+		options:   options,
+		client:    http.DefaultClient,
+	}
+	if len(a.account) == 0 {
+		return nil, errors.New("azblob: AZURE_STORAGE_ACCOUNT must be set")
+	}
+	if key := os.Getenv("AZURE_STORAGE_KEY"); len(key) > 0 {
+		decoded, err := base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			return nil, fmt.Errorf("azblob: AZURE_STORAGE_KEY is not base64 encoded: %w", err)
+		}
+		a.key = decoded
+	} else if token := os.Getenv("AZURE_STORAGE_SAS_TOKEN"); len(token) > 0 {
+		sas, err := url.ParseQuery(strings.TrimPrefix(token, "?"))
+		if err != nil {
+			return nil, fmt.Errorf("azblob: invalid AZURE_STORAGE_SAS_TOKEN: %w", err)
+		}
+		a.sas = sas
+	} else {
+		return nil, errors.New("azblob: one of AZURE_STORAGE_KEY or AZURE_STORAGE_SAS_TOKEN must be set")
+	}
+	return a, nil
+}
+
+// url returns the URL of the blob key, the container itself when key is empty.
+func (a *azureBucket) url(key string, query url.Values) *url.URL {
+	p := "/" + a.container
+	if len(key) > 0 {
+		p += "/" + key
+	}
+	values := url.Values{}
+	for name, value := range query {
+		values[name] = value
+	}
+	for name, value := range a.sas {
+		values[name] = value
+	}
+	return &url.URL{Scheme: "https", Host: a.account + ".blob.core.windows.net", Path: p, RawQuery: values.Encode()}
+}
+
+// do sends an authorized request, retrying transient failures.
+func (a *azureBucket) do(ctx context.Context, method string, u *url.URL, header http.Header, body []byte, expected ...int) (*http.Response, error) {
+	var resp *http.Response
+	err := retry(ctx, func() error {
+		req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		req.Header.Set("X-Ms-Date", time.Now().UTC().Format(http.TimeFormat))
+		req.Header.Set("X-Ms-Version", azureVersion)
+		if len(a.options.KMSKeyID) > 0 && method == http.MethodPut {
+			req.Header.Set("X-Ms-Encryption-Scope", a.options.KMSKeyID)
+		}
+		if a.key != nil {
+			a.sign(req, len(body))
+		}
+		resp, err = a.client.Do(req)
+		if err != nil {
+			return err
+		}
+		if err := checkResponse(resp, expected...); err != nil {
+			resp.Body.Close()
+			return err
+		}
+		return nil
+	})
+	return resp, err
+}
+
+// sign adds a Shared Key Authorization header to req.
+func (a *azureBucket) sign(req *http.Request, length int) {
+	contentLength := ""
+	if length > 0 {
+		contentLength = strconv.Itoa(length)
+	}
+	var headers []string
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-ms-") {
+			headers = append(headers, lower+":"+strings.TrimSpace(strings.Join(values, ",")))
+		}
+	}
+	sort.Strings(headers)
+
+	resource := "/" + a.account + req.URL.EscapedPath()
+	query := req.URL.Query()
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		values := append([]string(nil), query[name]...)
+		sort.Strings(values)
+		resource += "\n" + strings.ToLower(name) + ":" + strings.Join(values, ",")
+	}
+
+	stringToSign := strings.Join([]string{
+		req.Method,
+		req.Header.Get("Content-Encoding"),
+		req.Header.Get("Content-Language"),
+		contentLength,
+		req.Header.Get("Content-MD5"),
+		req.Header.Get("Content-Type"),
+		"", // Date, x-ms-date is used instead
+		req.Header.Get("If-Modified-Since"),
+		req.Header.Get("If-Match"),
+		req.Header.Get("If-None-Match"),
+		req.Header.Get("If-Unmodified-Since"),
+		req.Header.Get("Range"),
+		strings.Join(headers, "\n"),
+		resource,
+	}, "\n")
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte(stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("SharedKey %s:%s", a.account, base64.StdEncoding.EncodeToString(mac.Sum(nil))))
+}
+
+// azureWriter uploads the blob as a list of blocks that is committed on Close. Each block is
+// retried on its own, uncommitted blocks are discarded by the service after a week.
+type azureWriter struct {
+	ctx    context.Context
+	bucket *azureBucket
+	key    string
+	buffer []byte
+	blocks []string
+	done   bool
+}
+
+func (a *azureBucket) NewReader(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := a.do(ctx, http.MethodGet, a.url(key, nil), nil, nil, http.StatusOK)
+	if err != nil {
+		return nil, fmt.Errorf("azblob: unable to read %s: %w", key, err)
+	}
+	return resp.Body, nil
+}
+
+func (a *azureBucket) NewWriter(ctx context.Context, key string) (Writer, error) {
+	return &azureWriter{ctx: ctx, bucket: a, key: key, buffer: make([]byte, 0, a.options.PartSize)}, nil
+}
+
+func (w *azureWriter) Write(p []byte) (int, error) {
+	if w.done {
+		return 0, errors.New("azblob: write to closed object")
+	}
+	written := 0
+	for len(p) > 0 {
+		n := copy(w.buffer[len(w.buffer):cap(w.buffer)], p)
+		w.buffer = w.buffer[:len(w.buffer)+n]
+		p = p[n:]
+		written += n
+		if len(w.buffer) == cap(w.buffer) {
+			if err := w.upload(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (w *azureWriter) upload() error {
+	// Block ids must all have the same length.
+	id := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("block-%08d", len(w.blocks))))
+	u := w.bucket.url(w.key, url.Values{"comp": {"block"}, "blockid": {id}})
+	resp, err := w.bucket.do(w.ctx, http.MethodPut, u, nil, w.buffer, http.StatusCreated)
+	if err != nil {
+		return fmt.Errorf("azblob: unable to upload block %d of %s: %w", len(w.blocks), w.key, err)
+	}
+	resp.Body.Close()
+	w.blocks = append(w.blocks, id)
+	w.buffer = w.buffer[:0]
+	return nil
+}
+
+func (w *azureWriter) Close() error {
+	if w.done {
+		return nil
+	}
+	w.done = true
+	if len(w.buffer) > 0 {
+		if err := w.upload(); err != nil {
+			return err
+		}
+	}
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"BlockList"`
+		Latest  []string `xml:"Latest"`
+	}{Latest: w.blocks})
+	if err != nil {
+		return err
+	}
+	header := http.Header{"Content-Type": {"application/xml"}}
+	resp, err := w.bucket.do(w.ctx, http.MethodPut, w.bucket.url(w.key, url.Values{"comp": {"blocklist"}}), header, body, http.StatusCreated)
+	if err != nil {
+		return fmt.Errorf("azblob: unable to commit %s: %w", w.key, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (w *azureWriter) Abort() error {
+	w.done = true
+	return nil
+}
+
+func (a *azureBucket) List(ctx context.Context, prefix string) ([]Object, error) {
+	var objects []Object
+	marker := ""
+	for {
+		query := url.Values{"restype": {"container"}, "comp": {"list"}, "prefix": {prefix}}
+		if len(marker) > 0 {
+			query.Set("marker", marker)
+		}
+		resp, err := a.do(ctx, http.MethodGet, a.url("", query), nil, nil, http.StatusOK)
+		if err != nil {
+			return nil, fmt.Errorf("azblob: unable to list %s: %w", prefix, err)
+		}
+		var result struct {
+			Blobs []struct {
+				Name       string `xml:"Name"`
+				Properties struct {
+					LastModified  string `xml:"Last-Modified"`
+					ContentLength int64  `xml:"Content-Length"`
+				} `xml:"Properties"`
+			} `xml:"Blobs>Blob"`
+			NextMarker string `xml:"NextMarker"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("azblob: invalid list response: %w", err)
+		}
+		for _, blob := range result.Blobs {
+			modified, _ := time.Parse(time.RFC1123, blob.Properties.LastModified)
+			objects = append(objects, Object{Key: blob.Name, Size: blob.Properties.ContentLength, Modified: modified})
+		}
+		if len(result.NextMarker) == 0 {
+			return objects, nil
+		}
+		marker = result.NextMarker
+	}
+}
+
+func (a *azureBucket) Delete(ctx context.Context, key string) error {
+	resp, err := a.do(ctx, http.MethodDelete, a.url(key, nil), nil, nil, http.StatusAccepted)
+	if err != nil {
+		return fmt.Errorf("azblob: unable to delete %s: %w", key, err)
+	}
+	resp.Body.Close()
+	return nil
+}
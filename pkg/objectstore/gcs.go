@@ -0,0 +1,431 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	gcsStorageURL = "https://storage.googleapis.com"
+	gcsScope      = "https://www.googleapis.com/auth/devstorage.read_write"
+	gcsMetadata   = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+	// gcsChunk is the granularity of resumable upload chunks.
+	gcsChunk = 256 << 10
+)
+
+// gcsBucket implements Bucket for Google Cloud Storage. It authenticates with the service account
+// key in GOOGLE_APPLICATION_CREDENTIALS, or with the metadata server when running on Google Cloud.
+type gcsBucket struct {
+	bucket  string
+	options Options
+	client  *http.Client
+	tokens  *gcsTokenSource
+}
+
+func newGCSBucket(bucket string, options Options) (*gcsBucket, error) {
+	tokens, err := newGCSTokenSource()
+	if err != nil {
+		return nil, err
+	}
+	// Chunks other than the last one must be multiples of 256KiB.
+	options.PartSize = (options.PartSize + gcsChunk - 1) / gcsChunk * gcsChunk
+	return &gcsBucket{bucket: bucket, options: options, client: http.DefaultClient, tokens: tokens}, nil
+}
+
+// gcsTokenSource returns OAuth2 access tokens, caching them until shortly before they expire.
+type gcsTokenSource struct {
+	lock    sync.Mutex
+	account *gcsServiceAccount
+	token   string
+	expiry  time.Time
+}
+
+type gcsServiceAccount struct {
+	Type        string `json:"type"`
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+	key         *rsa.PrivateKey
+}
+
+func newGCSTokenSource() (*gcsTokenSource, error) {
+	path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	if len(path) == 0 {
+		return &gcsTokenSource{}, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("gcs: unable to read credentials: %w", err)
+	}
+	account := &gcsServiceAccount{}
+	if err := json.Unmarshal(data, account); err != nil {
+		return nil, fmt.Errorf("gcs: invalid credentials file %s: %w", path, err)
+	}
+	if account.Type != "service_account" {
+		return nil, fmt.Errorf("gcs: credentials of type %q are not supported, use a service account key", account.Type)
+	}
+	block, _ := pem.Decode([]byte(account.PrivateKey))
+	if block == nil {
+		return nil, errors.New("gcs: the service account key has no PEM encoded private key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("gcs: invalid service account private key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("gcs: the service account private key is not an RSA key")
+	}
+	account.key = key
+	if len(account.TokenURI) == 0 {
+		account.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+	return &gcsTokenSource{account: account}, nil
+}
+
+func (t *gcsTokenSource) Token(ctx context.Context) (string, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if len(t.token) > 0 && time.Now().Add(time.Minute).Before(t.expiry) {
+		return t.token, nil
+	}
+
+	var req *http.Request
+	var err error
+	if t.account == nil {
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, gcsMetadata, nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Metadata-Flavor", "Google")
+	} else {
+		assertion, err := t.account.assertion(time.Now())
+		if err != nil {
+			return "", err
+		}
+		form := url.Values{"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"}, "assertion": {assertion}}
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, t.account.TokenURI, strings.NewReader(form.Encode()))
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("gcs: unable to obtain an access token: %w", err)
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp, http.StatusOK); err != nil {
+		return "", fmt.Errorf("gcs: unable to obtain an access token: %w", err)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("gcs: invalid token response: %w", err)
+	}
+	t.token = token.AccessToken
+	t.expiry = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	return t.token, nil
+}
+
+// assertion returns the signed JWT exchanged for an access token.
+func (a *gcsServiceAccount) assertion(now time.Time) (string, error) {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   a.ClientEmail,
+		"scope": gcsScope,
+		"aud":   a.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	hashed := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(nil, a.key, crypto.SHA256, hashed[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// do sends an authenticated request, retrying transient failures.
+func (g *gcsBucket) do(ctx context.Context, method, u string, header http.Header, body []byte, expected ...int) (*http.Response, error) {
+	var resp *http.Response
+	err := retry(ctx, func() error {
+		token, err := g.tokens.Token(ctx)
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err = g.client.Do(req)
+		if err != nil {
+			return err
+		}
+		if err := checkResponse(resp, expected...); err != nil {
+			resp.Body.Close()
+			return err
+		}
+		return nil
+	})
+	return resp, err
+}
+
+// gcsWriter writes an object with a resumable upload. A chunk that fails is resumed from the
+// offset the service has persisted instead of restarting the upload.
+type gcsWriter struct {
+	ctx     context.Context
+	bucket  *gcsBucket
+	key     string
+	session string
+	offset  int64
+	buffer  []byte
+	done    bool
+}
+
+func (g *gcsBucket) NewReader(ctx context.Context, key string) (io.ReadCloser, error) {
+	u := fmt.Sprintf("%s/storage/v1/b/%s/o/%s?alt=media", gcsStorageURL, url.PathEscape(g.bucket), url.PathEscape(key))
+	resp, err := g.do(ctx, http.MethodGet, u, nil, nil, http.StatusOK)
+	if err != nil {
+		return nil, fmt.Errorf("gcs: unable to read %s: %w", key, err)
+	}
+	return resp.Body, nil
+}
+
+func (g *gcsBucket) NewWriter(ctx context.Context, key string) (Writer, error) {
+	query := url.Values{"uploadType": {"resumable"}, "name": {key}}
+	if len(g.options.KMSKeyID) > 0 {
+		query.Set("kmsKeyName", g.options.KMSKeyID)
+	}
+	u := fmt.Sprintf("%s/upload/storage/v1/b/%s/o?%s", gcsStorageURL, url.PathEscape(g.bucket), query.Encode())
+	header := http.Header{"Content-Type": {"application/json; charset=UTF-8"}}
+	resp, err := g.do(ctx, http.MethodPost, u, header, []byte("{}"), http.StatusOK)
+	if err != nil {
+		return nil, fmt.Errorf("gcs: unable to start upload of %s: %w", key, err)
+	}
+	resp.Body.Close()
+	session := resp.Header.Get("Location")
+	if len(session) == 0 {
+		return nil, fmt.Errorf("gcs: no upload session returned for %s", key)
+	}
+	return &gcsWriter{ctx: ctx, bucket: g, key: key, session: session, buffer: make([]byte, 0, g.options.PartSize)}, nil
+}
+
+func (w *gcsWriter) Write(p []byte) (int, error) {
+	if w.done {
+		return 0, errors.New("gcs: write to closed object")
+	}
+	written := 0
+	for len(p) > 0 {
+		n := copy(w.buffer[len(w.buffer):cap(w.buffer)], p)
+		w.buffer = w.buffer[:len(w.buffer)+n]
+		p = p[n:]
+		written += n
+		if len(w.buffer) == cap(w.buffer) {
+			if err := w.upload(false); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// upload sends the buffered chunk. Until the upload completes the service answers 308 with the
+// range it has persisted so far, which may be less than what was sent.
+func (w *gcsWriter) upload(last bool) error {
+	for attempt := 1; ; attempt++ {
+		err := w.send(last)
+		if err == nil || attempt == 5 || !retryable(err) {
+			return err
+		}
+		if err := w.query(); err != nil {
+			return err
+		}
+		select {
+		case <-w.ctx.Done():
+			return w.ctx.Err()
+		case <-time.After(time.Duration(attempt) * time.Second):
+		}
+	}
+}
+
+func (w *gcsWriter) send(last bool) error {
+	for {
+		total := "*"
+		if last {
+			total = strconv.FormatInt(w.offset+int64(len(w.buffer)), 10)
+		}
+		contentRange := fmt.Sprintf("bytes %d-%d/%s", w.offset, w.offset+int64(len(w.buffer))-1, total)
+		if len(w.buffer) == 0 {
+			if !last {
+				return nil
+			}
+			contentRange = "bytes */" + total
+		}
+		req, err := http.NewRequestWithContext(w.ctx, http.MethodPut, w.session, bytes.NewReader(w.buffer))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Range", contentRange)
+		resp, err := w.bucket.client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		switch resp.StatusCode {
+		case http.StatusOK, http.StatusCreated:
+			w.offset += int64(len(w.buffer))
+			w.buffer = w.buffer[:0]
+			return nil
+		case http.StatusPermanentRedirect:
+			pending := len(w.buffer)
+			w.persisted(resp.Header.Get("Range"))
+			if len(w.buffer) == 0 && !last {
+				return nil
+			}
+			if len(w.buffer) == pending {
+				return errors.New("the service did not persist any of the chunk")
+			}
+			continue
+		}
+		return checkResponse(resp, http.StatusOK)
+	}
+}
+
+// query asks the service how much of the upload it has persisted.
+func (w *gcsWriter) query() error {
+	req, err := http.NewRequestWithContext(w.ctx, http.MethodPut, w.session, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Range", "bytes */*")
+	resp, err := w.bucket.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusPermanentRedirect {
+		w.persisted(resp.Header.Get("Range"))
+		return nil
+	}
+	return checkResponse(resp, http.StatusOK, http.StatusCreated)
+}
+
+// persisted drops the part of the buffer covered by a Range header such as "bytes=0-1048575".
+func (w *gcsWriter) persisted(header string) {
+	end := w.offset - 1
+	if index := strings.LastIndex(header, "-"); index >= 0 {
+		if value, err := strconv.ParseInt(header[index+1:], 10, 64); err == nil {
+			end = value
+		}
+	}
+	if consumed := end + 1 - w.offset; consumed > 0 {
+		w.buffer = w.buffer[:copy(w.buffer, w.buffer[consumed:])]
+		w.offset = end + 1
+	}
+}
+
+func (w *gcsWriter) Close() error {
+	if w.done {
+		return nil
+	}
+	w.done = true
+	if err := w.upload(true); err != nil {
+		w.abort()
+		return fmt.Errorf("gcs: unable to complete upload of %s: %w", w.key, err)
+	}
+	return nil
+}
+
+func (w *gcsWriter) Abort() error {
+	if w.done {
+		return nil
+	}
+	w.done = true
+	return w.abort()
+}
+
+func (w *gcsWriter) abort() error {
+	req, err := http.NewRequest(http.MethodDelete, w.session, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := w.bucket.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (g *gcsBucket) List(ctx context.Context, prefix string) ([]Object, error) {
+	var objects []Object
+	token := ""
+	for {
+		query := url.Values{"prefix": {prefix}, "fields": {"items(name,size,updated),nextPageToken"}}
+		if len(token) > 0 {
+			query.Set("pageToken", token)
+		}
+		u := fmt.Sprintf("%s/storage/v1/b/%s/o?%s", gcsStorageURL, url.PathEscape(g.bucket), query.Encode())
+		resp, err := g.do(ctx, http.MethodGet, u, nil, nil, http.StatusOK)
+		if err != nil {
+			return nil, fmt.Errorf("gcs: unable to list %s: %w", prefix, err)
+		}
+		var result struct {
+			Items []struct {
+				Name    string    `json:"name"`
+				Size    string    `json:"size"`
+				Updated time.Time `json:"updated"`
+			} `json:"items"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("gcs: invalid list response: %w", err)
+		}
+		for _, item := range result.Items {
+			size, _ := strconv.ParseInt(item.Size, 10, 64)
+			objects = append(objects, Object{Key: item.Name, Size: size, Modified: item.Updated})
+		}
+		if len(result.NextPageToken) == 0 {
+			return objects, nil
+		}
+		token = result.NextPageToken
+	}
+}
+
+func (g *gcsBucket) Delete(ctx context.Context, key string) error {
+	u := fmt.Sprintf("%s/storage/v1/b/%s/o/%s", gcsStorageURL, url.PathEscape(g.bucket), url.PathEscape(key))
+	resp, err := g.do(ctx, http.MethodDelete, u, nil, nil, http.StatusNoContent, http.StatusOK)
+	if err != nil {
+		return fmt.Errorf("gcs: unable to delete %s: %w", key, err)
+	}
+	resp.Body.Close()
+	return nil
+}
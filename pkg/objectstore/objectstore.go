@@ -0,0 +1,234 @@
+// Package objectstore writes, lists and deletes objects in local directories and in S3, Google
+// Cloud Storage and Azure Blob storage, using the REST APIs of each service directly.
+package objectstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// DefaultPartSize is the amount of data buffered and uploaded at a time.
+const DefaultPartSize = 16 << 20
+
+// Options configures how objects are written.
+type Options struct {
+	// ServerSideEncryption is the S3 server-side encryption algorithm, AES256 or aws:kms.
+	ServerSideEncryption string
+	// KMSKeyID encrypts new objects with a customer managed key: the KMS key id for S3
+	// (with aws:kms), the Cloud KMS key name for GCS and the encryption scope for Azure.
+	KMSKeyID string
+	// PartSize is the size of the parts uploaded at a time, DefaultPartSize when zero.
+	PartSize int
+}
+
+// Object describes a stored object.
+type Object struct {
+	Key      string
+	Size     int64
+	Modified time.Time
+}
+
+// Writer writes an object. The object only becomes visible once Close succeeds, Abort discards
+// everything written so far. Abort after Close does nothing.
+type Writer interface {
+	io.Writer
+	Close() error
+	Abort() error
+}
+
+// Bucket holds objects addressed by slash separated keys.
+type Bucket interface {
+	NewReader(ctx context.Context, key string) (io.ReadCloser, error)
+	NewWriter(ctx context.Context, key string) (Writer, error)
+	// List returns the objects whose keys start with prefix.
+	List(ctx context.Context, prefix string) ([]Object, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// Open returns the bucket of location and the key of location within it. Supported locations are
+// s3://bucket/key, gs://bucket/key, azblob://container/key, file:///path and plain paths.
+func Open(location string, options Options) (Bucket, string, error) {
+	if options.PartSize <= 0 {
+		options.PartSize = DefaultPartSize
+	}
+	if !strings.Contains(location, "://") {
+		return fileBucket{}, location, nil
+	}
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid location %q: %w", location, err)
+	}
+	key := strings.TrimPrefix(u.Path, "/")
+	switch u.Scheme {
+	case "file":
+		return fileBucket{}, u.Path, nil
+	case "s3":
+		bucket, err := newS3Bucket(u.Host, u.Query(), options)
+		return bucket, key, err
+	case "gs":
+		bucket, err := newGCSBucket(u.Host, options)
+		return bucket, key, err
+	case "azblob":
+		bucket, err := newAzureBucket(u.Host, options)
+		return bucket, key, err
+	}
+	return nil, "", fmt.Errorf("unsupported location %q, must be a path or an s3://, gs:// or azblob:// URL", location)
+}
+
+// IsLocal reports whether location is a path on the local filesystem.
+func IsLocal(location string) bool {
+	return !strings.Contains(location, "://") || strings.HasPrefix(location, "file://")
+}
+
+// fileBucket stores objects as files, keys are paths.
+type fileBucket struct{}
+
+type fileWriter struct {
+	file *os.File
+	path string
+	done bool
+}
+
+func (fileBucket) NewReader(ctx context.Context, key string) (io.ReadCloser, error) {
+	return os.Open(filepath.FromSlash(key))
+}
+
+// NewWriter writes to a temporary file in the target directory which is renamed on Close.
+func (fileBucket) NewWriter(ctx context.Context, key string) (Writer, error) {
+	path := filepath.FromSlash(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	file, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return nil, err
+	}
+	return &fileWriter{file: file, path: path}, nil
+}
+
+func (f *fileWriter) Write(p []byte) (int, error) {
+	return f.file.Write(p)
+}
+
+func (f *fileWriter) Close() error {
+	if f.done {
+		return nil
+	}
+	if err := f.file.Sync(); err != nil {
+		f.Abort()
+		return err
+	}
+	if err := f.file.Close(); err != nil {
+		f.Abort()
+		return err
+	}
+	f.done = true
+	if err := os.Rename(f.file.Name(), f.path); err != nil {
+		os.Remove(f.file.Name())
+		return err
+	}
+	return nil
+}
+
+func (f *fileWriter) Abort() error {
+	if f.done {
+		return nil
+	}
+	f.done = true
+	f.file.Close()
+	return os.Remove(f.file.Name())
+}
+
+func (fileBucket) List(ctx context.Context, prefix string) ([]Object, error) {
+	prefix = filepath.FromSlash(prefix)
+	directory := filepath.Dir(prefix)
+	if strings.HasSuffix(prefix, string(filepath.Separator)) {
+		directory = prefix
+	}
+	entries, err := ioutil.ReadDir(directory)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var objects []Object
+	for _, entry := range entries {
+		path := filepath.Join(directory, entry.Name())
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") || !strings.HasPrefix(path, filepath.Clean(prefix)) {
+			continue
+		}
+		objects = append(objects, Object{Key: filepath.ToSlash(path), Size: entry.Size(), Modified: entry.ModTime()})
+	}
+	return objects, nil
+}
+
+func (fileBucket) Delete(ctx context.Context, key string) error {
+	return os.Remove(filepath.FromSlash(key))
+}
+
+// statusError is returned for unexpected HTTP responses.
+type statusError struct {
+	method string
+	url    string
+	code   int
+	body   string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%s %s: unexpected status %d: %s", e.method, e.url, e.code, strings.TrimSpace(e.body))
+}
+
+// checkResponse returns a statusError unless the response has one of the expected status codes.
+func checkResponse(resp *http.Response, expected ...int) error {
+	for _, code := range expected {
+		if resp.StatusCode == code {
+			return nil
+		}
+	}
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	u := *resp.Request.URL
+	// Presigned parameters must not end up in logs.
+	u.RawQuery = ""
+	return &statusError{method: resp.Request.Method, url: u.String(), code: resp.StatusCode, body: string(body)}
+}
+
+// retryable reports whether a failed request may succeed when sent again.
+func retryable(err error) bool {
+	var status *statusError
+	if errors.As(err, &status) {
+		return status.code == http.StatusTooManyRequests || status.code >= 500
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// retry calls fn until it succeeds, fails with a permanent error or five attempts have been made.
+func retry(ctx context.Context, fn func() error) error {
+	delay := time.Second
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt == 5 || !retryable(err) {
+			return err
+		}
+		klog.V(4).Infof("Retrying failed request in %s: %v", delay, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
@@ -0,0 +1,406 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// s3Bucket implements Bucket for Amazon S3 and compatible services. Credentials are read from
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN, the region from the region
+// query parameter, AWS_REGION or AWS_DEFAULT_REGION. The endpoint query parameter or
+// AWS_ENDPOINT_URL select an S3 compatible service, which is then addressed in path style.
+type s3Bucket struct {
+	bucket       string
+	region       string
+	endpoint     *url.URL
+	pathStyle    bool
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	options      Options
+	client       *http.Client
+}
+
+func newS3Bucket(bucket string, query url.Values, options Options) (*s3Bucket, error) {
+	s := &s3Bucket{
+		bucket:       bucket,
+		region:       firstNonEmpty(query.Get("region"), os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"), "us-east-1"),
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		options:      options,
+		client:       http.DefaultClient,
+	}
+	if len(s.accessKey) == 0 || len(s.secretKey) == 0 {
+		return nil, errors.New("s3: AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set")
+	}
+	if options.PartSize < 5<<20 {
+		return nil, errors.New("s3: the part size must be at least 5MiB")
+	}
+	switch options.ServerSideEncryption {
+	case "", "AES256", "aws:kms":
+	default:
+		return nil, fmt.Errorf("s3: unsupported server-side encryption %q, must be AES256 or aws:kms", options.ServerSideEncryption)
+	}
+
+	endpoint := firstNonEmpty(query.Get("endpoint"), os.Getenv("AWS_ENDPOINT_URL"))
+	if len(endpoint) > 0 {
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("s3: invalid endpoint %q: %w", endpoint, err)
+		}
+		s.endpoint = u
+		s.pathStyle = true
+	} else {
+		s.endpoint = &url.URL{Scheme: "https", Host: fmt.Sprintf("%s.s3.%s.amazonaws.com", bucket, s.region)}
+	}
+	return s, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if len(value) > 0 {
+			return value
+		}
+	}
+	return ""
+}
+
+// url returns the URL of key, the bucket itself when key is empty.
+func (s *s3Bucket) url(key string, query url.Values) *url.URL {
+	u := *s.endpoint
+	p := "/" + key
+	if s.pathStyle {
+		p = "/" + s.bucket + p
+	}
+	u.Path = p
+	u.RawPath = s3Escape(p)
+	u.RawQuery = s3Query(query)
+	return &u
+}
+
+// do signs and sends a request, retrying transient failures. The response body must be closed
+// by the caller unless an error is returned.
+func (s *s3Bucket) do(ctx context.Context, method string, u *url.URL, header http.Header, body []byte, expected ...int) (*http.Response, error) {
+	var resp *http.Response
+	err := retry(ctx, func() error {
+		req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		s.sign(req, body, time.Now().UTC())
+		resp, err = s.client.Do(req)
+		if err != nil {
+			return err
+		}
+		if err := checkResponse(resp, expected...); err != nil {
+			resp.Body.Close()
+			return err
+		}
+		return nil
+	})
+	return resp, err
+}
+
+// sign adds an AWS Signature Version 4 Authorization header to req.
+func (s *s3Bucket) sign(req *http.Request, body []byte, now time.Time) {
+	payload := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(payload[:])
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if len(s.sessionToken) > 0 {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") || lower == "content-type" || lower == "content-md5" {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	hashed := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + now.Format("20060102T150405Z") + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Escape percent-encodes every byte of p except unreserved characters and slashes.
+func s3Escape(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || strings.IndexByte("-._~/", c) >= 0 {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+// s3Query encodes query sorted by key as required for signing.
+func s3Query(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, key := range keys {
+		for _, value := range query[key] {
+			parts = append(parts, strings.ReplaceAll(s3Escape(key), "/", "%2F")+"="+strings.ReplaceAll(s3Escape(value), "/", "%2F"))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func (s *s3Bucket) encryptionHeader() http.Header {
+	header := http.Header{}
+	if len(s.options.ServerSideEncryption) > 0 {
+		header.Set("X-Amz-Server-Side-Encryption", s.options.ServerSideEncryption)
+	}
+	if len(s.options.KMSKeyID) > 0 {
+		header.Set("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id", s.options.KMSKeyID)
+	}
+	return header
+}
+
+// s3Writer buffers one part at a time. Objects smaller than a part are written with a single
+// request, larger ones with a multipart upload whose parts are retried individually.
+type s3Writer struct {
+	ctx      context.Context
+	bucket   *s3Bucket
+	key      string
+	buffer   []byte
+	uploadID string
+	parts    []s3Part
+	done     bool
+}
+
+type s3Part struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+func (s *s3Bucket) NewReader(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, s.url(key, nil), nil, nil, http.StatusOK)
+	if err != nil {
+		return nil, fmt.Errorf("s3: unable to read %s: %w", key, err)
+	}
+	return resp.Body, nil
+}
+
+func (s *s3Bucket) NewWriter(ctx context.Context, key string) (Writer, error) {
+	return &s3Writer{ctx: ctx, bucket: s, key: key, buffer: make([]byte, 0, s.options.PartSize)}, nil
+}
+
+func (w *s3Writer) Write(p []byte) (int, error) {
+	if w.done {
+		return 0, errors.New("s3: write to closed object")
+	}
+	written := 0
+	for len(p) > 0 {
+		n := copy(w.buffer[len(w.buffer):cap(w.buffer)], p)
+		w.buffer = w.buffer[:len(w.buffer)+n]
+		p = p[n:]
+		written += n
+		if len(w.buffer) == cap(w.buffer) {
+			if err := w.upload(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// upload sends the buffered data as the next part, starting the multipart upload if needed.
+func (w *s3Writer) upload() error {
+	if len(w.uploadID) == 0 {
+		resp, err := w.bucket.do(w.ctx, http.MethodPost, w.bucket.url(w.key, url.Values{"uploads": {""}}), w.bucket.encryptionHeader(), nil, http.StatusOK)
+		if err != nil {
+			return fmt.Errorf("s3: unable to start upload of %s: %w", w.key, err)
+		}
+		defer resp.Body.Close()
+		var result struct {
+			UploadID string `xml:"UploadId"`
+		}
+		if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+			return fmt.Errorf("s3: invalid response starting upload of %s: %w", w.key, err)
+		}
+		w.uploadID = result.UploadID
+	}
+
+	number := len(w.parts) + 1
+	query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {w.uploadID}}
+	resp, err := w.bucket.do(w.ctx, http.MethodPut, w.bucket.url(w.key, query), nil, w.buffer, http.StatusOK)
+	if err != nil {
+		return fmt.Errorf("s3: unable to upload part %d of %s: %w", number, w.key, err)
+	}
+	resp.Body.Close()
+	w.parts = append(w.parts, s3Part{PartNumber: number, ETag: resp.Header.Get("ETag")})
+	w.buffer = w.buffer[:0]
+	return nil
+}
+
+func (w *s3Writer) Close() error {
+	if w.done {
+		return nil
+	}
+	if len(w.uploadID) == 0 {
+		w.done = true
+		resp, err := w.bucket.do(w.ctx, http.MethodPut, w.bucket.url(w.key, nil), w.bucket.encryptionHeader(), w.buffer, http.StatusOK)
+		if err != nil {
+			return fmt.Errorf("s3: unable to write %s: %w", w.key, err)
+		}
+		resp.Body.Close()
+		return nil
+	}
+
+	if len(w.buffer) > 0 {
+		if err := w.upload(); err != nil {
+			w.Abort()
+			return err
+		}
+	}
+	w.done = true
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []s3Part `xml:"Part"`
+	}{Parts: w.parts})
+	if err != nil {
+		return err
+	}
+	resp, err := w.bucket.do(w.ctx, http.MethodPost, w.bucket.url(w.key, url.Values{"uploadId": {w.uploadID}}), nil, body, http.StatusOK)
+	if err != nil {
+		w.abort()
+		return fmt.Errorf("s3: unable to complete upload of %s: %w", w.key, err)
+	}
+	defer resp.Body.Close()
+	// Completion may fail after the status has been sent, the error is then in the body.
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if bytes.Contains(data, []byte("<Error>")) {
+		w.abort()
+		return fmt.Errorf("s3: unable to complete upload of %s: %s", w.key, data)
+	}
+	return nil
+}
+
+func (w *s3Writer) Abort() error {
+	if w.done {
+		return nil
+	}
+	w.done = true
+	return w.abort()
+}
+
+// abort discards the uploaded parts, which S3 would otherwise keep and bill for.
+func (w *s3Writer) abort() error {
+	if len(w.uploadID) == 0 {
+		return nil
+	}
+	resp, err := w.bucket.do(context.Background(), http.MethodDelete, w.bucket.url(w.key, url.Values{"uploadId": {w.uploadID}}), nil, nil, http.StatusNoContent, http.StatusNotFound)
+	if err != nil {
+		return fmt.Errorf("s3: unable to abort upload of %s: %w", w.key, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *s3Bucket) List(ctx context.Context, prefix string) ([]Object, error) {
+	var objects []Object
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if len(token) > 0 {
+			query.Set("continuation-token", token)
+		}
+		resp, err := s.do(ctx, http.MethodGet, s.url("", query), nil, nil, http.StatusOK)
+		if err != nil {
+			return nil, fmt.Errorf("s3: unable to list %s: %w", prefix, err)
+		}
+		var result struct {
+			Contents []struct {
+				Key          string    `xml:"Key"`
+				Size         int64     `xml:"Size"`
+				LastModified time.Time `xml:"LastModified"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("s3: invalid list response: %w", err)
+		}
+		for _, content := range result.Contents {
+			objects = append(objects, Object{Key: content.Key, Size: content.Size, Modified: content.LastModified})
+		}
+		if !result.IsTruncated {
+			return objects, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (s *s3Bucket) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, s.url(key, nil), nil, nil, http.StatusNoContent, http.StatusOK)
+	if err != nil {
+		return fmt.Errorf("s3: unable to delete %s: %w", key, err)
+	}
+	resp.Body.Close()
+	return nil
+}
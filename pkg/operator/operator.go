@@ -0,0 +1,439 @@
+// Package operator reconciles the MongoDBDatabase, MongoDBUser and MongoDBIndex custom resources of
+// a Kubernetes cluster with a deployment, as the provision command reconciles a manifest, and
+// writes the outcome back to their status as a Ready condition.
+package operator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/kube"
+	"github.com/bradmwilliams/mongodb-client/pkg/provision"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+)
+
+// resource is a custom resource reconciled by the operator.
+type resource interface {
+	object() *Object
+	// reconcile makes the deployment match the resource.
+	reconcile(ctx context.Context, o *Operator) error
+}
+
+func (o *Object) object() *Object {
+	return o
+}
+
+// kind is a kind of custom resource.
+type kind struct {
+	Kind   string
+	Plural string
+	new    func() resource
+}
+
+// kinds are reconciled in order when they are listed, so that the roles of the databases exist
+// before the users are granted them.
+var kinds = []kind{
+	{Kind: "MongoDBDatabase", Plural: "mongodbdatabases", new: func() resource { return &MongoDBDatabase{} }},
+	{Kind: "MongoDBUser", Plural: "mongodbusers", new: func() resource { return &MongoDBUser{} }},
+	{Kind: "MongoDBIndex", Plural: "mongodbindexes", new: func() resource { return &MongoDBIndex{} }},
+}
+
+// Operator reconciles the custom resources of a namespace, or of every namespace, with the
+// deployment of an admin client. The databases, users and indexes of deleted resources are left
+// alone.
+type Operator struct {
+	kube   *kube.Client
+	client *mongo.Client
+	// Namespace is the namespace of the resources, every namespace when it is empty.
+	Namespace string
+	// Resync is the interval after which every resource is reconciled again, undoing the changes
+	// made to the deployment since.
+	Resync time.Duration
+	// Timeout bounds the reconciliation of a resource.
+	Timeout time.Duration
+	// Grants are what the resources of each namespace may declare, keyed by namespace. The
+	// resources of the namespaces without grants are refused.
+	Grants map[string]Grants
+
+	// lock serializes the reconciliations.
+	lock sync.Mutex
+}
+
+// Grants restrict the databases and roles of the resources of a namespace, whose users could
+// otherwise be granted any role of the deployment.
+type Grants struct {
+	// Databases are the names, or patterns such as app_*, of the databases the resources may
+	// declare, grant roles of and be granted privileges on.
+	Databases []string
+	// Roles are the built-in or user-defined roles the users may be granted and the roles may
+	// inherit.
+	Roles []string
+}
+
+// checkDatabase returns why the resources of the grants may not declare the database name.
+func (g Grants) checkDatabase(name string) error {
+	for _, pattern := range g.Databases {
+		matched, err := path.Match(pattern, name)
+		if err != nil {
+			return fmt.Errorf("invalid database pattern %q: %w", pattern, err)
+		}
+		if matched {
+			return nil
+		}
+	}
+	return fmt.Errorf("database %s is not granted to the namespace", name)
+}
+
+// checkRoles returns why the resources of the grants may not be granted roles, those without a
+// database being roles of database.
+func (g Grants) checkRoles(database string, roles []provision.RoleGrant) error {
+	for _, role := range roles {
+		db := role.Database
+		if len(db) == 0 {
+			db = database
+		}
+		if err := g.checkDatabase(db); err != nil {
+			return fmt.Errorf("role %s of %s: %w", role.Role, db, err)
+		}
+		granted := false
+		for _, name := range g.Roles {
+			granted = granted || name == role.Role
+		}
+		if !granted {
+			return fmt.Errorf("role %s is not granted to the namespace", role.Role)
+		}
+	}
+	return nil
+}
+
+// grants returns the grants of the resources of namespace.
+func (o *Operator) grants(namespace string) (Grants, error) {
+	grants, ok := o.Grants[namespace]
+	if !ok {
+		return Grants{}, fmt.Errorf("namespace %s has no grants in the config of the operator", namespace)
+	}
+	return grants, nil
+}
+
+// New returns an operator reconciling the resources of kubeClient with client, which must be
+// allowed to manage the databases and users.
+func New(kubeClient *kube.Client, client *mongo.Client) *Operator {
+	return &Operator{kube: kubeClient, client: client, Resync: 5 * time.Minute, Timeout: time.Minute}
+}
+
+// Run reconciles the resources as they change, and all of them every Resync, until ctx is done.
+func (o *Operator) Run(ctx context.Context) {
+	// The kinds are listed in order on start, then watched concurrently.
+	versions := make([]string, len(kinds))
+	for i, k := range kinds {
+		versions[i] = o.listUntilDone(ctx, k)
+	}
+	var wg sync.WaitGroup
+	for i, k := range kinds {
+		wg.Add(1)
+		go func(k kind, version string) {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				if err := o.watch(ctx, k, version); err != nil && ctx.Err() == nil {
+					if kube.IsGone(err) {
+						klog.V(2).Infof("Watch of %s expired, listing again", k.Plural)
+					} else {
+						klog.Errorf("Unable to watch %s: %v", k.Plural, err)
+					}
+				}
+				version = o.listUntilDone(ctx, k)
+			}
+		}(k, versions[i])
+	}
+	wg.Wait()
+}
+
+func (o *Operator) path(k kind) string {
+	if len(o.Namespace) == 0 {
+		return fmt.Sprintf("/apis/%s/%s/%s", Group, Version, k.Plural)
+	}
+	return fmt.Sprintf("/apis/%s/%s/namespaces/%s/%s", Group, Version, o.Namespace, k.Plural)
+}
+
+// listUntilDone reconciles every resource of kind k, retrying with a backoff until it can be listed
+// or ctx is done, and returns the resource version to watch from.
+func (o *Operator) listUntilDone(ctx context.Context, k kind) string {
+	var version string
+	backoff := wait.Backoff{Duration: time.Second, Factor: 2, Jitter: 0.1, Steps: 8, Cap: time.Minute}
+	for ctx.Err() == nil {
+		var err error
+		if version, err = o.list(ctx, k); err == nil {
+			return version
+		}
+		klog.Errorf("Unable to list %s: %v", k.Plural, err)
+		select {
+		case <-ctx.Done():
+		case <-time.After(backoff.Step()):
+		}
+	}
+	return version
+}
+
+func (o *Operator) list(ctx context.Context, k kind) (string, error) {
+	var list struct {
+		Metadata struct {
+			ResourceVersion string `json:"resourceVersion"`
+		} `json:"metadata"`
+		Items []json.RawMessage `json:"items"`
+	}
+	if err := o.kube.Do(ctx, http.MethodGet, o.path(k), nil, &list); err != nil {
+		return "", err
+	}
+	for _, item := range list.Items {
+		o.handle(ctx, k, item, true)
+	}
+	return list.Metadata.ResourceVersion, nil
+}
+
+func (o *Operator) watch(ctx context.Context, k kind, version string) error {
+	return o.kube.Watch(ctx, o.path(k), version, o.Resync, func(event kube.WatchEvent) error {
+		switch event.Type {
+		case "ADDED":
+			o.handle(ctx, k, event.Object, true)
+		case "MODIFIED":
+			// The updates of the status, and of the metadata, do not change the generation.
+			o.handle(ctx, k, event.Object, false)
+		}
+		return nil
+	})
+}
+
+// handle reconciles the resource data of kind k, only when its spec changed since it was last
+// reconciled unless always is set.
+func (o *Operator) handle(ctx context.Context, k kind, data json.RawMessage, always bool) {
+	r := k.new()
+	if err := json.Unmarshal(data, r); err != nil {
+		klog.Errorf("Unable to decode a %s: %v", k.Kind, err)
+		return
+	}
+	obj := r.object()
+	if !always && obj.Status.ObservedGeneration == obj.Metadata.Generation {
+		return
+	}
+	previous := obj.Status
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	reconcileCtx, cancel := context.WithTimeout(ctx, o.Timeout)
+	defer cancel()
+	err := r.reconcile(reconcileCtx, o)
+	if err != nil {
+		klog.Errorf("Unable to reconcile %s %s/%s: %v", k.Kind, obj.Metadata.Namespace, obj.Metadata.Name, err)
+	}
+	if err := o.updateStatus(ctx, k, obj, previous, err); err != nil {
+		klog.Errorf("Unable to update the status of %s %s/%s: %v", k.Kind, obj.Metadata.Namespace, obj.Metadata.Name, err)
+	}
+}
+
+// updateStatus writes the status of obj with the Ready condition of the outcome of its
+// reconciliation, unless it is the previous one.
+func (o *Operator) updateStatus(ctx context.Context, k kind, obj *Object, previous Status, reconcileErr error) error {
+	ready := Condition{Type: "Ready", Status: "True", Reason: "Reconciled", ObservedGeneration: obj.Metadata.Generation}
+	if reconcileErr != nil {
+		ready.Status, ready.Reason, ready.Message = "False", "ReconcileFailed", reconcileErr.Error()
+	}
+	ready.LastTransitionTime = time.Now().UTC().Truncate(time.Second)
+	for _, condition := range previous.Conditions {
+		if condition.Type == ready.Type && condition.Status == ready.Status {
+			ready.LastTransitionTime = condition.LastTransitionTime
+		}
+	}
+	status := obj.Status
+	status.ObservedGeneration = obj.Metadata.Generation
+	status.Conditions = []Condition{ready}
+	if reflect.DeepEqual(status, previous) {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, o.Timeout)
+	defer cancel()
+	path := fmt.Sprintf("/apis/%s/%s/namespaces/%s/%s/%s/status", Group, Version, obj.Metadata.Namespace, k.Plural, obj.Metadata.Name)
+	return o.kube.Do(ctx, http.MethodPatch, path, map[string]interface{}{"status": status}, nil)
+}
+
+// apply applies changes, logging them, for the resource obj.
+func apply(ctx context.Context, obj *Object, changes []provision.Change) error {
+	for _, change := range changes {
+		klog.Infof("%s %s/%s: %s", obj.Kind, obj.Metadata.Namespace, obj.Metadata.Name, change)
+	}
+	return provision.Apply(ctx, changes)
+}
+
+func (d *MongoDBDatabase) reconcile(ctx context.Context, o *Operator) error {
+	name := d.Spec.Name
+	if len(name) == 0 {
+		name = d.Metadata.Name
+	}
+	grants, err := o.grants(d.Metadata.Namespace)
+	if err != nil {
+		return err
+	}
+	if err := grants.checkDatabase(name); err != nil {
+		return err
+	}
+	for _, role := range d.Spec.Roles {
+		if err := grants.checkRoles(name, role.Roles); err != nil {
+			return fmt.Errorf("role %s: %w", role.Name, err)
+		}
+		for _, privilege := range role.Privileges {
+			switch {
+			case privilege.Resource.Cluster:
+				return fmt.Errorf("role %s: cluster privileges are not granted to the namespace", role.Name)
+			case len(privilege.Resource.Database) == 0:
+				return fmt.Errorf("role %s: privileges on every database are not granted to the namespace", role.Name)
+			}
+			if err := grants.checkDatabase(privilege.Resource.Database); err != nil {
+				return fmt.Errorf("role %s: %w", role.Name, err)
+			}
+		}
+	}
+	manifest := &provision.Manifest{Databases: []provision.Database{{Name: name, Collections: d.Spec.Collections, Roles: d.Spec.Roles}}}
+	if err := manifest.Validate(); err != nil {
+		return err
+	}
+	changes, err := provision.Plan(ctx, o.client, manifest, provision.Options{})
+	if err != nil {
+		return err
+	}
+	return apply(ctx, &d.Object, changes)
+}
+
+func (u *MongoDBUser) reconcile(ctx context.Context, o *Operator) error {
+	name := u.Spec.Name
+	if len(name) == 0 {
+		name = u.Metadata.Name
+	}
+	grants, err := o.grants(u.Metadata.Namespace)
+	if err != nil {
+		return err
+	}
+	if err := grants.checkDatabase(u.Spec.Database); err != nil {
+		return err
+	}
+	if err := grants.checkRoles(u.Spec.Database, u.Spec.Roles); err != nil {
+		return err
+	}
+	ref := u.Spec.PasswordSecretRef
+	if len(ref.Key) == 0 {
+		ref.Key = "password"
+	}
+	if len(ref.Name) == 0 {
+		return fmt.Errorf("passwordSecretRef requires the name of a Secret")
+	}
+	secret, err := o.kube.GetSecret(ctx, u.Metadata.Namespace, ref.Name)
+	if err != nil {
+		return fmt.Errorf("unable to read the password: %w", err)
+	}
+	password := string(secret.Data[ref.Key])
+	if len(password) == 0 {
+		return fmt.Errorf("the key %s of secret %s is empty", ref.Key, ref.Name)
+	}
+	user := provision.User{Name: name, Roles: u.Spec.Roles, Password: password}
+	manifest := &provision.Manifest{Databases: []provision.Database{{Name: u.Spec.Database, Users: []provision.User{user}}}}
+	if err := manifest.Validate(); err != nil {
+		return err
+	}
+	changes, err := provision.Plan(ctx, o.client, manifest, provision.Options{})
+	if err != nil {
+		return err
+	}
+	created := false
+	for _, change := range changes {
+		created = created || change.Action == provision.Create
+	}
+	if err := apply(ctx, &u.Object, changes); err != nil {
+		return err
+	}
+	// The password of a user that was not just created is set when the Secret changed since it was
+	// last set, or was never set by the operator.
+	if !created && u.Status.PasswordVersion != secret.Metadata.ResourceVersion {
+		klog.Infof("%s %s/%s: ~ password of user %s@%s", u.Kind, u.Metadata.Namespace, u.Metadata.Name, name, u.Spec.Database)
+		command := bson.D{{Key: "updateUser", Value: name}, {Key: "pwd", Value: password}}
+		if err := o.client.Database(u.Spec.Database).RunCommand(ctx, command).Err(); err != nil {
+			return fmt.Errorf("unable to set the password of %s@%s: %w", name, u.Spec.Database, err)
+		}
+	}
+	u.Status.PasswordVersion = secret.Metadata.ResourceVersion
+	return nil
+}
+
+func (i *MongoDBIndex) reconcile(ctx context.Context, o *Operator) error {
+	if len(i.Spec.Database) == 0 || len(i.Spec.Collection) == 0 {
+		return fmt.Errorf("indexes require a database and a collection")
+	}
+	grants, err := o.grants(i.Metadata.Namespace)
+	if err != nil {
+		return err
+	}
+	if err := grants.checkDatabase(i.Spec.Database); err != nil {
+		return err
+	}
+	collection := provision.Collection{Name: i.Spec.Collection, Indexes: []provision.Index{i.Spec.Index}}
+	manifest := &provision.Manifest{Databases: []provision.Database{{Name: i.Spec.Database, Collections: []provision.Collection{collection}}}}
+	if err := manifest.Validate(); err != nil {
+		return err
+	}
+	// Only the declared index is planned, the other indexes and the options of the collection are
+	// left to the other resources.
+	changes, err := provision.PlanIndexes(ctx, o.client.Database(i.Spec.Database).Collection(i.Spec.Collection), collection.Indexes, false)
+	if err != nil {
+		return err
+	}
+	return apply(ctx, &i.Object, changes)
+}
+
+// CustomResourceDefinitions returns the definitions of the custom resources to apply to the
+// cluster. Their specs are validated by the operator, whose outcome is the Ready condition.
+func CustomResourceDefinitions() []map[string]interface{} {
+	var definitions []map[string]interface{}
+	for _, k := range kinds {
+		preserve := map[string]interface{}{"type": "object", "x-kubernetes-preserve-unknown-fields": true}
+		definitions = append(definitions, map[string]interface{}{
+			"apiVersion": "apiextensions.k8s.io/v1",
+			"kind":       "CustomResourceDefinition",
+			"metadata":   map[string]interface{}{"name": k.Plural + "." + Group},
+			"spec": map[string]interface{}{
+				"group": Group,
+				"scope": "Namespaced",
+				"names": map[string]interface{}{
+					"kind":     k.Kind,
+					"listKind": k.Kind + "List",
+					"plural":   k.Plural,
+					"singular": strings.ToLower(k.Kind),
+				},
+				"versions": []interface{}{map[string]interface{}{
+					"name":         Version,
+					"served":       true,
+					"storage":      true,
+					"subresources": map[string]interface{}{"status": map[string]interface{}{}},
+					"additionalPrinterColumns": []interface{}{
+						map[string]interface{}{"name": "Ready", "type": "string", "jsonPath": `.status.conditions[?(@.type=="Ready")].status`},
+						map[string]interface{}{"name": "Reason", "type": "string", "jsonPath": `.status.conditions[?(@.type=="Ready")].reason`},
+						map[string]interface{}{"name": "Age", "type": "date", "jsonPath": ".metadata.creationTimestamp"},
+					},
+					"schema": map[string]interface{}{"openAPIV3Schema": map[string]interface{}{
+						"type": "object",
+						"properties": map[string]interface{}{
+							"spec":   preserve,
+							"status": preserve,
+						},
+					}},
+				}},
+			},
+		})
+	}
+	return definitions
+}
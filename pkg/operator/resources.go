@@ -0,0 +1,88 @@
+package operator
+
+import (
+	"time"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/kube"
+	"github.com/bradmwilliams/mongodb-client/pkg/provision"
+)
+
+const (
+	// Group and Version are the API group and version of the custom resources.
+	Group   = "mongodb.bradmwilliams.github.io"
+	Version = "v1alpha1"
+)
+
+// Object holds what the custom resources have in common.
+type Object struct {
+	APIVersion string          `json:"apiVersion"`
+	Kind       string          `json:"kind"`
+	Metadata   kube.ObjectMeta `json:"metadata"`
+	Status     Status          `json:"status,omitempty"`
+}
+
+// Status is the status written back by the operator.
+type Status struct {
+	// ObservedGeneration is the generation of the spec last reconciled.
+	ObservedGeneration int64       `json:"observedGeneration,omitempty"`
+	Conditions         []Condition `json:"conditions,omitempty"`
+	// PasswordVersion is the resource version of the password Secret last set on a user.
+	PasswordVersion string `json:"passwordVersion,omitempty"`
+}
+
+// Condition is a condition of a resource, Ready is the only one.
+type Condition struct {
+	Type               string    `json:"type"`
+	Status             string    `json:"status"`
+	Reason             string    `json:"reason,omitempty"`
+	Message            string    `json:"message,omitempty"`
+	LastTransitionTime time.Time `json:"lastTransitionTime"`
+	ObservedGeneration int64     `json:"observedGeneration,omitempty"`
+}
+
+// MongoDBDatabase declares a database, its collections with their indexes, and its roles.
+type MongoDBDatabase struct {
+	Object
+	Spec DatabaseSpec `json:"spec"`
+}
+
+type DatabaseSpec struct {
+	// Name is the name of the database, the name of the resource by default.
+	Name        string                 `json:"name,omitempty"`
+	Collections []provision.Collection `json:"collections,omitempty"`
+	Roles       []provision.Role       `json:"roles,omitempty"`
+}
+
+// MongoDBUser declares a user, whose password is read from a Secret of the namespace of the
+// resource.
+type MongoDBUser struct {
+	Object
+	Spec UserSpec `json:"spec"`
+}
+
+type UserSpec struct {
+	// Name is the name of the user, the name of the resource by default.
+	Name string `json:"name,omitempty"`
+	// Database is the database the user authenticates against.
+	Database          string                `json:"database"`
+	Roles             []provision.RoleGrant `json:"roles,omitempty"`
+	PasswordSecretRef SecretKeySelector     `json:"passwordSecretRef"`
+}
+
+// SecretKeySelector selects a key of a Secret, password by default.
+type SecretKeySelector struct {
+	Name string `json:"name"`
+	Key  string `json:"key,omitempty"`
+}
+
+// MongoDBIndex declares an index of a collection.
+type MongoDBIndex struct {
+	Object
+	Spec IndexSpec `json:"spec"`
+}
+
+type IndexSpec struct {
+	Database   string `json:"database"`
+	Collection string `json:"collection"`
+	provision.Index
+}
@@ -0,0 +1,234 @@
+package provision
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// namespaceNotFound is the code of the error listing the indexes of a missing collection.
+const namespaceNotFound = 26
+
+// IndexInfo is an index of a collection as listed by the server.
+type IndexInfo struct {
+	Name                    string   `bson:"name"`
+	Key                     bson.D   `bson:"key"`
+	Weights                 bson.D   `bson:"weights,omitempty"`
+	DefaultLanguage         string   `bson:"default_language,omitempty"`
+	Unique                  bool     `bson:"unique,omitempty"`
+	Sparse                  bool     `bson:"sparse,omitempty"`
+	ExpireAfterSeconds      *int32   `bson:"expireAfterSeconds,omitempty"`
+	PartialFilterExpression bson.Raw `bson:"partialFilterExpression,omitempty"`
+}
+
+// Keys returns the keys of the index in the notation of the manifest. The fields of a text index
+// are sorted, the server does not keep their order.
+func (i *IndexInfo) Keys() []string {
+	var keys []string
+	for _, key := range i.Key {
+		switch key.Key {
+		case "_fts":
+			var fields []string
+			for _, weight := range i.Weights {
+				fields = append(fields, weight.Key+":text")
+			}
+			sort.Strings(fields)
+			keys = append(keys, fields...)
+		case "_ftsx":
+		default:
+			keys = append(keys, keyString(key))
+		}
+	}
+	return keys
+}
+
+func keyString(key bson.E) string {
+	switch value := key.Value.(type) {
+	case string:
+		return key.Key + ":" + value
+	case int32:
+		if value < 0 {
+			return "-" + key.Key
+		}
+	case int64:
+		if value < 0 {
+			return "-" + key.Key
+		}
+	case float64:
+		if value < 0 {
+			return "-" + key.Key
+		}
+	}
+	return key.Key
+}
+
+// normalizeKeys orders the text fields of keys like the ones of a listed index.
+func normalizeKeys(keys []string) []string {
+	var normalized, text []string
+	position := -1
+	for _, key := range keys {
+		if strings.HasSuffix(key, ":text") {
+			if position < 0 {
+				position = len(normalized)
+			}
+			text = append(text, key)
+			continue
+		}
+		normalized = append(normalized, key)
+	}
+	if position < 0 {
+		return normalized
+	}
+	sort.Strings(text)
+	return append(normalized[:position], append(text, normalized[position:]...)...)
+}
+
+// ListIndexes returns the indexes of collection, none when it does not exist.
+func ListIndexes(ctx context.Context, collection *mongo.Collection) ([]IndexInfo, error) {
+	cursor, err := collection.Indexes().List(ctx)
+	var commandErr mongo.CommandError
+	if errors.As(err, &commandErr) && commandErr.Code == namespaceNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the indexes of %s.%s: %w", collection.Database().Name(), collection.Name(), err)
+	}
+	var indexes []IndexInfo
+	if err := cursor.All(ctx, &indexes); err != nil {
+		return nil, fmt.Errorf("unable to list the indexes of %s.%s: %w", collection.Database().Name(), collection.Name(), err)
+	}
+	return indexes, nil
+}
+
+// Model returns the index model creating the declared index.
+func (i *Index) Model() (mongo.IndexModel, error) {
+	keys, err := i.KeySpec()
+	if err != nil {
+		return mongo.IndexModel{}, err
+	}
+	indexOptions := options.Index().SetName(i.name(keys))
+	if i.Unique {
+		indexOptions.SetUnique(true)
+	}
+	if i.Sparse {
+		indexOptions.SetSparse(true)
+	}
+	if i.Background {
+		indexOptions.SetBackground(true)
+	}
+	if i.ExpireAfterSeconds != nil {
+		indexOptions.SetExpireAfterSeconds(*i.ExpireAfterSeconds)
+	}
+	filter, err := document("partialFilterExpression", i.PartialFilterExpression)
+	if err != nil {
+		return mongo.IndexModel{}, err
+	}
+	if filter != nil {
+		indexOptions.SetPartialFilterExpression(filter)
+	}
+	return mongo.IndexModel{Keys: keys, Options: indexOptions}, nil
+}
+
+// describe returns the keys and options of an index, such as "[-published] unique".
+func describe(keys []string, unique, sparse bool, expireAfterSeconds *int32, filter interface{}) string {
+	parts := []string{"[" + strings.Join(keys, ", ") + "]"}
+	if unique {
+		parts = append(parts, "unique")
+	}
+	if sparse {
+		parts = append(parts, "sparse")
+	}
+	if expireAfterSeconds != nil {
+		parts = append(parts, fmt.Sprintf("expireAfterSeconds %d", *expireAfterSeconds))
+	}
+	if normalize(filter) != nil {
+		parts = append(parts, "partialFilterExpression "+extJSON(filter))
+	}
+	return strings.Join(parts, " ")
+}
+
+func sameExpiry(a, b *int32) bool {
+	return (a == nil && b == nil) || (a != nil && b != nil && *a == *b)
+}
+
+// PlanIndexes returns the changes making the indexes of collection match the declared ones. The
+// _id index is never changed, the other indexes that are not declared are dropped with prune.
+func PlanIndexes(ctx context.Context, collection *mongo.Collection, declared []Index, prune bool) ([]Change, error) {
+	current, err := ListIndexes(ctx, collection)
+	if err != nil {
+		return nil, err
+	}
+	return planIndexes(collection, declared, current, prune)
+}
+
+func planIndexes(collection *mongo.Collection, declared []Index, current []IndexInfo, prune bool) ([]Change, error) {
+	namespace := collection.Database().Name() + "." + collection.Name()
+	existing := map[string]IndexInfo{}
+	for _, index := range current {
+		existing[index.Name] = index
+	}
+	var changes []Change
+	names := map[string]bool{"_id_": true}
+	for _, index := range declared {
+		model, err := index.Model()
+		if err != nil {
+			return nil, fmt.Errorf("index of %s: %w", namespace, err)
+		}
+		name := *model.Options.Name
+		names[name] = true
+		filter, _ := document("partialFilterExpression", index.PartialFilterExpression)
+		detail := describe(index.Keys, index.Unique, index.Sparse, index.ExpireAfterSeconds, filter)
+		object := "index " + namespace + " " + name
+		create := func(ctx context.Context) error {
+			_, err := collection.Indexes().CreateOne(ctx, model)
+			return err
+		}
+
+		info, ok := existing[name]
+		if !ok {
+			changes = append(changes, Change{Action: Create, Object: object, Detail: detail, apply: create})
+			continue
+		}
+		if reflect.DeepEqual(normalizeKeys(index.Keys), info.Keys()) && index.Unique == info.Unique && index.Sparse == info.Sparse &&
+			sameExpiry(index.ExpireAfterSeconds, info.ExpireAfterSeconds) && sameDocument(filter, info.PartialFilterExpression) {
+			continue
+		}
+		changes = append(changes, Change{
+			Action: Modify,
+			Object: object,
+			Detail: fmt.Sprintf("recreate as %s (currently %s)", detail, describe(info.Keys(), info.Unique, info.Sparse, info.ExpireAfterSeconds, info.PartialFilterExpression)),
+			apply: func(ctx context.Context) error {
+				if _, err := collection.Indexes().DropOne(ctx, name); err != nil {
+					return err
+				}
+				return create(ctx)
+			},
+		})
+	}
+	if !prune {
+		return changes, nil
+	}
+	for _, info := range current {
+		if names[info.Name] {
+			continue
+		}
+		name := info.Name
+		changes = append(changes, Change{
+			Action: Drop,
+			Object: "index " + namespace + " " + name,
+			Detail: describe(info.Keys(), info.Unique, info.Sparse, info.ExpireAfterSeconds, info.PartialFilterExpression),
+			apply: func(ctx context.Context) error {
+				_, err := collection.Indexes().DropOne(ctx, name)
+				return err
+			},
+		})
+	}
+	return changes, nil
+}
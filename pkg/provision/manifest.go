@@ -0,0 +1,310 @@
+// Package provision reconciles the databases, collections, indexes, users and roles of a
+// deployment with the ones declared in a manifest.
+package provision
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"sigs.k8s.io/yaml"
+)
+
+// Manifest declares the state of a deployment.
+type Manifest struct {
+	Databases []Database `json:"databases"`
+}
+
+// Database declares the collections of a database and the users and roles defined in it. A
+// database without collections is not created, MongoDB creates databases with their first
+// collection.
+type Database struct {
+	Name        string       `json:"name"`
+	Collections []Collection `json:"collections,omitempty"`
+	Users       []User       `json:"users,omitempty"`
+	Roles       []Role       `json:"roles,omitempty"`
+}
+
+// Collection declares a collection, its options and its indexes.
+type Collection struct {
+	Name string `json:"name"`
+	// Capped collections hold at most Size bytes and, when set, Max documents. They only apply
+	// when the collection is created or converted to a capped collection, which is not undone.
+	Capped bool  `json:"capped,omitempty"`
+	Size   int64 `json:"size,omitempty"`
+	Max    int64 `json:"max,omitempty"`
+	// TimeSeries creates a time series collection, which can not be converted from or to a regular
+	// collection. Only its granularity can be changed afterwards, and only to a coarser one.
+	TimeSeries *TimeSeries `json:"timeseries,omitempty"`
+	// ExpireAfterSeconds removes the measurements of a time series collection this long after their
+	// time.
+	ExpireAfterSeconds *int64 `json:"expireAfterSeconds,omitempty"`
+	// Validator is an Extended JSON query document, such as {"$jsonSchema": {...}}, documents must
+	// match. It is removed from the collection when empty.
+	Validator json.RawMessage `json:"validator,omitempty"`
+	// ValidationLevel is off, strict or moderate, ValidationAction error or warn. The server
+	// defaults apply when they are empty.
+	ValidationLevel  string `json:"validationLevel,omitempty"`
+	ValidationAction string `json:"validationAction,omitempty"`
+	// Indexes of the collection, besides the _id index.
+	Indexes []Index `json:"indexes,omitempty"`
+}
+
+// TimeSeries declares the fields of the measurements of a time series collection.
+type TimeSeries struct {
+	// TimeField holds the date of the measurements.
+	TimeField string `json:"timeField"`
+	// MetaField holds what identifies the source of the measurements, which rarely changes.
+	MetaField string `json:"metaField,omitempty"`
+	// Granularity is seconds, minutes or hours, the interval between consecutive measurements of a
+	// source it is closest to. The server default, seconds, applies when it is empty.
+	Granularity string `json:"granularity,omitempty"`
+}
+
+// Granularities are the granularities of time series collections, from the finest to the coarsest.
+var Granularities = []string{"seconds", "minutes", "hours"}
+
+// Validate reports whether the time series options are complete and consistent.
+func (t *TimeSeries) Validate() error {
+	if len(t.TimeField) == 0 {
+		return fmt.Errorf("time series collections require a timeField")
+	}
+	if t.TimeField == t.MetaField || t.TimeField == "_id" || t.MetaField == "_id" {
+		return fmt.Errorf("the timeField and the metaField must be different fields other than _id")
+	}
+	if len(t.Granularity) > 0 && granularityRank(t.Granularity) < 0 {
+		return fmt.Errorf("granularity must be one of %s", strings.Join(Granularities, ", "))
+	}
+	return nil
+}
+
+// granularityRank returns the position of granularity in Granularities, -1 when it is unknown.
+func granularityRank(granularity string) int {
+	if len(granularity) == 0 {
+		granularity = Granularities[0]
+	}
+	for i, g := range Granularities {
+		if g == granularity {
+			return i
+		}
+	}
+	return -1
+}
+
+// Index declares an index. Indexes are identified by name, an index whose keys or options differ
+// from the declared ones is dropped and created again.
+type Index struct {
+	// Name defaults to the name the server gives to the keys, such as title_1_published_-1.
+	Name string `json:"name,omitempty"`
+	// Keys are field names, in order. A field prefixed with - is descending, one suffixed with :text,
+	// :2dsphere or :hashed has that index type, such as ["-published", "title:text"].
+	Keys []string `json:"keys"`
+	// Unique rejects documents with the same keys, Sparse leaves documents without the fields out.
+	Unique bool `json:"unique,omitempty"`
+	Sparse bool `json:"sparse,omitempty"`
+	// Background builds the index without blocking the collection on servers before 4.2, it is
+	// not compared with existing indexes.
+	Background bool `json:"background,omitempty"`
+	// ExpireAfterSeconds removes the documents this long after the date of their single key.
+	ExpireAfterSeconds *int32 `json:"expireAfterSeconds,omitempty"`
+	// PartialFilterExpression is an Extended JSON query document selecting the documents indexed.
+	PartialFilterExpression json.RawMessage `json:"partialFilterExpression,omitempty"`
+}
+
+// User declares a user authenticating against its database.
+type User struct {
+	Name string `json:"name"`
+	// PasswordEnv is the environment variable holding the password of the user, it is only used
+	// when the user is created.
+	PasswordEnv string      `json:"passwordEnv"`
+	Roles       []RoleGrant `json:"roles,omitempty"`
+	// Password is the password of the user, instead of PasswordEnv, for the callers that read it
+	// from elsewhere. It is only used when the user is created too.
+	Password string `json:"-"`
+}
+
+// RoleGrant grants a built-in or user-defined role of a database, the database of the user or
+// role it is granted to by default.
+type RoleGrant struct {
+	Role     string `json:"role"`
+	Database string `json:"db,omitempty"`
+}
+
+// Role declares a user-defined role.
+type Role struct {
+	Name       string      `json:"name"`
+	Privileges []Privilege `json:"privileges,omitempty"`
+	// Roles are inherited by the role.
+	Roles []RoleGrant `json:"roles,omitempty"`
+}
+
+// Privilege allows actions, such as find or insert, on a resource.
+type Privilege struct {
+	Resource Resource `json:"resource"`
+	Actions  []string `json:"actions"`
+}
+
+// Resource is a collection, every collection of a database when Collection is empty, or every
+// database when Database is empty too. Cluster selects the cluster wide actions instead.
+type Resource struct {
+	Database   string `json:"db,omitempty"`
+	Collection string `json:"collection,omitempty"`
+	Cluster    bool   `json:"cluster,omitempty"`
+}
+
+// Load reads a YAML or JSON manifest.
+func Load(path string) (*Manifest, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read manifest: %w", err)
+	}
+	manifest := &Manifest{}
+	if err := yaml.UnmarshalStrict(data, manifest); err != nil {
+		return nil, fmt.Errorf("unable to parse manifest %s: %w", path, err)
+	}
+	if err := manifest.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid manifest %s: %w", path, err)
+	}
+	return manifest, nil
+}
+
+// Validate reports whether the names of the manifest are valid and unique, and its collections
+// consistent.
+func (m *Manifest) Validate() error {
+	databases := map[string]bool{}
+	for _, database := range m.Databases {
+		if len(database.Name) == 0 || strings.ContainsAny(database.Name, "/\\. \"$") {
+			return fmt.Errorf("invalid database name %q", database.Name)
+		}
+		if databases[database.Name] {
+			return fmt.Errorf("database %s is declared twice", database.Name)
+		}
+		databases[database.Name] = true
+		collections := map[string]bool{}
+		for _, collection := range database.Collections {
+			if len(collection.Name) == 0 || strings.HasPrefix(collection.Name, "system.") || strings.Contains(collection.Name, "$") {
+				return fmt.Errorf("invalid collection name %q in database %s", collection.Name, database.Name)
+			}
+			if collections[collection.Name] {
+				return fmt.Errorf("collection %s.%s is declared twice", database.Name, collection.Name)
+			}
+			collections[collection.Name] = true
+			if err := collection.validate(); err != nil {
+				return fmt.Errorf("collection %s.%s: %w", database.Name, collection.Name, err)
+			}
+		}
+		for _, user := range database.Users {
+			if len(user.Name) == 0 || len(user.PasswordEnv) == 0 && len(user.Password) == 0 {
+				return fmt.Errorf("users of database %s require a name and a passwordEnv", database.Name)
+			}
+		}
+		for _, role := range database.Roles {
+			if len(role.Name) == 0 {
+				return fmt.Errorf("roles of database %s require a name", database.Name)
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Collection) validate() error {
+	if c.Capped && c.Size <= 0 {
+		return fmt.Errorf("capped collections require a size")
+	}
+	if !c.Capped && (c.Size != 0 || c.Max != 0) {
+		return fmt.Errorf("size and max only apply to capped collections")
+	}
+	if c.TimeSeries != nil {
+		if c.Capped {
+			return fmt.Errorf("time series collections can not be capped")
+		}
+		if err := c.TimeSeries.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.ExpireAfterSeconds != nil && (c.TimeSeries == nil || *c.ExpireAfterSeconds <= 0) {
+		return fmt.Errorf("expireAfterSeconds only applies to time series collections and must be positive")
+	}
+	if _, err := document("validator", c.Validator); err != nil {
+		return err
+	}
+	switch c.ValidationLevel {
+	case "", "off", "strict", "moderate":
+	default:
+		return fmt.Errorf("validationLevel must be off, strict or moderate")
+	}
+	switch c.ValidationAction {
+	case "", "error", "warn":
+	default:
+		return fmt.Errorf("validationAction must be error or warn")
+	}
+	names := map[string]bool{}
+	for _, index := range c.Indexes {
+		keys, err := index.KeySpec()
+		if err != nil {
+			return err
+		}
+		if _, err := document("partialFilterExpression", index.PartialFilterExpression); err != nil {
+			return err
+		}
+		name := index.name(keys)
+		if names[name] {
+			return fmt.Errorf("index %s is declared twice", name)
+		}
+		names[name] = true
+	}
+	return nil
+}
+
+// document parses an Extended JSON document of the manifest, nil when it is empty.
+func document(name string, data json.RawMessage) (bson.D, error) {
+	if len(data) == 0 || string(data) == "null" {
+		return nil, nil
+	}
+	doc := bson.D{}
+	if err := bson.UnmarshalExtJSON(data, false, &doc); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return doc, nil
+}
+
+// KeySpec returns the keys document of the index.
+func (i *Index) KeySpec() (bson.D, error) {
+	if len(i.Keys) == 0 {
+		return nil, fmt.Errorf("indexes require keys")
+	}
+	keys := bson.D{}
+	for _, spec := range i.Keys {
+		key := spec
+		var value interface{} = int32(1)
+		if strings.HasPrefix(key, "-") {
+			key, value = key[1:], int32(-1)
+		} else if parts := strings.SplitN(key, ":", 2); len(parts) == 2 {
+			switch parts[1] {
+			case "text", "2dsphere", "hashed":
+				key, value = parts[0], parts[1]
+			default:
+				return nil, fmt.Errorf("unsupported index type %q of key %s", parts[1], parts[0])
+			}
+		}
+		if len(key) == 0 {
+			return nil, fmt.Errorf("invalid index key %q", spec)
+		}
+		keys = append(keys, bson.E{Key: key, Value: value})
+	}
+	return keys, nil
+}
+
+// name returns the declared name of the index or the one the server would give it.
+func (i *Index) name(keys bson.D) string {
+	if len(i.Name) > 0 {
+		return i.Name
+	}
+	parts := make([]string, 0, 2*len(keys))
+	for _, key := range keys {
+		parts = append(parts, key.Key, fmt.Sprint(key.Value))
+	}
+	return strings.Join(parts, "_")
+}
@@ -0,0 +1,581 @@
+package provision
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Action is what a change does to an object of the deployment.
+type Action string
+
+const (
+	Create Action = "+"
+	Modify Action = "~"
+	Drop   Action = "-"
+)
+
+// Change is a difference between the deployment and the manifest and the command resolving it.
+type Change struct {
+	Action Action
+	// Object is what the change applies to, such as "index sampledb.episodes title_1".
+	Object string
+	// Detail describes the declared state, and for modifications the current one.
+	Detail string
+
+	apply func(ctx context.Context) error
+}
+
+func (c Change) String() string {
+	if len(c.Detail) == 0 {
+		return fmt.Sprintf("%s %s", c.Action, c.Object)
+	}
+	return fmt.Sprintf("%s %s: %s", c.Action, c.Object, c.Detail)
+}
+
+// Options change how the deployment is reconciled.
+type Options struct {
+	// PruneIndexes drops the indexes of the declared collections that are not declared.
+	PruneIndexes bool
+}
+
+// Plan returns the changes making the deployment of client match the manifest: roles are created
+// first so that users can be granted them, then collections and their indexes, then users. Users,
+// roles and collections that are not declared are left alone.
+func Plan(ctx context.Context, client *mongo.Client, manifest *Manifest, opts Options) ([]Change, error) {
+	var changes []Change
+	for _, database := range manifest.Databases {
+		for _, role := range database.Roles {
+			roleChanges, err := planRole(ctx, client.Database(database.Name), role)
+			if err != nil {
+				return nil, err
+			}
+			changes = append(changes, roleChanges...)
+		}
+	}
+	for _, database := range manifest.Databases {
+		for _, collection := range database.Collections {
+			collectionChanges, err := planCollection(ctx, client.Database(database.Name), collection, opts)
+			if err != nil {
+				return nil, err
+			}
+			changes = append(changes, collectionChanges...)
+		}
+	}
+	for _, database := range manifest.Databases {
+		for _, user := range database.Users {
+			userChanges, err := planUser(ctx, client.Database(database.Name), user)
+			if err != nil {
+				return nil, err
+			}
+			changes = append(changes, userChanges...)
+		}
+	}
+	return changes, nil
+}
+
+// Apply applies changes in order, it stops at the first that fails.
+func Apply(ctx context.Context, changes []Change) error {
+	for _, change := range changes {
+		if err := change.apply(ctx); err != nil {
+			return fmt.Errorf("%s: %w", change, err)
+		}
+	}
+	return nil
+}
+
+// runCommand runs a command whose result is only checked for errors.
+func runCommand(db *mongo.Database, command bson.D) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		return db.RunCommand(ctx, command).Err()
+	}
+}
+
+// normalize returns a document as plain JSON values, so that documents differing only by the order
+// of their fields or the types of their numbers compare equal.
+func normalize(doc interface{}) interface{} {
+	switch d := doc.(type) {
+	case nil:
+		return nil
+	case bson.D:
+		if len(d) == 0 {
+			return nil
+		}
+	case bson.Raw:
+		if len(d) == 0 {
+			return nil
+		}
+	}
+	data, err := bson.MarshalExtJSON(doc, false, false)
+	if err != nil {
+		return fmt.Sprint(doc)
+	}
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return string(data)
+	}
+	if m, ok := value.(map[string]interface{}); ok && len(m) == 0 {
+		return nil
+	}
+	return value
+}
+
+func sameDocument(a, b interface{}) bool {
+	return reflect.DeepEqual(normalize(a), normalize(b))
+}
+
+func extJSON(doc interface{}) string {
+	if normalize(doc) == nil {
+		return "{}"
+	}
+	data, err := bson.MarshalExtJSON(doc, false, false)
+	if err != nil {
+		return fmt.Sprint(doc)
+	}
+	return string(data)
+}
+
+type collectionInfo struct {
+	Name    string `bson:"name"`
+	Type    string `bson:"type"`
+	Options struct {
+		Capped           bool     `bson:"capped"`
+		Validator        bson.Raw `bson:"validator"`
+		ValidationLevel  string   `bson:"validationLevel"`
+		ValidationAction string   `bson:"validationAction"`
+		TimeSeries       *struct {
+			TimeField   string `bson:"timeField"`
+			MetaField   string `bson:"metaField"`
+			Granularity string `bson:"granularity"`
+		} `bson:"timeseries"`
+		ExpireAfterSeconds *int64 `bson:"expireAfterSeconds"`
+	} `bson:"options"`
+}
+
+// readCollectionInfo returns the options of collection name of db, or nil when it does not exist.
+func readCollectionInfo(ctx context.Context, db *mongo.Database, name string) (*collectionInfo, error) {
+	cursor, err := db.ListCollections(ctx, bson.D{{Key: "name", Value: name}})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the collections of %s: %w", db.Name(), err)
+	}
+	var infos []collectionInfo
+	if err := cursor.All(ctx, &infos); err != nil {
+		return nil, fmt.Errorf("unable to list the collections of %s: %w", db.Name(), err)
+	}
+	if len(infos) == 0 {
+		return nil, nil
+	}
+	return &infos[0], nil
+}
+
+// Validation is the validator of a collection with its validation level and action.
+type Validation struct {
+	Validator bson.Raw
+	Level     string
+	Action    string
+}
+
+// GetValidation returns the validation of collection name of db, or nil when it does not exist.
+func GetValidation(ctx context.Context, db *mongo.Database, name string) (*Validation, error) {
+	info, err := readCollectionInfo(ctx, db, name)
+	if err != nil || info == nil {
+		return nil, err
+	}
+	if info.Type == "view" {
+		return nil, fmt.Errorf("%s.%s is a view, not a collection", db.Name(), name)
+	}
+	return &Validation{Validator: info.Options.Validator, Level: info.Options.ValidationLevel, Action: info.Options.ValidationAction}, nil
+}
+
+// PlanCollection returns the changes making collection c of db match its declaration, like Plan.
+func PlanCollection(ctx context.Context, db *mongo.Database, c Collection, opts Options) ([]Change, error) {
+	return planCollection(ctx, db, c, opts)
+}
+
+func planCollection(ctx context.Context, db *mongo.Database, c Collection, opts Options) ([]Change, error) {
+	namespace := db.Name() + "." + c.Name
+	validator, err := document("validator", c.Validator)
+	if err != nil {
+		return nil, err
+	}
+	info, err := readCollectionInfo(ctx, db, c.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	if info == nil {
+		createOptions := options.CreateCollection()
+		var details []string
+		if c.Capped {
+			createOptions.SetCapped(true).SetSizeInBytes(c.Size)
+			details = append(details, fmt.Sprintf("capped at %d bytes", c.Size))
+			if c.Max > 0 {
+				createOptions.SetMaxDocuments(c.Max)
+				details = append(details, fmt.Sprintf("%d documents", c.Max))
+			}
+		}
+		if t := c.TimeSeries; t != nil {
+			timeSeries := options.TimeSeries().SetTimeField(t.TimeField)
+			details = append(details, "time series of "+t.TimeField)
+			if len(t.MetaField) > 0 {
+				timeSeries.SetMetaField(t.MetaField)
+				details = append(details, "metaField "+t.MetaField)
+			}
+			if len(t.Granularity) > 0 {
+				timeSeries.SetGranularity(t.Granularity)
+				details = append(details, "granularity "+t.Granularity)
+			}
+			createOptions.SetTimeSeriesOptions(timeSeries)
+		}
+		if c.ExpireAfterSeconds != nil {
+			createOptions.SetExpireAfterSeconds(*c.ExpireAfterSeconds)
+			details = append(details, fmt.Sprintf("expireAfterSeconds %d", *c.ExpireAfterSeconds))
+		}
+		if validator != nil {
+			createOptions.SetValidator(validator)
+			details = append(details, "validator "+extJSON(validator))
+		}
+		if len(c.ValidationLevel) > 0 {
+			createOptions.SetValidationLevel(c.ValidationLevel)
+			details = append(details, "validationLevel "+c.ValidationLevel)
+		}
+		if len(c.ValidationAction) > 0 {
+			createOptions.SetValidationAction(c.ValidationAction)
+			details = append(details, "validationAction "+c.ValidationAction)
+		}
+		changes := []Change{{
+			Action: Create,
+			Object: "collection " + namespace,
+			Detail: strings.Join(details, ", "),
+			apply: func(ctx context.Context) error {
+				return db.CreateCollection(ctx, c.Name, createOptions)
+			},
+		}}
+		indexChanges, err := planIndexes(db.Collection(c.Name), c.Indexes, nil, opts.PruneIndexes)
+		if err != nil {
+			return nil, err
+		}
+		return append(changes, indexChanges...), nil
+	}
+
+	if info.Type == "view" {
+		return nil, fmt.Errorf("%s is a view, not a collection", namespace)
+	}
+	var changes []Change
+	switch {
+	case c.Capped && !info.Options.Capped:
+		if c.Max > 0 {
+			return nil, fmt.Errorf("%s cannot be converted to a capped collection with a maximum number of documents", namespace)
+		}
+		changes = append(changes, Change{
+			Action: Modify,
+			Object: "collection " + namespace,
+			Detail: fmt.Sprintf("convert to capped at %d bytes", c.Size),
+			apply: runCommand(db, bson.D{
+				{Key: "convertToCapped", Value: c.Name},
+				{Key: "size", Value: c.Size},
+			}),
+		})
+	case !c.Capped && info.Options.Capped:
+		return nil, fmt.Errorf("%s is capped, capped collections cannot be converted back", namespace)
+	}
+
+	collMod := bson.D{{Key: "collMod", Value: c.Name}}
+	var details []string
+	timeSeriesChange, err := planTimeSeries(namespace, c, info)
+	if err != nil {
+		return nil, err
+	}
+	if len(timeSeriesChange) > 0 {
+		changes = append(changes, Change{
+			Action: Modify,
+			Object: "time series " + namespace,
+			Detail: strings.Join(timeSeriesDetails(timeSeriesChange, info), ", "),
+			apply:  runCommand(db, append(bson.D{{Key: "collMod", Value: c.Name}}, timeSeriesChange...)),
+		})
+	}
+	if !sameDocument(validator, info.Options.Validator) {
+		// An empty validator removes the current one.
+		value := bson.D{}
+		if validator != nil {
+			value = validator
+		}
+		collMod = append(collMod, bson.E{Key: "validator", Value: value})
+		details = append(details, fmt.Sprintf("validator %s (currently %s)", extJSON(validator), extJSON(info.Options.Validator)))
+	}
+	if len(c.ValidationLevel) > 0 && c.ValidationLevel != info.Options.ValidationLevel {
+		collMod = append(collMod, bson.E{Key: "validationLevel", Value: c.ValidationLevel})
+		details = append(details, fmt.Sprintf("validationLevel %s (currently %s)", c.ValidationLevel, info.Options.ValidationLevel))
+	}
+	if len(c.ValidationAction) > 0 && c.ValidationAction != info.Options.ValidationAction {
+		collMod = append(collMod, bson.E{Key: "validationAction", Value: c.ValidationAction})
+		details = append(details, fmt.Sprintf("validationAction %s (currently %s)", c.ValidationAction, info.Options.ValidationAction))
+	}
+	if len(details) > 0 {
+		changes = append(changes, Change{
+			Action: Modify,
+			Object: "validation of " + namespace,
+			Detail: strings.Join(details, ", "),
+			apply:  runCommand(db, collMod),
+		})
+	}
+
+	current, err := ListIndexes(ctx, db.Collection(c.Name))
+	if err != nil {
+		return nil, err
+	}
+	indexChanges, err := planIndexes(db.Collection(c.Name), c.Indexes, current, opts.PruneIndexes)
+	if err != nil {
+		return nil, err
+	}
+	return append(changes, indexChanges...), nil
+}
+
+// planTimeSeries returns the collMod fields making the time series options of a collection match
+// its declaration, or an error when they can not be changed.
+func planTimeSeries(namespace string, c Collection, info *collectionInfo) (bson.D, error) {
+	current := info.Options.TimeSeries
+	switch {
+	case c.TimeSeries == nil && current == nil:
+		return nil, nil
+	case c.TimeSeries == nil:
+		return nil, fmt.Errorf("%s is a time series collection, it cannot be converted to a regular collection", namespace)
+	case current == nil:
+		return nil, fmt.Errorf("%s is a regular collection, it cannot be converted to a time series collection", namespace)
+	}
+	if c.TimeSeries.TimeField != current.TimeField || c.TimeSeries.MetaField != current.MetaField {
+		return nil, fmt.Errorf("the timeField and metaField of %s are %q and %q, they cannot be changed", namespace, current.TimeField, current.MetaField)
+	}
+	var collMod bson.D
+	if declared := c.TimeSeries.Granularity; len(declared) > 0 && declared != current.Granularity {
+		if granularityRank(declared) < granularityRank(current.Granularity) {
+			return nil, fmt.Errorf("the granularity of %s is %s, it can only be made coarser", namespace, current.Granularity)
+		}
+		collMod = append(collMod, bson.E{Key: "timeseries", Value: bson.D{{Key: "granularity", Value: declared}}})
+	}
+	if c.ExpireAfterSeconds != nil && (info.Options.ExpireAfterSeconds == nil || *c.ExpireAfterSeconds != *info.Options.ExpireAfterSeconds) {
+		collMod = append(collMod, bson.E{Key: "expireAfterSeconds", Value: *c.ExpireAfterSeconds})
+	}
+	return collMod, nil
+}
+
+// timeSeriesDetails describes the changes of planTimeSeries.
+func timeSeriesDetails(collMod bson.D, info *collectionInfo) []string {
+	var details []string
+	for _, e := range collMod {
+		switch e.Key {
+		case "timeseries":
+			details = append(details, fmt.Sprintf("granularity %s (currently %s)", e.Value.(bson.D)[0].Value, info.Options.TimeSeries.Granularity))
+		case "expireAfterSeconds":
+			currently := "off"
+			if info.Options.ExpireAfterSeconds != nil {
+				currently = fmt.Sprint(*info.Options.ExpireAfterSeconds)
+			}
+			details = append(details, fmt.Sprintf("expireAfterSeconds %d (currently %s)", e.Value, currently))
+		}
+	}
+	return details
+}
+
+// GrantDocuments returns the roles granted as commands expect them, in database by default.
+func GrantDocuments(database string, grants []RoleGrant) bson.A {
+	roles := bson.A{}
+	for _, grant := range grants {
+		db := grant.Database
+		if len(db) == 0 {
+			db = database
+		}
+		roles = append(roles, bson.D{{Key: "role", Value: grant.Role}, {Key: "db", Value: db}})
+	}
+	return roles
+}
+
+// grantSet returns the roles granted as sorted role@db strings.
+func grantSet(roles bson.A) []string {
+	set := make([]string, 0, len(roles))
+	for _, role := range roles {
+		var grant struct {
+			Role     string `bson:"role"`
+			Database string `bson:"db"`
+		}
+		data, err := bson.Marshal(role)
+		if err == nil {
+			err = bson.Unmarshal(data, &grant)
+		}
+		if err != nil {
+			continue
+		}
+		set = append(set, grant.Role+"@"+grant.Database)
+	}
+	sort.Strings(set)
+	return set
+}
+
+func describeGrants(set []string) string {
+	if len(set) == 0 {
+		return "no roles"
+	}
+	return "roles " + strings.Join(set, ", ")
+}
+
+func planUser(ctx context.Context, db *mongo.Database, user User) ([]Change, error) {
+	object := "user " + user.Name + "@" + db.Name()
+	var result struct {
+		Users []struct {
+			Roles bson.A `bson:"roles"`
+		} `bson:"users"`
+	}
+	err := db.RunCommand(ctx, bson.D{{Key: "usersInfo", Value: bson.D{{Key: "user", Value: user.Name}, {Key: "db", Value: db.Name()}}}}).Decode(&result)
+	if err != nil {
+		return nil, fmt.Errorf("unable to look up %s: %w", object, err)
+	}
+	roles := GrantDocuments(db.Name(), user.Roles)
+	declared := grantSet(roles)
+
+	if len(result.Users) == 0 {
+		password := user.Password
+		if len(password) == 0 {
+			password = os.Getenv(user.PasswordEnv)
+		}
+		if len(password) == 0 {
+			return nil, fmt.Errorf("%s cannot be created, %s is empty", object, user.PasswordEnv)
+		}
+		return []Change{{
+			Action: Create,
+			Object: object,
+			Detail: describeGrants(declared),
+			apply: runCommand(db, bson.D{
+				{Key: "createUser", Value: user.Name},
+				{Key: "pwd", Value: password},
+				{Key: "roles", Value: roles},
+			}),
+		}}, nil
+	}
+	current := grantSet(result.Users[0].Roles)
+	if reflect.DeepEqual(declared, current) {
+		return nil, nil
+	}
+	return []Change{{
+		Action: Modify,
+		Object: object,
+		Detail: fmt.Sprintf("%s (currently %s)", describeGrants(declared), describeGrants(current)),
+		apply: runCommand(db, bson.D{
+			{Key: "updateUser", Value: user.Name},
+			{Key: "roles", Value: roles},
+		}),
+	}}, nil
+}
+
+// PrivilegeDocuments returns privileges as commands expect them.
+func PrivilegeDocuments(privileges []Privilege) bson.A {
+	list := bson.A{}
+	for _, privilege := range privileges {
+		resource := bson.D{{Key: "db", Value: privilege.Resource.Database}, {Key: "collection", Value: privilege.Resource.Collection}}
+		if privilege.Resource.Cluster {
+			resource = bson.D{{Key: "cluster", Value: true}}
+		}
+		list = append(list, bson.D{{Key: "resource", Value: resource}, {Key: "actions", Value: privilege.Actions}})
+	}
+	return list
+}
+
+// privilegeSet returns privileges as sorted strings such as "sampledb.episodes: find,insert".
+func privilegeSet(list bson.A) ([]string, error) {
+	set := make([]string, 0, len(list))
+	for _, item := range list {
+		var privilege struct {
+			Resource struct {
+				Database   *string `bson:"db"`
+				Collection *string `bson:"collection"`
+				Cluster    bool    `bson:"cluster"`
+			} `bson:"resource"`
+			Actions []string `bson:"actions"`
+		}
+		data, err := bson.Marshal(item)
+		if err != nil {
+			return nil, err
+		}
+		if err := bson.Unmarshal(data, &privilege); err != nil {
+			return nil, err
+		}
+		resource := "cluster"
+		if !privilege.Resource.Cluster {
+			var db, collection string
+			if privilege.Resource.Database != nil {
+				db = *privilege.Resource.Database
+			}
+			if privilege.Resource.Collection != nil {
+				collection = *privilege.Resource.Collection
+			}
+			resource = db + "." + collection
+		}
+		actions := append([]string(nil), privilege.Actions...)
+		sort.Strings(actions)
+		set = append(set, resource+": "+strings.Join(actions, ","))
+	}
+	sort.Strings(set)
+	return set, nil
+}
+
+func planRole(ctx context.Context, db *mongo.Database, role Role) ([]Change, error) {
+	object := "role " + role.Name + "@" + db.Name()
+	var result struct {
+		Roles []struct {
+			Privileges bson.A `bson:"privileges"`
+			Roles      bson.A `bson:"roles"`
+		} `bson:"roles"`
+	}
+	err := db.RunCommand(ctx, bson.D{
+		{Key: "rolesInfo", Value: bson.D{{Key: "role", Value: role.Name}, {Key: "db", Value: db.Name()}}},
+		{Key: "showPrivileges", Value: true},
+	}).Decode(&result)
+	if err != nil {
+		return nil, fmt.Errorf("unable to look up %s: %w", object, err)
+	}
+	declaredPrivileges := PrivilegeDocuments(role.Privileges)
+	roles := GrantDocuments(db.Name(), role.Roles)
+	privilegeStrings, err := privilegeSet(declaredPrivileges)
+	if err != nil {
+		return nil, err
+	}
+	declared := fmt.Sprintf("privileges [%s], inherited %s", strings.Join(privilegeStrings, "; "), describeGrants(grantSet(roles)))
+
+	if len(result.Roles) == 0 {
+		return []Change{{
+			Action: Create,
+			Object: object,
+			Detail: declared,
+			apply: runCommand(db, bson.D{
+				{Key: "createRole", Value: role.Name},
+				{Key: "privileges", Value: declaredPrivileges},
+				{Key: "roles", Value: roles},
+			}),
+		}}, nil
+	}
+	currentPrivileges, err := privilegeSet(result.Roles[0].Privileges)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the privileges of %s: %w", object, err)
+	}
+	if reflect.DeepEqual(privilegeStrings, currentPrivileges) && reflect.DeepEqual(grantSet(roles), grantSet(result.Roles[0].Roles)) {
+		return nil, nil
+	}
+	current := fmt.Sprintf("privileges [%s], inherited %s", strings.Join(currentPrivileges, "; "), describeGrants(grantSet(result.Roles[0].Roles)))
+	return []Change{{
+		Action: Modify,
+		Object: object,
+		Detail: fmt.Sprintf("%s (currently %s)", declared, current),
+		apply: runCommand(db, bson.D{
+			{Key: "updateRole", Value: role.Name},
+			{Key: "privileges", Value: declaredPrivileges},
+			{Key: "roles", Value: roles},
+		}),
+	}}, nil
+}
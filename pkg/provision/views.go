@@ -0,0 +1,372 @@
+package provision
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// View is the definition of a view, an aggregation pipeline run on ViewOn when the view is read.
+type View struct {
+	// Name defaults to the name of the file of the definition without its extension.
+	Name      string   `bson:"name,omitempty"`
+	ViewOn    string   `bson:"viewOn"`
+	Pipeline  []bson.D `bson:"pipeline"`
+	Collation bson.D   `bson:"collation,omitempty"`
+}
+
+// LoadViews reads the Extended JSON view definitions of paths, files or directories of .json files.
+func LoadViews(paths ...string) ([]View, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read view definitions: %w", err)
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(path, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+
+	var views []View
+	names := map[string]string{}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read view definition: %w", err)
+		}
+		var view View
+		if err := bson.UnmarshalExtJSON(data, false, &view); err != nil {
+			return nil, fmt.Errorf("unable to parse view definition %s: %w", file, err)
+		}
+		if len(view.Name) == 0 {
+			view.Name = strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+		}
+		if err := view.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid view definition %s: %w", file, err)
+		}
+		if previous, ok := names[view.Name]; ok {
+			return nil, fmt.Errorf("view %s is defined by %s and %s", view.Name, previous, file)
+		}
+		names[view.Name] = file
+		views = append(views, view)
+	}
+	return views, nil
+}
+
+// Validate reports whether the definition is complete.
+func (v *View) Validate() error {
+	if len(v.Name) == 0 || strings.ContainsAny(v.Name, "$") || strings.HasPrefix(v.Name, "system.") {
+		return fmt.Errorf("invalid view name %q", v.Name)
+	}
+	if len(v.ViewOn) == 0 {
+		return fmt.Errorf("view %s: viewOn is required", v.Name)
+	}
+	for i, stage := range v.Pipeline {
+		if len(stage) != 1 {
+			return fmt.Errorf("view %s: stage %d must have exactly one operator", v.Name, i)
+		}
+		if stage[0].Key == "$out" || stage[0].Key == "$merge" {
+			return fmt.Errorf("view %s: %s can not be used in a view", v.Name, stage[0].Key)
+		}
+	}
+	return nil
+}
+
+// Dependencies returns the collections and views the view reads: its viewOn and the collections of
+// its $lookup, $graphLookup and $unionWith stages, including those of nested pipelines.
+func (v *View) Dependencies() []string {
+	set := map[string]bool{v.ViewOn: true}
+	pipelineDependencies(v.Pipeline, set)
+	dependencies := make([]string, 0, len(set))
+	for name := range set {
+		dependencies = append(dependencies, name)
+	}
+	sort.Strings(dependencies)
+	return dependencies
+}
+
+func pipelineDependencies(pipeline interface{}, set map[string]bool) {
+	stages, ok := pipeline.(bson.A)
+	if !ok {
+		if typed, ok := pipeline.([]bson.D); ok {
+			for _, stage := range typed {
+				stages = append(stages, stage)
+			}
+		}
+	}
+	for _, stage := range stages {
+		stage, ok := stage.(bson.D)
+		if !ok {
+			continue
+		}
+		for _, e := range stage {
+			switch e.Key {
+			case "$lookup", "$graphLookup":
+				spec, _ := e.Value.(bson.D)
+				if from, ok := lookupString(spec, "from"); ok {
+					set[from] = true
+				}
+				pipelineDependencies(lookupValue(spec, "pipeline"), set)
+			case "$unionWith":
+				switch spec := e.Value.(type) {
+				case string:
+					set[spec] = true
+				case bson.D:
+					if coll, ok := lookupString(spec, "coll"); ok {
+						set[coll] = true
+					}
+					pipelineDependencies(lookupValue(spec, "pipeline"), set)
+				}
+			case "$facet":
+				spec, _ := e.Value.(bson.D)
+				for _, facet := range spec {
+					pipelineDependencies(facet.Value, set)
+				}
+			}
+		}
+	}
+}
+
+func lookupValue(doc bson.D, key string) interface{} {
+	for _, e := range doc {
+		if e.Key == key {
+			return e.Value
+		}
+	}
+	return nil
+}
+
+func lookupString(doc bson.D, key string) (string, bool) {
+	s, ok := lookupValue(doc, key).(string)
+	return s, ok
+}
+
+// ListViews returns the definitions of the views of db, sorted by name.
+func ListViews(ctx context.Context, db *mongo.Database) ([]View, error) {
+	cursor, err := db.ListCollections(ctx, bson.D{{Key: "type", Value: "view"}})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the views of %s: %w", db.Name(), err)
+	}
+	var infos []struct {
+		Name    string `bson:"name"`
+		Options View   `bson:"options"`
+	}
+	if err := cursor.All(ctx, &infos); err != nil {
+		return nil, fmt.Errorf("unable to list the views of %s: %w", db.Name(), err)
+	}
+	views := make([]View, 0, len(infos))
+	for _, info := range infos {
+		view := info.Options
+		view.Name = info.Name
+		if view.Pipeline == nil {
+			view.Pipeline = []bson.D{}
+		}
+		views = append(views, view)
+	}
+	sort.Slice(views, func(i, j int) bool { return views[i].Name < views[j].Name })
+	return views, nil
+}
+
+// Dependents returns the views of views that read name, directly or through other views, in the
+// order they can be created.
+func Dependents(views []View, name string) ([]View, error) {
+	ordered, err := sortViews(views)
+	if err != nil {
+		return nil, err
+	}
+	affected := map[string]bool{name: true}
+	var dependents []View
+	for _, view := range ordered {
+		if view.Name == name {
+			continue
+		}
+		for _, dependency := range view.Dependencies() {
+			if affected[dependency] {
+				affected[view.Name] = true
+				dependents = append(dependents, view)
+				break
+			}
+		}
+	}
+	return dependents, nil
+}
+
+// sortViews returns views ordered so that every view comes after the views it reads, or an error
+// when views read each other.
+func sortViews(views []View) ([]View, error) {
+	byName := map[string]View{}
+	names := make([]string, 0, len(views))
+	for _, view := range views {
+		byName[view.Name] = view
+		names = append(names, view.Name)
+	}
+	sort.Strings(names)
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := map[string]int{}
+	var ordered []View
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case visiting:
+			return fmt.Errorf("views read each other: %s", strings.Join(append(path, name), " -> "))
+		case visited:
+			return nil
+		}
+		state[name] = visiting
+		view := byName[name]
+		for _, dependency := range view.Dependencies() {
+			if _, ok := byName[dependency]; ok {
+				if err := visit(dependency, append(path, name)); err != nil {
+					return err
+				}
+			}
+		}
+		state[name] = visited
+		ordered = append(ordered, view)
+		return nil
+	}
+	for _, name := range names {
+		if err := visit(name, nil); err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
+
+// PlanViews returns the changes making the views of db match their definitions, in dependency
+// order. Missing views are created and views whose viewOn or pipeline changed are modified in place.
+// Views whose collation changed are dropped and created again, since it can not be modified, and so
+// are the views reading them, declared or not, since a view must have the collation of the views it
+// reads.
+func PlanViews(ctx context.Context, db *mongo.Database, views []View) ([]Change, error) {
+	existing, err := ListViews(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	current := map[string]View{}
+	all := map[string]View{}
+	for _, view := range existing {
+		current[view.Name] = view
+		all[view.Name] = view
+	}
+	declared := map[string]bool{}
+	for _, view := range views {
+		info, err := readCollectionInfo(ctx, db, view.Name)
+		if err != nil {
+			return nil, err
+		}
+		if info != nil && info.Type != "view" {
+			return nil, fmt.Errorf("%s.%s is a %s, not a view", db.Name(), view.Name, info.Type)
+		}
+		declared[view.Name] = true
+		all[view.Name] = view
+	}
+	list := make([]View, 0, len(all))
+	for _, view := range all {
+		list = append(list, view)
+	}
+	ordered, err := sortViews(list)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []Change
+	recreated := map[string]bool{}
+	for _, view := range ordered {
+		object := fmt.Sprintf("view %s.%s", db.Name(), view.Name)
+		previous, exists := current[view.Name]
+		var reason string
+		for _, dependency := range view.Dependencies() {
+			if recreated[dependency] {
+				reason = "recreated after " + dependency
+				break
+			}
+		}
+		switch {
+		case !exists:
+			changes = append(changes, Change{Action: Create, Object: object, Detail: describeView(view), apply: createView(db, view)})
+		case declared[view.Name] && !sameCollation(view.Collation, previous.Collation):
+			reason = fmt.Sprintf("collation %s, was %s", extJSON(view.Collation), extJSON(previous.Collation))
+		case declared[view.Name] && describeView(view) != describeView(previous) && len(reason) == 0:
+			changes = append(changes, Change{
+				Action: Modify,
+				Object: object,
+				Detail: fmt.Sprintf("%s, was %s", describeView(view), describeView(previous)),
+				apply: runCommand(db, bson.D{
+					{Key: "collMod", Value: view.Name},
+					{Key: "viewOn", Value: view.ViewOn},
+					{Key: "pipeline", Value: pipelineValue(view.Pipeline)},
+				}),
+			})
+		}
+		if exists && len(reason) > 0 {
+			recreated[view.Name] = true
+			drop := runCommand(db, bson.D{{Key: "drop", Value: view.Name}})
+			create := createView(db, view)
+			changes = append(changes, Change{Action: Modify, Object: object, Detail: reason, apply: func(ctx context.Context) error {
+				if err := drop(ctx); err != nil {
+					return err
+				}
+				return create(ctx)
+			}})
+		}
+	}
+	return changes, nil
+}
+
+// sameCollation reports whether current has the fields of declared, the server returns every
+// field of a collation with its default.
+func sameCollation(declared, current bson.D) bool {
+	if len(declared) == 0 {
+		return len(current) == 0 || lookupValue(current, "locale") == "simple"
+	}
+	for _, e := range declared {
+		if !sameDocument(bson.D{e}, bson.D{{Key: e.Key, Value: lookupValue(current, e.Key)}}) {
+			return false
+		}
+	}
+	return true
+}
+
+func createView(db *mongo.Database, view View) func(ctx context.Context) error {
+	command := bson.D{
+		{Key: "create", Value: view.Name},
+		{Key: "viewOn", Value: view.ViewOn},
+		{Key: "pipeline", Value: pipelineValue(view.Pipeline)},
+	}
+	if len(view.Collation) > 0 {
+		command = append(command, bson.E{Key: "collation", Value: view.Collation})
+	}
+	return runCommand(db, command)
+}
+
+// pipelineValue returns pipeline as an array, an empty pipeline is an empty array rather than null.
+func pipelineValue(pipeline []bson.D) bson.A {
+	value := bson.A{}
+	for _, stage := range pipeline {
+		value = append(value, stage)
+	}
+	return value
+}
+
+func describeView(view View) string {
+	return fmt.Sprintf("on %s, %s", view.ViewOn, extJSON(bson.D{{Key: "pipeline", Value: pipelineValue(view.Pipeline)}}))
+}
@@ -0,0 +1,186 @@
+// Package querytemplate renders Extended JSON filters and pipelines written as Go templates, in
+// which the parameters are converted to typed Extended JSON values by functions, such as
+// {"created": {"$gt": {{.since | date}}}}, so that scripts inject values without building JSON
+// strings themselves.
+package querytemplate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// value is a parameter. A value rendered without a function is a JSON string, never raw JSON.
+type value string
+
+func (v value) String() string {
+	data, _ := json.Marshal(string(v))
+	return string(data)
+}
+
+// IsTemplate reports whether text is a template rather than plain Extended JSON.
+func IsTemplate(text string) bool {
+	return strings.Contains(text, "{{")
+}
+
+// Render executes the template text with the parameters params and returns the Extended JSON it
+// renders. Referring to a parameter that is not set is an error.
+func Render(text string, params map[string]string) (string, error) {
+	tmpl, err := template.New("query").Option("missingkey=error").Funcs(template.FuncMap{
+		"string":  quote,
+		"int":     func(v interface{}) (string, error) { return number(v, "$numberInt", 32) },
+		"long":    func(v interface{}) (string, error) { return number(v, "$numberLong", 64) },
+		"double":  double,
+		"decimal": decimal,
+		"bool":    boolean,
+		"date":    date,
+		"oid":     oid,
+		"json":    raw,
+		"now":     func() time.Time { return time.Now().UTC() },
+		"ago": func(d string) (time.Time, error) {
+			duration, err := time.ParseDuration(d)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("ago: %w", err)
+			}
+			return time.Now().UTC().Add(-duration), nil
+		},
+	}).Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid template: %w", err)
+	}
+	data := make(map[string]value, len(params))
+	for name, v := range params {
+		data[name] = value(v)
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// text returns the text of a parameter or of a constant of the template.
+func text(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case value:
+		return string(v), nil
+	case string:
+		return v, nil
+	}
+	return "", fmt.Errorf("expected a string, not %T", v)
+}
+
+func quote(v interface{}) (string, error) {
+	s, err := text(v)
+	if err != nil {
+		return "", err
+	}
+	return value(s).String(), nil
+}
+
+func number(v interface{}, kind string, bits int) (string, error) {
+	s, err := text(v)
+	if err != nil {
+		return "", err
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(s), 10, bits)
+	if err != nil {
+		return "", fmt.Errorf("%q is not a %d-bit integer", s, bits)
+	}
+	return fmt.Sprintf(`{"%s":"%d"}`, kind, n), nil
+}
+
+func double(v interface{}) (string, error) {
+	s, err := text(v)
+	if err != nil {
+		return "", err
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return "", fmt.Errorf("%q is not a double", s)
+	}
+	return fmt.Sprintf(`{"$numberDouble":"%s"}`, strconv.FormatFloat(f, 'g', -1, 64)), nil
+}
+
+func decimal(v interface{}) (string, error) {
+	s, err := text(v)
+	if err != nil {
+		return "", err
+	}
+	d, err := primitive.ParseDecimal128(strings.TrimSpace(s))
+	if err != nil {
+		return "", fmt.Errorf("%q is not a decimal", s)
+	}
+	return fmt.Sprintf(`{"$numberDecimal":"%s"}`, d.String()), nil
+}
+
+func boolean(v interface{}) (string, error) {
+	s, err := text(v)
+	if err != nil {
+		return "", err
+	}
+	b, err := strconv.ParseBool(strings.TrimSpace(s))
+	if err != nil {
+		return "", fmt.Errorf("%q is not a bool", s)
+	}
+	return strconv.FormatBool(b), nil
+}
+
+// date renders a time, or its text: an RFC 3339 time, a day written 2006-01-02 or milliseconds
+// since the epoch.
+func date(v interface{}) (string, error) {
+	t, ok := v.(time.Time)
+	if !ok {
+		s, err := text(v)
+		if err != nil {
+			return "", err
+		}
+		s = strings.TrimSpace(s)
+		if t, err = parseDate(s); err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf(`{"$date":{"$numberLong":"%d"}}`, int64(primitive.NewDateTimeFromTime(t))), nil
+}
+
+func parseDate(s string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(0, ms*int64(time.Millisecond)).UTC(), nil
+	}
+	return time.Time{}, fmt.Errorf("%q is not a date, expected 2006-01-02, 2006-01-02T15:04:05Z or milliseconds since the epoch", s)
+}
+
+func oid(v interface{}) (string, error) {
+	s, err := text(v)
+	if err != nil {
+		return "", err
+	}
+	id, err := primitive.ObjectIDFromHex(strings.TrimSpace(s))
+	if err != nil {
+		return "", fmt.Errorf("%q is not an ObjectId", s)
+	}
+	return fmt.Sprintf(`{"$oid":"%s"}`, id.Hex()), nil
+}
+
+// raw renders a parameter holding a JSON value as is, once checked to be valid JSON.
+func raw(v interface{}) (string, error) {
+	s, err := text(v)
+	if err != nil {
+		return "", err
+	}
+	if !json.Valid([]byte(s)) {
+		return "", fmt.Errorf("%q is not valid JSON", s)
+	}
+	return s, nil
+}
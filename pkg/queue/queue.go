@@ -0,0 +1,416 @@
+// Package queue implements a reliable work queue on a collection. Messages are claimed atomically
+// and stay invisible to other consumers for a visibility timeout, renewed while they are processed,
+// after which the messages of crashed consumers are claimed again. Failed messages are retried with
+// an exponential backoff and moved to a dead-letter collection once out of attempts.
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"k8s.io/klog"
+)
+
+var (
+	messagesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mongodb_client_queue_messages_total",
+		Help: "Number of messages of each queue, partitioned by event: enqueued, claimed, acknowledged, retried, dead_lettered or redriven.",
+	}, []string{"queue", "event"})
+	processingDurationSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "mongodb_client_queue_processing_duration_seconds",
+		Help:    "Duration of the processing of the messages of each queue by Consume in seconds, partitioned by result.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 4, 10),
+	}, []string{"queue", "result"})
+	messages = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "mongodb_client_queue_messages",
+		Help: "Number of messages of each queue by state at the last call to Stats: ready, delayed, in_flight or dead.",
+	}, []string{"queue", "state"})
+)
+
+func init() {
+	prometheus.MustRegister(messagesTotal, processingDurationSeconds, messages)
+}
+
+// ErrEmpty is returned by Claim when no message is ready.
+var ErrEmpty = errors.New("no message is ready")
+
+// ErrLost is returned when a claimed message was claimed again by another consumer after its
+// visibility timeout expired, or was removed from the queue.
+var ErrLost = errors.New("the claim of the message was lost")
+
+// Queue is a named queue of messages stored in a collection, which several queues may share.
+type Queue struct {
+	Name string
+	// Visibility is how long a claimed message stays invisible to other consumers without being
+	// extended. Consume extends the messages it processes every Visibility/3.
+	Visibility time.Duration
+	// MaxAttempts is the number of claims of a message before it is dead-lettered.
+	MaxAttempts int
+	// Backoff delays the first retry of a failed message; it doubles after each subsequent failure
+	// up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// DeadLetter receives the messages out of attempts.
+	DeadLetter *mongo.Collection
+
+	collection *mongo.Collection
+}
+
+// New returns the queue called name of collection, whose messages are attempted 5 times and
+// dead-lettered to the collection of the same name suffixed with _dead.
+func New(collection *mongo.Collection, name string) *Queue {
+	return &Queue{
+		Name:        name,
+		Visibility:  time.Minute,
+		MaxAttempts: 5,
+		Backoff:     10 * time.Second,
+		MaxBackoff:  10 * time.Minute,
+		DeadLetter:  collection.Database().Collection(collection.Name() + "_dead"),
+		collection:  collection,
+	}
+}
+
+// Message is a message of a queue. A claimed message must be acknowledged with Ack once processed,
+// or released for a retry with Nack.
+type Message struct {
+	ID      primitive.ObjectID `bson:"_id"`
+	Queue   string             `bson:"queue"`
+	Payload bson.Raw           `bson:"payload"`
+	// Attempts is the number of claims of the message, including the current one.
+	Attempts   int       `bson:"attempts"`
+	EnqueuedAt time.Time `bson:"enqueuedAt"`
+	// VisibleAt is when the message can be claimed, the expiration of the claim of a claimed message.
+	VisibleAt time.Time          `bson:"visibleAt"`
+	Token     primitive.ObjectID `bson:"token,omitempty"`
+	LastError string             `bson:"lastError,omitempty"`
+	// DeadAt is when a message of the dead-letter collection was dead-lettered.
+	DeadAt *time.Time `bson:"deadAt,omitempty"`
+
+	queue *Queue
+}
+
+// EnsureIndexes creates the index of the claims of the messages.
+func (q *Queue) EnsureIndexes(ctx context.Context) error {
+	_, err := q.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "queue", Value: 1}, {Key: "visibleAt", Value: 1}},
+		Options: options.Index().SetName("queue_visibleAt"),
+	})
+	return err
+}
+
+// Enqueue adds a message holding payload, a document, claimable once delay has elapsed.
+func (q *Queue) Enqueue(ctx context.Context, payload interface{}, delay time.Duration) (primitive.ObjectID, error) {
+	data, err := bson.Marshal(payload)
+	if err != nil {
+		return primitive.NilObjectID, fmt.Errorf("the payload must be a document: %w", err)
+	}
+	now := time.Now().UTC()
+	message := Message{
+		ID:         primitive.NewObjectID(),
+		Queue:      q.Name,
+		Payload:    data,
+		EnqueuedAt: now,
+		VisibleAt:  now.Add(delay),
+	}
+	if _, err := q.collection.InsertOne(ctx, message); err != nil {
+		return primitive.NilObjectID, fmt.Errorf("unable to enqueue a message to %s: %w", q.Name, err)
+	}
+	messagesTotal.WithLabelValues(q.Name, "enqueued").Inc()
+	return message.ID, nil
+}
+
+// Claim claims the message ready for the longest time, returning ErrEmpty when none is. The
+// messages of consumers that crashed on their last attempt are dead-lettered instead of claimed.
+func (q *Queue) Claim(ctx context.Context) (*Message, error) {
+	for {
+		now := time.Now().UTC()
+		result := q.collection.FindOneAndUpdate(ctx,
+			bson.M{"queue": q.Name, "visibleAt": bson.M{"$lte": now}},
+			bson.M{
+				"$set": bson.M{"visibleAt": now.Add(q.Visibility), "token": primitive.NewObjectID()},
+				"$inc": bson.M{"attempts": 1},
+			},
+			options.FindOneAndUpdate().SetSort(bson.D{{Key: "visibleAt", Value: 1}}).SetReturnDocument(options.After),
+		)
+		message := &Message{queue: q}
+		if err := result.Decode(message); err != nil {
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return nil, ErrEmpty
+			}
+			return nil, fmt.Errorf("unable to claim a message of %s: %w", q.Name, err)
+		}
+		if message.Attempts <= q.MaxAttempts {
+			messagesTotal.WithLabelValues(q.Name, "claimed").Inc()
+			return message, nil
+		}
+		if message.LastError == "" {
+			message.LastError = "the visibility timeout of the last attempt expired"
+		}
+		if err := message.deadLetter(ctx); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Decode unmarshals the payload of the message into v.
+func (m *Message) Decode(v interface{}) error {
+	return bson.Unmarshal(m.Payload, v)
+}
+
+// Ack removes the processed message from the queue. It returns ErrLost when the message was claimed
+// again, which processed it twice.
+func (m *Message) Ack(ctx context.Context) error {
+	result, err := m.queue.collection.DeleteOne(ctx, bson.M{"_id": m.ID, "token": m.Token})
+	if err != nil {
+		return fmt.Errorf("unable to acknowledge message %s of %s: %w", m.ID.Hex(), m.queue.Name, err)
+	}
+	if result.DeletedCount == 0 {
+		return fmt.Errorf("message %s of %s: %w", m.ID.Hex(), m.queue.Name, ErrLost)
+	}
+	messagesTotal.WithLabelValues(m.queue.Name, "acknowledged").Inc()
+	return nil
+}
+
+// Extend renews the visibility timeout of the claimed message.
+func (m *Message) Extend(ctx context.Context) error {
+	visibleAt := time.Now().UTC().Add(m.queue.Visibility)
+	result, err := m.queue.collection.UpdateOne(ctx,
+		bson.M{"_id": m.ID, "token": m.Token},
+		bson.M{"$set": bson.M{"visibleAt": visibleAt}},
+	)
+	if err != nil {
+		return fmt.Errorf("unable to extend message %s of %s: %w", m.ID.Hex(), m.queue.Name, err)
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("message %s of %s: %w", m.ID.Hex(), m.queue.Name, ErrLost)
+	}
+	m.VisibleAt = visibleAt
+	return nil
+}
+
+// Nack records the failure of the processing of the message, cause, and releases it for a retry
+// once the backoff of its attempt has elapsed, or dead-letters it once out of attempts.
+func (m *Message) Nack(ctx context.Context, cause error) error {
+	m.LastError = cause.Error()
+	if m.Attempts >= m.queue.MaxAttempts {
+		return m.deadLetter(ctx)
+	}
+	result, err := m.queue.collection.UpdateOne(ctx,
+		bson.M{"_id": m.ID, "token": m.Token},
+		bson.M{
+			"$set":   bson.M{"visibleAt": time.Now().UTC().Add(m.queue.backoff(m.Attempts)), "lastError": m.LastError},
+			"$unset": bson.M{"token": ""},
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("unable to release message %s of %s: %w", m.ID.Hex(), m.queue.Name, err)
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("message %s of %s: %w", m.ID.Hex(), m.queue.Name, ErrLost)
+	}
+	messagesTotal.WithLabelValues(m.queue.Name, "retried").Inc()
+	return nil
+}
+
+// backoff returns the delay before the retry of a message that failed its attempt.
+func (q *Queue) backoff(attempt int) time.Duration {
+	delay := q.Backoff
+	for i := 1; i < attempt && delay < q.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if q.MaxBackoff > 0 && delay > q.MaxBackoff {
+		delay = q.MaxBackoff
+	}
+	return delay
+}
+
+// deadLetter moves the claimed message to the dead-letter collection. The message is copied before
+// it is removed, so a message whose removal failed is dead-lettered again by a later claim.
+func (m *Message) deadLetter(ctx context.Context) error {
+	now := time.Now().UTC()
+	dead := *m
+	dead.Token = primitive.NilObjectID
+	dead.DeadAt = &now
+	if _, err := m.queue.DeadLetter.ReplaceOne(ctx, bson.M{"_id": m.ID}, dead, options.Replace().SetUpsert(true)); err != nil {
+		return fmt.Errorf("unable to dead-letter message %s of %s: %w", m.ID.Hex(), m.queue.Name, err)
+	}
+	result, err := m.queue.collection.DeleteOne(ctx, bson.M{"_id": m.ID, "token": m.Token})
+	if err != nil {
+		return fmt.Errorf("unable to remove dead-lettered message %s of %s: %w", m.ID.Hex(), m.queue.Name, err)
+	}
+	if result.DeletedCount == 0 {
+		return fmt.Errorf("message %s of %s: %w", m.ID.Hex(), m.queue.Name, ErrLost)
+	}
+	klog.Warningf("Dead-lettered message %s of queue %s after %d attempts: %s", m.ID.Hex(), m.queue.Name, m.Attempts, m.LastError)
+	messagesTotal.WithLabelValues(m.queue.Name, "dead_lettered").Inc()
+	return nil
+}
+
+// Redrive moves the dead-lettered messages of the queue back to the queue with their attempts
+// reset, and returns their number.
+func (q *Queue) Redrive(ctx context.Context) (int64, error) {
+	cursor, err := q.DeadLetter.Find(ctx, bson.M{"queue": q.Name})
+	if err != nil {
+		return 0, fmt.Errorf("unable to read the dead letters of %s: %w", q.Name, err)
+	}
+	defer cursor.Close(ctx)
+	var count int64
+	for cursor.Next(ctx) {
+		var message Message
+		if err := cursor.Decode(&message); err != nil {
+			return count, err
+		}
+		message.Attempts = 0
+		message.DeadAt = nil
+		message.VisibleAt = time.Now().UTC()
+		// The message is restored before it is removed, a failure leaves it in both collections
+		// rather than losing it.
+		if _, err := q.collection.ReplaceOne(ctx, bson.M{"_id": message.ID}, message, options.Replace().SetUpsert(true)); err != nil {
+			return count, fmt.Errorf("unable to redrive message %s of %s: %w", message.ID.Hex(), q.Name, err)
+		}
+		if _, err := q.DeadLetter.DeleteOne(ctx, bson.M{"_id": message.ID}); err != nil {
+			return count, fmt.Errorf("unable to remove redriven message %s of %s: %w", message.ID.Hex(), q.Name, err)
+		}
+		count++
+		messagesTotal.WithLabelValues(q.Name, "redriven").Inc()
+	}
+	return count, cursor.Err()
+}
+
+// Stats is the number of messages of a queue by state.
+type Stats struct {
+	Queue string `json:"queue"`
+	// Ready messages can be claimed.
+	Ready int64 `json:"ready"`
+	// Delayed messages were enqueued with a delay or wait for a retry.
+	Delayed int64 `json:"delayed"`
+	// InFlight messages are claimed.
+	InFlight int64 `json:"inFlight"`
+	Dead     int64 `json:"dead"`
+}
+
+// Stats counts the messages of the queue by state and records them in the metrics.
+func (q *Queue) Stats(ctx context.Context) (Stats, error) {
+	stats := Stats{Queue: q.Name}
+	now := time.Now().UTC()
+	counts := []struct {
+		state  string
+		value  *int64
+		filter bson.M
+	}{
+		{"ready", &stats.Ready, bson.M{"queue": q.Name, "visibleAt": bson.M{"$lte": now}}},
+		{"delayed", &stats.Delayed, bson.M{"queue": q.Name, "visibleAt": bson.M{"$gt": now}, "token": bson.M{"$exists": false}}},
+		{"in_flight", &stats.InFlight, bson.M{"queue": q.Name, "visibleAt": bson.M{"$gt": now}, "token": bson.M{"$exists": true}}},
+	}
+	for _, count := range counts {
+		n, err := q.collection.CountDocuments(ctx, count.filter)
+		if err != nil {
+			return stats, fmt.Errorf("unable to count the %s messages of %s: %w", count.state, q.Name, err)
+		}
+		*count.value = n
+		messages.WithLabelValues(q.Name, count.state).Set(float64(n))
+	}
+	n, err := q.DeadLetter.CountDocuments(ctx, bson.M{"queue": q.Name})
+	if err != nil {
+		return stats, fmt.Errorf("unable to count the dead letters of %s: %w", q.Name, err)
+	}
+	stats.Dead = n
+	messages.WithLabelValues(q.Name, "dead").Set(float64(n))
+	return stats, nil
+}
+
+// Handler processes a message. The message is acknowledged when it returns nil and retried
+// otherwise. Its context is cancelled when the claim of the message is lost.
+type Handler func(ctx context.Context, message *Message) error
+
+// Consume runs concurrency consumers processing the messages of the queue with handler until ctx
+// is cancelled, waiting poll between two claims when the queue is empty.
+func (q *Queue) Consume(ctx context.Context, concurrency int, poll time.Duration, handler Handler) error {
+	if concurrency < 1 {
+		return fmt.Errorf("the concurrency must be at least 1")
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				message, err := q.Claim(ctx)
+				switch {
+				case err == nil:
+					q.process(ctx, message, handler)
+					continue
+				case errors.Is(err, ErrEmpty):
+				case ctx.Err() == nil:
+					klog.Warningf("Unable to claim a message of queue %s: %v", q.Name, err)
+				}
+				select {
+				case <-ctx.Done():
+				case <-time.After(poll):
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// process runs handler on the claimed message, extending its claim until handler returns.
+func (q *Queue) process(ctx context.Context, message *Message, handler Handler) {
+	processCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(q.Visibility / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			extendCtx, extendCancel := context.WithTimeout(ctx, q.Visibility/3)
+			err := message.Extend(extendCtx)
+			extendCancel()
+			if errors.Is(err, ErrLost) {
+				klog.Errorf("Lost message %s of queue %s while processing it", message.ID.Hex(), q.Name)
+				cancel()
+				return
+			}
+			if err != nil {
+				klog.Warningf("Unable to extend message %s of queue %s: %v", message.ID.Hex(), q.Name, err)
+			}
+		}
+	}()
+
+	start := time.Now()
+	err := handler(processCtx, message)
+	close(done)
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	processingDurationSeconds.WithLabelValues(q.Name, result).Observe(time.Since(start).Seconds())
+
+	// The outcome is recorded even when ctx is cancelled by a shutdown, so the message is not
+	// processed again once its visibility timeout expires.
+	settleCtx, settleCancel := context.WithTimeout(context.Background(), q.Visibility/3)
+	defer settleCancel()
+	if err == nil {
+		err = message.Ack(settleCtx)
+	} else {
+		klog.V(2).Infof("Message %s of queue %s failed attempt %d: %v", message.ID.Hex(), q.Name, message.Attempts, err)
+		err = message.Nack(settleCtx, err)
+	}
+	if err != nil {
+		klog.Warningf("Unable to settle message %s of queue %s: %v", message.ID.Hex(), q.Name, err)
+	}
+}
@@ -0,0 +1,224 @@
+// Package readiness checks continuously that a deployment can serve an application, reaching it and
+// the members of its replica set, and reports the outcome of the last check on a readiness endpoint
+// so that the pods of the application only receive traffic while the database is available.
+package readiness
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/description"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"k8s.io/klog"
+)
+
+// Options are the conditions under which the deployment is ready.
+type Options struct {
+	// Interval is the time between two checks.
+	Interval time.Duration
+	// Timeout bounds each check.
+	Timeout time.Duration
+	// RequirePrimary requires a primary that accepts writes, any reachable member is enough
+	// otherwise.
+	RequirePrimary bool
+	// MinSecondaries is the number of secondaries of a replica set that must be reachable.
+	MinSecondaries int
+	// FailureThreshold is the number of consecutive failed checks after which the deployment is
+	// no longer ready, so that a single slow check does not remove the pods from their Services.
+	FailureThreshold int
+}
+
+// Checker checks the deployment a client is connected to every interval.
+type Checker struct {
+	opts Options
+
+	lock     sync.Mutex
+	topology description.Topology
+	checked  bool
+	failures int
+	err      error
+	// kinds are the kinds of servers counted so far, reset to zero when they disappear.
+	kinds map[string]bool
+
+	ready       prometheus.Gauge
+	checks      *prometheus.CounterVec
+	duration    prometheus.Gauge
+	lastSuccess prometheus.Gauge
+	servers     *prometheus.GaugeVec
+}
+
+// NewChecker returns a checker of opts, whose ServerMonitor must be set on the client it checks.
+func NewChecker(opts Options) *Checker {
+	if opts.FailureThreshold < 1 {
+		opts.FailureThreshold = 1
+	}
+	return &Checker{
+		opts: opts,
+		ready: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "mongodb_client_readiness_ready",
+			Help: "Whether the deployment passed the last readiness checks (1) or not (0).",
+		}),
+		checks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "mongodb_client_readiness_checks_total",
+			Help: "Number of readiness checks, partitioned by result.",
+		}, []string{"result"}),
+		duration: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "mongodb_client_readiness_check_duration_seconds",
+			Help: "Duration of the last readiness check in seconds.",
+		}),
+		lastSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "mongodb_client_readiness_last_success_timestamp_seconds",
+			Help: "Unix time of the last successful readiness check.",
+		}),
+		servers: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "mongodb_client_readiness_servers",
+			Help: "Number of servers of the deployment known to the client, partitioned by kind.",
+		}, []string{"kind"}),
+		kinds: map[string]bool{},
+	}
+}
+
+// Collectors returns the metrics of the checker.
+func (c *Checker) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{c.ready, c.checks, c.duration, c.lastSuccess, c.servers}
+}
+
+// ServerMonitor returns the monitor recording the topology of the deployment as the client
+// discovers it, the state of the members of a replica set is read from it instead of commands
+// requiring privileges.
+func (c *Checker) ServerMonitor() *event.ServerMonitor {
+	return &event.ServerMonitor{
+		TopologyDescriptionChanged: func(e *event.TopologyDescriptionChangedEvent) {
+			c.lock.Lock()
+			defer c.lock.Unlock()
+			c.topology = e.NewDescription
+		},
+	}
+}
+
+// Run checks the deployment of client every interval until ctx is done.
+func (c *Checker) Run(ctx context.Context, client *mongo.Client) {
+	ticker := time.NewTicker(c.opts.Interval)
+	defer ticker.Stop()
+	for {
+		c.check(ctx, client)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// check checks the deployment once and records the outcome.
+func (c *Checker) check(ctx context.Context, client *mongo.Client) {
+	start := time.Now()
+	checkCtx, cancel := context.WithTimeout(ctx, c.opts.Timeout)
+	defer cancel()
+	err := c.Check(checkCtx, client)
+	if ctx.Err() != nil {
+		// Interrupted by the shutdown.
+		return
+	}
+	c.duration.Set(time.Since(start).Seconds())
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	wasReady := c.checked && c.err == nil
+	c.checked = true
+	if err != nil {
+		c.checks.WithLabelValues("failure").Inc()
+		c.failures++
+		if c.failures < c.opts.FailureThreshold && wasReady {
+			klog.V(2).Infof("Readiness check failed (%d of %d): %v", c.failures, c.opts.FailureThreshold, err)
+			return
+		}
+		if wasReady || c.err == nil || c.err.Error() != err.Error() {
+			klog.Warningf("The deployment is not ready: %v", err)
+		}
+		c.err = err
+		c.ready.Set(0)
+		return
+	}
+	c.checks.WithLabelValues("success").Inc()
+	c.lastSuccess.Set(float64(time.Now().Unix()))
+	if !wasReady {
+		klog.Infof("The deployment is ready")
+	}
+	c.failures = 0
+	c.err = nil
+	c.ready.Set(1)
+}
+
+// Check returns why the deployment of client is not ready, nil when it is: the primary, or any
+// member without RequirePrimary, must answer a ping, and enough secondaries must be reachable.
+func (c *Checker) Check(ctx context.Context, client *mongo.Client) error {
+	pref := readpref.Nearest()
+	if c.opts.RequirePrimary {
+		pref = readpref.Primary()
+	}
+	err := client.Ping(ctx, pref)
+	c.lock.Lock()
+	topology := c.topology
+	c.recordServers(topology)
+	c.lock.Unlock()
+	if err != nil {
+		return fmt.Errorf("unable to ping the deployment: %w", err)
+	}
+	if topology.Kind&description.ReplicaSet == 0 || c.opts.MinSecondaries == 0 {
+		return nil
+	}
+	secondaries := 0
+	for _, server := range topology.Servers {
+		if server.Kind == description.RSSecondary {
+			secondaries++
+		}
+	}
+	if secondaries < c.opts.MinSecondaries {
+		return fmt.Errorf("%d secondaries of replica set %s are reachable, %d are required", secondaries, topology.SetName, c.opts.MinSecondaries)
+	}
+	return nil
+}
+
+// recordServers sets the number of servers of each kind of topology, with the lock held.
+func (c *Checker) recordServers(topology description.Topology) {
+	counts := map[string]int{}
+	for _, server := range topology.Servers {
+		counts[server.Kind.String()]++
+	}
+	for kind := range c.kinds {
+		if _, ok := counts[kind]; !ok {
+			c.servers.WithLabelValues(kind).Set(0)
+		}
+	}
+	for kind, count := range counts {
+		c.kinds[kind] = true
+		c.servers.WithLabelValues(kind).Set(float64(count))
+	}
+}
+
+// Ready returns why the deployment is not ready after the last checks, nil when it is.
+func (c *Checker) Ready() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if !c.checked {
+		return fmt.Errorf("the deployment has not been checked yet")
+	}
+	return c.err
+}
+
+// ServeHTTP answers 200 while the deployment is ready, 503 with the reason otherwise.
+func (c *Checker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := c.Ready(); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok\n"))
+}
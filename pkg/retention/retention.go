@@ -0,0 +1,228 @@
+// Package retention purges the expired documents of collections, those older than a maximum age or
+// matching a filter, in rate limited batches, optionally archiving them to another collection
+// first. A policy based on the age of a date field alone can be enforced by a TTL index instead.
+package retention
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/bulk"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"k8s.io/klog"
+)
+
+var (
+	retentionPurgedDocuments = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mongodb_client_retention_purged_documents_total",
+		Help: "Number of expired documents deleted by each retention policy.",
+	}, []string{"policy"})
+	retentionArchivedDocuments = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mongodb_client_retention_archived_documents_total",
+		Help: "Number of expired documents copied to the archive collection by each retention policy.",
+	}, []string{"policy"})
+	retentionLastSuccessTimestamp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "mongodb_client_retention_last_success_timestamp_seconds",
+		Help: "Unix time of the last complete run of each retention policy.",
+	}, []string{"policy"})
+)
+
+func init() {
+	prometheus.MustRegister(retentionPurgedDocuments, retentionArchivedDocuments, retentionLastSuccessTimestamp)
+}
+
+// Policy selects the expired documents of a collection: those whose Field is older than MaxAge,
+// those matching Filter, or those matching both.
+type Policy struct {
+	// Name identifies the policy in the logs and the metrics.
+	Name       string
+	Database   string
+	Collection string
+	// Field is a date field, or _id whose ObjectIDs hold their creation time, and MaxAge is the age
+	// after which the documents expire.
+	Field  string
+	MaxAge time.Duration
+	Filter bson.D
+	// Archive is a collection of the same database the expired documents are copied to before they
+	// are deleted, when it is set.
+	Archive string
+	// BatchSize is the number of documents purged per request, 1000 when it is not set.
+	BatchSize int
+	// Rate limits the number of documents purged per second, when it is set, so that the purge does
+	// not compete with the application for the resources of the server.
+	Rate float64
+	// TTLIndex creates a TTL index on Field expiring the documents after MaxAge, with Filter as its
+	// partial filter expression, and leaves the deletes to the server instead.
+	TTLIndex bool
+}
+
+// Validate reports whether the policy is complete and consistent.
+func (p *Policy) Validate() error {
+	if len(p.Collection) == 0 {
+		return fmt.Errorf("a collection is required")
+	}
+	if (len(p.Field) == 0) != (p.MaxAge == 0) {
+		return fmt.Errorf("field and maxAge must be set together")
+	}
+	if p.MaxAge < 0 {
+		return fmt.Errorf("maxAge must not be negative")
+	}
+	if len(p.Field) == 0 && len(p.Filter) == 0 {
+		return fmt.Errorf("field and maxAge, or a filter, are required")
+	}
+	if p.BatchSize < 0 || p.Rate < 0 {
+		return fmt.Errorf("batchSize and rate must not be negative")
+	}
+	if p.Archive == p.Collection {
+		return fmt.Errorf("the archive collection must not be the collection itself")
+	}
+	if p.TTLIndex {
+		switch {
+		case len(p.Field) == 0:
+			return fmt.Errorf("ttlIndex requires field and maxAge")
+		case p.Field == "_id":
+			return fmt.Errorf("ttlIndex cannot expire documents by _id, TTL indexes require a date field")
+		case len(p.Archive) > 0:
+			return fmt.Errorf("ttlIndex cannot archive the documents it deletes")
+		case p.MaxAge < time.Second:
+			return fmt.Errorf("ttlIndex requires a maxAge of at least 1s")
+		}
+	}
+	return nil
+}
+
+// Run purges the documents of the policy that are expired at the time it starts, batch after
+// batch, or ensures its TTL index. It has the signature of a job handler.
+func (p *Policy) Run(ctx context.Context, c *mongo.Client) error {
+	collection := c.Database(p.Database).Collection(p.Collection)
+	if p.TTLIndex {
+		return p.ensureTTLIndex(ctx, collection)
+	}
+
+	filter := Expired(p.Field, p.MaxAge, p.Filter, time.Now())
+	batchSize := p.BatchSize
+	if batchSize < 1 {
+		batchSize = 1000
+	}
+	findOptions := options.Find().SetLimit(int64(batchSize)).SetSort(bson.D{{Key: "_id", Value: 1}})
+	if len(p.Archive) == 0 {
+		findOptions.SetProjection(bson.D{{Key: "_id", Value: 1}})
+	}
+	var purged int64
+	for {
+		started := time.Now()
+		cursor, err := collection.Find(ctx, filter, findOptions)
+		if err != nil {
+			return fmt.Errorf("unable to find the expired documents of %s: %w", p.Collection, err)
+		}
+		var docs []bson.Raw
+		if err := cursor.All(ctx, &docs); err != nil {
+			return fmt.Errorf("unable to read the expired documents of %s: %w", p.Collection, err)
+		}
+		if len(docs) == 0 {
+			break
+		}
+		ids := make(bson.A, 0, len(docs))
+		for _, doc := range docs {
+			ids = append(ids, doc.Lookup("_id"))
+		}
+		if len(p.Archive) > 0 {
+			if err := p.archive(ctx, c.Database(p.Database).Collection(p.Archive), docs); err != nil {
+				return err
+			}
+		}
+		result, err := collection.DeleteMany(ctx, bson.D{
+			{Key: "$and", Value: bson.A{filter, bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: ids}}}}}},
+		})
+		if err != nil {
+			return fmt.Errorf("unable to delete the expired documents of %s: %w", p.Collection, err)
+		}
+		purged += result.DeletedCount
+		retentionPurgedDocuments.WithLabelValues(p.Name).Add(float64(result.DeletedCount))
+		if len(docs) < batchSize {
+			break
+		}
+		if err := p.wait(ctx, started, len(docs)); err != nil {
+			return err
+		}
+	}
+	if purged > 0 {
+		klog.Infof("Retention policy %s purged %d documents of %s.%s", p.Name, purged, p.Database, p.Collection)
+	}
+	retentionLastSuccessTimestamp.WithLabelValues(p.Name).SetToCurrentTime()
+	return nil
+}
+
+// Expired returns the query of the documents whose field is older than maxAge at now, when field
+// is set, and that match filter, when it is set. The cutoff of _id is an ObjectID.
+func Expired(field string, maxAge time.Duration, filter bson.D, now time.Time) bson.D {
+	expired := bson.D{}
+	if len(field) > 0 {
+		var cutoff interface{} = now.Add(-maxAge)
+		if field == "_id" {
+			// The lowest ObjectID of the second of the cutoff, without the random and counter bytes.
+			var id primitive.ObjectID
+			binary.BigEndian.PutUint32(id[:4], uint32(now.Add(-maxAge).Unix()))
+			cutoff = id
+		}
+		expired = append(expired, bson.E{Key: field, Value: bson.D{{Key: "$lt", Value: cutoff}}})
+	}
+	if len(filter) > 0 {
+		expired = bson.D{{Key: "$and", Value: bson.A{expired, filter}}}
+	}
+	return expired
+}
+
+// archive copies docs to the archive collection, replacing the copies of a previous run that failed
+// before deleting them.
+func (p *Policy) archive(ctx context.Context, archive *mongo.Collection, docs []bson.Raw) error {
+	models := make([]mongo.WriteModel, 0, len(docs))
+	for _, doc := range docs {
+		models = append(models, mongo.NewReplaceOneModel().SetFilter(bson.D{{Key: "_id", Value: doc.Lookup("_id")}}).SetReplacement(doc).SetUpsert(true))
+	}
+	if _, err := bulk.Write(ctx, archive, models, bulk.Options{Ordered: true, BatchSize: len(models)}); err != nil {
+		return fmt.Errorf("unable to archive the expired documents of %s to %s: %w", p.Collection, p.Archive, err)
+	}
+	retentionArchivedDocuments.WithLabelValues(p.Name).Add(float64(len(docs)))
+	return nil
+}
+
+// wait delays the next batch so that count documents purged since started stay within the rate.
+func (p *Policy) wait(ctx context.Context, started time.Time, count int) error {
+	if p.Rate <= 0 {
+		return nil
+	}
+	delay := time.Duration(float64(count)/p.Rate*float64(time.Second)) - time.Since(started)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ensureTTLIndex creates the TTL index of the policy, an index of the same name with other options
+// fails rather than being replaced.
+func (p *Policy) ensureTTLIndex(ctx context.Context, collection *mongo.Collection) error {
+	indexOptions := options.Index().SetName("retention_" + p.Name).SetExpireAfterSeconds(int32(p.MaxAge / time.Second))
+	if len(p.Filter) > 0 {
+		indexOptions.SetPartialFilterExpression(p.Filter)
+	}
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{Key: p.Field, Value: 1}}, Options: indexOptions})
+	if err != nil {
+		return fmt.Errorf("unable to create the TTL index of %s: %w", p.Collection, err)
+	}
+	retentionLastSuccessTimestamp.WithLabelValues(p.Name).SetToCurrentTime()
+	return nil
+}
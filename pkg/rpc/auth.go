@@ -0,0 +1,91 @@
+package rpc
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/auth"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// writeMethods need read-write access to the database of their request, the others read access.
+var writeMethods = map[string]bool{
+	"/" + Documents_ServiceDesc.ServiceName + "/Insert": true,
+	"/" + Documents_ServiceDesc.ServiceName + "/Update": true,
+	"/" + Documents_ServiceDesc.ServiceName + "/Delete": true,
+}
+
+// request is implemented by every request of the Documents service.
+type request interface {
+	GetDatabase() string
+}
+
+// Authorization returns the server options requiring callers to authenticate with the credentials
+// accepted by authenticator, sent as the authorization metadata, and to hold a role granting access
+// to the database of their requests.
+func (s *Server) Authorization(authenticator *auth.Authenticator) []grpc.ServerOption {
+	authorize := func(ctx context.Context, method string, req interface{}) (context.Context, error) {
+		// The credentials are checked as those of an HTTP request with the same headers.
+		r := (&http.Request{Header: http.Header{}}).WithContext(ctx)
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			for _, value := range md.Get("authorization") {
+				r.Header.Add("Authorization", value)
+			}
+		}
+		identity, err := authenticator.Authenticate(r)
+		if err != nil {
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+		permission := auth.Permission{Write: writeMethods[method]}
+		if req, ok := req.(request); ok {
+			permission.Database = s.database(req.GetDatabase())
+		}
+		if !authenticator.Authorize(identity, permission) {
+			return nil, status.Errorf(codes.PermissionDenied, "%s may not access database %s", identity.Name, permission.Database)
+		}
+		return auth.NewContext(ctx, identity), nil
+	}
+
+	unary := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := authorize(ctx, info.FullMethod, req)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+	stream := func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &authorizedStream{ServerStream: ss, method: info.FullMethod, authorize: authorize})
+	}
+	return []grpc.ServerOption{grpc.ChainUnaryInterceptor(unary), grpc.ChainStreamInterceptor(stream)}
+}
+
+// authorizedStream authorizes the request of a server streaming call when it is received, since the
+// database is only known then.
+type authorizedStream struct {
+	grpc.ServerStream
+	method    string
+	authorize func(ctx context.Context, method string, req interface{}) (context.Context, error)
+	ctx       context.Context
+}
+
+func (s *authorizedStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	ctx, err := s.authorize(s.ServerStream.Context(), s.method, m)
+	if err != nil {
+		return err
+	}
+	s.ctx = ctx
+	return nil
+}
+
+func (s *authorizedStream) Context() context.Context {
+	if s.ctx != nil {
+		return s.ctx
+	}
+	return s.ServerStream.Context()
+}
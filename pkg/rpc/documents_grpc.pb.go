@@ -0,0 +1,314 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             v3.21.12
+// source: documents.proto
+
+package rpc
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// DocumentsClient is the client API for Documents service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type DocumentsClient interface {
+	// Query streams the documents of a collection matching a filter.
+	Query(ctx context.Context, in *QueryRequest, opts ...grpc.CallOption) (Documents_QueryClient, error)
+	// Insert inserts documents into a collection.
+	Insert(ctx context.Context, in *InsertRequest, opts ...grpc.CallOption) (*InsertResponse, error)
+	// Update applies an update or replaces the documents matching a filter.
+	Update(ctx context.Context, in *UpdateRequest, opts ...grpc.CallOption) (*UpdateResponse, error)
+	// Delete deletes the documents matching a filter.
+	Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error)
+	// Watch streams the changes of a collection, or of a database when collection is empty.
+	Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (Documents_WatchClient, error)
+}
+
+type documentsClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewDocumentsClient(cc grpc.ClientConnInterface) DocumentsClient {
+	return &documentsClient{cc}
+}
+
+func (c *documentsClient) Query(ctx context.Context, in *QueryRequest, opts ...grpc.CallOption) (Documents_QueryClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Documents_ServiceDesc.Streams[0], "/mongodbclient.v1.Documents/Query", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &documentsQueryClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Documents_QueryClient interface {
+	Recv() (*Document, error)
+	grpc.ClientStream
+}
+
+type documentsQueryClient struct {
+	grpc.ClientStream
+}
+
+func (x *documentsQueryClient) Recv() (*Document, error) {
+	m := new(Document)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *documentsClient) Insert(ctx context.Context, in *InsertRequest, opts ...grpc.CallOption) (*InsertResponse, error) {
+	out := new(InsertResponse)
+	err := c.cc.Invoke(ctx, "/mongodbclient.v1.Documents/Insert", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *documentsClient) Update(ctx context.Context, in *UpdateRequest, opts ...grpc.CallOption) (*UpdateResponse, error) {
+	out := new(UpdateResponse)
+	err := c.cc.Invoke(ctx, "/mongodbclient.v1.Documents/Update", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *documentsClient) Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error) {
+	out := new(DeleteResponse)
+	err := c.cc.Invoke(ctx, "/mongodbclient.v1.Documents/Delete", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *documentsClient) Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (Documents_WatchClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Documents_ServiceDesc.Streams[1], "/mongodbclient.v1.Documents/Watch", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &documentsWatchClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Documents_WatchClient interface {
+	Recv() (*ChangeEvent, error)
+	grpc.ClientStream
+}
+
+type documentsWatchClient struct {
+	grpc.ClientStream
+}
+
+func (x *documentsWatchClient) Recv() (*ChangeEvent, error) {
+	m := new(ChangeEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// DocumentsServer is the server API for Documents service.
+// All implementations must embed UnimplementedDocumentsServer
+// for forward compatibility
+type DocumentsServer interface {
+	// Query streams the documents of a collection matching a filter.
+	Query(*QueryRequest, Documents_QueryServer) error
+	// Insert inserts documents into a collection.
+	Insert(context.Context, *InsertRequest) (*InsertResponse, error)
+	// Update applies an update or replaces the documents matching a filter.
+	Update(context.Context, *UpdateRequest) (*UpdateResponse, error)
+	// Delete deletes the documents matching a filter.
+	Delete(context.Context, *DeleteRequest) (*DeleteResponse, error)
+	// Watch streams the changes of a collection, or of a database when collection is empty.
+	Watch(*WatchRequest, Documents_WatchServer) error
+	mustEmbedUnimplementedDocumentsServer()
+}
+
+// UnimplementedDocumentsServer must be embedded to have forward compatible implementations.
+type UnimplementedDocumentsServer struct {
+}
+
+func (UnimplementedDocumentsServer) Query(*QueryRequest, Documents_QueryServer) error {
+	return status.Errorf(codes.Unimplemented, "method Query not implemented")
+}
+func (UnimplementedDocumentsServer) Insert(context.Context, *InsertRequest) (*InsertResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Insert not implemented")
+}
+func (UnimplementedDocumentsServer) Update(context.Context, *UpdateRequest) (*UpdateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Update not implemented")
+}
+func (UnimplementedDocumentsServer) Delete(context.Context, *DeleteRequest) (*DeleteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Delete not implemented")
+}
+func (UnimplementedDocumentsServer) Watch(*WatchRequest, Documents_WatchServer) error {
+	return status.Errorf(codes.Unimplemented, "method Watch not implemented")
+}
+func (UnimplementedDocumentsServer) mustEmbedUnimplementedDocumentsServer() {}
+
+// UnsafeDocumentsServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to DocumentsServer will
+// result in compilation errors.
+type UnsafeDocumentsServer interface {
+	mustEmbedUnimplementedDocumentsServer()
+}
+
+func RegisterDocumentsServer(s grpc.ServiceRegistrar, srv DocumentsServer) {
+	s.RegisterService(&Documents_ServiceDesc, srv)
+}
+
+func _Documents_Query_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(QueryRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DocumentsServer).Query(m, &documentsQueryServer{stream})
+}
+
+type Documents_QueryServer interface {
+	Send(*Document) error
+	grpc.ServerStream
+}
+
+type documentsQueryServer struct {
+	grpc.ServerStream
+}
+
+func (x *documentsQueryServer) Send(m *Document) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Documents_Insert_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(InsertRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DocumentsServer).Insert(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/mongodbclient.v1.Documents/Insert",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DocumentsServer).Insert(ctx, req.(*InsertRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Documents_Update_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DocumentsServer).Update(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/mongodbclient.v1.Documents/Update",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DocumentsServer).Update(ctx, req.(*UpdateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Documents_Delete_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DocumentsServer).Delete(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/mongodbclient.v1.Documents/Delete",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DocumentsServer).Delete(ctx, req.(*DeleteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Documents_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DocumentsServer).Watch(m, &documentsWatchServer{stream})
+}
+
+type Documents_WatchServer interface {
+	Send(*ChangeEvent) error
+	grpc.ServerStream
+}
+
+type documentsWatchServer struct {
+	grpc.ServerStream
+}
+
+func (x *documentsWatchServer) Send(m *ChangeEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Documents_ServiceDesc is the grpc.ServiceDesc for Documents service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Documents_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "mongodbclient.v1.Documents",
+	HandlerType: (*DocumentsServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Insert",
+			Handler:    _Documents_Insert_Handler,
+		},
+		{
+			MethodName: "Update",
+			Handler:    _Documents_Update_Handler,
+		},
+		{
+			MethodName: "Delete",
+			Handler:    _Documents_Delete_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Query",
+			Handler:       _Documents_Query_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Watch",
+			Handler:       _Documents_Watch_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "documents.proto",
+}
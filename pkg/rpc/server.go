@@ -0,0 +1,462 @@
+// Package rpc serves the document operations of documents.proto over gRPC, so that services in
+// any language can use the client as a typed data access gateway.
+package rpc
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative documents.proto
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/api"
+	"github.com/bradmwilliams/mongodb-client/pkg/client"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog"
+)
+
+// CausalTokenMetadata is the metadata key of the causal token of a call: the calls run in causally
+// consistent sessions, advanced to the token received from the caller, whose token is returned in
+// the trailer. Callers passing the token of a call to the next read their own writes and never read
+// older data than they did.
+const CausalTokenMetadata = "causal-token"
+
+// Server implements the Documents service on the databases of a connection manager.
+type Server struct {
+	UnimplementedDocumentsServer
+	manager *client.ConnectionManager
+
+	// CheckEmpty, when it is set, is called before a request with multi and the filter {} updates
+	// or deletes every document of a collection, the request is denied when it returns an error.
+	CheckEmpty func(database, collection string) error
+}
+
+// NewServer returns a Documents service for the databases of manager.
+func NewServer(manager *client.ConnectionManager) *Server {
+	return &Server{manager: manager}
+}
+
+// database returns the database of a request, the application database when it is empty.
+func (s *Server) database(name string) string {
+	if len(name) == 0 {
+		return s.manager.Database()
+	}
+	return name
+}
+
+// collection returns the collection targeted by a request.
+func (s *Server) collection(database, collection string) (*mongo.Collection, error) {
+	if !s.manager.Connected() {
+		return nil, status.Error(codes.Unavailable, "not connected to database")
+	}
+	database = s.database(database)
+	if err := api.CheckNamespace(database, collection); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return s.manager.Primary().Database(database).Collection(collection), nil
+}
+
+// checkEmpty returns why every document of collection may not be updated or deleted, nil when
+// they may.
+func (s *Server) checkEmpty(collection *mongo.Collection) error {
+	if s.CheckEmpty == nil {
+		return nil
+	}
+	if err := s.CheckEmpty(collection.Database().Name(), collection.Name()); err != nil {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	return nil
+}
+
+// session runs fn in a causally consistent session advanced to the causal token of the metadata of
+// ctx, and returns the token of the session with setTrailer.
+func (s *Server) session(ctx context.Context, setTrailer func(metadata.MD) error, fn func(sess mongo.SessionContext) error) error {
+	var after *client.CausalToken
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(CausalTokenMetadata); len(values) > 0 {
+			var err error
+			if after, err = client.ParseCausalToken(values[0]); err != nil {
+				return status.Error(codes.InvalidArgument, err.Error())
+			}
+		}
+	}
+	token, err := s.manager.WithSession(ctx, after, fn)
+	if token != nil {
+		if trailerErr := setTrailer(metadata.Pairs(CausalTokenMetadata, token.String())); trailerErr != nil {
+			klog.Warningf("Unable to set the causal token trailer: %v", trailerErr)
+		}
+	}
+	return err
+}
+
+// unaryTrailer sets the trailer of the unary call of ctx.
+func unaryTrailer(ctx context.Context) func(metadata.MD) error {
+	return func(md metadata.MD) error {
+		return grpc.SetTrailer(ctx, md)
+	}
+}
+
+// parse parses the Extended JSON document of the request field name, empty when value is.
+func parse(name, value string) (bson.D, error) {
+	doc := bson.D{}
+	if len(value) == 0 {
+		return doc, nil
+	}
+	if err := bson.UnmarshalExtJSON([]byte(value), false, &doc); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid %s: %v", name, err)
+	}
+	return doc, nil
+}
+
+// marshalValue returns the canonical Extended JSON of a single value.
+func marshalValue(value interface{}) (string, error) {
+	data, err := bson.MarshalExtJSON(bson.D{{Key: "v", Value: value}}, true, false)
+	if err != nil {
+		return "", err
+	}
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return "", err
+	}
+	return string(doc["v"]), nil
+}
+
+// marshalRaw returns the canonical Extended JSON of a document, empty when it is missing.
+func marshalRaw(raw bson.Raw) (string, error) {
+	if raw == nil {
+		return "", nil
+	}
+	data, err := bson.MarshalExtJSON(raw, true, false)
+	return string(data), err
+}
+
+// statusError converts the errors of the driver to the status codes they stand for.
+func statusError(method string, err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	var commandErr mongo.CommandError
+	var writeErr mongo.WriteException
+	code := codes.Internal
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		code = codes.NotFound
+	case mongo.IsDuplicateKeyError(err):
+		code = codes.AlreadyExists
+	case errors.Is(err, context.Canceled):
+		code = codes.Canceled
+	case errors.Is(err, context.DeadlineExceeded) || mongo.IsTimeout(err):
+		code = codes.DeadlineExceeded
+	case errors.As(err, &commandErr) && commandErr.Code == 2: // BadValue
+		code = codes.InvalidArgument
+	case errors.As(err, &writeErr):
+		code = codes.InvalidArgument
+	case mongo.IsNetworkError(err):
+		code = codes.Unavailable
+	}
+	if code == codes.Internal {
+		klog.Errorf("%s failed: %v", method, err)
+	}
+	return status.Error(code, err.Error())
+}
+
+// Query streams the documents of a collection matching a filter.
+func (s *Server) Query(req *QueryRequest, stream Documents_QueryServer) error {
+	collection, err := s.collection(req.Database, req.Collection)
+	if err != nil {
+		return err
+	}
+	filter, err := parse("filter", req.Filter)
+	if err != nil {
+		return err
+	}
+	sort, err := parse("sort", req.Sort)
+	if err != nil {
+		return err
+	}
+	projection, err := parse("projection", req.Projection)
+	if err != nil {
+		return err
+	}
+	if req.Limit < 0 || req.Skip < 0 {
+		return status.Error(codes.InvalidArgument, "limit and skip must not be negative")
+	}
+	findOptions := options.Find().SetLimit(req.Limit).SetSkip(req.Skip)
+	if len(sort) > 0 {
+		findOptions.SetSort(sort)
+	}
+	if len(projection) > 0 {
+		findOptions.SetProjection(projection)
+	}
+
+	// Results are streamed for as long as the caller reads them, only the operation timeout of
+	// the first batch applies.
+	trailer := func(md metadata.MD) error {
+		stream.SetTrailer(md)
+		return nil
+	}
+	return s.session(stream.Context(), trailer, func(ctx mongo.SessionContext) error {
+		findCtx, cancel := context.WithTimeout(ctx, s.manager.Timeout(client.ReadOperation))
+		defer cancel()
+		findOptions.MaxTime = client.MaxTime(findCtx)
+		cursor, err := collection.Find(findCtx, filter, findOptions)
+		if err != nil {
+			return statusError("Query", err)
+		}
+		defer cursor.Close(context.Background())
+		for cursor.Next(ctx) {
+			doc, err := marshalRaw(cursor.Current)
+			if err != nil {
+				return statusError("Query", err)
+			}
+			if err := stream.Send(&Document{Json: doc}); err != nil {
+				return err
+			}
+		}
+		if err := cursor.Err(); err != nil {
+			return statusError("Query", err)
+		}
+		return nil
+	})
+}
+
+// Insert inserts documents into a collection.
+func (s *Server) Insert(ctx context.Context, req *InsertRequest) (*InsertResponse, error) {
+	collection, err := s.collection(req.Database, req.Collection)
+	if err != nil {
+		return nil, err
+	}
+	if len(req.Documents) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "no documents to insert")
+	}
+	docs := make([]interface{}, 0, len(req.Documents))
+	for i, document := range req.Documents {
+		doc := bson.D{}
+		if err := bson.UnmarshalExtJSON([]byte(document.Json), false, &doc); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid document %d: %v", i, err)
+		}
+		docs = append(docs, doc)
+	}
+
+	opCtx, cancel := context.WithTimeout(ctx, s.manager.Timeout(client.WriteOperation))
+	defer cancel()
+	var result *mongo.InsertManyResult
+	err = s.session(opCtx, unaryTrailer(ctx), func(sess mongo.SessionContext) error {
+		result, err = collection.InsertMany(sess, docs, options.InsertMany().SetOrdered(req.Ordered))
+		return err
+	})
+	if err != nil {
+		return nil, statusError("Insert", err)
+	}
+	response := &InsertResponse{}
+	for _, id := range result.InsertedIDs {
+		value, err := marshalValue(id)
+		if err != nil {
+			return nil, statusError("Insert", err)
+		}
+		response.InsertedIds = append(response.InsertedIds, value)
+	}
+	return response, nil
+}
+
+// Update applies an update or replaces the documents matching a filter.
+func (s *Server) Update(ctx context.Context, req *UpdateRequest) (*UpdateResponse, error) {
+	collection, err := s.collection(req.Database, req.Collection)
+	if err != nil {
+		return nil, err
+	}
+	if len(req.Filter) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "a filter is required, {} matches every document")
+	}
+	filter, err := parse("filter", req.Filter)
+	if err != nil {
+		return nil, err
+	}
+	update, err := parse("update", req.Update)
+	if err != nil {
+		return nil, err
+	}
+	if len(update) == 0 && !req.Replace {
+		return nil, status.Error(codes.InvalidArgument, "empty update")
+	}
+	if len(filter) == 0 && req.Multi {
+		if err := s.checkEmpty(collection); err != nil {
+			return nil, err
+		}
+	}
+
+	if req.Replace && req.Multi {
+		return nil, status.Error(codes.InvalidArgument, "replace and multi are mutually exclusive")
+	}
+	// Updates without update operators set the given fields, as with the REST API.
+	if !req.Replace && !strings.HasPrefix(update[0].Key, "$") {
+		update = bson.D{{Key: "$set", Value: update}}
+	}
+
+	opCtx, cancel := context.WithTimeout(ctx, s.manager.Timeout(client.WriteOperation))
+	defer cancel()
+	var result *mongo.UpdateResult
+	err = s.session(opCtx, unaryTrailer(ctx), func(sess mongo.SessionContext) error {
+		switch {
+		case req.Replace:
+			result, err = collection.ReplaceOne(sess, filter, update, options.Replace().SetUpsert(req.Upsert))
+		case req.Multi:
+			result, err = collection.UpdateMany(sess, filter, update, options.Update().SetUpsert(req.Upsert))
+		default:
+			result, err = collection.UpdateOne(sess, filter, update, options.Update().SetUpsert(req.Upsert))
+		}
+		return err
+	})
+	if err != nil {
+		return nil, statusError("Update", err)
+	}
+	response := &UpdateResponse{MatchedCount: result.MatchedCount, ModifiedCount: result.ModifiedCount}
+	if result.UpsertedID != nil {
+		if response.UpsertedId, err = marshalValue(result.UpsertedID); err != nil {
+			return nil, statusError("Update", err)
+		}
+	}
+	return response, nil
+}
+
+// Delete deletes the documents matching a filter.
+func (s *Server) Delete(ctx context.Context, req *DeleteRequest) (*DeleteResponse, error) {
+	collection, err := s.collection(req.Database, req.Collection)
+	if err != nil {
+		return nil, err
+	}
+	if len(req.Filter) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "a filter is required, {} matches every document")
+	}
+	filter, err := parse("filter", req.Filter)
+	if err != nil {
+		return nil, err
+	}
+	if len(filter) == 0 && req.Multi {
+		if err := s.checkEmpty(collection); err != nil {
+			return nil, err
+		}
+	}
+
+	opCtx, cancel := context.WithTimeout(ctx, s.manager.Timeout(client.WriteOperation))
+	defer cancel()
+	var result *mongo.DeleteResult
+	err = s.session(opCtx, unaryTrailer(ctx), func(sess mongo.SessionContext) error {
+		if req.Multi {
+			result, err = collection.DeleteMany(sess, filter)
+		} else {
+			result, err = collection.DeleteOne(sess, filter)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, statusError("Delete", err)
+	}
+	return &DeleteResponse{DeletedCount: result.DeletedCount}, nil
+}
+
+// changeEvent holds the fields of a change event sent to the callers of Watch.
+type changeEvent struct {
+	ID            bson.Raw `bson:"_id"`
+	OperationType string   `bson:"operationType"`
+	Namespace     struct {
+		Database   string `bson:"db"`
+		Collection string `bson:"coll"`
+	} `bson:"ns"`
+	DocumentKey       bson.Raw            `bson:"documentKey"`
+	FullDocument      bson.Raw            `bson:"fullDocument"`
+	UpdateDescription bson.Raw            `bson:"updateDescription"`
+	ClusterTime       primitive.Timestamp `bson:"clusterTime"`
+}
+
+// Watch streams the changes of a collection, or of a database when collection is empty.
+func (s *Server) Watch(req *WatchRequest, stream Documents_WatchServer) error {
+	if !s.manager.Connected() {
+		return status.Error(codes.Unavailable, "not connected to database")
+	}
+	database := s.database(req.Database)
+	collection := req.Collection
+	if len(collection) == 0 {
+		// Only the database name is checked.
+		collection = "-"
+	}
+	if err := api.CheckNamespace(database, collection); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	pipeline := []bson.D{}
+	if len(req.Pipeline) > 0 {
+		var wrapper struct {
+			Pipeline []bson.D `bson:"pipeline"`
+		}
+		if err := bson.UnmarshalExtJSON([]byte(`{"pipeline":`+req.Pipeline+`}`), false, &wrapper); err != nil {
+			return status.Errorf(codes.InvalidArgument, "pipeline must be an array of stages: %v", err)
+		}
+		pipeline = wrapper.Pipeline
+	}
+	streamOptions := options.ChangeStream()
+	if len(req.FullDocument) > 0 {
+		streamOptions.SetFullDocument(options.FullDocument(req.FullDocument))
+	}
+	if len(req.ResumeAfter) > 0 {
+		token, err := parse("resume_after", req.ResumeAfter)
+		if err != nil {
+			return err
+		}
+		streamOptions.SetResumeAfter(token)
+	}
+
+	ctx := stream.Context()
+	db := s.manager.Primary().Database(database)
+	var changes *mongo.ChangeStream
+	var err error
+	if len(req.Collection) == 0 {
+		changes, err = db.Watch(ctx, pipeline, streamOptions)
+	} else {
+		changes, err = db.Collection(req.Collection).Watch(ctx, pipeline, streamOptions)
+	}
+	if err != nil {
+		return statusError("Watch", err)
+	}
+	defer changes.Close(context.Background())
+	for changes.Next(ctx) {
+		var event changeEvent
+		if err := changes.Decode(&event); err != nil {
+			return statusError("Watch", err)
+		}
+		message := &ChangeEvent{
+			OperationType:        event.OperationType,
+			Database:             event.Namespace.Database,
+			Collection:           event.Namespace.Collection,
+			ClusterTimeSeconds:   event.ClusterTime.T,
+			ClusterTimeIncrement: event.ClusterTime.I,
+		}
+		for _, field := range []struct {
+			raw bson.Raw
+			out *string
+		}{
+			{event.ID, &message.ResumeToken},
+			{event.DocumentKey, &message.DocumentKey},
+			{event.FullDocument, &message.FullDocument},
+			{event.UpdateDescription, &message.UpdateDescription},
+		} {
+			if *field.out, err = marshalRaw(field.raw); err != nil {
+				return statusError("Watch", err)
+			}
+		}
+		if err := stream.Send(message); err != nil {
+			return err
+		}
+	}
+	if err := changes.Err(); err != nil {
+		return statusError("Watch", err)
+	}
+	return nil
+}
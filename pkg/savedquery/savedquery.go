@@ -0,0 +1,324 @@
+// Package savedquery stores named, parameterized queries and aggregation pipelines, so that teams
+// share vetted queries instead of copying their JSON around. Every save of a query adds a version,
+// the previous versions are kept, and queries may be restricted to the users holding given roles.
+package savedquery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrNotFound is returned when a query or a version of a query is not saved.
+var ErrNotFound = errors.New("saved query not found")
+
+// ParamTypes are the types of the parameters of queries.
+var ParamTypes = []string{"string", "int", "long", "double", "decimal", "bool", "date", "objectId"}
+
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// Query is a version of a saved query. It is either a find, with a Filter, Sort, Projection and
+// Limit, or an aggregation Pipeline. The documents are Extended JSON in which {"$param": "NAME"}
+// is replaced by the value of the parameter NAME when the query is run.
+type Query struct {
+	Name        string `json:"name" bson:"name"`
+	Version     int    `json:"version" bson:"version"`
+	Description string `json:"description,omitempty" bson:"description,omitempty"`
+	// Database defaults to the database of the client.
+	Database   string `json:"database,omitempty" bson:"database,omitempty"`
+	Collection string `json:"collection" bson:"collection"`
+	Filter     string `json:"filter,omitempty" bson:"filter,omitempty"`
+	Sort       string `json:"sort,omitempty" bson:"sort,omitempty"`
+	Projection string `json:"projection,omitempty" bson:"projection,omitempty"`
+	Limit      int64  `json:"limit,omitempty" bson:"limit,omitempty"`
+	// Pipeline is a JSON array of aggregation stages.
+	Pipeline string  `json:"pipeline,omitempty" bson:"pipeline,omitempty"`
+	Params   []Param `json:"params,omitempty" bson:"params,omitempty"`
+	// Tags label queries, such as the team owning them, to find them in listings.
+	Tags []string `json:"tags,omitempty" bson:"tags,omitempty"`
+	// Roles restrict running the query to the users holding one of them, written role or role@db.
+	Roles     []string  `json:"roles,omitempty" bson:"roles,omitempty"`
+	CreatedBy string    `json:"createdBy,omitempty" bson:"createdBy,omitempty"`
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
+}
+
+// Param is a parameter of a query. Parameters without a default are required.
+type Param struct {
+	Name        string  `json:"name" bson:"name"`
+	Type        string  `json:"type" bson:"type"`
+	Default     *string `json:"default,omitempty" bson:"default,omitempty"`
+	Description string  `json:"description,omitempty" bson:"description,omitempty"`
+}
+
+// Bound holds the documents of a query of which the parameters are replaced by their value.
+type Bound struct {
+	Filter     bson.D
+	Sort       bson.D
+	Projection bson.D
+	Pipeline   []bson.D
+}
+
+// Validate reports whether the query is complete and its documents only refer to its parameters.
+func (q *Query) Validate() error {
+	if !validName.MatchString(q.Name) {
+		return fmt.Errorf("invalid query name %q, expected letters, digits, '.', '_' and '-'", q.Name)
+	}
+	if len(q.Collection) == 0 {
+		return fmt.Errorf("query %s has no collection", q.Name)
+	}
+	if len(q.Pipeline) > 0 && (len(q.Filter) > 0 || len(q.Sort) > 0 || len(q.Projection) > 0 || q.Limit != 0) {
+		return fmt.Errorf("query %s must be either a pipeline or a find with a filter, sort, projection and limit", q.Name)
+	}
+	if q.Limit < 0 {
+		return fmt.Errorf("query %s has a negative limit", q.Name)
+	}
+	names := map[string]bool{}
+	for _, param := range q.Params {
+		if !validName.MatchString(param.Name) {
+			return fmt.Errorf("invalid parameter name %q", param.Name)
+		}
+		if names[param.Name] {
+			return fmt.Errorf("parameter %s is declared twice", param.Name)
+		}
+		names[param.Name] = true
+		if !validType(param.Type) {
+			return fmt.Errorf("parameter %s has an invalid type %q, must be one of %s", param.Name, param.Type, strings.Join(ParamTypes, ", "))
+		}
+	}
+	for _, role := range q.Roles {
+		if len(role) == 0 || strings.HasPrefix(role, "@") || strings.HasSuffix(role, "@") {
+			return fmt.Errorf("invalid role %q, expected role or role@db", role)
+		}
+	}
+	_, err := q.BindDefaults()
+	return err
+}
+
+func validType(name string) bool {
+	for _, t := range ParamTypes {
+		if t == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Convert returns the text of a value of the parameter as a BSON value of its type. Dates are
+// RFC 3339 times or days written 2006-01-02, in UTC.
+func (p Param) Convert(text string) (interface{}, error) {
+	switch p.Type {
+	case "string":
+		return text, nil
+	case "int":
+		n, err := strconv.ParseInt(text, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an int", text)
+		}
+		return int32(n), nil
+	case "long":
+		n, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a long", text)
+		}
+		return n, nil
+	case "double":
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a double", text)
+		}
+		return f, nil
+	case "decimal":
+		d, err := primitive.ParseDecimal128(text)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a decimal", text)
+		}
+		return d, nil
+	case "bool":
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a bool", text)
+		}
+		return b, nil
+	case "date":
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02"} {
+			if t, err := time.Parse(layout, text); err == nil {
+				return primitive.NewDateTimeFromTime(t), nil
+			}
+		}
+		return nil, fmt.Errorf("%q is not a date, expected 2006-01-02 or 2006-01-02T15:04:05Z", text)
+	case "objectId":
+		id, err := primitive.ObjectIDFromHex(text)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an ObjectId", text)
+		}
+		return id, nil
+	}
+	return nil, fmt.Errorf("unknown parameter type %q", p.Type)
+}
+
+// Bind returns the documents of the query with the parameters replaced by their value in values,
+// or their default. Values of parameters the query does not declare are rejected.
+func (q *Query) Bind(values map[string]string) (*Bound, error) {
+	declared := make(map[string]bool, len(q.Params))
+	converted := make(map[string]interface{}, len(q.Params))
+	for _, param := range q.Params {
+		declared[param.Name] = true
+		text, ok := values[param.Name]
+		if !ok {
+			if param.Default == nil {
+				return nil, fmt.Errorf("parameter %s of query %s is required", param.Name, q.Name)
+			}
+			text = *param.Default
+		}
+		value, err := param.Convert(text)
+		if err != nil {
+			return nil, fmt.Errorf("parameter %s: %w", param.Name, err)
+		}
+		converted[param.Name] = value
+	}
+	for name := range values {
+		if !declared[name] {
+			return nil, fmt.Errorf("query %s has no parameter %s", q.Name, name)
+		}
+	}
+	return q.bind(converted)
+}
+
+// BindDefaults returns the documents of the query with the parameters bound to their default, or
+// null when they have none, for the checks that do not depend on their values.
+func (q *Query) BindDefaults() (*Bound, error) {
+	values := make(map[string]interface{}, len(q.Params))
+	for _, param := range q.Params {
+		values[param.Name] = nil
+		if param.Default != nil {
+			value, err := param.Convert(*param.Default)
+			if err != nil {
+				return nil, fmt.Errorf("default of parameter %s: %w", param.Name, err)
+			}
+			values[param.Name] = value
+		}
+	}
+	return q.bind(values)
+}
+
+func (q *Query) bind(values map[string]interface{}) (*Bound, error) {
+	bound := &Bound{}
+	var err error
+	if bound.Filter, err = bindDocument(q.Filter, values); err != nil {
+		return nil, fmt.Errorf("filter: %w", err)
+	}
+	if bound.Sort, err = bindDocument(q.Sort, values); err != nil {
+		return nil, fmt.Errorf("sort: %w", err)
+	}
+	if bound.Projection, err = bindDocument(q.Projection, values); err != nil {
+		return nil, fmt.Errorf("projection: %w", err)
+	}
+	if len(q.Pipeline) > 0 {
+		var stages []json.RawMessage
+		if err := json.Unmarshal([]byte(q.Pipeline), &stages); err != nil {
+			return nil, fmt.Errorf("pipeline must be a JSON array of stages: %w", err)
+		}
+		for i, stage := range stages {
+			doc, err := bindDocument(string(stage), values)
+			if err != nil {
+				return nil, fmt.Errorf("stage %d: %w", i, err)
+			}
+			bound.Pipeline = append(bound.Pipeline, doc)
+		}
+	}
+	return bound, nil
+}
+
+// bindDocument parses the Extended JSON document text, nil when it is empty, and replaces its
+// parameters.
+func bindDocument(text string, values map[string]interface{}) (bson.D, error) {
+	if len(strings.TrimSpace(text)) == 0 {
+		return nil, nil
+	}
+	var doc bson.D
+	if err := bson.UnmarshalExtJSON([]byte(text), false, &doc); err != nil {
+		return nil, fmt.Errorf("invalid JSON document: %w", err)
+	}
+	replaced, err := replaceParams(doc, values)
+	if err != nil {
+		return nil, err
+	}
+	if d, ok := replaced.(bson.D); ok {
+		return d, nil
+	}
+	return nil, fmt.Errorf("the document can not be a parameter")
+}
+
+// replaceParams returns value with the documents {"$param": "NAME"} replaced by the value of NAME.
+func replaceParams(value interface{}, values map[string]interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case bson.D:
+		if len(v) == 1 && v[0].Key == "$param" {
+			name, ok := v[0].Value.(string)
+			if !ok {
+				return nil, fmt.Errorf("$param must be the name of a parameter")
+			}
+			param, ok := values[name]
+			if !ok {
+				return nil, fmt.Errorf("parameter %s is not declared", name)
+			}
+			return param, nil
+		}
+		replaced := make(bson.D, 0, len(v))
+		for _, e := range v {
+			r, err := replaceParams(e.Value, values)
+			if err != nil {
+				return nil, err
+			}
+			replaced = append(replaced, bson.E{Key: e.Key, Value: r})
+		}
+		return replaced, nil
+	case bson.A:
+		replaced := make(bson.A, 0, len(v))
+		for _, e := range v {
+			r, err := replaceParams(e, values)
+			if err != nil {
+				return nil, err
+			}
+			replaced = append(replaced, r)
+		}
+		return replaced, nil
+	}
+	return value, nil
+}
+
+// Authorize returns an error unless the query has no roles or the user authenticated by c holds one
+// of them. A role without a database matches the role of any database.
+func Authorize(ctx context.Context, c *mongo.Client, q *Query) error {
+	if len(q.Roles) == 0 {
+		return nil
+	}
+	var status struct {
+		AuthInfo struct {
+			Roles []struct {
+				Role     string `bson:"role"`
+				Database string `bson:"db"`
+			} `bson:"authenticatedUserRoles"`
+		} `bson:"authInfo"`
+	}
+	if err := c.Database("admin").RunCommand(ctx, bson.D{{Key: "connectionStatus", Value: 1}}).Decode(&status); err != nil {
+		return fmt.Errorf("unable to read the roles of the connection: %w", err)
+	}
+	for _, required := range q.Roles {
+		for _, held := range status.AuthInfo.Roles {
+			if required == held.Role || required == held.Role+"@"+held.Database {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("query %s requires one of the roles %s", q.Name, strings.Join(q.Roles, ", "))
+}
@@ -0,0 +1,273 @@
+package savedquery
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"sigs.k8s.io/yaml"
+)
+
+// Store holds the versions of saved queries.
+type Store interface {
+	// Save validates q and saves it as the next version of its name, which is set in q.
+	Save(ctx context.Context, q *Query) error
+	// Get returns a version of the query called name, the latest when version is zero.
+	Get(ctx context.Context, name string, version int) (*Query, error)
+	// List returns the latest version of every query, sorted by name.
+	List(ctx context.Context) ([]Query, error)
+	// History returns the versions of the query called name, the latest first.
+	History(ctx context.Context, name string) ([]Query, error)
+	// Delete removes every version of the query called name and returns how many were removed.
+	Delete(ctx context.Context, name string) (int, error)
+}
+
+// CollectionStore stores the queries in a collection, a document per version. Who may save and
+// delete queries is controlled by the privileges of the users on the collection.
+type CollectionStore struct {
+	Collection *mongo.Collection
+}
+
+var _ Store = &CollectionStore{}
+
+// EnsureIndexes creates the unique index of the names and versions of the queries.
+func (s *CollectionStore) EnsureIndexes(ctx context.Context) error {
+	_, err := s.Collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "name", Value: 1}, {Key: "version", Value: -1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to create the index of the saved queries: %w", err)
+	}
+	return nil
+}
+
+func (s *CollectionStore) Save(ctx context.Context, q *Query) error {
+	if err := q.Validate(); err != nil {
+		return err
+	}
+	latest, err := s.Get(ctx, q.Name, 0)
+	switch {
+	case err == ErrNotFound:
+		q.Version = 1
+	case err != nil:
+		return err
+	default:
+		q.Version = latest.Version + 1
+	}
+	q.CreatedAt = time.Now().UTC()
+	if _, err := s.Collection.InsertOne(ctx, q); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return fmt.Errorf("version %d of query %s was saved concurrently, retry", q.Version, q.Name)
+		}
+		return fmt.Errorf("unable to save query %s: %w", q.Name, err)
+	}
+	return nil
+}
+
+func (s *CollectionStore) Get(ctx context.Context, name string, version int) (*Query, error) {
+	filter := bson.D{{Key: "name", Value: name}}
+	if version > 0 {
+		filter = append(filter, bson.E{Key: "version", Value: version})
+	}
+	var q Query
+	err := s.Collection.FindOne(ctx, filter, options.FindOne().SetSort(bson.D{{Key: "version", Value: -1}})).Decode(&q)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read query %s: %w", name, err)
+	}
+	return &q, nil
+}
+
+func (s *CollectionStore) List(ctx context.Context) ([]Query, error) {
+	cursor, err := s.Collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$sort", Value: bson.D{{Key: "name", Value: 1}, {Key: "version", Value: -1}}}},
+		{{Key: "$group", Value: bson.D{{Key: "_id", Value: "$name"}, {Key: "latest", Value: bson.D{{Key: "$first", Value: "$$ROOT"}}}}}},
+		{{Key: "$replaceRoot", Value: bson.D{{Key: "newRoot", Value: "$latest"}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "name", Value: 1}}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the saved queries: %w", err)
+	}
+	var queries []Query
+	if err := cursor.All(ctx, &queries); err != nil {
+		return nil, fmt.Errorf("unable to list the saved queries: %w", err)
+	}
+	return queries, nil
+}
+
+func (s *CollectionStore) History(ctx context.Context, name string) ([]Query, error) {
+	cursor, err := s.Collection.Find(ctx, bson.D{{Key: "name", Value: name}}, options.Find().SetSort(bson.D{{Key: "version", Value: -1}}))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the versions of query %s: %w", name, err)
+	}
+	var queries []Query
+	if err := cursor.All(ctx, &queries); err != nil {
+		return nil, fmt.Errorf("unable to read the versions of query %s: %w", name, err)
+	}
+	if len(queries) == 0 {
+		return nil, ErrNotFound
+	}
+	return queries, nil
+}
+
+func (s *CollectionStore) Delete(ctx context.Context, name string) (int, error) {
+	result, err := s.Collection.DeleteMany(ctx, bson.D{{Key: "name", Value: name}})
+	if err != nil {
+		return 0, fmt.Errorf("unable to delete query %s: %w", name, err)
+	}
+	return int(result.DeletedCount), nil
+}
+
+// FileStore stores the queries in a local YAML or JSON file, which can be reviewed and shared in a
+// source repository. The file is created by the first save.
+type FileStore struct {
+	Path string
+}
+
+var _ Store = &FileStore{}
+
+// library is the content of the file of a FileStore.
+type library struct {
+	Queries []Query `json:"queries"`
+}
+
+func (s *FileStore) load() (*library, error) {
+	data, err := ioutil.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		return &library{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the saved queries: %w", err)
+	}
+	var l library
+	if err := yaml.UnmarshalStrict(data, &l); err != nil {
+		return nil, fmt.Errorf("unable to parse the saved queries of %s: %w", s.Path, err)
+	}
+	return &l, nil
+}
+
+// write replaces the file with l, atomically so that readers never see a partial file.
+func (s *FileStore) write(l *library) error {
+	data, err := yaml.Marshal(l)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(s.Path), "."+filepath.Base(s.Path)+".*")
+	if err != nil {
+		return fmt.Errorf("unable to write the saved queries: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("unable to write the saved queries: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("unable to write the saved queries: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.Path); err != nil {
+		return fmt.Errorf("unable to write the saved queries: %w", err)
+	}
+	return nil
+}
+
+// versions returns the versions of the query called name, the latest first.
+func (l *library) versions(name string) []Query {
+	var queries []Query
+	for _, q := range l.Queries {
+		if q.Name == name {
+			queries = append(queries, q)
+		}
+	}
+	sort.Slice(queries, func(i, j int) bool { return queries[i].Version > queries[j].Version })
+	return queries
+}
+
+func (s *FileStore) Save(ctx context.Context, q *Query) error {
+	if err := q.Validate(); err != nil {
+		return err
+	}
+	l, err := s.load()
+	if err != nil {
+		return err
+	}
+	q.Version = 1
+	if versions := l.versions(q.Name); len(versions) > 0 {
+		q.Version = versions[0].Version + 1
+	}
+	q.CreatedAt = time.Now().UTC()
+	l.Queries = append(l.Queries, *q)
+	return s.write(l)
+}
+
+func (s *FileStore) Get(ctx context.Context, name string, version int) (*Query, error) {
+	l, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	for _, q := range l.versions(name) {
+		if version == 0 || q.Version == version {
+			return &q, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *FileStore) List(ctx context.Context) ([]Query, error) {
+	l, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	latest := map[string]Query{}
+	for _, q := range l.Queries {
+		if current, ok := latest[q.Name]; !ok || q.Version > current.Version {
+			latest[q.Name] = q
+		}
+	}
+	queries := make([]Query, 0, len(latest))
+	for _, q := range latest {
+		queries = append(queries, q)
+	}
+	sort.Slice(queries, func(i, j int) bool { return queries[i].Name < queries[j].Name })
+	return queries, nil
+}
+
+func (s *FileStore) History(ctx context.Context, name string) ([]Query, error) {
+	l, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	versions := l.versions(name)
+	if len(versions) == 0 {
+		return nil, ErrNotFound
+	}
+	return versions, nil
+}
+
+func (s *FileStore) Delete(ctx context.Context, name string) (int, error) {
+	l, err := s.load()
+	if err != nil {
+		return 0, err
+	}
+	kept := l.Queries[:0]
+	for _, q := range l.Queries {
+		if q.Name != name {
+			kept = append(kept, q)
+		}
+	}
+	removed := len(l.Queries) - len(kept)
+	if removed == 0 {
+		return 0, nil
+	}
+	l.Queries = kept
+	return removed, s.write(l)
+}
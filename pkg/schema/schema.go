@@ -0,0 +1,361 @@
+// Package schema infers the structure of the documents of a collection from a sample, and describes
+// it as a MongoDB JSON Schema or as Go struct definitions.
+package schema
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"sort"
+	"strings"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+// Field is what was seen of a field, or of the elements of an array, across the sampled documents.
+type Field struct {
+	// Name is the name of the field, empty for array elements and documents.
+	Name string
+	// Seen is the number of times the field was present.
+	Seen int
+	// Types counts the values of the field by BSON type alias, such as "string" or "objectId".
+	Types map[string]int
+	// Fields are the fields of the values that are documents, in the order they were first seen.
+	Fields []*Field
+	// Items are the elements of the values that are arrays.
+	Items *Field
+
+	objects int
+	index   map[string]*Field
+}
+
+func newField(name string) *Field {
+	return &Field{Name: name, Types: map[string]int{}}
+}
+
+// New returns the structure of no documents, to which documents are added as they are read.
+func New() *Field {
+	return newField("")
+}
+
+// Infer returns the structure of documents, as a Field of type object.
+func Infer(documents []bson.Raw) (*Field, error) {
+	root := New()
+	for _, document := range documents {
+		if err := root.AddDocument(document); err != nil {
+			return nil, err
+		}
+	}
+	return root, nil
+}
+
+// AddDocument adds the fields of document to the structure, which only retains what was seen of
+// them rather than the document itself.
+func (f *Field) AddDocument(document bson.Raw) error {
+	return f.add(bson.RawValue{Type: bsontype.EmbeddedDocument, Value: document})
+}
+
+func (f *Field) add(value bson.RawValue) error {
+	f.Seen++
+	alias := typeAlias(value.Type)
+	f.Types[alias]++
+	switch value.Type {
+	case bsontype.EmbeddedDocument:
+		f.objects++
+		if f.index == nil {
+			f.index = map[string]*Field{}
+		}
+		elements, err := bson.Raw(value.Value).Elements()
+		if err != nil {
+			return err
+		}
+		for _, element := range elements {
+			key := element.Key()
+			field, ok := f.index[key]
+			if !ok {
+				field = newField(key)
+				f.index[key] = field
+				f.Fields = append(f.Fields, field)
+			}
+			if err := field.add(element.Value()); err != nil {
+				return err
+			}
+		}
+	case bsontype.Array:
+		if f.Items == nil {
+			f.Items = newField("")
+		}
+		values, err := bson.Raw(value.Value).Values()
+		if err != nil {
+			return err
+		}
+		for _, item := range values {
+			if err := f.Items.add(item); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Required reports whether field is present in every document holding it, its parent.
+func (f *Field) Required(field *Field) bool {
+	return field.Seen == f.objects
+}
+
+// typeAlias returns the alias of a BSON type used by $type and bsonType.
+func typeAlias(t bsontype.Type) string {
+	switch t {
+	case bsontype.Double:
+		return "double"
+	case bsontype.String:
+		return "string"
+	case bsontype.EmbeddedDocument:
+		return "object"
+	case bsontype.Array:
+		return "array"
+	case bsontype.Binary:
+		return "binData"
+	case bsontype.ObjectID:
+		return "objectId"
+	case bsontype.Boolean:
+		return "bool"
+	case bsontype.DateTime:
+		return "date"
+	case bsontype.Null:
+		return "null"
+	case bsontype.Regex:
+		return "regex"
+	case bsontype.JavaScript:
+		return "javascript"
+	case bsontype.Int32:
+		return "int"
+	case bsontype.Timestamp:
+		return "timestamp"
+	case bsontype.Int64:
+		return "long"
+	case bsontype.Decimal128:
+		return "decimal"
+	case bsontype.MinKey:
+		return "minKey"
+	case bsontype.MaxKey:
+		return "maxKey"
+	}
+	return t.String()
+}
+
+// types returns the type aliases of the field, most frequent first.
+func (f *Field) types() []string {
+	types := make([]string, 0, len(f.Types))
+	for alias := range f.Types {
+		types = append(types, alias)
+	}
+	sort.Slice(types, func(i, j int) bool {
+		if f.Types[types[i]] != f.Types[types[j]] {
+			return f.Types[types[i]] > f.Types[types[j]]
+		}
+		return types[i] < types[j]
+	})
+	return types
+}
+
+// JSONSchema returns the MongoDB JSON Schema, the argument of $jsonSchema, of the field.
+func (f *Field) JSONSchema() bson.D {
+	schema := bson.D{}
+	switch types := f.types(); len(types) {
+	case 0:
+	case 1:
+		schema = append(schema, bson.E{Key: "bsonType", Value: types[0]})
+	default:
+		schema = append(schema, bson.E{Key: "bsonType", Value: types})
+	}
+	if f.objects > 0 {
+		var required []string
+		properties := bson.D{}
+		for _, field := range f.Fields {
+			if f.Required(field) {
+				required = append(required, field.Name)
+			}
+			properties = append(properties, bson.E{Key: field.Name, Value: field.JSONSchema()})
+		}
+		if len(required) > 0 {
+			schema = append(schema, bson.E{Key: "required", Value: required})
+		}
+		schema = append(schema, bson.E{Key: "properties", Value: properties})
+	}
+	if f.Items != nil && f.Items.Seen > 0 {
+		schema = append(schema, bson.E{Key: "items", Value: f.Items.JSONSchema()})
+	}
+	return schema
+}
+
+// GoStructs returns the Go source of a struct named name holding the documents of the field, and of
+// the structs of its nested documents.
+func (f *Field) GoStructs(name string) ([]byte, error) {
+	g := &generator{names: map[string]bool{}}
+	g.structure(name, f)
+	source, err := format.Source(g.buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("unable to format the generated structs: %w", err)
+	}
+	return append(bytes.TrimRight(source, "\n"), '\n'), nil
+}
+
+type generator struct {
+	buf     bytes.Buffer
+	names   map[string]bool
+	pending []pendingStruct
+}
+
+type pendingStruct struct {
+	name  string
+	field *Field
+}
+
+// structure writes the struct of a document field and then those of its nested documents.
+func (g *generator) structure(name string, f *Field) {
+	g.names[name] = true
+	fmt.Fprintf(&g.buf, "type %s struct {\n", name)
+	used := map[string]bool{}
+	for _, field := range f.Fields {
+		fieldName := goName(field.Name)
+		for used[fieldName] {
+			fieldName += "_"
+		}
+		used[fieldName] = true
+		tag := field.Name
+		// An omitted _id is generated by the server, so that the struct can be inserted as is.
+		if !f.Required(field) || field.Types["null"] > 0 || field.Name == "_id" {
+			tag += ",omitempty"
+		}
+		fmt.Fprintf(&g.buf, "\t%s %s `bson:%q`\n", fieldName, g.goType(name+fieldName, field), tag)
+	}
+	fmt.Fprintf(&g.buf, "}\n\n")
+	for len(g.pending) > 0 {
+		next := g.pending[0]
+		g.pending = g.pending[1:]
+		g.structure(next.name, next.field)
+	}
+}
+
+// goType returns the Go type of the values of a field, queueing the structs of its documents under
+// name.
+func (g *generator) goType(name string, f *Field) string {
+	var types []string
+	for _, alias := range f.types() {
+		if alias != "null" {
+			types = append(types, alias)
+		}
+	}
+	switch {
+	case len(types) == 0:
+		return "interface{}"
+	case len(types) == 2 && contains(types, "int") && contains(types, "long"):
+		return "int64"
+	case len(types) > 1 && allNumeric(types):
+		return "float64"
+	case len(types) > 1:
+		return "interface{}"
+	}
+	switch types[0] {
+	case "string":
+		return "string"
+	case "int":
+		return "int32"
+	case "long":
+		return "int64"
+	case "double":
+		return "float64"
+	case "decimal":
+		return "primitive.Decimal128"
+	case "bool":
+		return "bool"
+	case "date":
+		return "time.Time"
+	case "objectId":
+		return "primitive.ObjectID"
+	case "binData":
+		return "primitive.Binary"
+	case "timestamp":
+		return "primitive.Timestamp"
+	case "regex":
+		return "primitive.Regex"
+	case "array":
+		if f.Items == nil || f.Items.Seen == 0 {
+			return "[]interface{}"
+		}
+		return "[]" + g.goType(name, f.Items)
+	case "object":
+		if len(f.Fields) == 0 {
+			return "bson.M"
+		}
+		for g.names[name] {
+			name += "_"
+		}
+		g.names[name] = true
+		g.pending = append(g.pending, pendingStruct{name: name, field: f})
+		return name
+	}
+	return "interface{}"
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func allNumeric(types []string) bool {
+	for _, t := range types {
+		switch t {
+		case "int", "long", "double":
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// goName returns an exported Go identifier for a field name: _id is ID and the words of
+// snake_case, kebab-case and camelCase names are capitalized.
+func goName(field string) string {
+	if field == "_id" {
+		return "ID"
+	}
+	words := strings.FieldsFunc(field, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	var name strings.Builder
+	for _, word := range words {
+		if strings.EqualFold(word, "id") {
+			name.WriteString("ID")
+			continue
+		}
+		runes := []rune(word)
+		runes[0] = unicode.ToUpper(runes[0])
+		name.WriteString(string(runes))
+	}
+	if name.Len() == 0 || unicode.IsDigit([]rune(name.String())[0]) {
+		return "F" + name.String()
+	}
+	return name.String()
+}
+
+// TypeName returns a Go type name for the documents of a collection, its name in the singular.
+func TypeName(collection string) string {
+	name := goName(collection)
+	switch {
+	case strings.HasSuffix(name, "ies") && len(name) > 3:
+		return name[:len(name)-3] + "y"
+	case strings.HasSuffix(name, "ses") || strings.HasSuffix(name, "xes"):
+		return name[:len(name)-2]
+	case strings.HasSuffix(name, "s") && !strings.HasSuffix(name, "ss") && len(name) > 1:
+		return name[:len(name)-1]
+	}
+	return name
+}
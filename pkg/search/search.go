@@ -0,0 +1,125 @@
+// Package search builds the aggregations of full-text searches, run either with the $text operator
+// on the text index of a collection or with the $search stage on an Atlas Search index.
+package search
+
+import (
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// ScoreField holds the relevance of the results, they are sorted by decreasing score.
+	ScoreField = "score"
+	// HighlightsField holds the passages of the results matching the terms, Atlas Search only.
+	HighlightsField = "highlights"
+)
+
+// Query is a full-text search returning a page of results.
+type Query struct {
+	// Text is the terms searched, with the syntax of $text: "quoted phrases" and -negated terms.
+	Text string
+	// Index is the Atlas Search index searched with $search, the text index of the collection is
+	// searched with $text when it is empty.
+	Index string
+	// Paths are the fields searched by $search, every indexed field when empty. $text searches the
+	// fields of the text index.
+	Paths []string
+	// Language selects the stop words and stemming of $text, the default language of the index
+	// when empty.
+	Language string
+	// Fuzzy allows up to this many single-character edits per term with $search.
+	Fuzzy int
+	// Highlight adds the passages matching the terms to the results of $search.
+	Highlight bool
+	// Filter selects the results among the documents matching Text.
+	Filter     bson.D
+	Skip       int64
+	Limit      int64
+	Projection bson.D
+}
+
+// Validate reports whether the query can be run.
+func (q *Query) Validate() error {
+	if len(q.Text) == 0 {
+		return fmt.Errorf("the searched text must not be empty")
+	}
+	if q.Skip < 0 || q.Limit < 0 {
+		return fmt.Errorf("skip and limit must not be negative")
+	}
+	if len(q.Index) == 0 {
+		if len(q.Paths) > 0 || q.Fuzzy > 0 || q.Highlight {
+			return fmt.Errorf("paths, fuzzy matching and highlights require an Atlas Search index")
+		}
+		return nil
+	}
+	if len(q.Language) > 0 {
+		return fmt.Errorf("the language of an Atlas Search is set by the analyzer of its index")
+	}
+	if q.Fuzzy < 0 || q.Fuzzy > 2 {
+		return fmt.Errorf("fuzzy matching allows 0 to 2 edits")
+	}
+	return nil
+}
+
+// Pipeline returns the aggregation of the page of results, sorted by decreasing score, with their
+// score in ScoreField and, when requested, their highlights in HighlightsField.
+func (q *Query) Pipeline() mongo.Pipeline {
+	if len(q.Index) > 0 {
+		return q.atlasPipeline()
+	}
+	text := bson.D{{Key: "$search", Value: q.Text}}
+	if len(q.Language) > 0 {
+		text = append(text, bson.E{Key: "$language", Value: q.Language})
+	}
+	match := append(bson.D{{Key: "$text", Value: text}}, q.Filter...)
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$addFields", Value: bson.D{{Key: ScoreField, Value: bson.D{{Key: "$meta", Value: "textScore"}}}}}},
+		{{Key: "$sort", Value: bson.D{{Key: ScoreField, Value: bson.D{{Key: "$meta", Value: "textScore"}}}}}},
+	}
+	return append(pipeline, q.page()...)
+}
+
+// atlasPipeline returns the aggregation of a $search, whose results are already sorted by score.
+func (q *Query) atlasPipeline() mongo.Pipeline {
+	var path interface{} = bson.D{{Key: "wildcard", Value: "*"}}
+	if len(q.Paths) == 1 {
+		path = q.Paths[0]
+	} else if len(q.Paths) > 1 {
+		path = q.Paths
+	}
+	text := bson.D{{Key: "query", Value: q.Text}, {Key: "path", Value: path}}
+	if q.Fuzzy > 0 {
+		text = append(text, bson.E{Key: "fuzzy", Value: bson.D{{Key: "maxEdits", Value: q.Fuzzy}}})
+	}
+	search := bson.D{{Key: "index", Value: q.Index}, {Key: "text", Value: text}}
+	if q.Highlight {
+		search = append(search, bson.E{Key: "highlight", Value: bson.D{{Key: "path", Value: path}}})
+	}
+	pipeline := mongo.Pipeline{{{Key: "$search", Value: search}}}
+	if len(q.Filter) > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: q.Filter}})
+	}
+	fields := bson.D{{Key: ScoreField, Value: bson.D{{Key: "$meta", Value: "searchScore"}}}}
+	if q.Highlight {
+		fields = append(fields, bson.E{Key: HighlightsField, Value: bson.D{{Key: "$meta", Value: "searchHighlights"}}})
+	}
+	pipeline = append(pipeline, bson.D{{Key: "$addFields", Value: fields}})
+	return append(pipeline, q.page()...)
+}
+
+func (q *Query) page() mongo.Pipeline {
+	var pipeline mongo.Pipeline
+	if q.Skip > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$skip", Value: q.Skip}})
+	}
+	if q.Limit > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$limit", Value: q.Limit}})
+	}
+	if len(q.Projection) > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$project", Value: q.Projection}})
+	}
+	return pipeline
+}
@@ -0,0 +1,84 @@
+package sink
+
+import (
+	"encoding/binary"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// ChangeEventSchema is the Avro schema of the events published with the avro format, in parsing
+// canonical form. Documents are carried as canonical Extended JSON strings since their shape is not
+// known in advance, clusterTime holds the seconds of the timestamp in the upper 32 bits and the
+// increment in the lower ones.
+const ChangeEventSchema = `{"name":"mongodb_client.ChangeEvent","type":"record","fields":[` +
+	`{"name":"operationType","type":"string"},` +
+	`{"name":"database","type":"string"},` +
+	`{"name":"collection","type":"string"},` +
+	`{"name":"documentKey","type":["null","string"]},` +
+	`{"name":"fullDocument","type":["null","string"]},` +
+	`{"name":"updateDescription","type":["null","string"]},` +
+	`{"name":"clusterTime","type":"long"}]}`
+
+// avroEmpty is the initial value of the CRC-64-AVRO fingerprint.
+const avroEmpty = 0xc15d213aa4d7a795
+
+var avroTable = func() [256]uint64 {
+	var table [256]uint64
+	for i := range table {
+		fp := uint64(i)
+		for j := 0; j < 8; j++ {
+			fp = (fp >> 1) ^ (avroEmpty & -(fp & 1))
+		}
+		table[i] = fp
+	}
+	return table
+}()
+
+// avroFingerprint returns the CRC-64-AVRO fingerprint of a schema in parsing canonical form.
+func avroFingerprint(schema string) uint64 {
+	fp := uint64(avroEmpty)
+	for i := 0; i < len(schema); i++ {
+		fp = (fp >> 8) ^ avroTable[byte(fp)^schema[i]]
+	}
+	return fp
+}
+
+// changeEventHeader prefixes every encoded event, as defined by the Avro single object encoding,
+// so that consumers can look up ChangeEventSchema by its fingerprint.
+var changeEventHeader = func() []byte {
+	header := []byte{0xc3, 0x01, 0, 0, 0, 0, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint64(header[2:], avroFingerprint(ChangeEventSchema))
+	return header
+}()
+
+// encodeAvro encodes event with ChangeEventSchema using the Avro single object encoding.
+func encodeAvro(event Event) ([]byte, error) {
+	buf := append([]byte(nil), changeEventHeader...)
+	buf = appendAvroString(buf, event.OperationType)
+	buf = appendAvroString(buf, event.Namespace.Database)
+	buf = appendAvroString(buf, event.Namespace.Collection)
+	for _, doc := range []bson.Raw{event.DocumentKey, event.FullDocument, event.UpdateDescription} {
+		if doc == nil {
+			buf = appendAvroLong(buf, 0)
+			continue
+		}
+		encoded, err := bson.MarshalExtJSON(doc, true, false)
+		if err != nil {
+			return nil, err
+		}
+		buf = appendAvroLong(buf, 1)
+		buf = appendAvroString(buf, string(encoded))
+	}
+	return appendAvroLong(buf, int64(event.ClusterTime.T)<<32|int64(event.ClusterTime.I)), nil
+}
+
+// appendAvroLong appends v zig-zag and variable length encoded.
+func appendAvroLong(buf []byte, v int64) []byte {
+	var encoded [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(encoded[:], uint64((v<<1)^(v>>63)))
+	return append(buf, encoded[:n]...)
+}
+
+func appendAvroString(buf []byte, s string) []byte {
+	return append(appendAvroLong(buf, int64(len(s))), s...)
+}
@@ -0,0 +1,123 @@
+package sink
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// KafkaConfig configures the Kafka sink. The SASL credentials are read from KAFKA_USERNAME and
+// KAFKA_PASSWORD.
+type KafkaConfig struct {
+	Brokers []string
+	// Topics routes the events of each namespace, the default topic is "{db}.{collection}".
+	Topics Router
+	// Format is json, relaxed Extended JSON of the whole event, or avro, ChangeEventSchema in the
+	// Avro single object encoding.
+	Format string
+	// TLS connects to the brokers with TLS.
+	TLS bool
+	// SASLMechanism is empty, PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512.
+	SASLMechanism string
+}
+
+type kafkaSink struct {
+	writer *kafka.Writer
+	topics Router
+	format string
+}
+
+// NewKafka returns a Sink writing every event to the topic of its namespace, keyed by the _id of
+// the changed document so that the events of a document stay in order within a partition.
+func NewKafka(config KafkaConfig) (Sink, error) {
+	if len(config.Brokers) == 0 {
+		return nil, errors.New("at least one Kafka broker is required")
+	}
+	var err error
+	if config.Format, err = checkFormat(config.Format); err != nil {
+		return nil, err
+	}
+	if len(config.Topics.Default) == 0 {
+		config.Topics.Default = "{db}.{collection}"
+	}
+
+	transport := &kafka.Transport{}
+	if config.TLS {
+		transport.TLS = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	if len(config.SASLMechanism) > 0 {
+		mechanism, err := saslMechanism(config.SASLMechanism, os.Getenv("KAFKA_USERNAME"), os.Getenv("KAFKA_PASSWORD"))
+		if err != nil {
+			return nil, err
+		}
+		transport.SASL = mechanism
+	}
+
+	return &kafkaSink{
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(config.Brokers...),
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireAll,
+			// Events are written one at a time, waiting for a batch to fill up would only add latency.
+			BatchTimeout: 10 * time.Millisecond,
+			Transport:    transport,
+		},
+		topics: config.Topics,
+		format: config.Format,
+	}, nil
+}
+
+func saslMechanism(name, username, password string) (sasl.Mechanism, error) {
+	if len(username) == 0 {
+		return nil, errors.New("KAFKA_USERNAME and KAFKA_PASSWORD are required for SASL authentication")
+	}
+	switch name {
+	case "PLAIN":
+		return plain.Mechanism{Username: username, Password: password}, nil
+	case "SCRAM-SHA-256":
+		return scram.Mechanism(scram.SHA256, username, password)
+	case "SCRAM-SHA-512":
+		return scram.Mechanism(scram.SHA512, username, password)
+	}
+	return nil, fmt.Errorf("unsupported SASL mechanism %q, must be PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512", name)
+}
+
+func (k *kafkaSink) Publish(ctx context.Context, raw bson.Raw) error {
+	event, err := decode(raw)
+	if err != nil {
+		return err
+	}
+	key, err := event.Key()
+	if err != nil {
+		return err
+	}
+	value, err := encode(k.format, raw, event)
+	if err != nil {
+		return err
+	}
+	message := kafka.Message{
+		Topic: k.topics.Route(event),
+		Key:   key,
+		Value: value,
+		Headers: []kafka.Header{
+			{Key: "mongodb.operationType", Value: []byte(event.OperationType)},
+		},
+	}
+	if err := k.writer.WriteMessages(ctx, message); err != nil {
+		return fmt.Errorf("unable to publish to Kafka topic %s: %w", message.Topic, err)
+	}
+	return nil
+}
+
+func (k *kafkaSink) Close() error {
+	return k.writer.Close()
+}
@@ -0,0 +1,86 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// NATSConfig configures the NATS JetStream sink. A credentials file is read from NATS_CREDS, user
+// and password or token may also be part of the URL.
+type NATSConfig struct {
+	// URL of the NATS servers, comma separated.
+	URL string
+	// Subjects routes the events of each namespace, the default subject is "mongodb.{db}.{collection}".
+	Subjects Router
+	// Format is json or avro, see FormatJSON and FormatAvro.
+	Format string
+}
+
+type natsSink struct {
+	conn     *nats.Conn
+	js       nats.JetStreamContext
+	subjects Router
+	format   string
+}
+
+// subjectReplacer replaces the characters that are not allowed in a subject token.
+var subjectReplacer = strings.NewReplacer(" ", "_", "\t", "_", "*", "_", ">", "_")
+
+// NewNATS returns a Sink publishing every event to the JetStream subject of its namespace. Each
+// message waits for the acknowledgement of the stream and carries the resume token of its event as
+// Nats-Msg-Id, so that the stream drops the duplicates published again after a restart.
+func NewNATS(config NATSConfig) (Sink, error) {
+	if len(config.URL) == 0 {
+		return nil, errors.New("a NATS server URL is required")
+	}
+	var err error
+	if config.Format, err = checkFormat(config.Format); err != nil {
+		return nil, err
+	}
+	if len(config.Subjects.Default) == 0 {
+		config.Subjects.Default = "mongodb.{db}.{collection}"
+	}
+
+	options := []nats.Option{nats.Name("mongodb-client"), nats.MaxReconnects(-1)}
+	if credentials := os.Getenv("NATS_CREDS"); len(credentials) > 0 {
+		options = append(options, nats.UserCredentials(credentials))
+	}
+	conn, err := nats.Connect(config.URL, options...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to NATS: %w", err)
+	}
+	js, err := conn.JetStream()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &natsSink{conn: conn, js: js, subjects: config.Subjects, format: config.Format}, nil
+}
+
+func (n *natsSink) Publish(ctx context.Context, raw bson.Raw) error {
+	event, err := decode(raw)
+	if err != nil {
+		return err
+	}
+	value, err := encode(n.format, raw, event)
+	if err != nil {
+		return err
+	}
+	msg := nats.NewMsg(subjectReplacer.Replace(n.subjects.Route(event)))
+	msg.Data = value
+	msg.Header.Set("Mongodb-Operation-Type", event.OperationType)
+	if _, err := n.js.PublishMsg(msg, nats.MsgId(event.MessageID()), nats.Context(ctx)); err != nil {
+		return fmt.Errorf("unable to publish to NATS subject %s: %w", msg.Subject, err)
+	}
+	return nil
+}
+
+func (n *natsSink) Close() error {
+	return n.conn.Drain()
+}
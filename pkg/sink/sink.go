@@ -0,0 +1,120 @@
+// Package sink publishes change stream events to external systems.
+package sink
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Sink publishes change events. Publish only returns once the event has been accepted, so that the
+// resume token of a durable consumer is never saved ahead of the published events.
+type Sink interface {
+	Publish(ctx context.Context, event bson.Raw) error
+	Close() error
+}
+
+// BatchSink is implemented by sinks that can publish several consecutive events at once.
+type BatchSink interface {
+	Sink
+	PublishBatch(ctx context.Context, events []bson.Raw) error
+}
+
+// Formats of the published events.
+const (
+	// FormatJSON is the relaxed Extended JSON of the whole event.
+	FormatJSON = "json"
+	// FormatAvro is ChangeEventSchema in the Avro single object encoding.
+	FormatAvro = "avro"
+)
+
+// checkFormat returns format, FormatJSON when empty, or an error for unsupported formats.
+func checkFormat(format string) (string, error) {
+	switch format {
+	case "":
+		return FormatJSON, nil
+	case FormatJSON, FormatAvro:
+		return format, nil
+	}
+	return "", fmt.Errorf("unsupported format %q, must be %s or %s", format, FormatJSON, FormatAvro)
+}
+
+// encode serializes the raw change event in format.
+func encode(format string, raw bson.Raw, event Event) ([]byte, error) {
+	if format == FormatAvro {
+		return encodeAvro(event)
+	}
+	return bson.MarshalExtJSON(raw, false, false)
+}
+
+// Event holds the fields of a change event used to route and key it.
+type Event struct {
+	// ID is the resume token of the event, unique within the deployment.
+	ID            bson.Raw `bson:"_id"`
+	OperationType string   `bson:"operationType"`
+	Namespace     struct {
+		Database   string `bson:"db"`
+		Collection string `bson:"coll"`
+	} `bson:"ns"`
+	DocumentKey       bson.Raw            `bson:"documentKey"`
+	FullDocument      bson.Raw            `bson:"fullDocument"`
+	UpdateDescription bson.Raw            `bson:"updateDescription"`
+	ClusterTime       primitive.Timestamp `bson:"clusterTime"`
+}
+
+// decode returns the routing fields of a raw change event.
+func decode(raw bson.Raw) (Event, error) {
+	var event Event
+	if err := bson.Unmarshal(raw, &event); err != nil {
+		return event, fmt.Errorf("invalid change event: %w", err)
+	}
+	return event, nil
+}
+
+// Key returns {"_id": <id>} of the changed document as canonical Extended JSON, so that all the
+// events of a document share a key. It is nil for events that do not concern a single document.
+func (e Event) Key() ([]byte, error) {
+	if e.DocumentKey == nil {
+		return nil, nil
+	}
+	id, err := e.DocumentKey.LookupErr("_id")
+	if err != nil {
+		return nil, nil
+	}
+	return bson.MarshalExtJSON(bson.D{{Key: "_id", Value: id}}, true, false)
+}
+
+// Router maps the namespace of an event to a destination, such as a topic or subject.
+type Router struct {
+	// Routes maps "database.collection" or "database" to a destination.
+	Routes map[string]string
+	// Default is used for namespaces without a route, "{db}" and "{collection}" are replaced by the
+	// namespace of the event.
+	Default string
+}
+
+// Route returns the destination of event.
+func (r Router) Route(event Event) string {
+	database, collection := event.Namespace.Database, event.Namespace.Collection
+	if destination, ok := r.Routes[database+"."+collection]; ok && len(collection) > 0 {
+		return destination
+	}
+	if destination, ok := r.Routes[database]; ok {
+		return destination
+	}
+	return strings.NewReplacer("{db}", database, "{collection}", collection).Replace(r.Default)
+}
+
+// MessageID returns an identifier unique to the event, suitable for deduplicating redeliveries.
+func (e Event) MessageID() string {
+	if data, err := e.ID.LookupErr("_data"); err == nil {
+		if s, ok := data.StringValueOK(); ok {
+			return s
+		}
+	}
+	id, _ := bson.MarshalExtJSON(e.ID, true, false)
+	return string(id)
+}
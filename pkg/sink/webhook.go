@@ -0,0 +1,233 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"k8s.io/klog"
+)
+
+var (
+	webhookDeliveries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mongodb_client_webhook_deliveries_total",
+		Help: "Number of webhook requests by result: success, failure (retried) or dead_letter.",
+	}, []string{"result"})
+	webhookEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "mongodb_client_webhook_events_total",
+		Help: "Number of change events by delivery result: delivered or dead_letter.",
+	}, []string{"result"})
+	webhookDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "mongodb_client_webhook_request_duration_seconds",
+		Help:    "Duration of the webhook requests.",
+		Buckets: prometheus.DefBuckets,
+	})
+)
+
+func init() {
+	prometheus.MustRegister(webhookDeliveries, webhookEvents, webhookDuration)
+}
+
+// SignatureHeader carries the hex encoded HMAC-SHA256 of the request body, prefixed by "sha256=".
+const SignatureHeader = "X-Mongodb-Client-Signature-256"
+
+// WebhookConfig configures the webhook sink. The signing secret is read from WEBHOOK_SECRET.
+type WebhookConfig struct {
+	URL string
+	// Batch posts JSON arrays of events instead of one event per request.
+	Batch bool
+	// Timeout of every request.
+	Timeout time.Duration
+	// Attempts is the number of times a request is sent before its events are dead-lettered.
+	Attempts int
+	// Backoff is the delay before the first retry, doubled after every attempt up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// DeadLetter receives the events whose delivery failed, when nil such a failure stops the consumer.
+	DeadLetter *mongo.Collection
+}
+
+type webhookSink struct {
+	config WebhookConfig
+	secret []byte
+	client *http.Client
+}
+
+// deadLetter is the document stored for each event that could not be delivered.
+type deadLetter struct {
+	Event    bson.Raw  `bson:"event"`
+	URL      string    `bson:"url"`
+	Error    string    `bson:"error"`
+	Attempts int       `bson:"attempts"`
+	FailedAt time.Time `bson:"failedAt"`
+}
+
+// permanentError is a response that is not worth retrying.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+// NewWebhook returns a Sink posting the events as relaxed Extended JSON to an HTTP endpoint. Failed
+// requests are retried with exponential backoff, events that still cannot be delivered are stored
+// in the dead letter collection.
+func NewWebhook(config WebhookConfig) (BatchSink, error) {
+	if !strings.HasPrefix(config.URL, "https://") && !strings.HasPrefix(config.URL, "http://") {
+		return nil, fmt.Errorf("invalid webhook URL %q, must be an http:// or https:// URL", config.URL)
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 30 * time.Second
+	}
+	if config.Attempts < 1 {
+		config.Attempts = 1
+	}
+	if !strings.HasPrefix(config.URL, "https://") {
+		klog.Warningf("Webhook %s does not use TLS, the events are sent in clear text", config.URL)
+	}
+	return &webhookSink{
+		config: config,
+		secret: []byte(os.Getenv("WEBHOOK_SECRET")),
+		client: &http.Client{Timeout: config.Timeout},
+	}, nil
+}
+
+func (w *webhookSink) Publish(ctx context.Context, event bson.Raw) error {
+	return w.PublishBatch(ctx, []bson.Raw{event})
+}
+
+func (w *webhookSink) PublishBatch(ctx context.Context, events []bson.Raw) error {
+	var body bytes.Buffer
+	if w.config.Batch {
+		body.WriteByte('[')
+	}
+	for i, event := range events {
+		if i > 0 {
+			if !w.config.Batch {
+				return errors.New("batches require a webhook configured to post arrays of events")
+			}
+			body.WriteByte(',')
+		}
+		data, err := bson.MarshalExtJSON(event, false, false)
+		if err != nil {
+			return err
+		}
+		body.Write(data)
+	}
+	if w.config.Batch {
+		body.WriteByte(']')
+	}
+	delivery := ""
+	if decoded, err := decode(events[0]); err == nil {
+		delivery = decoded.MessageID()
+	}
+
+	attempts, err := w.deliver(ctx, body.Bytes(), delivery)
+	if err == nil {
+		webhookEvents.WithLabelValues("delivered").Add(float64(len(events)))
+		return nil
+	}
+	if ctx.Err() != nil || w.config.DeadLetter == nil {
+		return fmt.Errorf("unable to deliver %d events to %s: %w", len(events), w.config.URL, err)
+	}
+
+	klog.Warningf("Storing %d undeliverable events in %s: %v", len(events), w.config.DeadLetter.Name(), err)
+	letters := make([]interface{}, 0, len(events))
+	for _, event := range events {
+		letters = append(letters, deadLetter{Event: event, URL: w.config.URL, Error: err.Error(), Attempts: attempts, FailedAt: time.Now().UTC()})
+	}
+	if _, insertErr := w.config.DeadLetter.InsertMany(ctx, letters); insertErr != nil {
+		return fmt.Errorf("unable to deliver %d events to %s (%v) nor to store them as dead letters: %w", len(events), w.config.URL, err, insertErr)
+	}
+	webhookDeliveries.WithLabelValues("dead_letter").Inc()
+	webhookEvents.WithLabelValues("dead_letter").Add(float64(len(events)))
+	return nil
+}
+
+// deliver posts body until it is accepted, the response is a permanent failure or the attempts are
+// exhausted, returning the number of attempts made.
+func (w *webhookSink) deliver(ctx context.Context, body []byte, delivery string) (int, error) {
+	delay := w.config.Backoff
+	for attempt := 1; ; attempt++ {
+		err := w.post(ctx, body, delivery)
+		if err == nil {
+			webhookDeliveries.WithLabelValues("success").Inc()
+			return attempt, nil
+		}
+		webhookDeliveries.WithLabelValues("failure").Inc()
+		var permanent *permanentError
+		if errors.As(err, &permanent) || attempt >= w.config.Attempts {
+			return attempt, err
+		}
+
+		sleep := delay + time.Duration(rand.Float64()*0.2*float64(delay))
+		klog.V(2).Infof("Webhook delivery attempt %d/%d failed, retrying in %s: %v", attempt, w.config.Attempts, sleep, err)
+		timer := time.NewTimer(sleep)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return attempt, err
+		case <-timer.C:
+		}
+		delay *= 2
+		if w.config.MaxBackoff > 0 && delay > w.config.MaxBackoff {
+			delay = w.config.MaxBackoff
+		}
+	}
+}
+
+func (w *webhookSink) post(ctx context.Context, body []byte, delivery string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return &permanentError{err: err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "mongodb-client")
+	if len(delivery) > 0 {
+		req.Header.Set("X-Mongodb-Client-Delivery", delivery)
+	}
+	if len(w.secret) > 0 {
+		mac := hmac.New(sha256.New, w.secret)
+		mac.Write(body)
+		req.Header.Set(SignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	start := time.Now()
+	resp, err := w.client.Do(req)
+	webhookDuration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+	message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	err = fmt.Errorf("unexpected status %d: %s", resp.StatusCode, strings.TrimSpace(string(message)))
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode >= 500 {
+		return err
+	}
+	return &permanentError{err: err}
+}
+
+func (w *webhookSink) Close() error {
+	w.client.CloseIdleConnections()
+	return nil
+}
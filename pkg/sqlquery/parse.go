@@ -0,0 +1,690 @@
+package sqlquery
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenSymbol
+	// tokenWord is an identifier or a keyword, a dotted path such as e.title is a single word.
+	tokenWord
+	// tokenQuoted is an identifier quoted with " or `, which is never a keyword.
+	tokenQuoted
+	tokenString
+	tokenNumber
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// symbols are matched longest first.
+var symbols = []string{"<>", "!=", "<=", ">=", "=", "<", ">", "(", ")", ",", "*", ";"}
+
+func tokenize(source string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(source); {
+		c := rune(source[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+			continue
+		case c == '\'':
+			var text strings.Builder
+			end := i + 1
+			for ; end < len(source); end++ {
+				if source[end] == '\'' {
+					// A quote is escaped by doubling it.
+					if end+1 < len(source) && source[end+1] == '\'' {
+						text.WriteByte('\'')
+						end++
+						continue
+					}
+					break
+				}
+				text.WriteByte(source[end])
+			}
+			if end >= len(source) {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			tokens = append(tokens, token{kind: tokenString, text: text.String(), pos: i})
+			i = end + 1
+			continue
+		case c == '"' || c == '`':
+			end := strings.IndexByte(source[i+1:], source[i])
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated identifier at %d", i)
+			}
+			tokens = append(tokens, token{kind: tokenQuoted, text: source[i+1 : i+1+end], pos: i})
+			i += end + 2
+			continue
+		case c >= '0' && c <= '9' || c == '-' && i+1 < len(source) && source[i+1] >= '0' && source[i+1] <= '9' && negativeAllowed(tokens):
+			end := i + 1
+			for end < len(source) && strings.ContainsRune("0123456789.eE", rune(source[end])) {
+				end++
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: source[i:end], pos: i})
+			i = end
+			continue
+		case c == '_' || c == '$' || unicode.IsLetter(c):
+			end := i + 1
+			for end < len(source) && (source[end] == '_' || source[end] == '$' || source[end] == '.' || unicode.IsLetter(rune(source[end])) || unicode.IsDigit(rune(source[end]))) {
+				end++
+			}
+			tokens = append(tokens, token{kind: tokenWord, text: source[i:end], pos: i})
+			i = end
+			continue
+		}
+		matched := false
+		for _, s := range symbols {
+			if strings.HasPrefix(source[i:], s) {
+				tokens = append(tokens, token{kind: tokenSymbol, text: s, pos: i})
+				i += len(s)
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("unexpected character %q at %d", c, i)
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(source)}), nil
+}
+
+// negativeAllowed reports whether a minus sign after tokens starts a negative number, arithmetic is
+// not supported so it is rejected after a column or a value.
+func negativeAllowed(tokens []token) bool {
+	if len(tokens) == 0 {
+		return true
+	}
+	last := tokens[len(tokens)-1]
+	return last.kind == tokenSymbol && last.text != ")" || last.kind == tokenWord && isKeyword(last.text)
+}
+
+var keywords = []string{"SELECT", "FROM", "WHERE", "GROUP", "BY", "HAVING", "ORDER", "LIMIT", "OFFSET", "ASC", "DESC",
+	"AS", "JOIN", "INNER", "LEFT", "OUTER", "ON", "AND", "OR", "NOT", "IN", "LIKE", "IS", "NULL", "BETWEEN", "TRUE", "FALSE"}
+
+func isKeyword(word string) bool {
+	for _, k := range keywords {
+		if strings.EqualFold(k, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// Statement is a parsed SELECT statement.
+type Statement struct {
+	Items []SelectItem
+	// Star is set by SELECT *.
+	Star    bool
+	From    Table
+	Joins   []Join
+	Where   Expr
+	GroupBy []string
+	Having  Expr
+	OrderBy []Order
+	Limit   int64
+	Offset  int64
+}
+
+// Table is a collection and the alias its columns are qualified with, its name by default.
+type Table struct {
+	Collection string
+	Alias      string
+}
+
+// Join embeds the documents of Table of which the Right column equals the Left column. A left join
+// keeps the documents without any.
+type Join struct {
+	Table Table
+	Left  string
+	Right string
+	Outer bool
+}
+
+// SelectItem is a column or an aggregate of the select list.
+type SelectItem struct {
+	Column    string
+	Aggregate *Aggregate
+	Alias     string
+}
+
+// Aggregate is COUNT(*), or COUNT, SUM, AVG, MIN or MAX of a column.
+type Aggregate struct {
+	Function string
+	// Column is empty for COUNT(*).
+	Column string
+}
+
+// Order sorts by a column, or an alias of the select list.
+type Order struct {
+	Column    string
+	Aggregate *Aggregate
+	Desc      bool
+}
+
+// Expr is a condition of WHERE or HAVING.
+type Expr interface{}
+
+// Operand is a Column, a Literal or an *Aggregate.
+type Operand interface{}
+
+// Column is a reference to a field, qualified by a table alias or not.
+type Column string
+
+// Literal is a constant value.
+type Literal struct {
+	Value interface{}
+}
+
+// Comparison compares two operands with one of =, <>, <, <=, > and >=.
+type Comparison struct {
+	Op          string
+	Left, Right Operand
+}
+
+// Logical is the conjunction, for AND, or disjunction, for OR, of its terms.
+type Logical struct {
+	Op    string
+	Terms []Expr
+}
+
+// Not negates an expression.
+type Not struct {
+	Expr Expr
+}
+
+// In matches the columns equal to one of the values.
+type In struct {
+	Column  Operand
+	Values  []interface{}
+	Negated bool
+}
+
+// Like matches the strings of a pattern in which % matches any string and _ any character.
+type Like struct {
+	Column  Operand
+	Pattern string
+	Negated bool
+}
+
+// IsNull matches the missing or null columns.
+type IsNull struct {
+	Column  Operand
+	Negated bool
+}
+
+// Between matches the columns between two values, inclusive.
+type Between struct {
+	Column    Operand
+	Low, High interface{}
+	Negated   bool
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// Parse parses a SELECT statement.
+func Parse(source string) (*Statement, error) {
+	tokens, err := tokenize(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	stmt, err := p.parseSelect()
+	if err != nil {
+		return nil, err
+	}
+	p.accept(";")
+	if p.peek().kind != tokenEOF {
+		return nil, p.unexpected()
+	}
+	return stmt, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// accept consumes the symbol or keyword text, compared case insensitively, when it is next.
+func (p *parser) accept(text string) bool {
+	t := p.peek()
+	if (t.kind == tokenSymbol || t.kind == tokenWord) && strings.EqualFold(t.text, text) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(texts ...string) error {
+	for _, text := range texts {
+		if !p.accept(text) {
+			return fmt.Errorf("expected %s: %w", text, p.unexpected())
+		}
+	}
+	return nil
+}
+
+func (p *parser) unexpected() error {
+	t := p.peek()
+	if t.kind == tokenEOF {
+		return fmt.Errorf("unexpected end of statement")
+	}
+	return fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+}
+
+// identifier consumes an identifier, which may be a dotted path.
+func (p *parser) identifier() (string, error) {
+	t := p.peek()
+	if t.kind == tokenQuoted || t.kind == tokenWord && !isKeyword(t.text) {
+		p.pos++
+		return t.text, nil
+	}
+	return "", fmt.Errorf("expected an identifier: %w", p.unexpected())
+}
+
+func (p *parser) parseSelect() (*Statement, error) {
+	if err := p.expect("SELECT"); err != nil {
+		return nil, err
+	}
+	stmt := &Statement{}
+	if p.accept("*") {
+		stmt.Star = true
+	} else {
+		for {
+			item, err := p.parseSelectItem()
+			if err != nil {
+				return nil, err
+			}
+			stmt.Items = append(stmt.Items, item)
+			if !p.accept(",") {
+				break
+			}
+		}
+	}
+	if err := p.expect("FROM"); err != nil {
+		return nil, err
+	}
+	var err error
+	if stmt.From, err = p.parseTable(); err != nil {
+		return nil, err
+	}
+joins:
+	for {
+		var outer bool
+		switch {
+		case p.accept("JOIN"):
+		case p.accept("INNER"):
+			if err := p.expect("JOIN"); err != nil {
+				return nil, err
+			}
+		case p.accept("LEFT"):
+			p.accept("OUTER")
+			if err := p.expect("JOIN"); err != nil {
+				return nil, err
+			}
+			outer = true
+		default:
+			break joins
+		}
+		join := Join{Outer: outer}
+		if join.Table, err = p.parseTable(); err != nil {
+			return nil, err
+		}
+		if err := p.expect("ON"); err != nil {
+			return nil, err
+		}
+		if join.Left, err = p.identifier(); err != nil {
+			return nil, err
+		}
+		if err := p.expect("="); err != nil {
+			return nil, err
+		}
+		if join.Right, err = p.identifier(); err != nil {
+			return nil, err
+		}
+		stmt.Joins = append(stmt.Joins, join)
+	}
+	if p.accept("WHERE") {
+		if stmt.Where, err = p.parseOr(); err != nil {
+			return nil, err
+		}
+	}
+	if p.accept("GROUP") {
+		if err := p.expect("BY"); err != nil {
+			return nil, err
+		}
+		for {
+			column, err := p.identifier()
+			if err != nil {
+				return nil, err
+			}
+			stmt.GroupBy = append(stmt.GroupBy, column)
+			if !p.accept(",") {
+				break
+			}
+		}
+	}
+	if p.accept("HAVING") {
+		if stmt.Having, err = p.parseOr(); err != nil {
+			return nil, err
+		}
+	}
+	if p.accept("ORDER") {
+		if err := p.expect("BY"); err != nil {
+			return nil, err
+		}
+		for {
+			var order Order
+			if aggregate, ok, err := p.parseAggregate(); err != nil {
+				return nil, err
+			} else if ok {
+				order.Aggregate = aggregate
+			} else if order.Column, err = p.identifier(); err != nil {
+				return nil, err
+			}
+			if p.accept("DESC") {
+				order.Desc = true
+			} else {
+				p.accept("ASC")
+			}
+			stmt.OrderBy = append(stmt.OrderBy, order)
+			if !p.accept(",") {
+				break
+			}
+		}
+	}
+	if p.accept("LIMIT") {
+		if stmt.Limit, err = p.parseCount("LIMIT"); err != nil {
+			return nil, err
+		}
+	}
+	if p.accept("OFFSET") {
+		if stmt.Offset, err = p.parseCount("OFFSET"); err != nil {
+			return nil, err
+		}
+	}
+	return stmt, nil
+}
+
+func (p *parser) parseCount(clause string) (int64, error) {
+	t := p.next()
+	n, err := strconv.ParseInt(t.text, 10, 64)
+	if t.kind != tokenNumber || err != nil || n < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative integer, not %q", clause, t.text)
+	}
+	return n, nil
+}
+
+func (p *parser) parseTable() (Table, error) {
+	name, err := p.identifier()
+	if err != nil {
+		return Table{}, err
+	}
+	table := Table{Collection: name, Alias: name}
+	if p.accept("AS") {
+		if table.Alias, err = p.identifier(); err != nil {
+			return Table{}, err
+		}
+	} else if t := p.peek(); t.kind == tokenQuoted || t.kind == tokenWord && !isKeyword(t.text) {
+		table.Alias = p.next().text
+	}
+	return table, nil
+}
+
+func (p *parser) parseSelectItem() (SelectItem, error) {
+	var item SelectItem
+	if aggregate, ok, err := p.parseAggregate(); err != nil {
+		return item, err
+	} else if ok {
+		item.Aggregate = aggregate
+	} else if item.Column, err = p.identifier(); err != nil {
+		return item, err
+	}
+	if p.accept("AS") {
+		alias, err := p.identifier()
+		if err != nil {
+			return item, err
+		}
+		item.Alias = alias
+	} else if t := p.peek(); t.kind == tokenQuoted || t.kind == tokenWord && !isKeyword(t.text) {
+		item.Alias = p.next().text
+	}
+	return item, nil
+}
+
+var aggregateFunctions = []string{"COUNT", "SUM", "AVG", "MIN", "MAX"}
+
+// parseAggregate parses an aggregate function call, and reports whether the next tokens are one.
+func (p *parser) parseAggregate() (*Aggregate, bool, error) {
+	t := p.peek()
+	if t.kind != tokenWord || p.tokens[p.pos+1].text != "(" || isTypedLiteral(t.text) {
+		return nil, false, nil
+	}
+	var function string
+	for _, f := range aggregateFunctions {
+		if strings.EqualFold(f, t.text) {
+			function = f
+		}
+	}
+	if len(function) == 0 {
+		return nil, false, fmt.Errorf("unsupported function %s at %d, expected one of %s", t.text, t.pos, strings.Join(aggregateFunctions, ", "))
+	}
+	p.pos += 2
+	aggregate := &Aggregate{Function: function}
+	if !(function == "COUNT" && p.accept("*")) {
+		column, err := p.identifier()
+		if err != nil {
+			return nil, false, err
+		}
+		aggregate.Column = column
+	}
+	return aggregate, true, p.expect(")")
+}
+
+func (p *parser) parseOr() (Expr, error) {
+	return p.parseLogical("OR", p.parseAnd)
+}
+
+func (p *parser) parseAnd() (Expr, error) {
+	return p.parseLogical("AND", p.parseNot)
+}
+
+func (p *parser) parseLogical(op string, operand func() (Expr, error)) (Expr, error) {
+	first, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	terms := []Expr{first}
+	for p.accept(op) {
+		term, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, term)
+	}
+	if len(terms) == 1 {
+		return first, nil
+	}
+	return Logical{Op: op, Terms: terms}, nil
+}
+
+func (p *parser) parseNot() (Expr, error) {
+	if p.accept("NOT") {
+		expr, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return Not{expr}, nil
+	}
+	return p.parsePredicate()
+}
+
+func (p *parser) parsePredicate() (Expr, error) {
+	if p.accept("(") {
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return expr, p.expect(")")
+	}
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	if p.accept("IS") {
+		negated := p.accept("NOT")
+		return IsNull{Column: left, Negated: negated}, p.expect("NULL")
+	}
+	negated := p.accept("NOT")
+	switch {
+	case p.accept("IN"):
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		in := In{Column: left, Negated: negated}
+		for {
+			value, err := p.parseLiteral()
+			if err != nil {
+				return nil, err
+			}
+			in.Values = append(in.Values, value)
+			if !p.accept(",") {
+				break
+			}
+		}
+		return in, p.expect(")")
+	case p.accept("LIKE"):
+		t := p.next()
+		if t.kind != tokenString {
+			return nil, fmt.Errorf("LIKE expects a string pattern, not %q", t.text)
+		}
+		return Like{Column: left, Pattern: t.text, Negated: negated}, nil
+	case p.accept("BETWEEN"):
+		low, err := p.parseLiteral()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("AND"); err != nil {
+			return nil, err
+		}
+		high, err := p.parseLiteral()
+		if err != nil {
+			return nil, err
+		}
+		return Between{Column: left, Low: low, High: high, Negated: negated}, nil
+	}
+	if negated {
+		return nil, fmt.Errorf("expected IN, LIKE or BETWEEN after NOT: %w", p.unexpected())
+	}
+	for _, op := range []string{"=", "<>", "!=", "<=", ">=", "<", ">"} {
+		if p.accept(op) {
+			right, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			if op == "!=" {
+				op = "<>"
+			}
+			return Comparison{Op: op, Left: left, Right: right}, nil
+		}
+	}
+	return nil, fmt.Errorf("expected a comparison: %w", p.unexpected())
+}
+
+func (p *parser) parseOperand() (Operand, error) {
+	if aggregate, ok, err := p.parseAggregate(); err != nil {
+		return nil, err
+	} else if ok {
+		return aggregate, nil
+	}
+	if t := p.peek(); t.kind == tokenQuoted || t.kind == tokenWord && !isKeyword(t.text) && !isTypedLiteral(t.text) {
+		p.pos++
+		return Column(t.text), nil
+	}
+	value, err := p.parseLiteral()
+	if err != nil {
+		return nil, err
+	}
+	return Literal{value}, nil
+}
+
+// isTypedLiteral reports whether word introduces a typed literal, such as DATE '2024-01-01'.
+func isTypedLiteral(word string) bool {
+	return strings.EqualFold(word, "DATE") || strings.EqualFold(word, "TIMESTAMP") || strings.EqualFold(word, "OBJECTID")
+}
+
+// parseLiteral parses a string, a number, TRUE, FALSE, NULL, a DATE or TIMESTAMP string, or an
+// OBJECTID('hex').
+func (p *parser) parseLiteral() (interface{}, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenString:
+		return t.text, nil
+	case tokenNumber:
+		if n, err := strconv.ParseInt(t.text, 10, 64); err == nil {
+			return n, nil
+		}
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at %d", t.text, t.pos)
+		}
+		return f, nil
+	case tokenWord:
+		switch strings.ToUpper(t.text) {
+		case "TRUE":
+			return true, nil
+		case "FALSE":
+			return false, nil
+		case "NULL":
+			return nil, nil
+		case "DATE", "TIMESTAMP":
+			value := p.next()
+			if value.kind != tokenString {
+				return nil, fmt.Errorf("%s expects a string, not %q", strings.ToUpper(t.text), value.text)
+			}
+			for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05", "2006-01-02"} {
+				if parsed, err := time.Parse(layout, value.text); err == nil {
+					return primitive.NewDateTimeFromTime(parsed), nil
+				}
+			}
+			return nil, fmt.Errorf("invalid %s %q", strings.ToUpper(t.text), value.text)
+		case "OBJECTID":
+			if err := p.expect("("); err != nil {
+				return nil, err
+			}
+			value := p.next()
+			id, err := primitive.ObjectIDFromHex(value.text)
+			if value.kind != tokenString || err != nil {
+				return nil, fmt.Errorf("invalid OBJECTID %q", value.text)
+			}
+			return id, p.expect(")")
+		}
+	}
+	if t.kind != tokenEOF {
+		p.pos--
+	}
+	return nil, fmt.Errorf("expected a value: %w", p.unexpected())
+}
@@ -0,0 +1,464 @@
+// Package sqlquery translates a subset of SQL SELECT statements into MongoDB finds and
+// aggregation pipelines: WHERE, ORDER BY, LIMIT and OFFSET, GROUP BY with COUNT, SUM, AVG, MIN and
+// MAX, HAVING, and joins on the equality of two columns.
+package sqlquery
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Translation is a statement translated into a find or, when Pipeline is set, an aggregation of
+// Collection.
+type Translation struct {
+	Collection string
+	Filter     bson.D
+	Projection bson.D
+	Sort       bson.D
+	Skip       int64
+	Limit      int64
+	Pipeline   []bson.D
+}
+
+// Command returns the find or aggregate command of the translation.
+func (t *Translation) Command() bson.D {
+	if t.Pipeline != nil {
+		return bson.D{
+			{Key: "aggregate", Value: t.Collection},
+			{Key: "pipeline", Value: t.Pipeline},
+			{Key: "cursor", Value: bson.D{}},
+		}
+	}
+	command := bson.D{{Key: "find", Value: t.Collection}, {Key: "filter", Value: t.Filter}}
+	if len(t.Projection) > 0 {
+		command = append(command, bson.E{Key: "projection", Value: t.Projection})
+	}
+	if len(t.Sort) > 0 {
+		command = append(command, bson.E{Key: "sort", Value: t.Sort})
+	}
+	if t.Skip > 0 {
+		command = append(command, bson.E{Key: "skip", Value: t.Skip})
+	}
+	if t.Limit > 0 {
+		command = append(command, bson.E{Key: "limit", Value: t.Limit})
+	}
+	return command
+}
+
+// Translate parses and translates a SELECT statement.
+func Translate(source string) (*Translation, error) {
+	stmt, err := Parse(source)
+	if err != nil {
+		return nil, err
+	}
+	return stmt.Translate()
+}
+
+// Translate returns the find of the statement, or its aggregation when it joins collections, groups
+// documents or renames columns.
+func (s *Statement) Translate() (*Translation, error) {
+	for _, item := range s.Items {
+		if strings.Contains(item.Alias, ".") {
+			return nil, fmt.Errorf("alias %s must not contain dots", item.Alias)
+		}
+	}
+	var lookups []bson.D
+	for _, join := range s.Joins {
+		stages, err := s.lookup(join)
+		if err != nil {
+			return nil, err
+		}
+		lookups = append(lookups, stages...)
+	}
+	filter, err := condition(s.Where, s.whereField)
+	if err != nil {
+		return nil, fmt.Errorf("WHERE: %w", err)
+	}
+	if s.grouped() {
+		return s.translateGroup(lookups, filter)
+	}
+	if s.Having != nil {
+		return nil, fmt.Errorf("HAVING requires GROUP BY or aggregates")
+	}
+
+	var sort bson.D
+	for _, order := range s.OrderBy {
+		if order.Aggregate != nil {
+			return nil, fmt.Errorf("ORDER BY: aggregates require GROUP BY")
+		}
+		path := s.documentPath(order.Column)
+		for _, item := range s.Items {
+			if item.Alias == order.Column {
+				path = s.documentPath(item.Column)
+			}
+		}
+		sort = append(sort, bson.E{Key: path, Value: direction(order.Desc)})
+	}
+	var projection bson.D
+	renamed, withID := false, false
+	for _, item := range s.Items {
+		path := s.documentPath(item.Column)
+		if len(item.Alias) > 0 && item.Alias != path {
+			renamed = true
+			projection = append(projection, bson.E{Key: item.Alias, Value: "$" + path})
+			continue
+		}
+		withID = withID || path == "_id"
+		projection = append(projection, bson.E{Key: path, Value: 1})
+	}
+	if !s.Star && !withID {
+		projection = append(bson.D{{Key: "_id", Value: 0}}, projection...)
+	}
+
+	if len(lookups) == 0 && !renamed {
+		if filter == nil {
+			filter = bson.D{}
+		}
+		return &Translation{Collection: s.From.Collection, Filter: filter, Projection: projection, Sort: sort, Skip: s.Offset, Limit: s.Limit}, nil
+	}
+	pipeline := append([]bson.D{}, lookups...)
+	if len(filter) > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: filter}})
+	}
+	pipeline = append(pipeline, s.page(sort)...)
+	if len(projection) > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$project", Value: projection}})
+	}
+	return &Translation{Collection: s.From.Collection, Pipeline: pipeline}, nil
+}
+
+// translateGroup returns the aggregation of a statement grouping the documents.
+func (s *Statement) translateGroup(lookups []bson.D, filter bson.D) (*Translation, error) {
+	if s.Star {
+		return nil, fmt.Errorf("SELECT * can not be grouped")
+	}
+	keys := map[string]string{}
+	var id interface{}
+	if len(s.GroupBy) > 0 {
+		fields := bson.D{}
+		for _, column := range s.GroupBy {
+			path := s.documentPath(column)
+			keys[path] = strings.ReplaceAll(path, ".", "_")
+			fields = append(fields, bson.E{Key: keys[path], Value: "$" + path})
+		}
+		id = fields
+	}
+	group := bson.D{{Key: "_id", Value: id}}
+	projection := bson.D{{Key: "_id", Value: 0}}
+	for _, item := range s.Items {
+		name := s.outputName(item)
+		if item.Aggregate == nil {
+			key, ok := keys[s.documentPath(item.Column)]
+			if !ok {
+				return nil, fmt.Errorf("column %s must be in GROUP BY or aggregated", item.Column)
+			}
+			projection = append(projection, bson.E{Key: name, Value: "$_id." + key})
+			continue
+		}
+		group = append(group, bson.E{Key: name, Value: s.accumulator(item.Aggregate)})
+		projection = append(projection, bson.E{Key: name, Value: 1})
+	}
+
+	having, err := condition(s.Having, s.outputField)
+	if err != nil {
+		return nil, fmt.Errorf("HAVING: %w", err)
+	}
+	var sort bson.D
+	for _, order := range s.OrderBy {
+		var operand Operand = Column(order.Column)
+		if order.Aggregate != nil {
+			operand = order.Aggregate
+		}
+		field, err := s.outputField(operand)
+		if err != nil {
+			return nil, fmt.Errorf("ORDER BY: %w", err)
+		}
+		sort = append(sort, bson.E{Key: field, Value: direction(order.Desc)})
+	}
+
+	pipeline := append([]bson.D{}, lookups...)
+	if len(filter) > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: filter}})
+	}
+	pipeline = append(pipeline, bson.D{{Key: "$group", Value: group}}, bson.D{{Key: "$project", Value: projection}})
+	if len(having) > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: having}})
+	}
+	pipeline = append(pipeline, s.page(sort)...)
+	return &Translation{Collection: s.From.Collection, Pipeline: pipeline}, nil
+}
+
+func (s *Statement) grouped() bool {
+	if len(s.GroupBy) > 0 {
+		return true
+	}
+	for _, item := range s.Items {
+		if item.Aggregate != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// page returns the $sort, $skip and $limit stages of the statement.
+func (s *Statement) page(sort bson.D) []bson.D {
+	var stages []bson.D
+	if len(sort) > 0 {
+		stages = append(stages, bson.D{{Key: "$sort", Value: sort}})
+	}
+	if s.Offset > 0 {
+		stages = append(stages, bson.D{{Key: "$skip", Value: s.Offset}})
+	}
+	if s.Limit > 0 {
+		stages = append(stages, bson.D{{Key: "$limit", Value: s.Limit}})
+	}
+	return stages
+}
+
+func direction(desc bool) int {
+	if desc {
+		return -1
+	}
+	return 1
+}
+
+// documentPath returns the path of a column in the documents before they are grouped: the columns
+// of the FROM collection are its fields, those of a joined collection the fields of the document
+// embedded as its alias.
+func (s *Statement) documentPath(column string) string {
+	return strings.TrimPrefix(column, s.From.Alias+".")
+}
+
+// lookup returns the $lookup and $unwind stages embedding the documents of a join as its alias.
+func (s *Statement) lookup(join Join) ([]bson.D, error) {
+	prefix := join.Table.Alias + "."
+	local, foreign := join.Left, join.Right
+	if strings.HasPrefix(local, prefix) {
+		local, foreign = foreign, local
+	}
+	if !strings.HasPrefix(foreign, prefix) || strings.HasPrefix(local, prefix) {
+		return nil, fmt.Errorf("the ON condition of the join of %s must compare one of its columns to a column of another collection", join.Table.Alias)
+	}
+	return []bson.D{
+		{{Key: "$lookup", Value: bson.D{
+			{Key: "from", Value: join.Table.Collection},
+			{Key: "localField", Value: s.documentPath(local)},
+			{Key: "foreignField", Value: strings.TrimPrefix(foreign, prefix)},
+			{Key: "as", Value: join.Table.Alias},
+		}}},
+		{{Key: "$unwind", Value: bson.D{
+			{Key: "path", Value: "$" + join.Table.Alias},
+			{Key: "preserveNullAndEmptyArrays", Value: join.Outer},
+		}}},
+	}, nil
+}
+
+// outputName returns the field of a select item in the results.
+func (s *Statement) outputName(item SelectItem) string {
+	switch {
+	case len(item.Alias) > 0:
+		return item.Alias
+	case item.Aggregate == nil:
+		return s.documentPath(item.Column)
+	case len(item.Aggregate.Column) == 0:
+		return "count"
+	}
+	return strings.ToLower(item.Aggregate.Function) + "_" + strings.ReplaceAll(s.documentPath(item.Aggregate.Column), ".", "_")
+}
+
+func (s *Statement) accumulator(aggregate *Aggregate) bson.D {
+	if len(aggregate.Column) == 0 {
+		return bson.D{{Key: "$sum", Value: 1}}
+	}
+	path := "$" + s.documentPath(aggregate.Column)
+	switch aggregate.Function {
+	case "COUNT":
+		// Missing and null values are not counted, they sort before the other values.
+		return bson.D{{Key: "$sum", Value: bson.D{{Key: "$cond", Value: bson.A{bson.D{{Key: "$gt", Value: bson.A{path, nil}}}, 1, 0}}}}}
+	case "SUM":
+		return bson.D{{Key: "$sum", Value: path}}
+	case "AVG":
+		return bson.D{{Key: "$avg", Value: path}}
+	case "MIN":
+		return bson.D{{Key: "$min", Value: path}}
+	}
+	return bson.D{{Key: "$max", Value: path}}
+}
+
+// whereField returns the field of an operand of WHERE, which can not be an aggregate.
+func (s *Statement) whereField(operand Operand) (string, error) {
+	switch v := operand.(type) {
+	case Column:
+		return s.documentPath(string(v)), nil
+	case *Aggregate:
+		return "", fmt.Errorf("aggregates are only allowed in HAVING")
+	}
+	return "", nil
+}
+
+// outputField returns the field of an operand of HAVING or of the ORDER BY of grouped documents,
+// which must be selected.
+func (s *Statement) outputField(operand Operand) (string, error) {
+	switch v := operand.(type) {
+	case Column:
+		for _, item := range s.Items {
+			if item.Alias == string(v) {
+				return item.Alias, nil
+			}
+		}
+		path := s.documentPath(string(v))
+		for _, item := range s.Items {
+			if item.Aggregate == nil && s.documentPath(item.Column) == path {
+				return s.outputName(item), nil
+			}
+		}
+		return "", fmt.Errorf("column %s must be selected", v)
+	case *Aggregate:
+		for _, item := range s.Items {
+			if item.Aggregate != nil && item.Aggregate.Function == v.Function && s.documentPath(item.Aggregate.Column) == s.documentPath(v.Column) {
+				return s.outputName(item), nil
+			}
+		}
+		return "", fmt.Errorf("aggregate %s(%s) must be selected", v.Function, v.Column)
+	}
+	return "", nil
+}
+
+var operators = map[string]string{"=": "$eq", "<>": "$ne", "<": "$lt", "<=": "$lte", ">": "$gt", ">=": "$gte"}
+
+// flipped are the operators of the comparisons of which the operands are swapped.
+var flipped = map[string]string{"=": "=", "<>": "<>", "<": ">", "<=": ">=", ">": "<", ">=": "<="}
+
+// condition returns the query filter of expr, nil when it is nil, of which the operands are the
+// fields returned by field, an empty field for a literal.
+func condition(expr Expr, field func(Operand) (string, error)) (bson.D, error) {
+	switch v := expr.(type) {
+	case nil:
+		return nil, nil
+	case Comparison:
+		left, err := field(v.Left)
+		if err != nil {
+			return nil, err
+		}
+		right, err := field(v.Right)
+		if err != nil {
+			return nil, err
+		}
+		op := v.Op
+		switch {
+		case len(left) > 0 && len(right) > 0:
+			return bson.D{{Key: "$expr", Value: bson.D{{Key: operators[op], Value: bson.A{"$" + left, "$" + right}}}}}, nil
+		case len(left) == 0 && len(right) == 0:
+			return nil, fmt.Errorf("a comparison must refer to a column")
+		case len(left) == 0:
+			left, op = right, flipped[op]
+			v.Right = v.Left
+		}
+		value := v.Right.(Literal).Value
+		if op == "=" {
+			return bson.D{{Key: left, Value: value}}, nil
+		}
+		return bson.D{{Key: left, Value: bson.D{{Key: operators[op], Value: value}}}}, nil
+	case Logical:
+		terms := make(bson.A, 0, len(v.Terms))
+		keys := map[string]bool{}
+		merged, mergeable := bson.D{}, v.Op == "AND"
+		for _, term := range v.Terms {
+			cond, err := condition(term, field)
+			if err != nil {
+				return nil, err
+			}
+			terms = append(terms, cond)
+			for _, e := range cond {
+				mergeable = mergeable && !keys[e.Key]
+				keys[e.Key] = true
+				merged = append(merged, e)
+			}
+		}
+		// Conditions on distinct fields are merged, as in {"a": 1, "b": 2}.
+		if mergeable {
+			return merged, nil
+		}
+		return bson.D{{Key: "$" + strings.ToLower(v.Op), Value: terms}}, nil
+	case Not:
+		cond, err := condition(v.Expr, field)
+		if err != nil {
+			return nil, err
+		}
+		return bson.D{{Key: "$nor", Value: bson.A{cond}}}, nil
+	case In:
+		name, err := operandField(v.Column, field)
+		if err != nil {
+			return nil, err
+		}
+		op := "$in"
+		if v.Negated {
+			op = "$nin"
+		}
+		return bson.D{{Key: name, Value: bson.D{{Key: op, Value: bson.A(v.Values)}}}}, nil
+	case Like:
+		name, err := operandField(v.Column, field)
+		if err != nil {
+			return nil, err
+		}
+		regex := likeRegex(v.Pattern)
+		if v.Negated {
+			return bson.D{{Key: name, Value: bson.D{{Key: "$not", Value: regex}}}}, nil
+		}
+		return bson.D{{Key: name, Value: regex}}, nil
+	case IsNull:
+		name, err := operandField(v.Column, field)
+		if err != nil {
+			return nil, err
+		}
+		if v.Negated {
+			return bson.D{{Key: name, Value: bson.D{{Key: "$ne", Value: nil}}}}, nil
+		}
+		return bson.D{{Key: name, Value: nil}}, nil
+	case Between:
+		name, err := operandField(v.Column, field)
+		if err != nil {
+			return nil, err
+		}
+		if v.Negated {
+			return bson.D{{Key: "$or", Value: bson.A{
+				bson.D{{Key: name, Value: bson.D{{Key: "$lt", Value: v.Low}}}},
+				bson.D{{Key: name, Value: bson.D{{Key: "$gt", Value: v.High}}}},
+			}}}, nil
+		}
+		return bson.D{{Key: name, Value: bson.D{{Key: "$gte", Value: v.Low}, {Key: "$lte", Value: v.High}}}}, nil
+	}
+	return nil, fmt.Errorf("unsupported condition %T", expr)
+}
+
+// operandField returns the field of an operand that must not be a literal.
+func operandField(operand Operand, field func(Operand) (string, error)) (string, error) {
+	name, err := field(operand)
+	if err == nil && len(name) == 0 {
+		err = fmt.Errorf("IN, LIKE, IS NULL and BETWEEN apply to columns, not values")
+	}
+	return name, err
+}
+
+// likeRegex returns the regular expression of a LIKE pattern, in which % matches any string and _
+// any character.
+func likeRegex(pattern string) primitive.Regex {
+	var expr strings.Builder
+	expr.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '%':
+			expr.WriteString(".*")
+		case '_':
+			expr.WriteString(".")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	expr.WriteString("$")
+	return primitive.Regex{Pattern: expr.String(), Options: "s"}
+}
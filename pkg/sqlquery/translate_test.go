@@ -0,0 +1,138 @@
+package sqlquery
+
+import (
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestTranslate(t *testing.T) {
+	tests := []struct {
+		statement string
+		// command is the relaxed Extended JSON of the command of the translation.
+		command string
+	}{
+		{
+			statement: `SELECT * FROM episodes`,
+			command:   `{"find":"episodes","filter":{}}`,
+		},
+		{
+			statement: `SELECT title, season FROM episodes WHERE season = 2 AND published >= DATE '2024-01-01' ORDER BY published DESC LIMIT 10 OFFSET 20`,
+			command:   `{"find":"episodes","filter":{"season":2,"published":{"$gte":{"$date":"2024-01-01T00:00:00Z"}}},"projection":{"_id":0,"title":1,"season":1},"sort":{"published":-1},"skip":20,"limit":10}`,
+		},
+		{
+			statement: `SELECT _id, title FROM episodes WHERE 3 < season`,
+			command:   `{"find":"episodes","filter":{"season":{"$gt":3}},"projection":{"_id":1,"title":1}}`,
+		},
+		{
+			statement: `SELECT title FROM episodes WHERE season = 1 OR season = 2`,
+			command:   `{"find":"episodes","filter":{"$or":[{"season":1},{"season":2}]},"projection":{"_id":0,"title":1}}`,
+		},
+		{
+			statement: `SELECT title FROM episodes WHERE season > 1 AND season < 4`,
+			command:   `{"find":"episodes","filter":{"$and":[{"season":{"$gt":1}},{"season":{"$lt":4}}]},"projection":{"_id":0,"title":1}}`,
+		},
+		{
+			statement: `SELECT title FROM episodes WHERE title LIKE 'Graph%_' AND NOT season IN (1, 2)`,
+			command:   `{"find":"episodes","filter":{"title":{"$regularExpression":{"pattern":"^Graph.*.$","options":"s"}},"$nor":[{"season":{"$in":[1,2]}}]},"projection":{"_id":0,"title":1}}`,
+		},
+		{
+			statement: `SELECT title FROM episodes WHERE title NOT LIKE 'a.b%' AND podcast IS NOT NULL AND guest IS NULL`,
+			command:   `{"find":"episodes","filter":{"title":{"$not":{"$regularExpression":{"pattern":"^a\\.b.*$","options":"s"}}},"podcast":{"$ne":null},"guest":null},"projection":{"_id":0,"title":1}}`,
+		},
+		{
+			statement: `SELECT title FROM episodes WHERE season NOT BETWEEN 1 AND 3`,
+			command:   `{"find":"episodes","filter":{"$or":[{"season":{"$lt":1}},{"season":{"$gt":3}}]},"projection":{"_id":0,"title":1}}`,
+		},
+		{
+			statement: `SELECT title FROM episodes WHERE season BETWEEN 1 AND 3 AND duration <> 1.5`,
+			command:   `{"find":"episodes","filter":{"season":{"$gte":1,"$lte":3},"duration":{"$ne":1.5}},"projection":{"_id":0,"title":1}}`,
+		},
+		{
+			statement: `SELECT title FROM episodes WHERE watched > duration`,
+			command:   `{"find":"episodes","filter":{"$expr":{"$gt":["$watched","$duration"]}},"projection":{"_id":0,"title":1}}`,
+		},
+		{
+			statement: `SELECT title FROM episodes WHERE title = 'It''s'`,
+			command:   `{"find":"episodes","filter":{"title":"It's"},"projection":{"_id":0,"title":1}}`,
+		},
+		{
+			statement: `SELECT title AS name FROM episodes ORDER BY name`,
+			command:   `{"aggregate":"episodes","pipeline":[{"$sort":{"title":1}},{"$project":{"_id":0,"name":"$title"}}],"cursor":{}}`,
+		},
+		{
+			statement: `SELECT season, COUNT(*), AVG(duration) AS average FROM episodes GROUP BY season HAVING COUNT(*) > 2 ORDER BY average DESC`,
+			command:   `{"aggregate":"episodes","pipeline":[{"$group":{"_id":{"season":"$season"},"count":{"$sum":1},"average":{"$avg":"$duration"}}},{"$project":{"_id":0,"season":"$_id.season","count":1,"average":1}},{"$match":{"count":{"$gt":2}}},{"$sort":{"average":-1}}],"cursor":{}}`,
+		},
+		{
+			statement: `SELECT COUNT(guest), MAX(e.duration) FROM episodes e`,
+			command:   `{"aggregate":"episodes","pipeline":[{"$group":{"_id":null,"count_guest":{"$sum":{"$cond":[{"$gt":["$guest",null]},1,0]}},"max_duration":{"$max":"$duration"}}},{"$project":{"_id":0,"count_guest":1,"max_duration":1}}],"cursor":{}}`,
+		},
+		{
+			statement: `SELECT e.title, p.title AS podcast FROM episodes e JOIN podcasts p ON e.podcast = p._id WHERE p.title = 'x'`,
+			command:   `{"aggregate":"episodes","pipeline":[{"$lookup":{"from":"podcasts","localField":"podcast","foreignField":"_id","as":"p"}},{"$unwind":{"path":"$p","preserveNullAndEmptyArrays":false}},{"$match":{"p.title":"x"}},{"$project":{"_id":0,"title":1,"podcast":"$p.title"}}],"cursor":{}}`,
+		},
+		{
+			statement: `SELECT e.title FROM episodes e LEFT JOIN podcasts p ON p._id = e.podcast`,
+			command:   `{"aggregate":"episodes","pipeline":[{"$lookup":{"from":"podcasts","localField":"podcast","foreignField":"_id","as":"p"}},{"$unwind":{"path":"$p","preserveNullAndEmptyArrays":true}},{"$project":{"_id":0,"title":1}}],"cursor":{}}`,
+		},
+		{
+			statement: `SELECT title FROM episodes WHERE _id = OBJECTID('5f1b2c3d4e5f6a7b8c9d0e1f');`,
+			command:   `{"find":"episodes","filter":{"_id":{"$oid":"5f1b2c3d4e5f6a7b8c9d0e1f"}},"projection":{"_id":0,"title":1}}`,
+		},
+		{
+			statement: `SELECT title FROM episodes WHERE NOT season = 1`,
+			command:   `{"find":"episodes","filter":{"$nor":[{"season":1}]},"projection":{"_id":0,"title":1}}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.statement, func(t *testing.T) {
+			translation, err := Translate(test.statement)
+			if err != nil {
+				t.Fatal(err)
+			}
+			command, err := bson.MarshalExtJSON(translation.Command(), false, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(command) != test.command {
+				t.Errorf("expected %s, got %s", test.command, command)
+			}
+		})
+	}
+}
+
+func TestTranslateErrors(t *testing.T) {
+	tests := []struct {
+		statement string
+		err       string
+	}{
+		{`SELECT FROM episodes`, `expected an identifier: unexpected "FROM" at 7`},
+		{`SELECT title FROM episodes WHERE`, `expected a value: unexpected end of statement`},
+		{`SELECT title FROM episodes LIMIT -1`, `LIMIT must be a non-negative integer, not "-1"`},
+		{`SELECT title FROM episodes WHERE title = 'open`, `unterminated string at 41`},
+		{`SELECT title FROM episodes WHERE MEDIAN(x) > 1`, `unsupported function MEDIAN at 33, expected one of COUNT, SUM, AVG, MIN, MAX`},
+		{`SELECT title FROM episodes WHERE COUNT(*) > 1`, `WHERE: aggregates are only allowed in HAVING`},
+		{`SELECT title, COUNT(*) FROM episodes`, `column title must be in GROUP BY or aggregated`},
+		{`SELECT * FROM episodes GROUP BY season`, `SELECT * can not be grouped`},
+		{`SELECT title FROM episodes HAVING title = 'x'`, `HAVING requires GROUP BY or aggregates`},
+		{`SELECT title FROM episodes ORDER BY COUNT(*)`, `ORDER BY: aggregates require GROUP BY`},
+		{`SELECT title AS "a.b" FROM episodes`, `alias a.b must not contain dots`},
+		{`SELECT title FROM episodes WHERE 1 = 2`, `WHERE: a comparison must refer to a column`},
+		{`SELECT title FROM episodes WHERE 'x' IN ('x')`, `WHERE: IN, LIKE, IS NULL and BETWEEN apply to columns, not values`},
+		{`SELECT e.title FROM episodes e JOIN podcasts p ON e.a = e.b`, `the ON condition of the join of p must compare one of its columns to a column of another collection`},
+		{`SELECT title FROM episodes WHERE title LIKE 3`, `LIKE expects a string pattern, not "3"`},
+		{`SELECT title FROM episodes WHERE published = DATE 'yesterday'`, `invalid DATE "yesterday"`},
+		{`SELECT title FROM episodes WHERE _id = OBJECTID('nope')`, `invalid OBJECTID "nope"`},
+		{`SELECT title FROM episodes WHERE season - 1 = 2`, `unexpected character '-' at 40`},
+		{`SELECT title FROM episodes extra tokens`, `unexpected "tokens" at 33`},
+	}
+	for _, test := range tests {
+		t.Run(test.statement, func(t *testing.T) {
+			if _, err := Translate(test.statement); err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("expected an error containing %q, got %v", test.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,201 @@
+// Package tenancy lets several tenants, such as teams, share a deployment by mapping the databases
+// and collections they name to namespaces of their own: a database per tenant and database, named
+// <tenant>_<database>, or a collection prefix per tenant in shared databases, <tenant>_<collection>.
+// A tenant only reaches the namespaces mapped from its own names.
+package tenancy
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Strategy is how the namespaces of the tenants are separated.
+type Strategy string
+
+const (
+	// DatabasePerTenant maps the database db of tenant t to the database t_db.
+	DatabasePerTenant Strategy = "database"
+	// CollectionPrefix maps the collection c of tenant t to the collection t_c of the same database.
+	CollectionPrefix Strategy = "prefix"
+)
+
+// separator separates the tenant from the database or collection, tenants can not contain it.
+const separator = "_"
+
+// maxDatabaseLength is the longest database name accepted by the server.
+const maxDatabaseLength = 63
+
+var validTenant = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9-]{0,31}$`)
+
+// internalDatabases are never mapped to tenants.
+var internalDatabases = map[string]bool{"admin": true, "config": true, "local": true}
+
+// Tenancy maps the namespaces of tenants with a strategy. A nil Tenancy maps every namespace to
+// itself, so that code serving tenants also serves deployments without tenancy.
+type Tenancy struct {
+	strategy Strategy
+}
+
+// New returns the tenancy of strategy.
+func New(strategy Strategy) (*Tenancy, error) {
+	if strategy != DatabasePerTenant && strategy != CollectionPrefix {
+		return nil, fmt.Errorf("unknown tenancy strategy %q, must be %s or %s", strategy, DatabasePerTenant, CollectionPrefix)
+	}
+	return &Tenancy{strategy: strategy}, nil
+}
+
+// Strategy returns the strategy of the tenancy.
+func (t *Tenancy) Strategy() Strategy {
+	return t.strategy
+}
+
+// CheckTenant rejects the tenants that are not 1 to 32 letters, digits and dashes.
+func CheckTenant(tenant string) error {
+	if !validTenant.MatchString(tenant) {
+		return fmt.Errorf("invalid tenant %q, expected 1 to 32 letters, digits and '-'", tenant)
+	}
+	return nil
+}
+
+// Namespace returns the database and collection of tenant that database and collection are mapped
+// to.
+func (t *Tenancy) Namespace(tenant, database, collection string) (string, string, error) {
+	if t == nil {
+		return database, collection, nil
+	}
+	if err := CheckTenant(tenant); err != nil {
+		return "", "", err
+	}
+	if internalDatabases[database] {
+		return "", "", fmt.Errorf("tenants can not access the database %s", database)
+	}
+	switch t.strategy {
+	case DatabasePerTenant:
+		database = tenant + separator + database
+		if len(database) > maxDatabaseLength {
+			return "", "", fmt.Errorf("the database %s of tenant %s is longer than %d characters", database, tenant, maxDatabaseLength)
+		}
+	case CollectionPrefix:
+		if len(collection) > 0 {
+			collection = tenant + separator + collection
+		}
+	}
+	return database, collection, nil
+}
+
+// Logical returns the database and collection of tenant mapped to the namespace database and
+// collection, and whether it belongs to tenant. An empty collection only maps the database.
+func (t *Tenancy) Logical(tenant, database, collection string) (string, string, bool) {
+	if t == nil {
+		return database, collection, true
+	}
+	if internalDatabases[database] {
+		return "", "", false
+	}
+	prefix := tenant + separator
+	switch t.strategy {
+	case DatabasePerTenant:
+		if !strings.HasPrefix(database, prefix) || len(database) == len(prefix) {
+			return "", "", false
+		}
+		return strings.TrimPrefix(database, prefix), collection, true
+	case CollectionPrefix:
+		if len(collection) == 0 {
+			return database, "", true
+		}
+		if !strings.HasPrefix(collection, prefix) || len(collection) == len(prefix) {
+			return "", "", false
+		}
+		return database, strings.TrimPrefix(collection, prefix), true
+	}
+	return "", "", false
+}
+
+// Client is the client of a deployment as seen by a tenant: the databases and collections it names
+// are mapped to those of the tenant.
+type Client struct {
+	client  *mongo.Client
+	tenancy *Tenancy
+	tenant  string
+}
+
+// Client returns c as seen by tenant.
+func (t *Tenancy) Client(c *mongo.Client, tenant string) (*Client, error) {
+	if t != nil {
+		if err := CheckTenant(tenant); err != nil {
+			return nil, err
+		}
+	}
+	return &Client{client: c, tenancy: t, tenant: tenant}, nil
+}
+
+// Tenant returns the tenant of the client.
+func (c *Client) Tenant() string {
+	return c.tenant
+}
+
+// Collection returns the collection of the tenant mapped from database and collection.
+func (c *Client) Collection(database, collection string) (*mongo.Collection, error) {
+	database, collection, err := c.tenancy.Namespace(c.tenant, database, collection)
+	if err != nil {
+		return nil, err
+	}
+	return c.client.Database(database).Collection(collection), nil
+}
+
+// ListDatabaseNames returns the databases of the tenant, by the names the tenant gives them. The
+// databases shared by the tenants of a collection prefix tenancy are all listed.
+func (c *Client) ListDatabaseNames(ctx context.Context) ([]string, error) {
+	databases, err := c.client.ListDatabaseNames(ctx, bson.D{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list databases: %w", err)
+	}
+	var names []string
+	for _, database := range databases {
+		if name, _, ok := c.tenancy.Logical(c.tenant, database, ""); ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// ListCollectionNames returns the collections of the tenant in database, by the names the tenant
+// gives them.
+func (c *Client) ListCollectionNames(ctx context.Context, database string) ([]string, error) {
+	physical, _, err := c.tenancy.Namespace(c.tenant, database, "")
+	if err != nil {
+		return nil, err
+	}
+	collections, err := c.client.Database(physical).ListCollectionNames(ctx, bson.D{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the collections of %s: %w", database, err)
+	}
+	var names []string
+	for _, collection := range collections {
+		if _, name, ok := c.tenancy.Logical(c.tenant, physical, collection); ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+type tenantKey struct{}
+
+// NewContext returns a copy of ctx carrying tenant.
+func NewContext(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// FromContext returns the tenant carried by ctx, empty when there is none.
+func FromContext(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	return tenant
+}
@@ -0,0 +1,251 @@
+// Package ui serves a single page dashboard browsing the databases and collections of the
+// deployment, running read-only queries and summarizing the health and metrics of the client.
+package ui
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/api"
+	"github.com/bradmwilliams/mongodb-client/pkg/client"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"k8s.io/klog"
+)
+
+// Prefix is where the dashboard is served.
+const Prefix = "/ui/"
+
+// maxDocuments bounds the documents returned by a query of the dashboard.
+const maxDocuments = 500
+
+//go:embed static
+var static embed.FS
+
+// Handler serves the dashboard and the read-only endpoints it uses below Prefix + "api/".
+type Handler struct {
+	manager *client.ConnectionManager
+	breaker *client.CircuitBreaker
+	// Readable reports whether the caller of ctx may read database, the other databases are
+	// hidden. It is nil when every database may be read.
+	Readable func(ctx context.Context, database string) bool
+
+	mux *http.ServeMux
+}
+
+// NewHandler returns the dashboard of the deployment of manager, reporting the state of breaker.
+func NewHandler(manager *client.ConnectionManager, breaker *client.CircuitBreaker) *Handler {
+	h := &Handler{manager: manager, breaker: breaker, mux: http.NewServeMux()}
+	content, err := fs.Sub(static, "static")
+	if err != nil {
+		panic(err)
+	}
+	h.mux.Handle(Prefix, http.StripPrefix(Prefix, http.FileServer(http.FS(content))))
+	h.mux.HandleFunc(Prefix+"api/databases", h.databases)
+	h.mux.HandleFunc(Prefix+"api/query", h.query)
+	h.mux.HandleFunc(Prefix+"api/health", h.health)
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "the dashboard is read-only", http.StatusMethodNotAllowed)
+		return
+	}
+	h.mux.ServeHTTP(w, r)
+}
+
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		klog.Errorf("Unable to write a dashboard response: %v", err)
+	}
+}
+
+func (h *Handler) readable(ctx context.Context, database string) bool {
+	return h.Readable == nil || h.Readable(ctx, database)
+}
+
+type collectionSummary struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Count int64  `json:"count"`
+}
+
+type databaseSummary struct {
+	Name        string              `json:"name"`
+	SizeOnDisk  int64               `json:"sizeOnDisk"`
+	Collections []collectionSummary `json:"collections"`
+}
+
+// databases lists the readable databases with their collections and estimated document counts.
+func (h *Handler) databases(w http.ResponseWriter, r *http.Request) {
+	if !h.manager.Connected() {
+		http.Error(w, "not connected to database", http.StatusServiceUnavailable)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), h.manager.Timeout(client.ReadOperation))
+	defer cancel()
+	result, err := h.manager.Primary().ListDatabases(ctx, bson.D{})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	summaries := []databaseSummary{}
+	for _, database := range result.Databases {
+		if !h.readable(ctx, database.Name) {
+			continue
+		}
+		summary := databaseSummary{Name: database.Name, SizeOnDisk: database.SizeOnDisk, Collections: []collectionSummary{}}
+		cursor, err := h.manager.Primary().Database(database.Name).ListCollections(ctx, bson.D{})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		var specs []struct {
+			Name string `bson:"name"`
+			Type string `bson:"type"`
+		}
+		if err := cursor.All(ctx, &specs); err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		for _, spec := range specs {
+			collection := collectionSummary{Name: spec.Name, Type: spec.Type, Count: -1}
+			// Views cannot be counted from their metadata.
+			if spec.Type != "view" {
+				if count, err := h.manager.Primary().Database(database.Name).Collection(spec.Name).EstimatedDocumentCount(ctx); err == nil {
+					collection.Count = count
+				}
+			}
+			summary.Collections = append(summary.Collections, collection)
+		}
+		sort.Slice(summary.Collections, func(i, j int) bool { return summary.Collections[i].Name < summary.Collections[j].Name })
+		summaries = append(summaries, summary)
+	}
+	writeJSON(w, summaries)
+}
+
+// query runs a find on a collection: db, collection, filter, sort and projection as Extended
+// JSON, limit and skip.
+func (h *Handler) query(w http.ResponseWriter, r *http.Request) {
+	if !h.manager.Connected() {
+		http.Error(w, "not connected to database", http.StatusServiceUnavailable)
+		return
+	}
+	params := r.URL.Query()
+	database, collection := params.Get("db"), params.Get("collection")
+	if err := api.CheckNamespace(database, collection); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !h.readable(r.Context(), database) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	findOptions := options.Find()
+	docs := map[string]bson.D{}
+	for _, name := range []string{"filter", "sort", "projection"} {
+		doc := bson.D{}
+		if value := params.Get(name); len(strings.TrimSpace(value)) > 0 {
+			if err := bson.UnmarshalExtJSON([]byte(value), false, &doc); err != nil {
+				http.Error(w, "invalid "+name+": "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		docs[name] = doc
+	}
+	if len(docs["sort"]) > 0 {
+		findOptions.SetSort(docs["sort"])
+	}
+	if len(docs["projection"]) > 0 {
+		findOptions.SetProjection(docs["projection"])
+	}
+	limit, err := integer(params.Get("limit"), 20)
+	if err != nil || limit == 0 || limit > maxDocuments {
+		http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxDocuments), http.StatusBadRequest)
+		return
+	}
+	skip, err := integer(params.Get("skip"), 0)
+	if err != nil {
+		http.Error(w, "skip must not be negative", http.StatusBadRequest)
+		return
+	}
+	findOptions.SetLimit(limit).SetSkip(skip)
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.manager.Timeout(client.ReadOperation))
+	defer cancel()
+	findOptions.MaxTime = client.MaxTime(ctx)
+	cursor, err := h.manager.Primary().Database(database).Collection(collection).Find(ctx, docs["filter"], findOptions)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer cursor.Close(ctx)
+	results := []json.RawMessage{}
+	for cursor.Next(ctx) {
+		data, err := bson.MarshalExtJSON(cursor.Current, false, false)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		results = append(results, data)
+	}
+	if err := cursor.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	writeJSON(w, results)
+}
+
+// integer parses a non-negative integer parameter, defaultValue when it is empty.
+func integer(value string, defaultValue int64) (int64, error) {
+	if len(value) == 0 {
+		return defaultValue, nil
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err == nil && n < 0 {
+		err = errors.New("negative")
+	}
+	return n, err
+}
+
+// health reports the connection, the circuit breaker and the mongodb_client_ metrics, summed
+// over their labels.
+func (h *Handler) health(w http.ResponseWriter, r *http.Request) {
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		klog.Warningf("Unable to gather metrics for the dashboard: %v", err)
+	}
+	metrics := map[string]float64{}
+	for _, family := range families {
+		if !strings.HasPrefix(family.GetName(), "mongodb_client_") {
+			continue
+		}
+		for _, metric := range family.Metric {
+			switch {
+			case metric.Counter != nil:
+				metrics[family.GetName()] += metric.Counter.GetValue()
+			case metric.Gauge != nil:
+				metrics[family.GetName()] += metric.Gauge.GetValue()
+			case metric.Histogram != nil:
+				metrics[family.GetName()+"_count"] += float64(metric.Histogram.GetSampleCount())
+			}
+		}
+	}
+	writeJSON(w, map[string]interface{}{
+		"connected": h.manager.Connected(),
+		"database":  h.manager.Database(),
+		"breaker":   h.breaker.State().String(),
+		"metrics":   metrics,
+	})
+}
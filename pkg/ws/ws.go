@@ -0,0 +1,268 @@
+// Package ws bridges change streams to WebSocket clients, such as live dashboards in browsers.
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/api"
+	"github.com/bradmwilliams/mongodb-client/pkg/client"
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"k8s.io/klog"
+)
+
+// Prefix is where the handler is served: /ws/watch/{db}/{collection}.
+const Prefix = "/ws/watch/"
+
+const (
+	// DefaultBufferSize is the number of events queued for a client reading slower than they
+	// happen.
+	DefaultBufferSize = 256
+	// pingInterval keeps idle connections open through proxies, a client not answering for two
+	// intervals is disconnected.
+	pingInterval = 30 * time.Second
+	writeTimeout = 10 * time.Second
+)
+
+var (
+	connections = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "mongodb_client_websocket_connections",
+		Help: "Number of WebSocket clients watching change streams.",
+	})
+	eventsSent = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "mongodb_client_websocket_events_sent_total",
+		Help: "Number of change events sent to WebSocket clients.",
+	})
+	eventsDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "mongodb_client_websocket_events_dropped_total",
+		Help: "Number of change events dropped because a WebSocket client was too slow.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(connections, eventsSent, eventsDropped)
+}
+
+// Handler upgrades requests to WebSocket connections streaming the changes of a collection.
+//
+// Clients select the events with the query parameters pipeline (an Extended JSON array of
+// stages), operationType (comma separated, such as insert,update), fullDocument (such as
+// updateLookup) and resumeAfter (the _id of the last event received). Events are sent as relaxed
+// Extended JSON text messages. Events are queued for slow clients up to the buffer size, then the
+// connection is closed, or with overflow=drop the events are dropped and counted in a
+// {"dropped": n} message sent before the next event.
+type Handler struct {
+	manager *client.ConnectionManager
+	// BufferSize defaults to DefaultBufferSize.
+	BufferSize int
+	upgrader   websocket.Upgrader
+}
+
+// NewHandler returns a handler for the collections of manager, accepting connections from pages
+// of allowedOrigins, or from the same origin only when it is empty.
+func NewHandler(manager *client.ConnectionManager, allowedOrigins []string) *Handler {
+	h := &Handler{manager: manager, BufferSize: DefaultBufferSize}
+	if len(allowedOrigins) > 0 {
+		h.upgrader.CheckOrigin = func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			for _, allowed := range allowedOrigins {
+				if allowed == "*" || strings.EqualFold(allowed, origin) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	return h
+}
+
+// ParseNamespace returns the database and collection of a request path below Prefix.
+func ParseNamespace(path string) (string, string, error) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(path, Prefix), "/"), "/")
+	if len(parts) != 2 {
+		return "", "", errors.New("expected /ws/watch/{db}/{collection}")
+	}
+	return parts[0], parts[1], api.CheckNamespace(parts[0], parts[1])
+}
+
+// stream returns the change stream options requested by the query parameters.
+func stream(r *http.Request) ([]bson.D, *options.ChangeStreamOptions, bool, error) {
+	query := r.URL.Query()
+	pipeline := []bson.D{}
+	if value := query.Get("pipeline"); len(value) > 0 {
+		var wrapper struct {
+			Pipeline []bson.D `bson:"pipeline"`
+		}
+		if err := bson.UnmarshalExtJSON([]byte(`{"pipeline":`+value+`}`), false, &wrapper); err != nil {
+			return nil, nil, false, fmt.Errorf("pipeline must be an array of stages: %v", err)
+		}
+		pipeline = wrapper.Pipeline
+	}
+	if value := query.Get("operationType"); len(value) > 0 {
+		pipeline = append([]bson.D{{{Key: "$match", Value: bson.D{{Key: "operationType", Value: bson.D{{Key: "$in", Value: strings.Split(value, ",")}}}}}}}, pipeline...)
+	}
+	streamOptions := options.ChangeStream()
+	if value := query.Get("fullDocument"); len(value) > 0 {
+		streamOptions.SetFullDocument(options.FullDocument(value))
+	}
+	if value := query.Get("resumeAfter"); len(value) > 0 {
+		token := bson.D{}
+		if err := bson.UnmarshalExtJSON([]byte(value), false, &token); err != nil {
+			return nil, nil, false, fmt.Errorf("invalid resumeAfter: %v", err)
+		}
+		streamOptions.SetResumeAfter(token)
+	}
+	var drop bool
+	switch query.Get("overflow") {
+	case "", "close":
+	case "drop":
+		drop = true
+	default:
+		return nil, nil, false, errors.New("overflow must be close or drop")
+	}
+	return pipeline, streamOptions, drop, nil
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	database, collection, err := ParseNamespace(r.URL.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	pipeline, streamOptions, drop, err := stream(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !h.manager.Connected() {
+		http.Error(w, "not connected to database", http.StatusServiceUnavailable)
+		return
+	}
+
+	// The stream is opened before the upgrade so that invalid pipelines and resume tokens are
+	// reported with a status.
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	changes, err := h.manager.Primary().Database(database).Collection(collection).Watch(ctx, pipeline, streamOptions)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("unable to watch %s.%s: %v", database, collection, err), http.StatusBadRequest)
+		return
+	}
+
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has replied with the failure.
+		changes.Close(context.Background())
+		return
+	}
+	defer conn.Close()
+	connections.Inc()
+	defer connections.Dec()
+	klog.V(2).Infof("WebSocket client %s watching %s.%s", r.RemoteAddr, database, collection)
+
+	// Clients only send control frames, they are read to answer pings and notice disconnects.
+	conn.SetReadDeadline(time.Now().Add(2 * pingInterval))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * pingInterval))
+	})
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	size := h.BufferSize
+	if size <= 0 {
+		size = DefaultBufferSize
+	}
+	events := make(chan []byte, size)
+	overflow := make(chan struct{})
+	dropped := make(chan int, 1)
+	// The stream is only used by this goroutine, it closes events when the stream ends and
+	// overflow when the client is too slow.
+	go func() {
+		defer changes.Close(context.Background())
+		for changes.Next(ctx) {
+			data, err := bson.MarshalExtJSON(changes.Current, false, false)
+			if err != nil {
+				klog.Errorf("Unable to encode a change event of %s.%s: %v", database, collection, err)
+				continue
+			}
+			select {
+			case events <- data:
+				continue
+			default:
+			}
+			if !drop {
+				close(overflow)
+				return
+			}
+			eventsDropped.Inc()
+			// Drops are accumulated until the writer picks them up.
+			count := 1
+			select {
+			case previous := <-dropped:
+				count += previous
+			default:
+			}
+			dropped <- count
+		}
+		if err := changes.Err(); err != nil && ctx.Err() == nil {
+			klog.Warningf("Change stream of %s.%s for WebSocket client %s failed: %v", database, collection, r.RemoteAddr, err)
+		}
+		close(events)
+	}()
+
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-overflow:
+			closeWith(conn, websocket.CloseTryAgainLater, "client too slow, resume after the last event received")
+			return
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeTimeout)); err != nil {
+				return
+			}
+		case data, ok := <-events:
+			if !ok {
+				closeWith(conn, websocket.CloseInternalServerErr, "change stream ended")
+				return
+			}
+			select {
+			case count := <-dropped:
+				notice, _ := json.Marshal(map[string]int{"dropped": count})
+				if err := write(conn, notice); err != nil {
+					return
+				}
+			default:
+			}
+			if err := write(conn, data); err != nil {
+				return
+			}
+			eventsSent.Inc()
+		}
+	}
+}
+
+func write(conn *websocket.Conn, data []byte) error {
+	conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return conn.WriteMessage(websocket.TextMessage, data)
+}
+
+func closeWith(conn *websocket.Conn, code int, reason string) {
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(writeTimeout))
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/client"
+	"github.com/bradmwilliams/mongodb-client/pkg/jq"
+	"github.com/spf13/pflag"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"sigs.k8s.io/yaml"
+)
+
+// outputFormats are the values of --output.
+var outputFormats = []string{"json", "yaml", "table", "go-template=TEMPLATE", "go-template-file=PATH"}
+
+// printer writes the results of commands in the format of --output: indented JSON, YAML, a table,
+// or the output of a Go template executed for every result. Documents are written as the Extended
+// JSON of --ejson, and the templates see them decoded from it, so {{.name}} is the field name.
+type printer struct {
+	format   string
+	template *template.Template
+
+	// written counts the results, the YAML documents are separated.
+	written int
+	// columns and rows buffer the documents printed as a table until flush.
+	columns []string
+	rows    []map[string]string
+}
+
+// parseOutput returns the printer of the format output, the default format of each command when
+// it is empty.
+func parseOutput(output string) (*printer, error) {
+	p := &printer{format: output}
+	var text string
+	switch {
+	case output == "", output == "json", output == "yaml", output == "table":
+		return p, nil
+	case strings.HasPrefix(output, "go-template="):
+		text = strings.TrimPrefix(output, "go-template=")
+	case strings.HasPrefix(output, "go-template-file="):
+		data, err := ioutil.ReadFile(strings.TrimPrefix(output, "go-template-file="))
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the template: %w", err)
+		}
+		text = string(data)
+	default:
+		return nil, fmt.Errorf("unsupported output format %q, must be one of: %s", output, strings.Join(outputFormats, ", "))
+	}
+	tmpl, err := template.New("output").Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			data, err := json.Marshal(v)
+			return string(data), err
+		},
+	}).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template: %w", err)
+	}
+	p.format, p.template = "go-template", tmpl
+	return p, nil
+}
+
+// printer returns the printer of --output.
+func (o *options) printer() (*printer, error) {
+	p, err := parseOutput(o.Output)
+	if err != nil {
+		return nil, fmt.Errorf("--output: %w", err)
+	}
+	return p, nil
+}
+
+// jsonFlag is the --json flag of the commands that printed JSON before --output, deprecated for
+// --output json which it sets.
+type jsonFlag struct {
+	o *options
+}
+
+func (f jsonFlag) String() string {
+	return strconv.FormatBool(f.o.Output == "json")
+}
+
+func (f jsonFlag) Set(value string) error {
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return err
+	}
+	if enabled {
+		f.o.Output = "json"
+	}
+	return nil
+}
+
+func (f jsonFlag) Type() string {
+	return "bool"
+}
+
+// addJSONFlag adds the deprecated --json flag to flagset.
+func addJSONFlag(flagset *pflag.FlagSet, o *options) {
+	flagset.Var(jsonFlag{o}, "json", "Print the results as JSON")
+	flagset.Lookup("json").NoOptDefVal = "true"
+	flagset.MarkDeprecated("json", "use --output json instead")
+}
+
+// printCursor writes the documents of cursor to standard output in the format of --output, or the
+// outputs of expr for each document when it is not nil.
+func (o *options) printCursor(ctx context.Context, cursor *mongo.Cursor, expr *jq.Expression) error {
+	out, err := o.printer()
+	if err != nil {
+		return err
+	}
+	if err := client.Each(ctx, cursor, func(doc bson.Raw) error {
+		return out.printTransformed(os.Stdout, doc, expr)
+	}); err != nil {
+		return err
+	}
+	return out.flush(os.Stdout)
+}
+
+// bsonValue is a BSON value that is not a document, such as an output of a jq expression.
+type bsonValue struct {
+	value interface{}
+}
+
+// marshalResult returns v as JSON: the Extended JSON of --ejson for documents and BSON values, and
+// the JSON encoding of the other values, such as the structs listed by commands.
+func marshalResult(v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case bson.D, bson.M, bson.Raw:
+		return ejson.marshal(v, false)
+	case bsonValue:
+		return ejson.marshalValue(v.value)
+	}
+	return json.Marshal(v)
+}
+
+// print writes items, a slice of the results of a list command, in the format of --output, or
+// calls table when the format is table or unset.
+func (p *printer) print(w io.Writer, items interface{}, table func(w io.Writer) error) error {
+	if len(p.format) == 0 || p.format == "table" {
+		return table(w)
+	}
+	values := reflect.ValueOf(items)
+	for i := 0; i < values.Len(); i++ {
+		if err := p.printDocument(w, values.Index(i).Interface()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printDocument writes doc in the format of --output, indented JSON by default. The documents of
+// a table are only written by flush.
+func (p *printer) printDocument(w io.Writer, doc interface{}) error {
+	data, err := marshalResult(doc)
+	if err != nil {
+		return err
+	}
+	defer func() { p.written++ }()
+	switch p.format {
+	case "yaml":
+		if data, err = yaml.JSONToYAML(data); err != nil {
+			return err
+		}
+		if p.written > 0 {
+			data = append([]byte("---\n"), data...)
+		}
+	case "table":
+		return p.addRow(data)
+	case "go-template":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		var value interface{}
+		if err := decoder.Decode(&value); err != nil {
+			return err
+		}
+		var out bytes.Buffer
+		if err := p.template.Execute(&out, value); err != nil {
+			return err
+		}
+		data = append(out.Bytes(), '\n')
+	default:
+		if ejson.Indent > 0 {
+			var out bytes.Buffer
+			if err := json.Indent(&out, data, "", strings.Repeat(" ", ejson.Indent)); err != nil {
+				return err
+			}
+			data = out.Bytes()
+		}
+		data = append(data, '\n')
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// printTransformed writes the outputs of expr for doc, doc itself when expr is nil.
+func (p *printer) printTransformed(w io.Writer, doc bson.Raw, expr *jq.Expression) error {
+	if expr == nil {
+		return p.printDocument(w, doc)
+	}
+	outputs, err := expr.Apply(doc)
+	if err != nil {
+		return err
+	}
+	for _, output := range outputs {
+		if _, ok := output.(bson.D); !ok {
+			output = bsonValue{output}
+		}
+		if err := p.printDocument(w, output); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addRow buffers a row of the fields of the JSON document data, in the order of their first
+// appearance. Values that are not documents fill a single VALUE column.
+func (p *printer) addRow(data []byte) error {
+	row := map[string]string{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		row["value"] = cell(data)
+		p.addColumn("value")
+		p.rows = append(p.rows, row)
+		return nil
+	}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return err
+		}
+		key := token.(string)
+		row[key] = cell(value)
+		p.addColumn(key)
+	}
+	p.rows = append(p.rows, row)
+	return nil
+}
+
+func (p *printer) addColumn(name string) {
+	for _, column := range p.columns {
+		if column == name {
+			return
+		}
+	}
+	p.columns = append(p.columns, name)
+}
+
+// cell returns a JSON value as the cell of a table, strings without their quotes.
+func cell(value json.RawMessage) string {
+	var str string
+	if err := json.Unmarshal(value, &str); err != nil {
+		str = string(value)
+	}
+	// Decimals and longs are printed as their digits rather than as Extended JSON.
+	var number map[string]string
+	if err := json.Unmarshal(value, &number); err == nil && len(number) == 1 {
+		for _, key := range []string{"$numberDecimal", "$numberLong"} {
+			if digits, ok := number[key]; ok {
+				str = digits
+			}
+		}
+	}
+	return strings.NewReplacer("\t", " ", "\n", " ").Replace(str)
+}
+
+// flush writes the documents buffered as a table.
+func (p *printer) flush(w io.Writer) error {
+	if p.format != "table" || len(p.rows) == 0 {
+		return nil
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	headers := make([]string, 0, len(p.columns))
+	for _, column := range p.columns {
+		headers = append(headers, strings.ToUpper(column))
+	}
+	fmt.Fprintln(tw, strings.Join(headers, "\t"))
+	for _, row := range p.rows {
+		cells := make([]string, 0, len(p.columns))
+		for _, column := range p.columns {
+			cells = append(cells, row[column])
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	p.columns, p.rows = nil, nil
+	return tw.Flush()
+}
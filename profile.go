@@ -0,0 +1,304 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func newProfileCommand(o *options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "profile",
+		Short: "Manage the database profiler and report on the slow operations it recorded",
+	}
+	cmd.AddCommand(newProfileEnableCommand(o))
+	cmd.AddCommand(newProfileDisableCommand(o))
+	cmd.AddCommand(newProfileReportCommand(o))
+	return cmd
+}
+
+type profileEnableOptions struct {
+	Database   string
+	All        bool
+	SlowMS     int32
+	SampleRate float64
+}
+
+func newProfileEnableCommand(o *options) *cobra.Command {
+	e := &profileEnableOptions{SlowMS: 100, SampleRate: 1}
+	cmd := &cobra.Command{
+		Use:   "enable",
+		Short: "Record the operations slower than --slow-ms, or all of them, in system.profile",
+		Long: `Set the profiling level of a database to record operations slower than --slow-ms in its
+system.profile collection, or every operation with --all. Profiling slows down the deployment, a
+--sample-rate below 1 records a fraction of the operations.`,
+		Example: `  mongodb-client profile enable --slow-ms 50
+  mongodb-client profile enable --all --sample-rate 0.1`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			if e.SampleRate <= 0 || e.SampleRate > 1 {
+				return fmt.Errorf("--sample-rate must be greater than 0 and at most 1")
+			}
+			if e.SlowMS < 0 {
+				return fmt.Errorf("--slow-ms must not be negative")
+			}
+			level := 1
+			if e.All {
+				level = 2
+			}
+			return setProfile(o, e.Database, bson.D{
+				{Key: "profile", Value: level},
+				{Key: "slowms", Value: e.SlowMS},
+				{Key: "sampleRate", Value: e.SampleRate},
+			})
+		},
+	}
+	flagset := cmd.Flags()
+	flagset.StringVar(&e.Database, "db", e.Database, "Database to profile, defaults to MONGODB_DATABASE")
+	flagset.BoolVar(&e.All, "all", e.All, "Record every operation instead of the slow ones")
+	flagset.Int32Var(&e.SlowMS, "slow-ms", e.SlowMS, "Operations that take longer than this many milliseconds are slow")
+	flagset.Float64Var(&e.SampleRate, "sample-rate", e.SampleRate, "Fraction of the operations that are recorded")
+	return cmd
+}
+
+func newProfileDisableCommand(o *options) *cobra.Command {
+	var database string
+	cmd := &cobra.Command{
+		Use:   "disable",
+		Short: "Stop recording operations in system.profile",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			return setProfile(o, database, bson.D{{Key: "profile", Value: 0}})
+		},
+	}
+	cmd.Flags().StringVar(&database, "db", database, "Database to stop profiling, defaults to MONGODB_DATABASE")
+	return cmd
+}
+
+// setProfile runs the profile command on database and prints the previous and new settings.
+func setProfile(o *options, database string, command bson.D) error {
+	manager, err := o.connect()
+	if err != nil {
+		return err
+	}
+	defer disconnect(manager)
+	if len(database) == 0 {
+		database = manager.Database()
+	}
+	ctx, cancel := manager.Context()
+	defer cancel()
+	var previous struct {
+		Was        int32   `bson:"was"`
+		SlowMS     int32   `bson:"slowms"`
+		SampleRate float64 `bson:"sampleRate"`
+	}
+	if err := manager.Primary().Database(database).RunCommand(ctx, command).Decode(&previous); err != nil {
+		return fmt.Errorf("unable to set the profiling level of %s: %w", database, err)
+	}
+	fmt.Fprintf(os.Stderr, "Profiling level of %s changed from %d (slowms %d, sample rate %g) to %v\n",
+		database, previous.Was, previous.SlowMS, previous.SampleRate, command[0].Value)
+	return nil
+}
+
+type profileReportOptions struct {
+	Database string
+	Since    time.Duration
+	Limit    int
+	MinRatio float64
+}
+
+// queryShape aggregates the profiled operations of a namespace sharing a query hash and plan.
+type queryShape struct {
+	Namespace    string   `bson:"ns" json:"ns"`
+	Op           string   `bson:"op" json:"op"`
+	QueryHash    string   `bson:"queryHash" json:"queryHash,omitempty"`
+	PlanSummary  string   `bson:"planSummary" json:"planSummary,omitempty"`
+	Count        int64    `bson:"count" json:"count"`
+	TotalMillis  int64    `bson:"totalMillis" json:"totalMillis"`
+	MaxMillis    int64    `bson:"maxMillis" json:"maxMillis"`
+	DocsExamined int64    `bson:"docsExamined" json:"docsExamined"`
+	KeysExamined int64    `bson:"keysExamined" json:"keysExamined"`
+	Returned     int64    `bson:"returned" json:"returned"`
+	Fields       []string `bson:"-" json:"fields,omitempty"`
+	Command      bson.Raw `bson:"command" json:"-"`
+}
+
+// examinedRatio returns the number of documents examined per document returned.
+func (s *queryShape) examinedRatio() float64 {
+	return float64(s.DocsExamined) / float64(max64(s.Returned, 1))
+}
+
+func (s *queryShape) collectionScan() bool {
+	return strings.HasPrefix(s.PlanSummary, "COLLSCAN")
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// profileReport is the report of the operations recorded by the profiler.
+type profileReport struct {
+	Slowest         []queryShape `json:"slowest"`
+	CollectionScans []queryShape `json:"collectionScans"`
+	MissingIndexes  []queryShape `json:"missingIndexCandidates"`
+}
+
+func newProfileReportCommand(o *options) *cobra.Command {
+	r := &profileReportOptions{Since: 24 * time.Hour, Limit: 10, MinRatio: 10}
+	cmd := &cobra.Command{
+		Use:   "report",
+		Short: "Report the slowest query shapes, collection scans and missing index candidates",
+		Long: `Group the operations recorded in system.profile by namespace, query hash and plan, and report:
+
+* the query shapes that took the longest in total
+* the query shapes that scanned whole collections
+* the query shapes examining more than --min-ratio documents per document returned, with the fields
+  of their filter as candidate index keys`,
+		Example: `  mongodb-client profile report --since 1h
+  mongodb-client profile report --db sampledb --limit 20 -o json`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			return r.run(o)
+		},
+	}
+	flagset := cmd.Flags()
+	flagset.StringVar(&r.Database, "db", r.Database, "Database of the profiled operations, defaults to MONGODB_DATABASE")
+	flagset.DurationVar(&r.Since, "since", r.Since, "Only report the operations recorded this long ago or less")
+	flagset.IntVar(&r.Limit, "limit", r.Limit, "Maximum number of query shapes in each section of the report")
+	flagset.Float64Var(&r.MinRatio, "min-ratio", r.MinRatio, "Documents examined per document returned that make a query shape a missing index candidate")
+	addJSONFlag(flagset, o)
+	return cmd
+}
+
+func (r *profileReportOptions) run(o *options) error {
+	if r.Limit < 1 {
+		return fmt.Errorf("--limit must be at least 1")
+	}
+	out, err := o.printer()
+	if err != nil {
+		return err
+	}
+	manager, err := o.connect()
+	if err != nil {
+		return err
+	}
+	defer disconnect(manager)
+	database := r.Database
+	if len(database) == 0 {
+		database = manager.Database()
+	}
+	ctx, cancel := manager.Context()
+	defer cancel()
+
+	match := bson.D{{Key: "ns", Value: bson.D{{Key: "$ne", Value: database + ".system.profile"}}}}
+	if r.Since > 0 {
+		match = append(match, bson.E{Key: "ts", Value: bson.D{{Key: "$gte", Value: time.Now().Add(-r.Since)}}})
+	}
+	cursor, err := manager.Primary().Database(database).Collection("system.profile").Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: bson.D{{Key: "ns", Value: "$ns"}, {Key: "op", Value: "$op"}, {Key: "queryHash", Value: "$queryHash"}, {Key: "planSummary", Value: "$planSummary"}}},
+			{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}},
+			{Key: "totalMillis", Value: bson.D{{Key: "$sum", Value: "$millis"}}},
+			{Key: "maxMillis", Value: bson.D{{Key: "$max", Value: "$millis"}}},
+			{Key: "docsExamined", Value: bson.D{{Key: "$sum", Value: "$docsExamined"}}},
+			{Key: "keysExamined", Value: bson.D{{Key: "$sum", Value: "$keysExamined"}}},
+			{Key: "returned", Value: bson.D{{Key: "$sum", Value: "$nreturned"}}},
+			{Key: "command", Value: bson.D{{Key: "$first", Value: "$command"}}},
+		}}},
+		{{Key: "$replaceWith", Value: bson.D{{Key: "$mergeObjects", Value: bson.A{"$_id", "$$ROOT"}}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "totalMillis", Value: -1}}}},
+	})
+	if err != nil {
+		return fmt.Errorf("unable to read the profiled operations of %s: %w", database, err)
+	}
+	var shapes []queryShape
+	if err := cursor.All(ctx, &shapes); err != nil {
+		return fmt.Errorf("unable to read the profiled operations of %s: %w", database, err)
+	}
+
+	report := profileReport{}
+	for _, shape := range shapes {
+		shape.Fields = filterFields(shape.Command)
+		if len(report.Slowest) < r.Limit {
+			report.Slowest = append(report.Slowest, shape)
+		}
+		if shape.collectionScan() && len(report.CollectionScans) < r.Limit {
+			report.CollectionScans = append(report.CollectionScans, shape)
+		}
+		if shape.DocsExamined > 0 && shape.examinedRatio() >= r.MinRatio {
+			report.MissingIndexes = append(report.MissingIndexes, shape)
+		}
+	}
+	sort.SliceStable(report.MissingIndexes, func(i, j int) bool {
+		return report.MissingIndexes[i].examinedRatio() > report.MissingIndexes[j].examinedRatio()
+	})
+	if len(report.MissingIndexes) > r.Limit {
+		report.MissingIndexes = report.MissingIndexes[:r.Limit]
+	}
+
+	return out.print(os.Stdout, []profileReport{report}, func(stdout io.Writer) error {
+		if len(shapes) == 0 {
+			fmt.Fprintf(os.Stderr, "No operation of %s was profiled, see profile enable\n", database)
+			return nil
+		}
+		w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "SLOWEST\tOP\tPLAN\tFILTER\tCOUNT\tTOTAL\tMAX\tEXAMINED\tRETURNED")
+		for _, shape := range report.Slowest {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%dms\t%dms\t%d\t%d\n", shape.Namespace, shape.Op, shape.PlanSummary,
+				strings.Join(shape.Fields, ","), shape.Count, shape.TotalMillis, shape.MaxMillis, shape.DocsExamined, shape.Returned)
+		}
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "COLLECTION SCANS\tOP\tFILTER\tCOUNT\tTOTAL\tEXAMINED\tRETURNED")
+		for _, shape := range report.CollectionScans {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%dms\t%d\t%d\n", shape.Namespace, shape.Op, strings.Join(shape.Fields, ","),
+				shape.Count, shape.TotalMillis, shape.DocsExamined, shape.Returned)
+		}
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "MISSING INDEX CANDIDATES\tOP\tPLAN\tCANDIDATE KEYS\tCOUNT\tEXAMINED PER RETURNED")
+		for _, shape := range report.MissingIndexes {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%.0f\n", shape.Namespace, shape.Op, shape.PlanSummary,
+				strings.Join(shape.Fields, ","), shape.Count, shape.examinedRatio())
+		}
+		return w.Flush()
+	})
+}
+
+// filterFields returns the top level fields of the filter of a profiled command: the filter of a
+// find, the query of an update, delete or count, or the leading $match stage of an aggregation.
+func filterFields(command bson.Raw) []string {
+	var filter bson.Raw
+	for _, field := range []string{"filter", "q", "query"} {
+		if value, err := command.LookupErr(field); err == nil {
+			filter, _ = value.DocumentOK()
+			break
+		}
+	}
+	if filter == nil {
+		if value, err := command.LookupErr("pipeline", "0", "$match"); err == nil {
+			filter, _ = value.DocumentOK()
+		}
+	}
+	elements, err := filter.Elements()
+	if err != nil {
+		return nil
+	}
+	var fields []string
+	for _, element := range elements {
+		if key := element.Key(); !strings.HasPrefix(key, "$") {
+			fields = append(fields, key)
+		}
+	}
+	return fields
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/provision"
+	"github.com/spf13/cobra"
+	"go.mongodb.org/mongo-driver/mongo"
+	"k8s.io/klog"
+)
+
+type provisionOptions struct {
+	Manifest     string
+	PruneIndexes bool
+	DryRun       bool
+}
+
+func newProvisionCommand(o *options) *cobra.Command {
+	p := &provisionOptions{}
+	cmd := &cobra.Command{
+		Use:   "provision",
+		Short: "Reconcile databases, collections, indexes, users and roles with a manifest",
+		Long: `Create the collections, indexes, users and roles declared in a YAML or JSON manifest, and
+update the validators, indexes, user grants and role privileges that differ from it. Every change is
+printed, prefixed with + for creations, ~ for modifications and - for removals, --dry-run only prints
+them.
+
+Collections, users and roles that are not declared are left alone, the indexes of declared
+collections that are not declared are only dropped with --prune-indexes. Passwords are read from
+the environment variable named by the passwordEnv of each user when it is created.`,
+		Example: `  mongodb-client provision --manifest manifest.yaml --dry-run
+
+  # manifest.yaml
+  databases:
+  - name: sampledb
+    collections:
+    - name: episodes
+      validator: {"$jsonSchema": {"required": ["title"]}}
+      indexes:
+      - keys: ["podcast", "-published"]
+      - name: title_text
+        keys: ["title:text"]
+    - name: events
+      capped: true
+      size: 1048576
+    users:
+    - name: app
+      passwordEnv: APP_PASSWORD
+      roles: [{role: readWrite}]`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			return p.run(o)
+		},
+	}
+
+	flagset := cmd.Flags()
+	flagset.StringVar(&p.Manifest, "manifest", p.Manifest, "YAML or JSON manifest declaring the databases of the deployment")
+	flagset.BoolVar(&p.PruneIndexes, "prune-indexes", p.PruneIndexes, "Drop the indexes of the declared collections that are not declared")
+	flagset.BoolVar(&p.DryRun, "dry-run", p.DryRun, "Print the changes without applying them")
+	cmd.MarkFlagRequired("manifest")
+	return cmd
+}
+
+func (p *provisionOptions) run(o *options) error {
+	manifest, err := provision.Load(p.Manifest)
+	if err != nil {
+		return validationError(err)
+	}
+	manager, err := o.connect()
+	if err != nil {
+		return err
+	}
+	defer disconnect(manager)
+
+	ctx, cancel := cmdContext()
+	defer cancel()
+	return reconcile(ctx, manager.Admin(), manifest, provision.Options{PruneIndexes: p.PruneIndexes}, p.DryRun, os.Stdout)
+}
+
+// reconcile prints the changes making the deployment of client match manifest to out and applies
+// them unless dryRun is set.
+func reconcile(ctx context.Context, client *mongo.Client, manifest *provision.Manifest, opts provision.Options, dryRun bool, out io.Writer) error {
+	changes, err := provision.Plan(ctx, client, manifest, opts)
+	if err != nil {
+		return fmt.Errorf("unable to compare the deployment with the manifest: %w", err)
+	}
+	return applyChanges(ctx, changes, dryRun, out)
+}
+
+// applyChanges prints changes to out and applies them unless dryRun is set.
+func applyChanges(ctx context.Context, changes []provision.Change, dryRun bool, out io.Writer) error {
+	if len(changes) == 0 {
+		fmt.Fprintln(out, "The deployment matches the manifest")
+		return nil
+	}
+	for _, change := range changes {
+		fmt.Fprintln(out, change)
+	}
+	if dryRun {
+		fmt.Fprintf(out, "%d changes not applied\n", len(changes))
+		return nil
+	}
+	if err := provision.Apply(ctx, changes); err != nil {
+		return err
+	}
+	klog.Infof("Applied %d changes of the manifest", len(changes))
+	return nil
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/client"
+	"github.com/bradmwilliams/mongodb-client/pkg/jq"
+	"github.com/spf13/cobra"
+	"go.mongodb.org/mongo-driver/bson"
+	mongoOptions "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type queryOptions struct {
+	Database   string
+	Collection string
+	Filter     string
+	Sort       string
+	Projection string
+	Skip       int64
+	Limit      int64
+	Explain    string
+	BatchSize  int32
+	Joins      []string
+	JoinsMany  []string
+	JoinFilter string
+	Total      bool
+	JQ         string
+	Lint       lintOptions
+	Params     []string
+}
+
+func newQueryCommand(o *options) *cobra.Command {
+	q := &queryOptions{}
+	cmd := &cobra.Command{
+		Use:   "query",
+		Short: "Run a find query and print the matching documents as Extended JSON",
+		Long: `Run a find query and print the matching documents as Extended JSON.
+
+With --join or --join-many the documents are printed with the documents they reference, found by a
+$lookup aggregation. A join is written COLLECTION:LOCAL=FOREIGN[:AS]: the documents of COLLECTION
+whose FOREIGN field equals the LOCAL field are embedded as AS, which defaults to LOCAL so that the
+reference is replaced by the document it references. --join embeds the first of them, --join-many
+the array of all of them.
+
+--filter selects the documents before they are joined and --join-filter after, so that it can refer
+to the joined fields. The page of --sort, --skip and --limit is selected before the joins unless
+--join-filter or --sort refer to joined fields.
+
+The documents of --filter, --sort, --project and --join-filter containing {{ are Go templates of the
+parameters set by --param NAME=VALUE, which render Extended JSON: {{.NAME}} is the value as a JSON
+string and {{.NAME | TYPE}} the value converted by one of the functions string, int, long, double,
+decimal, bool, date, oid or json, which inserts valid JSON as is. Dates are written 2006-01-02,
+2006-01-02T15:04:05Z or as milliseconds since the epoch, and {{now | date}} and {{ago "24h" | date}}
+are relative to the current time. The other commands accepting --param render their documents the
+same way.
+
+The filter is checked before it is run: unknown operators are rejected, and filters that no index
+supports on collections of at least --collscan-threshold documents are warned about. --lint-only
+only checks it.
+
+Named, parameterized queries shared by a team are saved with query save and run with query run.`,
+		Example: `  mongodb-client query --db sampledb --collection episodes --filter '{"duration":{"$gt":25}}' \
+    --sort '{"duration":-1}' --project '{"title":1}' --limit 100
+  mongodb-client query --collection episodes --filter '{"podcast":"weekly"}' --explain executionStats
+  mongodb-client query --collection episodes --filter '{"published":{"$gte":{"$date":"2024-01-01T00:00:00Z"}}}' --lint-only
+  mongodb-client query --collection episodes --filter '{"published":{"$gte":{{.since | date}}},"podcast":{{.podcast | oid}}}' \
+    --param since=2024-01-01 --param podcast=5f1f77bcf86cd799439011aa
+  mongodb-client query --collection episodes --join podcasts:podcast=_id --sort '{"published":-1}' --skip 20 --limit 20
+  mongodb-client query --collection podcasts --join-many episodes:_id=podcast:episodes \
+    --join-filter '{"episodes.duration":{"$gt":25}}' --total`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			return q.run(o)
+		},
+	}
+
+	flagset := cmd.Flags()
+	flagset.StringVar(&q.Database, "db", q.Database, "Database to query, defaults to MONGODB_DATABASE")
+	flagset.StringVar(&q.Collection, "collection", q.Collection, "Collection to query")
+	flagset.StringVar(&q.Filter, "filter", q.Filter, "Extended JSON query filter")
+	flagset.StringVar(&q.Sort, "sort", q.Sort, "Extended JSON sort specification")
+	flagset.StringVar(&q.Projection, "project", q.Projection, "Extended JSON projection")
+	flagset.Int64Var(&q.Skip, "skip", q.Skip, "Number of matching documents to skip")
+	flagset.Int64Var(&q.Limit, "limit", q.Limit, "Maximum number of documents to return (0 means no limit)")
+	flagset.Int32Var(&q.BatchSize, "batch-size", q.BatchSize, "Number of documents per cursor batch (0 uses the server default)")
+	flagset.StringVar(&q.Explain, "explain", q.Explain, "Print the plan of the query instead of its results: queryPlanner, executionStats or allPlansExecution")
+	flagset.StringArrayVar(&q.Joins, "join", q.Joins, "Embed the first document referenced by each document, as COLLECTION:LOCAL=FOREIGN[:AS], may be repeated")
+	flagset.StringArrayVar(&q.JoinsMany, "join-many", q.JoinsMany, "Embed the array of the documents referencing or referenced by each document, as COLLECTION:LOCAL=FOREIGN[:AS], may be repeated")
+	flagset.StringVar(&q.JoinFilter, "join-filter", q.JoinFilter, "Extended JSON query filter applied after the joins, which may refer to the joined fields")
+	flagset.BoolVar(&q.Total, "total", q.Total, "Print the number of joined documents of every page to stderr")
+	addJQFlag(flagset, &q.JQ)
+	addLintFlags(flagset, &q.Lint)
+	addParamFlag(flagset, &q.Params)
+	cmd.MarkFlagRequired("collection")
+	addQueryLibraryCommands(cmd, o)
+	return cmd
+}
+
+func (q *queryOptions) run(o *options) error {
+	if q.Limit < 0 || q.Skip < 0 {
+		return fmt.Errorf("--limit and --skip must not be negative")
+	}
+	if q.BatchSize < 0 {
+		return fmt.Errorf("--batch-size must not be negative")
+	}
+	if err := validateExplain(q.Explain); err != nil {
+		return err
+	}
+	if err := renderTemplates(q.Params, map[string]*string{"--filter": &q.Filter, "--sort": &q.Sort, "--project": &q.Projection, "--join-filter": &q.JoinFilter}); err != nil {
+		return err
+	}
+	filter, err := parseDocument(q.Filter)
+	if err != nil {
+		return fmt.Errorf("--filter: %w", err)
+	}
+	expr, err := parseJQ(q.JQ)
+	if err != nil {
+		return err
+	}
+	if len(q.Joins) > 0 || len(q.JoinsMany) > 0 {
+		return q.runJoin(o, filter, expr)
+	}
+	if len(q.JoinFilter) > 0 || q.Total {
+		return fmt.Errorf("--join-filter and --total require --join or --join-many")
+	}
+	findOptions := mongoOptions.Find().SetSkip(q.Skip).SetLimit(q.Limit)
+	if q.BatchSize > 0 {
+		findOptions.SetBatchSize(q.BatchSize)
+	}
+	command := bson.D{{Key: "find", Value: q.Collection}, {Key: "filter", Value: filter}}
+	if len(q.Sort) > 0 {
+		sort, err := parseDocument(q.Sort)
+		if err != nil {
+			return fmt.Errorf("--sort: %w", err)
+		}
+		findOptions.SetSort(sort)
+		command = append(command, bson.E{Key: "sort", Value: sort})
+	}
+	if len(q.Projection) > 0 {
+		projection, err := parseDocument(q.Projection)
+		if err != nil {
+			return fmt.Errorf("--project: %w", err)
+		}
+		findOptions.SetProjection(projection)
+		command = append(command, bson.E{Key: "projection", Value: projection})
+	}
+	if q.Skip > 0 {
+		command = append(command, bson.E{Key: "skip", Value: q.Skip})
+	}
+	if q.Limit > 0 {
+		command = append(command, bson.E{Key: "limit", Value: q.Limit})
+	}
+
+	manager, err := o.connect()
+	if err != nil {
+		return err
+	}
+	defer disconnect(manager)
+
+	database := q.Database
+	if len(database) == 0 {
+		database = manager.Database()
+	}
+
+	collection := manager.Primary().Database(database).Collection(q.Collection)
+
+	ctx, cancel := manager.ContextFor(client.ReadOperation)
+	defer cancel()
+	if err := q.Lint.check(ctx, collection, filter, nil); err != nil || q.Lint.Only {
+		return err
+	}
+	if len(q.Explain) > 0 {
+		return explain(ctx, os.Stdout, collection.Database(), command, q.Explain)
+	}
+	findOptions.MaxTime = client.MaxTime(ctx)
+	cursor, err := collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return err
+	}
+	return o.printCursor(ctx, cursor, expr)
+}
+
+// runJoin runs the query as an aggregation embedding the documents of the joins.
+func (q *queryOptions) runJoin(o *options, filter bson.D, expr *jq.Expression) error {
+	query := &client.JoinQuery{Filter: filter, Skip: q.Skip, Limit: q.Limit}
+	for _, spec := range q.Joins {
+		join, err := parseJoin(spec, true)
+		if err != nil {
+			return fmt.Errorf("--join: %w", err)
+		}
+		query.Joins = append(query.Joins, join)
+	}
+	for _, spec := range q.JoinsMany {
+		join, err := parseJoin(spec, false)
+		if err != nil {
+			return fmt.Errorf("--join-many: %w", err)
+		}
+		query.Joins = append(query.Joins, join)
+	}
+	var err error
+	if query.Match, err = parseDocument(q.JoinFilter); err != nil {
+		return fmt.Errorf("--join-filter: %w", err)
+	}
+	if len(q.Sort) > 0 {
+		if query.Sort, err = parseDocument(q.Sort); err != nil {
+			return fmt.Errorf("--sort: %w", err)
+		}
+	}
+	if len(q.Projection) > 0 {
+		if query.Projection, err = parseDocument(q.Projection); err != nil {
+			return fmt.Errorf("--project: %w", err)
+		}
+	}
+	if err := query.Validate(); err != nil {
+		return err
+	}
+
+	manager, err := o.connect()
+	if err != nil {
+		return err
+	}
+	defer disconnect(manager)
+
+	database := q.Database
+	if len(database) == 0 {
+		database = manager.Database()
+	}
+	collection := manager.Primary().Database(database).Collection(q.Collection)
+
+	ctx, cancel := manager.ContextFor(client.AggregateOperation)
+	defer cancel()
+	if err := q.Lint.check(ctx, collection, nil, query.Pipeline()); err != nil || q.Lint.Only {
+		return err
+	}
+	if len(q.Explain) > 0 {
+		command := bson.D{
+			{Key: "aggregate", Value: q.Collection},
+			{Key: "pipeline", Value: query.Pipeline()},
+			{Key: "cursor", Value: bson.D{}},
+		}
+		return explain(ctx, os.Stdout, collection.Database(), command, q.Explain)
+	}
+	if q.Total {
+		total, err := query.Count(ctx, collection)
+		if err != nil {
+			return fmt.Errorf("unable to count the joined documents: %w", err)
+		}
+		fmt.Fprintf(os.Stderr, "%d documents\n", total)
+	}
+	aggregateOptions := mongoOptions.Aggregate()
+	aggregateOptions.MaxTime = client.MaxTime(ctx)
+	if q.BatchSize > 0 {
+		aggregateOptions.SetBatchSize(q.BatchSize)
+	}
+	cursor, err := query.Find(ctx, collection, aggregateOptions)
+	if err != nil {
+		return err
+	}
+	return o.printCursor(ctx, cursor, expr)
+}
+
+// parseJoin parses a join written COLLECTION:LOCAL=FOREIGN[:AS], embedding a single document when one
+// is set.
+func parseJoin(spec string, one bool) (client.Join, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return client.Join{}, fmt.Errorf("%q must be COLLECTION:LOCAL=FOREIGN[:AS]", spec)
+	}
+	fields := strings.SplitN(parts[1], "=", 2)
+	if len(fields) != 2 {
+		return client.Join{}, fmt.Errorf("%q must be COLLECTION:LOCAL=FOREIGN[:AS]", spec)
+	}
+	join := client.Join{From: parts[0], LocalField: fields[0], ForeignField: fields[1], One: one}
+	if len(parts) == 3 {
+		join.As = parts[2]
+	}
+	return join, join.Validate()
+}
@@ -0,0 +1,576 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/client"
+	"github.com/bradmwilliams/mongodb-client/pkg/lint"
+	"github.com/bradmwilliams/mongodb-client/pkg/savedquery"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mongoOptions "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// libraryOptions locate the saved queries: a collection, or a local file when File is set.
+type libraryOptions struct {
+	File       string
+	Database   string
+	Collection string
+}
+
+func addLibraryFlags(flagset *pflag.FlagSet, l *libraryOptions) {
+	l.Collection = "savedQueries"
+	flagset.StringVar(&l.File, "library-file", l.File, "YAML file holding the saved queries instead of --library-collection")
+	flagset.StringVar(&l.Database, "library-db", l.Database, "Database of the saved queries, defaults to MONGODB_DATABASE")
+	flagset.StringVar(&l.Collection, "library-collection", l.Collection, "Collection holding the saved queries")
+}
+
+// store returns the saved queries, manager is only used, and may only be nil, for a file.
+func (l *libraryOptions) store(manager *client.ConnectionManager) savedquery.Store {
+	if len(l.File) > 0 {
+		return &savedquery.FileStore{Path: l.File}
+	}
+	database := l.Database
+	if len(database) == 0 {
+		database = manager.Database()
+	}
+	return &savedquery.CollectionStore{Collection: manager.Primary().Database(database).Collection(l.Collection)}
+}
+
+// open returns the saved queries, connecting to the database unless they are stored in a file, in
+// which case the manager is nil.
+func (l *libraryOptions) open(o *options) (savedquery.Store, *client.ConnectionManager, error) {
+	if len(l.File) > 0 {
+		return l.store(nil), nil, nil
+	}
+	manager, err := o.connect()
+	if err != nil {
+		return nil, nil, err
+	}
+	return l.store(manager), manager, nil
+}
+
+// libraryContext returns the context of an operation of kind on the saved queries, manager is nil
+// when they are stored in a file.
+func libraryContext(manager *client.ConnectionManager, kind client.OperationKind) (context.Context, context.CancelFunc) {
+	if manager == nil {
+		return cmdContext()
+	}
+	return manager.ContextFor(kind)
+}
+
+// getSavedQuery returns a version of the saved query called name, the latest when version is zero.
+func getSavedQuery(store savedquery.Store, manager *client.ConnectionManager, name string, version int) (*savedquery.Query, error) {
+	ctx, cancel := libraryContext(manager, client.ReadOperation)
+	defer cancel()
+	q, err := store.Get(ctx, name, version)
+	if err == savedquery.ErrNotFound {
+		if version > 0 {
+			return nil, fmt.Errorf("version %d of query %s is not saved", version, name)
+		}
+		return nil, fmt.Errorf("query %s is not saved", name)
+	}
+	return q, err
+}
+
+func addQueryLibraryCommands(cmd *cobra.Command, o *options) {
+	cmd.AddCommand(newQueryRunCommand(o))
+	cmd.AddCommand(newQuerySaveCommand(o))
+	cmd.AddCommand(newQueryListCommand(o))
+	cmd.AddCommand(newQueryShowCommand(o))
+	cmd.AddCommand(newQueryHistoryCommand(o))
+	cmd.AddCommand(newQueryDeleteCommand(o))
+	cmd.AddCommand(newQueryLintCommand(o))
+}
+
+type queryRunOptions struct {
+	Library libraryOptions
+	Version int
+	Params  []string
+	JQ      string
+	Lint    lintOptions
+}
+
+func newQueryRunCommand(o *options) *cobra.Command {
+	r := &queryRunOptions{}
+	cmd := &cobra.Command{
+		Use:   "run NAME",
+		Short: "Run a saved query and print the documents it returns",
+		Long: `Run the latest version of the saved query NAME, or the one of --version, with the values of its
+parameters set by --param. Parameters without a default are required. Queries saved with roles are
+only run for the users holding one of them.`,
+		Example: `  mongodb-client query run nightly-report --param since=2024-01-01
+  mongodb-client query run nightly-report --version 2 --param since=2024-01-01 --param minDuration=25`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			return r.run(o, arguments[0])
+		},
+	}
+	flagset := cmd.Flags()
+	addLibraryFlags(flagset, &r.Library)
+	flagset.IntVar(&r.Version, "version", r.Version, "Version of the query to run, defaults to the latest")
+	flagset.StringArrayVar(&r.Params, "param", r.Params, "Value of a parameter of the query as NAME=VALUE, may be repeated")
+	addJQFlag(flagset, &r.JQ)
+	addLintFlags(flagset, &r.Lint)
+	return cmd
+}
+
+func (r *queryRunOptions) run(o *options, name string) error {
+	values, err := parseParams(r.Params)
+	if err != nil {
+		return err
+	}
+	expr, err := parseJQ(r.JQ)
+	if err != nil {
+		return err
+	}
+
+	manager, err := o.connect()
+	if err != nil {
+		return err
+	}
+	defer disconnect(manager)
+
+	q, err := getSavedQuery(r.Library.store(manager), manager, name, r.Version)
+	if err != nil {
+		return err
+	}
+	bound, err := q.Bind(values)
+	if err != nil {
+		return err
+	}
+	database := q.Database
+	if len(database) == 0 {
+		database = manager.Database()
+	}
+	collection := manager.Primary().Database(database).Collection(q.Collection)
+
+	if len(q.Pipeline) > 0 {
+		ctx, cancel := manager.ContextFor(client.AggregateOperation)
+		defer cancel()
+		if err := savedquery.Authorize(ctx, manager.Primary(), q); err != nil {
+			return err
+		}
+		if err := r.Lint.check(ctx, collection, nil, bound.Pipeline); err != nil || r.Lint.Only {
+			return err
+		}
+		aggregateOptions := mongoOptions.Aggregate()
+		aggregateOptions.MaxTime = client.MaxTime(ctx)
+		cursor, err := collection.Aggregate(ctx, bound.Pipeline, aggregateOptions)
+		if err != nil {
+			return err
+		}
+		return o.printCursor(ctx, cursor, expr)
+	}
+	ctx, cancel := manager.ContextFor(client.ReadOperation)
+	defer cancel()
+	if err := savedquery.Authorize(ctx, manager.Primary(), q); err != nil {
+		return err
+	}
+	filter := bound.Filter
+	if filter == nil {
+		filter = bson.D{}
+	}
+	if err := r.Lint.check(ctx, collection, filter, nil); err != nil || r.Lint.Only {
+		return err
+	}
+	findOptions := mongoOptions.Find().SetLimit(q.Limit)
+	findOptions.MaxTime = client.MaxTime(ctx)
+	if bound.Sort != nil {
+		findOptions.SetSort(bound.Sort)
+	}
+	if bound.Projection != nil {
+		findOptions.SetProjection(bound.Projection)
+	}
+	cursor, err := collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return err
+	}
+	return o.printCursor(ctx, cursor, expr)
+}
+
+type querySaveOptions struct {
+	Library libraryOptions
+	Query   savedquery.Query
+	Params  []string
+}
+
+func newQuerySaveCommand(o *options) *cobra.Command {
+	s := &querySaveOptions{}
+	cmd := &cobra.Command{
+		Use:   "save NAME",
+		Short: "Save a query or pipeline as the next version of a named query",
+		Long: `Save a find query, with --filter, --sort, --project and --limit, or an aggregation --pipeline as the
+next version of the query NAME. The previous versions are kept.
+
+In the Extended JSON documents {"$param": "NAME"} is replaced by the value of the parameter NAME when
+the query is run. Parameters are declared with --define NAME:TYPE[=DEFAULT], where TYPE is one of
+string, int, long, double, decimal, bool, date and objectId, and are required unless they have a
+default. Dates are written 2006-01-02 or 2006-01-02T15:04:05Z.
+
+--role restricts running the query to the users holding one of the roles. Who may save and delete
+queries is controlled by the privileges on --library-collection.`,
+		Example: `  mongodb-client query save nightly-report --collection episodes \
+    --filter '{"published":{"$gte":{"$param":"since"}},"duration":{"$gt":{"$param":"minDuration"}}}' \
+    --define since:date --define minDuration:int=0 --sort '{"published":-1}' \
+    --description "Episodes published since a day" --tag reporting --role read@sampledb
+  mongodb-client query save durations-by-podcast --collection episodes \
+    --pipeline '[{"$group":{"_id":"$podcast","total":{"$sum":"$duration"}}}]' --library-file queries.yaml`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			s.Query.Name = arguments[0]
+			return s.run(o)
+		},
+	}
+	flagset := cmd.Flags()
+	addLibraryFlags(flagset, &s.Library)
+	flagset.StringVar(&s.Query.Database, "db", s.Query.Database, "Database the query runs in, defaults to the MONGODB_DATABASE of whoever runs it")
+	flagset.StringVar(&s.Query.Collection, "collection", s.Query.Collection, "Collection the query runs on")
+	flagset.StringVar(&s.Query.Filter, "filter", s.Query.Filter, "Extended JSON query filter")
+	flagset.StringVar(&s.Query.Sort, "sort", s.Query.Sort, "Extended JSON sort specification")
+	flagset.StringVar(&s.Query.Projection, "project", s.Query.Projection, "Extended JSON projection")
+	flagset.Int64Var(&s.Query.Limit, "limit", s.Query.Limit, "Maximum number of documents to return (0 means no limit)")
+	flagset.StringVar(&s.Query.Pipeline, "pipeline", s.Query.Pipeline, "JSON array of aggregation stages, instead of a find")
+	flagset.StringArrayVar(&s.Params, "define", s.Params, "Declare a parameter as NAME:TYPE[=DEFAULT], may be repeated")
+	flagset.StringVar(&s.Query.Description, "description", s.Query.Description, "Description of the query")
+	flagset.StringSliceVar(&s.Query.Tags, "tag", s.Query.Tags, "Tags of the query, such as the team owning it")
+	flagset.StringSliceVar(&s.Query.Roles, "role", s.Query.Roles, "Roles allowed to run the query, as role or role@db, anyone when unset")
+	cmd.MarkFlagRequired("collection")
+	return cmd
+}
+
+func (s *querySaveOptions) run(o *options) error {
+	for _, spec := range s.Params {
+		param, err := parseParamDefinition(spec)
+		if err != nil {
+			return fmt.Errorf("--define: %w", err)
+		}
+		s.Query.Params = append(s.Query.Params, param)
+	}
+	if current, err := user.Current(); err == nil {
+		s.Query.CreatedBy = current.Username
+	}
+	if err := s.Query.Validate(); err != nil {
+		return err
+	}
+
+	store, manager, err := s.Library.open(o)
+	if err != nil {
+		return err
+	}
+	if manager != nil {
+		defer disconnect(manager)
+	}
+	ctx, cancel := libraryContext(manager, client.WriteOperation)
+	defer cancel()
+	if collectionStore, ok := store.(*savedquery.CollectionStore); ok {
+		if err := collectionStore.EnsureIndexes(ctx); err != nil {
+			return err
+		}
+	}
+	if err := store.Save(ctx, &s.Query); err != nil {
+		return err
+	}
+	fmt.Printf("Saved version %d of query %s\n", s.Query.Version, s.Query.Name)
+	return nil
+}
+
+// parseParamDefinition parses a parameter written NAME:TYPE[=DEFAULT].
+func parseParamDefinition(spec string) (savedquery.Param, error) {
+	parts := strings.SplitN(spec, ":", 2)
+	if len(parts) != 2 {
+		return savedquery.Param{}, fmt.Errorf("%q must be NAME:TYPE[=DEFAULT]", spec)
+	}
+	param := savedquery.Param{Name: parts[0], Type: parts[1]}
+	if i := strings.Index(parts[1], "="); i >= 0 {
+		def := parts[1][i+1:]
+		param.Type, param.Default = parts[1][:i], &def
+	}
+	return param, nil
+}
+
+func newQueryListCommand(o *options) *cobra.Command {
+	var library libraryOptions
+	var tags []string
+	cmd := &cobra.Command{
+		Use:     "list",
+		Short:   "List the latest version of the saved queries",
+		Example: `  mongodb-client query list --tag reporting`,
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			store, manager, err := library.open(o)
+			if err != nil {
+				return err
+			}
+			if manager != nil {
+				defer disconnect(manager)
+			}
+			ctx, cancel := libraryContext(manager, client.ReadOperation)
+			defer cancel()
+			queries, err := store.List(ctx)
+			if err != nil {
+				return err
+			}
+			var listed []savedquery.Query
+			for _, q := range queries {
+				if hasTags(q.Tags, tags) {
+					listed = append(listed, q)
+				}
+			}
+			out, err := o.printer()
+			if err != nil {
+				return err
+			}
+			return out.print(os.Stdout, listed, func(stdout io.Writer) error {
+				w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+				fmt.Fprintln(w, "NAME\tVERSION\tCOLLECTION\tPARAMS\tTAGS\tROLES\tDESCRIPTION")
+				for _, q := range listed {
+					params := make([]string, 0, len(q.Params))
+					for _, param := range q.Params {
+						params = append(params, param.Name+":"+param.Type)
+					}
+					fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\t%s\n", q.Name, q.Version, q.Collection, strings.Join(params, ","), strings.Join(q.Tags, ","), strings.Join(q.Roles, ","), q.Description)
+				}
+				return w.Flush()
+			})
+		},
+	}
+	flagset := cmd.Flags()
+	addLibraryFlags(flagset, &library)
+	flagset.StringSliceVar(&tags, "tag", tags, "Only list the queries with all of these tags")
+	return cmd
+}
+
+// hasTags reports whether tags holds every tag of required.
+func hasTags(tags, required []string) bool {
+	for _, r := range required {
+		found := false
+		for _, tag := range tags {
+			if tag == r {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func newQueryShowCommand(o *options) *cobra.Command {
+	var library libraryOptions
+	var version int
+	cmd := &cobra.Command{
+		Use:     "show NAME",
+		Short:   "Print a version of a saved query",
+		Example: `  mongodb-client query show nightly-report --version 2`,
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			store, manager, err := library.open(o)
+			if err != nil {
+				return err
+			}
+			if manager != nil {
+				defer disconnect(manager)
+			}
+			q, err := getSavedQuery(store, manager, arguments[0], version)
+			if err != nil {
+				return err
+			}
+			out, err := o.printer()
+			if err != nil {
+				return err
+			}
+			return out.printDocument(os.Stdout, q)
+		},
+	}
+	flagset := cmd.Flags()
+	addLibraryFlags(flagset, &library)
+	flagset.IntVar(&version, "version", version, "Version of the query, defaults to the latest")
+	return cmd
+}
+
+func newQueryHistoryCommand(o *options) *cobra.Command {
+	var library libraryOptions
+	cmd := &cobra.Command{
+		Use:     "history NAME",
+		Short:   "List the versions of a saved query",
+		Example: `  mongodb-client query history nightly-report`,
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			store, manager, err := library.open(o)
+			if err != nil {
+				return err
+			}
+			if manager != nil {
+				defer disconnect(manager)
+			}
+			ctx, cancel := libraryContext(manager, client.ReadOperation)
+			defer cancel()
+			versions, err := store.History(ctx, arguments[0])
+			if err == savedquery.ErrNotFound {
+				return fmt.Errorf("query %s is not saved", arguments[0])
+			}
+			if err != nil {
+				return err
+			}
+			out, err := o.printer()
+			if err != nil {
+				return err
+			}
+			return out.print(os.Stdout, versions, func(stdout io.Writer) error {
+				w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+				fmt.Fprintln(w, "VERSION\tCREATED\tBY\tDESCRIPTION")
+				for _, q := range versions {
+					fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", q.Version, q.CreatedAt.Format("2006-01-02T15:04:05Z"), q.CreatedBy, q.Description)
+				}
+				return w.Flush()
+			})
+		},
+	}
+	addLibraryFlags(cmd.Flags(), &library)
+	return cmd
+}
+
+func newQueryDeleteCommand(o *options) *cobra.Command {
+	var library libraryOptions
+	cmd := &cobra.Command{
+		Use:     "delete NAME",
+		Short:   "Delete every version of a saved query",
+		Example: `  mongodb-client query delete nightly-report`,
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			store, manager, err := library.open(o)
+			if err != nil {
+				return err
+			}
+			if manager != nil {
+				defer disconnect(manager)
+			}
+			ctx, cancel := libraryContext(manager, client.WriteOperation)
+			defer cancel()
+			removed, err := store.Delete(ctx, arguments[0])
+			if err != nil {
+				return err
+			}
+			if removed == 0 {
+				return fmt.Errorf("query %s is not saved", arguments[0])
+			}
+			fmt.Printf("Deleted %d versions of query %s\n", removed, arguments[0])
+			return nil
+		},
+	}
+	addLibraryFlags(cmd.Flags(), &library)
+	return cmd
+}
+
+type queryLintOptions struct {
+	Library   libraryOptions
+	Threshold int64
+	Strict    bool
+}
+
+func newQueryLintCommand(o *options) *cobra.Command {
+	l := &queryLintOptions{Threshold: defaultCollScanThreshold}
+	cmd := &cobra.Command{
+		Use:   "lint [NAME...]",
+		Short: "Check the latest version of saved queries, every one by default",
+		Long: `Check the filters and pipelines of the latest version of saved queries, with their parameters
+bound to their default or null: unknown operators and malformed stages are errors, and filters that
+no index supports on collections of at least --collscan-threshold documents are warnings. The
+command fails when there are errors, or warnings with --strict, so that it can check a library of
+queries in continuous integration. With --library-file and --collscan-threshold 0 it does not
+connect to the database.`,
+		Example: `  mongodb-client query lint --library-file queries.yaml --collscan-threshold 0
+  mongodb-client query lint nightly-report --strict`,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			return l.run(o, arguments)
+		},
+	}
+	flagset := cmd.Flags()
+	addLibraryFlags(flagset, &l.Library)
+	addCollScanThresholdFlag(flagset, &l.Threshold)
+	flagset.BoolVar(&l.Strict, "strict", l.Strict, "Fail on warnings too")
+	return cmd
+}
+
+func (l *queryLintOptions) run(o *options, names []string) error {
+	var manager *client.ConnectionManager
+	if len(l.Library.File) == 0 || l.Threshold > 0 {
+		var err error
+		if manager, err = o.connect(); err != nil {
+			return err
+		}
+		defer disconnect(manager)
+	}
+	store := l.Library.store(manager)
+	var queries []savedquery.Query
+	if len(names) == 0 {
+		ctx, cancel := libraryContext(manager, client.ReadOperation)
+		defer cancel()
+		var err error
+		if queries, err = store.List(ctx); err != nil {
+			return err
+		}
+	}
+	for _, name := range names {
+		q, err := getSavedQuery(store, manager, name, 0)
+		if err != nil {
+			return err
+		}
+		queries = append(queries, *q)
+	}
+
+	var errors, warnings int
+	for _, q := range queries {
+		problems, err := l.lint(manager, &q)
+		if err != nil {
+			return fmt.Errorf("query %s: %w", q.Name, err)
+		}
+		for _, problem := range problems {
+			fmt.Printf("%s (version %d): %s\n", q.Name, q.Version, problem)
+		}
+		n := lint.Errors(problems)
+		errors, warnings = errors+n, warnings+len(problems)-n
+	}
+	fmt.Printf("%d queries checked, %d errors, %d warnings\n", len(queries), errors, warnings)
+	if errors > 0 || l.Strict && warnings > 0 {
+		return fmt.Errorf("the saved queries have problems")
+	}
+	return nil
+}
+
+// lint returns the problems of a saved query, of which an invalid document is an error.
+func (l *queryLintOptions) lint(manager *client.ConnectionManager, q *savedquery.Query) ([]lint.Problem, error) {
+	if err := q.Validate(); err != nil {
+		return []lint.Problem{{Severity: lint.Error, Path: q.Name, Message: err.Error()}}, nil
+	}
+	bound, err := q.BindDefaults()
+	if err != nil {
+		return nil, err
+	}
+	var collection *mongo.Collection
+	if manager != nil {
+		database := q.Database
+		if len(database) == 0 {
+			database = manager.Database()
+		}
+		collection = manager.Primary().Database(database).Collection(q.Collection)
+	}
+	ctx, cancel := libraryContext(manager, client.ReadOperation)
+	defer cancel()
+	filter, pipeline := bound.Filter, bound.Pipeline
+	if len(q.Pipeline) == 0 && filter == nil {
+		filter = bson.D{}
+	}
+	return lintQuery(ctx, collection, filter, pipeline, l.Threshold)
+}
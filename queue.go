@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/client"
+	"github.com/bradmwilliams/mongodb-client/pkg/queue"
+	"github.com/spf13/cobra"
+)
+
+type queueOptions struct {
+	Database   string
+	Collection string
+	DeadLetter string
+}
+
+func newQueueCommand(o *options) *cobra.Command {
+	q := &queueOptions{Collection: "queue"}
+	cmd := &cobra.Command{
+		Use:   "queue",
+		Short: "Enqueue, inspect and redrive the messages of work queues",
+		Long: `Manage the work queues stored in a collection, which are consumed by services through the queue
+package. Messages are claimed for a visibility timeout, retried with a backoff when their processing
+fails, and moved to the dead-letter collection once out of attempts.`,
+	}
+	persistent := cmd.PersistentFlags()
+	persistent.StringVar(&q.Database, "db", q.Database, "Database of the queue collection, defaults to MONGODB_DATABASE")
+	persistent.StringVar(&q.Collection, "collection", q.Collection, "Collection holding the messages")
+	persistent.StringVar(&q.DeadLetter, "dead-letter", q.DeadLetter, "Collection holding the dead-lettered messages, defaults to the collection suffixed with _dead")
+	cmd.AddCommand(newQueuePushCommand(o, q))
+	cmd.AddCommand(newQueueStatsCommand(o, q))
+	cmd.AddCommand(newQueueRedriveCommand(o, q))
+	return cmd
+}
+
+// queue connects to the database and returns the queue called name.
+func (q *queueOptions) queue(o *options, name string) (*client.ConnectionManager, *queue.Queue, error) {
+	manager, err := o.connect()
+	if err != nil {
+		return nil, nil, err
+	}
+	database := q.Database
+	if len(database) == 0 {
+		database = manager.Database()
+	}
+	db := manager.Primary().Database(database)
+	workQueue := queue.New(db.Collection(q.Collection), name)
+	if len(q.DeadLetter) > 0 {
+		workQueue.DeadLetter = db.Collection(q.DeadLetter)
+	}
+	return manager, workQueue, nil
+}
+
+func newQueuePushCommand(o *options, q *queueOptions) *cobra.Command {
+	var delay time.Duration
+	cmd := &cobra.Command{
+		Use:   "push QUEUE PAYLOAD...",
+		Short: "Enqueue messages holding Extended JSON documents",
+		Example: `  mongodb-client queue push transcode '{"episode":42}'
+  mongodb-client queue push reminders --delay 1h '{"user":"ada"}' '{"user":"grace"}'`,
+		Args: cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			if delay < 0 {
+				return fmt.Errorf("--delay must not be negative")
+			}
+			var payloads []interface{}
+			for _, argument := range arguments[1:] {
+				payload, err := parseDocument(argument)
+				if err != nil {
+					return err
+				}
+				payloads = append(payloads, payload)
+			}
+
+			manager, workQueue, err := q.queue(o, arguments[0])
+			if err != nil {
+				return err
+			}
+			defer disconnect(manager)
+			ctx, cancel := manager.ContextFor(client.WriteOperation)
+			defer cancel()
+			if err := workQueue.EnsureIndexes(ctx); err != nil {
+				return fmt.Errorf("unable to create the index of the queue: %w", err)
+			}
+			for _, payload := range payloads {
+				id, err := workQueue.Enqueue(ctx, payload, delay)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintln(os.Stdout, id.Hex())
+			}
+			return nil
+		},
+	}
+	cmd.Flags().DurationVar(&delay, "delay", delay, "Delay before the messages can be claimed")
+	return cmd
+}
+
+func newQueueStatsCommand(o *options, q *queueOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:     "stats QUEUE",
+		Short:   "Print the number of ready, delayed, in-flight and dead messages of a queue as JSON",
+		Example: `  mongodb-client queue stats transcode`,
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			manager, workQueue, err := q.queue(o, arguments[0])
+			if err != nil {
+				return err
+			}
+			defer disconnect(manager)
+			ctx, cancel := manager.Context()
+			defer cancel()
+			stats, err := workQueue.Stats(ctx)
+			if err != nil {
+				return err
+			}
+			return json.NewEncoder(os.Stdout).Encode(stats)
+		},
+	}
+}
+
+func newQueueRedriveCommand(o *options, q *queueOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "redrive QUEUE",
+		Short: "Move the dead-lettered messages of a queue back to the queue",
+		Long: `Move the dead-lettered messages of the queue back to the queue with their attempts reset, once
+the cause of their failures is fixed.`,
+		Example: `  mongodb-client queue redrive transcode`,
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			manager, workQueue, err := q.queue(o, arguments[0])
+			if err != nil {
+				return err
+			}
+			defer disconnect(manager)
+			ctx, cancel := cmdContext()
+			defer cancel()
+			count, err := workQueue.Redrive(ctx)
+			fmt.Fprintf(os.Stderr, "Redrove %d messages of queue %s\n", count, workQueue.Name)
+			return err
+		},
+	}
+}
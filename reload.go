@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/config"
+	"github.com/bradmwilliams/mongodb-client/pkg/jobs"
+	"k8s.io/klog"
+)
+
+// applyLogLevel sets the verbosity of the logs to the one of cfg, when it has one.
+func applyLogLevel(cfg *config.Config) error {
+	if cfg.LogLevel == nil {
+		return nil
+	}
+	if *cfg.LogLevel < 0 {
+		return fmt.Errorf("logLevel must not be negative")
+	}
+	return flag.CommandLine.Set("v", strconv.Itoa(*cfg.LogLevel))
+}
+
+// configReloader applies the config file again to the background process when its content changes,
+// as when the ConfigMap it is mounted from is updated, or on SIGHUP: the log level and the jobs, with
+// their schedules and the retention, archival, downsampling, backup and materialized view policies.
+// The other sections only apply on restart.
+type configReloader struct {
+	o *options
+	// jobs registers the jobs of a config file in a registry.
+	jobs   func(registry *jobs.Registry, cfg *config.Config) error
+	runner *jobs.Runner
+
+	content []byte
+}
+
+// run reloads the config file every interval when it changed, and on SIGHUP, until stopCh is
+// closed. It is only reloaded on SIGHUP when interval is zero.
+func (r *configReloader) run(interval time.Duration, stopCh <-chan struct{}) {
+	r.content, _ = ioutil.ReadFile(r.o.ConfigFile)
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-hangup:
+			klog.Infof("Received SIGHUP, reloading %s", r.o.ConfigFile)
+			r.reload(true)
+		case <-tick:
+			r.reload(false)
+		}
+	}
+}
+
+// reload applies the config file when its content changed since it was last applied, or always
+// when force is set. A config file that is invalid is not applied, the current one is kept.
+func (r *configReloader) reload(force bool) {
+	content, err := ioutil.ReadFile(r.o.ConfigFile)
+	if err != nil {
+		klog.Errorf("Unable to reload the config file, keeping the current one: %v", err)
+		return
+	}
+	if !force && bytes.Equal(content, r.content) {
+		return
+	}
+	cfg, err := config.Load(r.o.ConfigFile)
+	if err != nil {
+		klog.Errorf("Unable to reload the config file, keeping the current one: %v", err)
+		return
+	}
+	registry := jobs.NewRegistry()
+	if err := r.jobs(registry, cfg); err != nil {
+		klog.Errorf("Unable to reload the jobs of the config file, keeping the current ones: %v", err)
+		return
+	}
+	if err := applyLogLevel(cfg); err != nil {
+		klog.Errorf("Unable to reload the log level of the config file: %v", err)
+	}
+	r.runner.Update(registry)
+	r.content = content
+	klog.Infof("Reloaded %s, %d jobs are scheduled", r.o.ConfigFile, len(registry.Jobs()))
+}
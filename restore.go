@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/archive"
+	"github.com/bradmwilliams/mongodb-client/pkg/backup"
+	"github.com/bradmwilliams/mongodb-client/pkg/objectstore"
+	"github.com/spf13/cobra"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type restoreOptions struct {
+	archive.RestoreOptions
+	Archive       string
+	Gzip          bool
+	OplogArchives []string
+	ToTimestamp   string
+	FromBackup    string
+	BackupName    string
+}
+
+func newRestoreCommand(o *options) *cobra.Command {
+	r := &restoreOptions{
+		RestoreOptions: archive.RestoreOptions{BatchSize: 1000},
+		Gzip:           true,
+	}
+	cmd := &cobra.Command{
+		Use:   "restore",
+		Short: "Restore databases from a mongodump compatible BSON archive",
+		Long: `Restore collections, their options and indexes from a BSON archive written by the dump
+command or by mongodump --archive.
+
+Documents whose _id already exists are skipped, use --drop to replace the collections instead.
+
+With --oplog-replay the oplog entries of the archive, and those of the --oplog-archive slices,
+are replayed after the collections have been restored. --to-timestamp stops the replay at a point
+in time, either an RFC 3339 time or an oplog timestamp as <seconds>[:<increment>]. --from-backup
+selects the archive and oplog slices of a scheduled backup needed to reach that point.`,
+		Example: `  mongodb-client restore --archive sampledb.archive.gz --drop
+  mongodb-client restore --db sampledb --collection episodes --to-db scratch < sampledb.archive.gz
+  mongodb-client restore --from-backup s3://backups/mongodb --backup-name nightly --to-timestamp 2021-08-01T10:30:00Z --drop`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			return r.run(o)
+		},
+	}
+
+	flagset := cmd.Flags()
+	flagset.StringVar(&r.Archive, "archive", r.Archive, "File or s3://, gs:// or azblob:// URL to read the archive from, defaults to standard input")
+	flagset.BoolVar(&r.Gzip, "gzip", r.Gzip, "The archive is compressed with gzip")
+	flagset.StringVar(&r.Database, "db", r.Database, "Restore only this database of the archive")
+	flagset.StringVar(&r.Collection, "collection", r.Collection, "Restore only this collection of each database")
+	flagset.StringVar(&r.ToDatabase, "to-db", r.ToDatabase, "Restore into this database instead of the one recorded in the archive")
+	flagset.BoolVar(&r.Drop, "drop", r.Drop, "Drop each collection before restoring it")
+	flagset.BoolVar(&r.NoIndexRestore, "no-index-restore", r.NoIndexRestore, "Do not create the indexes recorded in the archive")
+	flagset.IntVar(&r.BatchSize, "batch-size", r.BatchSize, "Number of documents inserted per request")
+	flagset.BoolVar(&r.ReplayOplog, "oplog-replay", r.ReplayOplog, "Replay the oplog entries of the archive after restoring the collections")
+	flagset.StringSliceVar(&r.OplogArchives, "oplog-archive", r.OplogArchives, "Archives of oplog entries replayed in order after the archive, implies --oplog-replay")
+	flagset.StringVar(&r.ToTimestamp, "to-timestamp", r.ToTimestamp, "Replay the oplog up to and including this RFC 3339 time or <seconds>[:<increment>] timestamp, implies --oplog-replay")
+	flagset.StringVar(&r.FromBackup, "from-backup", r.FromBackup, "Destination of a scheduled backup to restore from instead of --archive, see --backup-name")
+	flagset.StringVar(&r.BackupName, "backup-name", r.BackupName, "Name of the backup in the config file to restore with --from-backup")
+	return cmd
+}
+
+// parseTimestamp parses an RFC 3339 time, which includes every entry of that second, or an oplog
+// timestamp given as <seconds>[:<increment>].
+func parseTimestamp(value string) (primitive.Timestamp, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return primitive.Timestamp{T: uint32(t.Unix()), I: ^uint32(0)}, nil
+	}
+	parts := strings.SplitN(value, ":", 2)
+	seconds, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return primitive.Timestamp{}, fmt.Errorf("%q is neither an RFC 3339 time nor a <seconds>[:<increment>] timestamp", value)
+	}
+	ts := primitive.Timestamp{T: uint32(seconds), I: ^uint32(0)}
+	if len(parts) == 2 {
+		increment, err := strconv.ParseUint(parts[1], 10, 32)
+		if err != nil {
+			return primitive.Timestamp{}, fmt.Errorf("invalid timestamp increment in %q", value)
+		}
+		ts.I = uint32(increment)
+	}
+	return ts, nil
+}
+
+func (r *restoreOptions) run(o *options) error {
+	if r.BatchSize < 1 {
+		return fmt.Errorf("--batch-size must be at least 1")
+	}
+	if len(r.ToTimestamp) > 0 {
+		ts, err := parseTimestamp(r.ToTimestamp)
+		if err != nil {
+			return fmt.Errorf("--to-timestamp: %w", err)
+		}
+		r.RestoreOptions.ToTimestamp = ts
+		r.ReplayOplog = true
+	}
+	if len(r.OplogArchives) > 0 {
+		r.ReplayOplog = true
+	}
+	if len(r.FromBackup) > 0 {
+		if len(r.BackupName) == 0 {
+			return fmt.Errorf("--from-backup requires --backup-name")
+		}
+		if len(r.Archive) > 0 || len(r.OplogArchives) > 0 {
+			return fmt.Errorf("--from-backup cannot be combined with --archive or --oplog-archive")
+		}
+	}
+
+	if r.Drop {
+		if err := o.confirm("Drop the collections of the archive before restoring them"); err != nil {
+			return err
+		}
+	}
+	r.CheckDrop = o.checkProtected
+
+	manager, err := o.connect()
+	if err != nil {
+		return err
+	}
+	defer disconnect(manager)
+
+	ctx, cancel := cmdContext()
+	defer cancel()
+
+	location, slices := r.Archive, r.OplogArchives
+	var bucket objectstore.Bucket
+	if len(r.FromBackup) > 0 {
+		var prefix string
+		if bucket, prefix, err = objectstore.Open(r.FromBackup, objectstore.Options{}); err != nil {
+			return err
+		}
+		b := &backup.Backup{Name: r.BackupName, Bucket: bucket, Prefix: prefix, Gzip: r.Gzip}
+		base, oplogSlices, err := b.PointInTime(ctx, r.RestoreOptions.ToTimestamp)
+		if err != nil {
+			return err
+		}
+		location = base.Key
+		for _, slice := range oplogSlices {
+			slices = append(slices, slice.Key)
+		}
+		fmt.Fprintf(os.Stderr, "Restoring %s with %d oplog slices\n", location, len(slices))
+	}
+
+	var results []archive.RestoreResult
+	err = r.read(ctx, bucket, location, func(input io.Reader) error {
+		results, err = archive.Restore(ctx, manager.Primary(), input, r.RestoreOptions)
+		return err
+	})
+	for _, result := range results {
+		if result.Namespace == archive.OplogNamespace {
+			fmt.Fprintf(os.Stderr, "oplog: %d entries replayed\n", result.Documents)
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "%s: %d documents restored, %d duplicates skipped\n", result.Namespace, result.Documents, result.Duplicates)
+	}
+	if err != nil {
+		return err
+	}
+	if len(slices) > 0 {
+		return r.replay(ctx, manager.Primary(), bucket, slices, lastTimestamp(results))
+	}
+	return nil
+}
+
+// replay applies the oplog slices in order, skipping the entries already applied.
+func (r *restoreOptions) replay(ctx context.Context, client *mongo.Client, bucket objectstore.Bucket, slices []string, after primitive.Timestamp) error {
+	for _, slice := range slices {
+		var applied int64
+		err := r.read(ctx, bucket, slice, func(input io.Reader) error {
+			var err error
+			applied, after, err = archive.ReplayOplog(ctx, client, input, r.RestoreOptions, after)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %w", slice, err)
+		}
+		fmt.Fprintf(os.Stderr, "%s: %d oplog entries replayed\n", slice, applied)
+	}
+	return nil
+}
+
+func lastTimestamp(results []archive.RestoreResult) primitive.Timestamp {
+	for _, result := range results {
+		if result.Namespace == archive.OplogNamespace {
+			return result.LastTimestamp
+		}
+	}
+	return primitive.Timestamp{}
+}
+
+// read opens the archive at location, in bucket if set, and passes its decompressed content to fn.
+func (r *restoreOptions) read(ctx context.Context, bucket objectstore.Bucket, location string, fn func(io.Reader) error) error {
+	in := io.ReadCloser(os.Stdin)
+	key := location
+	if bucket == nil && len(location) > 0 && location != "-" {
+		var err error
+		if bucket, key, err = objectstore.Open(location, objectstore.Options{}); err != nil {
+			return err
+		}
+	}
+	if bucket != nil {
+		var err error
+		if in, err = bucket.NewReader(ctx, key); err != nil {
+			return err
+		}
+	}
+	defer in.Close()
+
+	input := io.Reader(bufio.NewReader(in))
+	if r.Gzip {
+		decompressed, err := gzip.NewReader(input)
+		if err != nil {
+			return fmt.Errorf("unable to read compressed archive (use --gzip=false for uncompressed archives): %w", err)
+		}
+		defer decompressed.Close()
+		input = decompressed
+	}
+	return fn(input)
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/client"
+	"github.com/bradmwilliams/mongodb-client/pkg/provision"
+	"github.com/spf13/cobra"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mongoOptions "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func newSchemaCommand(o *options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "schema",
+		Short: "Get, set and try out the validators of collections",
+		Long: `Manage the validator of a collection, usually a $jsonSchema document, and its validation level and
+action. A schema file holds a validator, or a JSON Schema that is wrapped in $jsonSchema.`,
+	}
+	cmd.AddCommand(newSchemaGetCommand(o))
+	cmd.AddCommand(newSchemaSetCommand(o))
+	cmd.AddCommand(newSchemaValidateDataCommand(o))
+	return cmd
+}
+
+type schemaTarget struct {
+	Database   string
+	Collection string
+}
+
+func (t *schemaTarget) addFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&t.Database, "db", t.Database, "Database of the collection, defaults to MONGODB_DATABASE")
+	cmd.Flags().StringVar(&t.Collection, "collection", t.Collection, "Collection whose validator is managed")
+	cmd.MarkFlagRequired("collection")
+}
+
+func (t *schemaTarget) database(manager *client.ConnectionManager) string {
+	if len(t.Database) > 0 {
+		return t.Database
+	}
+	return manager.Database()
+}
+
+// readSchema returns the validator held in path: a validator document, or a JSON Schema that is
+// wrapped in $jsonSchema.
+func readSchema(path string) (bson.D, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the schema: %w", err)
+	}
+	doc, err := parseDocument(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(doc) == 0 {
+		return nil, fmt.Errorf("%s holds an empty document", path)
+	}
+	validator := doc
+	for _, element := range doc {
+		switch element.Key {
+		case "$jsonSchema":
+			return doc, nil
+		case "bsonType", "type", "properties", "required", "additionalProperties":
+			validator = bson.D{{Key: "$jsonSchema", Value: doc}}
+		}
+	}
+	return validator, nil
+}
+
+func newSchemaGetCommand(o *options) *cobra.Command {
+	t := &schemaTarget{}
+	cmd := &cobra.Command{
+		Use:   "get",
+		Short: "Print the validator of a collection as Extended JSON",
+		Long: `Print the validator of a collection as indented Extended JSON, which schema set accepts back. The
+validation level and action are printed to standard error.`,
+		Example: `  mongodb-client schema get --collection episodes > episodes.schema.json`,
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			manager, err := o.connect()
+			if err != nil {
+				return err
+			}
+			defer disconnect(manager)
+			database := t.database(manager)
+			ctx, cancel := manager.Context()
+			defer cancel()
+			validation, err := provision.GetValidation(ctx, manager.Primary().Database(database), t.Collection)
+			if err != nil {
+				return err
+			}
+			if validation == nil {
+				return fmt.Errorf("collection %s.%s does not exist", database, t.Collection)
+			}
+			if len(validation.Validator) == 0 {
+				fmt.Fprintf(os.Stderr, "%s.%s has no validator\n", database, t.Collection)
+				return nil
+			}
+			fmt.Fprintf(os.Stderr, "validationLevel %s, validationAction %s\n", validation.Level, validation.Action)
+			data, err := bson.MarshalExtJSON(validation.Validator, false, false)
+			if err != nil {
+				return err
+			}
+			var indented bytes.Buffer
+			if err := json.Indent(&indented, data, "", "  "); err != nil {
+				return err
+			}
+			fmt.Fprintln(os.Stdout, indented.String())
+			return nil
+		},
+	}
+	t.addFlags(cmd)
+	return cmd
+}
+
+type schemaSetOptions struct {
+	schemaTarget
+	File   string
+	Level  string
+	Action string
+	DryRun bool
+}
+
+func newSchemaSetCommand(o *options) *cobra.Command {
+	s := &schemaSetOptions{}
+	cmd := &cobra.Command{
+		Use:   "set",
+		Short: "Set the validator, validation level or validation action of a collection",
+		Long: `Set the validator of a collection from --file and its validation level and action, creating the
+collection when it does not exist. The validator is kept when only --level or --action is set. Run
+schema validate-data first to find the existing documents that a new validator would reject.`,
+		Example: `  mongodb-client schema set --collection episodes --file episodes.schema.json --level moderate
+  mongodb-client schema set --collection episodes --action error --dry-run`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			return s.run(o)
+		},
+	}
+	s.addFlags(cmd)
+	flagset := cmd.Flags()
+	flagset.StringVar(&s.File, "file", s.File, "File holding the validator or JSON Schema")
+	flagset.StringVar(&s.Level, "level", s.Level, "Validation level: off, strict or moderate, which only validates valid documents")
+	flagset.StringVar(&s.Action, "action", s.Action, "Validation action: error rejects invalid writes, warn only logs them")
+	flagset.BoolVar(&s.DryRun, "dry-run", s.DryRun, "Print the changes without applying them")
+	return cmd
+}
+
+func (s *schemaSetOptions) run(o *options) error {
+	if len(s.File) == 0 && len(s.Level) == 0 && len(s.Action) == 0 {
+		return fmt.Errorf("at least one of --file, --level or --action must be specified")
+	}
+	switch s.Level {
+	case "", "off", "strict", "moderate":
+	default:
+		return fmt.Errorf("--level must be off, strict or moderate")
+	}
+	switch s.Action {
+	case "", "error", "warn":
+	default:
+		return fmt.Errorf("--action must be error or warn")
+	}
+	var validator bson.D
+	if len(s.File) > 0 {
+		var err error
+		if validator, err = readSchema(s.File); err != nil {
+			return err
+		}
+	}
+
+	manager, err := o.connect()
+	if err != nil {
+		return err
+	}
+	defer disconnect(manager)
+	db := manager.Primary().Database(s.database(manager))
+	ctx, cancel := manager.Context()
+	defer cancel()
+
+	collection := provision.Collection{Name: s.Collection, ValidationLevel: s.Level, ValidationAction: s.Action}
+	var raw interface{}
+	if validator != nil {
+		raw = validator
+	} else {
+		current, err := provision.GetValidation(ctx, db, s.Collection)
+		if err != nil {
+			return err
+		}
+		if current != nil && len(current.Validator) > 0 {
+			raw = current.Validator
+		}
+	}
+	if raw != nil {
+		data, err := bson.MarshalExtJSON(raw, true, false)
+		if err != nil {
+			return err
+		}
+		collection.Validator = json.RawMessage(data)
+	}
+	changes, err := provision.PlanCollection(ctx, db, collection, provision.Options{})
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		fmt.Fprintf(os.Stdout, "The validation of %s.%s is unchanged\n", db.Name(), s.Collection)
+		return nil
+	}
+	return applyChanges(ctx, changes, s.DryRun, os.Stdout)
+}
+
+type schemaValidateDataOptions struct {
+	schemaTarget
+	File  string
+	Limit int64
+}
+
+func newSchemaValidateDataCommand(o *options) *cobra.Command {
+	v := &schemaValidateDataOptions{Limit: 10}
+	cmd := &cobra.Command{
+		Use:   "validate-data",
+		Short: "Report the documents of a collection that fail a validator",
+		Long: `Count the documents of a collection that do not match the validator of --file, or the current
+validator of the collection, and print up to --limit of them as Extended JSON. Writes to these
+documents would be rejected once the validator is set with validationLevel strict.`,
+		Example: `  mongodb-client schema validate-data --collection episodes --file episodes.schema.json`,
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			return v.run(o)
+		},
+	}
+	v.addFlags(cmd)
+	cmd.Flags().StringVar(&v.File, "file", v.File, "File holding the proposed validator or JSON Schema, defaults to the current validator")
+	cmd.Flags().Int64Var(&v.Limit, "limit", v.Limit, "Maximum number of invalid documents to print, 0 only counts them")
+	return cmd
+}
+
+func (v *schemaValidateDataOptions) run(o *options) error {
+	if v.Limit < 0 {
+		return fmt.Errorf("--limit must not be negative")
+	}
+	var validator interface{}
+	if len(v.File) > 0 {
+		schema, err := readSchema(v.File)
+		if err != nil {
+			return err
+		}
+		validator = schema
+	}
+
+	manager, err := o.connect()
+	if err != nil {
+		return err
+	}
+	defer disconnect(manager)
+	db := manager.Primary().Database(v.database(manager))
+	if validator == nil {
+		ctx, cancel := manager.Context()
+		defer cancel()
+		current, err := provision.GetValidation(ctx, db, v.Collection)
+		if err != nil {
+			return err
+		}
+		if current == nil || len(current.Validator) == 0 {
+			return fmt.Errorf("%s.%s has no validator, --file is required", db.Name(), v.Collection)
+		}
+		validator = current.Validator
+	}
+
+	// The documents failing a validator are those not matching it as a query.
+	filter := bson.D{{Key: "$nor", Value: bson.A{validator}}}
+	collection := db.Collection(v.Collection)
+	ctx, cancel := cmdContext()
+	defer cancel()
+	invalid, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return fmt.Errorf("unable to count the invalid documents: %w", err)
+	}
+	total, err := collection.EstimatedDocumentCount(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to count the documents: %w", err)
+	}
+	if invalid > 0 && v.Limit > 0 {
+		if err := printInvalid(ctx, collection, filter, v.Limit); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(os.Stderr, "%d of about %d documents of %s.%s fail the validator\n", invalid, total, db.Name(), v.Collection)
+	return nil
+}
+
+// printInvalid prints up to limit documents of collection matching filter.
+func printInvalid(ctx context.Context, collection *mongo.Collection, filter bson.D, limit int64) error {
+	cursor, err := collection.Find(ctx, filter, mongoOptions.Find().SetLimit(limit))
+	if err != nil {
+		return fmt.Errorf("unable to find the invalid documents: %w", err)
+	}
+	return client.Each(ctx, cursor, func(doc bson.Raw) error {
+		return printDocument(os.Stdout, doc)
+	})
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/atlas"
+	"github.com/bradmwilliams/mongodb-client/pkg/client"
+	"github.com/bradmwilliams/mongodb-client/pkg/provision"
+	"github.com/bradmwilliams/mongodb-client/pkg/search"
+	"github.com/spf13/cobra"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mongoOptions "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type searchOptions struct {
+	Database   string
+	Collection string
+	Query      search.Query
+	Filter     string
+	Projection string
+	Page       int64
+}
+
+func newSearchCommand(o *options) *cobra.Command {
+	s := &searchOptions{Query: search.Query{Limit: 20}}
+	cmd := &cobra.Command{
+		Use:   "search TEXT",
+		Short: "Run a full-text search and print the results by decreasing relevance",
+		Long: `Search the text index of a collection with $text, or an Atlas Search index with $search when
+--index is set, and print a page of the results as Extended JSON, by decreasing relevance. Their
+score is added as the field score, and with --highlight the passages matching the terms as the field
+highlights.
+
+TEXT follows the syntax of $text: "quoted phrases" must all be present and -terms must not be.
+See the search-index command to create the indexes.`,
+		Example: `  mongodb-client search --collection episodes 'mongodb -sql'
+  mongodb-client search --collection episodes --page 2 --limit 10 '"change streams"'
+  mongodb-client search --collection episodes --index default --path title,description --highlight --fuzzy 1 replication`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			s.Query.Text = arguments[0]
+			return s.run(o)
+		},
+	}
+
+	flagset := cmd.Flags()
+	flagset.StringVar(&s.Database, "db", s.Database, "Database to search, defaults to MONGODB_DATABASE")
+	flagset.StringVar(&s.Collection, "collection", s.Collection, "Collection to search")
+	flagset.StringVar(&s.Query.Index, "index", s.Query.Index, "Atlas Search index to search with $search instead of the text index of the collection")
+	flagset.StringSliceVar(&s.Query.Paths, "path", s.Query.Paths, "Fields searched with --index, every indexed field by default")
+	flagset.StringVar(&s.Query.Language, "language", s.Query.Language, "Language of the stop words and stemming of $text, defaults to the language of the text index")
+	flagset.IntVar(&s.Query.Fuzzy, "fuzzy", s.Query.Fuzzy, "Number of single-character edits, up to 2, allowed per term with --index")
+	flagset.BoolVar(&s.Query.Highlight, "highlight", s.Query.Highlight, "Add the passages matching the terms to the results of --index")
+	flagset.StringVar(&s.Filter, "filter", s.Filter, "Extended JSON query filter selecting the results among the matching documents")
+	flagset.StringVar(&s.Projection, "project", s.Projection, "Extended JSON projection of the results, which must include score and highlights to keep them")
+	flagset.Int64Var(&s.Query.Skip, "skip", s.Query.Skip, "Number of results to skip")
+	flagset.Int64Var(&s.Query.Limit, "limit", s.Query.Limit, "Maximum number of results, the size of the pages of --page (0 means no limit)")
+	flagset.Int64Var(&s.Page, "page", s.Page, "Print the results of this page, starting at 1, instead of using --skip")
+	cmd.MarkFlagRequired("collection")
+	return cmd
+}
+
+func (s *searchOptions) run(o *options) error {
+	if s.Page < 0 {
+		return fmt.Errorf("--page must not be negative")
+	}
+	if s.Page > 0 {
+		if s.Query.Skip > 0 || s.Query.Limit == 0 {
+			return fmt.Errorf("--page requires a --limit and can not be combined with --skip")
+		}
+		s.Query.Skip = (s.Page - 1) * s.Query.Limit
+	}
+	var err error
+	if s.Query.Filter, err = parseDocument(s.Filter); err != nil {
+		return fmt.Errorf("--filter: %w", err)
+	}
+	if len(s.Projection) > 0 {
+		if s.Query.Projection, err = parseDocument(s.Projection); err != nil {
+			return fmt.Errorf("--project: %w", err)
+		}
+	}
+	if err := s.Query.Validate(); err != nil {
+		return err
+	}
+
+	manager, err := o.connect()
+	if err != nil {
+		return err
+	}
+	defer disconnect(manager)
+
+	database := s.Database
+	if len(database) == 0 {
+		database = manager.Database()
+	}
+	ctx, cancel := manager.Context()
+	defer cancel()
+	cursor, err := manager.Primary().Database(database).Collection(s.Collection).Aggregate(ctx, s.Query.Pipeline())
+	if err != nil {
+		return fmt.Errorf("unable to search %s.%s: %w", database, s.Collection, err)
+	}
+	return o.printCursor(ctx, cursor, nil)
+}
+
+type searchIndexOptions struct {
+	Database   string
+	Collection string
+	Atlas      bool
+}
+
+func newSearchIndexCommand(o *options) *cobra.Command {
+	i := &searchIndexOptions{}
+	cmd := &cobra.Command{
+		Use:   "search-index",
+		Short: "Create, list and drop the text and Atlas Search indexes searched by the search command",
+		Long: `Manage the text index of a collection, a collection has at most one, or with --atlas its Atlas
+Search indexes. Atlas Search indexes are managed through the Atlas Admin API, which requires the
+atlas section of the config file and an API key in ATLAS_PUBLIC_KEY and ATLAS_PRIVATE_KEY:
+
+  atlas:
+    groupID: 5f1a0c3e8b2d4a0012345678
+    clusterName: production`,
+	}
+	persistent := cmd.PersistentFlags()
+	persistent.StringVar(&i.Database, "db", i.Database, "Database of the collection, defaults to MONGODB_DATABASE")
+	persistent.StringVar(&i.Collection, "collection", i.Collection, "Collection of the indexes")
+	persistent.BoolVar(&i.Atlas, "atlas", i.Atlas, "Manage the Atlas Search indexes of the collection instead of its text index")
+	cmd.MarkPersistentFlagRequired("collection")
+	cmd.AddCommand(newSearchIndexCreateCommand(o, i))
+	cmd.AddCommand(newSearchIndexListCommand(o, i))
+	cmd.AddCommand(newSearchIndexDropCommand(o, i))
+	return cmd
+}
+
+// atlasClient returns the client of the Atlas Admin API for the cluster of the config file.
+func (i *searchIndexOptions) atlasClient(o *options) (*atlas.Client, error) {
+	cfg, err := o.loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Atlas == nil {
+		return nil, fmt.Errorf("the Atlas Search indexes require the atlas section of --config")
+	}
+	return atlas.NewClient(cfg.Atlas.URL, cfg.Atlas.GroupID, cfg.Atlas.ClusterName, o.OperationTimeout)
+}
+
+// database returns the database of the options, the one of the environment by default.
+func (i *searchIndexOptions) database() (string, error) {
+	if len(i.Database) > 0 {
+		return i.Database, nil
+	}
+	connection, err := client.ConfigFromEnvironment()
+	if err != nil {
+		return "", err
+	}
+	return connection.Database, nil
+}
+
+func newSearchIndexCreateCommand(o *options, i *searchIndexOptions) *cobra.Command {
+	var name, language, mappings, analyzer string
+	var fields []string
+	cmd := &cobra.Command{
+		Use:   "create",
+		Short: "Create the text index or an Atlas Search index of a collection",
+		Long: `Create the text index of the collection on --field, each field optionally with its weight, the
+factor of the score of the terms it holds, such as title:10.
+
+With --atlas, create an Atlas Search index with the mappings of --mappings, dynamic mappings indexing
+every field by default. The index is built in the background, list shows when it is ready.`,
+		Example: `  mongodb-client search-index create --collection episodes --field title:10,description --language english
+  mongodb-client search-index create --collection episodes --atlas --name default
+  mongodb-client search-index create --collection episodes --atlas --name titles --mappings '{"dynamic":false,"fields":{"title":{"type":"string"}}}'`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			if i.Atlas {
+				if len(fields) > 0 || len(language) > 0 {
+					return fmt.Errorf("--field and --language only apply to text indexes, use --mappings and --analyzer with --atlas")
+				}
+				return i.createAtlas(o, name, mappings, analyzer)
+			}
+			if len(mappings) > 0 || len(analyzer) > 0 {
+				return fmt.Errorf("--mappings and --analyzer require --atlas")
+			}
+			return i.createText(o, name, fields, language)
+		},
+	}
+	flagset := cmd.Flags()
+	flagset.StringVar(&name, "name", name, "Name of the index, defaults to the name the server gives to a text index and to default for --atlas")
+	flagset.StringSliceVar(&fields, "field", fields, "Fields of the text index with an optional weight, such as title:10, $** indexes every string field")
+	flagset.StringVar(&language, "language", language, "Default language of the text index, which selects its stop words and stemming")
+	flagset.StringVar(&mappings, "mappings", mappings, "JSON mappings of the Atlas Search index, or a file holding them")
+	flagset.StringVar(&analyzer, "analyzer", analyzer, "Analyzer of the Atlas Search index, such as lucene.english")
+	return cmd
+}
+
+func (i *searchIndexOptions) createText(o *options, name string, fields []string, language string) error {
+	if len(fields) == 0 {
+		return fmt.Errorf("--field is required")
+	}
+	keys, weights := bson.D{}, bson.D{}
+	for _, field := range fields {
+		parts := strings.SplitN(field, ":", 2)
+		keys = append(keys, bson.E{Key: parts[0], Value: "text"})
+		if len(parts) == 2 {
+			weight, err := strconv.Atoi(parts[1])
+			if err != nil || weight < 1 || weight > 99999 {
+				return fmt.Errorf("--field %s: the weight must be an integer from 1 to 99999", field)
+			}
+			weights = append(weights, bson.E{Key: parts[0], Value: weight})
+		}
+	}
+	indexOptions := mongoOptions.Index()
+	if len(name) > 0 {
+		indexOptions.SetName(name)
+	}
+	if len(weights) > 0 {
+		indexOptions.SetWeights(weights)
+	}
+	if len(language) > 0 {
+		indexOptions.SetDefaultLanguage(language)
+	}
+
+	manager, err := o.connect()
+	if err != nil {
+		return err
+	}
+	defer disconnect(manager)
+	database := i.Database
+	if len(database) == 0 {
+		database = manager.Database()
+	}
+	// Index builds take as long as the collection is large.
+	ctx, cancel := cmdContext()
+	defer cancel()
+	created, err := manager.Primary().Database(database).Collection(i.Collection).Indexes().CreateOne(ctx, mongo.IndexModel{Keys: keys, Options: indexOptions})
+	if err != nil {
+		return fmt.Errorf("unable to create the text index of %s.%s: %w", database, i.Collection, err)
+	}
+	fmt.Fprintf(os.Stderr, "Created text index %s of %s.%s\n", created, database, i.Collection)
+	return nil
+}
+
+func (i *searchIndexOptions) createAtlas(o *options, name, mappings, analyzer string) error {
+	api, err := i.atlasClient(o)
+	if err != nil {
+		return err
+	}
+	database, err := i.database()
+	if err != nil {
+		return err
+	}
+	index := atlas.SearchIndex{Name: name, Database: database, CollectionName: i.Collection, Analyzer: analyzer}
+	if len(index.Name) == 0 {
+		index.Name = "default"
+	}
+	if len(mappings) > 0 {
+		data := []byte(mappings)
+		if !strings.HasPrefix(strings.TrimSpace(mappings), "{") {
+			if data, err = ioutil.ReadFile(mappings); err != nil {
+				return fmt.Errorf("--mappings: %w", err)
+			}
+		}
+		if !json.Valid(data) {
+			return fmt.Errorf("--mappings is not a JSON document")
+		}
+		index.Mappings = data
+	}
+	ctx, cancel := cmdContext()
+	defer cancel()
+	created, err := api.CreateSearchIndex(ctx, index)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Creating Atlas Search index %s (%s) of %s.%s, status %s\n", created.Name, created.ID, database, i.Collection, created.Status)
+	return nil
+}
+
+func newSearchIndexListCommand(o *options, i *searchIndexOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List the text index or the Atlas Search indexes of a collection",
+		Example: `  mongodb-client search-index list --collection episodes
+  mongodb-client search-index list --collection episodes --atlas`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			out, err := o.printer()
+			if err != nil {
+				return err
+			}
+			if i.Atlas {
+				api, err := i.atlasClient(o)
+				if err != nil {
+					return err
+				}
+				database, err := i.database()
+				if err != nil {
+					return err
+				}
+				ctx, cancel := cmdContext()
+				defer cancel()
+				indexes, err := api.SearchIndexes(ctx, database, i.Collection)
+				if err != nil {
+					return err
+				}
+				return out.print(os.Stdout, indexes, func(stdout io.Writer) error {
+					w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+					fmt.Fprintln(w, "NAME\tID\tSTATUS\tANALYZER\tMAPPINGS")
+					for _, index := range indexes {
+						fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", index.Name, index.ID, index.Status, index.Analyzer, string(index.Mappings))
+					}
+					return w.Flush()
+				})
+			}
+
+			manager, err := o.connect()
+			if err != nil {
+				return err
+			}
+			defer disconnect(manager)
+			database := i.Database
+			if len(database) == 0 {
+				database = manager.Database()
+			}
+			ctx, cancel := manager.Context()
+			defer cancel()
+			indexes, err := provision.ListIndexes(ctx, manager.Primary().Database(database).Collection(i.Collection))
+			if err != nil {
+				return err
+			}
+			var texts []provision.IndexInfo
+			var listings []bson.D
+			for _, index := range indexes {
+				if len(index.Weights) == 0 {
+					continue
+				}
+				texts = append(texts, index)
+				listings = append(listings, bson.D{{Key: "name", Value: index.Name}, {Key: "weights", Value: index.Weights}, {Key: "default_language", Value: index.DefaultLanguage}})
+			}
+			return out.print(os.Stdout, listings, func(stdout io.Writer) error {
+				w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+				fmt.Fprintln(w, "NAME\tFIELDS\tLANGUAGE")
+				for _, index := range texts {
+					var fields []string
+					for _, weight := range index.Weights {
+						fields = append(fields, fmt.Sprintf("%s:%v", weight.Key, weight.Value))
+					}
+					fmt.Fprintf(w, "%s\t%s\t%s\n", index.Name, strings.Join(fields, ","), index.DefaultLanguage)
+				}
+				return w.Flush()
+			})
+		},
+	}
+}
+
+func newSearchIndexDropCommand(o *options, i *searchIndexOptions) *cobra.Command {
+	var name string
+	cmd := &cobra.Command{
+		Use:   "drop",
+		Short: "Drop the text index or an Atlas Search index of a collection",
+		Example: `  mongodb-client search-index drop --collection episodes --name title_text_description_text
+  mongodb-client search-index drop --collection episodes --atlas --name default`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			if i.Atlas {
+				api, err := i.atlasClient(o)
+				if err != nil {
+					return err
+				}
+				database, err := i.database()
+				if err != nil {
+					return err
+				}
+				ctx, cancel := cmdContext()
+				defer cancel()
+				indexes, err := api.SearchIndexes(ctx, database, i.Collection)
+				if err != nil {
+					return err
+				}
+				for _, index := range indexes {
+					if index.Name == name {
+						if err := api.DeleteSearchIndex(ctx, index.ID); err != nil {
+							return err
+						}
+						fmt.Fprintf(os.Stderr, "Dropped Atlas Search index %s of %s.%s\n", name, database, i.Collection)
+						return nil
+					}
+				}
+				return fmt.Errorf("%s.%s has no Atlas Search index %s", database, i.Collection, name)
+			}
+
+			manager, err := o.connect()
+			if err != nil {
+				return err
+			}
+			defer disconnect(manager)
+			database := i.Database
+			if len(database) == 0 {
+				database = manager.Database()
+			}
+			ctx, cancel := manager.Context()
+			defer cancel()
+			collection := manager.Primary().Database(database).Collection(i.Collection)
+			indexes, err := provision.ListIndexes(ctx, collection)
+			if err != nil {
+				return err
+			}
+			for _, index := range indexes {
+				if index.Name == name && len(index.Weights) > 0 {
+					if _, err := collection.Indexes().DropOne(ctx, name); err != nil {
+						return fmt.Errorf("unable to drop index %s: %w", name, err)
+					}
+					fmt.Fprintf(os.Stderr, "Dropped text index %s of %s.%s\n", name, database, i.Collection)
+					return nil
+				}
+			}
+			return fmt.Errorf("%s.%s has no text index %s", database, i.Collection, name)
+		},
+	}
+	cmd.Flags().StringVar(&name, "name", name, "Name of the index")
+	cmd.MarkFlagRequired("name")
+	return cmd
+}
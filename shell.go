@@ -0,0 +1,413 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/client"
+	"github.com/spf13/cobra"
+	"go.mongodb.org/mongo-driver/bson"
+	mongoOptions "go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// shellBatchSize is the number of documents printed by find before the output is truncated.
+const shellBatchSize = 20
+
+func newShellCommand(o *options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "shell",
+		Short: "Start an interactive prompt for quick queries using the configured credentials",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			manager, err := o.connect()
+			if err != nil {
+				return err
+			}
+			defer disconnect(manager)
+
+			s := &shell{
+				o:        o,
+				manager:  manager,
+				database: manager.Database(),
+			}
+			return s.run()
+		},
+	}
+}
+
+type shell struct {
+	o        *options
+	manager  *client.ConnectionManager
+	database string
+	out      io.Writer
+	// term is the terminal of an interactive shell, nil when the commands are read from a pipe.
+	term *terminal.Terminal
+}
+
+type shellCommand struct {
+	usage       string
+	description string
+	run         func(s *shell, ctx context.Context, args string) error
+}
+
+var shellCommands map[string]shellCommand
+
+// The commands are assigned in init because several of them refer back to the table for their usage.
+func init() {
+	shellCommands = map[string]shellCommand{
+		"help":    {"help", "Show this help", (*shell).help},
+		"use":     {"use <database>", "Switch the current database", (*shell).use},
+		"show":    {"show dbs|collections", "List databases or the collections of the current database", (*shell).show},
+		"find":    {"find <collection> [filter]", fmt.Sprintf("Print up to %d documents matching filter", shellBatchSize), (*shell).find},
+		"findone": {"findone <collection> [filter]", "Print the first document matching filter", (*shell).findOne},
+		"count":   {"count <collection> [filter]", "Count the documents matching filter", (*shell).count},
+		"insert":  {"insert <collection> <document>", "Insert a document", (*shell).insert},
+		"update":  {"update <collection> <filter> <update>", "Apply update to every document matching filter", (*shell).update},
+		"delete":  {"delete <collection> <filter>", "Delete every document matching filter, once confirmed", (*shell).delete},
+		"exit":    {"exit", "Leave the shell", nil},
+	}
+}
+
+func (s *shell) prompt() string {
+	return s.database + "> "
+}
+
+func (s *shell) run() error {
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		s.out = os.Stdout
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			if !s.execute(scanner.Text()) {
+				return nil
+			}
+		}
+		return scanner.Err()
+	}
+
+	state, err := terminal.MakeRaw(fd)
+	if err != nil {
+		return fmt.Errorf("unable to initialize terminal: %w", err)
+	}
+	defer terminal.Restore(fd, state)
+
+	term := terminal.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{os.Stdin, os.Stdout}, s.prompt())
+	term.AutoCompleteCallback = s.complete
+	s.out = term
+	s.term = term
+
+	fmt.Fprintf(term, "Connected to %s, type 'help' for a list of commands.\n", s.database)
+	for {
+		line, err := term.ReadLine()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !s.execute(line) {
+			return nil
+		}
+		term.SetPrompt(s.prompt())
+	}
+}
+
+// execute runs a single line of input, returning false when the shell should exit.
+func (s *shell) execute(line string) bool {
+	name, args := splitWord(line)
+	if len(name) == 0 {
+		return true
+	}
+	// Commands are case-insensitive, exit has no run function so it is matched first.
+	key := strings.ToLower(name)
+	if key == "exit" || key == "quit" {
+		return false
+	}
+	command, ok := shellCommands[key]
+	if !ok || command.run == nil {
+		fmt.Fprintf(s.out, "Unknown command %q, type 'help' for a list of commands.\n", name)
+		return true
+	}
+
+	ctx, cancel := s.manager.Context()
+	defer cancel()
+	if err := command.run(s, ctx, args); err != nil {
+		fmt.Fprintf(s.out, "Error: %v\n", err)
+	}
+	return true
+}
+
+// complete is invoked for every key press and expands command, database and collection names on tab.
+func (s *shell) complete(line string, pos int, key rune) (string, int, bool) {
+	if key != '\t' || pos != len(line) {
+		return "", 0, false
+	}
+
+	var candidates []string
+	prefix := line
+	if i := strings.LastIndex(line, " "); i < 0 {
+		for name := range shellCommands {
+			candidates = append(candidates, name)
+		}
+	} else {
+		prefix = line[i+1:]
+		command, _ := splitWord(line)
+		ctx, cancel := s.manager.Context()
+		defer cancel()
+		switch {
+		case command == "use":
+			candidates, _ = s.manager.Primary().ListDatabaseNames(ctx, bson.M{})
+		case command == "show":
+			candidates = []string{"dbs", "collections"}
+		case strings.Count(line, " ") == 1:
+			candidates, _ = s.manager.Primary().Database(s.database).ListCollectionNames(ctx, bson.M{})
+		}
+	}
+
+	var matches []string
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, prefix) {
+			matches = append(matches, candidate)
+		}
+	}
+	if len(matches) == 0 {
+		return "", 0, false
+	}
+	sort.Strings(matches)
+
+	completion := commonPrefix(matches)
+	if len(matches) == 1 {
+		completion += " "
+	} else if completion == prefix {
+		fmt.Fprintln(s.out, strings.Join(matches, "  "))
+		return "", 0, false
+	}
+	newLine := line[:len(line)-len(prefix)] + completion
+	return newLine, len(newLine), true
+}
+
+func (s *shell) help(ctx context.Context, args string) error {
+	var names []string
+	for name := range shellCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(s.out, "  %-40s %s\n", shellCommands[name].usage, shellCommands[name].description)
+	}
+	fmt.Fprintln(s.out, "Filters and documents are Extended JSON, e.g. {\"_id\": {\"$oid\": \"610414778b0a99f9bc7f248b\"}}")
+	return nil
+}
+
+func (s *shell) use(ctx context.Context, args string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: %s", shellCommands["use"].usage)
+	}
+	s.database = args
+	fmt.Fprintf(s.out, "switched to db %s\n", s.database)
+	return nil
+}
+
+func (s *shell) show(ctx context.Context, args string) error {
+	var names []string
+	var err error
+	switch args {
+	case "dbs", "databases":
+		names, err = s.manager.Primary().ListDatabaseNames(ctx, bson.M{})
+	case "collections", "tables":
+		names, err = s.manager.Primary().Database(s.database).ListCollectionNames(ctx, bson.M{})
+	default:
+		return fmt.Errorf("usage: %s", shellCommands["show"].usage)
+	}
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintln(s.out, name)
+	}
+	return nil
+}
+
+func (s *shell) find(ctx context.Context, args string) error {
+	collection, filter, err := s.collectionAndFilter(args, "find")
+	if err != nil {
+		return err
+	}
+	cursor, err := s.manager.Primary().Database(s.database).Collection(collection).Find(ctx, filter, mongoOptions.Find().SetLimit(shellBatchSize+1))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	printed := 0
+	for cursor.Next(ctx) {
+		if printed == shellBatchSize {
+			fmt.Fprintf(s.out, "(only the first %d documents are shown, refine the filter to see more)\n", shellBatchSize)
+			break
+		}
+		if err := printDocument(s.out, cursor.Current); err != nil {
+			return err
+		}
+		printed++
+	}
+	return cursor.Err()
+}
+
+func (s *shell) findOne(ctx context.Context, args string) error {
+	collection, filter, err := s.collectionAndFilter(args, "findone")
+	if err != nil {
+		return err
+	}
+	raw, err := s.manager.Primary().Database(s.database).Collection(collection).FindOne(ctx, filter).DecodeBytes()
+	if err != nil {
+		return err
+	}
+	return printDocument(s.out, raw)
+}
+
+func (s *shell) count(ctx context.Context, args string) error {
+	collection, filter, err := s.collectionAndFilter(args, "count")
+	if err != nil {
+		return err
+	}
+	count, err := s.manager.Primary().Database(s.database).Collection(collection).CountDocuments(ctx, filter)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(s.out, count)
+	return nil
+}
+
+func (s *shell) insert(ctx context.Context, args string) error {
+	collection, documents, err := s.collectionAndDocuments(args, "insert", 1)
+	if err != nil {
+		return err
+	}
+	result, err := s.manager.Primary().Database(s.database).Collection(collection).InsertOne(ctx, documents[0])
+	if err != nil {
+		return err
+	}
+	return printDocument(s.out, bson.M{"insertedId": result.InsertedID})
+}
+
+func (s *shell) update(ctx context.Context, args string) error {
+	collection, documents, err := s.collectionAndDocuments(args, "update", 2)
+	if err != nil {
+		return err
+	}
+	result, err := s.manager.Primary().Database(s.database).Collection(collection).UpdateMany(ctx, documents[0], documents[1])
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(s.out, "Matched %d, modified %d document(s)\n", result.MatchedCount, result.ModifiedCount)
+	return nil
+}
+
+func (s *shell) delete(ctx context.Context, args string) error {
+	collection, documents, err := s.collectionAndDocuments(args, "delete", 1)
+	if err != nil {
+		return err
+	}
+	if len(documents[0]) == 0 {
+		if err := s.o.checkProtected(s.database, collection); err != nil {
+			return err
+		}
+	}
+	target := s.manager.Primary().Database(s.database).Collection(collection)
+	count, err := target.CountDocuments(ctx, documents[0])
+	if err != nil {
+		return err
+	}
+	if err := s.confirm(fmt.Sprintf("Delete %d document(s) of %s.%s", count, s.database, collection)); err != nil {
+		return err
+	}
+	// The confirmation may have outlasted the timeout of the command.
+	ctx, cancel := s.manager.ContextFor(client.WriteOperation)
+	defer cancel()
+	result, err := target.DeleteMany(ctx, documents[0])
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(s.out, "Deleted %d document(s)\n", result.DeletedCount)
+	return nil
+}
+
+// confirm asks to confirm the destructive action on the terminal of the shell, unless --yes is set.
+func (s *shell) confirm(action string) error {
+	if s.o.Yes {
+		return nil
+	}
+	if s.term == nil {
+		return fmt.Errorf("%s requires a confirmation, use --yes to confirm it when there is no terminal", action)
+	}
+	s.term.SetPrompt(action + "? Type yes to continue: ")
+	defer s.term.SetPrompt(s.prompt())
+	answer, err := s.term.ReadLine()
+	if err != nil {
+		return fmt.Errorf("unable to read the confirmation: %w", err)
+	}
+	if !confirmed(answer) {
+		return fmt.Errorf("%s was not confirmed", action)
+	}
+	return nil
+}
+
+// collectionAndFilter parses "<collection> [filter]".
+func (s *shell) collectionAndFilter(args, command string) (string, bson.D, error) {
+	collection, rest := splitWord(args)
+	if len(collection) == 0 {
+		return "", nil, fmt.Errorf("usage: %s", shellCommands[command].usage)
+	}
+	filter, err := parseDocument(rest)
+	return collection, filter, err
+}
+
+// collectionAndDocuments parses "<collection>" followed by exactly count JSON documents.
+func (s *shell) collectionAndDocuments(args, command string, count int) (string, []bson.D, error) {
+	collection, rest := splitWord(args)
+	values, err := splitDocuments(rest)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(collection) == 0 || len(values) != count {
+		return "", nil, fmt.Errorf("usage: %s", shellCommands[command].usage)
+	}
+	documents := make([]bson.D, 0, count)
+	for _, value := range values {
+		doc, err := parseDocument(value)
+		if err != nil {
+			return "", nil, err
+		}
+		documents = append(documents, doc)
+	}
+	return collection, documents, nil
+}
+
+// splitWord returns the first whitespace separated word of line and the trimmed remainder.
+func splitWord(line string) (string, string) {
+	line = strings.TrimSpace(line)
+	if i := strings.IndexAny(line, " \t"); i >= 0 {
+		return line[:i], strings.TrimSpace(line[i:])
+	}
+	return line, ""
+}
+
+func commonPrefix(values []string) string {
+	prefix := values[0]
+	for _, value := range values[1:] {
+		for !strings.HasPrefix(value, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
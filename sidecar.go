@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/client"
+	"github.com/bradmwilliams/mongodb-client/pkg/readiness"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/spf13/cobra"
+	"k8s.io/klog"
+)
+
+type sidecarOptions struct {
+	Listen string
+	readiness.Options
+}
+
+func newSidecarCommand(o *options) *cobra.Command {
+	s := &sidecarOptions{
+		Listen: ":8081",
+		Options: readiness.Options{
+			Interval:         5 * time.Second,
+			Timeout:          2 * time.Second,
+			RequirePrimary:   true,
+			FailureThreshold: 1,
+		},
+	}
+	cmd := &cobra.Command{
+		Use:   "sidecar",
+		Short: "Serve the readiness of the database to gate the pods of an application",
+		Long: `Check every --interval that the database is reachable and that its replica set has a primary,
+or --min-secondaries reachable secondaries, and answer /readyz on --listen with 200 while it does,
+503 with the reason otherwise. Run next to the containers of an application with a readiness probe
+on /readyz, its pods only receive traffic while the database can serve them.
+
+Only /readyz and the metrics of the checks at /metrics are served. The sidecar keeps a single
+connection to the database, with the application credentials, and the members of the replica set
+are known from the monitoring of the driver, so no privilege is needed.`,
+		Example: `  mongodb-client sidecar --min-secondaries 1
+
+  readinessProbe:
+    httpGet: {path: /readyz, port: 8081}
+    periodSeconds: 5`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			return s.run(o)
+		},
+	}
+	flagset := cmd.Flags()
+	flagset.StringVar(&s.Listen, "listen", s.Listen, "The address to serve /readyz and /metrics on")
+	flagset.DurationVar(&s.Interval, "interval", s.Interval, "Interval between two checks of the database")
+	flagset.DurationVar(&s.Timeout, "timeout", s.Timeout, "Time after which a check of the database fails")
+	flagset.BoolVar(&s.RequirePrimary, "require-primary", s.RequirePrimary, "Require a primary accepting writes, --require-primary=false accepts any reachable member")
+	flagset.IntVar(&s.MinSecondaries, "min-secondaries", s.MinSecondaries, "Number of secondaries of the replica set that must be reachable")
+	flagset.IntVar(&s.FailureThreshold, "failure-threshold", s.FailureThreshold, "Number of consecutive failed checks after which the database is no longer ready")
+	return cmd
+}
+
+func (s *sidecarOptions) run(o *options) error {
+	if s.Interval < time.Second {
+		return validationError(fmt.Errorf("--interval must be at least 1s"))
+	}
+	if s.Timeout <= 0 {
+		return validationError(fmt.Errorf("--timeout must be greater than zero"))
+	}
+	if s.MinSecondaries < 0 {
+		return validationError(fmt.Errorf("--min-secondaries must not be negative"))
+	}
+	if s.FailureThreshold < 1 {
+		return validationError(fmt.Errorf("--failure-threshold must be at least 1"))
+	}
+	if err := o.validate(); err != nil {
+		return validationError(err)
+	}
+	// The checks only need one connection at a time.
+	if o.MaxPoolSize == 0 {
+		o.MaxPoolSize = 1
+	}
+	checker := readiness.NewChecker(s.Options)
+	connection, err := o.connectionConfig()
+	if err != nil {
+		return validationError(err)
+	}
+	connection.ClientOptions.SetServerMonitor(checker.ServerMonitor()).SetHeartbeatInterval(s.Interval)
+	manager := client.NewConnectionManager(connection)
+	ctx, cancel := cmdContext()
+	defer cancel()
+	if err := manager.ConnectApplication(ctx); err != nil {
+		return validationError(err)
+	}
+	defer disconnect(manager)
+
+	// A registry of its own leaves out the metrics of the other commands registered on the default
+	// one.
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(checker.Collectors()...)
+	registry.MustRegister(prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
+	mux := http.NewServeMux()
+	mux.Handle("/readyz", checker)
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	go func() {
+		klog.Infof("Listening on %s for readiness and metrics", s.Listen)
+		if err := http.ListenAndServe(s.Listen, mux); err != nil {
+			klog.Exitf("Server exited: %v", err)
+		}
+	}()
+
+	checker.Run(ctx, manager.Primary())
+	return nil
+}
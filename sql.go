@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/client"
+	"github.com/bradmwilliams/mongodb-client/pkg/sqlquery"
+	"github.com/spf13/cobra"
+	mongoOptions "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type sqlOptions struct {
+	Database           string
+	ExplainTranslation bool
+	Explain            string
+	BatchSize          int32
+	JQ                 string
+}
+
+func newSQLCommand(o *options) *cobra.Command {
+	s := &sqlOptions{}
+	cmd := &cobra.Command{
+		Use:   "sql STATEMENT",
+		Short: "Run a SQL SELECT statement translated into a find or an aggregation",
+		Long: `Translate a SQL SELECT statement into a find or, when it joins, groups or renames columns, an
+aggregation pipeline, run it and print the documents it returns.
+
+The supported subset is SELECT * or a list of columns and COUNT, SUM, AVG, MIN and MAX aggregates
+with optional aliases, FROM a collection, [INNER | LEFT] JOIN another collection ON the equality of
+two columns, WHERE with =, <>, <, <=, >, >=, [NOT] IN, [NOT] LIKE, IS [NOT] NULL, [NOT] BETWEEN, AND,
+OR and NOT, GROUP BY, HAVING, ORDER BY, LIMIT and OFFSET. Columns are field paths such as
+address.city, qualified by the alias of their collection in joins. Values are strings in single
+quotes, numbers, TRUE, FALSE, NULL, DATE '2024-01-01', TIMESTAMP '2024-01-01T12:00:00Z' and
+OBJECTID('...').
+
+--explain-translation prints the find or aggregate command instead of running it.`,
+		Example: `  mongodb-client sql "SELECT title, duration FROM episodes WHERE duration > 25 ORDER BY duration DESC LIMIT 10"
+  mongodb-client sql "SELECT podcast, COUNT(*) AS episodes, AVG(duration) FROM episodes GROUP BY podcast HAVING episodes > 2"
+  mongodb-client sql --explain-translation \
+    "SELECT e.title, p.name AS podcast FROM episodes e JOIN podcasts p ON e.podcast = p._id WHERE e.title LIKE 'Go%'"`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			return s.run(o, arguments[0])
+		},
+	}
+
+	flagset := cmd.Flags()
+	flagset.StringVar(&s.Database, "db", s.Database, "Database of the collections, defaults to MONGODB_DATABASE")
+	flagset.BoolVar(&s.ExplainTranslation, "explain-translation", s.ExplainTranslation, "Print the find or aggregate command the statement translates to instead of running it")
+	flagset.StringVar(&s.Explain, "explain", s.Explain, "Print the plan of the translated command instead of its results: queryPlanner, executionStats or allPlansExecution")
+	flagset.Int32Var(&s.BatchSize, "batch-size", s.BatchSize, "Number of documents per cursor batch (0 uses the server default)")
+	addJQFlag(flagset, &s.JQ)
+	return cmd
+}
+
+func (s *sqlOptions) run(o *options, statement string) error {
+	if s.BatchSize < 0 {
+		return fmt.Errorf("--batch-size must not be negative")
+	}
+	if err := validateExplain(s.Explain); err != nil {
+		return err
+	}
+	translation, err := sqlquery.Translate(statement)
+	if err != nil {
+		return fmt.Errorf("invalid statement: %w", err)
+	}
+	if s.ExplainTranslation {
+		return printDocument(os.Stdout, translation.Command())
+	}
+	expr, err := parseJQ(s.JQ)
+	if err != nil {
+		return err
+	}
+
+	manager, err := o.connect()
+	if err != nil {
+		return err
+	}
+	defer disconnect(manager)
+
+	database := s.Database
+	if len(database) == 0 {
+		database = manager.Database()
+	}
+	db := manager.Primary().Database(database)
+	collection := db.Collection(translation.Collection)
+
+	if translation.Pipeline != nil {
+		ctx, cancel := manager.ContextFor(client.AggregateOperation)
+		defer cancel()
+		if len(s.Explain) > 0 {
+			return explain(ctx, os.Stdout, db, translation.Command(), s.Explain)
+		}
+		aggregateOptions := mongoOptions.Aggregate()
+		aggregateOptions.MaxTime = client.MaxTime(ctx)
+		if s.BatchSize > 0 {
+			aggregateOptions.SetBatchSize(s.BatchSize)
+		}
+		cursor, err := collection.Aggregate(ctx, translation.Pipeline, aggregateOptions)
+		if err != nil {
+			return err
+		}
+		return o.printCursor(ctx, cursor, expr)
+	}
+
+	ctx, cancel := manager.ContextFor(client.ReadOperation)
+	defer cancel()
+	if len(s.Explain) > 0 {
+		return explain(ctx, os.Stdout, db, translation.Command(), s.Explain)
+	}
+	findOptions := mongoOptions.Find().SetSkip(translation.Skip).SetLimit(translation.Limit)
+	findOptions.MaxTime = client.MaxTime(ctx)
+	if s.BatchSize > 0 {
+		findOptions.SetBatchSize(s.BatchSize)
+	}
+	if len(translation.Sort) > 0 {
+		findOptions.SetSort(translation.Sort)
+	}
+	if len(translation.Projection) > 0 {
+		findOptions.SetProjection(translation.Projection)
+	}
+	cursor, err := collection.Find(ctx, translation.Filter, findOptions)
+	if err != nil {
+		return err
+	}
+	return o.printCursor(ctx, cursor, expr)
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/cobra"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+)
+
+var (
+	collectionDocuments = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "mongodb_client_collection_documents",
+		Help: "Number of documents of each collection.",
+	}, []string{"database", "collection"})
+	collectionSizeBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "mongodb_client_collection_size_bytes",
+		Help: "Uncompressed size in bytes of the documents of each collection.",
+	}, []string{"database", "collection"})
+	collectionStorageSizeBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "mongodb_client_collection_storage_size_bytes",
+		Help: "Storage allocated in bytes to the documents of each collection.",
+	}, []string{"database", "collection"})
+	collectionIndexSizeBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "mongodb_client_collection_index_size_bytes",
+		Help: "Size in bytes of each index of each collection.",
+	}, []string{"database", "collection", "index"})
+	collectionCacheBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "mongodb_client_collection_cache_bytes",
+		Help: "Bytes of each collection currently in the WiredTiger cache.",
+	}, []string{"database", "collection"})
+)
+
+func init() {
+	prometheus.MustRegister(collectionDocuments, collectionSizeBytes, collectionStorageSizeBytes, collectionIndexSizeBytes, collectionCacheBytes)
+}
+
+type statsOptions struct {
+	Database      string
+	Collections   []string
+	MetricsListen string
+	Interval      time.Duration
+}
+
+// collectionStats are the statistics of a collection from collStats, sizes are in bytes.
+type collectionStats struct {
+	Collection     string           `bson:"-" json:"collection"`
+	Count          int64            `bson:"count" json:"count"`
+	Size           int64            `bson:"size" json:"size"`
+	StorageSize    int64            `bson:"storageSize" json:"storageSize"`
+	AvgObjSize     float64          `bson:"avgObjSize" json:"avgObjSize"`
+	Indexes        int32            `bson:"nindexes" json:"indexes"`
+	TotalIndexSize int64            `bson:"totalIndexSize" json:"totalIndexSize"`
+	IndexSizes     map[string]int64 `bson:"indexSizes" json:"indexSizes"`
+	WiredTiger     struct {
+		Cache struct {
+			Bytes        int64 `bson:"bytes currently in the cache" json:"bytes"`
+			BytesRead    int64 `bson:"bytes read into cache" json:"bytesRead"`
+			BytesWritten int64 `bson:"bytes written from cache" json:"bytesWritten"`
+		} `bson:"cache" json:"cache"`
+	} `bson:"wiredTiger" json:"wiredTiger"`
+}
+
+// databaseStats are the statistics of a database from dbStats with those of its collections.
+type databaseStats struct {
+	Database      string            `bson:"db" json:"database"`
+	Collections   int32             `bson:"collections" json:"collections"`
+	Objects       int64             `bson:"objects" json:"objects"`
+	DataSize      int64             `bson:"dataSize" json:"dataSize"`
+	StorageSize   int64             `bson:"storageSize" json:"storageSize"`
+	IndexSize     int64             `bson:"indexSize" json:"indexSize"`
+	AvgObjSize    float64           `bson:"avgObjSize" json:"avgObjSize"`
+	PerCollection []collectionStats `bson:"-" json:"perCollection"`
+}
+
+func newStatsCommand(o *options) *cobra.Command {
+	s := &statsOptions{Interval: time.Minute}
+	cmd := &cobra.Command{
+		Use:   "stats",
+		Short: "Report the statistics of a database and its collections",
+		Long: `Print the document counts, sizes, index sizes and WiredTiger cache usage of a database and its
+collections from dbStats and collStats.
+
+With --metrics-listen the statistics are instead read every --interval and served as Prometheus
+metrics until interrupted.`,
+		Example: `  mongodb-client stats
+  mongodb-client stats --collection episodes -o json
+  mongodb-client stats --metrics-listen :8081 --interval 30s`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			return s.run(o)
+		},
+	}
+	flagset := cmd.Flags()
+	flagset.StringVar(&s.Database, "db", s.Database, "Database to report on, defaults to MONGODB_DATABASE")
+	flagset.StringSliceVar(&s.Collections, "collection", s.Collections, "Only report on these collections, defaults to every collection")
+	addJSONFlag(flagset, o)
+	flagset.StringVar(&s.MetricsListen, "metrics-listen", s.MetricsListen, "Address to serve the statistics on as metrics, such as :8081")
+	flagset.DurationVar(&s.Interval, "interval", s.Interval, "How often the statistics are read with --metrics-listen")
+	return cmd
+}
+
+func (s *statsOptions) run(o *options) error {
+	if len(s.MetricsListen) > 0 && len(o.Output) > 0 {
+		return fmt.Errorf("--output cannot be combined with --metrics-listen")
+	}
+	out, err := o.printer()
+	if err != nil {
+		return err
+	}
+	if s.Interval < time.Second {
+		return fmt.Errorf("--interval must be at least 1s")
+	}
+	manager, err := o.connect()
+	if err != nil {
+		return err
+	}
+	defer disconnect(manager)
+	database := s.Database
+	if len(database) == 0 {
+		database = manager.Database()
+	}
+	db := manager.Primary().Database(database)
+
+	if len(s.MetricsListen) == 0 {
+		ctx, cancel := manager.Context()
+		defer cancel()
+		stats, err := readStats(ctx, db, s.Collections)
+		if err != nil {
+			return err
+		}
+		return out.print(os.Stdout, []*databaseStats{stats}, func(w io.Writer) error {
+			return printStats(w, stats)
+		})
+	}
+
+	serveMetrics(s.MetricsListen)
+	ctx, cancel := cmdContext()
+	defer cancel()
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		readCtx, cancel := manager.Context()
+		defer cancel()
+		stats, err := readStats(readCtx, db, s.Collections)
+		if err != nil {
+			klog.Errorf("Unable to read the statistics of %s: %v", database, err)
+			return
+		}
+		// Collections and indexes dropped since the last read must not be reported anymore.
+		for _, gauge := range []*prometheus.GaugeVec{collectionDocuments, collectionSizeBytes, collectionStorageSizeBytes, collectionIndexSizeBytes, collectionCacheBytes} {
+			gauge.Reset()
+		}
+		for _, collection := range stats.PerCollection {
+			labels := prometheus.Labels{"database": database, "collection": collection.Collection}
+			collectionDocuments.With(labels).Set(float64(collection.Count))
+			collectionSizeBytes.With(labels).Set(float64(collection.Size))
+			collectionStorageSizeBytes.With(labels).Set(float64(collection.StorageSize))
+			collectionCacheBytes.With(labels).Set(float64(collection.WiredTiger.Cache.Bytes))
+			for index, size := range collection.IndexSizes {
+				collectionIndexSizeBytes.WithLabelValues(database, collection.Collection, index).Set(float64(size))
+			}
+		}
+	}, s.Interval)
+	return nil
+}
+
+// readStats returns the statistics of db and of collections, or of all its collections when
+// collections is empty.
+func readStats(ctx context.Context, db *mongo.Database, collections []string) (*databaseStats, error) {
+	stats := &databaseStats{}
+	if err := db.RunCommand(ctx, bson.D{{Key: "dbStats", Value: 1}}).Decode(stats); err != nil {
+		return nil, fmt.Errorf("unable to read the statistics of %s: %w", db.Name(), err)
+	}
+	if len(collections) == 0 {
+		var err error
+		if collections, err = db.ListCollectionNames(ctx, bson.D{{Key: "type", Value: "collection"}}); err != nil {
+			return nil, fmt.Errorf("unable to list the collections of %s: %w", db.Name(), err)
+		}
+		sort.Strings(collections)
+	}
+	for _, name := range collections {
+		collection := collectionStats{Collection: name}
+		if err := db.RunCommand(ctx, bson.D{{Key: "collStats", Value: name}}).Decode(&collection); err != nil {
+			return nil, fmt.Errorf("unable to read the statistics of %s.%s: %w", db.Name(), name, err)
+		}
+		stats.PerCollection = append(stats.PerCollection, collection)
+	}
+	return stats, nil
+}
+
+func printStats(out io.Writer, stats *databaseStats) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "DATABASE\tCOLLECTIONS\tOBJECTS\tDATA SIZE\tSTORAGE SIZE\tINDEX SIZE\tAVG OBJ SIZE")
+	fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\t%.0f\n", stats.Database, stats.Collections, stats.Objects,
+		stats.DataSize, stats.StorageSize, stats.IndexSize, stats.AvgObjSize)
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintln(out)
+	w = tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "COLLECTION\tCOUNT\tSIZE\tSTORAGE SIZE\tAVG OBJ SIZE\tINDEXES\tINDEX SIZE\tCACHED\tCACHE READ\tCACHE WRITTEN")
+	for _, collection := range stats.PerCollection {
+		cache := collection.WiredTiger.Cache
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%.0f\t%d\t%d\t%d\t%d\t%d\n", collection.Collection, collection.Count,
+			collection.Size, collection.StorageSize, collection.AvgObjSize, collection.Indexes,
+			collection.TotalIndexSize, cache.Bytes, cache.BytesRead, cache.BytesWritten)
+	}
+	return w.Flush()
+}
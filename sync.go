@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/changestream"
+	"github.com/bradmwilliams/mongodb-client/pkg/elasticsearch"
+	"github.com/spf13/cobra"
+)
+
+type syncOptions struct {
+	Database              string
+	Collection            string
+	URL                   string
+	Index                 string
+	IndexBodyFile         string
+	Mapping               elasticsearch.Mapping
+	BatchSize             int
+	Timeout               time.Duration
+	Name                  string
+	ResumeTokenCollection string
+	ResumeTokenFile       string
+	Resync                bool
+	MetricsListen         string
+}
+
+func newSyncCommand(o *options) *cobra.Command {
+	s := &syncOptions{BatchSize: 500, Timeout: time.Minute}
+	cmd := &cobra.Command{
+		Use:   "sync",
+		Short: "Keep an Elasticsearch or OpenSearch index in sync with a collection",
+		Long: `Index every document of a collection, then apply its changes to the index as they happen
+using a change stream, until interrupted.
+
+With --resume-token-collection or --resume-token-file the position in the change stream is saved,
+a restarted sync applies the changes made in the meantime instead of indexing the collection again
+(unless --resync is set). The index is created with the settings and mappings of --index-body when it
+does not exist. Documents are indexed by _id with the fields selected by --include, --exclude and
+--rename, ObjectIds and dates are indexed as strings.
+
+Credentials are read from ELASTICSEARCH_API_KEY, or ELASTICSEARCH_USERNAME and ELASTICSEARCH_PASSWORD.`,
+		Example: `  mongodb-client sync --collection episodes --elasticsearch-url http://localhost:9200
+  mongodb-client sync --collection episodes --elasticsearch-url https://search:9200 --index podcast-episodes \
+    --include title,description,duration --rename description=summary --index-body mappings.json \
+    --resume-token-collection resume_tokens --metrics-listen :8081`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			return s.run(o)
+		},
+	}
+
+	flagset := cmd.Flags()
+	flagset.StringVar(&s.Database, "db", s.Database, "Database of the collection, defaults to MONGODB_DATABASE")
+	flagset.StringVar(&s.Collection, "collection", s.Collection, "Collection to index")
+	flagset.StringVar(&s.URL, "elasticsearch-url", s.URL, "URL of the Elasticsearch or OpenSearch cluster")
+	flagset.StringVar(&s.Index, "index", s.Index, "Index to keep in sync, defaults to the collection name")
+	flagset.StringVar(&s.IndexBodyFile, "index-body", s.IndexBodyFile, "JSON file holding the settings and mappings used to create the index")
+	flagset.StringSliceVar(&s.Mapping.Include, "include", s.Mapping.Include, "Index only these top level fields")
+	flagset.StringSliceVar(&s.Mapping.Exclude, "exclude", s.Mapping.Exclude, "Do not index these top level fields")
+	flagset.StringToStringVar(&s.Mapping.Rename, "rename", s.Mapping.Rename, "Index fields under another name, such as description=summary")
+	flagset.IntVar(&s.BatchSize, "batch-size", s.BatchSize, "Maximum number of documents per bulk request")
+	flagset.DurationVar(&s.Timeout, "timeout", s.Timeout, "Timeout of every request to the cluster")
+	flagset.StringVar(&s.Name, "sync-name", s.Name, "Name the resume token is saved under, defaults to elasticsearch-<db>.<collection>")
+	flagset.StringVar(&s.ResumeTokenCollection, "resume-token-collection", s.ResumeTokenCollection, "Collection of the application database in which resume tokens are saved")
+	flagset.StringVar(&s.ResumeTokenFile, "resume-token-file", s.ResumeTokenFile, "File in which resume tokens are saved")
+	flagset.BoolVar(&s.Resync, "resync", s.Resync, "Index the whole collection again even though a resume token was saved")
+	flagset.StringVar(&s.MetricsListen, "metrics-listen", s.MetricsListen, "Address to serve the sync metrics on, such as :8081")
+	cmd.MarkFlagRequired("collection")
+	cmd.MarkFlagRequired("elasticsearch-url")
+	return cmd
+}
+
+func (s *syncOptions) run(o *options) error {
+	if s.BatchSize < 1 {
+		return fmt.Errorf("--batch-size must be at least 1")
+	}
+	if len(s.ResumeTokenCollection) > 0 && len(s.ResumeTokenFile) > 0 {
+		return fmt.Errorf("only one of --resume-token-collection or --resume-token-file may be specified")
+	}
+	es, err := elasticsearch.NewClient(s.URL, s.Timeout)
+	if err != nil {
+		return err
+	}
+	var body []byte
+	if len(s.IndexBodyFile) > 0 {
+		if body, err = ioutil.ReadFile(s.IndexBodyFile); err != nil {
+			return fmt.Errorf("unable to read --index-body: %w", err)
+		}
+	}
+
+	manager, err := o.connect()
+	if err != nil {
+		return err
+	}
+	defer disconnect(manager)
+
+	database := s.Database
+	if len(database) == 0 {
+		database = manager.Database()
+	}
+	index := s.Index
+	if len(index) == 0 {
+		index = s.Collection
+	}
+	name := s.Name
+	if len(name) == 0 {
+		name = fmt.Sprintf("elasticsearch-%s.%s", database, s.Collection)
+	}
+	var store changestream.TokenStore
+	switch {
+	case len(s.ResumeTokenCollection) > 0:
+		store = changestream.NewCollectionStore(manager.Primary().Database(manager.Database()).Collection(s.ResumeTokenCollection))
+	case len(s.ResumeTokenFile) > 0:
+		store = changestream.NewFileStore(s.ResumeTokenFile)
+	}
+	if len(s.MetricsListen) > 0 {
+		serveMetrics(s.MetricsListen)
+	}
+
+	ctx, cancel := cmdContext()
+	defer cancel()
+	sync := &elasticsearch.Sync{
+		Name:       name,
+		Client:     es,
+		Collection: manager.Primary().Database(database).Collection(s.Collection),
+		Index:      index,
+		IndexBody:  body,
+		Mapping:    s.Mapping,
+		BatchSize:  s.BatchSize,
+		Store:      store,
+		Resync:     s.Resync,
+		Retry:      o.Retry,
+	}
+	if err := sync.Run(ctx); err != nil && ctx.Err() == nil {
+		return err
+	}
+	return nil
+}
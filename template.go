@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/querytemplate"
+	"github.com/spf13/pflag"
+)
+
+func addParamFlag(flagset *pflag.FlagSet, params *[]string) {
+	flagset.StringArrayVar(params, "param", *params, `Value of a parameter of the documents written as templates, as NAME=VALUE, may be repeated (see "query --help")`)
+}
+
+// parseParams parses the parameters written NAME=VALUE of --param.
+func parseParams(params []string) (map[string]string, error) {
+	values := make(map[string]string, len(params))
+	for _, param := range params {
+		parts := strings.SplitN(param, "=", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 {
+			return nil, fmt.Errorf("--param: %q must be NAME=VALUE", param)
+		}
+		values[parts[0]] = parts[1]
+	}
+	return values, nil
+}
+
+// renderTemplates replaces the values of the flags, by flag name, that are templates with the
+// Extended JSON they render with the parameters of --param.
+func renderTemplates(params []string, flags map[string]*string) error {
+	values, err := parseParams(params)
+	if err != nil {
+		return err
+	}
+	for name, value := range flags {
+		if !querytemplate.IsTemplate(*value) {
+			continue
+		}
+		rendered, err := querytemplate.Render(*value, values)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		*value = rendered
+	}
+	return nil
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/bulk"
+	"github.com/bradmwilliams/mongodb-client/pkg/client"
+	"github.com/bradmwilliams/mongodb-client/pkg/provision"
+	"github.com/spf13/cobra"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	mongoOptions "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type timeSeriesOptions struct {
+	Database string
+}
+
+func newTimeSeriesCommand(o *options) *cobra.Command {
+	t := &timeSeriesOptions{}
+	cmd := &cobra.Command{
+		Use:   "timeseries",
+		Short: "Create and write to time series collections and downsample their measurements",
+		Long: `Time series collections, on MongoDB 5.0 and later, store the measurements of a timeField, grouped
+by source with a metaField, in buckets sized for their granularity. They can also be declared in the
+manifest of the provision command with the timeseries and expireAfterSeconds of a collection.
+
+The downsample section of --config aggregates the measurements into rollup collections, with a
+document per window of an interval and per source, in the background jobs downsample-<name>:
+
+  downsample:
+    cpu-5m:
+      collection: cpu
+      rollupCollection: cpu_5m
+      timeField: time
+      metaField: host
+      interval: 5m
+      lag: 1m
+      aggregates:
+        usage: [avg, max]
+      schedule: "@every 5m"`,
+		Example: `  mongodb-client timeseries create cpu --time-field time --meta-field host --granularity seconds --expire-after 720h
+  mongodb-client timeseries write cpu --time-field time < measurements.ndjson
+  mongodb-client timeseries downsample cpu-5m --config config.yaml`,
+	}
+	cmd.PersistentFlags().StringVar(&t.Database, "db", t.Database, "Database of the collection, defaults to MONGODB_DATABASE")
+	cmd.AddCommand(newTimeSeriesCreateCommand(o, t))
+	cmd.AddCommand(newTimeSeriesWriteCommand(o, t))
+	cmd.AddCommand(newTimeSeriesDownsampleCommand(o))
+	return cmd
+}
+
+// database returns the database of the options, the one of manager by default.
+func (t *timeSeriesOptions) database(manager *client.ConnectionManager) string {
+	if len(t.Database) == 0 {
+		return manager.Database()
+	}
+	return t.Database
+}
+
+type timeSeriesCreateOptions struct {
+	provision.TimeSeries
+	ExpireAfter time.Duration
+}
+
+func newTimeSeriesCreateCommand(o *options, t *timeSeriesOptions) *cobra.Command {
+	c := &timeSeriesCreateOptions{}
+	cmd := &cobra.Command{
+		Use:   "create NAME",
+		Short: "Create a time series collection",
+		Long: `Create the time series collection NAME. Its timeField and metaField can not be changed afterwards,
+its granularity can only be made coarser.`,
+		Example: `  mongodb-client timeseries create cpu --time-field time --meta-field host --granularity minutes`,
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			return c.run(o, t, arguments[0])
+		},
+	}
+	flagset := cmd.Flags()
+	flagset.StringVar(&c.TimeField, "time-field", c.TimeField, "Field holding the date of the measurements")
+	flagset.StringVar(&c.MetaField, "meta-field", c.MetaField, "Field identifying the source of the measurements")
+	flagset.StringVar(&c.Granularity, "granularity", c.Granularity, "Interval between the measurements of a source it is closest to: "+strings.Join(provision.Granularities, ", "))
+	flagset.DurationVar(&c.ExpireAfter, "expire-after", c.ExpireAfter, "Delete the measurements this long after their date, never when 0")
+	cmd.MarkFlagRequired("time-field")
+	return cmd
+}
+
+func (c *timeSeriesCreateOptions) run(o *options, t *timeSeriesOptions, name string) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	if c.ExpireAfter < 0 || c.ExpireAfter%time.Second != 0 {
+		return fmt.Errorf("--expire-after must be a positive number of seconds")
+	}
+	createOptions := mongoOptions.CreateCollection()
+	timeSeries := mongoOptions.TimeSeries().SetTimeField(c.TimeField)
+	if len(c.MetaField) > 0 {
+		timeSeries.SetMetaField(c.MetaField)
+	}
+	if len(c.Granularity) > 0 {
+		timeSeries.SetGranularity(c.Granularity)
+	}
+	createOptions.SetTimeSeriesOptions(timeSeries)
+	if c.ExpireAfter > 0 {
+		createOptions.SetExpireAfterSeconds(int64(c.ExpireAfter / time.Second))
+	}
+
+	manager, err := o.connect()
+	if err != nil {
+		return err
+	}
+	defer disconnect(manager)
+	ctx, cancel := manager.Context()
+	defer cancel()
+	database := t.database(manager)
+	if err := manager.Primary().Database(database).CreateCollection(ctx, name, createOptions); err != nil {
+		return fmt.Errorf("unable to create the time series collection %s.%s: %w", database, name, err)
+	}
+	fmt.Fprintf(os.Stderr, "Created the time series collection %s.%s\n", database, name)
+	return nil
+}
+
+type timeSeriesWriteOptions struct {
+	TimeField string
+	File      string
+	BatchSize int
+	Now       bool
+}
+
+func newTimeSeriesWriteCommand(o *options, t *timeSeriesOptions) *cobra.Command {
+	w := &timeSeriesWriteOptions{BatchSize: 1000}
+	cmd := &cobra.Command{
+		Use:   "write NAME",
+		Short: "Insert NDJSON or JSON array measurements read from standard input or a file",
+		Long: `Insert the measurements into the time series collection NAME in unordered batches, the writes that
+time series collections handle best. The --time-field of a measurement may be an Extended JSON date
+or an RFC 3339 string, which is converted to a date, and it is set to the time of the write for
+the measurements without one with --now.`,
+		Example: `  mongodb-client timeseries write cpu --time-field time < measurements.ndjson
+  mongodb-client timeseries write cpu --time-field time --now --file samples.json`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			return w.run(o, t, arguments[0])
+		},
+	}
+	flagset := cmd.Flags()
+	flagset.StringVar(&w.TimeField, "time-field", w.TimeField, "Top-level field holding the date of the measurements")
+	flagset.StringVar(&w.File, "file", w.File, "File containing the measurements, defaults to standard input")
+	flagset.IntVar(&w.BatchSize, "batch-size", w.BatchSize, "Number of measurements written per request")
+	flagset.BoolVar(&w.Now, "now", w.Now, "Set the time field of the measurements without one to the time of the write")
+	cmd.MarkFlagRequired("time-field")
+	return cmd
+}
+
+func (w *timeSeriesWriteOptions) run(o *options, t *timeSeriesOptions, name string) error {
+	if w.BatchSize < 1 {
+		return fmt.Errorf("--batch-size must be at least 1")
+	}
+	input := io.Reader(os.Stdin)
+	if len(w.File) > 0 && w.File != "-" {
+		file, err := os.Open(w.File)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		input = file
+	}
+
+	manager, err := o.connect()
+	if err != nil {
+		return err
+	}
+	defer disconnect(manager)
+	collection := manager.Primary().Database(t.database(manager)).Collection(name)
+
+	writer := bulk.NewWriter(collection, bulk.Options{BatchSize: w.BatchSize, Timeout: manager.Timeout(client.WriteOperation)})
+	ctx := context.Background()
+	reader := newDocumentReader(input)
+	for count := 1; ; count++ {
+		doc, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("measurement %d: %w", count, err)
+		}
+		if doc, err = w.measurement(doc, time.Now()); err != nil {
+			return fmt.Errorf("measurement %d: %w", count, err)
+		}
+		if err := writer.Add(ctx, mongo.NewInsertOneModel().SetDocument(doc)); err != nil {
+			return fmt.Errorf("write failed (%s): %w", writer.Result(), err)
+		}
+	}
+	if err := writer.Flush(ctx); err != nil {
+		return fmt.Errorf("write failed (%s): %w", writer.Result(), err)
+	}
+	fmt.Fprintln(os.Stdout, writer.Result())
+	return nil
+}
+
+// measurement returns doc with a date in its time field, converted from an RFC 3339 string or set
+// to now with --now.
+func (w *timeSeriesWriteOptions) measurement(doc bson.D, now time.Time) (bson.D, error) {
+	for i, e := range doc {
+		if e.Key != w.TimeField {
+			continue
+		}
+		switch value := e.Value.(type) {
+		case primitive.DateTime:
+			return doc, nil
+		case string:
+			parsed, err := time.Parse(time.RFC3339Nano, value)
+			if err != nil {
+				return nil, fmt.Errorf("%s is not an RFC 3339 date: %w", w.TimeField, err)
+			}
+			doc[i].Value = primitive.NewDateTimeFromTime(parsed)
+			return doc, nil
+		default:
+			return nil, fmt.Errorf("%s must be a date or an RFC 3339 string, not %T", w.TimeField, e.Value)
+		}
+	}
+	if !w.Now {
+		return nil, fmt.Errorf("%s is missing, use --now to set it to the time of the write", w.TimeField)
+	}
+	return append(doc, bson.E{Key: w.TimeField, Value: primitive.NewDateTimeFromTime(now)}), nil
+}
+
+func newTimeSeriesDownsampleCommand(o *options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "downsample NAME",
+		Short: "Aggregate the measurements of a downsampling policy now instead of waiting for its job",
+		Long: `Aggregate the windows of the downsampling policy NAME of --config that ended its lag ago and were
+not aggregated yet, from the last window of the rollup collection, which is aggregated again, or
+from the first measurement.`,
+		Example: `  mongodb-client timeseries downsample cpu-5m --config config.yaml`,
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			if len(o.ConfigFile) == 0 {
+				return fmt.Errorf("--config is required to find the downsampling policy %s", arguments[0])
+			}
+			cfg, err := o.loadConfig()
+			if err != nil {
+				return err
+			}
+			manager, err := o.connect()
+			if err != nil {
+				return err
+			}
+			defer disconnect(manager)
+			policy, err := downsamplePolicy(cfg, arguments[0], manager.Database())
+			if err != nil {
+				return err
+			}
+			ctx, cancel := cmdContext()
+			defer cancel()
+			return policy.Run(ctx, manager.Primary())
+		},
+	}
+}
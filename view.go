@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/client"
+	"github.com/bradmwilliams/mongodb-client/pkg/provision"
+	"github.com/spf13/cobra"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type viewOptions struct {
+	Database string
+}
+
+func newViewCommand(o *options) *cobra.Command {
+	v := &viewOptions{}
+	cmd := &cobra.Command{
+		Use:   "view",
+		Short: "Create, drop and list the views of a database",
+		Long: `Views run an aggregation pipeline on a collection or another view when they are read. They are
+defined by Extended JSON files named after them:
+
+  # views/recent_episodes.json
+  {
+    "viewOn": "episodes",
+    "pipeline": [
+      {"$match": {"published": {"$gte": {"$date": "2021-01-01T00:00:00Z"}}}},
+      {"$lookup": {"from": "podcasts", "localField": "podcast", "foreignField": "_id", "as": "podcast"}}
+    ],
+    "collation": {"locale": "en"}
+  }
+
+The views read by other views, through their viewOn or their $lookup, $graphLookup and $unionWith
+stages, are created first.`,
+		Example: `  mongodb-client view create views/ --dry-run
+  mongodb-client view list
+  mongodb-client view drop recent_episodes`,
+	}
+	cmd.PersistentFlags().StringVar(&v.Database, "db", v.Database, "Database of the views, defaults to MONGODB_DATABASE")
+	cmd.AddCommand(newViewCreateCommand(o, v))
+	cmd.AddCommand(newViewDropCommand(o, v))
+	cmd.AddCommand(newViewListCommand(o, v))
+	return cmd
+}
+
+// database returns the database of the options, the one of manager by default.
+func (v *viewOptions) database(manager *client.ConnectionManager) string {
+	if len(v.Database) == 0 {
+		return manager.Database()
+	}
+	return v.Database
+}
+
+func newViewCreateCommand(o *options, v *viewOptions) *cobra.Command {
+	var dryRun bool
+	cmd := &cobra.Command{
+		Use:   "create FILE|DIR...",
+		Short: "Create the views defined by files, or update them when their definition changed",
+		Long: `Create the views defined by FILE, or by the .json files of DIR, that do not exist, and update the
+existing views whose viewOn or pipeline differs from their definition. Every change is printed like
+those of the provision command, --dry-run only prints them.
+
+The collation of a view can not be modified: a view whose collation changed is dropped and created
+again, and so are the views reading it, which must have the same collation.`,
+		Example: `  mongodb-client view create views/
+  mongodb-client view create views/recent_episodes.json --dry-run`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			views, err := provision.LoadViews(arguments...)
+			if err != nil {
+				return err
+			}
+			if len(views) == 0 {
+				return fmt.Errorf("no view definition found in %s", strings.Join(arguments, ", "))
+			}
+			manager, err := o.connect()
+			if err != nil {
+				return err
+			}
+			defer disconnect(manager)
+			ctx, cancel := cmdContext()
+			defer cancel()
+			changes, err := provision.PlanViews(ctx, manager.Primary().Database(v.database(manager)), views)
+			if err != nil {
+				return fmt.Errorf("unable to compare the views with their definitions: %w", err)
+			}
+			if len(changes) == 0 {
+				fmt.Fprintln(os.Stdout, "The views match their definitions")
+				return nil
+			}
+			return applyChanges(ctx, changes, dryRun, os.Stdout)
+		},
+	}
+	cmd.Flags().BoolVar(&dryRun, "dry-run", dryRun, "Print the changes without applying them")
+	return cmd
+}
+
+func newViewDropCommand(o *options, v *viewOptions) *cobra.Command {
+	var cascade bool
+	cmd := &cobra.Command{
+		Use:   "drop NAME...",
+		Short: "Drop views",
+		Long: `Drop the views NAME. A view read by other views is only dropped with --cascade, which drops them
+as well, the reads of a view whose source was dropped return no documents. Collections are never
+dropped by this command.`,
+		Example: `  mongodb-client view drop recent_episodes
+  mongodb-client view drop episodes_by_podcast --cascade`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			manager, err := o.connect()
+			if err != nil {
+				return err
+			}
+			defer disconnect(manager)
+			ctx, cancel := manager.Context()
+			defer cancel()
+			db := manager.Primary().Database(v.database(manager))
+			views, err := provision.ListViews(ctx, db)
+			if err != nil {
+				return err
+			}
+			existing := map[string]bool{}
+			for _, view := range views {
+				existing[view.Name] = true
+			}
+
+			var names []string
+			selected := map[string]bool{}
+			for _, name := range arguments {
+				if !existing[name] {
+					return fmt.Errorf("%s.%s is not a view", db.Name(), name)
+				}
+				dependents, err := provision.Dependents(views, name)
+				if err != nil {
+					return err
+				}
+				for _, dependent := range dependents {
+					if !cascade && !contains(arguments, dependent.Name) {
+						return fmt.Errorf("%s.%s is read by the view %s, use --cascade to drop it as well", db.Name(), name, dependent.Name)
+					}
+				}
+				// The dependents are dropped first, like the views they read are created first.
+				for i := len(dependents) - 1; i >= 0; i-- {
+					if !selected[dependents[i].Name] {
+						selected[dependents[i].Name] = true
+						names = append(names, dependents[i].Name)
+					}
+				}
+				if !selected[name] {
+					selected[name] = true
+					names = append(names, name)
+				}
+			}
+
+			for _, name := range names {
+				if err := o.checkProtected(db.Name(), name); err != nil {
+					return err
+				}
+			}
+			if err := o.confirm("Drop the views %s of %s", strings.Join(names, ", "), db.Name()); err != nil {
+				return err
+			}
+			// The confirmation may have outlasted the timeout of the command.
+			ctx, cancel = manager.Context()
+			defer cancel()
+			for _, name := range names {
+				if err := db.Collection(name).Drop(ctx); err != nil {
+					return fmt.Errorf("unable to drop the view %s.%s: %w", db.Name(), name, err)
+				}
+				fmt.Fprintf(os.Stdout, "- view %s.%s\n", db.Name(), name)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&cascade, "cascade", cascade, "Also drop the views reading the dropped views")
+	return cmd
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func newViewListCommand(o *options, v *viewOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the views of a database with the collections and views they read",
+		Long: `List the views of the database with their viewOn, the number of stages of their pipeline and every
+collection or view they read. With --output json or yaml the definitions are printed in the format
+read by the create command.`,
+		Example: `  mongodb-client view list
+  mongodb-client view list -o json`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			out, err := o.printer()
+			if err != nil {
+				return err
+			}
+			manager, err := o.connect()
+			if err != nil {
+				return err
+			}
+			defer disconnect(manager)
+			ctx, cancel := manager.Context()
+			defer cancel()
+			views, err := provision.ListViews(ctx, manager.Primary().Database(v.database(manager)))
+			if err != nil {
+				return err
+			}
+			definitions := make([]bson.D, 0, len(views))
+			for _, view := range views {
+				definition := bson.D{{Key: "name", Value: view.Name}, {Key: "viewOn", Value: view.ViewOn}, {Key: "pipeline", Value: view.Pipeline}}
+				if len(view.Collation) > 0 {
+					definition = append(definition, bson.E{Key: "collation", Value: view.Collation})
+				}
+				definitions = append(definitions, definition)
+			}
+			return out.print(os.Stdout, definitions, func(stdout io.Writer) error {
+				w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+				fmt.Fprintln(w, "NAME\tVIEW ON\tSTAGES\tREADS")
+				for _, view := range views {
+					fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", view.Name, view.ViewOn, len(view.Pipeline), strings.Join(view.Dependencies(), ","))
+				}
+				return w.Flush()
+			})
+		},
+	}
+	addJSONFlag(cmd.Flags(), o)
+	return cmd
+}
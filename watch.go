@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/bradmwilliams/mongodb-client/pkg/changestream"
+	"github.com/bradmwilliams/mongodb-client/pkg/sink"
+	"github.com/spf13/cobra"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	mongoOptions "go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var watchOutputs = []string{"pretty", "ndjson"}
+
+type watchOptions struct {
+	Database     string
+	Collection   string
+	AllDatabases bool
+	Pipeline     string
+	FullDocument string
+	Output       string
+	BatchSize    int32
+	MaxAwaitTime time.Duration
+
+	ConsumerName          string
+	ResumeTokenCollection string
+	ResumeTokenFile       string
+	MetricsListen         string
+
+	Kafka sink.KafkaConfig
+	NATS  sink.NATSConfig
+
+	Webhook           sink.WebhookConfig
+	WebhookBatchSize  int
+	WebhookDeadLetter string
+}
+
+func newWatchCommand(o *options) *cobra.Command {
+	w := &watchOptions{
+		Output:  "pretty",
+		Kafka:   sink.KafkaConfig{Format: sink.FormatJSON},
+		NATS:    sink.NATSConfig{Format: sink.FormatJSON},
+		Webhook: sink.WebhookConfig{Timeout: 30 * time.Second, Attempts: 5, Backoff: time.Second, MaxBackoff: time.Minute},
+	}
+	cmd := &cobra.Command{
+		Use:   "watch",
+		Short: "Print the changes made to a collection, a database or the whole deployment as they happen",
+		Long: `Open a change stream and print every change event until interrupted.
+
+The stream covers the collection given with --collection, otherwise the database given with --db
+(or MONGODB_DATABASE), or every database with --all-databases. Change streams require a replica set
+or a sharded cluster.
+
+With --resume-token-collection or --resume-token-file the command runs as a durable consumer: the
+resume token of every printed event is saved under --consumer-name, and a restarted consumer
+continues after the last saved event. Transient failures reopen the stream from the saved token.
+
+With --kafka-brokers the events are published to Kafka instead of being printed, to the topic of
+their namespace given by --kafka-topic-map or else --kafka-topic, keyed by the _id of the changed
+document. SASL credentials are read from KAFKA_USERNAME and KAFKA_PASSWORD.
+
+With --nats-url the events are published to NATS JetStream subjects given by --nats-subject-map or
+else --nats-subject. Every message is acknowledged by the stream before the next event is handled
+and its Nats-Msg-Id is the resume token of the event, so the stream drops the duplicates published
+again after a restart. A credentials file is read from NATS_CREDS.
+
+With --webhook-url the events are posted as JSON to an HTTP endpoint, one per request or as arrays
+of up to --webhook-batch-size events. The body is signed with HMAC-SHA256 using WEBHOOK_SECRET, the
+signature is sent as X-Mongodb-Client-Signature-256: sha256=<hex>. Failed requests are retried with
+exponential backoff, events that still cannot be delivered are stored in
+--webhook-dead-letter-collection, or stop the command when it is not set.`,
+		Example: `  mongodb-client watch --collection episodes
+  mongodb-client watch --collection episodes --full-document updateLookup --pipeline '[{"$match":{"operationType":"update"}}]'
+  mongodb-client watch --all-databases --output ndjson > changes.ndjson
+  mongodb-client watch --collection episodes --output ndjson --consumer-name audit --resume-token-collection resume_tokens --metrics-listen :8081
+  mongodb-client watch --consumer-name cdc --resume-token-collection resume_tokens --kafka-brokers kafka:9092 \
+    --kafka-topic-map sampledb.episodes=episodes --kafka-format avro
+  mongodb-client watch --db sampledb --consumer-name cdc --resume-token-file tokens.json --nats-url nats://nats:4222 \
+    --nats-subject 'cdc.{db}.{collection}'
+  WEBHOOK_SECRET=... mongodb-client watch --collection episodes --consumer-name hooks --resume-token-collection resume_tokens \
+    --webhook-url https://example.com/hooks/episodes --webhook-batch-size 100 --webhook-dead-letter-collection dead_letters`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, arguments []string) error {
+			return w.run(o)
+		},
+	}
+
+	flagset := cmd.Flags()
+	flagset.StringVar(&w.Database, "db", w.Database, "Database to watch, defaults to MONGODB_DATABASE")
+	flagset.StringVar(&w.Collection, "collection", w.Collection, "Watch only this collection of the database")
+	flagset.BoolVar(&w.AllDatabases, "all-databases", w.AllDatabases, "Watch every database of the deployment")
+	flagset.StringVar(&w.Pipeline, "pipeline", w.Pipeline, "Aggregation stages applied to the change events as a JSON array, such as $match or $project")
+	flagset.StringVar(&w.FullDocument, "full-document", w.FullDocument, "Set to updateLookup to include the current version of the document in update events")
+	flagset.StringVar(&w.Output, "output", w.Output, fmt.Sprintf("Output format of the events: %v", watchOutputs))
+	flagset.Int32Var(&w.BatchSize, "batch-size", w.BatchSize, "Number of events per cursor batch (0 uses the server default)")
+	flagset.DurationVar(&w.MaxAwaitTime, "max-await-time", w.MaxAwaitTime, "Maximum time the server waits for new events before answering a cursor request (0 uses the server default)")
+	flagset.StringVar(&w.ConsumerName, "consumer-name", w.ConsumerName, "Name the resume token is saved under, defaults to the watched namespace")
+	flagset.StringVar(&w.ResumeTokenCollection, "resume-token-collection", w.ResumeTokenCollection, "Collection of the application database in which resume tokens are saved")
+	flagset.StringVar(&w.ResumeTokenFile, "resume-token-file", w.ResumeTokenFile, "File in which resume tokens are saved")
+	flagset.StringVar(&w.MetricsListen, "metrics-listen", w.MetricsListen, "Address to serve the consumer metrics on, such as :8081")
+	flagset.StringSliceVar(&w.Kafka.Brokers, "kafka-brokers", w.Kafka.Brokers, "Kafka brokers to publish the events to instead of printing them")
+	flagset.StringVar(&w.Kafka.Topics.Default, "kafka-topic", "{db}.{collection}", "Topic of the events of namespaces missing from --kafka-topic-map, {db} and {collection} are replaced by the namespace")
+	flagset.StringToStringVar(&w.Kafka.Topics.Routes, "kafka-topic-map", w.Kafka.Topics.Routes, "Topics of the events of each database or database.collection, such as sampledb.episodes=episodes")
+	flagset.StringVar(&w.Kafka.Format, "kafka-format", w.Kafka.Format, "Serialization of the published events: json or avro")
+	flagset.BoolVar(&w.Kafka.TLS, "kafka-tls", w.Kafka.TLS, "Connect to the Kafka brokers with TLS")
+	flagset.StringVar(&w.Kafka.SASLMechanism, "kafka-sasl-mechanism", w.Kafka.SASLMechanism, "SASL mechanism used to authenticate to Kafka: PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512")
+	flagset.StringVar(&w.NATS.URL, "nats-url", w.NATS.URL, "NATS servers to publish the events to as JetStream messages instead of printing them")
+	flagset.StringVar(&w.NATS.Subjects.Default, "nats-subject", "mongodb.{db}.{collection}", "Subject of the events of namespaces missing from --nats-subject-map, {db} and {collection} are replaced by the namespace")
+	flagset.StringToStringVar(&w.NATS.Subjects.Routes, "nats-subject-map", w.NATS.Subjects.Routes, "Subjects of the events of each database or database.collection, such as sampledb.episodes=cdc.episodes")
+	flagset.StringVar(&w.NATS.Format, "nats-format", w.NATS.Format, "Serialization of the published events: json or avro")
+	flagset.StringVar(&w.Webhook.URL, "webhook-url", w.Webhook.URL, "HTTP endpoint to post the events to instead of printing them")
+	flagset.IntVar(&w.WebhookBatchSize, "webhook-batch-size", w.WebhookBatchSize, "Post JSON arrays of up to this many events instead of one event per request")
+	flagset.DurationVar(&w.Webhook.Timeout, "webhook-timeout", w.Webhook.Timeout, "Timeout of every webhook request")
+	flagset.IntVar(&w.Webhook.Attempts, "webhook-attempts", w.Webhook.Attempts, "Number of times a webhook request is sent before its events are dead-lettered")
+	flagset.DurationVar(&w.Webhook.Backoff, "webhook-backoff", w.Webhook.Backoff, "Delay before the first retry of a failed webhook request, doubled after every attempt")
+	flagset.DurationVar(&w.Webhook.MaxBackoff, "webhook-max-backoff", w.Webhook.MaxBackoff, "Maximum delay between two attempts of a webhook request")
+	flagset.StringVar(&w.WebhookDeadLetter, "webhook-dead-letter-collection", w.WebhookDeadLetter, "Collection of the application database storing the events that could not be delivered")
+	return cmd
+}
+
+func (w *watchOptions) run(o *options) error {
+	if w.AllDatabases && (len(w.Database) > 0 || len(w.Collection) > 0) {
+		return fmt.Errorf("--all-databases cannot be combined with --db or --collection")
+	}
+	if len(w.ResumeTokenCollection) > 0 && len(w.ResumeTokenFile) > 0 {
+		return fmt.Errorf("only one of --resume-token-collection or --resume-token-file may be specified")
+	}
+	durable := len(w.ResumeTokenCollection) > 0 || len(w.ResumeTokenFile) > 0
+	publishing := len(w.Kafka.Brokers) > 0 || len(w.NATS.URL) > 0 || len(w.Webhook.URL) > 0
+	if !durable && !publishing && (len(w.ConsumerName) > 0 || len(w.MetricsListen) > 0) {
+		return fmt.Errorf("--consumer-name and --metrics-listen require a resume token store or a sink")
+	}
+	if w.WebhookBatchSize < 0 {
+		return fmt.Errorf("--webhook-batch-size must not be negative")
+	}
+	var format func(io.Writer, interface{}) error
+	switch w.Output {
+	case "pretty":
+		format = printDocument
+	case "ndjson":
+		format = writeLine
+	default:
+		return fmt.Errorf("--output must be one of %v", watchOutputs)
+	}
+	pipeline := []bson.D{}
+	if len(w.Pipeline) > 0 {
+		var err error
+		if pipeline, err = parsePipeline(w.Pipeline); err != nil {
+			return fmt.Errorf("--pipeline: %w", err)
+		}
+	}
+
+	streamOptions := mongoOptions.ChangeStream()
+	if len(w.FullDocument) > 0 {
+		streamOptions.SetFullDocument(mongoOptions.FullDocument(w.FullDocument))
+	}
+	if w.BatchSize > 0 {
+		streamOptions.SetBatchSize(w.BatchSize)
+	}
+	if w.MaxAwaitTime > 0 {
+		streamOptions.SetMaxAwaitTime(w.MaxAwaitTime)
+	}
+
+	manager, err := o.connect()
+	if err != nil {
+		return err
+	}
+	defer disconnect(manager)
+
+	database := w.Database
+	if len(database) == 0 {
+		database = manager.Database()
+	}
+
+	// The stream runs until interrupted, so it is not bounded by the operation timeout.
+	ctx, cancel := cmdContext()
+	defer cancel()
+	var watcher changestream.Watcher
+	name := "*"
+	switch {
+	case w.AllDatabases:
+		watcher = manager.Primary()
+	case len(w.Collection) > 0:
+		watcher = manager.Primary().Database(database).Collection(w.Collection)
+		name = database + "." + w.Collection
+	default:
+		watcher = manager.Primary().Database(database)
+		name = database
+	}
+
+	publisher, err := w.sink(manager.Primary().Database(manager.Database()))
+	if err != nil {
+		return err
+	}
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	handle := func(ctx context.Context, event bson.Raw) error {
+		if err := format(out, event); err != nil {
+			return err
+		}
+		// Events are flushed one at a time so that they show up as soon as they happen, and so that
+		// the token of a durable consumer is only saved once its event has been written.
+		return out.Flush()
+	}
+	if publisher != nil {
+		defer publisher.Close()
+		handle = publisher.Publish
+	}
+
+	// Events published to a sink always go through a consumer, so that the stream is resumed after
+	// transient failures without losing events.
+	if durable || publisher != nil {
+		if len(w.ConsumerName) > 0 {
+			name = w.ConsumerName
+		}
+		var store changestream.TokenStore
+		switch {
+		case len(w.ResumeTokenCollection) > 0:
+			store = changestream.NewCollectionStore(manager.Primary().Database(manager.Database()).Collection(w.ResumeTokenCollection))
+		case len(w.ResumeTokenFile) > 0:
+			store = changestream.NewFileStore(w.ResumeTokenFile)
+		}
+		if len(w.MetricsListen) > 0 {
+			serveMetrics(w.MetricsListen)
+		}
+		consumer := &changestream.Consumer{
+			Name:     name,
+			Watcher:  watcher,
+			Pipeline: pipeline,
+			Options:  streamOptions,
+			Store:    store,
+			Retry:    o.Retry,
+		}
+		if batches, ok := publisher.(sink.BatchSink); ok && w.WebhookBatchSize > 0 {
+			consumer.BatchSize = w.WebhookBatchSize
+			return consumer.RunBatches(ctx, batches.PublishBatch)
+		}
+		return consumer.Run(ctx, handle)
+	}
+
+	stream, err := watcher.Watch(ctx, pipeline, streamOptions)
+	if err != nil {
+		return fmt.Errorf("unable to open the change stream: %w", err)
+	}
+	defer stream.Close(ctx)
+	for stream.Next(ctx) {
+		if err := handle(ctx, stream.Current); err != nil {
+			return err
+		}
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	return stream.Err()
+}
+
+// sink returns the sink the events are published to, nil when they are printed.
+// sink returns the sink the events are published to, nil when they are printed. The dead letters
+// of the webhook are stored in database.
+func (w *watchOptions) sink(database *mongo.Database) (sink.Sink, error) {
+	configured := 0
+	for _, set := range []bool{len(w.Kafka.Brokers) > 0, len(w.NATS.URL) > 0, len(w.Webhook.URL) > 0} {
+		if set {
+			configured++
+		}
+	}
+	if configured > 1 {
+		return nil, fmt.Errorf("only one of --kafka-brokers, --nats-url or --webhook-url may be specified")
+	}
+	switch {
+	case len(w.Kafka.Brokers) > 0:
+		return sink.NewKafka(w.Kafka)
+	case len(w.NATS.URL) > 0:
+		return sink.NewNATS(w.NATS)
+	case len(w.Webhook.URL) > 0:
+		w.Webhook.Batch = w.WebhookBatchSize > 0
+		if len(w.WebhookDeadLetter) > 0 {
+			w.Webhook.DeadLetter = database.Collection(w.WebhookDeadLetter)
+		}
+		return sink.NewWebhook(w.Webhook)
+	}
+	return nil, nil
+}